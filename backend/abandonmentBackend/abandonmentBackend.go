@@ -0,0 +1,319 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abandonmentBackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/metrics"
+	"github.com/cogment/cogment-trial-datastore/utils"
+)
+
+// Clock provides the current time and the timers of the abandonment worker
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Options represents the configuration of an abandonment backend
+type Options struct {
+	TTL             time.Duration // Duration without samples added after which an ongoing trial is abandoned
+	CheckInterval   time.Duration // Interval between the checks of the ongoing trials, 0 means a tenth of `TTL`
+	DeleteAbandoned bool          // Abandoned trials are deleted instead of being marked as abandoned
+	Clock           Clock         // Clock measuring the durations, nil means the system clock
+}
+
+// abandonmentBackend wraps a backend and abandons the ongoing trials to which no samples were added for a while, e.g.
+// because their producer crashed.
+//
+// The duration since the last addition of samples is tracked in memory, from the creation of the backend for the
+// trials ongoing at that time. Abandoned trials are ended using `AbandonTrials`, adding samples to them afterwards
+// makes them ongoing again.
+type abandonmentBackend struct {
+	backend.Backend
+	ttl                       time.Duration
+	checkInterval             time.Duration
+	deleteAbandoned           bool
+	clock                     Clock
+	lastAdditionsMutex        sync.Mutex
+	lastAdditions             map[string]time.Time // Time of the last addition of samples to each tracked trial
+	trialLocks                *utils.TrialLocks    // Held for reading by the additions and for writing by abandonments
+	abandonmentWorkerCancel   context.CancelFunc
+	abandonmentWorkerFinished chan struct{}
+}
+
+// CreateAbandonmentBackend creates a Backend abandoning, following the given options, the ongoing trials of the given
+// backend.
+//
+// The returned backend owns the given one, destroying it destroys the given backend.
+func CreateAbandonmentBackend(b backend.Backend, options Options) (backend.Backend, error) {
+	if options.TTL <= 0 {
+		return nil, fmt.Errorf("invalid abandonment ttl %v, expecting a positive duration", options.TTL)
+	}
+	checkInterval := options.CheckInterval
+	if checkInterval <= 0 {
+		checkInterval = options.TTL / 10
+	}
+	clock := options.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{}, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	now := clock.Now()
+	lastAdditions := make(map[string]time.Time)
+	for _, trialInfo := range trialsInfo.TrialInfos {
+		if trialInfo.State != grpcapi.TrialState_ENDED {
+			lastAdditions[trialInfo.TrialID] = now
+		}
+	}
+
+	abandonmentWorkerContext, abandonmentWorkerCancel := context.WithCancel(context.Background())
+	ab := &abandonmentBackend{
+		Backend:                   b,
+		ttl:                       options.TTL,
+		checkInterval:             checkInterval,
+		deleteAbandoned:           options.DeleteAbandoned,
+		clock:                     clock,
+		lastAdditions:             lastAdditions,
+		trialLocks:                utils.CreateTrialLocks(),
+		abandonmentWorkerCancel:   abandonmentWorkerCancel,
+		abandonmentWorkerFinished: make(chan struct{}),
+	}
+	go ab.abandonmentWorker(abandonmentWorkerContext)
+	return ab, nil
+}
+
+func (b *abandonmentBackend) Destroy() {
+	b.abandonmentWorkerCancel()
+	<-b.abandonmentWorkerFinished
+	b.Backend.Destroy()
+}
+
+func (b *abandonmentBackend) abandonmentWorker(ctx context.Context) {
+	defer close(b.abandonmentWorkerFinished)
+	for {
+		select {
+		case <-ctx.Done():
+			// Abandonment worker canceled
+			return
+		case <-b.clock.After(b.checkInterval):
+			err := b.abandonInactiveTrials(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.WithField("operation", "abandon_trials").WithError(err).Error("Unable to abandon the inactive trials")
+			}
+		}
+	}
+}
+
+// abandonInactiveTrials abandons the tracked trials to which no samples were added during the ttl and that didn't
+// end in the meantime.
+//
+// Only the additions to the trial being abandoned wait for it, samples can't be added to a trial while it is deleted.
+func (b *abandonmentBackend) abandonInactiveTrials(ctx context.Context) error {
+	now := b.clock.Now()
+	inactiveTrialIDs := b.inactiveTrialIDs(now)
+	if len(inactiveTrialIDs) == 0 {
+		return nil
+	}
+
+	// Trials that don't exist anymore aren't retrieved
+	trialsInfo, err := b.Backend.RetrieveTrials(ctx, inactiveTrialIDs, -1, -1)
+	if err != nil {
+		return err
+	}
+	ongoingTrialIDs := make(map[string]struct{}, len(trialsInfo.TrialInfos))
+	for _, trialInfo := range trialsInfo.TrialInfos {
+		if trialInfo.State != grpcapi.TrialState_ENDED {
+			ongoingTrialIDs[trialInfo.TrialID] = struct{}{}
+		}
+	}
+	for _, trialID := range inactiveTrialIDs {
+		if _, ongoing := ongoingTrialIDs[trialID]; !ongoing {
+			b.forgetInactiveTrial(trialID, now)
+		}
+	}
+
+	// Abandoning trials one by one, in creation order, so that each abandonment is atomic
+	for _, trialInfo := range trialsInfo.TrialInfos {
+		if _, ongoing := ongoingTrialIDs[trialInfo.TrialID]; !ongoing {
+			continue
+		}
+		abandoned, err := b.abandonInactiveTrial(ctx, trialInfo.TrialID, now)
+		if err != nil {
+			return err
+		}
+		if !abandoned {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"operation": "abandon_trials",
+			"trial_id":  trialInfo.TrialID,
+			"deleted":   b.deleteAbandoned,
+		}).Info("Abandoned inactive trial")
+		if b.deleteAbandoned {
+			metrics.EvictedTrialsCount.WithLabelValues("abandonment").Inc()
+		}
+	}
+	return nil
+}
+
+// inactiveTrialIDs returns the tracked trials to which no samples were added during the ttl preceding `now`
+func (b *abandonmentBackend) inactiveTrialIDs(now time.Time) []string {
+	b.lastAdditionsMutex.Lock()
+	defer b.lastAdditionsMutex.Unlock()
+	inactiveTrialIDs := []string{}
+	for trialID, lastAddition := range b.lastAdditions {
+		if now.Sub(lastAddition) >= b.ttl {
+			inactiveTrialIDs = append(inactiveTrialIDs, trialID)
+		}
+	}
+	return inactiveTrialIDs
+}
+
+// forgetInactiveTrial stops tracking the given trial unless samples were added to it during the ttl preceding `now`,
+// it returns whether the trial was inactive
+func (b *abandonmentBackend) forgetInactiveTrial(trialID string, now time.Time) bool {
+	b.lastAdditionsMutex.Lock()
+	defer b.lastAdditionsMutex.Unlock()
+	lastAddition, tracked := b.lastAdditions[trialID]
+	if !tracked || now.Sub(lastAddition) < b.ttl {
+		return false
+	}
+	delete(b.lastAdditions, trialID)
+	return true
+}
+
+// abandonInactiveTrial abandons the given trial unless samples were added to it since it was found inactive, it
+// returns whether the trial was abandoned
+func (b *abandonmentBackend) abandonInactiveTrial(ctx context.Context, trialID string, now time.Time) (bool, error) {
+	defer b.trialLocks.Lock(trialID)()
+	if !b.forgetInactiveTrial(trialID, now) {
+		return false, nil
+	}
+	var err error
+	if b.deleteAbandoned {
+		err = b.Backend.DeleteTrials(ctx, []string{trialID})
+	} else {
+		err = b.Backend.AbandonTrials(ctx, []string{trialID})
+	}
+	if errors.Is(err, backend.ErrTrialNotFound) || errors.Is(err, backend.ErrTrialDeleted) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// recordAdditions resets the abandonment timer of the given trials
+func (b *abandonmentBackend) recordAdditions(trialIDs ...string) {
+	b.lastAdditionsMutex.Lock()
+	defer b.lastAdditionsMutex.Unlock()
+	now := b.clock.Now()
+	for _, trialID := range trialIDs {
+		b.lastAdditions[trialID] = now
+	}
+}
+
+// forgetTrials stops tracking the given trials until samples are added to them
+func (b *abandonmentBackend) forgetTrials(trialIDs ...string) {
+	b.lastAdditionsMutex.Lock()
+	defer b.lastAdditionsMutex.Unlock()
+	for _, trialID := range trialIDs {
+		delete(b.lastAdditions, trialID)
+	}
+}
+
+// CreateOrUpdateTrials starts the abandonment timer of the given trials, the updated trials that already ended are
+// ignored once the timer expires
+func (b *abandonmentBackend) CreateOrUpdateTrials(ctx context.Context, trialsParams []*backend.TrialParams) error {
+	trialIDs := make([]string, len(trialsParams))
+	for trialIdx, trialParams := range trialsParams {
+		trialIDs[trialIdx] = trialParams.TrialID
+	}
+	defer b.trialLocks.RLock(trialIDs...)()
+	err := b.Backend.CreateOrUpdateTrials(ctx, trialsParams)
+	if err != nil {
+		return err
+	}
+	b.recordAdditions(trialIDs...)
+	return nil
+}
+
+func (b *abandonmentBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error {
+	trialIDs := make([]string, len(samples))
+	for sampleIdx, sample := range samples {
+		trialIDs[sampleIdx] = sample.TrialId
+	}
+	defer b.trialLocks.RLock(trialIDs...)()
+	err := b.Backend.AddSamples(ctx, samples)
+	if err != nil {
+		return err
+	}
+	b.recordAdditions(trialIDs...)
+	return nil
+}
+
+func (b *abandonmentBackend) AddSamplePartial(ctx context.Context, sample *grpcapi.StoredTrialSample) error {
+	defer b.trialLocks.RLock(sample.TrialId)()
+	err := b.Backend.AddSamplePartial(ctx, sample)
+	if err != nil {
+		return err
+	}
+	b.recordAdditions(sample.TrialId)
+	return nil
+}
+
+func (b *abandonmentBackend) ClearSamples(ctx context.Context, trialID string) error {
+	defer b.trialLocks.RLock(trialID)()
+	err := b.Backend.ClearSamples(ctx, trialID)
+	if err != nil {
+		return err
+	}
+	b.recordAdditions(trialID)
+	return nil
+}
+
+func (b *abandonmentBackend) EndTrials(ctx context.Context, trialIDs []string) error {
+	b.forgetTrials(trialIDs...)
+	return b.Backend.EndTrials(ctx, trialIDs)
+}
+
+func (b *abandonmentBackend) AbandonTrials(ctx context.Context, trialIDs []string) error {
+	b.forgetTrials(trialIDs...)
+	return b.Backend.AbandonTrials(ctx, trialIDs)
+}
+
+func (b *abandonmentBackend) DeleteTrials(ctx context.Context, trialIDs []string) error {
+	b.forgetTrials(trialIDs...)
+	return b.Backend.DeleteTrials(ctx, trialIDs)
+}
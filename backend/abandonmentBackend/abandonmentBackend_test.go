@@ -0,0 +1,272 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abandonmentBackend
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	"github.com/cogment/cogment-trial-datastore/backend/test"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// fakeClock is a Clock whose time only changes when it is advanced
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []fakeClockWaiter
+}
+
+type fakeClockWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	waiter := fakeClockWaiter{deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, waiter)
+	return waiter.c
+}
+
+func (c *fakeClock) waitersCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.waiters)
+}
+
+// advance advances the clock, firing the expired timers, once the abandonment worker waits for one of them. It then
+// waits for the worker to be done with the checks it triggered.
+func (c *fakeClock) advance(t *testing.T, d time.Duration) {
+	assert.Eventually(t, func() bool { return c.waitersCount() > 0 }, time.Second, time.Millisecond)
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	pendingWaiters := []fakeClockWaiter{}
+	for _, waiter := range c.waiters {
+		if waiter.deadline.After(c.now) {
+			pendingWaiters = append(pendingWaiters, waiter)
+		} else {
+			waiter.c <- c.now
+		}
+	}
+	c.waiters = pendingWaiters
+	c.mutex.Unlock()
+	assert.Eventually(t, func() bool { return c.waitersCount() > 0 }, time.Second, time.Millisecond)
+}
+
+func createAbandonmentMemoryBackend(t *testing.T, options Options) backend.Backend {
+	mb, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	b, err := CreateAbandonmentBackend(mb, options)
+	assert.NoError(t, err)
+	return b
+}
+
+func retrieveTrialInfos(t *testing.T, b backend.Backend) map[string]*backend.TrialInfo {
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{}, 0, -1)
+	assert.NoError(t, err)
+	trialInfos := make(map[string]*backend.TrialInfo)
+	for _, trialInfo := range trialsInfo.TrialInfos {
+		trialInfos[trialInfo.TrialID] = trialInfo
+	}
+	return trialInfos
+}
+
+func TestSuiteAbandonmentBackend(t *testing.T) {
+	test.RunSuite(t, func() backend.Backend {
+		// A ttl long enough not to interfere with the suite
+		return createAbandonmentMemoryBackend(t, Options{TTL: time.Hour})
+	}, func(b backend.Backend) {
+		b.Destroy()
+	})
+}
+
+func TestAbandonInactiveTrials(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	b := createAbandonmentMemoryBackend(t, Options{TTL: time.Minute, CheckInterval: 10 * time.Second, Clock: clock})
+	defer b.Destroy()
+
+	for _, trialID := range []string{"trial-1", "trial-2", "trial-3"} {
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: trialID, Params: &grpcapi.TrialParams{}}})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_RUNNING}})
+		assert.NoError(t, err)
+	}
+	err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "trial-3", TickId: 1, State: grpcapi.TrialState_ENDED}})
+	assert.NoError(t, err)
+
+	clock.advance(t, 30*time.Second)
+	// Adding samples resets the timer
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "trial-2", TickId: 1, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+	trialInfos := retrieveTrialInfos(t, b)
+	assert.False(t, trialInfos["trial-1"].Abandoned)
+
+	clock.advance(t, 40*time.Second)
+	trialInfos = retrieveTrialInfos(t, b)
+	assert.True(t, trialInfos["trial-1"].Abandoned)
+	assert.Equal(t, grpcapi.TrialState_ENDED, trialInfos["trial-1"].State)
+	assert.False(t, trialInfos["trial-2"].Abandoned)
+	assert.Equal(t, grpcapi.TrialState_RUNNING, trialInfos["trial-2"].State)
+	// Trials ended by their samples are never abandoned
+	assert.False(t, trialInfos["trial-3"].Abandoned)
+	assert.Equal(t, grpcapi.TrialState_ENDED, trialInfos["trial-3"].State)
+
+	clock.advance(t, 30*time.Second)
+	trialInfos = retrieveTrialInfos(t, b)
+	assert.True(t, trialInfos["trial-2"].Abandoned)
+
+	// Adding samples to an abandoned trial makes it ongoing again
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "trial-1", TickId: 1, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+	trialInfos = retrieveTrialInfos(t, b)
+	assert.False(t, trialInfos["trial-1"].Abandoned)
+	assert.Equal(t, grpcapi.TrialState_RUNNING, trialInfos["trial-1"].State)
+
+	clock.advance(t, time.Minute)
+	trialInfos = retrieveTrialInfos(t, b)
+	assert.True(t, trialInfos["trial-1"].Abandoned)
+}
+
+func TestAbandonTrialsEndedExplicitly(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	b := createAbandonmentMemoryBackend(t, Options{TTL: time.Minute, CheckInterval: 10 * time.Second, Clock: clock})
+	defer b.Destroy()
+
+	err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "trial-1", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+	err = b.EndTrials(context.Background(), []string{"trial-1"})
+	assert.NoError(t, err)
+
+	clock.advance(t, 2*time.Minute)
+	trialInfos := retrieveTrialInfos(t, b)
+	assert.False(t, trialInfos["trial-1"].Abandoned)
+	assert.Equal(t, grpcapi.TrialState_ENDED, trialInfos["trial-1"].State)
+}
+
+func TestDeleteAbandonedTrials(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	mb, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	// Trials ongoing when the backend is created are tracked from its creation
+	err = mb.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "trial-1", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+	b, err := CreateAbandonmentBackend(mb, Options{TTL: time.Minute, CheckInterval: 10 * time.Second, DeleteAbandoned: true, Clock: clock})
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "trial-2", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+
+	clock.advance(t, 30*time.Second)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "trial-2", TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+
+	clock.advance(t, 40*time.Second)
+	exist, err := b.TrialsExist(context.Background(), []string{"trial-1", "trial-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false, true}, exist)
+}
+
+// blockingAbandonmentBackend wraps a backend and blocks the abandonment of trials until it is released
+type blockingAbandonmentBackend struct {
+	backend.Backend
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingAbandonmentBackend) AbandonTrials(ctx context.Context, trialIDs []string) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.Backend.AbandonTrials(ctx, trialIDs)
+}
+
+func TestAbandonTrialsConcurrentAdditions(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	mb, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	blockingBackend := &blockingAbandonmentBackend{Backend: mb, started: make(chan struct{}, 1), release: make(chan struct{})}
+	b, err := CreateAbandonmentBackend(blockingBackend, Options{TTL: time.Minute, CheckInterval: 10 * time.Second, Clock: clock})
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	for _, trialID := range []string{"trial-1", "trial-2"} {
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: trialID, Params: &grpcapi.TrialParams{}}})
+		assert.NoError(t, err)
+	}
+
+	advanced := make(chan struct{})
+	go func() {
+		defer close(advanced)
+		clock.advance(t, 2*time.Minute)
+	}()
+	<-blockingBackend.started
+
+	// While a trial is being abandoned, samples are added to the others
+	added := make(chan error)
+	go func() {
+		added <- b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "trial-2", TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	}()
+	select {
+	case err := <-added:
+		assert.NoError(t, err)
+		close(blockingBackend.release)
+	case <-time.After(time.Second):
+		assert.Fail(t, "samples addition blocked by the abandonment")
+		close(blockingBackend.release)
+		assert.NoError(t, <-added)
+	}
+	<-advanced
+	trialInfos := retrieveTrialInfos(t, b)
+	assert.True(t, trialInfos["trial-1"].Abandoned)
+	// Samples were added to it since it was found inactive
+	assert.False(t, trialInfos["trial-2"].Abandoned)
+	assert.Equal(t, grpcapi.TrialState_RUNNING, trialInfos["trial-2"].State)
+}
+
+func TestAddSamplesUnknownTrial(t *testing.T) {
+	b := createAbandonmentMemoryBackend(t, Options{TTL: time.Minute})
+	defer b.Destroy()
+
+	err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "unknown-trial", TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	assert.Error(t, err)
+
+	// Only the additions that succeeded are tracked
+	ab := b.(*abandonmentBackend)
+	ab.lastAdditionsMutex.Lock()
+	defer ab.lastAdditionsMutex.Unlock()
+	assert.Empty(t, ab.lastAdditions)
+}
+
+func TestInvalidTTL(t *testing.T) {
+	mb, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer mb.Destroy()
+	_, err = CreateAbandonmentBackend(mb, Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// ActorClassPayloads represents the observation and action payloads of an actor at a given tick
+type ActorClassPayloads struct {
+	TickID      uint64
+	ActorIdx    uint32
+	ActorName   string
+	Observation []byte // nil when the actor has no observation at this tick
+	Action      []byte // nil when the actor has no action at this tick
+}
+
+// actorClassPayloadsFields are the only fields retrieved to extract the payloads of an actor class
+var actorClassPayloadsFields = []grpcapi.StoredTrialSampleField{
+	grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION,
+	grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION,
+}
+
+// ExtractActorClassPayloads extracts the observation and action payloads of the actors of the given class from a
+// sample, following the actor order of the sample. Actors having neither an observation nor an action are skipped.
+func ExtractActorClassPayloads(sample *grpcapi.StoredTrialSample, params *grpcapi.TrialParams, actorClass string) []ActorClassPayloads {
+	payloads := []ActorClassPayloads{}
+	for _, actorSample := range sample.ActorSamples {
+		if int(actorSample.Actor) >= len(params.GetActors()) {
+			continue
+		}
+		actorParams := params.Actors[actorSample.Actor]
+		if actorParams.ActorClass != actorClass || (actorSample.Observation == nil && actorSample.Action == nil) {
+			continue
+		}
+		actorPayloads := ActorClassPayloads{
+			TickID:    sample.TickId,
+			ActorIdx:  actorSample.Actor,
+			ActorName: actorParams.Name,
+		}
+		if actorSample.Observation != nil {
+			actorPayloads.Observation = sample.Payloads[*actorSample.Observation]
+		}
+		if actorSample.Action != nil {
+			actorPayloads.Action = sample.Payloads[*actorSample.Action]
+		}
+		payloads = append(payloads, actorPayloads)
+	}
+	return payloads
+}
+
+// RetrieveActorClassPayloads retrieves the observation and action payloads of the actors of the given class from the
+// currently stored samples of a trial, in tick order then in actor order, e.g. to batch them in a training pipeline.
+//
+// Only the observations and actions of the actors of the class are read from the backend. A class that no actor of
+// the trial belongs to results in no payloads.
+func RetrieveActorClassPayloads(ctx context.Context, b Backend, trialID string, actorClass string) ([]ActorClassPayloads, error) {
+	trialsInfo, err := b.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(trialsInfo.TrialInfos) == 0 {
+		return nil, &UnknownTrialError{TrialID: trialID}
+	}
+	trialsParams, err := b.GetTrialParams(ctx, []string{trialID})
+	if err != nil {
+		return nil, err
+	}
+	params := trialsParams[0].Params
+
+	payloads := []ActorClassPayloads{}
+	_, err = forEachStoredFilteredSample(
+		ctx,
+		b,
+		TrialSampleFilter{ActorClasses: []string{actorClass}, Fields: actorClassPayloadsFields},
+		trialID,
+		trialsInfo.TrialInfos[0].StoredSamplesCount,
+		func(sample *grpcapi.StoredTrialSample) error {
+			payloads = append(payloads, ExtractActorClassPayloads(sample, params, actorClass)...)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return payloads, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/openlyinc/pointy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractActorClassPayloads(t *testing.T) {
+	params := &grpcapi.TrialParams{
+		Actors: []*grpcapi.ActorParams{
+			{Name: "player-1", ActorClass: "player"},
+			{Name: "referee", ActorClass: "referee"},
+			{Name: "player-2", ActorClass: "player"},
+			{Name: "player-3", ActorClass: "player"},
+		},
+	}
+	sample := &grpcapi.StoredTrialSample{
+		TickId: 12,
+		ActorSamples: []*grpcapi.StoredTrialActorSample{
+			{Actor: 0, Observation: pointy.Uint32(0), Action: pointy.Uint32(1)},
+			{Actor: 1, Observation: pointy.Uint32(0), Action: pointy.Uint32(2)},
+			{Actor: 2, Observation: pointy.Uint32(3)},
+			{Actor: 3, Reward: pointy.Float32(1)},
+		},
+		Payloads: [][]byte{[]byte("obs"), []byte("action-1"), []byte("action-referee"), []byte("obs-2")},
+	}
+
+	assert.Equal(t, []ActorClassPayloads{
+		{TickID: 12, ActorIdx: 0, ActorName: "player-1", Observation: []byte("obs"), Action: []byte("action-1")},
+		{TickID: 12, ActorIdx: 2, ActorName: "player-2", Observation: []byte("obs-2")},
+	}, ExtractActorClassPayloads(sample, params, "player"))
+	assert.Equal(t, []ActorClassPayloads{
+		{TickID: 12, ActorIdx: 1, ActorName: "referee", Observation: []byte("obs"), Action: []byte("action-referee")},
+	}, ExtractActorClassPayloads(sample, params, "referee"))
+	assert.Empty(t, ExtractActorClassPayloads(sample, params, "spectator"))
+
+	// Actors missing from the params are skipped
+	assert.Empty(t, ExtractActorClassPayloads(sample, &grpcapi.TrialParams{}, "player"))
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// ActorRewardStats represents the rewards received by an actor over a trial
+type ActorRewardStats struct {
+	RewardsCount int
+	Total        float64
+	Mean         float64 // Mean value of a received reward, zero when none were received
+}
+
+// ComputeActorRewardStats computes the stats of the rewards received by each actor of the given samples, keyed by
+// actor index.
+//
+// Every received reward is accounted for, whether it was sent by another actor or by the environment (i.e. having
+// `EnvironmentActorIdx` as sender). Sent rewards, messages and observations are ignored.
+func ComputeActorRewardStats(samples []*grpcapi.StoredTrialSample) map[uint32]*ActorRewardStats {
+	stats := make(map[uint32]*ActorRewardStats)
+	for _, sample := range samples {
+		for _, actorSample := range sample.ActorSamples {
+			actorStats, found := stats[actorSample.Actor]
+			if !found {
+				actorStats = &ActorRewardStats{}
+				stats[actorSample.Actor] = actorStats
+			}
+			for _, reward := range actorSample.ReceivedRewards {
+				actorStats.RewardsCount++
+				actorStats.Total += float64(reward.Reward)
+			}
+		}
+	}
+	for _, actorStats := range stats {
+		if actorStats.RewardsCount > 0 {
+			actorStats.Mean = actorStats.Total / float64(actorStats.RewardsCount)
+		}
+	}
+	return stats
+}
+
+// RetrieveActorRewardStats computes the stats of the rewards received by each actor of a trial, restricted to the
+// currently stored samples within the given tick range, bounds being optional and inclusive.
+func RetrieveActorRewardStats(ctx context.Context, b Backend, trialID string, fromTickID *uint64, toTickID *uint64) (map[uint32]*ActorRewardStats, error) {
+	trialsInfo, err := b.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(trialsInfo.TrialInfos) == 0 {
+		return nil, &UnknownTrialError{TrialID: trialID}
+	}
+	storedSamplesCount := trialsInfo.TrialInfos[0].StoredSamplesCount
+
+	samples := make([]*grpcapi.StoredTrialSample, 0, storedSamplesCount)
+	_, err = forEachStoredSample(
+		ctx,
+		b,
+		trialID,
+		storedSamplesCount,
+		[]grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS},
+		func(sample *grpcapi.StoredTrialSample) error {
+			// The tick range is applied here as `forEachStoredSample` expects to visit every stored sample
+			if (fromTickID != nil && sample.TickId < *fromTickID) || (toTickID != nil && sample.TickId > *toTickID) {
+				return nil
+			}
+			samples = append(samples, sample)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeActorRewardStats(samples), nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeActorRewardStats(t *testing.T) {
+	samples := []*grpcapi.StoredTrialSample{
+		{
+			TickId: 0,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{
+					Actor: 0,
+					ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+						{Sender: EnvironmentActorIdx, Receiver: 0, Reward: 1, Confidence: 0.5},
+						{Sender: 1, Receiver: 0, Reward: -2.5, Confidence: 1},
+					},
+				},
+				{
+					Actor: 1,
+					SentRewards: []*grpcapi.StoredTrialActorSampleReward{
+						{Sender: 1, Receiver: 0, Reward: -2.5, Confidence: 1},
+					},
+				},
+				{Actor: 2},
+			},
+		},
+		{
+			TickId: 1,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{
+					Actor: 0,
+					ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+						{Sender: EnvironmentActorIdx, Receiver: 0, Reward: 4, Confidence: 1},
+					},
+				},
+				{
+					Actor: 1,
+					ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+						{Sender: EnvironmentActorIdx, Receiver: 1, Reward: 0.5, Confidence: 1},
+						{Sender: 0, Receiver: 1, Reward: 1.5, Confidence: 1},
+					},
+				},
+				{Actor: 2},
+			},
+		},
+	}
+
+	assert.Equal(t, map[uint32]*ActorRewardStats{
+		0: {RewardsCount: 3, Total: 2.5, Mean: 2.5 / 3},
+		1: {RewardsCount: 2, Total: 2, Mean: 1},
+		2: {},
+	}, ComputeActorRewardStats(samples))
+
+	assert.Empty(t, ComputeActorRewardStats([]*grpcapi.StoredTrialSample{}))
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"golang.org/x/sync/errgroup"
+)
+
+// ActorMetadata represents the metadata of an actor resolved from the trial params
+type ActorMetadata struct {
+	Name           string
+	ActorClass     string
+	Implementation string
+}
+
+// AnnotatedTrialSample represents a sample along with the metadata of its actors
+type AnnotatedTrialSample struct {
+	Sample *grpcapi.StoredTrialSample
+	Actors []ActorMetadata // Metadata of the actor of each of `Sample.ActorSamples`, zero for actors unknown to the params
+}
+
+// AnnotateSample resolves the metadata of the actors of the given sample using the given trial params
+func AnnotateSample(sample *grpcapi.StoredTrialSample, trialParams *grpcapi.TrialParams) *AnnotatedTrialSample {
+	annotatedSample := &AnnotatedTrialSample{
+		Sample: sample,
+		Actors: make([]ActorMetadata, len(sample.ActorSamples)),
+	}
+	for actorSampleIdx, actorSample := range sample.ActorSamples {
+		if int(actorSample.Actor) < len(trialParams.GetActors()) {
+			actorParams := trialParams.Actors[actorSample.Actor]
+			annotatedSample.Actors[actorSampleIdx] = ActorMetadata{
+				Name:           actorParams.GetName(),
+				ActorClass:     actorParams.GetActorClass(),
+				Implementation: actorParams.GetImplementation(),
+			}
+		}
+	}
+	return annotatedSample
+}
+
+func referencesUnknownActors(sample *grpcapi.StoredTrialSample, trialParams *grpcapi.TrialParams) bool {
+	for _, actorSample := range sample.ActorSamples {
+		if int(actorSample.Actor) >= len(trialParams.GetActors()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ObserveAnnotatedSamples observes the samples matching the given filter, like `Backend.ObserveSamples`, and sends
+// them annotated with the metadata of their actors.
+//
+// The params of each trial are retrieved once and retrieved again when a sample references an actor they don't
+// define, so that the actors added by updating the params of an ongoing trial are resolved.
+func ObserveAnnotatedSamples(ctx context.Context, b Backend, filter TrialSampleFilter, out chan<- *AnnotatedTrialSample) error {
+	observer := make(TrialSampleObserver)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(observer)
+		return b.ObserveSamples(ctx, filter, observer)
+	})
+	g.Go(func() error {
+		trialsParams := make(map[string]*grpcapi.TrialParams)
+		for sample := range observer {
+			trialParams, ok := trialsParams[sample.TrialId]
+			if !ok || referencesUnknownActors(sample, trialParams) {
+				retrievedTrialParams, err := b.GetTrialParams(ctx, []string{sample.TrialId})
+				if err != nil {
+					return err
+				}
+				trialParams = retrievedTrialParams[0].Params
+				trialsParams[sample.TrialId] = trialParams
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- AnnotateSample(sample, trialParams):
+			}
+		}
+		return nil
+	})
+	return g.Wait()
+}
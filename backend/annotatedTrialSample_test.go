@@ -0,0 +1,42 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotateSample(t *testing.T) {
+	annotatedSample := AnnotateSample(trialSample1, trialParams)
+	assert.Equal(t, trialSample1, annotatedSample.Sample)
+	assert.Equal(t, []ActorMetadata{
+		{Name: "my-actor-1", ActorClass: "my-actor-class-1", Implementation: "my-actor-implementation"},
+		{Name: "my-actor-2", ActorClass: "my-actor-class-2", Implementation: "my-actor-implementation"},
+	}, annotatedSample.Actors)
+}
+
+func TestAnnotateSampleUnknownActor(t *testing.T) {
+	sample := &grpcapi.StoredTrialSample{
+		ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 1}, {Actor: 2}},
+	}
+	annotatedSample := AnnotateSample(sample, trialParams)
+	assert.Equal(t, []ActorMetadata{
+		{Name: "my-actor-2", ActorClass: "my-actor-class-2", Implementation: "my-actor-implementation"},
+		{},
+	}, annotatedSample.Actors)
+}
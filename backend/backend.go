@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
 )
@@ -29,6 +30,13 @@ type TrialInfo struct {
 	State              grpcapi.TrialState
 	SamplesCount       int
 	StoredSamplesCount int
+	StoredSamplesSize  int    // Cumulated size, in bytes, of the serialized stored samples
+	MinTickID          uint64 // Smallest tick id of the stored samples, only meaningful when `StoredSamplesCount` > 0
+	MaxTickID          uint64 // Largest tick id of the stored samples, only meaningful when `StoredSamplesCount` > 0
+	// When the trial was first stored, zero for trials stored before creation timestamps were recorded
+	CreatedAt time.Time
+	Tags      map[string]string // Tags attached to the trial when it was stored, nil when there are none
+	Abandoned bool              // The trial was ended by `AbandonTrials` and no sample was added to it since
 }
 
 type TrialsInfoResult struct {
@@ -38,11 +46,34 @@ type TrialsInfoResult struct {
 
 type TrialsInfoObserver chan TrialsInfoResult
 
+// StorageStats represents the storage usage of a backend, computed from counters maintained as trials and samples are
+// stored rather than by scanning them
+type StorageStats struct {
+	BackendType  string // Name under which the backend is registered, e.g. "memory"
+	TrialsCount  int
+	SamplesCount int // Number of stored samples, evicted samples aren't counted
+	SamplesSize  int // Cumulated size, in bytes, of the serialized stored samples
+	Persistent   bool
+	FileSize     int64 // Size, in bytes, of the storage file, only meaningful when `Persistent`
+	// Space, in bytes, available on the file system of the storage file, only meaningful when `Persistent`, -1 when it
+	// can't be determined on this platform
+	FreeSpace int64
+}
+
+// SamplesEstimate represents the samples an observation would deliver, see `Backend.EstimateSamples`
+type SamplesEstimate struct {
+	SamplesCount int
+	SamplesSize  int  // Cumulated size, in bytes, of the serialized samples
+	Exact        bool // The samples were counted and measured, not extrapolated
+}
+
 // TrialParams represents the params of a trials
 type TrialParams struct {
-	TrialID string
-	UserID  string
-	Params  *grpcapi.TrialParams
+	TrialID           string
+	UserID            string
+	Params            *grpcapi.TrialParams
+	SampleOrderingKey SampleOrderingKey // Order in which the trial samples are observed, by tick id by default
+	Tags              map[string]string // Arbitrary key/value tags, e.g. grouping the trials of an experiment
 }
 
 type TrialSampleObserver chan *grpcapi.StoredTrialSample
@@ -51,26 +82,146 @@ type TrialSampleObserver chan *grpcapi.StoredTrialSample
 type Backend interface {
 	Destroy()
 
+	// CreateOrUpdateTrials stores the params of the given trials, the creation timestamp of new trials is recorded
 	CreateOrUpdateTrials(ctx context.Context, trialsParams []*TrialParams) error
+	// RetrieveTrials retrieves the info of the stored trials in creation order, updating a trial doesn't change its order
 	RetrieveTrials(ctx context.Context, filter []string, fromTrialIdx int, count int) (TrialsInfoResult, error)
 	ObserveTrials(ctx context.Context, filter []string, fromTrialIdx int, count int, out chan<- TrialsInfoResult) error
 	DeleteTrials(ctx context.Context, trialIDs []string) error
+	// TrialsExist checks, for each of the given trial ids, if the trial exists without loading its params or samples
+	TrialsExist(ctx context.Context, trialIDs []string) ([]bool, error)
 
 	GetTrialParams(ctx context.Context, trialIDs []string) ([]*TrialParams, error)
 
 	AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error
+	// AddSamplePartial adds a sample or, if a sample with the same tick already exists, merges it into it following `MergeTrialSamples` semantics
+	AddSamplePartial(ctx context.Context, sample *grpcapi.StoredTrialSample) error
+	// ClearSamples deletes every sample of a trial, and its result, while keeping its params, the trial is then ready to receive new samples.
+	// How ongoing observations of the trial samples behave depends on the backend.
+	ClearSamples(ctx context.Context, trialID string) error
+	// EndTrials marks the given trials as ended, as if their last stored sample was in the `ENDED` state, e.g. when the
+	// sample ending them will never be added. Ongoing observations of their samples end once the stored samples are sent.
+	// Samples added afterwards are stored, the trial state then follows them.
+	EndTrials(ctx context.Context, trialIDs []string) error
+	// AbandonTrials ends the given trials, like `EndTrials`, and marks them as abandoned, e.g. when their producer
+	// stopped adding samples without ending them. Adding samples to an abandoned trial, clearing its samples or ending
+	// it using `EndTrials` removes the mark.
+	AbandonTrials(ctx context.Context, trialIDs []string) error
+	// SetTrialResult stores the final result of a trial along with its params, replacing the previous one if any
+	SetTrialResult(ctx context.Context, trialID string, result *TrialResult) error
+	// GetTrialResult retrieves the result of a trial without reading its samples, a `NoTrialResultError` is raised if none was set
+	GetTrialResult(ctx context.Context, trialID string) (*TrialResult, error)
+	// ObserveSamples sends the samples matching the filter to `out`, waiting for the samples of ongoing trials.
+	//
+	// Any number of observations can run concurrently with the addition of samples to the same trials: each observation
+	// reads a consistent, growing, prefix of the samples of a trial, in order. Observations don't block each other and
+	// a slow observation doesn't block the addition of samples.
 	ObserveSamples(ctx context.Context, filter TrialSampleFilter, out chan<- *grpcapi.StoredTrialSample) error
+	// RetrieveLatestSample retrieves the stored sample of a trial having the largest tick id, restricted to the fields
+	// and actors selected by the filter, without going through the other samples. The filter's trial ids and tick range
+	// are ignored. It returns nil when the trial has no stored sample or when the filter leaves out its latest one.
+	RetrieveLatestSample(ctx context.Context, trialID string, filter TrialSampleFilter) (*grpcapi.StoredTrialSample, error)
+	// RetrieveSamplePayload retrieves a single payload of the stored sample of a trial at the given tick, without
+	// deserializing the rest of the sample. An `UnknownSampleError` is raised if the sample isn't stored and an
+	// `UnknownPayloadError` if the sample has no payload at the given index.
+	RetrieveSamplePayload(ctx context.Context, trialID string, tickID uint64, payloadIdx uint32) ([]byte, error)
+	// EstimateSamples estimates the number and the cumulated serialized size of the samples `ObserveSamples` would
+	// deliver, given the same filter, from the currently stored samples, without delivering them. Backends can
+	// extrapolate the estimate from a part of the samples, it is then not `Exact`.
+	EstimateSamples(ctx context.Context, filter TrialSampleFilter) (SamplesEstimate, error)
+
+	// Reindex rebuilds the secondary indices of the backend from the stored trials and samples, which are left untouched
+	Reindex(ctx context.Context) error
+
+	// StorageStats retrieves the storage usage of the backend without scanning the stored trials and samples
+	StorageStats(ctx context.Context) (StorageStats, error)
+}
+
+// TrialExists checks if the given trial exists in the given backend
+func TrialExists(ctx context.Context, b Backend, trialID string) (bool, error) {
+	exist, err := b.TrialsExist(ctx, []string{trialID})
+	if err != nil {
+		return false, err
+	}
+	return exist[0], nil
 }
 
+// Sentinel errors matched, using `errors.Is`, by the typed errors raised by the backends. They let callers handle a
+// category of failures without depending on the details of the typed errors.
+var (
+	// ErrTrialNotFound is matched by an `UnknownTrialError` for a trial that doesn't exist
+	ErrTrialNotFound = errors.New("trial not found")
+	// ErrTrialAlreadyExists is matched by a `TrialAlreadyExistsError`
+	ErrTrialAlreadyExists = errors.New("trial already exists")
+	// ErrSampleOutOfOrder is matched by the errors rejecting a sample whose tick id doesn't follow the added ones
+	ErrSampleOutOfOrder = errors.New("sample out of order")
+	// ErrTrialDeleted is matched by an `UnknownTrialError` for a trial deleted while operating on it
+	ErrTrialDeleted = errors.New("trial deleted")
+)
+
 // UnknownTrialError is raised when trying to operate on an unknown trial
 type UnknownTrialError struct {
 	TrialID string
+	Deleted bool // The trial is known to have been deleted, e.g. while samples were being added to it
 }
 
 func (e *UnknownTrialError) Error() string {
+	if e.Deleted {
+		return fmt.Sprintf("trial %q was deleted", e.TrialID)
+	}
 	return fmt.Sprintf("no trial %q found", e.TrialID)
 }
 
+// Is matches `ErrTrialDeleted` when the trial is known to have been deleted, `ErrTrialNotFound` otherwise
+func (e *UnknownTrialError) Is(target error) bool {
+	if e.Deleted {
+		return target == ErrTrialDeleted
+	}
+	return target == ErrTrialNotFound
+}
+
+// TooManyTrialsError is raised when trying to create a trial while the maximum number of trials is reached
+type TooManyTrialsError struct {
+	MaxTrialsCount int
+}
+
+func (e *TooManyTrialsError) Error() string {
+	return fmt.Sprintf("maximum number of trials (%d) reached", e.MaxTrialsCount)
+}
+
+// EvictedSamplesError is raised when observing samples that were evicted to bound the storage usage, their trial and
+// its params remaining stored
+type EvictedSamplesError struct {
+	TrialID   string
+	MinTickID uint64 // Smallest tick id of the evicted samples
+	MaxTickID uint64 // Largest tick id of the evicted samples
+}
+
+func (e *EvictedSamplesError) Error() string {
+	return fmt.Sprintf("the samples of trial %q from tick %d to tick %d were evicted", e.TrialID, e.MinTickID, e.MaxTickID)
+}
+
+// CorruptedSampleError is raised when reading a stored sample whose checksum doesn't match its content
+type CorruptedSampleError struct {
+	TrialID string
+	TickID  uint64
+}
+
+func (e *CorruptedSampleError) Error() string {
+	return fmt.Sprintf("sample %d of trial %q is corrupted, its checksum doesn't match", e.TickID, e.TrialID)
+}
+
+// MissingTrialParamsError is raised when observing, using actor names or classes, the samples of a trial whose params
+// are unavailable to resolve them
+type MissingTrialParamsError struct {
+	TrialID string
+	Filter  string // The filter requiring the trial params, e.g. "actor names"
+}
+
+func (e *MissingTrialParamsError) Error() string {
+	return fmt.Sprintf("unable to filter the samples of trial %q by %s, its params are unavailable", e.TrialID, e.Filter)
+}
+
 // UnexpectedError is raised when an internal issue occurs
 type UnexpectedError struct {
 	err error
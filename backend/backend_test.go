@@ -0,0 +1,42 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	err := fmt.Errorf("wrapped (%w)", &UnknownTrialError{TrialID: "my-trial"})
+	assert.True(t, errors.Is(err, ErrTrialNotFound))
+	assert.False(t, errors.Is(err, ErrTrialDeleted))
+
+	err = &UnknownTrialError{TrialID: "my-trial", Deleted: true}
+	assert.True(t, errors.Is(err, ErrTrialDeleted))
+	assert.False(t, errors.Is(err, ErrTrialNotFound))
+
+	err = &TrialAlreadyExistsError{TrialID: "my-trial"}
+	assert.True(t, errors.Is(err, ErrTrialAlreadyExists))
+	assert.False(t, errors.Is(err, ErrTrialNotFound))
+
+	// Typed errors can still be retrieved
+	var unknownTrialErr *UnknownTrialError
+	assert.True(t, errors.As(fmt.Errorf("wrapped (%w)", &UnknownTrialError{TrialID: "my-trial"}), &unknownTrialErr))
+	assert.Equal(t, "my-trial", unknownTrialErr.TrialID)
+}
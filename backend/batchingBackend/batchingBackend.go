@@ -0,0 +1,269 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batchingBackend
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// Options represents the configuration of a batching backend
+type Options struct {
+	MaxBatchSize  int           // Maximum number of added samples grouped before being written
+	FlushInterval time.Duration // Maximum duration an added sample waits for others before being written
+}
+
+var DefaultOptions = Options{
+	MaxBatchSize:  100,
+	FlushInterval: 10 * time.Millisecond,
+}
+
+// batchingBackend wraps a backend, typically a persistent one, and groups the samples added through `AddSamples` to
+// write them in a single call, e.g. a single transaction committed to disk instead of one per sample.
+//
+// Added samples are written once `MaxBatchSize` samples are pending, once the oldest pending sample waited for
+// `FlushInterval`, when a sample ends its trial and when the backend is destroyed. Any other operation writes the
+// pending samples beforehand, every operation then behaves as if the samples were added right away. As added samples
+// are referenced until they are written, they must not be modified afterwards.
+//
+// When writing a batch fails, it is written again trial by trial so that the samples of the other trials aren't lost.
+// The samples of a failing trial are dropped and the error is returned by the following `AddSamples` call for this
+// trial, write errors are never returned by the other operations.
+type batchingBackend struct {
+	backend.Backend
+	options        Options
+	flushMutex     sync.Mutex // Serializes the writes of the batches so that samples are written in order
+	pendingMutex   sync.Mutex
+	pendingSamples []*grpcapi.StoredTrialSample
+	flushTimer     *time.Timer      // Running while samples are pending
+	writeErrors    map[string]error // Errors while writing the pending samples of a trial, not yet returned, by trial id
+}
+
+// CreateBatchingBackend creates a Backend grouping the samples added to the given backend.
+//
+// The returned backend owns the given one, destroying it writes the pending samples then destroys the given backend.
+func CreateBatchingBackend(b backend.Backend, options Options) backend.Backend {
+	if options.MaxBatchSize <= 0 {
+		options.MaxBatchSize = DefaultOptions.MaxBatchSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = DefaultOptions.FlushInterval
+	}
+	return &batchingBackend{
+		Backend:     b,
+		options:     options,
+		writeErrors: make(map[string]error),
+	}
+}
+
+func (b *batchingBackend) Destroy() {
+	b.flush()
+	b.Backend.Destroy()
+}
+
+// flush writes the pending samples, if any.
+//
+// The samples were already accepted, they are written regardless of the context of the operation triggering the flush.
+// Write errors are kept for the following `AddSamples` call of the failing trials.
+func (b *batchingBackend) flush() {
+	b.flushMutex.Lock()
+	defer b.flushMutex.Unlock()
+
+	b.pendingMutex.Lock()
+	samples := b.pendingSamples
+	b.pendingSamples = nil
+	if b.flushTimer != nil {
+		b.flushTimer.Stop()
+		b.flushTimer = nil
+	}
+	b.pendingMutex.Unlock()
+
+	if len(samples) == 0 {
+		return
+	}
+	if err := b.Backend.AddSamples(context.Background(), samples); err == nil {
+		return
+	}
+
+	// Writing the samples of each trial on its own for a failing trial not to fail the others
+	trialIDs := []string{}
+	trialsSamples := make(map[string][]*grpcapi.StoredTrialSample)
+	for _, sample := range samples {
+		if _, ok := trialsSamples[sample.TrialId]; !ok {
+			trialIDs = append(trialIDs, sample.TrialId)
+		}
+		trialsSamples[sample.TrialId] = append(trialsSamples[sample.TrialId], sample)
+	}
+	for _, trialID := range trialIDs {
+		err := b.Backend.AddSamples(context.Background(), trialsSamples[trialID])
+		if err == nil {
+			continue
+		}
+		log.WithField("operation", "flush_samples").WithField("trial_id", trialID).WithError(err).Error("Unable to write the pending samples of a trial")
+		b.pendingMutex.Lock()
+		if _, ok := b.writeErrors[trialID]; !ok {
+			b.writeErrors[trialID] = err
+		}
+		b.pendingMutex.Unlock()
+	}
+}
+
+// takeWriteError returns, and forgets, the first error that occurred while writing the pending samples of the given
+// trials, if any
+func (b *batchingBackend) takeWriteError(trialIDs []string) error {
+	b.pendingMutex.Lock()
+	defer b.pendingMutex.Unlock()
+	var firstErr error
+	for _, trialID := range trialIDs {
+		if err, ok := b.writeErrors[trialID]; ok {
+			delete(b.writeErrors, trialID)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// AddSamples adds the given samples to the pending ones.
+//
+// Adding samples to an unknown trial fails right away. The samples are written before this call returns when the
+// maximum batch size is reached or when one of them ends its trial. An error while writing the samples of the given
+// trials, either now or in a previous batch, is returned.
+func (b *batchingBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+
+	trialIDs := make([]string, 0, 1)
+	for _, sample := range samples {
+		if len(trialIDs) == 0 || trialIDs[len(trialIDs)-1] != sample.TrialId {
+			trialIDs = append(trialIDs, sample.TrialId)
+		}
+	}
+	exist, err := b.Backend.TrialsExist(ctx, trialIDs)
+	if err != nil {
+		return err
+	}
+	for idx, trialID := range trialIDs {
+		if !exist[idx] {
+			return &backend.UnknownTrialError{TrialID: trialID}
+		}
+	}
+	if err := b.takeWriteError(trialIDs); err != nil {
+		return err
+	}
+
+	endsTrial := false
+	for _, sample := range samples {
+		if sample.State == grpcapi.TrialState_ENDED {
+			endsTrial = true
+			break
+		}
+	}
+
+	b.pendingMutex.Lock()
+	b.pendingSamples = append(b.pendingSamples, samples...)
+	full := len(b.pendingSamples) >= b.options.MaxBatchSize
+	if !full && !endsTrial && b.flushTimer == nil {
+		b.flushTimer = time.AfterFunc(b.options.FlushInterval, b.flush)
+	}
+	b.pendingMutex.Unlock()
+
+	if full || endsTrial {
+		b.flush()
+		return b.takeWriteError(trialIDs)
+	}
+	return nil
+}
+
+func (b *batchingBackend) RetrieveTrials(ctx context.Context, filter []string, fromTrialIdx int, count int) (backend.TrialsInfoResult, error) {
+	b.flush()
+	return b.Backend.RetrieveTrials(ctx, filter, fromTrialIdx, count)
+}
+
+func (b *batchingBackend) ObserveTrials(ctx context.Context, filter []string, fromTrialIdx int, count int, out chan<- backend.TrialsInfoResult) error {
+	b.flush()
+	return b.Backend.ObserveTrials(ctx, filter, fromTrialIdx, count, out)
+}
+
+func (b *batchingBackend) DeleteTrials(ctx context.Context, trialIDs []string) error {
+	b.flush()
+	if err := b.Backend.DeleteTrials(ctx, trialIDs); err != nil {
+		return err
+	}
+	// The write errors of deleted trials are irrelevant
+	b.takeWriteError(trialIDs)
+	return nil
+}
+
+func (b *batchingBackend) AddSamplePartial(ctx context.Context, sample *grpcapi.StoredTrialSample) error {
+	b.flush()
+	return b.Backend.AddSamplePartial(ctx, sample)
+}
+
+func (b *batchingBackend) ClearSamples(ctx context.Context, trialID string) error {
+	b.flush()
+	return b.Backend.ClearSamples(ctx, trialID)
+}
+
+func (b *batchingBackend) EndTrials(ctx context.Context, trialIDs []string) error {
+	b.flush()
+	return b.Backend.EndTrials(ctx, trialIDs)
+}
+
+func (b *batchingBackend) AbandonTrials(ctx context.Context, trialIDs []string) error {
+	b.flush()
+	return b.Backend.AbandonTrials(ctx, trialIDs)
+}
+
+func (b *batchingBackend) ObserveSamples(ctx context.Context, filter backend.TrialSampleFilter, out chan<- *grpcapi.StoredTrialSample) error {
+	b.flush()
+	return b.Backend.ObserveSamples(ctx, filter, out)
+}
+
+func (b *batchingBackend) RetrieveLatestSample(ctx context.Context, trialID string, filter backend.TrialSampleFilter) (*grpcapi.StoredTrialSample, error) {
+	b.flush()
+	return b.Backend.RetrieveLatestSample(ctx, trialID, filter)
+}
+
+func (b *batchingBackend) RetrieveSamplePayload(ctx context.Context, trialID string, tickID uint64, payloadIdx uint32) ([]byte, error) {
+	b.flush()
+	return b.Backend.RetrieveSamplePayload(ctx, trialID, tickID, payloadIdx)
+}
+
+func (b *batchingBackend) EstimateSamples(ctx context.Context, filter backend.TrialSampleFilter) (backend.SamplesEstimate, error) {
+	b.flush()
+	return b.Backend.EstimateSamples(ctx, filter)
+}
+
+func (b *batchingBackend) Reindex(ctx context.Context) error {
+	b.flush()
+	return b.Backend.Reindex(ctx)
+}
+
+func (b *batchingBackend) StorageStats(ctx context.Context) (backend.StorageStats, error) {
+	b.flush()
+	return b.Backend.StorageStats(ctx)
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batchingBackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/boltBackend"
+	"github.com/cogment/cogment-trial-datastore/backend/test"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+func createTempFilePath(t testing.TB) string {
+	f, err := os.CreateTemp("", "trial-datastore-batching-test")
+	assert.NoError(t, err)
+	f.Close()
+	return f.Name()
+}
+
+func TestSuiteBatchingBoltBackend(t *testing.T) {
+	filePaths := map[backend.Backend]string{}
+	test.RunSuite(t, func() backend.Backend {
+		filePath := createTempFilePath(t)
+		b, err := boltBackend.CreateBoltBackend(filePath)
+		assert.NoError(t, err)
+		bb := CreateBatchingBackend(b, DefaultOptions)
+		filePaths[bb] = filePath
+		return bb
+	}, func(b backend.Backend) {
+		b.Destroy()
+		os.Remove(filePaths[b])
+	})
+}
+
+func retrieveTickIDs(t *testing.T, b backend.Backend, trialID string) []uint64 {
+	observer := make(backend.TrialSampleObserver)
+	go func() {
+		defer close(observer)
+		err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{trialID}}, observer)
+		assert.NoError(t, err)
+	}()
+	tickIDs := []uint64{}
+	for sample := range observer {
+		tickIDs = append(tickIDs, sample.TickId)
+	}
+	return tickIDs
+}
+
+func TestBatchedSamplesAfterRestart(t *testing.T) {
+	filePath := createTempFilePath(t)
+	defer os.Remove(filePath)
+
+	b, err := boltBackend.CreateBoltBackend(filePath)
+	assert.NoError(t, err)
+	// Only flushing on destruction
+	bb := CreateBatchingBackend(b, Options{MaxBatchSize: 1000, FlushInterval: time.Hour})
+	err = bb.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "my-trial", Params: &grpcapi.TrialParams{MaxSteps: 12}},
+	})
+	assert.NoError(t, err)
+	for tickID := uint64(0); tickID < 10; tickID++ {
+		err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "my-trial", TickId: tickID, State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{[]byte("an observation")}},
+		})
+		assert.NoError(t, err)
+	}
+	// Nothing was written yet
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, trialsInfo.TrialInfos[0].StoredSamplesCount)
+
+	// Gracefully shutting down writes the pending samples
+	bb.Destroy()
+
+	// Reopening the same file, as on a restart
+	b, err = boltBackend.CreateBoltBackend(filePath)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	trialsInfo, err = b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, trialsInfo.TrialInfos[0].StoredSamplesCount)
+	// Ending the trial for the observation to end once the stored samples are sent
+	err = b.EndTrials(context.Background(), []string{"my-trial"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, retrieveTickIDs(t, b, "my-trial"))
+}
+
+func TestFlushOnTrialEnd(t *testing.T) {
+	filePath := createTempFilePath(t)
+	defer os.Remove(filePath)
+
+	b, err := boltBackend.CreateBoltBackend(filePath)
+	assert.NoError(t, err)
+	bb := CreateBatchingBackend(b, Options{MaxBatchSize: 1000, FlushInterval: time.Hour})
+	defer bb.Destroy()
+	err = bb.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: &grpcapi.TrialParams{}},
+		{TrialID: "trial-2", Params: &grpcapi.TrialParams{}},
+	})
+	assert.NoError(t, err)
+	err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+		{TrialId: "trial-1", TickId: 0, State: grpcapi.TrialState_RUNNING},
+		{TrialId: "trial-2", TickId: 0, State: grpcapi.TrialState_RUNNING},
+	})
+	assert.NoError(t, err)
+	err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+		{TrialId: "trial-1", TickId: 1, State: grpcapi.TrialState_ENDED},
+	})
+	assert.NoError(t, err)
+
+	// Every pending sample is written, without going through the batching backend
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"trial-1", "trial-2"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, trialsInfo.TrialInfos[0].StoredSamplesCount)
+	assert.Equal(t, grpcapi.TrialState_ENDED, trialsInfo.TrialInfos[0].State)
+	assert.Equal(t, 1, trialsInfo.TrialInfos[1].StoredSamplesCount)
+}
+
+func TestFlushOnBatchSizeAndInterval(t *testing.T) {
+	filePath := createTempFilePath(t)
+	defer os.Remove(filePath)
+
+	b, err := boltBackend.CreateBoltBackend(filePath)
+	assert.NoError(t, err)
+	bb := CreateBatchingBackend(b, Options{MaxBatchSize: 3, FlushInterval: 50 * time.Millisecond})
+	defer bb.Destroy()
+	err = bb.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+
+	storedSamplesCount := func() int {
+		trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+		assert.NoError(t, err)
+		return trialsInfo.TrialInfos[0].StoredSamplesCount
+	}
+
+	for tickID := uint64(0); tickID < 4; tickID++ {
+		err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "my-trial", TickId: tickID, State: grpcapi.TrialState_RUNNING}})
+		assert.NoError(t, err)
+	}
+	// The first 3 samples fill a batch
+	assert.Equal(t, 3, storedSamplesCount())
+	// The last one is written once the flush interval is elapsed
+	assert.Eventually(t, func() bool { return storedSamplesCount() == 4 }, time.Second, 10*time.Millisecond)
+
+	// Adding samples to an unknown trial fails right away
+	err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "unknown-trial", TickId: 0}})
+	var unknownTrialErr *backend.UnknownTrialError
+	assert.ErrorAs(t, err, &unknownTrialErr)
+}
+
+// failingBackend fails the additions of samples to a given trial
+type failingBackend struct {
+	backend.Backend
+	failingTrialID string
+}
+
+func (b *failingBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error {
+	for _, sample := range samples {
+		if sample.TrialId == b.failingTrialID {
+			return errors.New("unable to write the samples")
+		}
+	}
+	return b.Backend.AddSamples(ctx, samples)
+}
+
+func TestFailingFlush(t *testing.T) {
+	filePath := createTempFilePath(t)
+	defer os.Remove(filePath)
+
+	b, err := boltBackend.CreateBoltBackend(filePath)
+	assert.NoError(t, err)
+	bb := CreateBatchingBackend(&failingBackend{Backend: b, failingTrialID: "failing-trial"}, Options{MaxBatchSize: 1000, FlushInterval: time.Hour})
+	defer bb.Destroy()
+	err = bb.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "failing-trial", Params: &grpcapi.TrialParams{}},
+		{TrialID: "my-trial", Params: &grpcapi.TrialParams{}},
+	})
+	assert.NoError(t, err)
+	err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+		{TrialId: "failing-trial", TickId: 0, State: grpcapi.TrialState_RUNNING},
+		{TrialId: "my-trial", TickId: 0, State: grpcapi.TrialState_RUNNING},
+	})
+	assert.NoError(t, err)
+
+	// Reading flushes the pending samples without failing
+	trialsInfo, err := bb.RetrieveTrials(context.Background(), []string{"failing-trial", "my-trial"}, -1, -1)
+	assert.NoError(t, err)
+	// The samples of the other trial are written
+	assert.Equal(t, 0, trialsInfo.TrialInfos[0].StoredSamplesCount)
+	assert.Equal(t, 1, trialsInfo.TrialInfos[1].StoredSamplesCount)
+
+	// The write error is returned by the following addition to the same trial, once
+	err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "my-trial", TickId: 1, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+	err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "failing-trial", TickId: 1, State: grpcapi.TrialState_RUNNING}})
+	assert.EqualError(t, err, "unable to write the samples")
+	err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "failing-trial", TickId: 2, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+
+	// Synchronous flushes only return the errors of the added samples
+	err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "my-trial", TickId: 2, State: grpcapi.TrialState_ENDED}})
+	assert.NoError(t, err)
+	trialsInfo, err = bb.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, trialsInfo.TrialInfos[0].StoredSamplesCount)
+	err = bb.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "failing-trial", TickId: 3, State: grpcapi.TrialState_ENDED}})
+	assert.EqualError(t, err, "unable to write the samples")
+}
+
+// BenchmarkAppendSamples compares appending samples one by one to a bolt backend, committing each one, to appending
+// them through a batching backend
+func BenchmarkAppendSamples(b *testing.B) {
+	for _, batched := range []bool{false, true} {
+		name := "per_sample_commits"
+		if batched {
+			name = "batched_commits"
+		}
+		b.Run(name, func(b *testing.B) {
+			filePath := createTempFilePath(b)
+			defer os.Remove(filePath)
+			bck, err := boltBackend.CreateBoltBackend(filePath)
+			assert.NoError(b, err)
+			if batched {
+				bck = CreateBatchingBackend(bck, DefaultOptions)
+			}
+			defer bck.Destroy()
+			trialID := fmt.Sprintf("trial-%d", b.N)
+			err = bck.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: trialID, Params: &grpcapi.TrialParams{}}})
+			assert.NoError(b, err)
+			payload := make([]byte, 1024)
+
+			b.ResetTimer()
+			for tickID := 0; tickID < b.N; tickID++ {
+				err := bck.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+					{TrialId: trialID, TickId: uint64(tickID), State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{payload}},
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			// Every sample is written
+			err = bck.EndTrials(context.Background(), []string{trialID})
+			assert.NoError(b, err)
+		})
+	}
+}
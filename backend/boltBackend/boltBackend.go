@@ -18,8 +18,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"time"
 
@@ -35,19 +37,67 @@ import (
 type boltBackend struct {
 	db                    *bolt.DB
 	filePath              string
-	observeDbPollingDelay time.Duration // The maximum duration between two polling of the db during an 'observe' request
+	observeDbPollingDelay time.Duration    // The maximum duration between two polling of the db during an 'observe trials' request
+	samplesNotifier       *samplesNotifier // Wakes up the observations of the samples of a trial when they are updated
+	marshalOptions        proto.MarshalOptions
+	serializationPool     *backend.SerializationPool
+	payloadCompression    backend.PayloadCompression
+	sampleChecksums       bool
+	verifySampleChecksums bool
+	creationClock         backend.CreationClock
 }
 
+// Options represents the configuration of a bolt backend
+type Options struct {
+	// Serialize samples using deterministic marshaling, equal samples are then always stored as identical bytes.
+	// It might be slower as map fields need to be sorted.
+	DeterministicSerialization bool
+	// How the payloads of the samples of the created trials are compressed, the compression of each trial is stored
+	// along with it so that it doesn't depend on the options used to reopen the file
+	PayloadCompression backend.PayloadCompression
+	// Recycle the buffers used to compress and serialize the added samples instead of allocating them for each sample
+	SerializationPooling bool
+	// Store a checksum along with each sample of the created trials, like the payload compression it is stored along
+	// with each trial
+	SampleChecksums bool
+	// Check the samples checksums when reading them, a mismatch results in a `backend.CorruptedSampleError`
+	VerifySampleChecksums bool
+}
+
+var DefaultOptions = Options{
+	DeterministicSerialization: false,
+	PayloadCompression:         backend.NoPayloadCompression,
+	SerializationPooling:       true,
+	SampleChecksums:            false,
+	VerifySampleChecksums:      true,
+}
+
+// The maximum number of samples read in a single transaction during an 'observe' request
+const observedSamplesBatchSize = 100
+
+// The maximum number of samples of each trial read to estimate the samples an 'observe' request would deliver
+const estimatedSamplesReadCount = 64
+
 type metadata struct {
-	UserID   string
-	TrialIdx uint64
+	UserID            string
+	TrialIdx          uint64
+	SampleOrderingKey string
+	CreatedAt         int64             // Creation timestamp, in nanoseconds since the Unix epoch, 0 when the trial was created without one
+	Tags              map[string]string // Tags of the trial, nil for trials stored before tags were supported
 }
 
 // Bucket structure is
 //	trials	> {trial_id}			> samples			> {tick_id}	> {grpcapi.StoredTrialSample}
 //														>	params			>	{grpcapi.TrialParams}
 //														> metadata		>	{boltBackend.metadata}
+//														> payload_compression	>	{backend.PayloadCompression}
+//														> sample_checksums	>	{}
+//														> samples_size	>	{samples_size}
+//														> result		>	{backend.TrialResult}
+//														> ended		>	{1}
+//														> abandoned		>	{1}
 //	trial_indices	>	trial_idx	>	{trial_idx}	>	{trial_id}
+//	stats	> see storageStats.go
 
 var trialsBucketName = []byte("trials")
 
@@ -65,6 +115,26 @@ var paramsKey = []byte("params")
 
 var metadataKey = []byte("metadata")
 
+// payloadCompressionKey is the key, in the trial bucket, of the compression of the trial's samples payloads
+var payloadCompressionKey = []byte("payload_compression")
+
+// sampleChecksumsKey is the key, in the trial bucket, marking a trial whose stored samples are followed by their checksum
+var sampleChecksumsKey = []byte("sample_checksums")
+
+// samplesSizeKey is the key, in the trial bucket, of the cumulated size of the serialized stored samples
+var samplesSizeKey = []byte("samples_size")
+
+// resultKey is the key, in the trial bucket, of the trial result, it is absent until a result is set
+var resultKey = []byte("result")
+
+// endedKey is the key, in the trial bucket, marking a trial explicitly ended without a sample in the `ENDED`
+// state, it is removed once samples are added
+var endedKey = []byte("ended")
+
+// abandonedKey is the key, in the trial bucket, marking a trial ended by `AbandonTrials`, it is removed along with
+// `endedKey`
+var abandonedKey = []byte("abandoned")
+
 var indicesBucketName = []byte("trial_indices")
 
 var trialsIdxBucketName = []byte("trial_idx")
@@ -86,6 +156,14 @@ func serializeNumID(id uint64) []byte {
 	return []byte(fmt.Sprintf("%016x", id))
 }
 
+func deserializeNumID(value []byte) (uint64, error) {
+	number, err := strconv.ParseUint(string(value), 16, 64)
+	if err != nil {
+		return 0, backend.NewUnexpectedError("unable to deserialize number id (%w)", err)
+	}
+	return number, nil
+}
+
 func deserializeNumIDAsInt(value []byte) (int, error) {
 	number, err := strconv.ParseInt(string(value), 16, 32)
 	if err != nil {
@@ -119,8 +197,27 @@ func deserializeTrialParams(v []byte) (*grpcapi.TrialParams, error) {
 	return params, nil
 }
 
-func serializeSample(sample *grpcapi.StoredTrialSample) ([]byte, error) {
-	v, err := proto.Marshal(sample)
+func serializeSample(sample *grpcapi.StoredTrialSample, marshalOptions proto.MarshalOptions) ([]byte, error) {
+	v, err := marshalOptions.Marshal(sample)
+	if err != nil {
+		return nil, backend.NewUnexpectedError("unable to serialize sample (%w)", err)
+	}
+	return v, nil
+}
+
+// serializePooledSample compresses the payloads of a sample and serializes it using the given buffers, the returned
+// bytes are only valid until they are released
+func serializePooledSample(
+	serializationBuffers *backend.SerializationBuffers,
+	sample *grpcapi.StoredTrialSample,
+	payloadCompression backend.PayloadCompression,
+	marshalOptions proto.MarshalOptions,
+) ([]byte, error) {
+	compressedSample, err := serializationBuffers.CompressSamplePayloads(sample, payloadCompression)
+	if err != nil {
+		return nil, err
+	}
+	v, err := serializationBuffers.MarshalSample(marshalOptions, compressedSample)
 	if err != nil {
 		return nil, backend.NewUnexpectedError("unable to serialize sample (%w)", err)
 	}
@@ -136,6 +233,38 @@ func deserializeSample(v []byte) (*grpcapi.StoredTrialSample, error) {
 	return sample, nil
 }
 
+// deserializeStoredSample deserializes a sample stored in the given trial bucket, checking its checksum if the trial
+// has some and the verification is enabled
+func (b *boltBackend) deserializeStoredSample(trialBucket *bolt.Bucket, trialID string, tickIDKey []byte, v []byte) (*grpcapi.StoredTrialSample, error) {
+	serializedSample, err := b.serializedStoredSample(trialBucket, trialID, tickIDKey, v)
+	if err != nil {
+		return nil, err
+	}
+	return deserializeSample(serializedSample)
+}
+
+// serializedStoredSample removes the checksum of a sample stored in the given trial bucket, if the trial has some,
+// checking it if the verification is enabled
+func (b *boltBackend) serializedStoredSample(trialBucket *bolt.Bucket, trialID string, tickIDKey []byte, v []byte) ([]byte, error) {
+	if trialBucket.Get(sampleChecksumsKey) == nil {
+		return v, nil
+	}
+	serializedSample, valid := backend.SplitSampleChecksum(v)
+	if !valid && b.verifySampleChecksums {
+		tickID, _ := deserializeNumID(tickIDKey)
+		return nil, &backend.CorruptedSampleError{TrialID: trialID, TickID: tickID}
+	}
+	return serializedSample, nil
+}
+
+// serializeStoredSample appends its checksum to a serialized sample if the given trial bucket has some
+func serializeStoredSample(trialBucket *bolt.Bucket, sampleV []byte) []byte {
+	if trialBucket.Get(sampleChecksumsKey) == nil {
+		return sampleV
+	}
+	return backend.AppendSampleChecksum(sampleV)
+}
+
 func serializeTrialMetadata(metadata *metadata) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -156,8 +285,41 @@ func deserializeTrialMetadata(v []byte) (*metadata, error) {
 	return metadata, nil
 }
 
+// creationTimestamp converts a stored creation timestamp to a time, 0 being the zero time
+func creationTimestamp(createdAt int64) time.Time {
+	if createdAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, createdAt)
+}
+
+func serializeTrialResult(result *backend.TrialResult) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(*result)
+	if err != nil {
+		return nil, backend.NewUnexpectedError("unable to serialize trial result (%w)", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func deserializeTrialResult(v []byte) (*backend.TrialResult, error) {
+	dec := gob.NewDecoder(bytes.NewBuffer(v))
+	result := &backend.TrialResult{}
+	err := dec.Decode(result)
+	if err != nil {
+		return nil, backend.NewUnexpectedError("unable to deserialize trial result (%w)", err)
+	}
+	return result, nil
+}
+
 // CreateBoltBackend creates a Backend that will store samples in a blot-managed file
 func CreateBoltBackend(filePath string) (backend.Backend, error) {
+	return CreateBoltBackendWithOptions(filePath, DefaultOptions)
+}
+
+// CreateBoltBackendWithOptions creates a Backend configured with the given options that will store samples in a blot-managed file
+func CreateBoltBackendWithOptions(filePath string, options Options) (backend.Backend, error) {
 	db, err := bolt.Open(filePath, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		// Opening of the file failed
@@ -177,6 +339,14 @@ func CreateBoltBackend(filePath string) (backend.Backend, error) {
 		if err != nil {
 			return backend.NewUnexpectedError("unable to create the trial idx bucket (%w)", err)
 		}
+		statsBucket, err := tx.CreateBucketIfNotExists(statsBucketName)
+		if err != nil {
+			return backend.NewUnexpectedError("unable to create the stats bucket (%w)", err)
+		}
+		if statsBucket.Get(trialsCountKey) == nil {
+			// Files created before the storage stats were maintained are counted once
+			return recountStorageCounters(tx)
+		}
 		return nil
 	})
 	if err != nil {
@@ -188,6 +358,34 @@ func CreateBoltBackend(filePath string) (backend.Backend, error) {
 		db:                    db,
 		filePath:              filePath,
 		observeDbPollingDelay: 100 * time.Millisecond,
+		samplesNotifier:       createSamplesNotifier(),
+		marshalOptions:        proto.MarshalOptions{Deterministic: options.DeterministicSerialization},
+		serializationPool:     backend.CreateSerializationPool(options.SerializationPooling),
+		payloadCompression:    options.PayloadCompression,
+		sampleChecksums:       options.SampleChecksums,
+		verifySampleChecksums: options.VerifySampleChecksums,
+	}
+
+	// New trials are created after the last stored one, even if the wall clock went backward since then
+	err = db.View(func(tx *bolt.Tx) error {
+		_, lastTrialIDKey := getTrialsIdxBucket(tx).Cursor().Last()
+		if lastTrialIDKey == nil {
+			return nil
+		}
+		lastTrialBucket := getTrialsBucket(tx).Bucket(lastTrialIDKey)
+		if lastTrialBucket == nil {
+			return nil
+		}
+		metadata, err := deserializeTrialMetadata(lastTrialBucket.Get(metadataKey))
+		if err != nil {
+			return err
+		}
+		b.creationClock.Observe(creationTimestamp(metadata.CreatedAt))
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 	return b, nil
 }
@@ -205,6 +403,7 @@ func (b *boltBackend) CreateOrUpdateTrials(ctx context.Context, paramsList []*ba
 		for _, params := range paramsList {
 			trialKey := serializeTrialID(params.TrialID)
 			var trialIdx uint64
+			var createdAt int64
 			trialBucket := trialsBucket.Bucket(trialKey)
 
 			if trialBucket == nil {
@@ -216,11 +415,29 @@ func (b *boltBackend) CreateOrUpdateTrials(ctx context.Context, paramsList []*ba
 				}
 
 				trialIdx, _ = trialsIdxBucket.NextSequence()
+				createdAt = b.creationClock.Now().UnixNano()
 				trialIdxKey := serializeNumID(trialIdx)
 				err = trialsIdxBucket.Put(trialIdxKey, trialKey)
 				if err != nil {
 					return backend.NewUnexpectedError("unable to add trial %q insertion index (%w)", params.TrialID, err)
 				}
+
+				err = trialBucket.Put(payloadCompressionKey, []byte(b.payloadCompression))
+				if err != nil {
+					return backend.NewUnexpectedError("unable to add trial %q payload compression (%w)", params.TrialID, err)
+				}
+
+				if b.sampleChecksums {
+					err = trialBucket.Put(sampleChecksumsKey, []byte{})
+					if err != nil {
+						return backend.NewUnexpectedError("unable to add trial %q sample checksums (%w)", params.TrialID, err)
+					}
+				}
+
+				err = updateStorageCounters(tx, storageCounters{trialsCount: 1})
+				if err != nil {
+					return err
+				}
 			} else {
 				// This is an existing trial, retrieving its idx
 				metadataV := trialBucket.Get(metadataKey)
@@ -232,6 +449,7 @@ func (b *boltBackend) CreateOrUpdateTrials(ctx context.Context, paramsList []*ba
 					return err
 				}
 				trialIdx = metadata.TrialIdx
+				createdAt = metadata.CreatedAt
 			}
 
 			// Create sample bucket if it doesn't exist
@@ -242,8 +460,11 @@ func (b *boltBackend) CreateOrUpdateTrials(ctx context.Context, paramsList []*ba
 
 			// Insert / Update metadata
 			metadataV, err := serializeTrialMetadata(&metadata{
-				UserID:   params.UserID,
-				TrialIdx: trialIdx,
+				UserID:            params.UserID,
+				TrialIdx:          trialIdx,
+				SampleOrderingKey: params.SampleOrderingKey.String(),
+				CreatedAt:         createdAt,
+				Tags:              params.Tags,
 			})
 			if err != nil {
 				return err
@@ -321,22 +542,49 @@ func (b *boltBackend) RetrieveTrials(ctx context.Context, filter []string, fromT
 					return backend.NewUnexpectedError("no sample bucket for trial %q", trialID)
 				}
 				samplesCount := samplesBucket.Stats().KeyN
+				samplesSize, err := getSamplesSize(trialBucket)
+				if err != nil {
+					return err
+				}
 				state := grpcapi.TrialState_UNKNOWN
+				minTickID, maxTickID := uint64(0), uint64(0)
 				if samplesCount > 0 {
-					_, v := samplesBucket.Cursor().Last()
-					lastSample := &grpcapi.StoredTrialSample{}
-					err := proto.Unmarshal(v, lastSample)
+					c := samplesBucket.Cursor()
+					minTickIDKey, _ := c.First()
+					minTickID, err = deserializeNumID(minTickIDKey)
 					if err != nil {
+						return err
+					}
+					maxTickIDKey, v := c.Last()
+					maxTickID, err = deserializeNumID(maxTickIDKey)
+					if err != nil {
+						return err
+					}
+					lastSample, err := b.deserializeStoredSample(trialBucket, trialID, maxTickIDKey, v)
+					if err != nil {
+						var corruptedSampleError *backend.CorruptedSampleError
+						if errors.As(err, &corruptedSampleError) {
+							return err
+						}
 						return backend.NewUnexpectedError("unable to deserialize the last stored sample of trial %q", trialID)
 					}
 					state = lastSample.State
 				}
+				if trialBucket.Get(endedKey) != nil {
+					state = grpcapi.TrialState_ENDED
+				}
 				trialInfos = append(trialInfos, &backend.TrialInfo{
 					TrialID:            trialID,
 					UserID:             metadata.UserID,
 					State:              state,
 					SamplesCount:       samplesCount,
 					StoredSamplesCount: samplesCount,
+					StoredSamplesSize:  int(samplesSize),
+					MinTickID:          minTickID,
+					MaxTickID:          maxTickID,
+					CreatedAt:          creationTimestamp(metadata.CreatedAt),
+					Tags:               metadata.Tags,
+					Abandoned:          trialBucket.Get(abandonedKey) != nil,
 				})
 			}
 		}
@@ -416,6 +664,10 @@ func (b *boltBackend) DeleteTrials(ctx context.Context, trialIDs []string) error
 		trialsBucket := getTrialsBucket(tx)
 		trialsIdxBucket := getTrialsIdxBucket(tx)
 		for _, trialID := range trialIDs {
+			if err := ctx.Err(); err != nil {
+				// Rolling back the deletion
+				return err
+			}
 			// Retrieving this trial's idx
 			trialIDKey := serializeTrialID(trialID)
 			trialBucket := trialsBucket.Bucket(trialIDKey)
@@ -436,6 +688,19 @@ func (b *boltBackend) DeleteTrials(ctx context.Context, trialIDs []string) error
 			}
 			trialIdx := metadata.TrialIdx
 
+			trialCounters, err := countTrialSamples(trialBucket)
+			if err != nil {
+				return err
+			}
+			err = updateStorageCounters(tx, storageCounters{
+				trialsCount:  -1,
+				samplesCount: -trialCounters.samplesCount,
+				samplesSize:  -trialCounters.samplesSize,
+			})
+			if err != nil {
+				return err
+			}
+
 			// Delete the trial bucket
 			err = trialsBucket.DeleteBucket(trialIDKey)
 			if err != nil {
@@ -456,9 +721,29 @@ func (b *boltBackend) DeleteTrials(ctx context.Context, trialIDs []string) error
 		return err
 	}
 
+	// Ending the ongoing observations of the deleted trials samples
+	b.samplesNotifier.notify(trialIDs...)
+
 	return nil
 }
 
+func (b *boltBackend) TrialsExist(ctx context.Context, trialIDs []string) ([]bool, error) {
+	exist := make([]bool, len(trialIDs))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		trialsBucket := getTrialsBucket(tx)
+		for idx, trialID := range trialIDs {
+			exist[idx] = trialsBucket.Bucket(serializeTrialID(trialID)) != nil
+		}
+		return nil
+	})
+
+	if err != nil {
+		return []bool{}, err
+	}
+
+	return exist, nil
+}
+
 func getTrialParams(tx *bolt.Tx, trialIDs []string) ([]*backend.TrialParams, error) {
 	paramsList := []*backend.TrialParams{}
 	trialsBucket := getTrialsBucket(tx)
@@ -491,10 +776,17 @@ func getTrialParams(tx *bolt.Tx, trialIDs []string) ([]*backend.TrialParams, err
 			return []*backend.TrialParams{}, err
 		}
 
+		sampleOrderingKey, err := backend.ParseSampleOrderingKey(metadata.SampleOrderingKey)
+		if err != nil {
+			return []*backend.TrialParams{}, backend.NewUnexpectedError("invalid sample ordering key for trial %q (%w)", trialID, err)
+		}
+
 		paramsList = append(paramsList, &backend.TrialParams{
-			TrialID: trialID,
-			UserID:  metadata.UserID,
-			Params:  params,
+			TrialID:           trialID,
+			UserID:            metadata.UserID,
+			Params:            params,
+			SampleOrderingKey: sampleOrderingKey,
+			Tags:              metadata.Tags,
 		})
 	}
 
@@ -518,11 +810,22 @@ func (b *boltBackend) GetTrialParams(ctx context.Context, trialIDs []string) ([]
 }
 
 func (b *boltBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error {
+	// bolt references the put values until the transaction is over, the buffers are released once the batch is done
+	serializationBuffersList := make([]*backend.SerializationBuffers, 0, len(samples))
+	defer func() {
+		for _, serializationBuffers := range serializationBuffersList {
+			serializationBuffers.Release()
+		}
+	}()
 	err := b.db.Batch(func(tx *bolt.Tx) error {
 		// Function must be idempotent as it might be called multiple times
 		trialsBucket := getTrialsBucket(tx)
 
 		for _, sample := range samples {
+			if err := ctx.Err(); err != nil {
+				// Rolling back the addition
+				return err
+			}
 			trialBucket := trialsBucket.Bucket(serializeTrialID(sample.TrialId))
 			if trialBucket == nil {
 				return &backend.UnknownTrialError{TrialID: sample.TrialId}
@@ -533,15 +836,144 @@ func (b *boltBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredT
 				return backend.NewUnexpectedError("no sample bucket for trial %q", sample.TrialId)
 			}
 
-			sampleV, err := serializeSample(sample)
+			payloadCompression, err := getPayloadCompression(trialBucket)
+			if err != nil {
+				return err
+			}
+			serializationBuffers := b.serializationPool.Acquire()
+			serializationBuffersList = append(serializationBuffersList, serializationBuffers)
+			sampleV, err := serializePooledSample(serializationBuffers, sample, payloadCompression, b.marshalOptions)
 			if err != nil {
 				return err
 			}
 
-			err = samplesBucket.Put(serializeNumID(sample.TickId), sampleV)
+			err = putSample(trialBucket, samplesBucket, serializeNumID(sample.TickId), serializeStoredSample(trialBucket, sampleV))
 			if err != nil {
 				return backend.NewUnexpectedError("unable to put sample %d for trial %q (%w)", sample.TickId, sample.TrialId, err)
 			}
+			err = deleteEndedMarks(trialBucket)
+			if err != nil {
+				return backend.NewUnexpectedError("unable to update the state of trial %q (%w)", sample.TrialId, err)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		// Error during the insertion
+		return err
+	}
+
+	trialIDs := make([]string, 0, 1)
+	for _, sample := range samples {
+		if len(trialIDs) == 0 || trialIDs[len(trialIDs)-1] != sample.TrialId {
+			trialIDs = append(trialIDs, sample.TrialId)
+		}
+	}
+	b.samplesNotifier.notify(trialIDs...)
+
+	return nil
+}
+
+func getPayloadCompression(trialBucket *bolt.Bucket) (backend.PayloadCompression, error) {
+	// Trials stored before the payload compression was supported don't define it
+	payloadCompression, err := backend.ParsePayloadCompression(string(trialBucket.Get(payloadCompressionKey)))
+	if err != nil {
+		return backend.NoPayloadCompression, backend.NewUnexpectedError("invalid payload compression (%w)", err)
+	}
+	return payloadCompression, nil
+}
+
+func getSamplesSize(trialBucket *bolt.Bucket) (uint64, error) {
+	samplesSizeV := trialBucket.Get(samplesSizeKey)
+	if samplesSizeV == nil {
+		// Trials stored before the samples size was tracked, `Reindex` computes it
+		return 0, nil
+	}
+	return deserializeNumID(samplesSizeV)
+}
+
+// putSample stores a serialized sample, replacing the one having the same tick id if any, and updates the
+// trial's samples size and the storage counters
+func putSample(trialBucket *bolt.Bucket, samplesBucket *bolt.Bucket, tickIDKey []byte, sampleV []byte) error {
+	samplesSize, err := getSamplesSize(trialBucket)
+	if err != nil {
+		return err
+	}
+	countersDelta := storageCounters{samplesCount: 1, samplesSize: int64(len(sampleV))}
+	samplesSize += uint64(len(sampleV))
+	if replacedSampleV := samplesBucket.Get(tickIDKey); replacedSampleV != nil {
+		samplesSize -= uint64(len(replacedSampleV))
+		countersDelta.samplesCount = 0
+		countersDelta.samplesSize -= int64(len(replacedSampleV))
+	}
+	err = samplesBucket.Put(tickIDKey, sampleV)
+	if err != nil {
+		return err
+	}
+	err = trialBucket.Put(samplesSizeKey, serializeNumID(samplesSize))
+	if err != nil {
+		return err
+	}
+	return updateStorageCounters(trialBucket.Tx(), countersDelta)
+}
+
+func (b *boltBackend) AddSamplePartial(ctx context.Context, partialSample *grpcapi.StoredTrialSample) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// bolt references the put values until the transaction is over, the buffers are released once the batch is done
+	serializationBuffersList := make([]*backend.SerializationBuffers, 0, 1)
+	defer func() {
+		for _, serializationBuffers := range serializationBuffersList {
+			serializationBuffers.Release()
+		}
+	}()
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		// Function must be idempotent as it might be called multiple times
+		trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(partialSample.TrialId))
+		if trialBucket == nil {
+			return &backend.UnknownTrialError{TrialID: partialSample.TrialId}
+		}
+
+		samplesBucket := trialBucket.Bucket(samplesBucketName)
+		if samplesBucket == nil {
+			return backend.NewUnexpectedError("no sample bucket for trial %q", partialSample.TrialId)
+		}
+
+		payloadCompression, err := getPayloadCompression(trialBucket)
+		if err != nil {
+			return err
+		}
+
+		tickIDKey := serializeNumID(partialSample.TickId)
+		sample := partialSample
+		if storedSampleV := samplesBucket.Get(tickIDKey); storedSampleV != nil {
+			storedSample, err := b.deserializeStoredSample(trialBucket, partialSample.TrialId, tickIDKey, storedSampleV)
+			if err != nil {
+				return err
+			}
+			err = backend.DecompressSamplePayloads(storedSample, payloadCompression)
+			if err != nil {
+				return err
+			}
+			sample = backend.MergeTrialSamples(storedSample, partialSample)
+		}
+
+		serializationBuffers := b.serializationPool.Acquire()
+		serializationBuffersList = append(serializationBuffersList, serializationBuffers)
+		sampleV, err := serializePooledSample(serializationBuffers, sample, payloadCompression, b.marshalOptions)
+		if err != nil {
+			return err
+		}
+
+		err = putSample(trialBucket, samplesBucket, tickIDKey, serializeStoredSample(trialBucket, sampleV))
+		if err != nil {
+			return backend.NewUnexpectedError("unable to put sample %d for trial %q (%w)", sample.TickId, sample.TrialId, err)
+		}
+		err = deleteEndedMarks(trialBucket)
+		if err != nil {
+			return backend.NewUnexpectedError("unable to update the state of trial %q (%w)", sample.TrialId, err)
 		}
 		return nil
 	})
@@ -551,9 +983,233 @@ func (b *boltBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredT
 		return err
 	}
 
+	b.samplesNotifier.notify(partialSample.TrialId)
+
 	return nil
 }
 
+// deleteEndedMarks removes the keys marking a trial as explicitly ended or abandoned
+func deleteEndedMarks(trialBucket *bolt.Bucket) error {
+	err := trialBucket.Delete(endedKey)
+	if err != nil {
+		return err
+	}
+	return trialBucket.Delete(abandonedKey)
+}
+
+func (b *boltBackend) EndTrials(ctx context.Context, trialIDs []string) error {
+	return b.endTrials(trialIDs, false)
+}
+
+func (b *boltBackend) AbandonTrials(ctx context.Context, trialIDs []string) error {
+	return b.endTrials(trialIDs, true)
+}
+
+func (b *boltBackend) endTrials(trialIDs []string, abandoned bool) error {
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		// Function must be idempotent as it might be called multiple times
+		trialsBucket := getTrialsBucket(tx)
+		for _, trialID := range trialIDs {
+			trialBucket := trialsBucket.Bucket(serializeTrialID(trialID))
+			if trialBucket == nil {
+				return &backend.UnknownTrialError{TrialID: trialID}
+			}
+			err := trialBucket.Put(endedKey, []byte{1})
+			if err != nil {
+				return backend.NewUnexpectedError("unable to end trial %q (%w)", trialID, err)
+			}
+			if abandoned {
+				err = trialBucket.Put(abandonedKey, []byte{1})
+			} else {
+				err = trialBucket.Delete(abandonedKey)
+			}
+			if err != nil {
+				return backend.NewUnexpectedError("unable to end trial %q (%w)", trialID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.samplesNotifier.notify(trialIDs...)
+
+	return nil
+}
+
+func (b *boltBackend) SetTrialResult(ctx context.Context, trialID string, result *backend.TrialResult) error {
+	resultV, err := serializeTrialResult(result)
+	if err != nil {
+		return err
+	}
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		// Function must be idempotent as it might be called multiple times
+		trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(trialID))
+		if trialBucket == nil {
+			return &backend.UnknownTrialError{TrialID: trialID}
+		}
+		err := trialBucket.Put(resultKey, resultV)
+		if err != nil {
+			return backend.NewUnexpectedError("unable to store the result of trial %q (%w)", trialID, err)
+		}
+		return nil
+	})
+}
+
+func (b *boltBackend) GetTrialResult(ctx context.Context, trialID string) (*backend.TrialResult, error) {
+	var result *backend.TrialResult
+	err := b.db.View(func(tx *bolt.Tx) error {
+		trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(trialID))
+		if trialBucket == nil {
+			return &backend.UnknownTrialError{TrialID: trialID}
+		}
+		resultV := trialBucket.Get(resultKey)
+		if resultV == nil {
+			return &backend.NoTrialResultError{TrialID: trialID}
+		}
+		var err error
+		result, err = deserializeTrialResult(resultV)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ClearSamples deletes every sample of a trial, and its result, while keeping its params.
+//
+// Ongoing observations of the trial samples continue, only retrieving the samples added afterwards whose tick id is
+// greater than the last one they retrieved.
+func (b *boltBackend) ClearSamples(ctx context.Context, trialID string) error {
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		// Function must be idempotent as it might be called multiple times
+		trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(trialID))
+		if trialBucket == nil {
+			return &backend.UnknownTrialError{TrialID: trialID}
+		}
+
+		trialCounters, err := countTrialSamples(trialBucket)
+		if err != nil {
+			return err
+		}
+		err = updateStorageCounters(tx, storageCounters{
+			samplesCount: -trialCounters.samplesCount,
+			samplesSize:  -trialCounters.samplesSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = trialBucket.DeleteBucket(samplesBucketName)
+		if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return backend.NewUnexpectedError("unable to delete trial %q sample bucket (%w)", trialID, err)
+		}
+
+		_, err = trialBucket.CreateBucket(samplesBucketName)
+		if err != nil {
+			return backend.NewUnexpectedError("unable to add trial %q sample bucket (%w)", trialID, err)
+		}
+		err = deleteEndedMarks(trialBucket)
+		if err != nil {
+			return backend.NewUnexpectedError("unable to update the state of trial %q (%w)", trialID, err)
+		}
+		err = trialBucket.Delete(resultKey)
+		if err != nil {
+			return backend.NewUnexpectedError("unable to delete the result of trial %q (%w)", trialID, err)
+		}
+		return trialBucket.Put(samplesSizeKey, serializeNumID(0))
+	})
+	if err != nil {
+		return err
+	}
+
+	b.samplesNotifier.notify(trialID)
+
+	return nil
+}
+
+// Reindex rebuilds the trials insertion index from the trials metadata, the trials' samples size from their samples and
+// the storage counters from the trials.
+func (b *boltBackend) Reindex(ctx context.Context) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		trialsBucket := getTrialsBucket(tx)
+		trialsIdxBucket := getTrialsIdxBucket(tx)
+
+		// Removing the index entries not matching an existing trial
+		staleTrialIdxKeys := [][]byte{}
+		err := trialsIdxBucket.ForEach(func(trialIdxKey []byte, trialIDKey []byte) error {
+			trialBucket := trialsBucket.Bucket(trialIDKey)
+			if trialBucket == nil {
+				staleTrialIdxKeys = append(staleTrialIdxKeys, trialIdxKey)
+				return nil
+			}
+			metadata, err := deserializeTrialMetadata(trialBucket.Get(metadataKey))
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(serializeNumID(metadata.TrialIdx), trialIdxKey) {
+				staleTrialIdxKeys = append(staleTrialIdxKeys, trialIdxKey)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, trialIdxKey := range staleTrialIdxKeys {
+			err := trialsIdxBucket.Delete(trialIdxKey)
+			if err != nil {
+				return backend.NewUnexpectedError("unable to delete stale trial idx (%w)", err)
+			}
+		}
+
+		// Adding the missing index entries
+		err = trialsBucket.ForEach(func(trialIDKey []byte, _ []byte) error {
+			trialBucket := trialsBucket.Bucket(trialIDKey)
+			if trialBucket == nil {
+				return nil
+			}
+			metadata, err := deserializeTrialMetadata(trialBucket.Get(metadataKey))
+			if err != nil {
+				return err
+			}
+			samplesBucket := trialBucket.Bucket(samplesBucketName)
+			if samplesBucket != nil {
+				samplesSize := uint64(0)
+				err := samplesBucket.ForEach(func(_ []byte, sampleV []byte) error {
+					samplesSize += uint64(len(sampleV))
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				err = trialBucket.Put(samplesSizeKey, serializeNumID(samplesSize))
+				if err != nil {
+					return backend.NewUnexpectedError("unable to update trial %q samples size (%w)", deserializeTrialID(trialIDKey), err)
+				}
+			}
+
+			trialIdxKey := serializeNumID(metadata.TrialIdx)
+			if trialsIdxBucket.Get(trialIdxKey) == nil {
+				err := trialsIdxBucket.Put(trialIdxKey, trialIDKey)
+				if err != nil {
+					return backend.NewUnexpectedError("unable to add trial %q insertion index (%w)", deserializeTrialID(trialIDKey), err)
+				}
+			}
+			if metadata.TrialIdx > trialsIdxBucket.Sequence() {
+				return trialsIdxBucket.SetSequence(metadata.TrialIdx)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return recountStorageCounters(tx)
+	})
+}
+
 func (b *boltBackend) ObserveSamples(ctx context.Context, filter backend.TrialSampleFilter, out chan<- *grpcapi.StoredTrialSample) error {
 	paramsList := []*backend.TrialParams{}
 	err := b.db.View(func(tx *bolt.Tx) error {
@@ -565,63 +1221,151 @@ func (b *boltBackend) ObserveSamples(ctx context.Context, filter backend.TrialSa
 	if err != nil {
 		return err
 	}
+	for _, params := range paramsList {
+		err := filter.CheckTrialParams(params.TrialID, params.Params)
+		if err != nil {
+			return err
+		}
+	}
 
 	g, ctx := errgroup.WithContext(ctx)
 	for _, params := range paramsList {
 		appliedFilter := backend.NewAppliedTrialSampleFilter(filter, params.Params)
 		params := params // Create a new 'params' that gets captured by the goroutine's closure https://golang.org/doc/faq#closures_and_goroutines
+		var trialOut chan<- *grpcapi.StoredTrialSample = out
+		closeTrialOut := func() {}
+		if !params.SampleOrderingKey.IsTickID() {
+			// This trial's samples need to be sorted before being sent
+			unsortedOut := make(backend.TrialSampleObserver)
+			trialOut = unsortedOut
+			closeTrialOut = func() { close(unsortedOut) }
+			g.Go(func() error {
+				return backend.ForwardSortedSamples(ctx, params.SampleOrderingKey, filter.Reverse, unsortedOut, out)
+			})
+		}
 		g.Go(func() error {
+			defer closeTrialOut()
+			payloadCompression := backend.NoPayloadCompression
+			err := b.db.View(func(tx *bolt.Tx) error {
+				trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(params.TrialID))
+				if trialBucket == nil {
+					// The trial was deleted in the meantime
+					return nil
+				}
+				var err error
+				payloadCompression, err = getPayloadCompression(trialBucket)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if filter.Reverse {
+				return b.observeStoredSamplesInReverse(ctx, params.TrialID, filter, appliedFilter, payloadCompression, trialOut)
+			}
+			// Subscribing before the first read, samples added afterwards are always notified
+			subscription := b.samplesNotifier.subscribe(params.TrialID)
+			defer b.samplesNotifier.unsubscribe(params.TrialID, subscription)
 			trialEnded := false
 			var lastTickIDKey []byte
+			var fromTickIDKey, toTickIDKey []byte
+			if filter.FromTickID != nil {
+				fromTickIDKey = serializeNumID(*filter.FromTickID)
+			}
+			if filter.ToTickID != nil {
+				toTickIDKey = serializeNumID(*filter.ToTickID)
+			}
 			for {
 				// Retrieving a bunch of samples for this trial
+				readSamples := make([]*grpcapi.StoredTrialSample, 0, observedSamplesBatchSize)
 				err := b.db.View(func(tx *bolt.Tx) error {
-					// Assuming all the buckets are there, we already checked before that the the trial exists and the other buckets are "static"
-					samplesBucket := getTrialsBucket(tx).Bucket(serializeTrialID(params.TrialID)).Bucket(samplesBucketName)
+					trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(params.TrialID))
+					if trialBucket == nil {
+						// The trial was deleted during the observation, ending it
+						trialEnded = true
+						return nil
+					}
+					// Assuming the other buckets are there, they are "static"
+					samplesBucket := trialBucket.Bucket(samplesBucketName)
 
 					var tickIDKey []byte
 					var sampleV []byte
 					c := samplesBucket.Cursor()
-					if lastTickIDKey == nil {
+					if lastTickIDKey == nil && fromTickIDKey != nil {
+						// No 'saved' key, start at the first selected tick
+						tickIDKey, sampleV = c.Seek(fromTickIDKey)
+					} else if lastTickIDKey == nil {
 						// No 'saved' key, start at the beginning
 						tickIDKey, sampleV = c.First()
 					} else {
 						// A key has been saved, seeking it
-						_, _ = c.Seek(lastTickIDKey)
-						// And then go to the following one
-						tickIDKey, sampleV = c.Next()
+						tickIDKey, sampleV = c.Seek(lastTickIDKey)
+						if bytes.Equal(tickIDKey, lastTickIDKey) {
+							// And then go to the following one
+							tickIDKey, sampleV = c.Next()
+						}
 					}
 					for ; tickIDKey != nil; tickIDKey, sampleV = c.Next() {
-						sample, err := deserializeSample(sampleV)
+						if err := ctx.Err(); err != nil {
+							// The observation is canceled, e.g. the client went away, not scanning the remaining samples
+							return err
+						}
+						if toTickIDKey != nil && bytes.Compare(tickIDKey, toTickIDKey) > 0 {
+							// Samples are ordered by tick id, every selected sample has been read
+							trialEnded = true
+							return nil
+						}
+						sample, err := b.deserializeStoredSample(trialBucket, params.TrialID, tickIDKey, sampleV)
+						if err != nil {
+							return err
+						}
+						err = backend.DecompressSamplePayloads(sample, payloadCompression)
 						if err != nil {
 							return err
 						}
 
 						trialEnded = sample.State == grpcapi.TrialState_ENDED
+						lastTickIDKey = make([]byte, len(tickIDKey))
+						copy(lastTickIDKey, tickIDKey)
 						filteredSample := appliedFilter.Filter(sample)
-
-						select {
-						case <-ctx.Done():
-							return ctx.Err()
-						case out <- filteredSample:
-							// A valid key was reached, saving it
-							lastTickIDKey = make([]byte, len(tickIDKey))
-							copy(lastTickIDKey, tickIDKey)
+						if filteredSample == nil {
+							// The sample is filtered out
 							continue
 						}
+						readSamples = append(readSamples, filteredSample)
+						if len(readSamples) >= observedSamplesBatchSize {
+							return nil
+						}
+					}
+					if trialBucket.Get(endedKey) != nil {
+						// Every stored sample has been read and the trial was explicitly ended
+						trialEnded = true
 					}
 					return nil
 				})
 				if err != nil {
 					return err
 				}
+				// Samples are sent once the read transaction is closed, a slow observer would otherwise block any write
+				// needing the database to grow
+				for _, sample := range readSamples {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case trialOut <- sample:
+					}
+				}
 				if trialEnded {
 					break
 				}
+				if len(readSamples) >= observedSamplesBatchSize {
+					// The batch was full, more samples are likely already available
+					continue
+				}
+				// Waiting for the trial samples to be updated
 				select {
 				case <-ctx.Done():
 					return ctx.Err()
-				case <-time.After(b.observeDbPollingDelay):
+				case <-*subscription:
 					continue
 				}
 			}
@@ -631,3 +1375,324 @@ func (b *boltBackend) ObserveSamples(ctx context.Context, filter backend.TrialSa
 
 	return g.Wait()
 }
+
+// seekReverse moves the cursor to the last key before the given one, or equal to it when `inclusive` is set, and to the
+// last key when no key is given
+func seekReverse(c *bolt.Cursor, key []byte, inclusive bool) ([]byte, []byte) {
+	if key == nil {
+		return c.Last()
+	}
+	seekedKey, v := c.Seek(key)
+	if seekedKey == nil {
+		// Every key is before the given one
+		return c.Last()
+	}
+	if inclusive && bytes.Equal(seekedKey, key) {
+		return seekedKey, v
+	}
+	return c.Prev()
+}
+
+// observeStoredSamplesInReverse sends the currently stored samples of a trial, from the last to the first one, reading
+// them with a reverse cursor
+func (b *boltBackend) observeStoredSamplesInReverse(
+	ctx context.Context,
+	trialID string,
+	filter backend.TrialSampleFilter,
+	appliedFilter *backend.AppliedTrialSampleFilter,
+	payloadCompression backend.PayloadCompression,
+	out chan<- *grpcapi.StoredTrialSample,
+) error {
+	var fromTickIDKey, toTickIDKey []byte
+	if filter.FromTickID != nil {
+		fromTickIDKey = serializeNumID(*filter.FromTickID)
+	}
+	if filter.ToTickID != nil {
+		toTickIDKey = serializeNumID(*filter.ToTickID)
+	}
+	var lastTickIDKey []byte
+	trialEnded := false
+	for !trialEnded {
+		// Retrieving a bunch of samples for this trial
+		readSamples := make([]*grpcapi.StoredTrialSample, 0, observedSamplesBatchSize)
+		err := b.db.View(func(tx *bolt.Tx) error {
+			trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(trialID))
+			if trialBucket == nil {
+				// The trial was deleted during the observation, ending it
+				trialEnded = true
+				return nil
+			}
+			c := trialBucket.Bucket(samplesBucketName).Cursor()
+			var tickIDKey []byte
+			var sampleV []byte
+			if lastTickIDKey == nil {
+				// No 'saved' key, start at the last selected tick
+				tickIDKey, sampleV = seekReverse(c, toTickIDKey, true)
+			} else {
+				// A key has been saved, start at the previous one
+				tickIDKey, sampleV = seekReverse(c, lastTickIDKey, false)
+			}
+			for ; tickIDKey != nil; tickIDKey, sampleV = c.Prev() {
+				if err := ctx.Err(); err != nil {
+					// The observation is canceled, e.g. the client went away, not scanning the remaining samples
+					return err
+				}
+				if fromTickIDKey != nil && bytes.Compare(tickIDKey, fromTickIDKey) < 0 {
+					// Samples are ordered by tick id, every selected sample has been read
+					trialEnded = true
+					return nil
+				}
+				sample, err := b.deserializeStoredSample(trialBucket, trialID, tickIDKey, sampleV)
+				if err != nil {
+					return err
+				}
+				err = backend.DecompressSamplePayloads(sample, payloadCompression)
+				if err != nil {
+					return err
+				}
+
+				lastTickIDKey = make([]byte, len(tickIDKey))
+				copy(lastTickIDKey, tickIDKey)
+				filteredSample := appliedFilter.Filter(sample)
+				if filteredSample == nil {
+					// The sample is filtered out
+					continue
+				}
+				readSamples = append(readSamples, filteredSample)
+				if len(readSamples) >= observedSamplesBatchSize {
+					return nil
+				}
+			}
+			// The first stored sample has been read
+			trialEnded = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Samples are sent once the read transaction is closed, a slow observer would otherwise block any write
+		// needing the database to grow
+		for _, sample := range readSamples {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- sample:
+			}
+		}
+	}
+	return nil
+}
+
+func (b *boltBackend) RetrieveLatestSample(ctx context.Context, trialID string, filter backend.TrialSampleFilter) (*grpcapi.StoredTrialSample, error) {
+	var latestSample *grpcapi.StoredTrialSample
+	err := b.db.View(func(tx *bolt.Tx) error {
+		paramsList, err := getTrialParams(tx, []string{trialID})
+		if err != nil {
+			return err
+		}
+		params := paramsList[0].Params
+		err = filter.CheckTrialParams(trialID, params)
+		if err != nil {
+			return err
+		}
+		trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(trialID))
+		// Samples are keyed by tick id, the last key is the one of the latest sample
+		tickIDKey, sampleV := trialBucket.Bucket(samplesBucketName).Cursor().Last()
+		if tickIDKey == nil {
+			return nil
+		}
+		payloadCompression, err := getPayloadCompression(trialBucket)
+		if err != nil {
+			return err
+		}
+		sample, err := b.deserializeStoredSample(trialBucket, trialID, tickIDKey, sampleV)
+		if err != nil {
+			return err
+		}
+		err = backend.DecompressSamplePayloads(sample, payloadCompression)
+		if err != nil {
+			return err
+		}
+		filter.FromTickID, filter.ToTickID = nil, nil
+		latestSample = backend.NewAppliedTrialSampleFilter(filter, params).Filter(sample)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return latestSample, nil
+}
+
+// RetrieveSamplePayload extracts the payload from the stored serialized sample, decompressing it alone
+func (b *boltBackend) RetrieveSamplePayload(ctx context.Context, trialID string, tickID uint64, payloadIdx uint32) ([]byte, error) {
+	var payload []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(trialID))
+		if trialBucket == nil {
+			return &backend.UnknownTrialError{TrialID: trialID}
+		}
+		samplesBucket := trialBucket.Bucket(samplesBucketName)
+		if samplesBucket == nil {
+			return backend.NewUnexpectedError("no sample bucket for trial %q", trialID)
+		}
+		tickIDKey := serializeNumID(tickID)
+		sampleV := samplesBucket.Get(tickIDKey)
+		if sampleV == nil {
+			unknownSampleErr := &backend.UnknownSampleError{TrialID: trialID, TickID: tickID, StoredSamplesCount: samplesBucket.Stats().KeyN}
+			c := samplesBucket.Cursor()
+			minTickIDKey, _ := c.First()
+			maxTickIDKey, _ := c.Last()
+			if minTickIDKey != nil {
+				unknownSampleErr.MinTickID, _ = deserializeNumID(minTickIDKey)
+				unknownSampleErr.MaxTickID, _ = deserializeNumID(maxTickIDKey)
+			}
+			return unknownSampleErr
+		}
+		serializedSample, err := b.serializedStoredSample(trialBucket, trialID, tickIDKey, sampleV)
+		if err != nil {
+			return err
+		}
+		storedPayload, found, err := backend.ExtractSerializedSamplePayload(serializedSample, payloadIdx)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return &backend.UnknownPayloadError{TrialID: trialID, TickID: tickID, PayloadIdx: payloadIdx}
+		}
+		payloadCompression, err := getPayloadCompression(trialBucket)
+		if err != nil {
+			return err
+		}
+		// The stored payload is only valid during the transaction
+		payload, err = backend.DecompressPayload(append([]byte{}, storedPayload...), payloadCompression)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// EstimateSamples reads, for each trial, at most `estimatedSamplesReadCount` evenly spread samples within the tick
+// range and extrapolates the estimate to the other samples, from the proportion of the read samples that are
+// selected and the ratio between their filtered and stored sizes. The estimate is exact for the trials having fewer
+// samples in the tick range.
+func (b *boltBackend) EstimateSamples(ctx context.Context, filter backend.TrialSampleFilter) (backend.SamplesEstimate, error) {
+	estimate := backend.SamplesEstimate{Exact: true}
+	paramsList := []*backend.TrialParams{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		paramsList, err = getTrialParams(tx, filter.TrialIDs)
+		return err
+	})
+	if err != nil {
+		return estimate, err
+	}
+	for _, params := range paramsList {
+		err := filter.CheckTrialParams(params.TrialID, params.Params)
+		if err != nil {
+			return estimate, err
+		}
+	}
+
+	var fromTickIDKey, toTickIDKey []byte
+	if filter.FromTickID != nil {
+		fromTickIDKey = serializeNumID(*filter.FromTickID)
+	}
+	if filter.ToTickID != nil {
+		toTickIDKey = serializeNumID(*filter.ToTickID)
+	}
+	for _, params := range paramsList {
+		appliedFilter := backend.NewAppliedTrialSampleFilter(filter, params.Params)
+		err := b.db.View(func(tx *bolt.Tx) error {
+			trialBucket := getTrialsBucket(tx).Bucket(serializeTrialID(params.TrialID))
+			if trialBucket == nil {
+				// The trial was deleted in the meantime
+				return nil
+			}
+			payloadCompression, err := getPayloadCompression(trialBucket)
+			if err != nil {
+				return err
+			}
+			c := trialBucket.Bucket(samplesBucketName).Cursor()
+			forEachSampleInRange := func(fn func(sampleIdx int, tickIDKey []byte, sampleV []byte) error) error {
+				tickIDKey, sampleV := c.First()
+				if fromTickIDKey != nil {
+					tickIDKey, sampleV = c.Seek(fromTickIDKey)
+				}
+				for sampleIdx := 0; tickIDKey != nil; tickIDKey, sampleV = c.Next() {
+					if toTickIDKey != nil && bytes.Compare(tickIDKey, toTickIDKey) > 0 {
+						return nil
+					}
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					err := fn(sampleIdx, tickIDKey, sampleV)
+					if err != nil {
+						return err
+					}
+					sampleIdx++
+				}
+				return nil
+			}
+
+			// Going through the keys, the samples are only read when deserialized
+			storedSamplesCount := 0
+			storedSamplesSize := 0
+			err = forEachSampleInRange(func(_ int, _ []byte, sampleV []byte) error {
+				storedSamplesCount++
+				storedSamplesSize += len(sampleV)
+				return nil
+			})
+			if err != nil || storedSamplesCount == 0 {
+				return err
+			}
+
+			readSamplesInterval := (storedSamplesCount + estimatedSamplesReadCount - 1) / estimatedSamplesReadCount
+			readSamplesSize := 0
+			readSamplesCount := 0
+			selectedSamplesSize := 0
+			selectedSamplesCount := 0
+			err = forEachSampleInRange(func(sampleIdx int, tickIDKey []byte, sampleV []byte) error {
+				if sampleIdx%readSamplesInterval != 0 {
+					return nil
+				}
+				readSamplesCount++
+				readSamplesSize += len(sampleV)
+				sample, err := b.deserializeStoredSample(trialBucket, params.TrialID, tickIDKey, sampleV)
+				if err != nil {
+					return err
+				}
+				err = backend.DecompressSamplePayloads(sample, payloadCompression)
+				if err != nil {
+					return err
+				}
+				filteredSample := appliedFilter.Filter(sample)
+				if filteredSample != nil {
+					selectedSamplesCount++
+					selectedSamplesSize += proto.Size(filteredSample)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if readSamplesInterval == 1 {
+				estimate.SamplesCount += selectedSamplesCount
+				estimate.SamplesSize += selectedSamplesSize
+				return nil
+			}
+			estimate.Exact = false
+			estimate.SamplesCount += int(math.Round(float64(storedSamplesCount) * float64(selectedSamplesCount) / float64(readSamplesCount)))
+			if readSamplesSize > 0 {
+				estimate.SamplesSize += int(math.Round(float64(storedSamplesSize) * float64(selectedSamplesSize) / float64(readSamplesSize)))
+			}
+			return nil
+		})
+		if err != nil {
+			return estimate, err
+		}
+	}
+	return estimate, nil
+}
@@ -15,13 +15,21 @@
 package boltBackend
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/openlyinc/pointy"
 	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/cogment/cogment-trial-datastore/backend"
 	"github.com/cogment/cogment-trial-datastore/backend/test"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
 )
 
 func TestSuiteBoltBackend(t *testing.T) {
@@ -44,6 +52,44 @@ func TestSuiteBoltBackend(t *testing.T) {
 	})
 }
 
+func TestSuiteBoltBackendPayloadCompression(t *testing.T) {
+	test.RunSuite(t, func() backend.Backend {
+		f, err := os.CreateTemp("", "trial-datastore-bolt-test")
+		assert.NoError(t, err)
+		defer f.Close()
+
+		options := DefaultOptions
+		options.PayloadCompression = backend.LZ4PayloadCompression
+		b, err := CreateBoltBackendWithOptions(f.Name(), options)
+		assert.NoError(t, err)
+		return b
+	}, func(b backend.Backend) {
+		rb := b.(*boltBackend)
+
+		defer os.Remove(rb.filePath)
+		defer rb.Destroy()
+	})
+}
+
+func TestSuiteBoltBackendSampleChecksums(t *testing.T) {
+	test.RunSuite(t, func() backend.Backend {
+		f, err := os.CreateTemp("", "trial-datastore-bolt-test")
+		assert.NoError(t, err)
+		defer f.Close()
+
+		options := DefaultOptions
+		options.SampleChecksums = true
+		b, err := CreateBoltBackendWithOptions(f.Name(), options)
+		assert.NoError(t, err)
+		return b
+	}, func(b backend.Backend) {
+		rb := b.(*boltBackend)
+
+		defer os.Remove(rb.filePath)
+		defer rb.Destroy()
+	})
+}
+
 func BenchmarkBoltBackend(b *testing.B) {
 	test.RunBenchmarks(b, func() backend.Backend {
 		// create and open a temporary file
@@ -63,3 +109,364 @@ func BenchmarkBoltBackend(b *testing.B) {
 		defer rb.Destroy()
 	})
 }
+
+func TestDeterministicSerialization(t *testing.T) {
+	sample := &grpcapi.StoredTrialSample{
+		TrialId: "my-trial",
+		TickId:  12,
+		State:   grpcapi.TrialState_RUNNING,
+		ActorSamples: []*grpcapi.StoredTrialActorSample{
+			{Actor: 0, Observation: pointy.Uint32(0), Reward: pointy.Float32(0.5)},
+			{Actor: 1, Action: pointy.Uint32(1)},
+		},
+		Payloads: [][]byte{[]byte("an observation"), []byte("an action")},
+	}
+	equalSample := proto.Clone(sample).(*grpcapi.StoredTrialSample)
+
+	marshalOptions := proto.MarshalOptions{Deterministic: true}
+	sampleV, err := serializeSample(sample, marshalOptions)
+	assert.NoError(t, err)
+	equalSampleV, err := serializeSample(equalSample, marshalOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, sampleV, equalSampleV)
+}
+
+func TestReopenExistingFile(t *testing.T) {
+	f, err := os.CreateTemp("", "trial-datastore-bolt-test")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	b, err := CreateBoltBackend(f.Name())
+	assert.NoError(t, err)
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "my-trial", UserID: "my-user", Params: &grpcapi.TrialParams{MaxSteps: 12}},
+	})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+		{TrialId: "my-trial", TickId: 0, State: grpcapi.TrialState_RUNNING},
+		{TrialId: "my-trial", TickId: 1, State: grpcapi.TrialState_ENDED},
+	})
+	assert.NoError(t, err)
+	b.Destroy()
+
+	// Reopening the same file, as on a restart
+	b, err = CreateBoltBackend(f.Name())
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Len(t, trialsInfo.TrialInfos, 1)
+	assert.Equal(t, "my-user", trialsInfo.TrialInfos[0].UserID)
+	assert.Equal(t, grpcapi.TrialState_ENDED, trialsInfo.TrialInfos[0].State)
+	assert.Equal(t, 2, trialsInfo.TrialInfos[0].StoredSamplesCount)
+	assert.False(t, trialsInfo.TrialInfos[0].CreatedAt.IsZero())
+
+	// New trials are appended after the existing ones
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "my-other-trial", UserID: "my-user", Params: &grpcapi.TrialParams{MaxSteps: 12}},
+	})
+	assert.NoError(t, err)
+	trialsInfo, err = b.RetrieveTrials(context.Background(), []string{}, 0, -1)
+	assert.NoError(t, err)
+	assert.Len(t, trialsInfo.TrialInfos, 2)
+	assert.Equal(t, "my-trial", trialsInfo.TrialInfos[0].TrialID)
+	assert.Equal(t, "my-other-trial", trialsInfo.TrialInfos[1].TrialID)
+	assert.True(t, trialsInfo.TrialInfos[1].CreatedAt.After(trialsInfo.TrialInfos[0].CreatedAt))
+}
+
+func TestCompactFile(t *testing.T) {
+	f, err := os.CreateTemp("", "trial-datastore-bolt-test")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	b, err := CreateBoltBackend(f.Name())
+	assert.NoError(t, err)
+	trialIDs := []string{}
+	for trialIdx := 0; trialIdx < 20; trialIdx++ {
+		trialID := fmt.Sprintf("trial-%d", trialIdx)
+		trialIDs = append(trialIDs, trialID)
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: trialID, UserID: "my-user", Params: &grpcapi.TrialParams{MaxSteps: 12}},
+		})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 50; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{
+				TrialId:  trialID,
+				TickId:   tickID,
+				State:    grpcapi.TrialState_RUNNING,
+				Payloads: [][]byte{bytes.Repeat([]byte{byte(tickID)}, 1024)},
+			})
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+	}
+	err = b.DeleteTrials(context.Background(), trialIDs[1:])
+	assert.NoError(t, err)
+	b.Destroy()
+
+	sizeBefore, sizeAfter, err := CompactFile(f.Name())
+	assert.NoError(t, err)
+	assert.Less(t, sizeAfter, sizeBefore)
+	info, err := os.Stat(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, sizeAfter, info.Size())
+
+	// The remaining trial is still there
+	b, err = CreateBoltBackend(f.Name())
+	assert.NoError(t, err)
+	defer b.Destroy()
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{}, 0, -1)
+	assert.NoError(t, err)
+	assert.Len(t, trialsInfo.TrialInfos, 1)
+	assert.Equal(t, "trial-0", trialsInfo.TrialInfos[0].TrialID)
+	assert.Equal(t, 50, trialsInfo.TrialInfos[0].StoredSamplesCount)
+}
+
+func TestObserveSamplesFollowsAppendedSamples(t *testing.T) {
+	f, err := os.CreateTemp("", "trial-datastore-bolt-test")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	b, err := CreateBoltBackend(f.Name())
+	assert.NoError(t, err)
+	defer b.Destroy()
+	rb := b.(*boltBackend)
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "my-trial", UserID: "my-user", Params: &grpcapi.TrialParams{MaxSteps: 12}},
+	})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+		{TrialId: "my-trial", TickId: 0, State: grpcapi.TrialState_RUNNING},
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	observer := make(backend.TrialSampleObserver)
+	observeResult := make(chan error, 1)
+	go func() {
+		defer close(observer)
+		observeResult <- b.ObserveSamples(ctx, backend.TrialSampleFilter{TrialIDs: []string{"my-trial"}}, observer)
+	}()
+
+	sample := <-observer
+	assert.Equal(t, uint64(0), sample.TickId)
+	assert.Equal(t, 1, rb.samplesNotifier.subscriptionsCount())
+
+	// Appended samples wake up the observation
+	for tickID := uint64(1); tickID <= 3; tickID++ {
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "my-trial", TickId: tickID, State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+		select {
+		case sample := <-observer:
+			assert.Equal(t, tickID, sample.TickId)
+		case <-time.After(rb.observeDbPollingDelay / 2):
+			assert.FailNow(t, "the appended sample wasn't observed")
+		}
+	}
+
+	// Canceling the observation unsubscribes it
+	cancel()
+	assert.ErrorIs(t, <-observeResult, context.Canceled)
+	assert.Equal(t, 0, rb.samplesNotifier.subscriptionsCount())
+}
+
+func TestStorageStatsOfFileWithoutStats(t *testing.T) {
+	f, err := os.CreateTemp("", "trial-datastore-bolt-test")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	b, err := CreateBoltBackend(f.Name())
+	assert.NoError(t, err)
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "my-trial", Params: &grpcapi.TrialParams{MaxSteps: 12}},
+	})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+		{TrialId: "my-trial", TickId: 0, State: grpcapi.TrialState_RUNNING},
+		{TrialId: "my-trial", TickId: 1, State: grpcapi.TrialState_ENDED},
+	})
+	assert.NoError(t, err)
+	stats, err := b.StorageStats(context.Background())
+	assert.NoError(t, err)
+
+	// Removing the stats, as in a file created before they were maintained
+	err = b.(*boltBackend).db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(statsBucketName)
+	})
+	assert.NoError(t, err)
+	b.Destroy()
+
+	b, err = CreateBoltBackend(f.Name())
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	recountedStats, err := b.StorageStats(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, recountedStats.TrialsCount)
+	assert.Equal(t, 2, recountedStats.SamplesCount)
+	assert.Equal(t, stats.SamplesSize, recountedStats.SamplesSize)
+	assert.True(t, recountedStats.Persistent)
+	assert.Greater(t, recountedStats.FreeSpace, int64(0))
+}
+
+func observeTrialSamples(b backend.Backend, trialID string) ([]*grpcapi.StoredTrialSample, error) {
+	observer := make(backend.TrialSampleObserver)
+	observeErr := make(chan error, 1)
+	go func() {
+		defer close(observer)
+		observeErr <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{trialID}}, observer)
+	}()
+	samples := []*grpcapi.StoredTrialSample{}
+	for sample := range observer {
+		samples = append(samples, sample)
+	}
+	return samples, <-observeErr
+}
+
+func TestCorruptedSample(t *testing.T) {
+	f, err := os.CreateTemp("", "trial-datastore-bolt-test")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	options := DefaultOptions
+	options.SampleChecksums = true
+	b, err := CreateBoltBackendWithOptions(f.Name(), options)
+	assert.NoError(t, err)
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "my-trial", Params: &grpcapi.TrialParams{MaxSteps: 12}},
+	})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+		{TrialId: "my-trial", TickId: 0, State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{[]byte("an observation")}},
+		{TrialId: "my-trial", TickId: 1, State: grpcapi.TrialState_ENDED, Payloads: [][]byte{[]byte("another observation")}},
+	})
+	assert.NoError(t, err)
+
+	samples, err := observeTrialSamples(b, "my-trial")
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+
+	// Flipping a byte of the stored sample of tick 1, here in its checksum
+	err = b.(*boltBackend).db.Update(func(tx *bolt.Tx) error {
+		samplesBucket := getTrialsBucket(tx).Bucket(serializeTrialID("my-trial")).Bucket(samplesBucketName)
+		sampleV := append([]byte{}, samplesBucket.Get(serializeNumID(1))...)
+		sampleV[len(sampleV)-1] ^= 0x01
+		return samplesBucket.Put(serializeNumID(1), sampleV)
+	})
+	assert.NoError(t, err)
+
+	_, err = observeTrialSamples(b, "my-trial")
+	assert.Equal(t, &backend.CorruptedSampleError{TrialID: "my-trial", TickID: 1}, err)
+
+	err = b.AddSamplePartial(context.Background(), &grpcapi.StoredTrialSample{TrialId: "my-trial", TickId: 1, State: grpcapi.TrialState_ENDED})
+	assert.Equal(t, &backend.CorruptedSampleError{TrialID: "my-trial", TickID: 1}, err)
+
+	_, err = b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+	var corruptedSampleErr *backend.CorruptedSampleError
+	assert.ErrorAs(t, err, &corruptedSampleErr)
+	assert.Equal(t, uint64(1), corruptedSampleErr.TickID)
+	b.Destroy()
+
+	// Without verification the samples are read as is
+	options.VerifySampleChecksums = false
+	b, err = CreateBoltBackendWithOptions(f.Name(), options)
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	samples, err = observeTrialSamples(b, "my-trial")
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, []byte("another observation"), samples[1].Payloads[0])
+}
+
+func TestSampleChecksumsStoredWithTrials(t *testing.T) {
+	f, err := os.CreateTemp("", "trial-datastore-bolt-test")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	b, err := CreateBoltBackend(f.Name())
+	assert.NoError(t, err)
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "trial-without-checksums"}})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "trial-without-checksums", TickId: 0, State: grpcapi.TrialState_ENDED}})
+	assert.NoError(t, err)
+	b.Destroy()
+
+	// Trials created before the checksums were enabled remain readable
+	options := DefaultOptions
+	options.SampleChecksums = true
+	b, err = CreateBoltBackendWithOptions(f.Name(), options)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "trial-with-checksums"}})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "trial-with-checksums", TickId: 0, State: grpcapi.TrialState_ENDED}})
+	assert.NoError(t, err)
+
+	for _, trialID := range []string{"trial-without-checksums", "trial-with-checksums"} {
+		samples, err := observeTrialSamples(b, trialID)
+		assert.NoError(t, err)
+		assert.Len(t, samples, 1)
+	}
+}
+
+func TestEstimateSamplesOfLargeTrial(t *testing.T) {
+	f, err := os.CreateTemp("", "trial-datastore-bolt-test")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	b, err := CreateBoltBackend(f.Name())
+	assert.NoError(t, err)
+	defer b.Destroy()
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "my-trial", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "actor-0"}, {Name: "actor-1"}}}},
+	})
+	assert.NoError(t, err)
+	samples := []*grpcapi.StoredTrialSample{}
+	for tickID := uint64(0); tickID < 10*estimatedSamplesReadCount; tickID++ {
+		samples = append(samples, &grpcapi.StoredTrialSample{
+			TrialId: "my-trial",
+			TickId:  tickID,
+			State:   grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{Actor: 0, Observation: pointy.Uint32(0)},
+				{Actor: 1, Observation: pointy.Uint32(1)},
+			},
+			Payloads: [][]byte{bytes.Repeat([]byte{'a'}, 64), bytes.Repeat([]byte{'b'}, 256)},
+		})
+	}
+	samples[len(samples)-1].State = grpcapi.TrialState_ENDED
+	err = b.AddSamples(context.Background(), samples)
+	assert.NoError(t, err)
+
+	filter := backend.TrialSampleFilter{TrialIDs: []string{"my-trial"}, ActorNames: []string{"actor-0"}, CompactPayloads: true}
+	estimate, err := b.EstimateSamples(context.Background(), filter)
+	assert.NoError(t, err)
+	assert.False(t, estimate.Exact)
+
+	observedSamplesSize := 0
+	observer := make(backend.TrialSampleObserver)
+	go func() {
+		defer close(observer)
+		assert.NoError(t, b.ObserveSamples(context.Background(), filter, observer))
+	}()
+	for sample := range observer {
+		observedSamplesSize += proto.Size(sample)
+	}
+	// Samples being alike, the extrapolation is close to the actual size
+	assert.Equal(t, len(samples), estimate.SamplesCount)
+	assert.InEpsilon(t, observedSamplesSize, estimate.SamplesSize, 0.05)
+}
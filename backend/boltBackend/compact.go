@@ -0,0 +1,78 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boltBackend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// compactionTxMaxSize is the maximum size of the transactions used to copy the file content during a compaction
+const compactionTxMaxSize = 64 * 1024 * 1024
+
+// CompactFile rewrites the bolt-managed file at the given path to reclaim the disk space freed by deleted trials.
+//
+// Bolt reuses the pages freed by deletions but never shrinks its file, the compaction copies the live content to a
+// new file that then replaces the original one. The file must not be opened by a backend while being compacted.
+// It returns the file sizes before and after the compaction.
+func CompactFile(filePath string) (int64, int64, error) {
+	srcDb, err := bolt.Open(filePath, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer srcDb.Close()
+
+	srcInfo, err := os.Stat(filePath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Writing the compacted file in the same directory for the final rename to be atomic
+	dstFile, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".compact-*")
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to create the compacted file (%w)", err)
+	}
+	dstPath := dstFile.Name()
+	dstFile.Close()
+	defer os.Remove(dstPath)
+
+	dstDb, err := bolt.Open(dstPath, srcInfo.Mode(), &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, 0, err
+	}
+	err = bolt.Compact(dstDb, srcDb, compactionTxMaxSize)
+	if closeErr := dstDb.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to compact %q (%w)", filePath, err)
+	}
+
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	srcDb.Close()
+	err = os.Rename(dstPath, filePath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to replace %q by its compacted version (%w)", filePath, err)
+	}
+
+	return srcInfo.Size(), dstInfo.Size(), nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boltBackend
+
+import (
+	"fmt"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+)
+
+// BackendName is the name under which the bolt backend is registered
+const BackendName = "bolt"
+
+func init() {
+	backend.Register(BackendName, createBoltBackendFromFactoryOptions)
+}
+
+// createBoltBackendFromFactoryOptions creates a bolt backend storing its data in the file defined by the
+// `FILE_STORAGE_PATH` setting
+func createBoltBackendFromFactoryOptions(factoryOptions backend.FactoryOptions) (backend.Backend, error) {
+	if factoryOptions.Settings == nil || factoryOptions.Settings.GetString("FILE_STORAGE_PATH") == "" {
+		return nil, fmt.Errorf("the %q backend requires a file path, defined by the `FILE_STORAGE_PATH` setting", BackendName)
+	}
+	filePath := factoryOptions.Settings.GetString("FILE_STORAGE_PATH")
+	return CreateBoltBackendWithOptions(filePath, Options{
+		DeterministicSerialization: factoryOptions.DeterministicSerialization,
+		PayloadCompression:         factoryOptions.PayloadCompression,
+		SerializationPooling:       factoryOptions.SerializationPooling,
+		SampleChecksums:            factoryOptions.SampleChecksums,
+		VerifySampleChecksums:      factoryOptions.VerifySampleChecksums,
+	})
+}
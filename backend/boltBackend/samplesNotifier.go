@@ -0,0 +1,84 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boltBackend
+
+import "sync"
+
+// samplesSubscription is signaled when the samples of a trial are updated, signals are coalesced, a subscriber only
+// needs to know that it has to read the trial samples again
+type samplesSubscription chan struct{}
+
+// samplesNotifier keeps track, per trial, of the observations waiting for new samples
+type samplesNotifier struct {
+	mutex         sync.Mutex
+	subscriptions map[string]map[*samplesSubscription]struct{}
+}
+
+func createSamplesNotifier() *samplesNotifier {
+	return &samplesNotifier{
+		subscriptions: make(map[string]map[*samplesSubscription]struct{}),
+	}
+}
+
+// subscribe registers a subscription to the updates of the samples of a trial, it must be unsubscribed once the
+// observation ends
+func (n *samplesNotifier) subscribe(trialID string) *samplesSubscription {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	subscription := make(samplesSubscription, 1)
+	trialSubscriptions, ok := n.subscriptions[trialID]
+	if !ok {
+		trialSubscriptions = make(map[*samplesSubscription]struct{})
+		n.subscriptions[trialID] = trialSubscriptions
+	}
+	trialSubscriptions[&subscription] = struct{}{}
+	return &subscription
+}
+
+func (n *samplesNotifier) unsubscribe(trialID string, subscription *samplesSubscription) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	trialSubscriptions := n.subscriptions[trialID]
+	delete(trialSubscriptions, subscription)
+	if len(trialSubscriptions) == 0 {
+		delete(n.subscriptions, trialID)
+	}
+}
+
+// notify signals the subscriptions of the given trials without blocking, a subscription already having a pending
+// signal will read the update anyway
+func (n *samplesNotifier) notify(trialIDs ...string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, trialID := range trialIDs {
+		for subscription := range n.subscriptions[trialID] {
+			select {
+			case *subscription <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// subscriptionsCount returns the number of active subscriptions, for all the trials
+func (n *samplesNotifier) subscriptionsCount() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	count := 0
+	for _, trialSubscriptions := range n.subscriptions {
+		count += len(trialSubscriptions)
+	}
+	return count
+}
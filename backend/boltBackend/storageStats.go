@@ -0,0 +1,174 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boltBackend
+
+import (
+	"context"
+	"os"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket structure is
+//	stats	> trials_count	> {trials count}
+//				> samples_count	> {samples count}
+//				> samples_size	> {cumulated size of the serialized stored samples}
+
+var statsBucketName = []byte("stats")
+
+var trialsCountKey = []byte("trials_count")
+
+var samplesCountKey = []byte("samples_count")
+
+func getStatsBucket(tx *bolt.Tx) *bolt.Bucket {
+	statsBucket := tx.Bucket(statsBucketName)
+	if statsBucket == nil {
+		log.Fatal("stats bucket doesn't exist")
+	}
+	return statsBucket
+}
+
+// storageCounters represents the counters, stored in the stats bucket, updated along with the trials and samples
+type storageCounters struct {
+	trialsCount  int64
+	samplesCount int64
+	samplesSize  int64
+}
+
+func getStorageCounters(statsBucket *bolt.Bucket) (storageCounters, error) {
+	counters := storageCounters{}
+	for key, counter := range map[string]*int64{
+		string(trialsCountKey):  &counters.trialsCount,
+		string(samplesCountKey): &counters.samplesCount,
+		string(samplesSizeKey):  &counters.samplesSize,
+	} {
+		counterV := statsBucket.Get([]byte(key))
+		if counterV == nil {
+			continue
+		}
+		value, err := deserializeNumID(counterV)
+		if err != nil {
+			return storageCounters{}, err
+		}
+		*counter = int64(value)
+	}
+	return counters, nil
+}
+
+func putStorageCounters(statsBucket *bolt.Bucket, counters storageCounters) error {
+	for key, counter := range map[string]int64{
+		string(trialsCountKey):  counters.trialsCount,
+		string(samplesCountKey): counters.samplesCount,
+		string(samplesSizeKey):  counters.samplesSize,
+	} {
+		if counter < 0 {
+			// Can only happen if the file was modified without maintaining the counters, `Reindex` recounts them
+			counter = 0
+		}
+		err := statsBucket.Put([]byte(key), serializeNumID(uint64(counter)))
+		if err != nil {
+			return backend.NewUnexpectedError("unable to update the storage stats (%w)", err)
+		}
+	}
+	return nil
+}
+
+// updateStorageCounters adds the given deltas to the storage counters
+func updateStorageCounters(tx *bolt.Tx, delta storageCounters) error {
+	statsBucket := getStatsBucket(tx)
+	counters, err := getStorageCounters(statsBucket)
+	if err != nil {
+		return err
+	}
+	counters.trialsCount += delta.trialsCount
+	counters.samplesCount += delta.samplesCount
+	counters.samplesSize += delta.samplesSize
+	return putStorageCounters(statsBucket, counters)
+}
+
+// countTrialSamples computes the storage counters of the samples of a trial, i.e. without counting the trial itself
+func countTrialSamples(trialBucket *bolt.Bucket) (storageCounters, error) {
+	samplesBucket := trialBucket.Bucket(samplesBucketName)
+	if samplesBucket == nil {
+		return storageCounters{}, nil
+	}
+	samplesSize, err := getSamplesSize(trialBucket)
+	if err != nil {
+		return storageCounters{}, err
+	}
+	return storageCounters{
+		samplesCount: int64(samplesBucket.Stats().KeyN),
+		samplesSize:  int64(samplesSize),
+	}, nil
+}
+
+// recountStorageCounters computes the storage counters from every stored trial and stores them, e.g. for files
+// created before the counters were maintained
+func recountStorageCounters(tx *bolt.Tx) error {
+	trialsBucket := getTrialsBucket(tx)
+	counters := storageCounters{}
+	err := trialsBucket.ForEach(func(trialIDKey []byte, _ []byte) error {
+		trialBucket := trialsBucket.Bucket(trialIDKey)
+		if trialBucket == nil {
+			return nil
+		}
+		trialCounters, err := countTrialSamples(trialBucket)
+		if err != nil {
+			return err
+		}
+		counters.trialsCount++
+		counters.samplesCount += trialCounters.samplesCount
+		counters.samplesSize += trialCounters.samplesSize
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return putStorageCounters(getStatsBucket(tx), counters)
+}
+
+func (b *boltBackend) StorageStats(ctx context.Context) (backend.StorageStats, error) {
+	if err := ctx.Err(); err != nil {
+		return backend.StorageStats{}, err
+	}
+	counters := storageCounters{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		counters, err = getStorageCounters(getStatsBucket(tx))
+		return err
+	})
+	if err != nil {
+		return backend.StorageStats{}, err
+	}
+	fileInfo, err := os.Stat(b.filePath)
+	if err != nil {
+		return backend.StorageStats{}, backend.NewUnexpectedError("unable to retrieve the size of the storage file (%w)", err)
+	}
+	freeSpace, err := freeSpace(b.filePath)
+	if err != nil {
+		return backend.StorageStats{}, backend.NewUnexpectedError("unable to retrieve the free space of the storage file system (%w)", err)
+	}
+	return backend.StorageStats{
+		BackendType:  BackendName,
+		TrialsCount:  int(counters.trialsCount),
+		SamplesCount: int(counters.samplesCount),
+		SamplesSize:  int(counters.samplesSize),
+		Persistent:   true,
+		FileSize:     fileInfo.Size(),
+		FreeSpace:    freeSpace,
+	}, nil
+}
@@ -0,0 +1,500 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cachedBackend
+
+import (
+	"context"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/utils"
+)
+
+// populationBatchSize is the number of samples copied at once from the persistent backend to the cache
+const populationBatchSize = 100
+
+// cachedBackend serves the reads of recent trials from a bounded cache backend, e.g. a memory backend, while every
+// write goes through to a persistent backend, e.g. a bolt backend.
+//
+// The persistent backend is authoritative: the trials info is always retrieved from it and a failure to write to the
+// cache only invalidates the cached trials. The samples of a trial are read from the cache when the trial is cached,
+// otherwise they are read from the persistent backend and, once the trial has ended, copied to the cache.
+type cachedBackend struct {
+	persistent backend.Backend
+	cache      backend.Backend
+	// Reads and writes lock their trials for reading, to run concurrently, while copying a trial to the cache locks it
+	// so that the trial isn't written, nor its cached samples read, during the copy. Other trials aren't affected.
+	populationLocks *utils.TrialLocks
+	// Writes lock their trials so that the writes of a trial are applied in the same order to both backends
+	trialLocks *utils.TrialLocks
+}
+
+// CreateCachedBackend creates a Backend writing through to `persistent` and serving the reads of the recent trials
+// from `cache`.
+//
+// The returned backend owns the given ones, destroying it destroys both of them.
+func CreateCachedBackend(persistent backend.Backend, cache backend.Backend) backend.Backend {
+	return &cachedBackend{
+		persistent:      persistent,
+		cache:           cache,
+		populationLocks: utils.CreateTrialLocks(),
+		trialLocks:      utils.CreateTrialLocks(),
+	}
+}
+
+func (b *cachedBackend) Destroy() {
+	b.cache.Destroy()
+	b.persistent.Destroy()
+}
+
+// cachedTrialIDs returns the given trial ids that are cached
+func (b *cachedBackend) cachedTrialIDs(ctx context.Context, trialIDs []string) ([]string, error) {
+	exist, err := b.cache.TrialsExist(ctx, trialIDs)
+	if err != nil {
+		return nil, err
+	}
+	cachedTrialIDs := []string{}
+	for idx, trialID := range trialIDs {
+		if exist[idx] {
+			cachedTrialIDs = append(cachedTrialIDs, trialID)
+		}
+	}
+	return cachedTrialIDs, nil
+}
+
+// invalidate removes trials from the cache after a failed write, their reads are then served by the persistent backend
+func (b *cachedBackend) invalidate(ctx context.Context, trialIDs []string, cause error) {
+	logger := log.WithFields(log.Fields{"operation": "invalidate_cache", "trial_ids": trialIDs})
+	logger.WithError(cause).Warn("Unable to write to the cache, invalidating the cached trials")
+	cachedTrialIDs, err := b.cachedTrialIDs(ctx, trialIDs)
+	if err == nil && len(cachedTrialIDs) > 0 {
+		err = b.cache.DeleteTrials(ctx, cachedTrialIDs)
+	}
+	if err != nil {
+		logger.WithError(err).Error("Unable to invalidate the cached trials")
+	}
+}
+
+func (b *cachedBackend) CreateOrUpdateTrials(ctx context.Context, trialsParams []*backend.TrialParams) error {
+	trialIDs := make([]string, len(trialsParams))
+	for idx, trialParams := range trialsParams {
+		trialIDs[idx] = trialParams.TrialID
+	}
+	defer b.populationLocks.RLock(trialIDs...)()
+	defer b.trialLocks.Lock(trialIDs...)()
+	err := b.persistent.CreateOrUpdateTrials(ctx, trialsParams)
+	if err != nil {
+		return err
+	}
+	// Like `writeCachedTrials`, the cache is written regardless of the cancellation of the operation
+	err = b.cache.CreateOrUpdateTrials(context.Background(), trialsParams)
+	if err != nil {
+		b.invalidate(context.Background(), trialIDs, err)
+	}
+	return nil
+}
+
+func (b *cachedBackend) RetrieveTrials(ctx context.Context, filter []string, fromTrialIdx int, count int) (backend.TrialsInfoResult, error) {
+	return b.persistent.RetrieveTrials(ctx, filter, fromTrialIdx, count)
+}
+
+func (b *cachedBackend) ObserveTrials(ctx context.Context, filter []string, fromTrialIdx int, count int, out chan<- backend.TrialsInfoResult) error {
+	return b.persistent.ObserveTrials(ctx, filter, fromTrialIdx, count, out)
+}
+
+func (b *cachedBackend) DeleteTrials(ctx context.Context, trialIDs []string) error {
+	defer b.populationLocks.RLock(trialIDs...)()
+	defer b.trialLocks.Lock(trialIDs...)()
+	err := b.persistent.DeleteTrials(ctx, trialIDs)
+	if err != nil {
+		return err
+	}
+	// Deleted trials mustn't be served from the cache, failing to delete them is an error. Like `writeCachedTrials`,
+	// the cache is written regardless of the cancellation of the operation.
+	cachedTrialIDs, err := b.cachedTrialIDs(context.Background(), trialIDs)
+	if err != nil {
+		return err
+	}
+	if len(cachedTrialIDs) == 0 {
+		return nil
+	}
+	return b.cache.DeleteTrials(context.Background(), cachedTrialIDs)
+}
+
+func (b *cachedBackend) TrialsExist(ctx context.Context, trialIDs []string) ([]bool, error) {
+	return b.persistent.TrialsExist(ctx, trialIDs)
+}
+
+func (b *cachedBackend) GetTrialParams(ctx context.Context, trialIDs []string) ([]*backend.TrialParams, error) {
+	trialsParams, err := b.cache.GetTrialParams(ctx, trialIDs)
+	if err == nil {
+		return trialsParams, nil
+	}
+	return b.persistent.GetTrialParams(ctx, trialIDs)
+}
+
+// samplesTrialIDs returns the distinct trial ids of the given samples
+func samplesTrialIDs(samples []*grpcapi.StoredTrialSample) []string {
+	trialIDs := []string{}
+	seenTrialIDs := make(map[string]struct{})
+	for _, sample := range samples {
+		if _, seen := seenTrialIDs[sample.TrialId]; !seen {
+			seenTrialIDs[sample.TrialId] = struct{}{}
+			trialIDs = append(trialIDs, sample.TrialId)
+		}
+	}
+	return trialIDs
+}
+
+func (b *cachedBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error {
+	trialIDs := samplesTrialIDs(samples)
+	defer b.populationLocks.RLock(trialIDs...)()
+	defer b.trialLocks.Lock(trialIDs...)()
+	err := b.persistent.AddSamples(ctx, samples)
+	if err != nil {
+		return err
+	}
+	return b.writeCachedTrials(trialIDs, func(ctx context.Context, cachedTrialIDs []string) error {
+		isCached := make(map[string]bool)
+		for _, trialID := range cachedTrialIDs {
+			isCached[trialID] = true
+		}
+		cachedSamples := make([]*grpcapi.StoredTrialSample, 0, len(samples))
+		for _, sample := range samples {
+			if isCached[sample.TrialId] {
+				cachedSamples = append(cachedSamples, sample)
+			}
+		}
+		return b.cache.AddSamples(ctx, cachedSamples)
+	})
+}
+
+func (b *cachedBackend) AddSamplePartial(ctx context.Context, sample *grpcapi.StoredTrialSample) error {
+	defer b.populationLocks.RLock(sample.TrialId)()
+	defer b.trialLocks.Lock(sample.TrialId)()
+	err := b.persistent.AddSamplePartial(ctx, sample)
+	if err != nil {
+		return err
+	}
+	return b.writeCachedTrials([]string{sample.TrialId}, func(ctx context.Context, cachedTrialIDs []string) error {
+		return b.cache.AddSamplePartial(ctx, sample)
+	})
+}
+
+func (b *cachedBackend) ClearSamples(ctx context.Context, trialID string) error {
+	defer b.populationLocks.RLock(trialID)()
+	defer b.trialLocks.Lock(trialID)()
+	err := b.persistent.ClearSamples(ctx, trialID)
+	if err != nil {
+		return err
+	}
+	return b.writeCachedTrials([]string{trialID}, func(ctx context.Context, cachedTrialIDs []string) error {
+		return b.cache.ClearSamples(ctx, trialID)
+	})
+}
+
+func (b *cachedBackend) EndTrials(ctx context.Context, trialIDs []string) error {
+	defer b.populationLocks.RLock(trialIDs...)()
+	defer b.trialLocks.Lock(trialIDs...)()
+	err := b.persistent.EndTrials(ctx, trialIDs)
+	if err != nil {
+		return err
+	}
+	return b.writeCachedTrials(trialIDs, func(ctx context.Context, cachedTrialIDs []string) error {
+		return b.cache.EndTrials(ctx, cachedTrialIDs)
+	})
+}
+
+func (b *cachedBackend) AbandonTrials(ctx context.Context, trialIDs []string) error {
+	defer b.populationLocks.RLock(trialIDs...)()
+	defer b.trialLocks.Lock(trialIDs...)()
+	err := b.persistent.AbandonTrials(ctx, trialIDs)
+	if err != nil {
+		return err
+	}
+	return b.writeCachedTrials(trialIDs, func(ctx context.Context, cachedTrialIDs []string) error {
+		return b.cache.AbandonTrials(ctx, cachedTrialIDs)
+	})
+}
+
+// SetTrialResult only writes to the persistent backend, trial results are read from it like the trials info
+func (b *cachedBackend) SetTrialResult(ctx context.Context, trialID string, result *backend.TrialResult) error {
+	return b.persistent.SetTrialResult(ctx, trialID, result)
+}
+
+func (b *cachedBackend) GetTrialResult(ctx context.Context, trialID string) (*backend.TrialResult, error) {
+	return b.persistent.GetTrialResult(ctx, trialID)
+}
+
+// writeCachedTrials calls `write` with the given trials that are cached, if any, invalidating them when it fails.
+//
+// It is called once the persistent backend is written, the cache is then written regardless of the cancellation of
+// the operation for it not to become stale.
+func (b *cachedBackend) writeCachedTrials(trialIDs []string, write func(ctx context.Context, cachedTrialIDs []string) error) error {
+	ctx := context.Background()
+	cachedTrialIDs, err := b.cachedTrialIDs(ctx, trialIDs)
+	if err == nil && len(cachedTrialIDs) > 0 {
+		err = write(ctx, cachedTrialIDs)
+	}
+	if err != nil {
+		b.invalidate(ctx, trialIDs, err)
+	}
+	return nil
+}
+
+// ObserveSamples serves the samples from the cache when every selected trial is cached.
+//
+// When the cache evicted some of the selected samples before any sample was sent, the persistent backend takes over.
+// Once samples were sent the `EvictedSamplesError` is returned, the evicted trial being copied back to the cache for
+// the following reads, e.g. a retrieval resumed using its continuation token.
+func (b *cachedBackend) ObserveSamples(ctx context.Context, filter backend.TrialSampleFilter, out chan<- *grpcapi.StoredTrialSample) error {
+	if len(filter.TrialIDs) == 0 {
+		return b.persistent.ObserveSamples(ctx, filter, out)
+	}
+	sentSamplesCount, err := b.observeCachedSamples(ctx, filter, out)
+	if errors.Is(err, errNotCached) {
+		// Copying the ended trials to the cache for the following reads
+		err := b.populate(ctx, filter.TrialIDs)
+		if err != nil {
+			return err
+		}
+		return b.persistent.ObserveSamples(ctx, filter, out)
+	}
+	var evictedSamplesErr *backend.EvictedSamplesError
+	if errors.As(err, &evictedSamplesErr) {
+		// The cache evicted some of the selected samples, copying them back to the cache for the following reads
+		err := b.populate(ctx, []string{evictedSamplesErr.TrialID})
+		if err != nil {
+			return err
+		}
+		if sentSamplesCount == 0 {
+			return b.persistent.ObserveSamples(ctx, filter, out)
+		}
+	}
+	return err
+}
+
+// errNotCached is returned by `observeCachedSamples` when some of the selected trials aren't cached
+var errNotCached = errors.New("trials not cached")
+
+// observeCachedSamples is `ObserveSamples` served by the cache, it returns the number of sent samples.
+//
+// The selected trials are locked for reading during the whole observation, the trials are then written concurrently
+// but not copied to the cache, which would end the observation early.
+func (b *cachedBackend) observeCachedSamples(ctx context.Context, filter backend.TrialSampleFilter, out chan<- *grpcapi.StoredTrialSample) (int, error) {
+	defer b.populationLocks.RLock(filter.TrialIDs...)()
+	cachedTrialIDs, err := b.cachedTrialIDs(ctx, filter.TrialIDs)
+	if err != nil {
+		return 0, err
+	}
+	if len(cachedTrialIDs) < len(filter.TrialIDs) {
+		return 0, errNotCached
+	}
+
+	// Counting the samples sent by the cache to know if the persistent backend can take over
+	cacheOut := make(chan *grpcapi.StoredTrialSample)
+	sentSamplesCount := 0
+	forwardDone := make(chan struct{})
+	go func() {
+		defer close(forwardDone)
+		for sample := range cacheOut {
+			select {
+			case out <- sample:
+				sentSamplesCount++
+			case <-ctx.Done():
+				// Draining the remaining samples
+			}
+		}
+	}()
+	err = b.cache.ObserveSamples(ctx, filter, cacheOut)
+	close(cacheOut)
+	<-forwardDone
+	return sentSamplesCount, err
+}
+
+func (b *cachedBackend) RetrieveLatestSample(ctx context.Context, trialID string, filter backend.TrialSampleFilter) (*grpcapi.StoredTrialSample, error) {
+	// Not reading a trial while it is copied to the cache
+	defer b.populationLocks.RLock(trialID)()
+	cachedTrialIDs, err := b.cachedTrialIDs(ctx, []string{trialID})
+	if err != nil {
+		return nil, err
+	}
+	if len(cachedTrialIDs) == 1 {
+		sample, err := b.cache.RetrieveLatestSample(ctx, trialID, filter)
+		if err == nil {
+			return sample, nil
+		}
+		// e.g. the cache evicted the trial samples
+	}
+	return b.persistent.RetrieveLatestSample(ctx, trialID, filter)
+}
+
+func (b *cachedBackend) RetrieveSamplePayload(ctx context.Context, trialID string, tickID uint64, payloadIdx uint32) ([]byte, error) {
+	// Not reading a trial while it is copied to the cache
+	defer b.populationLocks.RLock(trialID)()
+	cachedTrialIDs, err := b.cachedTrialIDs(ctx, []string{trialID})
+	if err != nil {
+		return nil, err
+	}
+	if len(cachedTrialIDs) == 1 {
+		payload, err := b.cache.RetrieveSamplePayload(ctx, trialID, tickID, payloadIdx)
+		if err == nil {
+			return payload, nil
+		}
+		// e.g. the cache evicted the trial samples
+	}
+	return b.persistent.RetrieveSamplePayload(ctx, trialID, tickID, payloadIdx)
+}
+
+// EstimateSamples uses the cache, whose estimates are exact, when every selected trial is cached
+func (b *cachedBackend) EstimateSamples(ctx context.Context, filter backend.TrialSampleFilter) (backend.SamplesEstimate, error) {
+	if len(filter.TrialIDs) == 0 {
+		return b.persistent.EstimateSamples(ctx, filter)
+	}
+	// Not reading a trial while it is copied to the cache
+	defer b.populationLocks.RLock(filter.TrialIDs...)()
+	cachedTrialIDs, err := b.cachedTrialIDs(ctx, filter.TrialIDs)
+	if err != nil {
+		return backend.SamplesEstimate{}, err
+	}
+	if len(cachedTrialIDs) == len(filter.TrialIDs) {
+		estimate, err := b.cache.EstimateSamples(ctx, filter)
+		if err == nil {
+			return estimate, nil
+		}
+		// e.g. the cache evicted some of the trials samples
+	}
+	return b.persistent.EstimateSamples(ctx, filter)
+}
+
+// populate copies the given ended trials to the cache, unless they are already cached with all their samples.
+//
+// Ongoing trials aren't copied, as well as trials whose samples are ordered by another key than the tick id, the
+// persistent backend observing them in that order.
+func (b *cachedBackend) populate(ctx context.Context, trialIDs []string) error {
+	trialsInfo, err := b.persistent.RetrieveTrials(ctx, trialIDs, -1, -1)
+	if err != nil {
+		return err
+	}
+	for _, trialInfo := range trialsInfo.TrialInfos {
+		if trialInfo.State != grpcapi.TrialState_ENDED {
+			continue
+		}
+		err := b.populateEndedTrial(ctx, trialInfo.TrialID)
+		if err != nil {
+			// The trial reads keep being served by the persistent backend, a partial copy, e.g. when the operation is
+			// canceled, is removed regardless
+			b.invalidate(context.Background(), []string{trialInfo.TrialID}, err)
+		}
+	}
+	return nil
+}
+
+// populateEndedTrial locks a trial and copies it to the cache if it is still ended, only this trial's reads and writes
+// wait for the copy
+func (b *cachedBackend) populateEndedTrial(ctx context.Context, trialID string) error {
+	defer b.populationLocks.Lock(trialID)()
+	// The trial could have been written since it was retrieved
+	trialsInfo, err := b.persistent.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+	if err != nil {
+		return err
+	}
+	if len(trialsInfo.TrialInfos) == 0 || trialsInfo.TrialInfos[0].State != grpcapi.TrialState_ENDED {
+		return nil
+	}
+	return b.populateTrial(ctx, trialsInfo.TrialInfos[0])
+}
+
+// populateTrial copies an ended trial to the cache, the trial should be locked in `populationLocks`
+func (b *cachedBackend) populateTrial(ctx context.Context, trialInfo *backend.TrialInfo) error {
+	trialsParams, err := b.persistent.GetTrialParams(ctx, []string{trialInfo.TrialID})
+	if err != nil {
+		return err
+	}
+	if !trialsParams[0].SampleOrderingKey.IsTickID() {
+		return nil
+	}
+
+	cachedTrialsInfo, err := b.cache.RetrieveTrials(ctx, []string{trialInfo.TrialID}, -1, -1)
+	if err != nil {
+		return err
+	}
+	if len(cachedTrialsInfo.TrialInfos) == 0 {
+		err = b.cache.CreateOrUpdateTrials(ctx, trialsParams)
+	} else if cachedTrialsInfo.TrialInfos[0].StoredSamplesCount != trialInfo.StoredSamplesCount {
+		// Some samples were evicted from the cache, copying the trial samples again
+		err = b.cache.ClearSamples(ctx, trialInfo.TrialID)
+	} else {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if trialInfo.StoredSamplesCount > 0 {
+		persistentOut := make(chan *grpcapi.StoredTrialSample)
+		observeErr := make(chan error, 1)
+		go func() {
+			defer close(persistentOut)
+			observeErr <- b.persistent.ObserveSamples(ctx, backend.TrialSampleFilter{TrialIDs: []string{trialInfo.TrialID}}, persistentOut)
+		}()
+		batch := make([]*grpcapi.StoredTrialSample, 0, populationBatchSize)
+		var addErr error
+		for sample := range persistentOut {
+			if addErr != nil {
+				continue
+			}
+			batch = append(batch, sample)
+			if len(batch) == populationBatchSize {
+				addErr = b.cache.AddSamples(ctx, batch)
+				batch = make([]*grpcapi.StoredTrialSample, 0, populationBatchSize)
+			}
+		}
+		if err := <-observeErr; err != nil {
+			return err
+		}
+		if addErr != nil {
+			return addErr
+		}
+		if len(batch) > 0 {
+			err := b.cache.AddSamples(ctx, batch)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return b.cache.EndTrials(ctx, []string{trialInfo.TrialID})
+}
+
+func (b *cachedBackend) Reindex(ctx context.Context) error {
+	err := b.persistent.Reindex(ctx)
+	if err != nil {
+		return err
+	}
+	return b.cache.Reindex(ctx)
+}
+
+// StorageStats retrieves the storage usage of the persistent backend, the cached trials are also stored by it
+func (b *cachedBackend) StorageStats(ctx context.Context) (backend.StorageStats, error) {
+	stats, err := b.persistent.StorageStats(ctx)
+	if err != nil {
+		return backend.StorageStats{}, err
+	}
+	stats.BackendType = BackendName
+	return stats, nil
+}
@@ -0,0 +1,314 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cachedBackend
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/boltBackend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	"github.com/cogment/cogment-trial-datastore/backend/test"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// countingBackend counts the samples observations of the backend it wraps
+type countingBackend struct {
+	backend.Backend
+	observationsCount int32
+}
+
+func (b *countingBackend) ObserveSamples(ctx context.Context, filter backend.TrialSampleFilter, out chan<- *grpcapi.StoredTrialSample) error {
+	atomic.AddInt32(&b.observationsCount, 1)
+	return b.Backend.ObserveSamples(ctx, filter, out)
+}
+
+type cachedBackendTestFixture struct {
+	filePath   string
+	persistent *countingBackend
+	cache      backend.Backend
+	backend    backend.Backend
+}
+
+func createCachedBackendTestFixture(t *testing.T, filePath string, maxCachedSamplesSize uint32) *cachedBackendTestFixture {
+	if filePath == "" {
+		f, err := os.CreateTemp("", "trial-datastore-cached-test")
+		assert.NoError(t, err)
+		f.Close()
+		filePath = f.Name()
+	}
+	persistent, err := boltBackend.CreateBoltBackendWithOptions(filePath, boltBackend.Options{DeterministicSerialization: true})
+	assert.NoError(t, err)
+	cacheOptions := memoryBackend.DefaultOptions
+	cacheOptions.MaxSamplesSize = maxCachedSamplesSize
+	cacheOptions.DeterministicSerialization = true
+	cache, err := memoryBackend.CreateMemoryBackendWithOptions(cacheOptions)
+	assert.NoError(t, err)
+	countingPersistent := &countingBackend{Backend: persistent}
+	return &cachedBackendTestFixture{
+		filePath:   filePath,
+		persistent: countingPersistent,
+		cache:      cache,
+		backend:    CreateCachedBackend(countingPersistent, cache),
+	}
+}
+
+func (fxt *cachedBackendTestFixture) persistentObservationsCount() int {
+	return int(atomic.LoadInt32(&fxt.persistent.observationsCount))
+}
+
+func observeSamples(t *testing.T, b backend.Backend, trialID string) []*grpcapi.StoredTrialSample {
+	observer := make(backend.TrialSampleObserver)
+	observeErr := make(chan error, 1)
+	go func() {
+		defer close(observer)
+		observeErr <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{trialID}}, observer)
+	}()
+	samples := []*grpcapi.StoredTrialSample{}
+	for sample := range observer {
+		samples = append(samples, sample)
+	}
+	assert.NoError(t, <-observeErr)
+	return samples
+}
+
+func addTrial(t *testing.T, b backend.Backend, trialID string, samplesCount int) {
+	err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{
+		TrialID: trialID,
+		UserID:  "my-user",
+		Params: &grpcapi.TrialParams{
+			Actors:   []*grpcapi.ActorParams{{Name: "player", ActorClass: "pl"}},
+			MaxSteps: uint32(samplesCount),
+		},
+	}})
+	assert.NoError(t, err)
+	for tickID := 0; tickID < samplesCount; tickID++ {
+		sample := &grpcapi.StoredTrialSample{
+			TrialId:      trialID,
+			TickId:       uint64(tickID),
+			Timestamp:    uint64(1000 + tickID),
+			State:        grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Observation: proto.Uint32(0)}},
+			Payloads:     [][]byte{[]byte("an observation of the player")},
+		}
+		if tickID == samplesCount-1 {
+			sample.State = grpcapi.TrialState_ENDED
+		}
+		err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{sample})
+		assert.NoError(t, err)
+	}
+}
+
+// assertSameBytes checks that the given samples serialize to the exact same bytes
+func assertSameBytes(t *testing.T, expected []*grpcapi.StoredTrialSample, actual []*grpcapi.StoredTrialSample) {
+	assert.Len(t, actual, len(expected))
+	marshalOptions := proto.MarshalOptions{Deterministic: true}
+	for idx := range expected {
+		expectedBytes, err := marshalOptions.Marshal(expected[idx])
+		assert.NoError(t, err)
+		actualBytes, err := marshalOptions.Marshal(actual[idx])
+		assert.NoError(t, err)
+		assert.Equal(t, expectedBytes, actualBytes)
+	}
+}
+
+func TestSuiteCachedBackend(t *testing.T) {
+	filePaths := make(map[backend.Backend]string)
+	test.RunSuite(t, func() backend.Backend {
+		fxt := createCachedBackendTestFixture(t, "", memoryBackend.DefaultMaxSampleSize)
+		filePaths[fxt.backend] = fxt.filePath
+		return fxt.backend
+	}, func(b backend.Backend) {
+		defer os.Remove(filePaths[b])
+		defer b.Destroy()
+	})
+}
+
+func TestReadFromCache(t *testing.T) {
+	fxt := createCachedBackendTestFixture(t, "", memoryBackend.DefaultMaxSampleSize)
+	defer os.Remove(fxt.filePath)
+	defer fxt.backend.Destroy()
+
+	addTrial(t, fxt.backend, "my-trial", 50)
+
+	samples := observeSamples(t, fxt.backend, "my-trial")
+	assert.Equal(t, 0, fxt.persistentObservationsCount())
+
+	// The samples served by the cache are the persisted ones
+	assertSameBytes(t, observeSamples(t, fxt.persistent.Backend, "my-trial"), samples)
+
+	trialsParams, err := fxt.backend.GetTrialParams(context.Background(), []string{"my-trial"})
+	assert.NoError(t, err)
+	persistedTrialsParams, err := fxt.persistent.GetTrialParams(context.Background(), []string{"my-trial"})
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(persistedTrialsParams[0].Params, trialsParams[0].Params))
+	assert.Equal(t, persistedTrialsParams[0].UserID, trialsParams[0].UserID)
+}
+
+func TestPopulateCache(t *testing.T) {
+	fxt := createCachedBackendTestFixture(t, "", memoryBackend.DefaultMaxSampleSize)
+	defer os.Remove(fxt.filePath)
+	addTrial(t, fxt.backend, "my-trial", 50)
+	fxt.backend.Destroy()
+
+	// After a restart, only the persistent backend holds the trial
+	fxt = createCachedBackendTestFixture(t, fxt.filePath, memoryBackend.DefaultMaxSampleSize)
+	defer fxt.backend.Destroy()
+	exists, err := backend.TrialExists(context.Background(), fxt.cache, "my-trial")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// The first read falls back to the persistent backend and populates the cache
+	samples := observeSamples(t, fxt.backend, "my-trial")
+	assert.Len(t, samples, 50)
+	observationsCount := fxt.persistentObservationsCount()
+	exists, err = backend.TrialExists(context.Background(), fxt.cache, "my-trial")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// The next ones are served from the cache
+	cachedSamples := observeSamples(t, fxt.backend, "my-trial")
+	assert.Equal(t, observationsCount, fxt.persistentObservationsCount())
+	assertSameBytes(t, samples, cachedSamples)
+	trialsInfo, err := fxt.cache.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, grpcapi.TrialState_ENDED, trialsInfo.TrialInfos[0].State)
+}
+
+func TestPopulateCacheAfterEviction(t *testing.T) {
+	fxt := createCachedBackendTestFixture(t, "", 1024)
+	defer os.Remove(fxt.filePath)
+	defer fxt.backend.Destroy()
+
+	addTrial(t, fxt.backend, "my-trial", 200)
+	assert.Eventually(t, func() bool {
+		trialsInfo, err := fxt.cache.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+		assert.NoError(t, err)
+		return trialsInfo.TrialInfos[0].StoredSamplesCount == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// The evicted samples are read from the persistent backend
+	samples := observeSamples(t, fxt.backend, "my-trial")
+	// Once to copy the trial back to the cache, once to serve the read
+	assert.Equal(t, 2, fxt.persistentObservationsCount())
+	assertSameBytes(t, observeSamples(t, fxt.persistent.Backend, "my-trial"), samples)
+}
+
+func TestDeleteTrialsFromBothTiers(t *testing.T) {
+	fxt := createCachedBackendTestFixture(t, "", memoryBackend.DefaultMaxSampleSize)
+	defer os.Remove(fxt.filePath)
+	defer fxt.backend.Destroy()
+
+	addTrial(t, fxt.backend, "my-trial", 10)
+	addTrial(t, fxt.persistent, "my-uncached-trial", 10)
+
+	err := fxt.backend.DeleteTrials(context.Background(), []string{"my-trial", "my-uncached-trial"})
+	assert.NoError(t, err)
+	for _, b := range []backend.Backend{fxt.persistent, fxt.cache, fxt.backend} {
+		exist, err := b.TrialsExist(context.Background(), []string{"my-trial", "my-uncached-trial"})
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{false, false}, exist)
+	}
+
+	// A trial recreated with the same id isn't served stale samples
+	err = fxt.backend.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial"}})
+	assert.NoError(t, err)
+	err = fxt.backend.EndTrials(context.Background(), []string{"my-trial"})
+	assert.NoError(t, err)
+	assert.Empty(t, observeSamples(t, fxt.backend, "my-trial"))
+}
+
+func TestCreateRegisteredBackend(t *testing.T) {
+	f, err := os.CreateTemp("", "trial-datastore-cached-test")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	settings := viper.New()
+	settings.Set("FILE_STORAGE_PATH", f.Name())
+	settings.Set("MEMORY_STORAGE_MAX_SAMPLE_SIZE", 1024)
+	b, err := backend.CreateBackend(BackendName, backend.FactoryOptions{Settings: settings})
+	assert.NoError(t, err)
+	defer b.Destroy()
+	assert.IsType(t, &cachedBackend{}, b)
+
+	// The persistent tier requires a file path
+	_, err = backend.CreateBackend(BackendName, backend.FactoryOptions{})
+	assert.Error(t, err)
+}
+
+// blockingBackend blocks the samples observations of a trial, of the backend it wraps, until `release` is closed
+type blockingBackend struct {
+	backend.Backend
+	blockedTrialID string
+	blocked        chan struct{}
+	release        chan struct{}
+}
+
+func (b *blockingBackend) ObserveSamples(ctx context.Context, filter backend.TrialSampleFilter, out chan<- *grpcapi.StoredTrialSample) error {
+	if len(filter.TrialIDs) == 1 && filter.TrialIDs[0] == b.blockedTrialID {
+		select {
+		case b.blocked <- struct{}{}:
+		default:
+		}
+		<-b.release
+	}
+	return b.Backend.ObserveSamples(ctx, filter, out)
+}
+
+func TestPopulateCacheDoesntBlockOtherTrials(t *testing.T) {
+	fxt := createCachedBackendTestFixture(t, "", memoryBackend.DefaultMaxSampleSize)
+	defer os.Remove(fxt.filePath)
+	blockingPersistent := &blockingBackend{
+		Backend:        fxt.persistent,
+		blockedTrialID: "my-uncached-trial",
+		blocked:        make(chan struct{}, 1),
+		release:        make(chan struct{}),
+	}
+	b := CreateCachedBackend(blockingPersistent, fxt.cache)
+	defer b.Destroy()
+
+	addTrial(t, fxt.persistent, "my-uncached-trial", 10)
+	addTrial(t, b, "my-ongoing-trial", 0)
+
+	observedSamples := make(chan []*grpcapi.StoredTrialSample)
+	go func() {
+		observedSamples <- observeSamples(t, b, "my-uncached-trial")
+	}()
+	// The uncached trial is being copied to the cache
+	<-blockingPersistent.blocked
+
+	added := make(chan error)
+	go func() {
+		added <- b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "my-ongoing-trial", TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	}()
+	select {
+	case err := <-added:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "adding samples to a trial waited for the copy of another trial to the cache")
+	}
+
+	close(blockingPersistent.release)
+	assert.Len(t, <-observedSamples, 10)
+}
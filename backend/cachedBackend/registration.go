@@ -0,0 +1,44 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cachedBackend
+
+import (
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/boltBackend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+)
+
+// BackendName is the name under which the cached backend is registered
+const BackendName = "cached"
+
+func init() {
+	backend.Register(BackendName, createCachedBackendFromFactoryOptions)
+}
+
+// createCachedBackendFromFactoryOptions creates a cached backend writing through to a bolt backend, configured by the
+// `FILE_STORAGE_PATH` setting, and caching the recent trials in a memory backend, configured by the `MEMORY_STORAGE_*`
+// settings
+func createCachedBackendFromFactoryOptions(factoryOptions backend.FactoryOptions) (backend.Backend, error) {
+	persistent, err := backend.CreateBackend(boltBackend.BackendName, factoryOptions)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := backend.CreateBackend(memoryBackend.BackendName, factoryOptions)
+	if err != nil {
+		persistent.Destroy()
+		return nil, err
+	}
+	return CreateCachedBackend(persistent, cache), nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sync"
+	"time"
+)
+
+// CreationClock provides the creation timestamps of trials.
+//
+// Timestamps are read from the wall clock, along with its monotonic reading, and are strictly increasing: when the
+// wall clock doesn't move forward, e.g. because it was set backward, the returned timestamp is one nanosecond after
+// the previous one. Trials are therefore listed in creation order whichever of their order or timestamp is used.
+type CreationClock struct {
+	mutex sync.Mutex
+	last  time.Time
+}
+
+// Now returns a creation timestamp, strictly after every timestamp previously returned or observed
+func (c *CreationClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	if !now.After(c.last) {
+		now = c.last.Add(time.Nanosecond)
+	}
+	c.last = now
+	return now
+}
+
+// Observe makes the clock aware of an existing creation timestamp, e.g. the one of a trial stored before a restart,
+// the following timestamps are after it
+func (c *CreationClock) Observe(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if t.After(c.last) {
+		c.last = t
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreationClock(t *testing.T) {
+	clock := CreationClock{}
+	previous := clock.Now()
+	for i := 0; i < 1000; i++ {
+		now := clock.Now()
+		assert.True(t, now.After(previous))
+		previous = now
+	}
+}
+
+func TestCreationClockObserve(t *testing.T) {
+	clock := CreationClock{}
+	future := time.Now().Add(time.Hour)
+	clock.Observe(future)
+	assert.Equal(t, future.Add(time.Nanosecond), clock.Now())
+
+	// Observing a timestamp in the past doesn't move the clock back
+	clock.Observe(time.Now().Add(-time.Hour))
+	assert.Equal(t, future.Add(2*time.Nanosecond), clock.Now())
+}
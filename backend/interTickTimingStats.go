@@ -0,0 +1,190 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"golang.org/x/sync/errgroup"
+)
+
+// InterTickTimingStats represents the distribution of the durations between the timestamps of consecutive samples
+type InterTickTimingStats struct {
+	IntervalsCount int
+	Min            time.Duration
+	Max            time.Duration
+	Mean           time.Duration
+	Percentiles    map[float64]time.Duration // Nearest-rank percentiles, keyed by the requested percentile in ]0, 100]
+}
+
+// ComputeInterTickTimingStats computes the inter-tick timing stats of the given samples.
+//
+// Samples are considered in tick order and their timestamps are expected in nanoseconds. Samples without timestamp
+// (i.e. zero) are ignored, as are intervals for which the timestamp goes backward. Equal timestamps result in a zero
+// duration interval.
+func ComputeInterTickTimingStats(samples []*grpcapi.StoredTrialSample, percentiles []float64) (*InterTickTimingStats, error) {
+	for _, percentile := range percentiles {
+		if percentile <= 0 || percentile > 100 {
+			return nil, fmt.Errorf("invalid percentile %v, expecting a value in ]0, 100]", percentile)
+		}
+	}
+
+	sortedSamples := make([]*grpcapi.StoredTrialSample, 0, len(samples))
+	for _, sample := range samples {
+		if sample.Timestamp != 0 {
+			sortedSamples = append(sortedSamples, sample)
+		}
+	}
+	sort.SliceStable(sortedSamples, func(i, j int) bool { return sortedSamples[i].TickId < sortedSamples[j].TickId })
+
+	intervals := make([]time.Duration, 0, len(sortedSamples))
+	for sampleIdx := 1; sampleIdx < len(sortedSamples); sampleIdx++ {
+		previousTimestamp := sortedSamples[sampleIdx-1].Timestamp
+		timestamp := sortedSamples[sampleIdx].Timestamp
+		if timestamp < previousTimestamp {
+			continue
+		}
+		intervals = append(intervals, time.Duration(timestamp-previousTimestamp))
+	}
+
+	stats := &InterTickTimingStats{
+		IntervalsCount: len(intervals),
+		Percentiles:    make(map[float64]time.Duration),
+	}
+	if len(intervals) == 0 {
+		return stats, nil
+	}
+
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	stats.Min = intervals[0]
+	stats.Max = intervals[len(intervals)-1]
+	total := 0.
+	for _, interval := range intervals {
+		total += float64(interval)
+	}
+	stats.Mean = time.Duration(total / float64(len(intervals)))
+	for _, percentile := range percentiles {
+		rank := int(math.Ceil(percentile / 100 * float64(len(intervals))))
+		stats.Percentiles[percentile] = intervals[rank-1]
+	}
+
+	return stats, nil
+}
+
+// RetrieveInterTickTimingStats computes the inter-tick timing stats of the currently stored samples of a trial
+func RetrieveInterTickTimingStats(ctx context.Context, b Backend, trialID string, percentiles []float64) (*InterTickTimingStats, error) {
+	samples, err := retrieveStoredSamples(ctx, b, trialID)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeInterTickTimingStats(samples, percentiles)
+}
+
+// retrieveStoredSamples retrieves the currently stored samples of a trial, without their payloads and without waiting
+// for the samples of an ongoing trial
+func retrieveStoredSamples(ctx context.Context, b Backend, trialID string) ([]*grpcapi.StoredTrialSample, error) {
+	trialsInfo, err := b.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(trialsInfo.TrialInfos) == 0 {
+		return nil, &UnknownTrialError{TrialID: trialID}
+	}
+	storedSamplesCount := trialsInfo.TrialInfos[0].StoredSamplesCount
+
+	samples := make([]*grpcapi.StoredTrialSample, 0, storedSamplesCount)
+	_, err = forEachStoredSample(
+		ctx,
+		b,
+		trialID,
+		storedSamplesCount,
+		// Only retrieving a field without payloads
+		[]grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_REWARD},
+		func(sample *grpcapi.StoredTrialSample) error {
+			samples = append(samples, sample)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// forEachStoredSample calls `fn` for, at most, the first `storedSamplesCount` observed samples of a trial, restricted
+// to the given fields, and returns the number of visited samples.
+//
+// It doesn't wait for the samples of an ongoing trial as long as `storedSamplesCount` samples are already stored.
+func forEachStoredSample(
+	ctx context.Context,
+	b Backend,
+	trialID string,
+	storedSamplesCount int,
+	fields []grpcapi.StoredTrialSampleField,
+	fn func(sample *grpcapi.StoredTrialSample) error,
+) (int, error) {
+	return forEachStoredFilteredSample(ctx, b, TrialSampleFilter{Fields: fields}, trialID, storedSamplesCount, fn)
+}
+
+// forEachStoredFilteredSample is `forEachStoredSample` observing the samples through the given filter, its trial ids
+// are ignored. The filter must not filter out samples altogether, e.g. using a tick range.
+func forEachStoredFilteredSample(
+	ctx context.Context,
+	b Backend,
+	filter TrialSampleFilter,
+	trialID string,
+	storedSamplesCount int,
+	fn func(sample *grpcapi.StoredTrialSample) error,
+) (int, error) {
+	if storedSamplesCount == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	visitedSamplesCount := 0
+	observer := make(TrialSampleObserver)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(observer)
+		filter.TrialIDs = []string{trialID}
+		return b.ObserveSamples(ctx, filter, observer)
+	})
+	g.Go(func() error {
+		for sample := range observer {
+			err := fn(sample)
+			if err != nil {
+				return err
+			}
+			visitedSamplesCount++
+			if visitedSamplesCount == storedSamplesCount {
+				cancel()
+				return nil
+			}
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+		return visitedSamplesCount, err
+	}
+	return visitedSamplesCount, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+	"time"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeInterTickTimingStats(t *testing.T) {
+	t0 := uint64(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC).UnixNano())
+	samples := []*grpcapi.StoredTrialSample{
+		{TickId: 0, Timestamp: t0},
+		{TickId: 2, Timestamp: t0 + uint64(300*time.Millisecond)},
+		{TickId: 1, Timestamp: t0 + uint64(100*time.Millisecond)},
+		{TickId: 3, Timestamp: t0 + uint64(300*time.Millisecond)},  // Same timestamp as the previous one
+		{TickId: 4, Timestamp: 0},                                  // No timestamp, ignored
+		{TickId: 5, Timestamp: t0 + uint64(5300*time.Millisecond)}, // Stall
+		{TickId: 6, Timestamp: t0 + uint64(5400*time.Millisecond)},
+	}
+
+	stats, err := ComputeInterTickTimingStats(samples, []float64{50, 90, 100})
+	assert.NoError(t, err)
+
+	// Intervals are 100ms, 200ms, 0ms, 5000ms and 100ms
+	assert.Equal(t, 5, stats.IntervalsCount)
+	assert.Equal(t, time.Duration(0), stats.Min)
+	assert.Equal(t, 5000*time.Millisecond, stats.Max)
+	assert.Equal(t, 1080*time.Millisecond, stats.Mean)
+	assert.Equal(t, 100*time.Millisecond, stats.Percentiles[50])
+	assert.Equal(t, 5000*time.Millisecond, stats.Percentiles[90])
+	assert.Equal(t, 5000*time.Millisecond, stats.Percentiles[100])
+}
+
+func TestComputeInterTickTimingStatsNotEnoughSamples(t *testing.T) {
+	stats, err := ComputeInterTickTimingStats([]*grpcapi.StoredTrialSample{{TickId: 0, Timestamp: 12}, {TickId: 1, Timestamp: 0}}, []float64{50})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.IntervalsCount)
+	assert.Len(t, stats.Percentiles, 0)
+}
+
+func TestComputeInterTickTimingStatsInvalidPercentile(t *testing.T) {
+	_, err := ComputeInterTickTimingStats([]*grpcapi.StoredTrialSample{}, []float64{0})
+	assert.Error(t, err)
+	_, err = ComputeInterTickTimingStats([]*grpcapi.StoredTrialSample{}, []float64{101})
+	assert.Error(t, err)
+}
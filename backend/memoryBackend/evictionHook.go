@@ -0,0 +1,126 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memoryBackend
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// EvictedTrial represents a trial about to be evicted from a memory backend
+type EvictedTrial struct {
+	TrialID     string
+	UserID      string
+	Params      *grpcapi.TrialParams
+	State       grpcapi.TrialState
+	Samples     []*grpcapi.StoredTrialSample
+	SamplesOnly bool // true when only the samples are evicted to reclaim memory, false when the whole trial is evicted
+}
+
+// EvictionHook is called before a trial is evicted, returning false vetoes the eviction.
+//
+// The hook is called with a snapshot of the trial while the backend isn't locked, should the trial change meanwhile
+// its eviction is reconsidered. Once the given context is done the eviction proceeds regardless of the hook.
+type EvictionHook func(ctx context.Context, trial *EvictedTrial) bool
+
+var DefaultEvictionHookTimeout = 5 * time.Second
+
+// evictionHookDecision is the outcome of running the eviction hook
+type evictionHookDecision int
+
+const (
+	evictionAllowed evictionHookDecision = iota
+	evictionVetoed
+	evictionStale // The trial changed while the hook was running, the eviction should be reconsidered
+)
+
+// runEvictionHook runs the eviction hook, if any, for the given trial and returns whether the eviction can proceed.
+//
+// `trialsMutex` should be locked, it is unlocked while the hook runs on a snapshot of the trial.
+func (b *memoryBackend) runEvictionHook(trialID string, data *trialData, samplesOnly bool) evictionHookDecision {
+	if b.evictionHook == nil {
+		return evictionAllowed
+	}
+
+	// Only referencing the stored samples, they are deserialized once the backend is unlocked
+	data.samplesMutex.Lock()
+	storedSamples := data.storedSamples
+	serializedSamples := make([][]byte, storedSamples.Len())
+	for sampleIdx := range serializedSamples {
+		serializedSample, _ := storedSamples.Item(sampleIdx)
+		serializedSamples[sampleIdx] = serializedSample.([]byte)
+	}
+	evictedTrial := &EvictedTrial{
+		TrialID:     trialID,
+		UserID:      data.userID,
+		Params:      data.params,
+		State:       data.trialState,
+		Samples:     make([]*grpcapi.StoredTrialSample, 0, len(serializedSamples)),
+		SamplesOnly: samplesOnly,
+	}
+	payloadBlobs := data.payloadBlobs
+	data.samplesMutex.Unlock()
+
+	b.trialsMutex.Unlock()
+	proceed := b.callEvictionHook(evictedTrial, payloadBlobs, serializedSamples)
+	b.trialsMutex.Lock()
+
+	data.samplesMutex.Lock()
+	defer data.samplesMutex.Unlock()
+	if b.trials[trialID] != data || data.deleted || data.storedSamples != storedSamples || storedSamples.Len() != len(serializedSamples) {
+		return evictionStale
+	}
+	if !proceed {
+		return evictionVetoed
+	}
+	return evictionAllowed
+}
+
+// callEvictionHook deserializes the samples of the evicted trial and calls the eviction hook with it, waiting at most
+// for the eviction hook timeout
+func (b *memoryBackend) callEvictionHook(evictedTrial *EvictedTrial, payloadBlobs *payloadBlobStore, serializedSamples [][]byte) bool {
+	logger := log.WithFields(log.Fields{"operation": "evict_trial", "trial_id": evictedTrial.TrialID})
+	for _, serializedSample := range serializedSamples {
+		sample, err := b.deserializeSample(payloadBlobs, serializedSample)
+		if err != nil {
+			logger.WithError(err).Error("Unable to deserialize a sample of the evicted trial")
+			continue
+		}
+		evictedTrial.Samples = append(evictedTrial.Samples, sample)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.evictionHookTimeout)
+	defer cancel()
+
+	resultChannel := make(chan bool, 1)
+	go func() {
+		resultChannel <- b.evictionHook(ctx, evictedTrial)
+	}()
+
+	select {
+	case <-ctx.Done():
+		logger.WithField("timeout_ms", b.evictionHookTimeout.Milliseconds()).Warn("Eviction hook timed out, evicting the trial")
+		return true
+	case proceed := <-resultChannel:
+		if !proceed {
+			logger.Debug("Eviction vetoed by the eviction hook")
+		}
+		return proceed
+	}
+}
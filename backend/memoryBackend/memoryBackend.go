@@ -18,6 +18,8 @@ import (
 	"container/list"
 	"context"
 	"errors"
+	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -34,22 +36,116 @@ import (
 type trialData struct {
 	params            *grpcapi.TrialParams
 	userID            string
+	sampleOrderingKey backend.SampleOrderingKey
+	tags              map[string]string
 	trialState        grpcapi.TrialState
 	samplesCount      int
 	storedSamplesSize uint32
 	storedSamples     utils.ObservableList
-	evListElement     *list.Element // Element corresponding to this trial in the eviction list, nil means the trial has be evicted
+	storedSamplesIdx  map[uint64]int // Index of the stored samples in `storedSamples` by tick id
+	minTickID         uint64         // Smallest tick id of the stored samples
+	maxTickID         uint64         // Largest tick id of the stored samples
+	unorderedTicks    bool           // Some stored samples have a smaller tick id than a sample stored before them
+	samplesMutex      sync.Mutex
+	evListElement     *list.Element     // Element corresponding to this trial in the eviction list, nil means the trial has be evicted since samples were last added to it
+	payloadBlobs      *payloadBlobStore // Distinct payloads of the stored samples, nil when payloads aren't deduplicated
+	hasEvictedSamples bool              // Some samples were evicted to bound the memory usage
+	evictedMinTickID  uint64            // Smallest tick id of the evicted samples
+	evictedMaxTickID  uint64            // Largest tick id of the evicted samples
+	result            *backend.TrialResult
+	abandoned         bool // Ended by `AbandonTrials`, no sample was added since
 	deleted           bool
+	createdAt         time.Time
+	trialIdx          int // Index of the trial in `trialIDs`, a trial registered again after its deletion is listed again
+}
+
+// corruptedSampleError identifies the corrupted sample at the given index of the trial's stored samples
+func (t *trialData) corruptedSampleError(trialID string, sampleIdx int) error {
+	t.samplesMutex.Lock()
+	defer t.samplesMutex.Unlock()
+	return identifyCorruptedSample(trialID, t.storedSamplesIdx, sampleIdx)
 }
 
 func createTrialInfo(trialID string, data *trialData) *backend.TrialInfo {
+	data.samplesMutex.Lock()
+	defer data.samplesMutex.Unlock()
 	return &backend.TrialInfo{
 		TrialID:            trialID,
 		State:              data.trialState,
 		UserID:             data.userID,
 		SamplesCount:       data.samplesCount,
 		StoredSamplesCount: data.storedSamples.Len(),
+		StoredSamplesSize:  int(data.storedSamplesSize),
+		MinTickID:          data.minTickID,
+		MaxTickID:          data.maxTickID,
+		CreatedAt:          data.createdAt,
+		Tags:               data.tags,
+		Abandoned:          data.abandoned,
+	}
+}
+
+// trialParams returns the params of the trial, `CreateOrUpdateTrials` can update them concurrently
+func (data *trialData) trialParams(trialID string) *backend.TrialParams {
+	data.samplesMutex.Lock()
+	defer data.samplesMutex.Unlock()
+	return &backend.TrialParams{
+		TrialID:           trialID,
+		UserID:            data.userID,
+		Params:            data.params,
+		SampleOrderingKey: data.sampleOrderingKey,
+		Tags:              data.tags,
+	}
+}
+
+// recordEvictedSamples records the tick range of the stored samples before they are evicted, `samplesMutex` should be locked
+func (data *trialData) recordEvictedSamples() {
+	if data.storedSamples.Len() == 0 {
+		return
+	}
+	if !data.hasEvictedSamples || data.minTickID < data.evictedMinTickID {
+		data.evictedMinTickID = data.minTickID
+	}
+	if !data.hasEvictedSamples || data.maxTickID > data.evictedMaxTickID {
+		data.evictedMaxTickID = data.maxTickID
+	}
+	data.hasEvictedSamples = true
+}
+
+// evictedSamplesError returns an `EvictedSamplesError` if some of the samples selected by the filter were evicted
+func (data *trialData) evictedSamplesError(trialID string, filter backend.TrialSampleFilter) error {
+	data.samplesMutex.Lock()
+	defer data.samplesMutex.Unlock()
+	if !data.hasEvictedSamples {
+		return nil
+	}
+	if (filter.FromTickID != nil && *filter.FromTickID > data.evictedMaxTickID) || (filter.ToTickID != nil && *filter.ToTickID < data.evictedMinTickID) {
+		// The selected tick range doesn't overlap the evicted samples
+		return nil
+	}
+	return &backend.EvictedSamplesError{TrialID: trialID, MinTickID: data.evictedMinTickID, MaxTickID: data.evictedMaxTickID}
+}
+
+// ticksOrdered returns whether the given stored samples, and the ones added to them later on, are stored in
+// increasing tick order so far. It can't be known once they were replaced, e.g. by their eviction.
+func (data *trialData) ticksOrdered(storedSamples utils.ObservableList) bool {
+	data.samplesMutex.Lock()
+	defer data.samplesMutex.Unlock()
+	return data.storedSamples == storedSamples && !data.unorderedTicks
+}
+
+// sampleIdxsByDecreasingTick returns the indices of the stored samples by decreasing tick id, for each tick id only
+// the last stored sample is kept. `samplesMutex` should be locked.
+func (data *trialData) sampleIdxsByDecreasingTick() []int {
+	tickIDs := make([]uint64, 0, len(data.storedSamplesIdx))
+	for tickID := range data.storedSamplesIdx {
+		tickIDs = append(tickIDs, tickID)
+	}
+	sort.Slice(tickIDs, func(i, j int) bool { return tickIDs[i] > tickIDs[j] })
+	sampleIdxs := make([]int, len(tickIDs))
+	for idx, tickID := range tickIDs {
+		sampleIdxs[idx] = data.storedSamplesIdx[tickID]
 	}
+	return sampleIdxs
 }
 
 type memoryBackend struct {
@@ -57,26 +153,144 @@ type memoryBackend struct {
 	trialsEvList          *list.List // trial eviction list, front is least recently used, back is recently used
 	trialsMutex           *sync.Mutex
 	trialIDs              utils.ObservableList
+	trialsCount           int // Number of non-deleted trials
+	oldestTrialIdx        int // Index in `trialIDs` before which every trial is deleted
 	samplesSize           uint32
+	samplesCount          uint32 // Number of stored samples, evicted samples aren't counted
 	maxSamplesSize        uint32
+	maxTrialsCount        int
+	maxTrialsCountPolicy  MaxTrialsCountPolicy
+	evictionHook          EvictionHook
+	evictionHookTimeout   time.Duration
+	marshalOptions        proto.MarshalOptions
+	serializationPool     *backend.SerializationPool
+	payloadCompression    backend.PayloadCompression
+	deduplicatePayloads   bool
+	sampleChecksums       bool
+	verifySampleChecksums bool
 	evictionWorkerTrigger chan struct{}
 	evictionWorkerCancel  context.CancelFunc
+	creationClock         backend.CreationClock
+
+	evictedTrialsCounter        backend.Counter
+	evictedTrialsSamplesCounter backend.Counter
+}
+
+// noopCounter is the counter of the evictions that aren't counted
+type noopCounter struct{}
+
+func (noopCounter) Inc() {}
+
+// MaxTrialsCountPolicy defines how the creation of a trial is handled when the maximum number of trials is reached
+type MaxTrialsCountPolicy int
+
+const (
+	// RejectNewTrials rejects the creation of new trials with a `backend.TooManyTrialsError`
+	RejectNewTrials MaxTrialsCountPolicy = iota
+	// EvictOldestTrials deletes the oldest trials, regardless of their state, to make room for the new ones
+	EvictOldestTrials
+)
+
+// ParseMaxTrialsCountPolicy parses a policy expressed as either "reject" or "evict"
+func ParseMaxTrialsCountPolicy(policy string) (MaxTrialsCountPolicy, error) {
+	switch policy {
+	case "reject":
+		return RejectNewTrials, nil
+	case "evict":
+		return EvictOldestTrials, nil
+	default:
+		return RejectNewTrials, fmt.Errorf("unknown max trials count policy %q expecting one of [reject evict]", policy)
+	}
+}
+
+// Options represents the configuration of a memory backend
+type Options struct {
+	MaxSamplesSize       uint32               // Maximum cumulated size of the stored samples before evicting least recently used trials samples
+	MaxTrialsCount       int                  // Maximum number of stored trials, 0 means no limit
+	MaxTrialsCountPolicy MaxTrialsCountPolicy // What to do when creating a trial while the maximum number of trials is reached
+	EvictionHook         EvictionHook         // Called before evicting a trial, nil means no hook
+	EvictionHookTimeout  time.Duration        // Maximum duration the eviction hook can delay an eviction, `DefaultEvictionHookTimeout` if not positive
+	// Serialize samples using deterministic marshaling, equal samples are then always stored as identical bytes.
+	// It might be slower as map fields need to be sorted.
+	DeterministicSerialization bool
+	PayloadCompression         backend.PayloadCompression // How the payloads of the stored samples are compressed
+	// Store identical payloads of the samples of a trial only once, e.g. an observation unchanged across ticks
+	DeduplicatePayloads bool
+	// Recycle the buffers used to compress the payloads of the added samples instead of allocating them for each sample
+	SerializationPooling bool
+	// Store a checksum along with each sample, protecting them against memory corruptions
+	SampleChecksums bool
+	// Check the samples checksums when reading them, a mismatch results in a `backend.CorruptedSampleError`
+	VerifySampleChecksums bool
+	// Incremented each time a trial is evicted because `MaxTrialsCount` is reached, can be nil
+	EvictedTrialsCounter backend.Counter
+	// Incremented each time the samples of a trial are evicted because `MaxSamplesSize` is reached, can be nil
+	EvictedTrialsSamplesCounter backend.Counter
 }
 
 var DefaultMaxSampleSize uint32 = 1024 * 1024 * 1024 // 1GB
 
+var DefaultOptions = Options{
+	MaxSamplesSize:             DefaultMaxSampleSize,
+	MaxTrialsCount:             0,
+	MaxTrialsCountPolicy:       RejectNewTrials,
+	EvictionHook:               nil,
+	EvictionHookTimeout:        DefaultEvictionHookTimeout,
+	DeterministicSerialization: false,
+	PayloadCompression:         backend.NoPayloadCompression,
+	DeduplicatePayloads:        false,
+	SerializationPooling:       true,
+	SampleChecksums:            false,
+	VerifySampleChecksums:      true,
+}
+
 // CreateMemoryBackend creates a Backend that will store at most "maxSamplesSize" bytes of samples
 func CreateMemoryBackend(maxSamplesSize uint32) (backend.Backend, error) {
+	options := DefaultOptions
+	options.MaxSamplesSize = maxSamplesSize
+	return CreateMemoryBackendWithOptions(options)
+}
+
+// CreateMemoryBackendWithOptions creates a Backend configured with the given options
+func CreateMemoryBackendWithOptions(options Options) (backend.Backend, error) {
+	evictionHookTimeout := options.EvictionHookTimeout
+	if evictionHookTimeout <= 0 {
+		evictionHookTimeout = DefaultEvictionHookTimeout
+	}
+	evictedTrialsCounter := options.EvictedTrialsCounter
+	if evictedTrialsCounter == nil {
+		evictedTrialsCounter = noopCounter{}
+	}
+	evictedTrialsSamplesCounter := options.EvictedTrialsSamplesCounter
+	if evictedTrialsSamplesCounter == nil {
+		evictedTrialsSamplesCounter = noopCounter{}
+	}
 	evictionWorkerContext, evictionWorkerCancel := context.WithCancel(context.Background())
+	serializationPool := backend.CreateSerializationPool(options.SerializationPooling)
 	backend := &memoryBackend{
 		trials:                make(map[string]*trialData),
 		trialsMutex:           &sync.Mutex{},
 		trialIDs:              utils.CreateObservableList(),
+		trialsCount:           0,
+		oldestTrialIdx:        0,
 		trialsEvList:          list.New(),
 		samplesSize:           0,
-		maxSamplesSize:        uint32(maxSamplesSize),
+		maxSamplesSize:        options.MaxSamplesSize,
+		maxTrialsCount:        options.MaxTrialsCount,
+		maxTrialsCountPolicy:  options.MaxTrialsCountPolicy,
+		evictionHook:          options.EvictionHook,
+		evictionHookTimeout:   evictionHookTimeout,
+		marshalOptions:        proto.MarshalOptions{Deterministic: options.DeterministicSerialization},
+		serializationPool:     serializationPool,
+		payloadCompression:    options.PayloadCompression,
+		deduplicatePayloads:   options.DeduplicatePayloads,
+		sampleChecksums:       options.SampleChecksums,
+		verifySampleChecksums: options.VerifySampleChecksums,
 		evictionWorkerTrigger: make(chan struct{}),
 		evictionWorkerCancel:  evictionWorkerCancel,
+
+		evictedTrialsCounter:        evictedTrialsCounter,
+		evictedTrialsSamplesCounter: evictedTrialsSamplesCounter,
 	}
 
 	// Start the eviction worker
@@ -90,59 +304,106 @@ func (b *memoryBackend) Destroy() {
 	b.evictionWorkerCancel()
 }
 
+// createTrialPayloadBlobStore creates the store of the deduplicated payloads of a trial, nil if payloads aren't deduplicated
+func (b *memoryBackend) createTrialPayloadBlobStore() *payloadBlobStore {
+	if !b.deduplicatePayloads {
+		return nil
+	}
+	return createPayloadBlobStore()
+}
+
 func (b *memoryBackend) getSampleSize() uint32 {
 	sampleSize := atomic.LoadUint32(&b.samplesSize)
 	return sampleSize
 }
 
 func (b *memoryBackend) evictLruTrials(ctx context.Context) uint32 {
+	totalReclaimedSampleSize := uint32(0)
 	for {
-		doneChannel := make(chan bool)
-		totalReclaimedSampleSize := uint32(0)
+		// Buffered so that an eviction finishing after the cancellation doesn't block
+		resultChannel := make(chan lruEvictionResult, 1)
 		go func() {
-			if b.getSampleSize() <= b.maxSamplesSize {
-				// Already done
-				doneChannel <- true
-				return
-			}
-			b.trialsMutex.Lock()
-			defer b.trialsMutex.Unlock()
-			front := b.trialsEvList.Front()
-			if front == nil {
-				// No trials
-				doneChannel <- true
-				return
-			}
-			frontTrialID := front.Value.(string)
-			frontData := b.trials[frontTrialID]
-			if !frontData.storedSamples.HasEnded() {
-				// We don't want to evict ongoing trials if the LRU trials is ongoing there's probably nothing to evict
-				doneChannel <- true
-				return
-			}
-			reclaimedSampleSize := frontData.storedSamplesSize
-			totalReclaimedSampleSize += reclaimedSampleSize
-			// Subtract the trial size from the total
-			atomic.AddUint32(&b.samplesSize, ^uint32(reclaimedSampleSize-1))
-			frontData.storedSamples = utils.CreateObservableList()
-			frontData.storedSamplesSize = 0
-			frontData.evListElement = nil
-			b.trialsEvList.Remove(front)
-			doneChannel <- b.getSampleSize() <= b.maxSamplesSize
+			resultChannel <- b.evictLruTrial()
 		}()
 
 		select {
 		case <-ctx.Done():
 			// Cancelled
 			return totalReclaimedSampleSize
-		case done := <-doneChannel:
-			if done {
+		case result := <-resultChannel:
+			totalReclaimedSampleSize += result.reclaimedSampleSize
+			if result.done {
 				return totalReclaimedSampleSize
 			}
 		}
 	}
 }
 
+// lruEvictionResult is the outcome of the eviction of the samples of the least recently used trial
+type lruEvictionResult struct {
+	reclaimedSampleSize uint32
+	done                bool // Nothing more needs or can be evicted
+}
+
+// evictLruTrial evicts the samples of the least recently used trial if the samples size exceeds the maximum
+func (b *memoryBackend) evictLruTrial() lruEvictionResult {
+	if b.getSampleSize() <= b.maxSamplesSize {
+		// Already done
+		return lruEvictionResult{done: true}
+	}
+	b.trialsMutex.Lock()
+	defer b.trialsMutex.Unlock()
+	front := b.trialsEvList.Front()
+	if front == nil {
+		// No trials
+		return lruEvictionResult{done: true}
+	}
+	frontTrialID := front.Value.(string)
+	frontData := b.trials[frontTrialID]
+	frontData.samplesMutex.Lock()
+	storedSamples := frontData.storedSamples
+	storedSamplesCount := storedSamples.Len()
+	frontData.samplesMutex.Unlock()
+	if !storedSamples.HasEnded() {
+		// We don't want to evict ongoing trials if the LRU trials is ongoing there's probably nothing to evict
+		return lruEvictionResult{done: true}
+	}
+	switch b.runEvictionHook(frontTrialID, frontData, true) {
+	case evictionVetoed:
+		// Eviction vetoed, the trial is considered as recently used so that the others get evicted first next time
+		b.trialsEvList.MoveToBack(front)
+		return lruEvictionResult{done: true}
+	case evictionStale:
+		return lruEvictionResult{}
+	}
+	if b.trialsEvList.Front() != front {
+		// The trial was used while the eviction hook was running
+		return lruEvictionResult{}
+	}
+	// Samples are added without locking the backend, the ones added since the trial was checked would be lost
+	frontData.samplesMutex.Lock()
+	defer frontData.samplesMutex.Unlock()
+	if frontData.storedSamples != storedSamples || storedSamples.Len() != storedSamplesCount {
+		return lruEvictionResult{}
+	}
+	frontData.recordEvictedSamples()
+	reclaimedSampleSize := frontData.storedSamplesSize
+	// Subtract the trial size from the total
+	atomic.AddUint32(&b.samplesSize, ^uint32(reclaimedSampleSize-1))
+	atomic.AddUint32(&b.samplesCount, ^uint32(frontData.storedSamples.Len()-1))
+	frontData.storedSamples = utils.CreateObservableList()
+	// Like the evicted samples, the new list is ended, observations don't wait for samples that won't come
+	frontData.storedSamples.End()
+	frontData.storedSamplesIdx = make(map[uint64]int)
+	frontData.unorderedTicks = false
+	frontData.storedSamplesSize = 0
+	frontData.payloadBlobs = b.createTrialPayloadBlobStore()
+	frontData.evListElement = nil
+	b.trialsEvList.Remove(front)
+	b.evictedTrialsSamplesCounter.Inc()
+	return lruEvictionResult{reclaimedSampleSize: reclaimedSampleSize, done: b.getSampleSize() <= b.maxSamplesSize}
+}
+
 func (b *memoryBackend) evictionWorker(ctx context.Context) {
 	for {
 		doneChannel := make(chan bool)
@@ -153,8 +414,14 @@ func (b *memoryBackend) evictionWorker(ctx context.Context) {
 			for len(b.evictionWorkerTrigger) > 0 {
 				<-b.evictionWorkerTrigger
 			}
+			startTime := time.Now()
 			reclaimedSampleSize := b.evictLruTrials(ctx)
-			log.Debugf("Eviction worker reclaimed %dB from total sample size, now storing %dB of samples", reclaimedSampleSize, b.getSampleSize())
+			log.WithFields(log.Fields{
+				"operation":           "evict_samples",
+				"duration_ms":         time.Since(startTime).Milliseconds(),
+				"reclaimed_size":      reclaimedSampleSize,
+				"stored_samples_size": b.getSampleSize(),
+			}).Debug("Eviction worker reclaimed samples")
 			doneChannel <- true
 		}()
 
@@ -185,30 +452,210 @@ func (b *memoryBackend) retrieveTrialDatas(trialIDs []string) ([]*trialData, err
 	return reply, nil
 }
 
+// listedTrial returns the trial listed at the given index of `trialIDs`, its data is nil if it was deleted since,
+// `trialsMutex` should be locked
+func (b *memoryBackend) listedTrial(trialIdx int) (string, *trialData) {
+	trialIDItem, _ := b.trialIDs.Item(trialIdx)
+	trialID := trialIDItem.(string)
+	data, exists := b.trials[trialID]
+	if !exists || data.deleted || data.trialIdx != trialIdx {
+		return trialID, nil
+	}
+	return trialID, data
+}
+
+// evictOldestTrial deletes the oldest non-deleted trial whose eviction isn't vetoed, `trialsMutex` should be locked, it
+// is unlocked while the eviction hook runs
+func (b *memoryBackend) evictOldestTrial() bool {
+	for ; b.oldestTrialIdx < b.trialIDs.Len(); b.oldestTrialIdx++ {
+		if _, data := b.listedTrial(b.oldestTrialIdx); data != nil {
+			break
+		}
+	}
+	for trialIdx := b.oldestTrialIdx; trialIdx < b.trialIDs.Len(); trialIdx++ {
+		trialID, data := b.listedTrial(trialIdx)
+		if data == nil {
+			continue
+		}
+		switch b.runEvictionHook(trialID, data, false) {
+		case evictionVetoed:
+			continue
+		case evictionStale:
+			// Reconsidering the trial
+			trialIdx--
+			continue
+		}
+		log.WithFields(log.Fields{
+			"operation":        "evict_trial",
+			"trial_id":         trialID,
+			"max_trials_count": b.maxTrialsCount,
+		}).Debug("Evicting trial, the maximum number of trials is reached")
+		b.deleteTrial(trialID)
+		b.evictedTrialsCounter.Inc()
+		return true
+	}
+	return false
+}
+
+// Reindex rebuilds the trials count, the samples sizes and tick indices and the trials states from the stored samples.
+func (b *memoryBackend) Reindex(ctx context.Context) error {
+	b.trialsMutex.Lock()
+	defer b.trialsMutex.Unlock()
+
+	listedTrialIDs := make(map[string]struct{})
+	for trialIdx := 0; trialIdx < b.trialIDs.Len(); trialIdx++ {
+		trialIDItem, _ := b.trialIDs.Item(trialIdx)
+		listedTrialIDs[trialIDItem.(string)] = struct{}{}
+	}
+
+	trialsCount := 0
+	samplesSize := uint32(0)
+	samplesCount := uint32(0)
+	for trialID, data := range b.trials {
+		if _, listed := listedTrialIDs[trialID]; !listed {
+			log.WithFields(log.Fields{"operation": "reindex", "trial_id": trialID}).Warn("Reindexing trial missing from the trials list")
+			data.trialIdx = b.trialIDs.Len()
+			b.trialIDs.Append(trialID, false)
+		}
+		if data.deleted {
+			continue
+		}
+		trialsCount++
+
+		data.samplesMutex.Lock()
+		storedSamplesIdx := make(map[uint64]int)
+		storedSamplesSize := uint32(0)
+		minTickID, maxTickID := uint64(0), uint64(0)
+		unorderedTicks := false
+		trialState := data.trialState
+		for sampleIdx := 0; sampleIdx < data.storedSamples.Len(); sampleIdx++ {
+			storedSample, _ := data.storedSamples.Item(sampleIdx)
+			serializedSample, valid := b.splitSampleChecksum(storedSample.([]byte))
+			if !valid {
+				err := identifyCorruptedSample(trialID, data.storedSamplesIdx, sampleIdx)
+				data.samplesMutex.Unlock()
+				return err
+			}
+			sample := &grpcapi.StoredTrialSample{}
+			if err := proto.Unmarshal(serializedSample, sample); err != nil {
+				data.samplesMutex.Unlock()
+				return backend.NewUnexpectedError("unable to deserialize sample of trial %q (%w)", trialID, err)
+			}
+			storedSamplesIdx[sample.TickId] = sampleIdx
+			if sampleIdx > 0 && sample.TickId < maxTickID {
+				unorderedTicks = true
+			}
+			if sampleIdx == 0 || sample.TickId < minTickID {
+				minTickID = sample.TickId
+			}
+			if sampleIdx == 0 || sample.TickId > maxTickID {
+				maxTickID = sample.TickId
+			}
+			storedSamplesSize += uint32(len(storedSample.([]byte)))
+			trialState = sample.State
+		}
+		if data.payloadBlobs != nil {
+			storedSamplesSize += data.payloadBlobs.getSize()
+		}
+		data.storedSamplesIdx = storedSamplesIdx
+		data.minTickID = minTickID
+		data.maxTickID = maxTickID
+		data.unorderedTicks = unorderedTicks
+		data.storedSamplesSize = storedSamplesSize
+		data.trialState = trialState
+		if data.samplesCount < data.storedSamples.Len() {
+			// Evicted samples are counted but not stored, the count can't be lower than the number of stored samples
+			data.samplesCount = data.storedSamples.Len()
+		}
+		samplesCount += uint32(data.storedSamples.Len())
+		data.samplesMutex.Unlock()
+
+		samplesSize += storedSamplesSize
+	}
+	b.trialsCount = trialsCount
+	atomic.StoreUint32(&b.samplesSize, samplesSize)
+	atomic.StoreUint32(&b.samplesCount, samplesCount)
+
+	for b.oldestTrialIdx = 0; b.oldestTrialIdx < b.trialIDs.Len(); b.oldestTrialIdx++ {
+		if _, data := b.listedTrial(b.oldestTrialIdx); data != nil {
+			break
+		}
+	}
+
+	b.triggerEvictionIfNeeded()
+	return nil
+}
+
+func (b *memoryBackend) StorageStats(ctx context.Context) (backend.StorageStats, error) {
+	if err := ctx.Err(); err != nil {
+		return backend.StorageStats{}, err
+	}
+	b.trialsMutex.Lock()
+	trialsCount := b.trialsCount
+	b.trialsMutex.Unlock()
+	return backend.StorageStats{
+		BackendType:  BackendName,
+		TrialsCount:  trialsCount,
+		SamplesCount: int(atomic.LoadUint32(&b.samplesCount)),
+		SamplesSize:  int(b.getSampleSize()),
+	}, nil
+}
+
 func (b *memoryBackend) CreateOrUpdateTrials(ctx context.Context, trialsParams []*backend.TrialParams) error {
 	b.trialsMutex.Lock()
 	defer b.trialsMutex.Unlock()
 
+	if b.maxTrialsCount > 0 && b.maxTrialsCountPolicy == RejectNewTrials {
+		newTrialIDs := make(map[string]struct{})
+		for _, trialParams := range trialsParams {
+			if data, exists := b.trials[trialParams.TrialID]; !exists || data.deleted {
+				newTrialIDs[trialParams.TrialID] = struct{}{}
+			}
+		}
+		if b.trialsCount+len(newTrialIDs) > b.maxTrialsCount {
+			return &backend.TooManyTrialsError{MaxTrialsCount: b.maxTrialsCount}
+		}
+	}
+
 	for _, trialParams := range trialsParams {
-		if data, exists := b.trials[trialParams.TrialID]; exists {
+		if b.maxTrialsCount > 0 {
+			// The backend is unlocked while the eviction hook runs, the trial could be created meanwhile
+			for !b.trialExists(trialParams.TrialID) && b.trialsCount >= b.maxTrialsCount {
+				if !b.evictOldestTrial() {
+					return &backend.TooManyTrialsError{MaxTrialsCount: b.maxTrialsCount}
+				}
+			}
+		}
+		if data, exists := b.trials[trialParams.TrialID]; exists && !data.deleted {
 			if data.evListElement != nil {
 				b.trialsEvList.MoveToBack(data.evListElement)
 			}
+			data.samplesMutex.Lock()
 			data.params = trialParams.Params
 			data.userID = trialParams.UserID
+			data.sampleOrderingKey = trialParams.SampleOrderingKey
+			data.tags = backend.CopyTrialTags(trialParams.Tags)
+			data.samplesMutex.Unlock()
 		} else {
 			data := &trialData{
 				params:            trialParams.Params,
 				userID:            trialParams.UserID,
+				sampleOrderingKey: trialParams.SampleOrderingKey,
+				tags:              backend.CopyTrialTags(trialParams.Tags),
 				trialState:        grpcapi.TrialState_UNKNOWN,
 				samplesCount:      0,
 				storedSamples:     utils.CreateObservableList(),
+				storedSamplesIdx:  make(map[uint64]int),
 				storedSamplesSize: 0,
 				evListElement:     b.trialsEvList.PushFront(trialParams.TrialID),
+				payloadBlobs:      b.createTrialPayloadBlobStore(),
 				deleted:           false,
+				createdAt:         b.creationClock.Now(),
+				trialIdx:          b.trialIDs.Len(),
 			}
 			b.trials[trialParams.TrialID] = data
 			b.trialIDs.Append(trialParams.TrialID, false)
+			b.trialsCount++
 		}
 	}
 	return nil
@@ -244,14 +691,14 @@ func (b *memoryBackend) RetrieveTrials(ctx context.Context, filter []string, fro
 		if len(result.TrialInfos) >= count {
 			break
 		}
-		trialIDItem, _ := b.trialIDs.Item(trialIdx)
-		trialID := trialIDItem.(string)
-		data, _ := b.retrieveTrialDatas([]string{trialID})
-		if data[0].deleted {
+		b.trialsMutex.Lock()
+		trialID, data := b.listedTrial(trialIdx)
+		b.trialsMutex.Unlock()
+		if data == nil {
 			continue
 		}
 		if selectedTrialIDs.Selects(trialID) {
-			result.TrialInfos = append(result.TrialInfos, createTrialInfo(trialID, data[0]))
+			result.TrialInfos = append(result.TrialInfos, createTrialInfo(trialID, data))
 			result.NextTrialIdx = trialIdx + 1
 		}
 	}
@@ -279,12 +726,13 @@ func (b *memoryBackend) ObserveTrials(ctx context.Context, filter []string, from
 	})
 	g.Go(func() error {
 		defer cancel()
-		for trialIDItem := range observer {
-			trialID := trialIDItem.(string)
-			data, _ := b.retrieveTrialDatas([]string{trialID})
-			if !data[0].deleted && selectedTrialIDs.Selects(trialID) {
+		for range observer {
+			b.trialsMutex.Lock()
+			trialID, data := b.listedTrial(trialIdx)
+			b.trialsMutex.Unlock()
+			if data != nil && selectedTrialIDs.Selects(trialID) {
 				unitResult := backend.TrialsInfoResult{
-					TrialInfos:   []*backend.TrialInfo{createTrialInfo(trialID, data[0])},
+					TrialInfos:   []*backend.TrialInfo{createTrialInfo(trialID, data)},
 					NextTrialIdx: trialIdx + 1,
 				}
 				select {
@@ -309,24 +757,55 @@ func (b *memoryBackend) ObserveTrials(ctx context.Context, filter []string, from
 	return nil
 }
 
+// deleteTrial deletes the given trial, `trialsMutex` should be locked
+func (b *memoryBackend) deleteTrial(trialID string) {
+	if data, exists := b.trials[trialID]; exists && !data.deleted {
+		if data.evListElement != nil {
+			b.trialsEvList.Remove(data.evListElement)
+		}
+		// Flagging the deleted data so that concurrent additions of samples fail instead of being lost
+		data.samplesMutex.Lock()
+		data.deleted = true
+		// Subtract the trial size from the total
+		atomic.AddUint32(&b.samplesSize, ^uint32(data.storedSamplesSize-1))
+		atomic.AddUint32(&b.samplesCount, ^uint32(data.storedSamples.Len()-1))
+		// Ending the ongoing observations of the trial samples
+		data.storedSamples.End()
+		data.samplesMutex.Unlock()
+		// The trial id remains listed in `trialIDs`, a trial registered again after its deletion being listed again
+		delete(b.trials, trialID)
+		b.trialsCount--
+	}
+}
+
 func (b *memoryBackend) DeleteTrials(ctx context.Context, trialIDs []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	b.trialsMutex.Lock()
 	defer b.trialsMutex.Unlock()
 	for _, trialID := range trialIDs {
-		if data, exists := b.trials[trialID]; exists {
-			if data.evListElement != nil {
-				b.trialsEvList.Remove(data.evListElement)
-			}
-			// Subtract the trial size from the total
-			atomic.AddUint32(&b.samplesSize, ^uint32(data.storedSamplesSize-1))
-			b.trials[trialID] = &trialData{
-				deleted: true,
-			}
-		}
+		b.deleteTrial(trialID)
 	}
 	return nil
 }
 
+// trialExists checks if the given trial exists, `trialsMutex` should be locked
+func (b *memoryBackend) trialExists(trialID string) bool {
+	data, exists := b.trials[trialID]
+	return exists && !data.deleted
+}
+
+func (b *memoryBackend) TrialsExist(ctx context.Context, trialIDs []string) ([]bool, error) {
+	b.trialsMutex.Lock()
+	defer b.trialsMutex.Unlock()
+	exist := make([]bool, len(trialIDs))
+	for idx, trialID := range trialIDs {
+		exist[idx] = b.trialExists(trialID)
+	}
+	return exist, nil
+}
+
 func (b *memoryBackend) GetTrialParams(ctx context.Context, trialIDs []string) ([]*backend.TrialParams, error) {
 	trialDatas, err := b.retrieveTrialDatas(trialIDs)
 	if err != nil {
@@ -334,12 +813,132 @@ func (b *memoryBackend) GetTrialParams(ctx context.Context, trialIDs []string) (
 	}
 	trialParams := make([]*backend.TrialParams, len(trialIDs))
 	for idx, trialData := range trialDatas {
-		trialParams[idx] = &backend.TrialParams{TrialID: trialIDs[idx], Params: trialData.params}
+		trialParams[idx] = trialData.trialParams(trialIDs[idx])
 	}
 	return trialParams, nil
 }
 
+func (b *memoryBackend) triggerEvictionIfNeeded() {
+	if b.getSampleSize() > b.maxSamplesSize {
+		go func() {
+			b.evictionWorkerTrigger <- struct{}{}
+		}()
+	}
+}
+
+// serializeSample serializes a sample of the given trial, compressing and deduplicating its payloads.
+//
+// It returns the serialized sample and the number of bytes added to the trial payload blobs.
+func (b *memoryBackend) serializeSample(t *trialData, sample *grpcapi.StoredTrialSample) ([]byte, uint32, error) {
+	if t.payloadBlobs != nil {
+		// The payload blobs retain the compressed payloads, they can't be recycled
+		compressedSample, err := backend.CompressSamplePayloads(sample, b.payloadCompression)
+		if err != nil {
+			return nil, 0, err
+		}
+		storedSample, addedBlobsSize := t.payloadBlobs.dedupSamplePayloads(compressedSample)
+		serializedSample, err := b.marshalOptions.Marshal(storedSample)
+		if err != nil {
+			return nil, 0, backend.NewUnexpectedError("unable to serialize sample (%w)", err)
+		}
+		return b.appendSampleChecksum(serializedSample), addedBlobsSize, nil
+	}
+
+	// The serialized sample is stored as is, only the compressed payloads, copied by the serialization, are recycled
+	serializationBuffers := b.serializationPool.Acquire()
+	defer serializationBuffers.Release()
+	compressedSample, err := serializationBuffers.CompressSamplePayloads(sample, b.payloadCompression)
+	if err != nil {
+		return nil, 0, err
+	}
+	serializedSample, err := b.marshalOptions.Marshal(compressedSample)
+	if err != nil {
+		return nil, 0, backend.NewUnexpectedError("unable to serialize sample (%w)", err)
+	}
+	return b.appendSampleChecksum(serializedSample), 0, nil
+}
+
+// errCorruptedSample is returned by `deserializeSample` when the checksum of a sample doesn't match, the callers
+// knowing the sample turn it into a `backend.CorruptedSampleError`
+var errCorruptedSample = errors.New("corrupted sample")
+
+func (b *memoryBackend) appendSampleChecksum(serializedSample []byte) []byte {
+	if !b.sampleChecksums {
+		return serializedSample
+	}
+	return backend.AppendSampleChecksum(serializedSample)
+}
+
+// splitSampleChecksum removes the checksum of a stored sample, if any, and returns whether it is valid or not verified
+func (b *memoryBackend) splitSampleChecksum(storedSample []byte) ([]byte, bool) {
+	if !b.sampleChecksums {
+		return storedSample, true
+	}
+	serializedSample, valid := backend.SplitSampleChecksum(storedSample)
+	return serializedSample, valid || !b.verifySampleChecksums
+}
+
+// identifyCorruptedSample identifies the corrupted sample at the given index of the stored samples of a trial
+func identifyCorruptedSample(trialID string, storedSamplesIdx map[uint64]int, sampleIdx int) error {
+	for tickID, idx := range storedSamplesIdx {
+		if idx == sampleIdx {
+			return &backend.CorruptedSampleError{TrialID: trialID, TickID: tickID}
+		}
+	}
+	return &backend.CorruptedSampleError{TrialID: trialID}
+}
+
+// deserializeSample deserializes a sample, resolving its payloads from the given blobs, if any, and decompressing them
+func (b *memoryBackend) deserializeSample(payloadBlobs *payloadBlobStore, serializedSample []byte) (*grpcapi.StoredTrialSample, error) {
+	serializedSample, valid := b.splitSampleChecksum(serializedSample)
+	if !valid {
+		return nil, errCorruptedSample
+	}
+	sample := &grpcapi.StoredTrialSample{}
+	if err := proto.Unmarshal(serializedSample, sample); err != nil {
+		return nil, backend.NewUnexpectedError("unable to deserialize sample (%w)", err)
+	}
+	if payloadBlobs != nil {
+		if err := payloadBlobs.resolveSamplePayloads(sample); err != nil {
+			return nil, err
+		}
+	}
+	if err := backend.DecompressSamplePayloads(sample, b.payloadCompression); err != nil {
+		return nil, err
+	}
+	return sample, nil
+}
+
+func (b *memoryBackend) addSample(t *trialData, sample *grpcapi.StoredTrialSample) error {
+	serializedSample, addedBlobsSize, err := b.serializeSample(t, sample)
+	if err != nil {
+		return err
+	}
+	sampleSize := uint32(len(serializedSample)) + addedBlobsSize
+	atomic.AddUint32(&b.samplesSize, sampleSize)
+	atomic.AddUint32(&b.samplesCount, 1)
+	t.storedSamplesSize += sampleSize
+	if t.storedSamples.Len() > 0 && sample.TickId < t.maxTickID {
+		t.unorderedTicks = true
+	}
+	if t.storedSamples.Len() == 0 || sample.TickId < t.minTickID {
+		t.minTickID = sample.TickId
+	}
+	if t.storedSamples.Len() == 0 || sample.TickId > t.maxTickID {
+		t.maxTickID = sample.TickId
+	}
+	t.storedSamplesIdx[sample.TickId] = t.storedSamples.Len()
+	t.storedSamples.Append(serializedSample, sample.State == grpcapi.TrialState_ENDED)
+	t.trialState = sample.State
+	t.abandoned = false
+	t.samplesCount++
+	return nil
+}
+
 func (b *memoryBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	trialIDs := make([]string, len(samples))
 	for idx, sample := range samples {
 		trialIDs[idx] = sample.TrialId
@@ -350,22 +949,243 @@ func (b *memoryBackend) AddSamples(ctx context.Context, samples []*grpcapi.Store
 	}
 	for idx, sample := range samples {
 		t := trialDatas[idx]
-		serializedSample, err := proto.Marshal(sample)
+		t.samplesMutex.Lock()
+		if t.deleted {
+			t.samplesMutex.Unlock()
+			return &backend.UnknownTrialError{TrialID: sample.TrialId, Deleted: true}
+		}
+		err := b.addSample(t, sample)
+		evicted := t.evListElement == nil
+		t.samplesMutex.Unlock()
 		if err != nil {
-			return backend.NewUnexpectedError("unable to serialize sample (%w)", err)
+			return err
+		}
+		if evicted {
+			b.relistEvictedTrial(sample.TrialId, t)
 		}
-		sampleSize := uint32(len(serializedSample))
-		atomic.AddUint32(&b.samplesSize, sampleSize)
-		t.storedSamplesSize += sampleSize
-		t.storedSamples.Append(serializedSample, sample.State == grpcapi.TrialState_ENDED)
-		t.trialState = sample.State
-		t.samplesCount++
 	}
 
-	if b.getSampleSize() > b.maxSamplesSize {
-		go func() {
-			b.evictionWorkerTrigger <- struct{}{}
-		}()
+	b.triggerEvictionIfNeeded()
+	return nil
+}
+
+// relistEvictedTrial adds back a trial whose samples were evicted to the eviction list, the samples added to it since
+// then can be evicted in turn
+func (b *memoryBackend) relistEvictedTrial(trialID string, t *trialData) {
+	b.trialsMutex.Lock()
+	defer b.trialsMutex.Unlock()
+	if b.trials[trialID] != t {
+		// Deleted in the meantime
+		return
+	}
+	t.samplesMutex.Lock()
+	defer t.samplesMutex.Unlock()
+	if t.evListElement == nil {
+		t.evListElement = b.trialsEvList.PushBack(trialID)
+	}
+}
+
+func (b *memoryBackend) AddSamplePartial(ctx context.Context, partialSample *grpcapi.StoredTrialSample) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	trialDatas, err := b.retrieveTrialDatas([]string{partialSample.TrialId})
+	if err != nil {
+		return err
+	}
+	t := trialDatas[0]
+	evicted := false
+	defer func() {
+		// Once the trial samples mutex is unlocked, the backend can't be locked while it is
+		if evicted {
+			b.relistEvictedTrial(partialSample.TrialId, t)
+		}
+	}()
+	t.samplesMutex.Lock()
+	defer t.samplesMutex.Unlock()
+	if t.deleted {
+		return &backend.UnknownTrialError{TrialID: partialSample.TrialId, Deleted: true}
+	}
+
+	sampleIdx, exists := t.storedSamplesIdx[partialSample.TickId]
+	if !exists {
+		err := b.addSample(t, partialSample)
+		if err != nil {
+			return err
+		}
+		evicted = t.evListElement == nil
+		b.triggerEvictionIfNeeded()
+		return nil
+	}
+
+	serializedSample, _ := t.storedSamples.Item(sampleIdx)
+	storedSample, err := b.deserializeSample(t.payloadBlobs, serializedSample.([]byte))
+	if errors.Is(err, errCorruptedSample) {
+		return &backend.CorruptedSampleError{TrialID: partialSample.TrialId, TickID: partialSample.TickId}
+	}
+	if err != nil {
+		return err
+	}
+	mergedSample := backend.MergeTrialSamples(storedSample, partialSample)
+	serializedMergedSample, addedBlobsSize, err := b.serializeSample(t, mergedSample)
+	if err != nil {
+		return err
+	}
+
+	sampleSizeDelta := uint32(len(serializedMergedSample)) + addedBlobsSize - uint32(len(serializedSample.([]byte)))
+	atomic.AddUint32(&b.samplesSize, sampleSizeDelta)
+	t.storedSamplesSize += sampleSizeDelta
+	t.storedSamples.Replace(sampleIdx, serializedMergedSample, mergedSample.State == grpcapi.TrialState_ENDED)
+	if sampleIdx == t.storedSamples.Len()-1 {
+		t.trialState = mergedSample.State
+	}
+	t.abandoned = false
+
+	b.triggerEvictionIfNeeded()
+	return nil
+}
+
+func (b *memoryBackend) EndTrials(ctx context.Context, trialIDs []string) error {
+	return b.endTrials(trialIDs, false)
+}
+
+func (b *memoryBackend) AbandonTrials(ctx context.Context, trialIDs []string) error {
+	return b.endTrials(trialIDs, true)
+}
+
+func (b *memoryBackend) endTrials(trialIDs []string, abandoned bool) error {
+	trialDatas, err := b.retrieveTrialDatas(trialIDs)
+	if err != nil {
+		return err
+	}
+	for idx, t := range trialDatas {
+		t.samplesMutex.Lock()
+		if t.deleted {
+			t.samplesMutex.Unlock()
+			return &backend.UnknownTrialError{TrialID: trialIDs[idx], Deleted: true}
+		}
+		t.trialState = grpcapi.TrialState_ENDED
+		t.abandoned = abandoned
+		t.storedSamples.End()
+		t.samplesMutex.Unlock()
+	}
+
+	// Ended trials can be evicted
+	b.triggerEvictionIfNeeded()
+	return nil
+}
+
+func (b *memoryBackend) SetTrialResult(ctx context.Context, trialID string, result *backend.TrialResult) error {
+	trialDatas, err := b.retrieveTrialDatas([]string{trialID})
+	if err != nil {
+		return err
+	}
+	t := trialDatas[0]
+	t.samplesMutex.Lock()
+	defer t.samplesMutex.Unlock()
+	if t.deleted {
+		return &backend.UnknownTrialError{TrialID: trialID, Deleted: true}
+	}
+	t.result = result
+	return nil
+}
+
+func (b *memoryBackend) GetTrialResult(ctx context.Context, trialID string) (*backend.TrialResult, error) {
+	trialDatas, err := b.retrieveTrialDatas([]string{trialID})
+	if err != nil {
+		return nil, err
+	}
+	t := trialDatas[0]
+	t.samplesMutex.Lock()
+	defer t.samplesMutex.Unlock()
+	if t.deleted {
+		return nil, &backend.UnknownTrialError{TrialID: trialID}
+	}
+	if t.result == nil {
+		return nil, &backend.NoTrialResultError{TrialID: trialID}
+	}
+	return t.result, nil
+}
+
+// ClearSamples deletes every sample of a trial, and its result, while keeping its params.
+//
+// Ongoing observations of the trial samples end once the samples they already retrieved are sent, they don't retrieve
+// the samples added afterwards.
+func (b *memoryBackend) ClearSamples(ctx context.Context, trialID string) error {
+	b.trialsMutex.Lock()
+	defer b.trialsMutex.Unlock()
+	data, exists := b.trials[trialID]
+	if !exists || data.deleted {
+		return &backend.UnknownTrialError{TrialID: trialID}
+	}
+	data.samplesMutex.Lock()
+	defer data.samplesMutex.Unlock()
+
+	// Subtract the trial size from the total
+	atomic.AddUint32(&b.samplesSize, ^uint32(data.storedSamplesSize-1))
+	atomic.AddUint32(&b.samplesCount, ^uint32(data.storedSamples.Len()-1))
+	data.storedSamples.End()
+	data.storedSamples = utils.CreateObservableList()
+	data.storedSamplesIdx = make(map[uint64]int)
+	data.unorderedTicks = false
+	data.storedSamplesSize = 0
+	data.payloadBlobs = b.createTrialPayloadBlobStore()
+	data.samplesCount = 0
+	data.trialState = grpcapi.TrialState_UNKNOWN
+	data.abandoned = false
+	data.hasEvictedSamples = false
+	data.result = nil
+	if data.evListElement == nil {
+		// The trial samples were evicted, it can receive samples again
+		data.evListElement = b.trialsEvList.PushBack(trialID)
+	} else {
+		b.trialsEvList.MoveToBack(data.evListElement)
+	}
+	return nil
+}
+
+// observeStoredSamplesInReverse sends the samples currently stored in `storedSamples` by decreasing tick id, from the
+// last to the first one when they are stored in tick order, following `sampleIdxs` otherwise
+func (b *memoryBackend) observeStoredSamplesInReverse(
+	ctx context.Context,
+	trialID string,
+	t *trialData,
+	storedSamples utils.ObservableList,
+	sampleIdxs []int,
+	payloadBlobs *payloadBlobStore,
+	appliedFilter *backend.AppliedTrialSampleFilter,
+	out chan<- *grpcapi.StoredTrialSample,
+) error {
+	samplesCount := len(sampleIdxs)
+	if sampleIdxs == nil {
+		samplesCount = storedSamples.Len()
+	}
+	for position := 0; position < samplesCount; position++ {
+		sampleIdx := samplesCount - 1 - position
+		if sampleIdxs != nil {
+			sampleIdx = sampleIdxs[position]
+		}
+		serializedSample, _ := storedSamples.Item(sampleIdx)
+		sample, err := b.deserializeSample(payloadBlobs, serializedSample.([]byte))
+		if errors.Is(err, errCorruptedSample) {
+			return t.corruptedSampleError(trialID, sampleIdx)
+		}
+		if err != nil {
+			return err
+		}
+		if appliedFilter.IsBeforeTickRange(sample.TickId) {
+			// Samples are visited by decreasing tick id, every selected sample has been sent
+			return nil
+		}
+		filteredSample := appliedFilter.Filter(sample)
+		if filteredSample == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- filteredSample:
+		}
 	}
 	return nil
 }
@@ -375,40 +1195,122 @@ func (b *memoryBackend) ObserveSamples(ctx context.Context, filter backend.Trial
 	if err != nil {
 		return err
 	}
+	trialsParams := make([]*backend.TrialParams, len(trialDatas))
+	for idx, td := range trialDatas {
+		err := td.evictedSamplesError(filter.TrialIDs[idx], filter)
+		if err != nil {
+			return err
+		}
+		trialsParams[idx] = td.trialParams(filter.TrialIDs[idx])
+		err = filter.CheckTrialParams(filter.TrialIDs[idx], trialsParams[idx].Params)
+		if err != nil {
+			return err
+		}
+	}
 
 	g, ctx := errgroup.WithContext(ctx)
 
-	for _, td := range trialDatas {
+	for idx, td := range trialDatas {
 		td := td // Create a new 'td' that gets captured by the goroutine's closure https://golang.org/doc/faq#closures_and_goroutines
-		appliedFilter := backend.NewAppliedTrialSampleFilter(filter, td.params)
+		trialID := filter.TrialIDs[idx]
+		sampleOrderingKey := trialsParams[idx].SampleOrderingKey
+		appliedFilter := backend.NewAppliedTrialSampleFilter(filter, trialsParams[idx].Params)
+		// The observed samples are resolved with the payload blobs stored along with them
+		td.samplesMutex.Lock()
+		storedSamples, payloadBlobs := td.storedSamples, td.payloadBlobs
+		var reverseSampleIdxs []int
+		if filter.Reverse && td.unorderedTicks {
+			// The samples aren't stored in tick order, they need to be visited by decreasing tick id
+			reverseSampleIdxs = td.sampleIdxsByDecreasingTick()
+		}
+		td.samplesMutex.Unlock()
+		var trialOut chan<- *grpcapi.StoredTrialSample = out
+		closeTrialOut := func() {}
+		if !sampleOrderingKey.IsTickID() {
+			// This trial's samples need to be sorted before being sent
+			unsortedOut := make(backend.TrialSampleObserver)
+			trialOut = unsortedOut
+			closeTrialOut = func() { close(unsortedOut) }
+			g.Go(func() error {
+				return backend.ForwardSortedSamples(ctx, sampleOrderingKey, filter.Reverse, unsortedOut, out)
+			})
+		}
+		if filter.Reverse {
+			g.Go(func() error {
+				defer closeTrialOut()
+				return b.observeStoredSamplesInReverse(ctx, trialID, td, storedSamples, reverseSampleIdxs, payloadBlobs, appliedFilter, trialOut)
+			})
+			continue
+		}
 		observer := make(utils.ObservableListObserver)
+		trialCtx, endTrialObservation := context.WithCancel(ctx)
 		g.Go(func() error {
 			defer close(observer)
-			err := td.storedSamples.Observe(ctx, 0, observer)
+			err := storedSamples.Observe(trialCtx, 0, observer)
+			if err != nil && ctx.Err() == nil && trialCtx.Err() != nil {
+				// The observation of this trial ended early as the filter's tick range was passed
+				return nil
+			}
 			return err
 		})
 		if appliedFilter.SelectsAll() {
 			// No filtering done on this trial's samples
 			g.Go(func() error {
-				for serializedSample := range observer {
-					sample := &grpcapi.StoredTrialSample{}
-					if err := proto.Unmarshal(serializedSample.([]byte), sample); err != nil {
-						return backend.NewUnexpectedError("unable to deserialize sample (%w)", err)
+				defer closeTrialOut()
+				defer endTrialObservation()
+				for sampleIdx := 0; ; sampleIdx++ {
+					serializedSample, ok := <-observer
+					if !ok {
+						break
+					}
+					sample, err := b.deserializeSample(payloadBlobs, serializedSample.([]byte))
+					if errors.Is(err, errCorruptedSample) {
+						return td.corruptedSampleError(trialID, sampleIdx)
+					}
+					if err != nil {
+						return err
+					}
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case trialOut <- sample:
 					}
-					out <- sample
 				}
 				return nil
 			})
 		} else {
 			// Some filtering done on this trial samples
 			g.Go(func() error {
-				for serializedSample := range observer {
-					sample := &grpcapi.StoredTrialSample{}
-					if err := proto.Unmarshal(serializedSample.([]byte), sample); err != nil {
-						return backend.NewUnexpectedError("unable to deserialize sample (%w)", err)
+				defer closeTrialOut()
+				defer endTrialObservation()
+				for sampleIdx := 0; ; sampleIdx++ {
+					serializedSample, ok := <-observer
+					if !ok {
+						break
+					}
+					sample, err := b.deserializeSample(payloadBlobs, serializedSample.([]byte))
+					if errors.Is(err, errCorruptedSample) {
+						return td.corruptedSampleError(trialID, sampleIdx)
+					}
+					if err != nil {
+						return err
+					}
+					if appliedFilter.IsPastTickRange(sample.TickId) {
+						// Unless they are stored in tick order, the following samples could still be in the range
+						if td.ticksOrdered(storedSamples) {
+							endTrialObservation()
+						}
+						continue
 					}
 					filteredSample := appliedFilter.Filter(sample)
-					out <- filteredSample
+					if filteredSample == nil {
+						continue
+					}
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case trialOut <- filteredSample:
+					}
 				}
 				return nil
 			})
@@ -416,3 +1318,149 @@ func (b *memoryBackend) ObserveSamples(ctx context.Context, filter backend.Trial
 	}
 	return g.Wait()
 }
+
+func (b *memoryBackend) RetrieveLatestSample(ctx context.Context, trialID string, filter backend.TrialSampleFilter) (*grpcapi.StoredTrialSample, error) {
+	trialDatas, err := b.retrieveTrialDatas([]string{trialID})
+	if err != nil {
+		return nil, err
+	}
+	td := trialDatas[0]
+	td.samplesMutex.Lock()
+	if td.deleted {
+		td.samplesMutex.Unlock()
+		return nil, &backend.UnknownTrialError{TrialID: trialID}
+	}
+	if td.storedSamples.Len() == 0 {
+		td.samplesMutex.Unlock()
+		if td.hasEvictedSamples {
+			return nil, &backend.EvictedSamplesError{TrialID: trialID, MinTickID: td.evictedMinTickID, MaxTickID: td.evictedMaxTickID}
+		}
+		return nil, nil
+	}
+	// The largest tick id is tracked as samples are added, no need to go through the stored samples
+	sampleIdx := td.storedSamplesIdx[td.maxTickID]
+	serializedSample, _ := td.storedSamples.Item(sampleIdx)
+	payloadBlobs := td.payloadBlobs
+	params := td.params
+	td.samplesMutex.Unlock()
+
+	err = filter.CheckTrialParams(trialID, params)
+	if err != nil {
+		return nil, err
+	}
+	sample, err := b.deserializeSample(payloadBlobs, serializedSample.([]byte))
+	if errors.Is(err, errCorruptedSample) {
+		return nil, td.corruptedSampleError(trialID, sampleIdx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	filter.FromTickID, filter.ToTickID = nil, nil
+	return backend.NewAppliedTrialSampleFilter(filter, params).Filter(sample), nil
+}
+
+// RetrieveSamplePayload extracts the payload from the stored serialized sample, resolving and decompressing it alone
+func (b *memoryBackend) RetrieveSamplePayload(ctx context.Context, trialID string, tickID uint64, payloadIdx uint32) ([]byte, error) {
+	trialDatas, err := b.retrieveTrialDatas([]string{trialID})
+	if err != nil {
+		return nil, err
+	}
+	td := trialDatas[0]
+	td.samplesMutex.Lock()
+	if td.deleted {
+		td.samplesMutex.Unlock()
+		return nil, &backend.UnknownTrialError{TrialID: trialID}
+	}
+	sampleIdx, stored := td.storedSamplesIdx[tickID]
+	if !stored {
+		defer td.samplesMutex.Unlock()
+		if td.hasEvictedSamples && tickID >= td.evictedMinTickID && tickID <= td.evictedMaxTickID {
+			return nil, &backend.EvictedSamplesError{TrialID: trialID, MinTickID: td.evictedMinTickID, MaxTickID: td.evictedMaxTickID}
+		}
+		return nil, &backend.UnknownSampleError{
+			TrialID:            trialID,
+			TickID:             tickID,
+			StoredSamplesCount: td.storedSamples.Len(),
+			MinTickID:          td.minTickID,
+			MaxTickID:          td.maxTickID,
+		}
+	}
+	storedSample, _ := td.storedSamples.Item(sampleIdx)
+	payloadBlobs := td.payloadBlobs
+	td.samplesMutex.Unlock()
+
+	serializedSample, valid := b.splitSampleChecksum(storedSample.([]byte))
+	if !valid {
+		return nil, &backend.CorruptedSampleError{TrialID: trialID, TickID: tickID}
+	}
+	payload, found, err := backend.ExtractSerializedSamplePayload(serializedSample, payloadIdx)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &backend.UnknownPayloadError{TrialID: trialID, TickID: tickID, PayloadIdx: payloadIdx}
+	}
+	if payloadBlobs != nil {
+		payload, err = payloadBlobs.get(payload)
+		if err != nil {
+			return nil, backend.NewUnexpectedError("unable to resolve payload #%d of sample %d (%w)", payloadIdx, tickID, err)
+		}
+	} else {
+		// The stored samples are shared by concurrent retrievals, they can't be altered
+		payload = append([]byte{}, payload...)
+	}
+	return backend.DecompressPayload(payload, b.payloadCompression)
+}
+
+// EstimateSamples filters every currently stored sample, the estimate is exact
+func (b *memoryBackend) EstimateSamples(ctx context.Context, filter backend.TrialSampleFilter) (backend.SamplesEstimate, error) {
+	estimate := backend.SamplesEstimate{Exact: true}
+	trialDatas, err := b.retrieveTrialDatas(filter.TrialIDs)
+	if err != nil {
+		return estimate, err
+	}
+	for idx, td := range trialDatas {
+		trialID := filter.TrialIDs[idx]
+		err := td.evictedSamplesError(trialID, filter)
+		if err != nil {
+			return estimate, err
+		}
+		params := td.trialParams(trialID).Params
+		err = filter.CheckTrialParams(trialID, params)
+		if err != nil {
+			return estimate, err
+		}
+		appliedFilter := backend.NewAppliedTrialSampleFilter(filter, params)
+		td.samplesMutex.Lock()
+		storedSamples, payloadBlobs := td.storedSamples, td.payloadBlobs
+		storedSamplesCount := storedSamples.Len()
+		ticksOrdered := !td.unorderedTicks
+		td.samplesMutex.Unlock()
+		for sampleIdx := 0; sampleIdx < storedSamplesCount; sampleIdx++ {
+			if err := ctx.Err(); err != nil {
+				return estimate, err
+			}
+			serializedSample, _ := storedSamples.Item(sampleIdx)
+			sample, err := b.deserializeSample(payloadBlobs, serializedSample.([]byte))
+			if errors.Is(err, errCorruptedSample) {
+				return estimate, td.corruptedSampleError(trialID, sampleIdx)
+			}
+			if err != nil {
+				return estimate, err
+			}
+			if appliedFilter.IsPastTickRange(sample.TickId) {
+				if ticksOrdered {
+					break
+				}
+				continue
+			}
+			filteredSample := appliedFilter.Filter(sample)
+			if filteredSample == nil {
+				continue
+			}
+			estimate.SamplesCount++
+			estimate.SamplesSize += proto.Size(filteredSample)
+		}
+	}
+	return estimate, nil
+}
@@ -16,6 +16,7 @@ package memoryBackend
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -24,6 +25,9 @@ import (
 	"github.com/cogment/cogment-trial-datastore/backend"
 	"github.com/cogment/cogment-trial-datastore/backend/test"
 	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/openlyinc/pointy"
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
 )
 
 var nextTickID uint64 // = 0
@@ -73,6 +77,136 @@ func BenchmarkMemoryBackend(b *testing.B) {
 	})
 }
 
+func TestSuiteMemoryBackendPayloadCompression(t *testing.T) {
+	test.RunSuite(t, func() backend.Backend {
+		options := DefaultOptions
+		options.PayloadCompression = backend.ZstdPayloadCompression
+		b, err := CreateMemoryBackendWithOptions(options)
+		assert.NoError(t, err)
+		return b
+	}, func(b backend.Backend) {
+		mb := b.(*memoryBackend)
+		mb.Destroy()
+	})
+}
+
+func BenchmarkMemoryBackendPayloadCompression(b *testing.B) {
+	for _, payloadCompression := range []backend.PayloadCompression{backend.ZstdPayloadCompression, backend.LZ4PayloadCompression} {
+		b.Run(string(payloadCompression), func(b *testing.B) {
+			test.RunBenchmarks(b, func() backend.Backend {
+				options := DefaultOptions
+				options.PayloadCompression = payloadCompression
+				bck, err := CreateMemoryBackendWithOptions(options)
+				assert.NoError(b, err)
+				return bck
+			}, func(bck backend.Backend) {
+				mb := bck.(*memoryBackend)
+				mb.Destroy()
+			})
+		})
+	}
+}
+
+func TestSuiteMemoryBackendPayloadDeduplication(t *testing.T) {
+	test.RunSuite(t, func() backend.Backend {
+		options := DefaultOptions
+		options.PayloadCompression = backend.ZstdPayloadCompression
+		options.DeduplicatePayloads = true
+		b, err := CreateMemoryBackendWithOptions(options)
+		assert.NoError(t, err)
+		return b
+	}, func(b backend.Backend) {
+		mb := b.(*memoryBackend)
+		mb.Destroy()
+	})
+}
+
+func TestSuiteMemoryBackendSampleChecksums(t *testing.T) {
+	test.RunSuite(t, func() backend.Backend {
+		options := DefaultOptions
+		options.SampleChecksums = true
+		b, err := CreateMemoryBackendWithOptions(options)
+		assert.NoError(t, err)
+		return b
+	}, func(b backend.Backend) {
+		mb := b.(*memoryBackend)
+		mb.Destroy()
+	})
+}
+
+func TestPayloadDeduplication(t *testing.T) {
+	options := DefaultOptions
+	options.DeduplicatePayloads = true
+	b, err := CreateMemoryBackendWithOptions(options)
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: generateTrialParams(1, 100)}})
+	assert.NoError(t, err)
+
+	observation := make([]byte, 1024)
+	for idx := range observation {
+		observation[idx] = byte(idx)
+	}
+	samples := make([]*grpcapi.StoredTrialSample, 100)
+	for tickID := range samples {
+		samples[tickID] = &grpcapi.StoredTrialSample{
+			TrialId:      "my-trial",
+			TickId:       uint64(tickID),
+			State:        grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Observation: pointy.Uint32(0), Action: pointy.Uint32(1)}},
+			Payloads:     [][]byte{observation, {byte(tickID % 2)}}, // Unchanged observation and two distinct actions
+		}
+	}
+	samples[99].State = grpcapi.TrialState_ENDED
+	err = b.AddSamples(context.Background(), samples)
+	assert.NoError(t, err)
+
+	// The 100 identical observations are stored once
+	data := b.(*memoryBackend).trials["my-trial"]
+	assert.Equal(t, 3, data.payloadBlobs.getBlobsCount())
+	assert.Equal(t, uint32(1024+2), data.payloadBlobs.getSize())
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Less(t, trialsInfo.TrialInfos[0].StoredSamplesSize, 100*1024)
+
+	observeSamples := func(fields []grpcapi.StoredTrialSampleField) []*grpcapi.StoredTrialSample {
+		observer := make(backend.TrialSampleObserver)
+		go func() {
+			defer close(observer)
+			err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"my-trial"}, Fields: fields}, observer)
+			assert.NoError(t, err)
+		}()
+		observedSamples := []*grpcapi.StoredTrialSample{}
+		for sample := range observer {
+			observedSamples = append(observedSamples, sample)
+		}
+		return observedSamples
+	}
+
+	// Filtering drops the payloads references
+	for _, sample := range observeSamples([]grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION}) {
+		assert.Nil(t, sample.Payloads[0])
+		assert.Equal(t, []byte{byte(sample.TickId % 2)}, sample.Payloads[1])
+		// Altering a retrieved payload doesn't alter the stored blob
+		sample.Payloads[1][0] = 42
+	}
+
+	// Samples are retrieved fully materialized
+	observedSamples := observeSamples(nil)
+	assert.Len(t, observedSamples, 100)
+	for tickID, sample := range observedSamples {
+		assert.True(t, proto.Equal(samples[tickID], sample))
+		sample.Payloads[0][0] = 42
+	}
+	assert.True(t, proto.Equal(samples[0], observeSamples(nil)[0]))
+
+	// Clearing the samples drops the blobs
+	err = b.ClearSamples(context.Background(), "my-trial")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, b.(*memoryBackend).trials["my-trial"].payloadBlobs.getBlobsCount())
+}
+
 func TestTriaEviction(t *testing.T) {
 	// Uncomment to see the log from the trial eviction worker
 	// log.SetLevel(log.DebugLevel)
@@ -151,3 +285,604 @@ func TestTriaEviction(t *testing.T) {
 	}
 
 }
+
+func TestTrialEvictionLeastRecentlyRead(t *testing.T) {
+	b, err := CreateMemoryBackend(100000) // Should be enough for 2 trials worth of sample data.
+	assert.NoError(t, err)
+	assert.NotNil(t, b)
+	defer b.Destroy()
+
+	for _, trialID := range []string{"trial-1", "trial-2", "trial-3"} {
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{
+			TrialID: trialID,
+			Params:  generateTrialParams(12, 100),
+		}})
+		assert.NoError(t, err)
+	}
+
+	samplesCount := 1000
+	firstTickIDs := map[string]uint64{}
+	addSamples := func(trialID string) {
+		firstTickIDs[trialID] = nextTickID
+		for i := 0; i < samplesCount; i++ {
+			err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample(trialID, 12, i == samplesCount-1)})
+			assert.NoError(t, err)
+		}
+		time.Sleep(100 * time.Millisecond) // Give time to the trial eviction worker
+	}
+	observeSamples := func(filter backend.TrialSampleFilter) (int, error) {
+		observer := make(backend.TrialSampleObserver)
+		observeErr := make(chan error, 1)
+		go func() {
+			defer close(observer)
+			observeErr <- b.ObserveSamples(context.Background(), filter, observer)
+		}()
+		observedSamplesCount := 0
+		for range observer {
+			observedSamplesCount++
+		}
+		return observedSamplesCount, <-observeErr
+	}
+
+	addSamples("trial-1")
+	addSamples("trial-2")
+
+	// Reading trial-1 makes trial-2 the least recently used trial
+	observedSamplesCount, err := observeSamples(backend.TrialSampleFilter{TrialIDs: []string{"trial-1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, samplesCount, observedSamplesCount)
+
+	// Going past the budget evicts the samples of trial-2
+	addSamples("trial-3")
+	r, err := b.RetrieveTrials(context.Background(), []string{"trial-1", "trial-2", "trial-3"}, 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, samplesCount, r.TrialInfos[0].StoredSamplesCount)
+	assert.Equal(t, samplesCount, r.TrialInfos[1].SamplesCount)
+	assert.Equal(t, 0, r.TrialInfos[1].StoredSamplesCount)
+	assert.Equal(t, samplesCount, r.TrialInfos[2].StoredSamplesCount)
+
+	// The params of trial-2 are retained
+	trialsParams, err := b.GetTrialParams(context.Background(), []string{"trial-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(100), trialsParams[0].Params.MaxSteps)
+
+	// Retrieving the evicted samples fails instead of returning nothing
+	_, err = observeSamples(backend.TrialSampleFilter{TrialIDs: []string{"trial-2"}})
+	var evictedSamplesErr *backend.EvictedSamplesError
+	assert.ErrorAs(t, err, &evictedSamplesErr)
+	assert.Equal(t, "trial-2", evictedSamplesErr.TrialID)
+	assert.Equal(t, firstTickIDs["trial-2"], evictedSamplesErr.MinTickID)
+	assert.Equal(t, firstTickIDs["trial-2"]+uint64(samplesCount-1), evictedSamplesErr.MaxTickID)
+
+	// Tick ranges outside of the evicted samples can still be retrieved
+	_, err = observeSamples(backend.TrialSampleFilter{TrialIDs: []string{"trial-2"}, FromTickID: pointy.Uint64(firstTickIDs["trial-3"])})
+	assert.NoError(t, err)
+
+	// Once cleared, the trial is retrievable again
+	err = b.ClearSamples(context.Background(), "trial-2")
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample("trial-2", 12, true)})
+	assert.NoError(t, err)
+	observedSamplesCount, err = observeSamples(backend.TrialSampleFilter{TrialIDs: []string{"trial-2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, observedSamplesCount)
+}
+
+func TestTrialEvictionRefilledTrial(t *testing.T) {
+	b, err := CreateMemoryBackend(100000) // Less than a batch of the trial samples.
+	assert.NoError(t, err)
+	defer b.Destroy()
+	mb := b.(*memoryBackend)
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "trial-1", Params: generateTrialParams(12, 100)}})
+	assert.NoError(t, err)
+
+	addSamples := func() {
+		for i := 0; i < 5000; i++ {
+			err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample("trial-1", 12, true)})
+			assert.NoError(t, err)
+		}
+	}
+
+	addSamples()
+	assert.Eventually(t, func() bool { return mb.getSampleSize() <= mb.maxSamplesSize }, time.Second, 10*time.Millisecond)
+
+	// The samples added after the eviction are evicted in turn
+	addSamples()
+	assert.Eventually(t, func() bool { return mb.getSampleSize() <= mb.maxSamplesSize }, time.Second, 10*time.Millisecond)
+	r, err := b.RetrieveTrials(context.Background(), []string{"trial-1"}, 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 10000, r.TrialInfos[0].SamplesCount)
+	assert.Equal(t, 0, r.TrialInfos[0].StoredSamplesCount)
+}
+
+func TestTrialEvictionConcurrentAdditions(t *testing.T) {
+	// Meant to be run with `-race`, samples added to an ended trial race its eviction
+	b, err := CreateMemoryBackend(100000)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	mb := b.(*memoryBackend)
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: generateTrialParams(2, 100)}})
+	assert.NoError(t, err)
+
+	evictionCtx, stopEviction := context.WithCancel(context.Background())
+	evictionDone := make(chan struct{})
+	go func() {
+		defer close(evictionDone)
+		for evictionCtx.Err() == nil {
+			mb.evictLruTrials(evictionCtx)
+		}
+	}()
+
+	// Large batches of ended samples, the trial is evicted while they are being added
+	batchesCount := 2
+	batchSize := 2000
+	for batchIdx := 0; batchIdx < batchesCount; batchIdx++ {
+		samples := make([]*grpcapi.StoredTrialSample, batchSize)
+		for sampleIdx := range samples {
+			samples[sampleIdx] = &grpcapi.StoredTrialSample{
+				TrialId:  "my-trial",
+				TickId:   uint64(batchIdx*batchSize + sampleIdx),
+				State:    grpcapi.TrialState_ENDED,
+				Payloads: [][]byte{make([]byte, 10000)},
+			}
+		}
+		err := b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+	}
+	stopEviction()
+	<-evictionDone
+
+	// The counters match the stored samples
+	data := mb.trials["my-trial"]
+	data.samplesMutex.Lock()
+	defer data.samplesMutex.Unlock()
+	assert.Equal(t, batchesCount*batchSize, data.samplesCount)
+	assert.Equal(t, data.storedSamplesSize, mb.getSampleSize())
+	assert.Equal(t, uint32(data.storedSamples.Len()), atomic.LoadUint32(&mb.samplesCount))
+}
+
+func TestConcurrentTrialParamsUpdates(t *testing.T) {
+	// Meant to be run with `-race`, the params of a trial are read while they are updated
+	b, err := CreateMemoryBackend(DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	trialParams := func(maxSteps uint32) *grpcapi.TrialParams {
+		return &grpcapi.TrialParams{MaxSteps: maxSteps, Actors: []*grpcapi.ActorParams{{Name: "actor-0"}, {Name: "actor-1"}}}
+	}
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: trialParams(100)}})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample("my-trial", 2, true)})
+	assert.NoError(t, err)
+
+	updatesDone := make(chan struct{})
+	go func() {
+		defer close(updatesDone)
+		for i := 0; i < 100; i++ {
+			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: trialParams(uint32(i))}})
+			assert.NoError(t, err)
+		}
+	}()
+
+	filter := backend.TrialSampleFilter{TrialIDs: []string{"my-trial"}, ActorNames: []string{"actor-0"}}
+	for i := 0; i < 100; i++ {
+		_, err := b.GetTrialParams(context.Background(), []string{"my-trial"})
+		assert.NoError(t, err)
+		_, err = b.RetrieveLatestSample(context.Background(), "my-trial", filter)
+		assert.NoError(t, err)
+		_, err = b.EstimateSamples(context.Background(), filter)
+		assert.NoError(t, err)
+		observer := make(backend.TrialSampleObserver)
+		go func() {
+			defer close(observer)
+			err := b.ObserveSamples(context.Background(), filter, observer)
+			assert.NoError(t, err)
+		}()
+		for range observer {
+		}
+	}
+	<-updatesDone
+}
+
+func TestMaxTrialsCountRejectNewTrials(t *testing.T) {
+	b, err := CreateMemoryBackendWithOptions(Options{
+		MaxSamplesSize:       DefaultMaxSampleSize,
+		MaxTrialsCount:       2,
+		MaxTrialsCountPolicy: RejectNewTrials,
+	})
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: generateTrialParams(2, 100)},
+		{TrialID: "trial-2", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+
+	// Updating existing trials is fine
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-2", Params: generateTrialParams(4, 100)},
+	})
+	assert.NoError(t, err)
+
+	// Creating a new one is not
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: generateTrialParams(4, 100)},
+		{TrialID: "trial-3", Params: generateTrialParams(2, 100)},
+	})
+	var tooManyTrialsErr *backend.TooManyTrialsError
+	assert.ErrorAs(t, err, &tooManyTrialsErr)
+	assert.Equal(t, 2, tooManyTrialsErr.MaxTrialsCount)
+
+	// Nothing was changed by the rejected call
+	trialsParams, err := b.GetTrialParams(context.Background(), []string{"trial-1"})
+	assert.NoError(t, err)
+	assert.Len(t, trialsParams[0].Params.Actors, 2)
+
+	r, err := b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"trial-1", "trial-2"}, []string{r.TrialInfos[0].TrialID, r.TrialInfos[1].TrialID})
+
+	// Deleting a trial makes room for a new one
+	err = b.DeleteTrials(context.Background(), []string{"trial-1"})
+	assert.NoError(t, err)
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-3", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+}
+
+func TestMaxTrialsCountEvictOldestTrials(t *testing.T) {
+	b, err := CreateMemoryBackendWithOptions(Options{
+		MaxSamplesSize:       DefaultMaxSampleSize,
+		MaxTrialsCount:       2,
+		MaxTrialsCountPolicy: EvictOldestTrials,
+	})
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	for _, trialID := range []string{"trial-1", "trial-2", "trial-3"} {
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: trialID, Params: generateTrialParams(2, 100)},
+		})
+		assert.NoError(t, err)
+	}
+
+	r, err := b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+	assert.NoError(t, err)
+	assert.Len(t, r.TrialInfos, 2)
+	assert.Equal(t, "trial-2", r.TrialInfos[0].TrialID)
+	assert.Equal(t, "trial-3", r.TrialInfos[1].TrialID)
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-4", Params: generateTrialParams(2, 100)},
+		{TrialID: "trial-5", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+
+	r, err = b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+	assert.NoError(t, err)
+	assert.Len(t, r.TrialInfos, 2)
+	assert.Equal(t, "trial-4", r.TrialInfos[0].TrialID)
+	assert.Equal(t, "trial-5", r.TrialInfos[1].TrialID)
+}
+
+func TestEvictionHook(t *testing.T) {
+	evictedTrialIDs := []string{}
+	b, err := CreateMemoryBackendWithOptions(Options{
+		MaxSamplesSize:       DefaultMaxSampleSize,
+		MaxTrialsCount:       2,
+		MaxTrialsCountPolicy: EvictOldestTrials,
+		EvictionHook: func(ctx context.Context, trial *EvictedTrial) bool {
+			assert.False(t, trial.SamplesOnly)
+			if trial.TrialID == "trial-2" {
+				// Vetoing the eviction of trial-2
+				return false
+			}
+			if trial.TrialID == "trial-1" {
+				assert.Len(t, trial.Samples, 2)
+				assert.Equal(t, grpcapi.TrialState_ENDED, trial.State)
+			}
+			evictedTrialIDs = append(evictedTrialIDs, trial.TrialID)
+			return true
+		},
+		EvictionHookTimeout: DefaultEvictionHookTimeout,
+	})
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: generateTrialParams(2, 100)},
+		{TrialID: "trial-2", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample("trial-1", 2, false), generateSample("trial-1", 2, true)})
+	assert.NoError(t, err)
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-3", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"trial-1"}, evictedTrialIDs)
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-4", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"trial-1", "trial-3"}, evictedTrialIDs)
+
+	r, err := b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"trial-2", "trial-4"}, []string{r.TrialInfos[0].TrialID, r.TrialInfos[1].TrialID})
+}
+
+func TestEvictionHookVetoAll(t *testing.T) {
+	b, err := CreateMemoryBackendWithOptions(Options{
+		MaxSamplesSize:       DefaultMaxSampleSize,
+		MaxTrialsCount:       1,
+		MaxTrialsCountPolicy: EvictOldestTrials,
+		EvictionHook: func(ctx context.Context, trial *EvictedTrial) bool {
+			return false
+		},
+		EvictionHookTimeout: DefaultEvictionHookTimeout,
+	})
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-2", Params: generateTrialParams(2, 100)},
+	})
+	var tooManyTrialsErr *backend.TooManyTrialsError
+	assert.ErrorAs(t, err, &tooManyTrialsErr)
+}
+
+func TestEvictionHookUnlocked(t *testing.T) {
+	var b backend.Backend
+	b, err := CreateMemoryBackendWithOptions(Options{
+		MaxSamplesSize:       DefaultMaxSampleSize,
+		MaxTrialsCount:       1,
+		MaxTrialsCountPolicy: EvictOldestTrials,
+		// Without any timeout the default one is used
+		EvictionHook: func(ctx context.Context, trial *EvictedTrial) bool {
+			// The backend isn't locked while the hook runs
+			exist, err := b.TrialsExist(ctx, []string{trial.TrialID})
+			assert.NoError(t, err)
+			assert.Equal(t, []bool{true}, exist)
+			return false
+		},
+	})
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-2", Params: generateTrialParams(2, 100)},
+	})
+	var tooManyTrialsErr *backend.TooManyTrialsError
+	assert.ErrorAs(t, err, &tooManyTrialsErr)
+}
+
+func TestEvictionHookTimeout(t *testing.T) {
+	b, err := CreateMemoryBackendWithOptions(Options{
+		MaxSamplesSize:       DefaultMaxSampleSize,
+		MaxTrialsCount:       1,
+		MaxTrialsCountPolicy: EvictOldestTrials,
+		EvictionHook: func(ctx context.Context, trial *EvictedTrial) bool {
+			<-ctx.Done()
+			return false
+		},
+		EvictionHookTimeout: 10 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	for _, trialID := range []string{"trial-1", "trial-2"} {
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: trialID, Params: generateTrialParams(2, 100)},
+		})
+		assert.NoError(t, err)
+	}
+
+	r, err := b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+	assert.NoError(t, err)
+	assert.Len(t, r.TrialInfos, 1)
+	assert.Equal(t, "trial-2", r.TrialInfos[0].TrialID)
+}
+
+func TestDeterministicSerialization(t *testing.T) {
+	options := DefaultOptions
+	options.DeterministicSerialization = true
+	b, err := CreateMemoryBackendWithOptions(options)
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+
+	sample := generateSample("trial-1", 2, false)
+	sample.ActorSamples[0] = &grpcapi.StoredTrialActorSample{Actor: 0, Observation: pointy.Uint32(0), Reward: pointy.Float32(0.5)}
+	sample.ActorSamples[1] = &grpcapi.StoredTrialActorSample{Actor: 1, Action: pointy.Uint32(1)}
+	sample.Payloads = [][]byte{[]byte("an observation"), []byte("an action")}
+	equalSample := proto.Clone(sample).(*grpcapi.StoredTrialSample)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{sample, equalSample})
+	assert.NoError(t, err)
+
+	mb := b.(*memoryBackend)
+	serializedSample, ok := mb.trials["trial-1"].storedSamples.Item(0)
+	assert.True(t, ok)
+	serializedEqualSample, ok := mb.trials["trial-1"].storedSamples.Item(1)
+	assert.True(t, ok)
+	assert.Equal(t, serializedSample, serializedEqualSample)
+}
+
+func TestClearSamplesEndsOngoingObservations(t *testing.T) {
+	b, err := CreateMemoryBackend(DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample("trial-1", 2, false)})
+	assert.NoError(t, err)
+
+	observer := make(backend.TrialSampleObserver)
+	go func() {
+		defer close(observer)
+		err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"trial-1"}}, observer)
+		assert.NoError(t, err)
+	}()
+	<-observer
+
+	err = b.ClearSamples(context.Background(), "trial-1")
+	assert.NoError(t, err)
+
+	// The ongoing observation ends without retrieving later samples
+	_, ok := <-observer
+	assert.False(t, ok)
+}
+
+func TestReindex(t *testing.T) {
+	b, err := CreateMemoryBackend(DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: generateTrialParams(2, 100)},
+		{TrialID: "trial-2", Params: generateTrialParams(2, 100)},
+	})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+		generateSample("trial-1", 2, false),
+		generateSample("trial-1", 2, false),
+		generateSample("trial-2", 2, true),
+	})
+	assert.NoError(t, err)
+
+	mb := b.(*memoryBackend)
+	expectedSamplesSize := mb.samplesSize
+	expectedStoredSamplesIdx := mb.trials["trial-1"].storedSamplesIdx
+	expectedTrial2StoredSamplesSize := mb.trials["trial-2"].storedSamplesSize
+
+	// Corrupting the indices
+	mb.trialsMutex.Lock()
+	mb.trialsCount = 12
+	mb.samplesSize = 0
+	mb.trials["trial-1"].storedSamplesIdx = map[uint64]int{}
+	mb.trials["trial-2"].storedSamplesSize = 0
+	mb.trials["trial-2"].trialState = grpcapi.TrialState_UNKNOWN
+	mb.trialsMutex.Unlock()
+
+	err = b.Reindex(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, mb.trialsCount)
+	assert.Equal(t, expectedSamplesSize, mb.samplesSize)
+	assert.Equal(t, expectedStoredSamplesIdx, mb.trials["trial-1"].storedSamplesIdx)
+	assert.Equal(t, expectedTrial2StoredSamplesSize, mb.trials["trial-2"].storedSamplesSize)
+	assert.Equal(t, grpcapi.TrialState_ENDED, mb.trials["trial-2"].trialState)
+
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"trial-1"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Len(t, trialsInfo.TrialInfos, 1)
+	assert.Equal(t, 2, trialsInfo.TrialInfos[0].StoredSamplesCount)
+}
+
+func TestCreateRegisteredBackend(t *testing.T) {
+	settings := viper.New()
+	settings.Set("MEMORY_STORAGE_MAX_SAMPLE_SIZE", 1024)
+	settings.Set("MEMORY_STORAGE_MAX_TRIALS_COUNT", 12)
+	settings.Set("MEMORY_STORAGE_MAX_TRIALS_COUNT_POLICY", "evict")
+	settings.Set("MEMORY_STORAGE_PAYLOAD_DEDUPLICATION", true)
+
+	b, err := backend.CreateBackend(BackendName, backend.FactoryOptions{
+		PayloadCompression: backend.ZstdPayloadCompression,
+		Settings:           settings,
+	})
+	assert.NoError(t, err)
+	defer b.Destroy()
+	mb := b.(*memoryBackend)
+	assert.Equal(t, uint32(1024), mb.maxSamplesSize)
+	assert.Equal(t, 12, mb.maxTrialsCount)
+	assert.Equal(t, EvictOldestTrials, mb.maxTrialsCountPolicy)
+	assert.True(t, mb.deduplicatePayloads)
+	assert.Equal(t, backend.ZstdPayloadCompression, mb.payloadCompression)
+
+	// Without settings the default options are used
+	b, err = backend.CreateBackend(BackendName, backend.FactoryOptions{})
+	assert.NoError(t, err)
+	defer b.Destroy()
+	assert.Equal(t, DefaultMaxSampleSize, b.(*memoryBackend).maxSamplesSize)
+
+	settings.Set("MEMORY_STORAGE_MAX_TRIALS_COUNT_POLICY", "foo")
+	_, err = backend.CreateBackend(BackendName, backend.FactoryOptions{Settings: settings})
+	assert.Error(t, err)
+}
+
+func TestCorruptedSample(t *testing.T) {
+	for _, verifySampleChecksums := range []bool{true, false} {
+		options := DefaultOptions
+		options.SampleChecksums = true
+		options.VerifySampleChecksums = verifySampleChecksums
+		b, err := CreateMemoryBackendWithOptions(options)
+		assert.NoError(t, err)
+		defer b.Destroy()
+
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "my-trial", Params: generateTrialParams(1, 100)},
+		})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "my-trial", TickId: 4, State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{[]byte("an observation")}},
+			{TrialId: "my-trial", TickId: 5, State: grpcapi.TrialState_ENDED, Payloads: [][]byte{[]byte("another observation")}},
+		})
+		assert.NoError(t, err)
+
+		// Flipping a byte of the checksum of the stored sample of tick 5
+		mb := b.(*memoryBackend)
+		storedSample, _ := mb.trials["my-trial"].storedSamples.Item(1)
+		storedSample.([]byte)[len(storedSample.([]byte))-1] ^= 0x01
+
+		observer := make(backend.TrialSampleObserver)
+		observeErr := make(chan error, 1)
+		go func() {
+			defer close(observer)
+			observeErr <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"my-trial"}}, observer)
+		}()
+		samples := []*grpcapi.StoredTrialSample{}
+		for sample := range observer {
+			samples = append(samples, sample)
+		}
+		err = <-observeErr
+
+		if verifySampleChecksums {
+			assert.Equal(t, &backend.CorruptedSampleError{TrialID: "my-trial", TickID: 5}, err)
+			assert.Len(t, samples, 1)
+
+			err = b.AddSamplePartial(context.Background(), &grpcapi.StoredTrialSample{TrialId: "my-trial", TickId: 5, State: grpcapi.TrialState_ENDED})
+			assert.Equal(t, &backend.CorruptedSampleError{TrialID: "my-trial", TickID: 5}, err)
+
+			err = b.Reindex(context.Background())
+			assert.Equal(t, &backend.CorruptedSampleError{TrialID: "my-trial", TickID: 5}, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Len(t, samples, 2)
+			assert.Equal(t, []byte("another observation"), samples[1].Payloads[0])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memoryBackend
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+type payloadRef = [sha256.Size]byte
+
+// payloadBlobStore stores, once, each distinct payload of the samples of a trial, addressed by its content hash.
+//
+// Blobs are never removed individually, the whole store is dropped along with the trial samples. Stores are replaced
+// instead of emptied so that ongoing observations can still resolve the samples they already retrieved.
+type payloadBlobStore struct {
+	blobs map[payloadRef][]byte
+	size  uint32 // Cumulated size, in bytes, of the stored blobs
+	mutex sync.RWMutex
+}
+
+func createPayloadBlobStore() *payloadBlobStore {
+	return &payloadBlobStore{
+		blobs: make(map[payloadRef][]byte),
+	}
+}
+
+// add stores the given payload if it isn't already, returns its reference and the number of bytes added to the store
+func (s *payloadBlobStore) add(payload []byte) (payloadRef, uint32) {
+	ref := sha256.Sum256(payload)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.blobs[ref]; exists {
+		return ref, 0
+	}
+	blob := make([]byte, len(payload))
+	copy(blob, payload)
+	s.blobs[ref] = blob
+	s.size += uint32(len(blob))
+	return ref, uint32(len(blob))
+}
+
+// get returns a copy of the referenced payload, copies are returned so that the shared blob can't be altered
+func (s *payloadBlobStore) get(serializedRef []byte) ([]byte, error) {
+	if len(serializedRef) != sha256.Size {
+		return nil, fmt.Errorf("invalid payload reference of %dB", len(serializedRef))
+	}
+	var ref payloadRef
+	copy(ref[:], serializedRef)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	blob, exists := s.blobs[ref]
+	if !exists {
+		return nil, fmt.Errorf("unknown payload reference %x", ref)
+	}
+	payload := make([]byte, len(blob))
+	copy(payload, blob)
+	return payload, nil
+}
+
+func (s *payloadBlobStore) getSize() uint32 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.size
+}
+
+func (s *payloadBlobStore) getBlobsCount() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.blobs)
+}
+
+// dedupSamplePayloads stores the payloads of the given sample and returns a sample referencing them along with the
+// number of bytes added to the store, the given sample is left untouched
+func (s *payloadBlobStore) dedupSamplePayloads(sample *grpcapi.StoredTrialSample) (*grpcapi.StoredTrialSample, uint32) {
+	dedupedSample := &grpcapi.StoredTrialSample{
+		UserId:       sample.UserId,
+		TrialId:      sample.TrialId,
+		TickId:       sample.TickId,
+		Timestamp:    sample.Timestamp,
+		State:        sample.State,
+		ActorSamples: sample.ActorSamples,
+		Payloads:     make([][]byte, len(sample.Payloads)),
+	}
+	addedSize := uint32(0)
+	for payloadIdx, payload := range sample.Payloads {
+		ref, payloadAddedSize := s.add(payload)
+		dedupedSample.Payloads[payloadIdx] = ref[:]
+		addedSize += payloadAddedSize
+	}
+	return dedupedSample, addedSize
+}
+
+// resolveSamplePayloads replaces, in place, the payload references of the given sample by the payloads themselves
+func (s *payloadBlobStore) resolveSamplePayloads(sample *grpcapi.StoredTrialSample) error {
+	for payloadIdx, serializedRef := range sample.Payloads {
+		payload, err := s.get(serializedRef)
+		if err != nil {
+			return backend.NewUnexpectedError("unable to resolve payload #%d of sample %d (%w)", payloadIdx, sample.TickId, err)
+		}
+		sample.Payloads[payloadIdx] = payload
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memoryBackend
+
+import (
+	"github.com/cogment/cogment-trial-datastore/backend"
+)
+
+// BackendName is the name under which the memory backend is registered, it is the default backend
+const BackendName = "memory"
+
+func init() {
+	backend.Register(BackendName, createMemoryBackendFromFactoryOptions)
+}
+
+// createMemoryBackendFromFactoryOptions creates a memory backend configured by the `MEMORY_STORAGE_MAX_SAMPLE_SIZE`,
+// `MEMORY_STORAGE_MAX_TRIALS_COUNT`, `MEMORY_STORAGE_MAX_TRIALS_COUNT_POLICY` and `MEMORY_STORAGE_PAYLOAD_DEDUPLICATION`
+// settings, using the default options for the missing ones
+func createMemoryBackendFromFactoryOptions(factoryOptions backend.FactoryOptions) (backend.Backend, error) {
+	options := DefaultOptions
+	options.DeterministicSerialization = factoryOptions.DeterministicSerialization
+	options.PayloadCompression = factoryOptions.PayloadCompression
+	options.SerializationPooling = factoryOptions.SerializationPooling
+	options.SampleChecksums = factoryOptions.SampleChecksums
+	options.VerifySampleChecksums = factoryOptions.VerifySampleChecksums
+	options.EvictedTrialsCounter = factoryOptions.EvictedTrialsCounter
+	options.EvictedTrialsSamplesCounter = factoryOptions.EvictedTrialsSamplesCounter
+	if settings := factoryOptions.Settings; settings != nil {
+		if settings.IsSet("MEMORY_STORAGE_MAX_SAMPLE_SIZE") {
+			options.MaxSamplesSize = settings.GetUint32("MEMORY_STORAGE_MAX_SAMPLE_SIZE")
+		}
+		if settings.IsSet("MEMORY_STORAGE_MAX_TRIALS_COUNT") {
+			options.MaxTrialsCount = settings.GetInt("MEMORY_STORAGE_MAX_TRIALS_COUNT")
+		}
+		if settings.IsSet("MEMORY_STORAGE_MAX_TRIALS_COUNT_POLICY") {
+			maxTrialsCountPolicy, err := ParseMaxTrialsCountPolicy(settings.GetString("MEMORY_STORAGE_MAX_TRIALS_COUNT_POLICY"))
+			if err != nil {
+				return nil, err
+			}
+			options.MaxTrialsCountPolicy = maxTrialsCountPolicy
+		}
+		options.DeduplicatePayloads = settings.GetBool("MEMORY_STORAGE_PAYLOAD_DEDUPLICATION")
+	}
+	return CreateMemoryBackendWithOptions(options)
+}
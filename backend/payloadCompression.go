@@ -0,0 +1,165 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// PayloadCompression defines how the payloads of the stored samples are compressed
+type PayloadCompression string
+
+const (
+	NoPayloadCompression   PayloadCompression = "none"
+	ZstdPayloadCompression PayloadCompression = "zstd"
+	LZ4PayloadCompression  PayloadCompression = "lz4"
+)
+
+// ParsePayloadCompression parses a payload compression expressed as "none", "zstd" or "lz4", empty means "none"
+func ParsePayloadCompression(name string) (PayloadCompression, error) {
+	switch PayloadCompression(name) {
+	case "", NoPayloadCompression:
+		return NoPayloadCompression, nil
+	case ZstdPayloadCompression, LZ4PayloadCompression:
+		return PayloadCompression(name), nil
+	default:
+		return NoPayloadCompression, fmt.Errorf("unknown payload compression %q, expecting \"none\", \"zstd\" or \"lz4\"", name)
+	}
+}
+
+// IsNone returns true if the payloads are not compressed, the zero value is considered as no compression
+func (c PayloadCompression) IsNone() bool {
+	return c == "" || c == NoPayloadCompression
+}
+
+// zstd encoders and decoders are safe for concurrent use when encoding or decoding whole buffers
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+func compressPayload(payload []byte, compression PayloadCompression) ([]byte, error) {
+	if len(payload) == 0 {
+		// Filtered out payloads are left empty
+		return payload, nil
+	}
+	if compression.IsNone() {
+		return payload, nil
+	}
+	return appendCompressedPayload(nil, payload, compression)
+}
+
+// appendCompressedPayload appends the compressed payload to `dst`, reusing its capacity when it is large enough
+func appendCompressedPayload(dst []byte, payload []byte, compression PayloadCompression) ([]byte, error) {
+	if len(payload) == 0 {
+		return dst, nil
+	}
+	switch compression {
+	case ZstdPayloadCompression:
+		if cap(dst)-len(dst) < len(payload) {
+			grownDst := make([]byte, len(dst), len(dst)+len(payload))
+			copy(grownDst, dst)
+			dst = grownDst
+		}
+		return zstdEncoder.EncodeAll(payload, dst), nil
+	case LZ4PayloadCompression:
+		// lz4 blocks don't include the size of the uncompressed data, it is prepended as a varint
+		compressedPayloadBound := binary.MaxVarintLen64 + lz4.CompressBlockBound(len(payload))
+		if cap(dst)-len(dst) < compressedPayloadBound {
+			grownDst := make([]byte, len(dst), len(dst)+compressedPayloadBound)
+			copy(grownDst, dst)
+			dst = grownDst
+		}
+		compressedPayload := dst[len(dst) : len(dst)+compressedPayloadBound]
+		sizeLen := binary.PutUvarint(compressedPayload, uint64(len(payload)))
+		compressedLen, err := lz4.CompressBlock(payload, compressedPayload[sizeLen:], nil)
+		if err != nil {
+			return nil, err
+		}
+		return dst[:len(dst)+sizeLen+compressedLen], nil
+	default:
+		return append(dst, payload...), nil
+	}
+}
+
+func decompressPayload(payload []byte, compression PayloadCompression) ([]byte, error) {
+	if len(payload) == 0 {
+		return payload, nil
+	}
+	switch compression {
+	case ZstdPayloadCompression:
+		return zstdDecoder.DecodeAll(payload, nil)
+	case LZ4PayloadCompression:
+		size, sizeLen := binary.Uvarint(payload)
+		if sizeLen <= 0 {
+			return nil, fmt.Errorf("invalid lz4 payload size")
+		}
+		decompressedPayload := make([]byte, size)
+		decompressedLen, err := lz4.UncompressBlock(payload[sizeLen:], decompressedPayload)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(decompressedLen) != size {
+			return nil, fmt.Errorf("invalid lz4 payload, expected %d bytes got %d", size, decompressedLen)
+		}
+		return decompressedPayload, nil
+	default:
+		return payload, nil
+	}
+}
+
+// CompressSamplePayloads returns a copy of the given sample whose payloads are compressed.
+//
+// The given sample isn't modified, the returned sample shares everything but its payloads with it.
+func CompressSamplePayloads(sample *grpcapi.StoredTrialSample, compression PayloadCompression) (*grpcapi.StoredTrialSample, error) {
+	if compression.IsNone() {
+		return sample, nil
+	}
+	compressedSample := &grpcapi.StoredTrialSample{
+		UserId:       sample.UserId,
+		TrialId:      sample.TrialId,
+		TickId:       sample.TickId,
+		Timestamp:    sample.Timestamp,
+		State:        sample.State,
+		ActorSamples: sample.ActorSamples,
+		Payloads:     make([][]byte, len(sample.Payloads)),
+	}
+	for payloadIdx, payload := range sample.Payloads {
+		compressedPayload, err := compressPayload(payload, compression)
+		if err != nil {
+			return nil, NewUnexpectedError("unable to compress payload #%d of sample %d (%w)", payloadIdx, sample.TickId, err)
+		}
+		compressedSample.Payloads[payloadIdx] = compressedPayload
+	}
+	return compressedSample, nil
+}
+
+// DecompressSamplePayloads decompresses, in place, the payloads of the given sample
+func DecompressSamplePayloads(sample *grpcapi.StoredTrialSample, compression PayloadCompression) error {
+	if compression.IsNone() {
+		return nil
+	}
+	for payloadIdx, payload := range sample.Payloads {
+		decompressedPayload, err := decompressPayload(payload, compression)
+		if err != nil {
+			return NewUnexpectedError("unable to decompress payload #%d of sample %d (%w)", payloadIdx, sample.TickId, err)
+		}
+		sample.Payloads[payloadIdx] = decompressedPayload
+	}
+	return nil
+}
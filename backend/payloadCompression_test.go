@@ -0,0 +1,129 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+var payloadCompressions = []PayloadCompression{NoPayloadCompression, ZstdPayloadCompression, LZ4PayloadCompression}
+
+func TestParsePayloadCompression(t *testing.T) {
+	for _, name := range []string{"", "none"} {
+		payloadCompression, err := ParsePayloadCompression(name)
+		assert.NoError(t, err)
+		assert.True(t, payloadCompression.IsNone())
+	}
+
+	payloadCompression, err := ParsePayloadCompression("zstd")
+	assert.NoError(t, err)
+	assert.Equal(t, ZstdPayloadCompression, payloadCompression)
+
+	payloadCompression, err = ParsePayloadCompression("lz4")
+	assert.NoError(t, err)
+	assert.Equal(t, LZ4PayloadCompression, payloadCompression)
+
+	_, err = ParsePayloadCompression("gzip")
+	assert.Error(t, err)
+}
+
+func TestPayloadCompressionRoundTrip(t *testing.T) {
+	for _, payloadCompression := range payloadCompressions {
+		t.Run(string(payloadCompression), func(t *testing.T) {
+			sample := proto.Clone(trialSample1).(*grpcapi.StoredTrialSample)
+			sample.Payloads = append(sample.Payloads, []byte{}, bytes.Repeat([]byte("payload"), 100))
+
+			compressedSample, err := CompressSamplePayloads(sample, payloadCompression)
+			assert.NoError(t, err)
+			assert.True(t, proto.Equal(trialSample1.ActorSamples[0], compressedSample.ActorSamples[0]))
+			// Empty payloads are kept empty
+			assert.Len(t, compressedSample.Payloads[len(trialSample1.Payloads)], 0)
+			if !payloadCompression.IsNone() {
+				assert.Less(t, len(compressedSample.Payloads[len(trialSample1.Payloads)+1]), 700)
+			}
+
+			// The original sample isn't modified
+			assert.Equal(t, bytes.Repeat([]byte("payload"), 100), sample.Payloads[len(trialSample1.Payloads)+1])
+
+			serializedSample, err := proto.Marshal(compressedSample)
+			assert.NoError(t, err)
+			deserializedSample := &grpcapi.StoredTrialSample{}
+			err = proto.Unmarshal(serializedSample, deserializedSample)
+			assert.NoError(t, err)
+
+			err = DecompressSamplePayloads(deserializedSample, payloadCompression)
+			assert.NoError(t, err)
+			assert.True(t, proto.Equal(sample, deserializedSample))
+		})
+	}
+}
+
+func TestPayloadCompressionIncompressiblePayload(t *testing.T) {
+	payload := make([]byte, 4096)
+	_, err := rand.Read(payload)
+	assert.NoError(t, err)
+	for _, payloadCompression := range payloadCompressions {
+		compressedSample, err := CompressSamplePayloads(&grpcapi.StoredTrialSample{Payloads: [][]byte{payload}}, payloadCompression)
+		assert.NoError(t, err)
+		err = DecompressSamplePayloads(compressedSample, payloadCompression)
+		assert.NoError(t, err)
+		assert.Equal(t, payload, compressedSample.Payloads[0])
+	}
+}
+
+func TestDecompressInvalidPayload(t *testing.T) {
+	for _, payloadCompression := range []PayloadCompression{ZstdPayloadCompression, LZ4PayloadCompression} {
+		err := DecompressSamplePayloads(&grpcapi.StoredTrialSample{Payloads: [][]byte{[]byte("not compressed")}}, payloadCompression)
+		assert.Error(t, err)
+	}
+}
+
+func BenchmarkPayloadCompression(b *testing.B) {
+	// Somewhat compressible payloads, e.g. serialized observations of a grid world
+	sample := proto.Clone(trialSample1).(*grpcapi.StoredTrialSample)
+	sample.Payloads = make([][]byte, 10)
+	for payloadIdx := range sample.Payloads {
+		payload := make([]byte, 1024)
+		for byteIdx := range payload {
+			payload[byteIdx] = byte((byteIdx * (payloadIdx + 1) / 64) % 7)
+		}
+		sample.Payloads[payloadIdx] = payload
+	}
+
+	for _, payloadCompression := range payloadCompressions {
+		b.Run(string(payloadCompression), func(b *testing.B) {
+			b.ReportAllocs()
+			storedSize := 0
+			for n := 0; n < b.N; n++ {
+				compressedSample, err := CompressSamplePayloads(sample, payloadCompression)
+				if err != nil {
+					b.Fatal(err)
+				}
+				serializedSample, err := proto.Marshal(compressedSample)
+				if err != nil {
+					b.Fatal(err)
+				}
+				storedSize = len(serializedSample)
+			}
+			b.ReportMetric(float64(storedSize), "stored-bytes/sample")
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// sampleReservoir selects uniformly, without replacement, a fixed number of samples from a stream of samples whose
+// length isn't known in advance, only holding the selected samples in memory
+type sampleReservoir struct {
+	samples      []*grpcapi.StoredTrialSample
+	size         int
+	visitedCount int
+	rand         *rand.Rand
+}
+
+func newSampleReservoir(size int, seed int64) *sampleReservoir {
+	return &sampleReservoir{
+		samples: make([]*grpcapi.StoredTrialSample, 0, size),
+		size:    size,
+		rand:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (r *sampleReservoir) add(sample *grpcapi.StoredTrialSample) {
+	r.visitedCount++
+	if len(r.samples) < r.size {
+		r.samples = append(r.samples, sample)
+		return
+	}
+	// The sample replaces a selected one with a probability of size / visitedCount
+	if sampleIdx := r.rand.Intn(r.visitedCount); sampleIdx < r.size {
+		r.samples[sampleIdx] = sample
+	}
+}
+
+// selectedSamples returns the selected samples ordered by tick
+func (r *sampleReservoir) selectedSamples() []*grpcapi.StoredTrialSample {
+	sort.Slice(r.samples, func(i, j int) bool { return r.samples[i].TickId < r.samples[j].TickId })
+	return r.samples
+}
+
+// RetrieveRandomSamples selects uniformly, without replacement, `count` of the currently stored samples of a trial
+// selected by the given filter, e.g. to build training minibatches. Every selected sample is returned when there are
+// fewer of them.
+//
+// The samples are selected using reservoir sampling as they are read, only the selected ones are held in memory. They
+// are returned ordered by tick. The same seed, filter and stored samples result in the same selection.
+func RetrieveRandomSamples(ctx context.Context, b Backend, trialID string, count int, seed int64, filter TrialSampleFilter) ([]*grpcapi.StoredTrialSample, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("invalid random samples count %d, expecting a strictly positive value", count)
+	}
+	trialsInfo, err := b.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(trialsInfo.TrialInfos) == 0 {
+		return nil, &UnknownTrialError{TrialID: trialID}
+	}
+	storedSamplesCount := trialsInfo.TrialInfos[0].StoredSamplesCount
+	reservoir := newSampleReservoir(count, seed)
+	if storedSamplesCount == 0 {
+		return reservoir.selectedSamples(), nil
+	}
+
+	trialsParams, err := b.GetTrialParams(ctx, []string{trialID})
+	if err != nil {
+		return nil, err
+	}
+	filter.TrialIDs = []string{trialID}
+	err = filter.CheckTrialParams(trialID, trialsParams[0].Params)
+	if err != nil {
+		return nil, err
+	}
+	appliedFilter := NewAppliedTrialSampleFilter(filter, trialsParams[0].Params)
+
+	_, err = forEachStoredSample(ctx, b, trialID, storedSamplesCount, nil, func(sample *grpcapi.StoredTrialSample) error {
+		filteredSample := appliedFilter.Filter(sample)
+		if filteredSample != nil {
+			reservoir.add(filteredSample)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservoir.selectedSamples(), nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleReservoirIsUniform(t *testing.T) {
+	selectionsCount := make([]int, 10)
+	for seed := int64(0); seed < 2000; seed++ {
+		reservoir := newSampleReservoir(3, seed)
+		for tickID := uint64(0); tickID < 10; tickID++ {
+			reservoir.add(&grpcapi.StoredTrialSample{TickId: tickID})
+		}
+		selectedSamples := reservoir.selectedSamples()
+		assert.Len(t, selectedSamples, 3)
+		for _, sample := range selectedSamples {
+			selectionsCount[sample.TickId]++
+		}
+	}
+	// Each sample is expected to be selected 600 times
+	for tickID, selectionCount := range selectionsCount {
+		assert.InDelta(t, 600, selectionCount, 90, "sample %d", tickID)
+	}
+}
+
+func TestSampleReservoirSmallerStream(t *testing.T) {
+	reservoir := newSampleReservoir(5, 12)
+	for tickID := uint64(0); tickID < 3; tickID++ {
+		reservoir.add(&grpcapi.StoredTrialSample{TickId: tickID})
+	}
+	assert.Len(t, reservoir.selectedSamples(), 3)
+}
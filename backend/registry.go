@@ -0,0 +1,108 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Settings gives access to the backend-specific configuration, e.g. the path of a file-based storage.
+//
+// `*viper.Viper` implements it.
+type Settings interface {
+	IsSet(key string) bool
+	GetString(key string) string
+	GetBool(key string) bool
+	GetInt(key string) int
+	GetUint32(key string) uint32
+}
+
+// Counter counts events, e.g. the evictions of trials, `prometheus.Counter` implements it
+type Counter interface {
+	Inc()
+}
+
+// FactoryOptions represents the configuration given to a backend factory
+type FactoryOptions struct {
+	DeterministicSerialization bool
+	PayloadCompression         PayloadCompression
+	SerializationPooling       bool     // Recycle the transient buffers used to serialize the added samples
+	SampleChecksums            bool     // Store a checksum along with each added sample
+	VerifySampleChecksums      bool     // Check the checksums of the stored samples when reading them
+	Settings                   Settings // Backend-specific settings, nil when there are none
+	// Incremented each time the backend evicts a trial, params and samples, to stay under its limits, can be nil
+	EvictedTrialsCounter Counter
+	// Incremented each time the backend evicts the samples only of a trial to stay under its limits, can be nil
+	EvictedTrialsSamplesCounter Counter
+}
+
+// Factory creates a backend from the given options
+type Factory func(options FactoryOptions) (Backend, error)
+
+var (
+	factoriesMutex sync.RWMutex
+	factories      = make(map[string]Factory)
+)
+
+// Register makes a backend available under the given name, it is meant to be called from the `init` function of the
+// package implementing the backend.
+//
+// It panics if a backend is already registered under the given name.
+func Register(name string, factory Factory) {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+	if factory == nil {
+		panic("backend.Register: nil factory")
+	}
+	if _, found := factories[name]; found {
+		panic(fmt.Sprintf("backend.Register: backend %q registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// RegisteredBackends returns the sorted names of the registered backends
+func RegisteredBackends() []string {
+	factoriesMutex.RLock()
+	defer factoriesMutex.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UnknownBackendError is raised when creating a backend that isn't registered
+type UnknownBackendError struct {
+	Name               string
+	RegisteredBackends []string
+}
+
+func (e *UnknownBackendError) Error() string {
+	return fmt.Sprintf("no backend %q registered, expecting one of %v", e.Name, e.RegisteredBackends)
+}
+
+// CreateBackend creates a backend using the factory registered under the given name
+func CreateBackend(name string, options FactoryOptions) (Backend, error) {
+	factoriesMutex.RLock()
+	factory, found := factories[name]
+	factoriesMutex.RUnlock()
+	if !found {
+		return nil, &UnknownBackendError{Name: name, RegisteredBackends: RegisteredBackends()}
+	}
+	return factory(options)
+}
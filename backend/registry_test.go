@@ -0,0 +1,48 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	var receivedOptions FactoryOptions
+	Register("registry-test", func(options FactoryOptions) (Backend, error) {
+		receivedOptions = options
+		return nil, errors.New("registry-test backend")
+	})
+	assert.Contains(t, RegisteredBackends(), "registry-test")
+
+	_, err := CreateBackend("registry-test", FactoryOptions{DeterministicSerialization: true, PayloadCompression: LZ4PayloadCompression})
+	assert.EqualError(t, err, "registry-test backend")
+	assert.Equal(t, FactoryOptions{DeterministicSerialization: true, PayloadCompression: LZ4PayloadCompression}, receivedOptions)
+
+	assert.Panics(t, func() {
+		Register("registry-test", func(options FactoryOptions) (Backend, error) { return nil, nil })
+	})
+	assert.Panics(t, func() {
+		Register("registry-test-nil", nil)
+	})
+
+	_, err = CreateBackend("unknown", FactoryOptions{})
+	var unknownBackendErr *UnknownBackendError
+	assert.ErrorAs(t, err, &unknownBackendErr)
+	assert.Equal(t, "unknown", unknownBackendErr.Name)
+	assert.Equal(t, RegisteredBackends(), unknownBackendErr.RegisteredBackends)
+}
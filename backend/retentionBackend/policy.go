@@ -0,0 +1,68 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retentionBackend
+
+import (
+	"fmt"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+)
+
+// Policy selects the trials a retention backend evicts
+type Policy interface {
+	// SelectEvictedTrials returns the ids of the trials to evict, the given trials are every stored trial in creation order
+	SelectEvictedTrials(trials []*backend.TrialInfo) []string
+	String() string
+}
+
+// MaxTrialsCountPolicy evicts the oldest trials once more than `MaxTrialsCount` trials are stored
+type MaxTrialsCountPolicy struct {
+	MaxTrialsCount int
+}
+
+func (p MaxTrialsCountPolicy) SelectEvictedTrials(trials []*backend.TrialInfo) []string {
+	evictedTrialIDs := []string{}
+	for trialIdx := 0; trialIdx < len(trials)-p.MaxTrialsCount; trialIdx++ {
+		evictedTrialIDs = append(evictedTrialIDs, trials[trialIdx].TrialID)
+	}
+	return evictedTrialIDs
+}
+
+func (p MaxTrialsCountPolicy) String() string {
+	return fmt.Sprintf("at most %d trials", p.MaxTrialsCount)
+}
+
+// MaxStoredSamplesSizePolicy evicts the oldest trials once the cumulated size of the stored samples exceeds
+// `MaxStoredSamplesSize` bytes
+type MaxStoredSamplesSizePolicy struct {
+	MaxStoredSamplesSize int
+}
+
+func (p MaxStoredSamplesSizePolicy) SelectEvictedTrials(trials []*backend.TrialInfo) []string {
+	storedSamplesSize := 0
+	for _, trial := range trials {
+		storedSamplesSize += trial.StoredSamplesSize
+	}
+	evictedTrialIDs := []string{}
+	for trialIdx := 0; trialIdx < len(trials) && storedSamplesSize > p.MaxStoredSamplesSize; trialIdx++ {
+		evictedTrialIDs = append(evictedTrialIDs, trials[trialIdx].TrialID)
+		storedSamplesSize -= trials[trialIdx].StoredSamplesSize
+	}
+	return evictedTrialIDs
+}
+
+func (p MaxStoredSamplesSizePolicy) String() string {
+	return fmt.Sprintf("at most %dB of samples", p.MaxStoredSamplesSize)
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retentionBackend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+)
+
+var trials = []*backend.TrialInfo{
+	{TrialID: "trial-1", StoredSamplesSize: 100},
+	{TrialID: "trial-2", StoredSamplesSize: 50},
+	{TrialID: "trial-3", StoredSamplesSize: 0},
+	{TrialID: "trial-4", StoredSamplesSize: 200},
+}
+
+func TestMaxTrialsCountPolicy(t *testing.T) {
+	assert.Equal(t, []string{"trial-1"}, MaxTrialsCountPolicy{MaxTrialsCount: 3}.SelectEvictedTrials(trials))
+	assert.Equal(t, []string{"trial-1", "trial-2", "trial-3", "trial-4"}, MaxTrialsCountPolicy{MaxTrialsCount: 0}.SelectEvictedTrials(trials))
+	assert.Equal(t, []string{}, MaxTrialsCountPolicy{MaxTrialsCount: 4}.SelectEvictedTrials(trials))
+	assert.Equal(t, []string{}, MaxTrialsCountPolicy{MaxTrialsCount: 10}.SelectEvictedTrials(trials))
+}
+
+func TestMaxStoredSamplesSizePolicy(t *testing.T) {
+	assert.Equal(t, []string{}, MaxStoredSamplesSizePolicy{MaxStoredSamplesSize: 350}.SelectEvictedTrials(trials))
+	assert.Equal(t, []string{"trial-1"}, MaxStoredSamplesSizePolicy{MaxStoredSamplesSize: 250}.SelectEvictedTrials(trials))
+	assert.Equal(t, []string{"trial-1", "trial-2"}, MaxStoredSamplesSizePolicy{MaxStoredSamplesSize: 200}.SelectEvictedTrials(trials))
+	// Trials are evicted in creation order, even if they don't store any sample
+	assert.Equal(t, []string{"trial-1", "trial-2", "trial-3", "trial-4"}, MaxStoredSamplesSizePolicy{MaxStoredSamplesSize: 100}.SelectEvictedTrials(trials))
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retentionBackend
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/metrics"
+)
+
+// retentionBackend wraps a backend and evicts whole trials, oldest first, following retention policies.
+//
+// Policies are enforced asynchronously after trials are created or samples are added. Adding samples to a trial
+// evicted in the meantime fails like adding samples to a deleted trial.
+type retentionBackend struct {
+	backend.Backend
+	policies                []Policy
+	retentionWorkerTrigger  chan struct{}
+	retentionWorkerCancel   context.CancelFunc
+	retentionWorkerFinished chan struct{}
+}
+
+// CreateRetentionBackend creates a Backend enforcing the given retention policies on top of the given backend.
+//
+// The returned backend owns the given one, destroying it destroys the given backend.
+func CreateRetentionBackend(b backend.Backend, policies ...Policy) backend.Backend {
+	retentionWorkerContext, retentionWorkerCancel := context.WithCancel(context.Background())
+	rb := &retentionBackend{
+		Backend:                 b,
+		policies:                policies,
+		retentionWorkerTrigger:  make(chan struct{}, 1),
+		retentionWorkerCancel:   retentionWorkerCancel,
+		retentionWorkerFinished: make(chan struct{}),
+	}
+	go rb.retentionWorker(retentionWorkerContext)
+	return rb
+}
+
+func (b *retentionBackend) Destroy() {
+	b.retentionWorkerCancel()
+	<-b.retentionWorkerFinished
+	b.Backend.Destroy()
+}
+
+func (b *retentionBackend) retentionWorker(ctx context.Context) {
+	defer close(b.retentionWorkerFinished)
+	for {
+		select {
+		case <-ctx.Done():
+			// Retention worker canceled
+			return
+		case <-b.retentionWorkerTrigger:
+			err := b.enforcePolicies(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.WithField("operation", "enforce_retention").WithError(err).Error("Unable to enforce the retention policies")
+			}
+		}
+	}
+}
+
+func (b *retentionBackend) triggerRetention() {
+	select {
+	case b.retentionWorkerTrigger <- struct{}{}:
+	default:
+		// An enforcement is already pending
+	}
+}
+
+// enforcePolicies evicts the trials selected by any of the policies
+func (b *retentionBackend) enforcePolicies(ctx context.Context) error {
+	trialsInfo, err := b.Backend.RetrieveTrials(ctx, []string{}, 0, -1)
+	if err != nil {
+		return err
+	}
+
+	evictedTrialIDs := []string{}
+	evictedTrialPolicies := map[string]Policy{}
+	for _, policy := range b.policies {
+		for _, trialID := range policy.SelectEvictedTrials(trialsInfo.TrialInfos) {
+			if _, alreadyEvicted := evictedTrialPolicies[trialID]; !alreadyEvicted {
+				evictedTrialIDs = append(evictedTrialIDs, trialID)
+				evictedTrialPolicies[trialID] = policy
+			}
+		}
+	}
+
+	// Evicting trials one by one, in creation order, so that each eviction is atomic
+	for _, trialID := range evictedTrialIDs {
+		err := b.Backend.DeleteTrials(ctx, []string{trialID})
+		if err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"operation":        "enforce_retention",
+			"trial_id":         trialID,
+			"retention_policy": evictedTrialPolicies[trialID].String(),
+		}).Info("Evicted trial")
+		metrics.EvictedTrialsCount.WithLabelValues("retention_policy").Inc()
+	}
+	return nil
+}
+
+func (b *retentionBackend) CreateOrUpdateTrials(ctx context.Context, trialsParams []*backend.TrialParams) error {
+	err := b.Backend.CreateOrUpdateTrials(ctx, trialsParams)
+	b.triggerRetention()
+	return err
+}
+
+func (b *retentionBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error {
+	err := b.Backend.AddSamples(ctx, samples)
+	b.triggerRetention()
+	return err
+}
+
+func (b *retentionBackend) AddSamplePartial(ctx context.Context, sample *grpcapi.StoredTrialSample) error {
+	err := b.Backend.AddSamplePartial(ctx, sample)
+	b.triggerRetention()
+	return err
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retentionBackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	"github.com/cogment/cogment-trial-datastore/backend/test"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+func createRetentionMemoryBackend(t *testing.T, policies ...Policy) backend.Backend {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	return CreateRetentionBackend(b, policies...)
+}
+
+func retrieveTrialIDs(t *testing.T, b backend.Backend) []string {
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{}, 0, -1)
+	assert.NoError(t, err)
+	trialIDs := []string{}
+	for _, trialInfo := range trialsInfo.TrialInfos {
+		trialIDs = append(trialIDs, trialInfo.TrialID)
+	}
+	return trialIDs
+}
+
+func TestSuiteRetentionBackend(t *testing.T) {
+	test.RunSuite(t, func() backend.Backend {
+		// Limits high enough not to interfere with the suite
+		return createRetentionMemoryBackend(t, MaxTrialsCountPolicy{MaxTrialsCount: 10000}, MaxStoredSamplesSizePolicy{MaxStoredSamplesSize: 1 << 30})
+	}, func(b backend.Backend) {
+		b.Destroy()
+	})
+}
+
+func TestMaxTrialsCountRetention(t *testing.T) {
+	b := createRetentionMemoryBackend(t, MaxTrialsCountPolicy{MaxTrialsCount: 2})
+	defer b.Destroy()
+
+	for _, trialID := range []string{"trial-1", "trial-2", "trial-3", "trial-4"} {
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: trialID, Params: &grpcapi.TrialParams{}}})
+		assert.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"trial-3", "trial-4"}, retrieveTrialIDs(t, b))
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMaxStoredSamplesSizeRetention(t *testing.T) {
+	b := createRetentionMemoryBackend(t, MaxStoredSamplesSizePolicy{MaxStoredSamplesSize: 2500})
+	defer b.Destroy()
+
+	for _, trialID := range []string{"trial-1", "trial-2", "trial-3"} {
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: trialID, Params: &grpcapi.TrialParams{}}})
+		assert.NoError(t, err)
+		// A bit more than 1KB of samples per trial
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, Payloads: [][]byte{make([]byte, 512)}},
+			{TrialId: trialID, TickId: 1, Payloads: [][]byte{make([]byte, 512)}},
+		})
+		assert.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"trial-2", "trial-3"}, retrieveTrialIDs(t, b))
+	}, time.Second, 10*time.Millisecond)
+
+	// Evicted trials are deleted with their params
+	exist, err := b.TrialsExist(context.Background(), []string{"trial-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false}, exist)
+}
+
+func TestRetentionWhileAddingSamples(t *testing.T) {
+	b := createRetentionMemoryBackend(t, MaxTrialsCountPolicy{MaxTrialsCount: 3})
+	defer b.Destroy()
+
+	wg := sync.WaitGroup{}
+	for writerIdx := 0; writerIdx < 10; writerIdx++ {
+		trialID := fmt.Sprintf("trial-%d", writerIdx)
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: trialID, Params: &grpcapi.TrialParams{}}})
+		assert.NoError(t, err)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tickID := uint64(0); tickID < 100; tickID++ {
+				err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: trialID, TickId: tickID}})
+				if err != nil {
+					// Adding samples to an evicted trial is the only acceptable failure
+					var unknownTrialErr *backend.UnknownTrialError
+					assert.True(t, errors.As(err, &unknownTrialErr))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"trial-7", "trial-8", "trial-9"}, retrieveTrialIDs(t, b))
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// RewardRowDirection defines from which side of a reward a `RewardRow` is flattened
+type RewardRowDirection string
+
+const (
+	ReceivedRewardRow RewardRowDirection = "received" // Flattened from the `received_rewards` of the receiver
+	SentRewardRow     RewardRowDirection = "sent"     // Flattened from the `sent_rewards` of the sender
+)
+
+// EnvironmentActorIdx is the actor index used as the sender or the receiver of rewards for the environment
+const EnvironmentActorIdx = -1
+
+// RewardRow represents a reward received or sent by an actor at a given tick
+type RewardRow struct {
+	TrialID    string
+	TickID     uint64
+	ActorIdx   uint32
+	Direction  RewardRowDirection
+	Sender     int32 // `EnvironmentActorIdx` for the environment
+	Receiver   int32 // `EnvironmentActorIdx` for the environment
+	Reward     float32
+	Confidence float32
+}
+
+// RewardsCSVHeader lists the columns of the CSV rewards export, in the order of `RewardRow`'s fields
+var RewardsCSVHeader = []string{"trial_id", "tick_id", "actor_index", "direction", "sender", "receiver", "reward", "confidence"}
+
+// FlattenSampleRewards flattens the received and sent rewards of every actor of a sample into one row per reward.
+//
+// A reward sent from an actor to another appears twice, once as received by the receiver and once as sent by the
+// sender. Rewards sent by the environment only appear as received.
+func FlattenSampleRewards(sample *grpcapi.StoredTrialSample) []RewardRow {
+	rows := []RewardRow{}
+	for _, actorSample := range sample.ActorSamples {
+		for _, reward := range actorSample.ReceivedRewards {
+			rows = append(rows, RewardRow{
+				TrialID:    sample.TrialId,
+				TickID:     sample.TickId,
+				ActorIdx:   actorSample.Actor,
+				Direction:  ReceivedRewardRow,
+				Sender:     reward.Sender,
+				Receiver:   int32(actorSample.Actor),
+				Reward:     reward.Reward,
+				Confidence: reward.Confidence,
+			})
+		}
+		for _, reward := range actorSample.SentRewards {
+			rows = append(rows, RewardRow{
+				TrialID:    sample.TrialId,
+				TickID:     sample.TickId,
+				ActorIdx:   actorSample.Actor,
+				Direction:  SentRewardRow,
+				Sender:     int32(actorSample.Actor),
+				Receiver:   reward.Receiver,
+				Reward:     reward.Reward,
+				Confidence: reward.Confidence,
+			})
+		}
+	}
+	return rows
+}
+
+func (r RewardRow) csvRecord() []string {
+	return []string{
+		r.TrialID,
+		strconv.FormatUint(r.TickID, 10),
+		strconv.FormatUint(uint64(r.ActorIdx), 10),
+		string(r.Direction),
+		strconv.FormatInt(int64(r.Sender), 10),
+		strconv.FormatInt(int64(r.Receiver), 10),
+		strconv.FormatFloat(float64(r.Reward), 'g', -1, 32),
+		strconv.FormatFloat(float64(r.Confidence), 'g', -1, 32),
+	}
+}
+
+// ExportRewardsCSV writes the flattened rewards of the currently stored samples of the given trials to the given
+// writer as CSV, starting with a `RewardsCSVHeader` header row. Every trial is exported when no trial ids are given.
+//
+// Trials are exported one after the other, their samples are written as they are observed. Unknown trials are
+// reported before anything is written.
+func ExportRewardsCSV(ctx context.Context, b Backend, trialIDs []string, w io.Writer) error {
+	trialsInfo, err := b.RetrieveTrials(ctx, trialIDs, -1, -1)
+	if err != nil {
+		return err
+	}
+	storedSamplesCounts := make(map[string]int, len(trialsInfo.TrialInfos))
+	for _, trialInfo := range trialsInfo.TrialInfos {
+		storedSamplesCounts[trialInfo.TrialID] = trialInfo.StoredSamplesCount
+	}
+	if len(trialIDs) == 0 {
+		trialIDs = make([]string, 0, len(trialsInfo.TrialInfos))
+		for _, trialInfo := range trialsInfo.TrialInfos {
+			trialIDs = append(trialIDs, trialInfo.TrialID)
+		}
+	}
+	for _, trialID := range trialIDs {
+		if _, found := storedSamplesCounts[trialID]; !found {
+			return &UnknownTrialError{TrialID: trialID}
+		}
+	}
+
+	csvWriter := csv.NewWriter(w)
+	err = csvWriter.Write(RewardsCSVHeader)
+	if err != nil {
+		return err
+	}
+	for _, trialID := range trialIDs {
+		_, err := forEachStoredSample(
+			ctx,
+			b,
+			trialID,
+			storedSamplesCounts[trialID],
+			[]grpcapi.StoredTrialSampleField{
+				grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS,
+				grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_REWARDS,
+			},
+			func(sample *grpcapi.StoredTrialSample) error {
+				for _, row := range FlattenSampleRewards(sample) {
+					err := csvWriter.Write(row.csvRecord())
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			return err
+		}
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlattenSampleRewards(t *testing.T) {
+	sample := &grpcapi.StoredTrialSample{
+		TrialId: "my-trial",
+		TickId:  12,
+		ActorSamples: []*grpcapi.StoredTrialActorSample{
+			{
+				Actor: 0,
+				ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+					{Sender: -1, Reward: 1, Confidence: 0.5},
+					{Sender: 1, Reward: -2.5, Confidence: 1},
+				},
+			},
+			{
+				Actor: 1,
+				SentRewards: []*grpcapi.StoredTrialActorSampleReward{
+					{Receiver: 0, Reward: -2.5, Confidence: 1},
+				},
+			},
+			{
+				Actor: 2,
+			},
+		},
+	}
+
+	assert.Equal(t, []RewardRow{
+		{TrialID: "my-trial", TickID: 12, ActorIdx: 0, Direction: ReceivedRewardRow, Sender: EnvironmentActorIdx, Receiver: 0, Reward: 1, Confidence: 0.5},
+		{TrialID: "my-trial", TickID: 12, ActorIdx: 0, Direction: ReceivedRewardRow, Sender: 1, Receiver: 0, Reward: -2.5, Confidence: 1},
+		{TrialID: "my-trial", TickID: 12, ActorIdx: 1, Direction: SentRewardRow, Sender: 1, Receiver: 0, Reward: -2.5, Confidence: 1},
+	}, FlattenSampleRewards(sample))
+
+	assert.Empty(t, FlattenSampleRewards(&grpcapi.StoredTrialSample{TrialId: "my-trial"}))
+}
+
+func TestRewardRowCSVRecord(t *testing.T) {
+	row := RewardRow{TrialID: "my-trial", TickID: 12, ActorIdx: 1, Direction: SentRewardRow, Sender: 1, Receiver: EnvironmentActorIdx, Reward: 0.1, Confidence: 1}
+	assert.Equal(t, []string{"my-trial", "12", "1", "sent", "1", "-1", "0.1", "1"}, row.csvRecord())
+	assert.Len(t, RewardsCSVHeader, len(row.csvRecord()))
+}
@@ -0,0 +1,42 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// SampleChecksumSize is the size of the checksum appended to a serialized sample
+const SampleChecksumSize = 4
+
+var sampleChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// AppendSampleChecksum appends the CRC32 (Castagnoli) checksum of a serialized sample to it
+func AppendSampleChecksum(serializedSample []byte) []byte {
+	checksum := crc32.Checksum(serializedSample, sampleChecksumTable)
+	return append(serializedSample, byte(checksum>>24), byte(checksum>>16), byte(checksum>>8), byte(checksum))
+}
+
+// SplitSampleChecksum removes the checksum appended by `AppendSampleChecksum` and returns the serialized sample along
+// with whether the checksum matches it
+func SplitSampleChecksum(v []byte) ([]byte, bool) {
+	if len(v) < SampleChecksumSize {
+		return nil, false
+	}
+	serializedSample := v[:len(v)-SampleChecksumSize]
+	checksum := binary.BigEndian.Uint32(v[len(v)-SampleChecksumSize:])
+	return serializedSample, crc32.Checksum(serializedSample, sampleChecksumTable) == checksum
+}
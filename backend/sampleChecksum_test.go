@@ -0,0 +1,46 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleChecksum(t *testing.T) {
+	serializedSample := []byte("a serialized sample")
+	v := AppendSampleChecksum(append([]byte{}, serializedSample...))
+	assert.Len(t, v, len(serializedSample)+SampleChecksumSize)
+
+	splitSerializedSample, valid := SplitSampleChecksum(v)
+	assert.True(t, valid)
+	assert.Equal(t, serializedSample, splitSerializedSample)
+
+	for byteIdx := range v {
+		corruptedV := append([]byte{}, v...)
+		corruptedV[byteIdx] ^= 0x01
+		_, valid := SplitSampleChecksum(corruptedV)
+		assert.False(t, valid)
+	}
+
+	_, valid = SplitSampleChecksum(v[:SampleChecksumSize-1])
+	assert.False(t, valid)
+
+	emptyV := AppendSampleChecksum(nil)
+	splitSerializedSample, valid = SplitSampleChecksum(emptyV)
+	assert.True(t, valid)
+	assert.Empty(t, splitSerializedSample)
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"golang.org/x/sync/errgroup"
+)
+
+// UnknownSampleError is raised when retrieving a sample at a tick for which its trial doesn't store any
+type UnknownSampleError struct {
+	TrialID            string
+	TickID             uint64
+	StoredSamplesCount int
+	MinTickID          uint64
+	MaxTickID          uint64
+}
+
+func (e *UnknownSampleError) Error() string {
+	if e.StoredSamplesCount == 0 {
+		return fmt.Sprintf("no sample at tick %d for trial %q, it doesn't store any sample", e.TickID, e.TrialID)
+	}
+	return fmt.Sprintf("no sample at tick %d for trial %q, its stored samples range from tick %d to tick %d", e.TickID, e.TrialID, e.MinTickID, e.MaxTickID)
+}
+
+// RetrieveSample retrieves the stored sample of a trial at the given tick, along with the trial params.
+//
+// It observes the samples of the trial restricted to that single tick and doesn't wait for the samples of an ongoing
+// trial, an `UnknownSampleError` is returned if the sample isn't stored.
+func RetrieveSample(ctx context.Context, b Backend, trialID string, tickID uint64) (*grpcapi.StoredTrialSample, *TrialParams, error) {
+	trialsInfo, err := b.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(trialsInfo.TrialInfos) == 0 {
+		return nil, nil, &UnknownTrialError{TrialID: trialID}
+	}
+	trialInfo := trialsInfo.TrialInfos[0]
+	unknownSampleErr := &UnknownSampleError{
+		TrialID:            trialID,
+		TickID:             tickID,
+		StoredSamplesCount: trialInfo.StoredSamplesCount,
+		MinTickID:          trialInfo.MinTickID,
+		MaxTickID:          trialInfo.MaxTickID,
+	}
+	if trialInfo.StoredSamplesCount == 0 || tickID < trialInfo.MinTickID || tickID > trialInfo.MaxTickID {
+		return nil, nil, unknownSampleErr
+	}
+
+	trialsParams, err := b.GetTrialParams(ctx, []string{trialID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A later tick is stored, the observation ends once it is reached even for an ongoing trial
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var sample *grpcapi.StoredTrialSample
+	observer := make(TrialSampleObserver)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(observer)
+		return b.ObserveSamples(ctx, TrialSampleFilter{TrialIDs: []string{trialID}, FromTickID: &tickID, ToTickID: &tickID}, observer)
+	})
+	g.Go(func() error {
+		for observedSample := range observer {
+			if observedSample.TickId == tickID {
+				sample = observedSample
+				cancel()
+				return nil
+			}
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil && sample == nil {
+		return nil, nil, err
+	}
+	if sample == nil {
+		return nil, nil, unknownSampleErr
+	}
+	return sample, trialsParams[0], nil
+}
+
+// sampleActorLabel labels an actor of a sample, or the environment, using its index and its name from the params
+func sampleActorLabel(actorIdx int32, params *grpcapi.TrialParams) string {
+	if actorIdx == EnvironmentActorIdx {
+		return "environment"
+	}
+	actors := params.GetActors()
+	if actorIdx < 0 || int(actorIdx) >= len(actors) {
+		return fmt.Sprintf("#%d (unknown actor)", actorIdx)
+	}
+	return fmt.Sprintf("#%d %q", actorIdx, actors[actorIdx].Name)
+}
+
+// samplePayloadLabel labels a payload reference of a sample with its size
+func samplePayloadLabel(payloadIdx uint32, sample *grpcapi.StoredTrialSample) string {
+	if int(payloadIdx) >= len(sample.Payloads) {
+		return fmt.Sprintf("payload #%d (missing)", payloadIdx)
+	}
+	return fmt.Sprintf("payload #%d (%d bytes)", payloadIdx, len(sample.Payloads[payloadIdx]))
+}
+
+// WriteSampleInspection writes a human-readable description of a sample of a trial having the given params: actors
+// and the senders and receivers of rewards and messages are labeled using their names and payload references show
+// the payload sizes. The raw bytes of the payloads are also dumped if `hexdump` is set.
+func WriteSampleInspection(w io.Writer, sample *grpcapi.StoredTrialSample, params *grpcapi.TrialParams, hexdump bool) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "trial %q, tick %d\n", sample.TrialId, sample.TickId)
+	fmt.Fprintf(b, "  user id: %q\n", sample.UserId)
+	if sample.Timestamp != 0 {
+		fmt.Fprintf(b, "  timestamp: %s\n", time.Unix(0, int64(sample.Timestamp)).UTC().Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(b, "  state: %s\n", sample.State)
+	for _, actorSample := range sample.ActorSamples {
+		actorClass := ""
+		if actors := params.GetActors(); int(actorSample.Actor) < len(actors) {
+			actorClass = fmt.Sprintf(" of class %q", actors[actorSample.Actor].ActorClass)
+		}
+		fmt.Fprintf(b, "  actor %s%s\n", sampleActorLabel(int32(actorSample.Actor), params), actorClass)
+		if actorSample.Observation != nil {
+			fmt.Fprintf(b, "    observation: %s\n", samplePayloadLabel(*actorSample.Observation, sample))
+		}
+		if actorSample.Action != nil {
+			fmt.Fprintf(b, "    action: %s\n", samplePayloadLabel(*actorSample.Action, sample))
+		}
+		if actorSample.Reward != nil {
+			fmt.Fprintf(b, "    reward: %g\n", *actorSample.Reward)
+		}
+		for _, reward := range actorSample.ReceivedRewards {
+			fmt.Fprintf(b, "    received reward from %s: %g (confidence %g)", sampleActorLabel(reward.Sender, params), reward.Reward, reward.Confidence)
+			if reward.UserData != nil {
+				fmt.Fprintf(b, ", user data: %s", samplePayloadLabel(*reward.UserData, sample))
+			}
+			b.WriteString("\n")
+		}
+		for _, reward := range actorSample.SentRewards {
+			fmt.Fprintf(b, "    sent reward to %s: %g (confidence %g)", sampleActorLabel(reward.Receiver, params), reward.Reward, reward.Confidence)
+			if reward.UserData != nil {
+				fmt.Fprintf(b, ", user data: %s", samplePayloadLabel(*reward.UserData, sample))
+			}
+			b.WriteString("\n")
+		}
+		for _, message := range actorSample.ReceivedMessages {
+			fmt.Fprintf(b, "    received message from %s: %s\n", sampleActorLabel(message.Sender, params), samplePayloadLabel(message.Payload, sample))
+		}
+		for _, message := range actorSample.SentMessages {
+			fmt.Fprintf(b, "    sent message to %s: %s\n", sampleActorLabel(message.Receiver, params), samplePayloadLabel(message.Payload, sample))
+		}
+	}
+	for payloadIdx, payload := range sample.Payloads {
+		fmt.Fprintf(b, "  payload #%d: %d bytes\n", payloadIdx, len(payload))
+		if hexdump && len(payload) > 0 {
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(hex.Dump(payload), "\n"), "\n") {
+				fmt.Fprintf(b, "    %s", line)
+			}
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/openlyinc/pointy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSampleInspection(t *testing.T) {
+	output := &strings.Builder{}
+	err := WriteSampleInspection(output, trialSample1, trialParams, false)
+	assert.NoError(t, err)
+	inspection := output.String()
+
+	assert.True(t, strings.HasPrefix(inspection, "trial \"my-trial\", tick 12\n"))
+	assert.Contains(t, inspection, "  actor #0 \"my-actor-1\" of class \"my-actor-class-1\"\n")
+	assert.Contains(t, inspection, "    action: payload #1 (9 bytes)\n")
+	assert.Contains(t, inspection, "    received reward from environment: 0.5 (confidence 1)\n")
+	assert.Contains(t, inspection, "    received reward from #1 \"my-actor-2\": 0.5 (confidence 0.2), user data: payload #2 (18 bytes)\n")
+	assert.Contains(t, inspection, "    sent reward to #0 \"my-actor-1\": 0.5 (confidence 0.2), user data: payload #2 (18 bytes)\n")
+	assert.Contains(t, inspection, "    sent message to environment: payload #5 (23 bytes)\n")
+	assert.Contains(t, inspection, "  payload #0: 14 bytes\n")
+	assert.NotContains(t, inspection, "|an observation|")
+}
+
+func TestWriteSampleInspectionHexdump(t *testing.T) {
+	output := &strings.Builder{}
+	err := WriteSampleInspection(output, trialSample1, trialParams, true)
+	assert.NoError(t, err)
+	assert.Contains(t, output.String(), "  payload #0: 14 bytes\n    00000000  61 6e 20 6f 62 73 65 72  76 61 74 69 6f 6e        |an observation|\n")
+}
+
+func TestWriteSampleInspectionUnknownActors(t *testing.T) {
+	sample := &grpcapi.StoredTrialSample{
+		TrialId:      "my-trial",
+		TickId:       3,
+		ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 2, Action: pointy.Uint32(1)}},
+	}
+
+	// Neither the actor nor the referenced payload are known, the sample is still described
+	output := &strings.Builder{}
+	err := WriteSampleInspection(output, sample, nil, false)
+	assert.NoError(t, err)
+	assert.Contains(t, output.String(), "  actor #2 (unknown actor)\n    action: payload #1 (missing)\n")
+}
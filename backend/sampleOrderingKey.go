@@ -0,0 +1,128 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SampleOrderingKey defines the field of `grpcapi.StoredTrialSample` used to order the samples of a trial.
+//
+// The zero value orders samples by tick id.
+type SampleOrderingKey struct {
+	field protoreflect.FieldDescriptor
+}
+
+// ParseSampleOrderingKey parses an ordering key expressed as the name of a scalar field of `grpcapi.StoredTrialSample`, e.g. "timestamp"
+func ParseSampleOrderingKey(fieldName string) (SampleOrderingKey, error) {
+	if fieldName == "" {
+		return SampleOrderingKey{}, nil
+	}
+	field := (&grpcapi.StoredTrialSample{}).ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(fieldName))
+	if field == nil {
+		return SampleOrderingKey{}, fmt.Errorf("no sample field %q", fieldName)
+	}
+	if field.Cardinality() == protoreflect.Repeated {
+		return SampleOrderingKey{}, fmt.Errorf("sample field %q is not orderable", fieldName)
+	}
+	switch field.Kind() {
+	case protoreflect.BoolKind, protoreflect.EnumKind, protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind, protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind, protoreflect.FloatKind, protoreflect.DoubleKind, protoreflect.StringKind:
+	default:
+		return SampleOrderingKey{}, fmt.Errorf("sample field %q is not orderable", fieldName)
+	}
+	if field.Name() == "tick_id" {
+		return SampleOrderingKey{}, nil
+	}
+	return SampleOrderingKey{field: field}, nil
+}
+
+// IsTickID returns true if the samples are ordered by tick id
+func (k SampleOrderingKey) IsTickID() bool {
+	return k.field == nil
+}
+
+func (k SampleOrderingKey) String() string {
+	if k.IsTickID() {
+		return "tick_id"
+	}
+	return string(k.field.Name())
+}
+
+func (k SampleOrderingKey) less(a *grpcapi.StoredTrialSample, b *grpcapi.StoredTrialSample) bool {
+	if k.IsTickID() {
+		return a.TickId < b.TickId
+	}
+	aValue := a.ProtoReflect().Get(k.field)
+	bValue := b.ProtoReflect().Get(k.field)
+	switch k.field.Kind() {
+	case protoreflect.BoolKind:
+		return !aValue.Bool() && bValue.Bool()
+	case protoreflect.EnumKind:
+		return aValue.Enum() < bValue.Enum()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind, protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return aValue.Int() < bValue.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return aValue.Uint() < bValue.Uint()
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return aValue.Float() < bValue.Float()
+	default:
+		return aValue.String() < bValue.String()
+	}
+}
+
+// SortSamples sorts the given samples following the given ordering key, samples having the same key are ordered by tick id
+func SortSamples(samples []*grpcapi.StoredTrialSample, orderingKey SampleOrderingKey) {
+	sort.SliceStable(samples, func(i, j int) bool {
+		if orderingKey.less(samples[i], samples[j]) {
+			return true
+		}
+		if orderingKey.less(samples[j], samples[i]) {
+			return false
+		}
+		return samples[i].TickId < samples[j].TickId
+	})
+}
+
+// ForwardSortedSamples sends every sample received from `in` to `out` sorted following the given ordering key, in
+// decreasing order when `reverse` is set.
+//
+// As samples can only be sorted once they are all known, nothing is sent until `in` is closed.
+func ForwardSortedSamples(ctx context.Context, orderingKey SampleOrderingKey, reverse bool, in <-chan *grpcapi.StoredTrialSample, out chan<- *grpcapi.StoredTrialSample) error {
+	samples := []*grpcapi.StoredTrialSample{}
+	for sample := range in {
+		samples = append(samples, sample)
+	}
+	SortSamples(samples, orderingKey)
+	if reverse {
+		for i, j := 0, len(samples)-1; i < j; i, j = i+1, j-1 {
+			samples[i], samples[j] = samples[j], samples[i]
+		}
+	}
+	for _, sample := range samples {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- sample:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSampleOrderingKey(t *testing.T) {
+	k, err := ParseSampleOrderingKey("")
+	assert.NoError(t, err)
+	assert.True(t, k.IsTickID())
+
+	k, err = ParseSampleOrderingKey("tick_id")
+	assert.NoError(t, err)
+	assert.True(t, k.IsTickID())
+	assert.Equal(t, SampleOrderingKey{}, k)
+
+	k, err = ParseSampleOrderingKey("timestamp")
+	assert.NoError(t, err)
+	assert.False(t, k.IsTickID())
+	assert.Equal(t, "timestamp", k.String())
+
+	_, err = ParseSampleOrderingKey("not_a_field")
+	assert.Error(t, err)
+
+	_, err = ParseSampleOrderingKey("actor_samples")
+	assert.Error(t, err)
+
+	_, err = ParseSampleOrderingKey("payloads")
+	assert.Error(t, err)
+}
+
+func TestSortSamples(t *testing.T) {
+	samples := []*grpcapi.StoredTrialSample{
+		{TickId: 0, Timestamp: 30, UserId: "c"},
+		{TickId: 2, Timestamp: 10, UserId: "a"},
+		{TickId: 1, Timestamp: 10, UserId: "b"},
+	}
+
+	timestampKey, err := ParseSampleOrderingKey("timestamp")
+	assert.NoError(t, err)
+	SortSamples(samples, timestampKey)
+	assert.Equal(t, []uint64{1, 2, 0}, []uint64{samples[0].TickId, samples[1].TickId, samples[2].TickId})
+
+	userIDKey, err := ParseSampleOrderingKey("user_id")
+	assert.NoError(t, err)
+	SortSamples(samples, userIDKey)
+	assert.Equal(t, []uint64{2, 1, 0}, []uint64{samples[0].TickId, samples[1].TickId, samples[2].TickId})
+
+	SortSamples(samples, SampleOrderingKey{})
+	assert.Equal(t, []uint64{0, 1, 2}, []uint64{samples[0].TickId, samples[1].TickId, samples[2].TickId})
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// UnknownPayloadError is raised when retrieving a payload whose index is out of the range of the payloads of a sample
+type UnknownPayloadError struct {
+	TrialID    string
+	TickID     uint64
+	PayloadIdx uint32
+}
+
+func (e *UnknownPayloadError) Error() string {
+	return fmt.Sprintf("no payload #%d in the sample at tick %d for trial %q", e.PayloadIdx, e.TickID, e.TrialID)
+}
+
+var samplePayloadsFieldNumber = (&grpcapi.StoredTrialSample{}).ProtoReflect().Descriptor().Fields().ByName("payloads").Number()
+
+// ExtractSerializedSamplePayload extracts the payload at the given index from a serialized sample, and whether it was
+// found, without deserializing the rest of the sample.
+//
+// The returned payload is a sub-slice of the serialized sample, it is neither copied nor decompressed.
+func ExtractSerializedSamplePayload(serializedSample []byte, payloadIdx uint32) ([]byte, bool, error) {
+	visitedPayloadsCount := uint32(0)
+	for len(serializedSample) > 0 {
+		fieldNumber, wireType, tagLen := protowire.ConsumeTag(serializedSample)
+		if tagLen < 0 {
+			return nil, false, NewUnexpectedError("unable to deserialize sample (%w)", protowire.ParseError(tagLen))
+		}
+		serializedSample = serializedSample[tagLen:]
+		if fieldNumber == samplePayloadsFieldNumber && wireType == protowire.BytesType {
+			payload, payloadLen := protowire.ConsumeBytes(serializedSample)
+			if payloadLen < 0 {
+				return nil, false, NewUnexpectedError("unable to deserialize sample (%w)", protowire.ParseError(payloadLen))
+			}
+			if visitedPayloadsCount == payloadIdx {
+				return payload, true, nil
+			}
+			visitedPayloadsCount++
+			serializedSample = serializedSample[payloadLen:]
+			continue
+		}
+		valueLen := protowire.ConsumeFieldValue(fieldNumber, wireType, serializedSample)
+		if valueLen < 0 {
+			return nil, false, NewUnexpectedError("unable to deserialize sample (%w)", protowire.ParseError(valueLen))
+		}
+		serializedSample = serializedSample[valueLen:]
+	}
+	return nil, false, nil
+}
+
+// DecompressPayload decompresses a payload of a stored sample, the returned payload can share the given one
+func DecompressPayload(payload []byte, compression PayloadCompression) ([]byte, error) {
+	decompressedPayload, err := decompressPayload(payload, compression)
+	if err != nil {
+		return nil, NewUnexpectedError("unable to decompress payload (%w)", err)
+	}
+	return decompressedPayload, nil
+}
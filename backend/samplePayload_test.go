@@ -0,0 +1,53 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestExtractSerializedSamplePayload(t *testing.T) {
+	serializedSample, err := proto.Marshal(trialSample1)
+	assert.NoError(t, err)
+
+	for payloadIdx, expectedPayload := range trialSample1.Payloads {
+		payload, found, err := ExtractSerializedSamplePayload(serializedSample, uint32(payloadIdx))
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, expectedPayload, payload)
+	}
+
+	_, found, err := ExtractSerializedSamplePayload(serializedSample, uint32(len(trialSample1.Payloads)))
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, _, err = ExtractSerializedSamplePayload(serializedSample[:len(serializedSample)-1], uint32(len(trialSample1.Payloads)))
+	assert.Error(t, err)
+}
+
+func TestExtractSerializedSamplePayloadEmpty(t *testing.T) {
+	// Filtered out payloads are left empty
+	serializedSample, err := proto.Marshal(&grpcapi.StoredTrialSample{TickId: 12, Payloads: [][]byte{[]byte("a payload"), {}, []byte("another payload")}})
+	assert.NoError(t, err)
+
+	payload, found, err := ExtractSerializedSamplePayload(serializedSample, 1)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Empty(t, payload)
+}
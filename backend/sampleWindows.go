@@ -0,0 +1,243 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// SampleWindowsOptions represents how samples are aggregated into windows
+type SampleWindowsOptions struct {
+	WindowsCount int // Maximum number of windows, the tick range is split into windows of equal width
+	// Inclusive tick range split into windows, samples outside of it are ignored
+	FromTickID uint64
+	ToTickID   uint64
+}
+
+// ComputeSampleWindows aggregates the given samples into windows of consecutive ticks, one aggregated sample per window.
+//
+// The tick range is split into at most `options.WindowsCount` windows of equal width, windows without any sample are
+// omitted. The aggregated samples are ordered by tick and have the tick id, timestamp and state of the last sample of
+// their window. For each actor, the aggregated sample has:
+//
+// - the last observation and the last action of the window,
+// - a reward which is the mean of the rewards of the window, only counting the samples where the actor has one,
+// - a single received reward, sent by -1, whose value is the sum of the received rewards of the window and whose
+// confidence is the mean of their confidences, without user data.
+//
+// Sent rewards and messages are not aggregated.
+func ComputeSampleWindows(samples []*grpcapi.StoredTrialSample, options SampleWindowsOptions) ([]*grpcapi.StoredTrialSample, error) {
+	aggregator, err := newSampleWindowsAggregator(options)
+	if err != nil {
+		return nil, err
+	}
+	for _, sample := range samples {
+		aggregator.add(sample)
+	}
+	return aggregator.windowSamples(), nil
+}
+
+// RetrieveSampleWindows aggregates the currently stored samples of a trial, selected by the given filter, into at most
+// `windowsCount` windows following `ComputeSampleWindows` semantics.
+//
+// The windows cover the tick range of the stored samples, restricted by the tick range of the filter if any. Samples
+// are aggregated as they are read, they are never all held in memory.
+func RetrieveSampleWindows(ctx context.Context, b Backend, trialID string, windowsCount int, filter TrialSampleFilter) ([]*grpcapi.StoredTrialSample, error) {
+	trialsInfo, err := b.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(trialsInfo.TrialInfos) == 0 {
+		return nil, &UnknownTrialError{TrialID: trialID}
+	}
+	trialInfo := trialsInfo.TrialInfos[0]
+
+	options := SampleWindowsOptions{
+		WindowsCount: windowsCount,
+		FromTickID:   trialInfo.MinTickID,
+		ToTickID:     trialInfo.MaxTickID,
+	}
+	if filter.FromTickID != nil && *filter.FromTickID > options.FromTickID {
+		options.FromTickID = *filter.FromTickID
+	}
+	if filter.ToTickID != nil && *filter.ToTickID < options.ToTickID {
+		options.ToTickID = *filter.ToTickID
+	}
+	aggregator, err := newSampleWindowsAggregator(options)
+	if err != nil {
+		return nil, err
+	}
+	if trialInfo.StoredSamplesCount == 0 || options.FromTickID > options.ToTickID {
+		return aggregator.windowSamples(), nil
+	}
+
+	trialsParams, err := b.GetTrialParams(ctx, []string{trialID})
+	if err != nil {
+		return nil, err
+	}
+	filter.TrialIDs = []string{trialID}
+	err = filter.CheckTrialParams(trialID, trialsParams[0].Params)
+	if err != nil {
+		return nil, err
+	}
+	appliedFilter := NewAppliedTrialSampleFilter(filter, trialsParams[0].Params)
+
+	_, err = forEachStoredSample(ctx, b, trialID, trialInfo.StoredSamplesCount, nil, func(sample *grpcapi.StoredTrialSample) error {
+		filteredSample := appliedFilter.Filter(sample)
+		if filteredSample != nil {
+			aggregator.add(filteredSample)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return aggregator.windowSamples(), nil
+}
+
+type sampleWindowsAggregator struct {
+	options     SampleWindowsOptions
+	windowWidth uint64
+	windows     map[uint64]*sampleWindow // Windows having at least one sample, by window index
+}
+
+type sampleWindow struct {
+	lastSample *grpcapi.StoredTrialSample // Sample having the largest tick id
+	actors     map[uint32]*actorSampleWindow
+}
+
+type actorSampleWindow struct {
+	observation       []byte
+	observationTickID uint64
+	hasObservation    bool
+	action            []byte
+	actionTickID      uint64
+	hasAction         bool
+	rewardsSum        float32
+	rewardsCount      int
+	receivedRewards   []*grpcapi.StoredTrialActorSampleReward
+}
+
+func newSampleWindowsAggregator(options SampleWindowsOptions) (*sampleWindowsAggregator, error) {
+	if options.WindowsCount <= 0 {
+		return nil, fmt.Errorf("invalid windows count %d, expecting a strictly positive value", options.WindowsCount)
+	}
+	windowWidth := uint64(1)
+	if options.ToTickID > options.FromTickID {
+		// Equivalent to ceil((ToTickID - FromTickID + 1) / WindowsCount) without overflow
+		windowWidth = (options.ToTickID-options.FromTickID)/uint64(options.WindowsCount) + 1
+	}
+	return &sampleWindowsAggregator{
+		options:     options,
+		windowWidth: windowWidth,
+		windows:     make(map[uint64]*sampleWindow),
+	}, nil
+}
+
+func (a *sampleWindowsAggregator) add(sample *grpcapi.StoredTrialSample) {
+	if sample.TickId < a.options.FromTickID || sample.TickId > a.options.ToTickID {
+		return
+	}
+	windowIdx := (sample.TickId - a.options.FromTickID) / a.windowWidth
+	window, ok := a.windows[windowIdx]
+	if !ok {
+		window = &sampleWindow{lastSample: sample, actors: make(map[uint32]*actorSampleWindow)}
+		a.windows[windowIdx] = window
+	} else if sample.TickId >= window.lastSample.TickId {
+		window.lastSample = sample
+	}
+
+	for _, actorSample := range sample.ActorSamples {
+		actorWindow, ok := window.actors[actorSample.Actor]
+		if !ok {
+			actorWindow = &actorSampleWindow{}
+			window.actors[actorSample.Actor] = actorWindow
+		}
+		if actorSample.Observation != nil && (!actorWindow.hasObservation || sample.TickId >= actorWindow.observationTickID) {
+			actorWindow.observation = sample.Payloads[*actorSample.Observation]
+			actorWindow.observationTickID = sample.TickId
+			actorWindow.hasObservation = true
+		}
+		if actorSample.Action != nil && (!actorWindow.hasAction || sample.TickId >= actorWindow.actionTickID) {
+			actorWindow.action = sample.Payloads[*actorSample.Action]
+			actorWindow.actionTickID = sample.TickId
+			actorWindow.hasAction = true
+		}
+		if actorSample.Reward != nil {
+			actorWindow.rewardsSum += *actorSample.Reward
+			actorWindow.rewardsCount++
+		}
+		actorWindow.receivedRewards = append(actorWindow.receivedRewards, actorSample.ReceivedRewards...)
+	}
+}
+
+// windowSamples returns the aggregated sample of each window having samples, ordered by tick
+func (a *sampleWindowsAggregator) windowSamples() []*grpcapi.StoredTrialSample {
+	windowIdxs := make([]uint64, 0, len(a.windows))
+	for windowIdx := range a.windows {
+		windowIdxs = append(windowIdxs, windowIdx)
+	}
+	sort.Slice(windowIdxs, func(i, j int) bool { return windowIdxs[i] < windowIdxs[j] })
+
+	samples := make([]*grpcapi.StoredTrialSample, 0, len(windowIdxs))
+	for _, windowIdx := range windowIdxs {
+		samples = append(samples, a.windows[windowIdx].aggregate())
+	}
+	return samples
+}
+
+func (w *sampleWindow) aggregate() *grpcapi.StoredTrialSample {
+	sample := &grpcapi.StoredTrialSample{
+		UserId:       w.lastSample.UserId,
+		TrialId:      w.lastSample.TrialId,
+		TickId:       w.lastSample.TickId,
+		Timestamp:    w.lastSample.Timestamp,
+		State:        w.lastSample.State,
+		ActorSamples: make([]*grpcapi.StoredTrialActorSample, 0, len(w.actors)),
+		Payloads:     [][]byte{},
+	}
+
+	actorIdxs := make([]uint32, 0, len(w.actors))
+	for actorIdx := range w.actors {
+		actorIdxs = append(actorIdxs, actorIdx)
+	}
+	sort.Slice(actorIdxs, func(i, j int) bool { return actorIdxs[i] < actorIdxs[j] })
+
+	for _, actorIdx := range actorIdxs {
+		actorWindow := w.actors[actorIdx]
+		actorSample := &grpcapi.StoredTrialActorSample{Actor: actorIdx}
+		if actorWindow.hasObservation {
+			observation := uint32(len(sample.Payloads))
+			actorSample.Observation = &observation
+			sample.Payloads = append(sample.Payloads, actorWindow.observation)
+		}
+		if actorWindow.hasAction {
+			action := uint32(len(sample.Payloads))
+			actorSample.Action = &action
+			sample.Payloads = append(sample.Payloads, actorWindow.action)
+		}
+		if actorWindow.rewardsCount > 0 {
+			reward := actorWindow.rewardsSum / float32(actorWindow.rewardsCount)
+			actorSample.Reward = &reward
+		}
+		actorSample.ReceivedRewards = SumRewardAggregation.aggregate(actorIdx, actorWindow.receivedRewards)
+		sample.ActorSamples = append(sample.ActorSamples, actorSample)
+	}
+	return sample
+}
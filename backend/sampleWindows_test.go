@@ -0,0 +1,124 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/openlyinc/pointy"
+	"github.com/stretchr/testify/assert"
+)
+
+// generateWindowedSamples generates samples of an actor whose reward, observation and action depend on the tick id
+func generateWindowedSamples(tickIDs ...uint64) []*grpcapi.StoredTrialSample {
+	samples := make([]*grpcapi.StoredTrialSample, len(tickIDs))
+	for sampleIdx, tickID := range tickIDs {
+		samples[sampleIdx] = &grpcapi.StoredTrialSample{
+			TrialId:   "my-trial",
+			TickId:    tickID,
+			Timestamp: 1000 + tickID,
+			State:     grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{
+					Actor:       0,
+					Observation: pointy.Uint32(0),
+					Action:      pointy.Uint32(1),
+					Reward:      pointy.Float32(float32(tickID)),
+					ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+						{Sender: 1, Reward: 1, Confidence: 1, UserData: pointy.Uint32(2)},
+						{Sender: -1, Reward: float32(tickID), Confidence: 0.5},
+					},
+				},
+			},
+			Payloads: [][]byte{
+				{byte('o'), byte(tickID)},
+				{byte('a'), byte(tickID)},
+				[]byte("a reward user data"),
+			},
+		}
+	}
+	return samples
+}
+
+func TestComputeSampleWindows(t *testing.T) {
+	samples := generateWindowedSamples(0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	windowSamples, err := ComputeSampleWindows(samples, SampleWindowsOptions{WindowsCount: 3, FromTickID: 0, ToTickID: 9})
+	assert.NoError(t, err)
+
+	// Windows are [0, 3], [4, 7] and [8, 9]
+	assert.Len(t, windowSamples, 3)
+	assert.Equal(t, []uint64{3, 7, 9}, []uint64{windowSamples[0].TickId, windowSamples[1].TickId, windowSamples[2].TickId})
+	assert.Equal(t, uint64(1003), windowSamples[0].Timestamp)
+
+	actorSample := windowSamples[0].ActorSamples[0]
+	assert.Equal(t, uint32(0), actorSample.Actor)
+	assert.Equal(t, float32(1.5), *actorSample.Reward)
+	assert.Equal(t, []byte{byte('o'), 3}, windowSamples[0].Payloads[*actorSample.Observation])
+	assert.Equal(t, []byte{byte('a'), 3}, windowSamples[0].Payloads[*actorSample.Action])
+	assert.Len(t, actorSample.ReceivedRewards, 1)
+	assert.Equal(t, int32(-1), actorSample.ReceivedRewards[0].Sender)
+	// 4 rewards of 1 and the rewards 0, 1, 2 and 3
+	assert.Equal(t, float32(10), actorSample.ReceivedRewards[0].Reward)
+	assert.Equal(t, float32(0.75), actorSample.ReceivedRewards[0].Confidence)
+	assert.Nil(t, actorSample.ReceivedRewards[0].UserData)
+	// Only the observation and the action are kept
+	assert.Len(t, windowSamples[0].Payloads, 2)
+
+	assert.Equal(t, float32(8.5), *windowSamples[2].ActorSamples[0].Reward)
+}
+
+func TestComputeSampleWindowsOmitsEmptyWindows(t *testing.T) {
+	samples := generateWindowedSamples(0, 1, 8, 9, 12)
+	windowSamples, err := ComputeSampleWindows(samples, SampleWindowsOptions{WindowsCount: 5, FromTickID: 0, ToTickID: 9})
+	assert.NoError(t, err)
+
+	// Windows are [0, 1], [2, 3], [4, 5], [6, 7] and [8, 9], tick 12 is out of range
+	assert.Len(t, windowSamples, 2)
+	assert.Equal(t, uint64(1), windowSamples[0].TickId)
+	assert.Equal(t, float32(0.5), *windowSamples[0].ActorSamples[0].Reward)
+	assert.Equal(t, uint64(9), windowSamples[1].TickId)
+	assert.Equal(t, float32(8.5), *windowSamples[1].ActorSamples[0].Reward)
+}
+
+func TestComputeSampleWindowsMoreWindowsThanTicks(t *testing.T) {
+	samples := generateWindowedSamples(4, 5, 6)
+	windowSamples, err := ComputeSampleWindows(samples, SampleWindowsOptions{WindowsCount: 500, FromTickID: 4, ToTickID: 6})
+	assert.NoError(t, err)
+	assert.Len(t, windowSamples, 3)
+	for sampleIdx, windowSample := range windowSamples {
+		assert.Equal(t, samples[sampleIdx].TickId, windowSample.TickId)
+		assert.Equal(t, *samples[sampleIdx].ActorSamples[0].Reward, *windowSample.ActorSamples[0].Reward)
+	}
+}
+
+func TestComputeSampleWindowsWithoutRewards(t *testing.T) {
+	samples := []*grpcapi.StoredTrialSample{
+		{TrialId: "my-trial", TickId: 0, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 1}}},
+		{TrialId: "my-trial", TickId: 1, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 1}}},
+	}
+	windowSamples, err := ComputeSampleWindows(samples, SampleWindowsOptions{WindowsCount: 1, FromTickID: 0, ToTickID: 1})
+	assert.NoError(t, err)
+	assert.Len(t, windowSamples, 1)
+	assert.Equal(t, grpcapi.TrialState_ENDED, windowSamples[0].State)
+	assert.Nil(t, windowSamples[0].ActorSamples[0].Reward)
+	assert.Nil(t, windowSamples[0].ActorSamples[0].Observation)
+	assert.Len(t, windowSamples[0].ActorSamples[0].ReceivedRewards, 0)
+}
+
+func TestComputeSampleWindowsInvalidWindowsCount(t *testing.T) {
+	_, err := ComputeSampleWindows(generateWindowedSamples(0, 1), SampleWindowsOptions{WindowsCount: 0, FromTickID: 0, ToTickID: 1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sync"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// Buffers growing larger than this are not recycled, a few samples with huge payloads shouldn't keep memory reserved
+const maxPooledBufferSize = 1 << 20
+
+// SerializationPool recycles the transient buffers used to compress and serialize the samples being added, to reduce
+// the allocations and the GC pressure of the append path.
+//
+// A disabled pool, as well as a nil one, allocates new buffers every time.
+type SerializationPool struct {
+	enabled bool
+	buffers sync.Pool
+}
+
+// CreateSerializationPool creates a serialization pool, recycling buffers only when enabled
+func CreateSerializationPool(enabled bool) *SerializationPool {
+	p := &SerializationPool{enabled: enabled}
+	p.buffers.New = func() interface{} {
+		return &SerializationBuffers{pool: p, compressedSample: &grpcapi.StoredTrialSample{}}
+	}
+	return p
+}
+
+// Acquire retrieves buffers from the pool, they must be released once what they hold isn't referenced anymore
+func (p *SerializationPool) Acquire() *SerializationBuffers {
+	if p == nil || !p.enabled {
+		return &SerializationBuffers{compressedSample: &grpcapi.StoredTrialSample{}}
+	}
+	return p.buffers.Get().(*SerializationBuffers)
+}
+
+// SerializationBuffers holds the buffers used to compress and serialize one sample.
+//
+// The compressed sample, its payloads and the serialized bytes they return are owned by the buffers, they must not be
+// referenced, e.g. retained by a backend, once the buffers are released.
+type SerializationBuffers struct {
+	pool             *SerializationPool // Pool the buffers are returned to, nil when they are not recycled
+	compressedSample *grpcapi.StoredTrialSample
+	payloads         [][]byte // Compressed payloads, `compressedSample.Payloads` is a slice of it
+	serializedSample []byte
+}
+
+// CompressSamplePayloads is `CompressSamplePayloads` writing the compressed sample and its payloads in the buffers
+func (bufs *SerializationBuffers) CompressSamplePayloads(sample *grpcapi.StoredTrialSample, compression PayloadCompression) (*grpcapi.StoredTrialSample, error) {
+	if compression.IsNone() {
+		return sample, nil
+	}
+	for len(bufs.payloads) < len(sample.Payloads) {
+		bufs.payloads = append(bufs.payloads, nil)
+	}
+	for payloadIdx, payload := range sample.Payloads {
+		compressedPayload, err := appendCompressedPayload(bufs.payloads[payloadIdx][:0], payload, compression)
+		if err != nil {
+			return nil, NewUnexpectedError("unable to compress payload #%d of sample %d (%w)", payloadIdx, sample.TickId, err)
+		}
+		bufs.payloads[payloadIdx] = compressedPayload
+	}
+	compressedSample := bufs.compressedSample
+	compressedSample.UserId = sample.UserId
+	compressedSample.TrialId = sample.TrialId
+	compressedSample.TickId = sample.TickId
+	compressedSample.Timestamp = sample.Timestamp
+	compressedSample.State = sample.State
+	compressedSample.ActorSamples = sample.ActorSamples
+	compressedSample.Payloads = bufs.payloads[:len(sample.Payloads)]
+	return compressedSample, nil
+}
+
+// MarshalSample serializes the given sample in the buffers
+func (bufs *SerializationBuffers) MarshalSample(marshalOptions proto.MarshalOptions, sample *grpcapi.StoredTrialSample) ([]byte, error) {
+	serializedSample, err := marshalOptions.MarshalAppend(bufs.serializedSample[:0], sample)
+	if err != nil {
+		return nil, err
+	}
+	bufs.serializedSample = serializedSample
+	return serializedSample, nil
+}
+
+// Release returns the buffers to their pool, nothing they hold may be referenced afterwards
+func (bufs *SerializationBuffers) Release() {
+	if bufs.pool == nil {
+		return
+	}
+	// Not retaining the fields of the released sample, they belong to the caller
+	bufs.compressedSample.Reset()
+	for payloadIdx, payload := range bufs.payloads {
+		if cap(payload) > maxPooledBufferSize {
+			bufs.payloads[payloadIdx] = nil
+		}
+	}
+	if cap(bufs.serializedSample) > maxPooledBufferSize {
+		bufs.serializedSample = nil
+	}
+	bufs.pool.buffers.Put(bufs)
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func samplesWithPayloads(samplesCount int, payloadsCount int, payloadSize int) []*grpcapi.StoredTrialSample {
+	samples := make([]*grpcapi.StoredTrialSample, samplesCount)
+	for sampleIdx := range samples {
+		sample := proto.Clone(trialSample1).(*grpcapi.StoredTrialSample)
+		sample.TickId = uint64(sampleIdx)
+		sample.Payloads = make([][]byte, payloadsCount)
+		for payloadIdx := range sample.Payloads {
+			payload := make([]byte, payloadSize)
+			for byteIdx := range payload {
+				payload[byteIdx] = byte((byteIdx * (payloadIdx + sampleIdx + 1) / 64) % 7)
+			}
+			sample.Payloads[payloadIdx] = payload
+		}
+		samples[sampleIdx] = sample
+	}
+	return samples
+}
+
+func deserializeCompressedSample(t *testing.T, serializedSample []byte, payloadCompression PayloadCompression) *grpcapi.StoredTrialSample {
+	sample := &grpcapi.StoredTrialSample{}
+	err := proto.Unmarshal(serializedSample, sample)
+	assert.NoError(t, err)
+	err = DecompressSamplePayloads(sample, payloadCompression)
+	assert.NoError(t, err)
+	return sample
+}
+
+func TestSerializationPoolRoundTrip(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		pool := CreateSerializationPool(enabled)
+		for _, payloadCompression := range payloadCompressions {
+			t.Run(fmt.Sprintf("%s-pooling-%v", payloadCompression, enabled), func(t *testing.T) {
+				// Samples having fewer and smaller payloads than the previous ones reuse their buffers
+				for _, sample := range append(samplesWithPayloads(1, 5, 2048), samplesWithPayloads(1, 2, 128)...) {
+					sample.Payloads = append(sample.Payloads, []byte{})
+
+					serializationBuffers := pool.Acquire()
+					compressedSample, err := serializationBuffers.CompressSamplePayloads(sample, payloadCompression)
+					assert.NoError(t, err)
+					serializedSample, err := serializationBuffers.MarshalSample(proto.MarshalOptions{}, compressedSample)
+					assert.NoError(t, err)
+
+					assert.True(t, proto.Equal(sample, deserializeCompressedSample(t, serializedSample, payloadCompression)))
+					serializationBuffers.Release()
+				}
+			})
+		}
+	}
+}
+
+func TestSerializationPoolRelease(t *testing.T) {
+	pool := CreateSerializationPool(true)
+	sample := samplesWithPayloads(1, 2, 128)[0]
+
+	serializationBuffers := pool.Acquire()
+	compressedSample, err := serializationBuffers.CompressSamplePayloads(sample, ZstdPayloadCompression)
+	assert.NoError(t, err)
+	_, err = serializationBuffers.MarshalSample(proto.MarshalOptions{}, compressedSample)
+	assert.NoError(t, err)
+	serializationBuffers.Release()
+
+	// The released buffers don't reference the sample anymore
+	assert.Equal(t, "", serializationBuffers.compressedSample.TrialId)
+	assert.Nil(t, serializationBuffers.compressedSample.ActorSamples)
+	assert.Nil(t, serializationBuffers.compressedSample.Payloads)
+
+	// The original sample isn't modified
+	assert.True(t, proto.Equal(samplesWithPayloads(1, 2, 128)[0], sample))
+}
+
+func TestSerializationPoolConcurrentUse(t *testing.T) {
+	// Meant to be run with `-race`, buffers shared between concurrent serializations would be reported
+	pool := CreateSerializationPool(true)
+	samples := samplesWithPayloads(100, 3, 512)
+	serializedSamples := make([][]byte, len(samples))
+
+	wg := sync.WaitGroup{}
+	for workerIdx := 0; workerIdx < 8; workerIdx++ {
+		wg.Add(1)
+		go func(workerIdx int) {
+			defer wg.Done()
+			for sampleIdx := workerIdx; sampleIdx < len(samples); sampleIdx += 8 {
+				serializationBuffers := pool.Acquire()
+				compressedSample, err := serializationBuffers.CompressSamplePayloads(samples[sampleIdx], LZ4PayloadCompression)
+				assert.NoError(t, err)
+				serializedSample, err := serializationBuffers.MarshalSample(proto.MarshalOptions{}, compressedSample)
+				assert.NoError(t, err)
+				// Keeping a copy, the serialized bytes are recycled once released
+				serializedSamples[sampleIdx] = append([]byte{}, serializedSample...)
+				serializationBuffers.Release()
+			}
+		}(workerIdx)
+	}
+	wg.Wait()
+
+	for sampleIdx, serializedSample := range serializedSamples {
+		assert.True(t, proto.Equal(samples[sampleIdx], deserializeCompressedSample(t, serializedSample, LZ4PayloadCompression)))
+	}
+}
+
+func BenchmarkSerializationPool(b *testing.B) {
+	sample := samplesWithPayloads(1, 10, 1024)[0]
+
+	for _, payloadCompression := range payloadCompressions {
+		for _, enabled := range []bool{false, true} {
+			pool := CreateSerializationPool(enabled)
+			b.Run(fmt.Sprintf("%s-pooling-%v", payloadCompression, enabled), func(b *testing.B) {
+				b.ReportAllocs()
+				for n := 0; n < b.N; n++ {
+					serializationBuffers := pool.Acquire()
+					compressedSample, err := serializationBuffers.CompressSamplePayloads(sample, payloadCompression)
+					if err != nil {
+						b.Fatal(err)
+					}
+					_, err = serializationBuffers.MarshalSample(proto.MarshalOptions{}, compressedSample)
+					if err != nil {
+						b.Fatal(err)
+					}
+					serializationBuffers.Release()
+				}
+			})
+		}
+	}
+}
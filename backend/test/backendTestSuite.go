@@ -16,7 +16,12 @@ package test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -25,6 +30,8 @@ import (
 
 	"github.com/cogment/cogment-trial-datastore/backend"
 	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/openlyinc/pointy"
+	"google.golang.org/protobuf/proto"
 )
 
 var nextTickID uint64 // = 0
@@ -79,7 +86,22 @@ func extractTrialIDs(trialInfos []*backend.TrialInfo) []string {
 	return trialIDs
 }
 
-// RunSuite runs the full backend test suite
+// withoutCreationTimestamps checks that the given trials have a creation timestamp and returns copies without it
+func withoutCreationTimestamps(t *testing.T, trialInfos []*backend.TrialInfo) []*backend.TrialInfo {
+	trialInfosCopy := make([]*backend.TrialInfo, len(trialInfos))
+	for trialInfoIdx, trialInfo := range trialInfos {
+		assert.False(t, trialInfo.CreatedAt.IsZero(), "trial %q has no creation timestamp", trialInfo.TrialID)
+		trialInfoCopy := *trialInfo
+		trialInfoCopy.CreatedAt = time.Time{}
+		trialInfosCopy[trialInfoIdx] = &trialInfoCopy
+	}
+	return trialInfosCopy
+}
+
+// RunSuite runs the full backend test suite.
+//
+// It defines the behavior expected from any implementation of `backend.Backend` and can be used to test third-party
+// backends, `createBackend` must return a new empty backend each time it is called.
 func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend func(backend.Backend)) {
 	t.Run("TestCreateBackend", func(t *testing.T) {
 		b := createBackend()
@@ -111,7 +133,7 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 
 			assert.Len(t, r.TrialInfos, 2)
 
-			assert.ElementsMatch(t, r.TrialInfos, []*backend.TrialInfo{
+			assert.ElementsMatch(t, withoutCreationTimestamps(t, r.TrialInfos), []*backend.TrialInfo{
 				{
 					TrialID:            "trial-1",
 					State:              grpcapi.TrialState_UNKNOWN,
@@ -147,7 +169,7 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 
 			assert.Len(t, r.TrialInfos, 3)
 
-			assert.ElementsMatch(t, r.TrialInfos, []*backend.TrialInfo{
+			assert.ElementsMatch(t, withoutCreationTimestamps(t, r.TrialInfos), []*backend.TrialInfo{
 				{
 					TrialID:            "trial-1",
 					State:              grpcapi.TrialState_UNKNOWN,
@@ -175,7 +197,7 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 
 			assert.Len(t, r1.TrialInfos, 2)
 
-			assert.ElementsMatch(t, r1.TrialInfos, []*backend.TrialInfo{
+			assert.ElementsMatch(t, withoutCreationTimestamps(t, r1.TrialInfos), []*backend.TrialInfo{
 				{
 					TrialID:            "trial-1",
 					State:              grpcapi.TrialState_UNKNOWN,
@@ -195,7 +217,7 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 
 			assert.Len(t, r2.TrialInfos, 1)
 
-			assert.ElementsMatch(t, r2.TrialInfos, []*backend.TrialInfo{
+			assert.ElementsMatch(t, withoutCreationTimestamps(t, r2.TrialInfos), []*backend.TrialInfo{
 				{
 					TrialID:            "trial-3",
 					State:              grpcapi.TrialState_UNKNOWN,
@@ -342,6 +364,53 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 
 			assert.Len(t, r.TrialInfos, 0)
 		}
+
+		{
+			// The params of deleted trials aren't retrievable anymore
+			_, err := b.GetTrialParams(context.Background(), []string{"A"})
+			assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+		}
+	})
+	t.Run("TestTrialsExist", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		{
+			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+				{
+					TrialID: "A",
+					Params:  generateTrialParams(1, 2),
+				},
+				{
+					TrialID: "B",
+					Params:  generateTrialParams(3, 4),
+				},
+			})
+			assert.NoError(t, err)
+		}
+
+		{
+			exist, err := b.TrialsExist(context.Background(), []string{"A", "B", "C"})
+			assert.NoError(t, err)
+			assert.Equal(t, []bool{true, true, false}, exist)
+		}
+
+		{
+			err := b.DeleteTrials(context.Background(), []string{"A"})
+			assert.NoError(t, err)
+		}
+
+		{
+			exists, err := backend.TrialExists(context.Background(), b, "A")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+		}
+
+		{
+			exists, err := backend.TrialExists(context.Background(), b, "B")
+			assert.NoError(t, err)
+			assert.True(t, exists)
+		}
 	})
 	t.Run("TestGetTrialParams", func(t *testing.T) {
 		b := createBackend()
@@ -351,6 +420,7 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
 				{
 					TrialID: "A",
+					UserID:  "user-a",
 					Params:  generateTrialParams(2, 100),
 				},
 				{
@@ -377,6 +447,7 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 			assert.Len(t, trialsParams, 2)
 
 			assert.Equal(t, "A", trialsParams[0].TrialID)
+			assert.Equal(t, "user-a", trialsParams[0].UserID)
 			assert.Len(t, trialsParams[0].Params.Actors, 2)
 			assert.Equal(t, uint32(100), trialsParams[0].Params.MaxSteps)
 			assert.Equal(t, "B", trialsParams[1].TrialID)
@@ -442,6 +513,68 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 
 		cancel()
 	})
+	t.Run("TestAddSamplePartial", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{
+			TrialID: "my-trial",
+			Params:  generateTrialParams(2, 100),
+		}})
+		assert.NoError(t, err)
+
+		observation := uint32(0)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{
+			TrialId:      "my-trial",
+			TickId:       0,
+			State:        grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Observation: &observation}},
+			Payloads:     [][]byte{[]byte("an observation")},
+		}})
+		assert.NoError(t, err)
+
+		// Adding the reward of the first tick
+		reward := float32(12)
+		err = b.AddSamplePartial(context.Background(), &grpcapi.StoredTrialSample{
+			TrialId:      "my-trial",
+			TickId:       0,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Reward: &reward}},
+		})
+		assert.NoError(t, err)
+
+		// Adding a new tick
+		err = b.AddSamplePartial(context.Background(), &grpcapi.StoredTrialSample{
+			TrialId: "my-trial",
+			TickId:  1,
+			State:   grpcapi.TrialState_ENDED,
+		})
+		assert.NoError(t, err)
+
+		r, err := b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Len(t, r.TrialInfos, 1)
+		assert.Equal(t, 2, r.TrialInfos[0].SamplesCount)
+		assert.Equal(t, grpcapi.TrialState_ENDED, r.TrialInfos[0].State)
+
+		observer := make(backend.TrialSampleObserver)
+		go func() {
+			err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"my-trial"}}, observer)
+			assert.NoError(t, err)
+			close(observer)
+		}()
+		samples := []*grpcapi.StoredTrialSample{}
+		for sample := range observer {
+			samples = append(samples, sample)
+		}
+
+		assert.Len(t, samples, 2)
+		assert.Equal(t, uint64(0), samples[0].TickId)
+		assert.Equal(t, grpcapi.TrialState_RUNNING, samples[0].State)
+		assert.Len(t, samples[0].ActorSamples, 1)
+		assert.Equal(t, []byte("an observation"), samples[0].Payloads[*samples[0].ActorSamples[0].Observation])
+		assert.Equal(t, float32(12), *samples[0].ActorSamples[0].Reward)
+		assert.Equal(t, uint64(1), samples[1].TickId)
+	})
 	t.Run("TestConcurrentAddAndObserveSamples", func(t *testing.T) {
 		t.Parallel() // This test involves goroutines and `time.Sleep`
 
@@ -491,6 +624,177 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 		}
 		wg.Wait()
 	})
+	t.Run("TestConcurrentAddSamplesSameTrial", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{
+			TrialID: "my-trial",
+			Params:  generateTrialParams(2, 100),
+		}})
+		assert.NoError(t, err)
+
+		writersCount := 8
+		samplesPerWriterCount := 25
+		wg := sync.WaitGroup{}
+		for writerIdx := 0; writerIdx < writersCount; writerIdx++ {
+			wg.Add(1)
+			go func(writerIdx int) {
+				defer wg.Done()
+				for sampleIdx := 0; sampleIdx < samplesPerWriterCount; sampleIdx++ {
+					err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{
+						TrialId: "my-trial",
+						TickId:  uint64(writerIdx*samplesPerWriterCount + sampleIdx),
+						State:   grpcapi.TrialState_RUNNING,
+					}})
+					assert.NoError(t, err)
+				}
+			}(writerIdx)
+		}
+		wg.Wait()
+
+		// Every sample of every writer is stored
+		trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, writersCount*samplesPerWriterCount, trialsInfo.TrialInfos[0].SamplesCount)
+		assert.Equal(t, writersCount*samplesPerWriterCount, trialsInfo.TrialInfos[0].StoredSamplesCount)
+	})
+
+	t.Run("TestAddSamplesExistingTickID", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: generateTrialParams(2, 100)}})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "my-trial", UserId: "first", TickId: 0, State: grpcapi.TrialState_RUNNING},
+			{TrialId: "my-trial", UserId: "first", TickId: 1, State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+
+		// Adding a sample having the tick id of a stored sample is accepted, the memory backend keeps both samples while
+		// the bolt backend replaces the stored one
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "my-trial", UserId: "second", TickId: 1, State: grpcapi.TrialState_ENDED},
+		})
+		assert.NoError(t, err)
+
+		trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, grpcapi.TrialState_ENDED, trialsInfo.TrialInfos[0].State)
+
+		observer := make(backend.TrialSampleObserver)
+		go func() {
+			defer close(observer)
+			err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"my-trial"}}, observer)
+			assert.NoError(t, err)
+		}()
+		samples := []*grpcapi.StoredTrialSample{}
+		for sample := range observer {
+			samples = append(samples, sample)
+		}
+		// In any case, the last retrieved sample is the latest added one
+		assert.Equal(t, uint64(0), samples[0].TickId)
+		assert.Equal(t, "first", samples[0].UserId)
+		assert.Equal(t, uint64(1), samples[len(samples)-1].TickId)
+		assert.Equal(t, "second", samples[len(samples)-1].UserId)
+	})
+
+	t.Run("TestDeleteTrialDuringObservation", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: generateTrialParams(2, 100)}})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample("my-trial", 2, 12, false)})
+		assert.NoError(t, err)
+
+		observer := make(backend.TrialSampleObserver)
+		observationErr := make(chan error, 1)
+		go func() {
+			defer close(observer)
+			observationErr <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"my-trial"}}, observer)
+		}()
+		<-observer
+
+		err = b.DeleteTrials(context.Background(), []string{"my-trial"})
+		assert.NoError(t, err)
+
+		// The ongoing observation ends
+		_, ok := <-observer
+		assert.False(t, ok)
+		assert.NoError(t, <-observationErr)
+	})
+
+	t.Run("TestObserveSamplesFilters", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: &grpcapi.TrialParams{
+			Actors: []*grpcapi.ActorParams{
+				{Name: "actor-1", ActorClass: "class-1", Implementation: "impl-1"},
+				{Name: "actor-2", ActorClass: "class-2", Implementation: "impl-2"},
+			},
+		}}})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{
+				TrialId: "my-trial",
+				TickId:  0,
+				State:   grpcapi.TrialState_RUNNING,
+				ActorSamples: []*grpcapi.StoredTrialActorSample{
+					{Actor: 0, Observation: pointy.Uint32(0), Reward: pointy.Float32(1)},
+					{Actor: 1, Observation: pointy.Uint32(1), Action: pointy.Uint32(2)},
+				},
+				Payloads: [][]byte{[]byte("observation 1"), []byte("observation 2"), []byte("action 2")},
+			},
+			{
+				TrialId: "my-trial",
+				TickId:  1,
+				State:   grpcapi.TrialState_ENDED,
+				ActorSamples: []*grpcapi.StoredTrialActorSample{
+					{Actor: 0, Observation: pointy.Uint32(0), Action: pointy.Uint32(1)},
+					{Actor: 1, Observation: pointy.Uint32(0)},
+				},
+				Payloads: [][]byte{[]byte("observation"), []byte("action 1")},
+			},
+		})
+		assert.NoError(t, err)
+
+		observeSamples := func(filter backend.TrialSampleFilter) []*grpcapi.StoredTrialSample {
+			filter.TrialIDs = []string{"my-trial"}
+			observer := make(backend.TrialSampleObserver)
+			go func() {
+				defer close(observer)
+				err := b.ObserveSamples(context.Background(), filter, observer)
+				assert.NoError(t, err)
+			}()
+			samples := []*grpcapi.StoredTrialSample{}
+			for sample := range observer {
+				samples = append(samples, sample)
+			}
+			return samples
+		}
+
+		samples := observeSamples(backend.TrialSampleFilter{
+			ActorClasses: []string{"class-2"},
+			Fields:       []grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION},
+		})
+		assert.Len(t, samples, 2)
+		assert.Len(t, samples[0].ActorSamples, 1)
+		assert.Equal(t, uint32(1), samples[0].ActorSamples[0].Actor)
+		assert.Nil(t, samples[0].ActorSamples[0].Observation)
+		assert.Equal(t, []byte("action 2"), samples[0].Payloads[2])
+		assert.Empty(t, samples[0].Payloads[1])
+
+		samples = observeSamples(backend.TrialSampleFilter{
+			ActorNames:     []string{"actor-1"},
+			RequireActions: true,
+		})
+		assert.Len(t, samples, 1)
+		assert.Equal(t, uint64(1), samples[0].TickId)
+	})
+
 	t.Run("TestObserveSamplesEmptyTrial", func(t *testing.T) {
 		b := createBackend()
 		defer destroyBackend(b)
@@ -537,4 +841,1795 @@ func RunSuite(t *testing.T, createBackend func() backend.Backend, destroyBackend
 			close(observer)
 		}
 	})
+	t.Run("TestRetrieveInterTickTimingStats", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		{
+			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+				{
+					TrialID: "trial-1",
+					Params:  generateTrialParams(2, 100),
+				},
+			})
+			assert.NoError(t, err)
+		}
+
+		{
+			// The trial is still ongoing
+			t0 := uint64(time.Now().UnixNano())
+			err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+				{TrialId: "trial-1", TickId: 0, Timestamp: t0, State: grpcapi.TrialState_RUNNING},
+				{TrialId: "trial-1", TickId: 1, Timestamp: t0 + uint64(10*time.Millisecond), State: grpcapi.TrialState_RUNNING},
+				{TrialId: "trial-1", TickId: 2, Timestamp: t0 + uint64(40*time.Millisecond), State: grpcapi.TrialState_RUNNING},
+			})
+			assert.NoError(t, err)
+		}
+
+		{
+			stats, err := backend.RetrieveInterTickTimingStats(context.Background(), b, "trial-1", []float64{50})
+			assert.NoError(t, err)
+			assert.Equal(t, 2, stats.IntervalsCount)
+			assert.Equal(t, 10*time.Millisecond, stats.Min)
+			assert.Equal(t, 30*time.Millisecond, stats.Max)
+			assert.Equal(t, 20*time.Millisecond, stats.Mean)
+			assert.Equal(t, 10*time.Millisecond, stats.Percentiles[50])
+		}
+
+		{
+			_, err := backend.RetrieveInterTickTimingStats(context.Background(), b, "trial-2", []float64{50})
+			var unknownTrialErr *backend.UnknownTrialError
+			assert.ErrorAs(t, err, &unknownTrialErr)
+		}
+	})
+	t.Run("TestClearSamples", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		{
+			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+				{
+					TrialID: "trial-1",
+					Params:  generateTrialParams(2, 100),
+				},
+			})
+			assert.NoError(t, err)
+		}
+
+		{
+			err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+				{TrialId: "trial-1", TickId: 0, State: grpcapi.TrialState_RUNNING},
+				{TrialId: "trial-1", TickId: 1, State: grpcapi.TrialState_ENDED},
+			})
+			assert.NoError(t, err)
+		}
+
+		{
+			err := b.ClearSamples(context.Background(), "trial-1")
+			assert.NoError(t, err)
+
+			r, err := b.RetrieveTrials(context.Background(), []string{"trial-1"}, -1, -1)
+			assert.NoError(t, err)
+			assert.Len(t, r.TrialInfos, 1)
+			assert.Equal(t, 0, r.TrialInfos[0].SamplesCount)
+			assert.Equal(t, 0, r.TrialInfos[0].StoredSamplesCount)
+			assert.Equal(t, grpcapi.TrialState_UNKNOWN, r.TrialInfos[0].State)
+
+			trialsParams, err := b.GetTrialParams(context.Background(), []string{"trial-1"})
+			assert.NoError(t, err)
+			assert.Len(t, trialsParams[0].Params.Actors, 2)
+			assert.Equal(t, uint32(100), trialsParams[0].Params.MaxSteps)
+		}
+
+		{
+			err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+				{TrialId: "trial-1", TickId: 0, State: grpcapi.TrialState_RUNNING},
+				{TrialId: "trial-1", TickId: 1, State: grpcapi.TrialState_RUNNING},
+				{TrialId: "trial-1", TickId: 2, State: grpcapi.TrialState_ENDED},
+			})
+			assert.NoError(t, err)
+
+			r, err := b.RetrieveTrials(context.Background(), []string{"trial-1"}, -1, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, r.TrialInfos[0].SamplesCount)
+			assert.Equal(t, grpcapi.TrialState_ENDED, r.TrialInfos[0].State)
+
+			observer := make(backend.TrialSampleObserver)
+			go func() {
+				defer close(observer)
+				err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"trial-1"}}, observer)
+				assert.NoError(t, err)
+			}()
+			tickIDs := []uint64{}
+			for sample := range observer {
+				tickIDs = append(tickIDs, sample.TickId)
+			}
+			assert.Equal(t, []uint64{0, 1, 2}, tickIDs)
+		}
+
+		{
+			err := b.ClearSamples(context.Background(), "trial-2")
+			var unknownTrialErr *backend.UnknownTrialError
+			assert.ErrorAs(t, err, &unknownTrialErr)
+		}
+	})
+	t.Run("TestObserveSamplesSampleOrderingKey", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		timestampKey, err := backend.ParseSampleOrderingKey("timestamp")
+		assert.NoError(t, err)
+
+		{
+			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+				{
+					TrialID:           "trial-1",
+					Params:            generateTrialParams(2, 100),
+					SampleOrderingKey: timestampKey,
+				},
+			})
+			assert.NoError(t, err)
+
+			trialsParams, err := b.GetTrialParams(context.Background(), []string{"trial-1"})
+			assert.NoError(t, err)
+			assert.Equal(t, timestampKey, trialsParams[0].SampleOrderingKey)
+		}
+
+		observer := make(backend.TrialSampleObserver)
+		go func() {
+			defer close(observer)
+			err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"trial-1"}}, observer)
+			assert.NoError(t, err)
+		}()
+
+		{
+			err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+				{TrialId: "trial-1", TickId: 0, Timestamp: 300, State: grpcapi.TrialState_RUNNING},
+				{TrialId: "trial-1", TickId: 1, Timestamp: 100, State: grpcapi.TrialState_RUNNING},
+				{TrialId: "trial-1", TickId: 2, Timestamp: 200, State: grpcapi.TrialState_ENDED},
+			})
+			assert.NoError(t, err)
+		}
+
+		tickIDs := []uint64{}
+		for sample := range observer {
+			tickIDs = append(tickIDs, sample.TickId)
+		}
+		assert.Equal(t, []uint64{1, 2, 0}, tickIDs)
+	})
+	t.Run("TestRetrieveTickGaps", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		{
+			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+				{
+					TrialID: "trial-1",
+					Params:  generateTrialParams(2, 100),
+				},
+			})
+			assert.NoError(t, err)
+		}
+
+		{
+			// Tick 3 is missing
+			err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+				{TrialId: "trial-1", TickId: 1, State: grpcapi.TrialState_RUNNING},
+				{TrialId: "trial-1", TickId: 2, State: grpcapi.TrialState_RUNNING},
+				{TrialId: "trial-1", TickId: 4, State: grpcapi.TrialState_RUNNING},
+			})
+			assert.NoError(t, err)
+		}
+
+		{
+			gaps, err := backend.RetrieveTickGaps(context.Background(), b, "trial-1", backend.TickGapsOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, []backend.TickGap{{FromTickID: 3, ToTickID: 3}}, gaps)
+		}
+	})
+
+	t.Run("TestReindex", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "reindex-1", Params: generateTrialParams(2, 100)},
+			{TrialID: "reindex-2", Params: generateTrialParams(2, 100)},
+		})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample("reindex-1", 2, 12, false)})
+		assert.NoError(t, err)
+
+		err = b.Reindex(context.Background())
+		assert.NoError(t, err)
+
+		// Reindexing a consistent backend doesn't change anything
+		trialsInfo, err := b.RetrieveTrials(context.Background(), []string{}, 0, -1)
+		assert.NoError(t, err)
+		assert.Len(t, trialsInfo.TrialInfos, 2)
+		assert.Equal(t, "reindex-1", trialsInfo.TrialInfos[0].TrialID)
+		assert.Equal(t, 1, trialsInfo.TrialInfos[0].StoredSamplesCount)
+		assert.Equal(t, "reindex-2", trialsInfo.TrialInfos[1].TrialID)
+	})
+
+	t.Run("TestObserveAnnotatedSamples", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		trialParams := &grpcapi.TrialParams{
+			MaxSteps: 100,
+			Actors: []*grpcapi.ActorParams{
+				{Name: "actor-1", ActorClass: "class-1", Implementation: "impl-1"},
+				{Name: "actor-2", ActorClass: "class-2", Implementation: "impl-2"},
+			},
+		}
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "annotated", Params: trialParams}})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{
+			TrialId:      "annotated",
+			TickId:       0,
+			State:        grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 1}, {Actor: 0}},
+		}})
+		assert.NoError(t, err)
+
+		observer := make(chan *backend.AnnotatedTrialSample)
+		go func() {
+			defer close(observer)
+			err := backend.ObserveAnnotatedSamples(context.Background(), b, backend.TrialSampleFilter{TrialIDs: []string{"annotated"}}, observer)
+			assert.NoError(t, err)
+		}()
+
+		annotatedSample := <-observer
+		assert.Equal(t, uint64(0), annotatedSample.Sample.TickId)
+		assert.Equal(t, []backend.ActorMetadata{
+			{Name: "actor-2", ActorClass: "class-2", Implementation: "impl-2"},
+			{Name: "actor-1", ActorClass: "class-1", Implementation: "impl-1"},
+		}, annotatedSample.Actors)
+
+		// Updating the params of the ongoing trial with an additional actor
+		evolvedTrialParams := proto.Clone(trialParams).(*grpcapi.TrialParams)
+		evolvedTrialParams.Actors = append(evolvedTrialParams.Actors, &grpcapi.ActorParams{Name: "actor-3", ActorClass: "class-1", Implementation: "impl-3"})
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "annotated", Params: evolvedTrialParams}})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{
+			TrialId:      "annotated",
+			TickId:       1,
+			State:        grpcapi.TrialState_ENDED,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0}, {Actor: 2}},
+		}})
+		assert.NoError(t, err)
+
+		annotatedSample = <-observer
+		assert.Equal(t, uint64(1), annotatedSample.Sample.TickId)
+		assert.Equal(t, []backend.ActorMetadata{
+			{Name: "actor-1", ActorClass: "class-1", Implementation: "impl-1"},
+			{Name: "actor-3", ActorClass: "class-1", Implementation: "impl-3"},
+		}, annotatedSample.Actors)
+
+		_, ok := <-observer
+		assert.False(t, ok)
+	})
+
+	t.Run("TestObserveSamplesTickRange", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "tick-range", Params: generateTrialParams(2, 100)}})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "tick-range", TickId: 0, State: grpcapi.TrialState_RUNNING},
+			{TrialId: "tick-range", TickId: 1, State: grpcapi.TrialState_RUNNING},
+			{TrialId: "tick-range", TickId: 2, State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+
+		observer := make(backend.TrialSampleObserver)
+		observationErr := make(chan error, 1)
+		go func() {
+			defer close(observer)
+			observationErr <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{
+				TrialIDs:   []string{"tick-range"},
+				FromTickID: pointy.Uint64(1),
+				ToTickID:   pointy.Uint64(3),
+			}, observer)
+		}()
+
+		for _, expectedTickID := range []uint64{1, 2} {
+			sample := <-observer
+			assert.Equal(t, expectedTickID, sample.TickId)
+		}
+
+		// The observation of the ongoing trial ends once a sample after the range is stored
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "tick-range", TickId: 3, State: grpcapi.TrialState_RUNNING},
+			{TrialId: "tick-range", TickId: 4, State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+
+		sample := <-observer
+		assert.Equal(t, uint64(3), sample.TickId)
+		_, ok := <-observer
+		assert.False(t, ok)
+		assert.NoError(t, <-observationErr)
+	})
+
+	t.Run("TestObserveSamplesTickRangeOutOfOrder", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "tick-range", Params: generateTrialParams(2, 100)}})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for _, tickID := range []uint64{1, 2, 5, 3, 4} {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: "tick-range", TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+		err = b.EndTrials(context.Background(), []string{"tick-range"})
+		assert.NoError(t, err)
+
+		observer := make(backend.TrialSampleObserver)
+		observationErr := make(chan error, 1)
+		go func() {
+			defer close(observer)
+			observationErr <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{
+				TrialIDs:   []string{"tick-range"},
+				FromTickID: pointy.Uint64(1),
+				ToTickID:   pointy.Uint64(4),
+			}, observer)
+		}()
+
+		// The samples stored after one past the range are still selected
+		tickIDs := []uint64{}
+		for sample := range observer {
+			tickIDs = append(tickIDs, sample.TickId)
+		}
+		assert.NoError(t, <-observationErr)
+		assert.Equal(t, []uint64{1, 2, 3, 4}, tickIDs)
+	})
+
+	t.Run("TestRetrieveTrialsSummary", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "summary-1", Params: generateTrialParams(2, 100)},
+			{TrialID: "summary-2", Params: generateTrialParams(2, 100)},
+		})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{
+			{TrialId: "summary-1", TickId: 4, State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{[]byte("a payload")}},
+			{TrialId: "summary-1", TickId: 2, State: grpcapi.TrialState_RUNNING},
+			{TrialId: "summary-1", TickId: 7, State: grpcapi.TrialState_ENDED, Payloads: [][]byte{[]byte("another payload")}},
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"summary-1", "summary-2"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, trialsInfo.TrialInfos[0].StoredSamplesCount)
+		// The stored size depends on the backend payload compression
+		assert.Greater(t, trialsInfo.TrialInfos[0].StoredSamplesSize, 0)
+		assert.Equal(t, uint64(2), trialsInfo.TrialInfos[0].MinTickID)
+		assert.Equal(t, uint64(7), trialsInfo.TrialInfos[0].MaxTickID)
+		assert.Equal(t, 0, trialsInfo.TrialInfos[1].StoredSamplesCount)
+		assert.Equal(t, 0, trialsInfo.TrialInfos[1].StoredSamplesSize)
+
+		// Reindexing doesn't change the summary
+		err = b.Reindex(context.Background())
+		assert.NoError(t, err)
+		reindexedTrialsInfo, err := b.RetrieveTrials(context.Background(), []string{"summary-1", "summary-2"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, trialsInfo, reindexedTrialsInfo)
+
+		err = b.ClearSamples(context.Background(), "summary-1")
+		assert.NoError(t, err)
+		trialsInfo, err = b.RetrieveTrials(context.Background(), []string{"summary-1"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, trialsInfo.TrialInfos[0].StoredSamplesCount)
+		assert.Equal(t, 0, trialsInfo.TrialInfos[0].StoredSamplesSize)
+	})
+
+	t.Run("TestExportImportTrial", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		observeTrialSamples := func(b backend.Backend, trialID string) []*grpcapi.StoredTrialSample {
+			observer := make(backend.TrialSampleObserver)
+			go func() {
+				defer close(observer)
+				err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{trialID}}, observer)
+				assert.NoError(t, err)
+			}()
+			samples := []*grpcapi.StoredTrialSample{}
+			for sample := range observer {
+				samples = append(samples, sample)
+			}
+			return samples
+		}
+
+		trialParams := generateTrialParams(2, 500)
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "exported", UserID: "my-user", Params: trialParams},
+		})
+		assert.NoError(t, err)
+		samplesCount := 250 // More than a chunk of imported samples
+		samples := make([]*grpcapi.StoredTrialSample, 0, samplesCount)
+		for sampleIdx := 0; sampleIdx < samplesCount; sampleIdx++ {
+			samples = append(samples, generateSample("exported", 2, 16, sampleIdx == samplesCount-1))
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		archive := strings.Builder{}
+		err = backend.ExportTrial(context.Background(), b, "exported", &archive)
+		assert.NoError(t, err)
+
+		importedBackend := createBackend()
+		defer destroyBackend(importedBackend)
+
+		trialID, err := backend.ImportTrial(context.Background(), importedBackend, strings.NewReader(archive.String()), backend.ImportTrialOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "exported", trialID)
+
+		trialsInfo, err := importedBackend.RetrieveTrials(context.Background(), []string{"exported"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Len(t, trialsInfo.TrialInfos, 1)
+		assert.Equal(t, "my-user", trialsInfo.TrialInfos[0].UserID)
+		assert.Equal(t, grpcapi.TrialState_ENDED, trialsInfo.TrialInfos[0].State)
+		assert.Equal(t, samplesCount, trialsInfo.TrialInfos[0].StoredSamplesCount)
+		trialsParams, err := importedBackend.GetTrialParams(context.Background(), []string{"exported"})
+		assert.NoError(t, err)
+		assert.True(t, proto.Equal(trialParams, trialsParams[0].Params))
+
+		exportedSamples := observeTrialSamples(b, "exported")
+		importedSamples := observeTrialSamples(importedBackend, "exported")
+		assert.Len(t, importedSamples, samplesCount)
+		for sampleIdx := range exportedSamples {
+			assert.True(t, proto.Equal(exportedSamples[sampleIdx], importedSamples[sampleIdx]))
+		}
+
+		// Re-importing an existing trial fails unless it is explicitly skipped
+		_, err = backend.ImportTrial(context.Background(), importedBackend, strings.NewReader(archive.String()), backend.ImportTrialOptions{})
+		var alreadyExistsErr *backend.TrialAlreadyExistsError
+		assert.True(t, errors.As(err, &alreadyExistsErr))
+		trialID, err = backend.ImportTrial(context.Background(), importedBackend, strings.NewReader(archive.String()), backend.ImportTrialOptions{SkipExisting: true})
+		assert.NoError(t, err)
+		assert.Equal(t, "exported", trialID)
+
+		// Importing under another id
+		trialID, err = backend.ImportTrial(context.Background(), importedBackend, strings.NewReader(archive.String()), backend.ImportTrialOptions{TrialID: "copy"})
+		assert.NoError(t, err)
+		assert.Equal(t, "copy", trialID)
+		assert.Len(t, observeTrialSamples(importedBackend, "copy"), samplesCount)
+
+		// A truncated archive isn't imported
+		_, err = backend.ImportTrial(context.Background(), importedBackend, strings.NewReader(archive.String()[:archive.Len()-10]), backend.ImportTrialOptions{TrialID: "truncated"})
+		assert.Error(t, err)
+		exists, err := backend.TrialExists(context.Background(), importedBackend, "truncated")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		// An archive with samples larger than the maximum message size isn't imported
+		_, err = backend.ImportTrial(context.Background(), importedBackend, strings.NewReader(archive.String()), backend.ImportTrialOptions{TrialID: "too-large", MaxMessageSize: 16})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "exceeds the maximum size of 16B")
+		}
+		exists, err = backend.TrialExists(context.Background(), importedBackend, "too-large")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		// An invalid archive isn't imported
+		_, err = backend.ImportTrial(context.Background(), importedBackend, strings.NewReader("not an archive"), backend.ImportTrialOptions{})
+		assert.Error(t, err)
+
+		// Unknown trials can't be exported
+		err = backend.ExportTrial(context.Background(), b, "unknown", &strings.Builder{})
+		var unknownTrialErr *backend.UnknownTrialError
+		assert.True(t, errors.As(err, &unknownTrialErr))
+	})
+
+	t.Run("TestConcurrentReadersAndWriter", func(t *testing.T) {
+		// Meant to be run with the race detector
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "concurrent", Params: generateTrialParams(2, 500)},
+		})
+		assert.NoError(t, err)
+
+		samplesCount := 200
+		readersCount := 8
+		// Each sample payload is derived from its tick id to detect any corruption
+		makeTickPayload := func(tickID uint64) []byte {
+			payload := make([]byte, 256)
+			for idx := range payload {
+				payload[idx] = byte(tickID) + byte(idx)
+			}
+			return payload
+		}
+
+		wg := sync.WaitGroup{}
+		wg.Add(readersCount + 1)
+		go func() {
+			defer wg.Done()
+			for tickID := uint64(0); tickID < uint64(samplesCount); tickID++ {
+				sample := &grpcapi.StoredTrialSample{
+					TrialId:  "concurrent",
+					TickId:   tickID,
+					State:    grpcapi.TrialState_RUNNING,
+					Payloads: [][]byte{makeTickPayload(tickID)},
+				}
+				if tickID == uint64(samplesCount-1) {
+					sample.State = grpcapi.TrialState_ENDED
+				}
+				err := b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{sample})
+				assert.NoError(t, err)
+			}
+		}()
+		for readerIdx := 0; readerIdx < readersCount; readerIdx++ {
+			readerIdx := readerIdx
+			go func() {
+				defer wg.Done()
+				// Readers start at different points of the writing
+				time.Sleep(time.Duration(readerIdx) * time.Millisecond)
+
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				observer := make(backend.TrialSampleObserver)
+				go func() {
+					defer close(observer)
+					err := b.ObserveSamples(ctx, backend.TrialSampleFilter{TrialIDs: []string{"concurrent"}}, observer)
+					assert.NoError(t, err)
+				}()
+				// Every reader sees every sample, in order and intact
+				expectedTickID := uint64(0)
+				for sample := range observer {
+					assert.Equal(t, expectedTickID, sample.TickId)
+					assert.Equal(t, makeTickPayload(sample.TickId), sample.Payloads[0])
+					expectedTickID++
+
+					trialsInfo, err := b.RetrieveTrials(ctx, []string{"concurrent"}, -1, -1)
+					assert.NoError(t, err)
+					assert.GreaterOrEqual(t, trialsInfo.TrialInfos[0].StoredSamplesCount, int(expectedTickID))
+				}
+				assert.Equal(t, uint64(samplesCount), expectedTickID)
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("TestDeleteTrialsMatching", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		trialIDs := []string{"debug-1", "debug-2", "debug-3", "run-1", "run-2"}
+		for _, trialID := range trialIDs {
+			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+				{TrialID: trialID, Params: generateTrialParams(2, 100)},
+			})
+			assert.NoError(t, err)
+			err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+				generateSample(trialID, 2, 16, false),
+				generateSample(trialID, 2, 16, true),
+			})
+			assert.NoError(t, err)
+		}
+		retrieveTrialIDs := func() []string {
+			trialsInfo, err := b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+			assert.NoError(t, err)
+			return extractTrialIDs(trialsInfo.TrialInfos)
+		}
+
+		// A filter without criterion deletes nothing
+		result, err := backend.DeleteTrialsMatching(context.Background(), b, backend.TrialsDeletionFilter{})
+		assert.NoError(t, err)
+		assert.Empty(t, result.DeletedTrialIDs)
+		assert.ElementsMatch(t, trialIDs, retrieveTrialIDs())
+
+		result, err = backend.DeleteTrialsMatching(context.Background(), b, backend.TrialsDeletionFilter{TrialIDPrefix: "debug-"})
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"debug-1", "debug-2", "debug-3"}, result.DeletedTrialIDs)
+		assert.Equal(t, 6, result.DeletedSamplesCount)
+		assert.ElementsMatch(t, []string{"run-1", "run-2"}, retrieveTrialIDs())
+
+		// Strict deletions of unknown trials fail without deleting anything
+		_, err = backend.DeleteTrialsMatching(context.Background(), b, backend.TrialsDeletionFilter{
+			TrialIDs: []string{"run-1", "debug-1"},
+			Strict:   true,
+		})
+		var unknownTrialErr *backend.UnknownTrialError
+		assert.ErrorAs(t, err, &unknownTrialErr)
+		assert.Equal(t, "debug-1", unknownTrialErr.TrialID)
+		assert.ElementsMatch(t, []string{"run-1", "run-2"}, retrieveTrialIDs())
+
+		// Otherwise unknown trials are skipped, both criteria need to match
+		result, err = backend.DeleteTrialsMatching(context.Background(), b, backend.TrialsDeletionFilter{
+			TrialIDs:      []string{"run-1", "run-2", "debug-1"},
+			TrialIDPrefix: "run-1",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"run-1"}, result.DeletedTrialIDs)
+		assert.Equal(t, 2, result.DeletedSamplesCount)
+		assert.Equal(t, []string{"run-2"}, retrieveTrialIDs())
+	})
+	t.Run("TestTrialsCreationOrder", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		before := time.Now()
+		for _, trialID := range []string{"trial-c", "trial-a", "trial-b"} {
+			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+				{TrialID: trialID, UserID: "my-user", Params: generateTrialParams(2, 100)},
+			})
+			assert.NoError(t, err)
+		}
+		after := time.Now()
+
+		r, err := b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"trial-c", "trial-a", "trial-b"}, extractTrialIDs(r.TrialInfos))
+		for trialInfoIdx, trialInfo := range r.TrialInfos {
+			assert.False(t, trialInfo.CreatedAt.Before(before))
+			assert.False(t, trialInfo.CreatedAt.After(after))
+			if trialInfoIdx > 0 {
+				assert.True(t, trialInfo.CreatedAt.After(r.TrialInfos[trialInfoIdx-1].CreatedAt))
+			}
+		}
+
+		// Updating a trial keeps its creation timestamp and order
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "trial-c", UserID: "my-other-user", Params: generateTrialParams(2, 200)},
+		})
+		assert.NoError(t, err)
+		updatedR, err := b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"trial-c", "trial-a", "trial-b"}, extractTrialIDs(updatedR.TrialInfos))
+		assert.True(t, r.TrialInfos[0].CreatedAt.Equal(updatedR.TrialInfos[0].CreatedAt))
+
+		// Trials can be deleted by creation time
+		result, err := backend.DeleteTrialsMatching(context.Background(), b, backend.TrialsDeletionFilter{CreatedBefore: r.TrialInfos[1].CreatedAt})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"trial-c"}, result.DeletedTrialIDs)
+		result, err = backend.DeleteTrialsMatching(context.Background(), b, backend.TrialsDeletionFilter{TrialIDPrefix: "trial-a", CreatedBefore: after})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"trial-a"}, result.DeletedTrialIDs)
+	})
+	t.Run("TestRetrieveSampleWindows", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "windows-1", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "actor-0"}, {Name: "actor-1"}}}},
+		})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 10; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{
+				TrialId: "windows-1",
+				TickId:  tickID,
+				State:   grpcapi.TrialState_RUNNING,
+				ActorSamples: []*grpcapi.StoredTrialActorSample{
+					{Actor: 0, Reward: pointy.Float32(float32(tickID))},
+					{Actor: 1, Reward: pointy.Float32(1)},
+				},
+			})
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		windowSamples, err := backend.RetrieveSampleWindows(context.Background(), b, "windows-1", 2, backend.TrialSampleFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, windowSamples, 2)
+		assert.Equal(t, uint64(4), windowSamples[0].TickId)
+		assert.Equal(t, float32(2), *windowSamples[0].ActorSamples[0].Reward)
+		assert.Equal(t, float32(1), *windowSamples[0].ActorSamples[1].Reward)
+		assert.Equal(t, uint64(9), windowSamples[1].TickId)
+		assert.Equal(t, float32(7), *windowSamples[1].ActorSamples[0].Reward)
+
+		// Combined with a tick range and an actors filter
+		windowSamples, err = backend.RetrieveSampleWindows(context.Background(), b, "windows-1", 2, backend.TrialSampleFilter{
+			ActorNames: []string{"actor-0"},
+			FromTickID: pointy.Uint64(2),
+			ToTickID:   pointy.Uint64(5),
+		})
+		assert.NoError(t, err)
+		assert.Len(t, windowSamples, 2)
+		assert.Equal(t, uint64(3), windowSamples[0].TickId)
+		assert.Len(t, windowSamples[0].ActorSamples, 1)
+		assert.Equal(t, float32(2.5), *windowSamples[0].ActorSamples[0].Reward)
+		assert.Equal(t, uint64(5), windowSamples[1].TickId)
+		assert.Equal(t, float32(4.5), *windowSamples[1].ActorSamples[0].Reward)
+
+		_, err = backend.RetrieveSampleWindows(context.Background(), b, "windows-2", 2, backend.TrialSampleFilter{})
+		var unknownTrialErr *backend.UnknownTrialError
+		assert.ErrorAs(t, err, &unknownTrialErr)
+	})
+	t.Run("TestEndTrials", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "ended-1", Params: generateTrialParams(2, 100)},
+			{TrialID: "ended-2", Params: generateTrialParams(2, 100)},
+		})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "ended-1", TickId: 0, State: grpcapi.TrialState_RUNNING},
+			{TrialId: "ended-1", TickId: 1, State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+
+		// An ongoing observation of the trial waits for more samples
+		observer := make(backend.TrialSampleObserver)
+		observeResult := make(chan error, 1)
+		go func() {
+			defer close(observer)
+			observeResult <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"ended-1", "ended-2"}}, observer)
+		}()
+		tickIDs := []uint64{}
+		for len(tickIDs) < 2 {
+			tickIDs = append(tickIDs, (<-observer).TickId)
+		}
+
+		r, err := b.RetrieveTrials(context.Background(), []string{"ended-1", "ended-2"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, grpcapi.TrialState_RUNNING, r.TrialInfos[0].State)
+		assert.Equal(t, grpcapi.TrialState_UNKNOWN, r.TrialInfos[1].State)
+
+		// Ending the trials, with or without samples, ends the observation
+		err = b.EndTrials(context.Background(), []string{"ended-1", "ended-2"})
+		assert.NoError(t, err)
+		for sample := range observer {
+			tickIDs = append(tickIDs, sample.TickId)
+		}
+		assert.NoError(t, <-observeResult)
+		assert.Equal(t, []uint64{0, 1}, tickIDs)
+
+		r, err = b.RetrieveTrials(context.Background(), []string{"ended-1", "ended-2"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, grpcapi.TrialState_ENDED, r.TrialInfos[0].State)
+		assert.Equal(t, 2, r.TrialInfos[0].StoredSamplesCount)
+		assert.Equal(t, grpcapi.TrialState_ENDED, r.TrialInfos[1].State)
+
+		// Samples added afterwards define the trial state
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "ended-1", TickId: 2, State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+		r, err = b.RetrieveTrials(context.Background(), []string{"ended-1"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, grpcapi.TrialState_RUNNING, r.TrialInfos[0].State)
+
+		err = b.EndTrials(context.Background(), []string{"ended-3"})
+		var unknownTrialErr *backend.UnknownTrialError
+		assert.ErrorAs(t, err, &unknownTrialErr)
+	})
+	t.Run("TestRetrieveActorClassPayloads", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "payloads-1", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{
+				{Name: "player-1", ActorClass: "player"},
+				{Name: "referee", ActorClass: "referee"},
+				{Name: "player-2", ActorClass: "player"},
+			}}},
+		})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 3; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{
+				TrialId: "payloads-1",
+				TickId:  tickID,
+				State:   grpcapi.TrialState_RUNNING,
+				ActorSamples: []*grpcapi.StoredTrialActorSample{
+					{Actor: 0, Observation: pointy.Uint32(0), Action: pointy.Uint32(1)},
+					{Actor: 1, Observation: pointy.Uint32(0), Action: pointy.Uint32(2)},
+					{Actor: 2, Observation: pointy.Uint32(3), Action: pointy.Uint32(4)},
+				},
+				Payloads: [][]byte{
+					[]byte(fmt.Sprintf("obs-%d", tickID)),
+					[]byte(fmt.Sprintf("action-1-%d", tickID)),
+					[]byte(fmt.Sprintf("action-referee-%d", tickID)),
+					[]byte(fmt.Sprintf("obs-2-%d", tickID)),
+					[]byte(fmt.Sprintf("action-2-%d", tickID)),
+				},
+			})
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		// The trial is ongoing, only the stored samples are retrieved
+		payloads, err := backend.RetrieveActorClassPayloads(context.Background(), b, "payloads-1", "player")
+		assert.NoError(t, err)
+		assert.Len(t, payloads, 6)
+		for payloadsIdx, actorPayloads := range payloads {
+			tickID := uint64(payloadsIdx / 2)
+			assert.Equal(t, tickID, actorPayloads.TickID)
+			if payloadsIdx%2 == 0 {
+				assert.Equal(t, uint32(0), actorPayloads.ActorIdx)
+				assert.Equal(t, "player-1", actorPayloads.ActorName)
+				assert.Equal(t, []byte(fmt.Sprintf("obs-%d", tickID)), actorPayloads.Observation)
+				assert.Equal(t, []byte(fmt.Sprintf("action-1-%d", tickID)), actorPayloads.Action)
+			} else {
+				assert.Equal(t, uint32(2), actorPayloads.ActorIdx)
+				assert.Equal(t, "player-2", actorPayloads.ActorName)
+				assert.Equal(t, []byte(fmt.Sprintf("obs-2-%d", tickID)), actorPayloads.Observation)
+				assert.Equal(t, []byte(fmt.Sprintf("action-2-%d", tickID)), actorPayloads.Action)
+			}
+		}
+
+		payloads, err = backend.RetrieveActorClassPayloads(context.Background(), b, "payloads-1", "spectator")
+		assert.NoError(t, err)
+		assert.Empty(t, payloads)
+
+		_, err = backend.RetrieveActorClassPayloads(context.Background(), b, "payloads-2", "player")
+		var unknownTrialErr *backend.UnknownTrialError
+		assert.ErrorAs(t, err, &unknownTrialErr)
+	})
+	t.Run("TestCanceledOperations", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "large", Params: generateTrialParams(2, 100)}})
+		assert.NoError(t, err)
+		samplesCount := 5000
+		samples := make([]*grpcapi.StoredTrialSample, samplesCount)
+		for sampleIdx := range samples {
+			samples[sampleIdx] = generateSample("large", 2, 10, sampleIdx == samplesCount-1)
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		// Canceling a retrieval stops it promptly, even if the observer doesn't read the remaining samples
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		observer := make(backend.TrialSampleObserver)
+		observeErr := make(chan error, 1)
+		go func() {
+			observeErr <- b.ObserveSamples(ctx, backend.TrialSampleFilter{TrialIDs: []string{"large"}}, observer)
+		}()
+		for sampleIdx := 0; sampleIdx < 10; sampleIdx++ {
+			<-observer
+		}
+		cancel()
+		select {
+		case err := <-observeErr:
+			assert.True(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "the canceled observation didn't stop")
+		}
+
+		// An expired deadline stops the retrieval as well
+		ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+		err = b.ObserveSamples(ctx, backend.TrialSampleFilter{TrialIDs: []string{"large"}}, make(backend.TrialSampleObserver))
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+
+		// Canceled additions and deletions don't change anything
+		canceledCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = b.AddSamples(canceledCtx, []*grpcapi.StoredTrialSample{generateSample("large", 2, 10, true)})
+		assert.True(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+		err = b.DeleteTrials(canceledCtx, []string{"large"})
+		assert.True(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+		trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"large"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Len(t, trialsInfo.TrialInfos, 1)
+		assert.Equal(t, samplesCount, trialsInfo.TrialInfos[0].StoredSamplesCount)
+	})
+	t.Run("TestTrialTags", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "tagged", Params: generateTrialParams(2, 10), Tags: map[string]string{"experiment": "foo", "seed": "42"}},
+			{TrialID: "untagged", Params: generateTrialParams(2, 10)},
+		})
+		assert.NoError(t, err)
+
+		trialsInfo, err := b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+		assert.NoError(t, err)
+		assert.Len(t, trialsInfo.TrialInfos, 2)
+		assert.Equal(t, map[string]string{"experiment": "foo", "seed": "42"}, trialsInfo.TrialInfos[0].Tags)
+		assert.Len(t, trialsInfo.TrialInfos[1].Tags, 0)
+
+		trialsParams, err := b.GetTrialParams(context.Background(), []string{"tagged", "untagged"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"experiment": "foo", "seed": "42"}, trialsParams[0].Tags)
+		assert.Len(t, trialsParams[1].Tags, 0)
+
+		// Updating a trial replaces its tags
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "tagged", Params: generateTrialParams(2, 10), Tags: map[string]string{"experiment": "bar"}},
+		})
+		assert.NoError(t, err)
+		trialsParams, err = b.GetTrialParams(context.Background(), []string{"tagged"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"experiment": "bar"}, trialsParams[0].Tags)
+	})
+
+	t.Run("TestStorageStats", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		stats, err := b.StorageStats(context.Background())
+		assert.NoError(t, err)
+		assert.NotEmpty(t, stats.BackendType)
+		assert.Equal(t, 0, stats.TrialsCount)
+		assert.Equal(t, 0, stats.SamplesCount)
+		assert.Equal(t, 0, stats.SamplesSize)
+
+		storedSamplesSize := func() int {
+			trialsInfo, err := b.RetrieveTrials(context.Background(), []string{}, -1, -1)
+			assert.NoError(t, err)
+			storedSamplesSize := 0
+			for _, trialInfo := range trialsInfo.TrialInfos {
+				storedSamplesSize += trialInfo.StoredSamplesSize
+			}
+			return storedSamplesSize
+		}
+
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "trial-1", Params: generateTrialParams(2, 10)},
+			{TrialID: "trial-2", Params: generateTrialParams(2, 10)},
+		})
+		assert.NoError(t, err)
+		trial2Sample := generateSample("trial-2", 2, 16, false)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			generateSample("trial-1", 2, 16, false),
+			generateSample("trial-1", 2, 16, false),
+			trial2Sample,
+		})
+		assert.NoError(t, err)
+		// Merging into an existing sample doesn't change the count
+		err = b.AddSamplePartial(context.Background(), &grpcapi.StoredTrialSample{TrialId: "trial-2", TickId: trial2Sample.TickId, State: grpcapi.TrialState_ENDED})
+		assert.NoError(t, err)
+		// Updating an existing trial doesn't change the count
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "trial-2", Params: generateTrialParams(2, 20)}})
+		assert.NoError(t, err)
+
+		stats, err = b.StorageStats(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, stats.TrialsCount)
+		assert.Equal(t, 3, stats.SamplesCount)
+		assert.Greater(t, stats.SamplesSize, 0)
+		assert.Equal(t, storedSamplesSize(), stats.SamplesSize)
+		if stats.Persistent {
+			assert.Greater(t, stats.FileSize, int64(0))
+		}
+
+		err = b.DeleteTrials(context.Background(), []string{"trial-1"})
+		assert.NoError(t, err)
+
+		stats, err = b.StorageStats(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stats.TrialsCount)
+		assert.Equal(t, 1, stats.SamplesCount)
+		assert.Equal(t, storedSamplesSize(), stats.SamplesSize)
+
+		err = b.ClearSamples(context.Background(), "trial-2")
+		assert.NoError(t, err)
+
+		stats, err = b.StorageStats(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stats.TrialsCount)
+		assert.Equal(t, 0, stats.SamplesCount)
+		assert.Equal(t, 0, stats.SamplesSize)
+
+		// Reindexing recounts the same stats
+		err = b.Reindex(context.Background())
+		assert.NoError(t, err)
+		reindexedStats, err := b.StorageStats(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, stats.TrialsCount, reindexedStats.TrialsCount)
+		assert.Equal(t, stats.SamplesCount, reindexedStats.SamplesCount)
+		assert.Equal(t, stats.SamplesSize, reindexedStats.SamplesSize)
+	})
+
+	t.Run("TestObserveSamplesReverse", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "reverse", Params: generateTrialParams(2, 1000)}})
+		assert.NoError(t, err)
+		samples := make([]*grpcapi.StoredTrialSample, 0, 250)
+		for tickID := uint64(0); tickID < 250; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: "reverse", TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		observeReverse := func(fromTickID *uint64, toTickID *uint64) []uint64 {
+			observer := make(backend.TrialSampleObserver)
+			observationErr := make(chan error, 1)
+			go func() {
+				defer close(observer)
+				observationErr <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{
+					TrialIDs:   []string{"reverse"},
+					FromTickID: fromTickID,
+					ToTickID:   toTickID,
+					Reverse:    true,
+				}, observer)
+			}()
+			tickIDs := []uint64{}
+			for sample := range observer {
+				tickIDs = append(tickIDs, sample.TickId)
+			}
+			assert.NoError(t, <-observationErr)
+			return tickIDs
+		}
+
+		// The observation of the ongoing trial ends once every stored sample is sent
+		tickIDs := observeReverse(nil, nil)
+		assert.Len(t, tickIDs, 250)
+		for sampleIdx, tickID := range tickIDs {
+			assert.Equal(t, uint64(249-sampleIdx), tickID)
+		}
+
+		assert.Equal(t, []uint64{7, 6, 5, 4, 3}, observeReverse(pointy.Uint64(3), pointy.Uint64(7)))
+		assert.Equal(t, []uint64{2, 1, 0}, observeReverse(nil, pointy.Uint64(2)))
+		assert.Equal(t, []uint64{249, 248}, observeReverse(pointy.Uint64(248), pointy.Uint64(1000)))
+		assert.Empty(t, observeReverse(pointy.Uint64(300), nil))
+	})
+
+	t.Run("TestObserveSamplesReverseOutOfOrder", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "reverse", Params: generateTrialParams(2, 100)}})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for _, tickID := range []uint64{1, 2, 5, 3, 4} {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: "reverse", TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		observeReverse := func(fromTickID *uint64, toTickID *uint64) []uint64 {
+			observer := make(backend.TrialSampleObserver)
+			observationErr := make(chan error, 1)
+			go func() {
+				defer close(observer)
+				observationErr <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{
+					TrialIDs:   []string{"reverse"},
+					FromTickID: fromTickID,
+					ToTickID:   toTickID,
+					Reverse:    true,
+				}, observer)
+			}()
+			tickIDs := []uint64{}
+			for sample := range observer {
+				tickIDs = append(tickIDs, sample.TickId)
+			}
+			assert.NoError(t, <-observationErr)
+			return tickIDs
+		}
+
+		// Samples are sent by decreasing tick id whatever the order in which they were added
+		assert.Equal(t, []uint64{5, 4, 3, 2, 1}, observeReverse(nil, nil))
+		assert.Equal(t, []uint64{5, 4, 3}, observeReverse(pointy.Uint64(3), nil))
+		assert.Equal(t, []uint64{4, 3, 2}, observeReverse(pointy.Uint64(2), pointy.Uint64(4)))
+	})
+
+	t.Run("TestImportTrialArchives", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		archivesDirPath := t.TempDir()
+		archiveFilePaths := []string{}
+		for _, trialID := range []string{"archived-1", "archived-2", "archived-3"} {
+			err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: trialID, Params: generateTrialParams(2, 100)}})
+			assert.NoError(t, err)
+			err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+				generateSample(trialID, 2, 16, false),
+				generateSample(trialID, 2, 16, true),
+			})
+			assert.NoError(t, err)
+
+			archive := strings.Builder{}
+			err = backend.ExportTrial(context.Background(), b, trialID, &archive)
+			assert.NoError(t, err)
+			archiveFilePath := filepath.Join(archivesDirPath, trialID+".ctd")
+			err = os.WriteFile(archiveFilePath, []byte(archive.String()), 0600)
+			assert.NoError(t, err)
+			archiveFilePaths = append(archiveFilePaths, archiveFilePath)
+		}
+		invalidArchiveFilePath := filepath.Join(archivesDirPath, "invalid.ctd")
+		err := os.WriteFile(invalidArchiveFilePath, []byte("not an archive"), 0600)
+		assert.NoError(t, err)
+		archiveFilePaths = append(archiveFilePaths, invalidArchiveFilePath, filepath.Join(archivesDirPath, "missing.ctd"))
+
+		importedBackend := createBackend()
+		defer destroyBackend(importedBackend)
+
+		// An existing trial, without samples
+		err = importedBackend.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "archived-2", Params: generateTrialParams(2, 100)}})
+		assert.NoError(t, err)
+
+		results := []backend.TrialArchiveImport{}
+		lastDone := 0
+		summary := backend.ImportTrialArchives(context.Background(), importedBackend, archiveFilePaths, backend.ImportTrialArchivesOptions{
+			Concurrency:  2,
+			SkipExisting: true,
+			Progress: func(result backend.TrialArchiveImport, done int, total int) {
+				results = append(results, result)
+				assert.Equal(t, lastDone+1, done)
+				assert.Equal(t, 5, total)
+				lastDone = done
+			},
+		})
+		assert.Equal(t, backend.ImportTrialArchivesSummary{Succeeded: 2, Failed: 2, Skipped: 1}, summary)
+		assert.Len(t, results, 5)
+		for _, result := range results {
+			switch result.FilePath {
+			case invalidArchiveFilePath, filepath.Join(archivesDirPath, "missing.ctd"):
+				assert.Error(t, result.Err)
+				assert.Empty(t, result.TrialID)
+			case archiveFilePaths[1]:
+				assert.NoError(t, result.Err)
+				assert.True(t, result.Skipped)
+				assert.Equal(t, "archived-2", result.TrialID)
+			default:
+				assert.NoError(t, result.Err)
+				assert.False(t, result.Skipped)
+			}
+		}
+		trialsInfo, err := importedBackend.RetrieveTrials(context.Background(), []string{"archived-1", "archived-2", "archived-3"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Len(t, trialsInfo.TrialInfos, 3)
+		for _, trialInfo := range trialsInfo.TrialInfos {
+			if trialInfo.TrialID == "archived-2" {
+				assert.Equal(t, 0, trialInfo.StoredSamplesCount)
+			} else {
+				assert.Equal(t, 2, trialInfo.StoredSamplesCount)
+			}
+		}
+
+		// Without skipping them, existing trials fail unless they are overwritten
+		summary = backend.ImportTrialArchives(context.Background(), importedBackend, archiveFilePaths[:3], backend.ImportTrialArchivesOptions{})
+		assert.Equal(t, backend.ImportTrialArchivesSummary{Failed: 3}, summary)
+		summary = backend.ImportTrialArchives(context.Background(), importedBackend, archiveFilePaths[:3], backend.ImportTrialArchivesOptions{Overwrite: true})
+		assert.Equal(t, backend.ImportTrialArchivesSummary{Succeeded: 3}, summary)
+		trialsInfo, err = importedBackend.RetrieveTrials(context.Background(), []string{"archived-2"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Len(t, trialsInfo.TrialInfos, 1)
+		assert.Equal(t, 2, trialsInfo.TrialInfos[0].StoredSamplesCount)
+		assert.Equal(t, grpcapi.TrialState_ENDED, trialsInfo.TrialInfos[0].State)
+	})
+
+	t.Run("TestRetrieveRandomSamples", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "random-1", Params: &grpcapi.TrialParams{}},
+		})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 20; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: "random-1", TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		tickIDs := func(samples []*grpcapi.StoredTrialSample) []uint64 {
+			tickIDs := []uint64{}
+			for _, sample := range samples {
+				tickIDs = append(tickIDs, sample.TickId)
+			}
+			return tickIDs
+		}
+
+		randomSamples, err := backend.RetrieveRandomSamples(context.Background(), b, "random-1", 5, 42, backend.TrialSampleFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, randomSamples, 5)
+		assert.IsIncreasing(t, tickIDs(randomSamples))
+
+		// The same seed results in the same samples
+		sameRandomSamples, err := backend.RetrieveRandomSamples(context.Background(), b, "random-1", 5, 42, backend.TrialSampleFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, tickIDs(randomSamples), tickIDs(sameRandomSamples))
+
+		otherRandomSamples, err := backend.RetrieveRandomSamples(context.Background(), b, "random-1", 5, 43, backend.TrialSampleFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, otherRandomSamples, 5)
+		assert.NotEqual(t, tickIDs(randomSamples), tickIDs(otherRandomSamples))
+
+		// Within a tick range
+		randomSamples, err = backend.RetrieveRandomSamples(context.Background(), b, "random-1", 3, 42, backend.TrialSampleFilter{
+			FromTickID: pointy.Uint64(10),
+			ToTickID:   pointy.Uint64(14),
+		})
+		assert.NoError(t, err)
+		assert.Len(t, randomSamples, 3)
+		for _, sample := range randomSamples {
+			assert.GreaterOrEqual(t, sample.TickId, uint64(10))
+			assert.LessOrEqual(t, sample.TickId, uint64(14))
+		}
+
+		// Requesting more samples than stored returns all of them
+		randomSamples, err = backend.RetrieveRandomSamples(context.Background(), b, "random-1", 50, 42, backend.TrialSampleFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, tickIDs(samples), tickIDs(randomSamples))
+
+		_, err = backend.RetrieveRandomSamples(context.Background(), b, "random-2", 5, 42, backend.TrialSampleFilter{})
+		var unknownTrialErr *backend.UnknownTrialError
+		assert.ErrorAs(t, err, &unknownTrialErr)
+	})
+
+	t.Run("TestConcurrentDeleteAndAddSamples", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		ctx := context.Background()
+		trialsParams := []*backend.TrialParams{{TrialID: "concurrent", Params: &grpcapi.TrialParams{}}}
+		err := b.CreateOrUpdateTrials(ctx, trialsParams)
+		assert.NoError(t, err)
+
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for tickID := uint64(0); tickID < 100; tickID++ {
+				err := b.AddSamples(ctx, []*grpcapi.StoredTrialSample{{TrialId: "concurrent", TickId: tickID, State: grpcapi.TrialState_RUNNING}})
+				if err != nil {
+					// Additions racing a deletion are rejected as a whole
+					var unknownTrialErr *backend.UnknownTrialError
+					assert.ErrorAs(t, err, &unknownTrialErr)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, b.DeleteTrials(ctx, []string{"concurrent"}))
+				// Registering a deleted trial again creates a new trial
+				assert.NoError(t, b.CreateOrUpdateTrials(ctx, trialsParams))
+			}
+		}()
+		wg.Wait()
+
+		err = b.AddSamples(ctx, []*grpcapi.StoredTrialSample{{TrialId: "concurrent", TickId: 100, State: grpcapi.TrialState_ENDED}})
+		assert.NoError(t, err)
+
+		exist, err := b.TrialsExist(ctx, []string{"concurrent"})
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{true}, exist)
+		trialsInfo, err := b.RetrieveTrials(ctx, []string{}, -1, -1)
+		assert.NoError(t, err)
+		assert.Len(t, trialsInfo.TrialInfos, 1)
+
+		// The stored samples are consistent with the trial info
+		samples := []*grpcapi.StoredTrialSample{}
+		observer := make(backend.TrialSampleObserver)
+		go func() {
+			defer close(observer)
+			err := b.ObserveSamples(ctx, backend.TrialSampleFilter{TrialIDs: []string{"concurrent"}}, observer)
+			assert.NoError(t, err)
+		}()
+		for sample := range observer {
+			samples = append(samples, sample)
+		}
+		assert.Len(t, samples, trialsInfo.TrialInfos[0].StoredSamplesCount)
+		assert.Equal(t, uint64(100), samples[len(samples)-1].TickId)
+		for sampleIdx := 1; sampleIdx < len(samples); sampleIdx++ {
+			assert.Less(t, samples[sampleIdx-1].TickId, samples[sampleIdx].TickId)
+		}
+	})
+
+	t.Run("TestRetrieveSample", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "inspected-1", UserID: "my-user", Params: generateTrialParams(2, 100)},
+			{TrialID: "inspected-2", Params: &grpcapi.TrialParams{}},
+		})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for _, tickID := range []uint64{2, 3, 5, 6} {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: "inspected-1", TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		// The trial is still ongoing, the retrieval doesn't wait for its next samples
+		sample, trialParams, err := backend.RetrieveSample(context.Background(), b, "inspected-1", 5)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(5), sample.TickId)
+		assert.Equal(t, "my-user", trialParams.UserID)
+		assert.Len(t, trialParams.Params.Actors, 2)
+
+		sample, _, err = backend.RetrieveSample(context.Background(), b, "inspected-1", 6)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(6), sample.TickId)
+
+		for _, tickID := range []uint64{0, 4, 7} {
+			_, _, err = backend.RetrieveSample(context.Background(), b, "inspected-1", tickID)
+			var unknownSampleErr *backend.UnknownSampleError
+			assert.ErrorAs(t, err, &unknownSampleErr)
+			assert.Equal(t, tickID, unknownSampleErr.TickID)
+		}
+
+		_, _, err = backend.RetrieveSample(context.Background(), b, "inspected-2", 0)
+		var unknownSampleErr *backend.UnknownSampleError
+		assert.ErrorAs(t, err, &unknownSampleErr)
+
+		_, _, err = backend.RetrieveSample(context.Background(), b, "unknown-trial", 0)
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+	})
+
+	t.Run("TestRetrieveLatestSample", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "my-trial", Params: &grpcapi.TrialParams{
+				Actors: []*grpcapi.ActorParams{
+					{Name: "actor-1", ActorClass: "class-1", Implementation: "impl-1"},
+					{Name: "actor-2", ActorClass: "class-2", Implementation: "impl-2"},
+				},
+			}},
+		})
+		assert.NoError(t, err)
+
+		sample, err := b.RetrieveLatestSample(context.Background(), "my-trial", backend.TrialSampleFilter{})
+		assert.NoError(t, err)
+		assert.Nil(t, sample)
+
+		for _, tickID := range []uint64{0, 1, 3, 4, 8} {
+			err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+				{
+					TrialId: "my-trial",
+					TickId:  tickID,
+					State:   grpcapi.TrialState_RUNNING,
+					ActorSamples: []*grpcapi.StoredTrialActorSample{
+						{Actor: 0, Observation: pointy.Uint32(0), Action: pointy.Uint32(1)},
+						{Actor: 1, Observation: pointy.Uint32(0)},
+					},
+					Payloads: [][]byte{[]byte(fmt.Sprintf("observation %d", tickID)), []byte("action")},
+				},
+			})
+			assert.NoError(t, err)
+
+			sample, err := b.RetrieveLatestSample(context.Background(), "my-trial", backend.TrialSampleFilter{})
+			assert.NoError(t, err)
+			assert.Equal(t, tickID, sample.TickId)
+			assert.Len(t, sample.ActorSamples, 2)
+			assert.Equal(t, []byte(fmt.Sprintf("observation %d", tickID)), sample.Payloads[0])
+		}
+
+		sample, err = b.RetrieveLatestSample(context.Background(), "my-trial", backend.TrialSampleFilter{
+			ActorNames: []string{"actor-2"},
+			Fields:     []grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(8), sample.TickId)
+		assert.Len(t, sample.ActorSamples, 1)
+		assert.Equal(t, uint32(1), sample.ActorSamples[0].Actor)
+		assert.Nil(t, sample.ActorSamples[0].Action)
+		assert.Equal(t, []byte("observation 8"), sample.Payloads[0])
+
+		// Only the latest sample is considered, it has no action for the second actor
+		sample, err = b.RetrieveLatestSample(context.Background(), "my-trial", backend.TrialSampleFilter{
+			ActorNames:     []string{"actor-2"},
+			RequireActions: true,
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, sample)
+
+		_, err = b.RetrieveLatestSample(context.Background(), "unknown-trial", backend.TrialSampleFilter{})
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+	})
+
+	t.Run("TestTrialResult", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "my-trial", Params: generateTrialParams(2, 100)},
+		})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample("my-trial", 2, 8, true)})
+		assert.NoError(t, err)
+
+		_, err = b.GetTrialResult(context.Background(), "my-trial")
+		var noTrialResultErr *backend.NoTrialResultError
+		assert.ErrorAs(t, err, &noTrialResultErr)
+		assert.Equal(t, "my-trial", noTrialResultErr.TrialID)
+
+		err = b.SetTrialResult(context.Background(), "my-trial", &backend.TrialResult{Scores: map[string]float64{"return": 12.5}, Data: []byte("details")})
+		assert.NoError(t, err)
+		result, err := b.GetTrialResult(context.Background(), "my-trial")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]float64{"return": 12.5}, result.Scores)
+		assert.Equal(t, []byte("details"), result.Data)
+
+		// Setting the result again replaces it
+		err = b.SetTrialResult(context.Background(), "my-trial", &backend.TrialResult{Scores: map[string]float64{"return": 3, "steps": 10}})
+		assert.NoError(t, err)
+		result, err = b.GetTrialResult(context.Background(), "my-trial")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]float64{"return": 3, "steps": 10}, result.Scores)
+		assert.Empty(t, result.Data)
+
+		// Updating the trial params keeps the result
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "my-trial", Params: generateTrialParams(2, 200)},
+		})
+		assert.NoError(t, err)
+		result, err = b.GetTrialResult(context.Background(), "my-trial")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]float64{"return": 3, "steps": 10}, result.Scores)
+
+		// Clearing the trial samples for a new run deletes the result
+		err = b.ClearSamples(context.Background(), "my-trial")
+		assert.NoError(t, err)
+		_, err = b.GetTrialResult(context.Background(), "my-trial")
+		assert.ErrorAs(t, err, &noTrialResultErr)
+
+		err = b.SetTrialResult(context.Background(), "unknown-trial", &backend.TrialResult{})
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+		_, err = b.GetTrialResult(context.Background(), "unknown-trial")
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+
+		err = b.DeleteTrials(context.Background(), []string{"my-trial"})
+		assert.NoError(t, err)
+		_, err = b.GetTrialResult(context.Background(), "my-trial")
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+	})
+
+	t.Run("TestMergeTrials", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		actors := []*grpcapi.ActorParams{{Name: "actor-0", ActorClass: "player"}, {Name: "actor-1", ActorClass: "player"}}
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "merge-destination", Params: &grpcapi.TrialParams{Actors: actors, MaxSteps: 100}},
+			{TrialID: "merge-source-1", Params: &grpcapi.TrialParams{Actors: actors, MaxSteps: 50}},
+			{TrialID: "merge-source-2", Params: &grpcapi.TrialParams{Actors: actors}},
+		})
+		assert.NoError(t, err)
+		addSamples := func(trialID string, tickIDs ...uint64) {
+			samples := []*grpcapi.StoredTrialSample{}
+			for _, tickID := range tickIDs {
+				samples = append(samples, &grpcapi.StoredTrialSample{
+					TrialId:  trialID,
+					TickId:   tickID,
+					State:    grpcapi.TrialState_RUNNING,
+					Payloads: [][]byte{[]byte(fmt.Sprintf("%s-%d", trialID, tickID))},
+				})
+			}
+			assert.NoError(t, b.AddSamples(context.Background(), samples))
+		}
+		retrievePayloads := func(trialID string) []string {
+			observer := make(backend.TrialSampleObserver)
+			go func() {
+				defer close(observer)
+				err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{trialID}, Reverse: true}, observer)
+				assert.NoError(t, err)
+			}()
+			payloads := []string{}
+			for sample := range observer {
+				payloads = append([]string{fmt.Sprintf("%d:%s", sample.TickId, sample.Payloads[0])}, payloads...)
+			}
+			return payloads
+		}
+		addSamples("merge-destination", 0, 1, 2)
+		addSamples("merge-source-1", 0, 1)
+		addSamples("merge-source-2", 2, 3)
+
+		// By default, source samples directly follow the destination ones
+		result, err := backend.MergeTrials(context.Background(), b, "merge-source-1", "merge-destination", backend.TrialsMergeOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, backend.TrialsMergeResult{MergedSamplesCount: 2, TickIDOffset: 3}, result)
+		assert.Equal(
+			t,
+			[]string{"0:merge-destination-0", "1:merge-destination-1", "2:merge-destination-2", "3:merge-source-1-0", "4:merge-source-1-1"},
+			retrievePayloads("merge-destination"),
+		)
+		// The source trial is kept unless requested otherwise
+		assert.Equal(t, []string{"0:merge-source-1-0", "1:merge-source-1-1"}, retrievePayloads("merge-source-1"))
+
+		// Merged samples must follow the destination ones
+		_, err = backend.MergeTrials(context.Background(), b, "merge-source-2", "merge-destination", backend.TrialsMergeOptions{TickIDOffset: pointy.Uint64(0)})
+		assert.ErrorIs(t, err, backend.ErrSampleOutOfOrder)
+
+		result, err = backend.MergeTrials(context.Background(), b, "merge-source-2", "merge-destination", backend.TrialsMergeOptions{
+			TickIDOffset: pointy.Uint64(10),
+			DeleteSource: true,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, backend.TrialsMergeResult{MergedSamplesCount: 2, TickIDOffset: 10}, result)
+		assert.Equal(
+			t,
+			[]string{"0:merge-destination-0", "1:merge-destination-1", "2:merge-destination-2", "3:merge-source-1-0", "4:merge-source-1-1", "12:merge-source-2-2", "13:merge-source-2-3"},
+			retrievePayloads("merge-destination"),
+		)
+		exists, err := backend.TrialExists(context.Background(), b, "merge-source-2")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		_, err = backend.MergeTrials(context.Background(), b, "merge-source-2", "merge-destination", backend.TrialsMergeOptions{})
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+		_, err = backend.MergeTrials(context.Background(), b, "merge-destination", "merge-destination", backend.TrialsMergeOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("TestMergeTrialsIncompatibleParams", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "merge-destination", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{
+				{Name: "actor-0", ActorClass: "player"},
+				{Name: "actor-1", ActorClass: "player"},
+			}}},
+			{TrialID: "merge-renamed", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{
+				{Name: "actor-1", ActorClass: "player"},
+				{Name: "actor-0", ActorClass: "player"},
+			}}},
+			{TrialID: "merge-shorter", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{
+				{Name: "actor-0", ActorClass: "player"},
+			}}},
+		})
+		assert.NoError(t, err)
+		for _, trialID := range []string{"merge-destination", "merge-renamed", "merge-shorter"} {
+			err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{generateSample(trialID, 1, 16, false)})
+			assert.NoError(t, err)
+		}
+
+		_, err = backend.MergeTrials(context.Background(), b, "merge-renamed", "merge-destination", backend.TrialsMergeOptions{DeleteSource: true})
+		var incompatibleParamsErr *backend.IncompatibleTrialParamsError
+		assert.ErrorAs(t, err, &incompatibleParamsErr)
+		assert.Equal(t, "merge-renamed", incompatibleParamsErr.SourceTrialID)
+		assert.Equal(t, []string{
+			"actors[0] is named \"actor-1\" instead of \"actor-0\"",
+			"actors[1] is named \"actor-0\" instead of \"actor-1\"",
+		}, incompatibleParamsErr.Differences)
+
+		_, err = backend.MergeTrials(context.Background(), b, "merge-shorter", "merge-destination", backend.TrialsMergeOptions{DeleteSource: true})
+		assert.ErrorAs(t, err, &incompatibleParamsErr)
+		assert.Equal(t, []string{"1 actors instead of 2"}, incompatibleParamsErr.Differences)
+
+		// Nothing was merged nor deleted
+		trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"merge-destination", "merge-renamed", "merge-shorter"}, -1, -1)
+		assert.NoError(t, err)
+		assert.Len(t, trialsInfo.TrialInfos, 3)
+		for _, trialInfo := range trialsInfo.TrialInfos {
+			assert.Equal(t, 1, trialInfo.StoredSamplesCount, trialInfo.TrialID)
+		}
+	})
+
+	t.Run("TestEstimateSamples", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "estimate-1", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "actor-0"}, {Name: "actor-1"}}}},
+			{TrialID: "estimate-2", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "actor-0"}, {Name: "actor-1"}}}},
+		})
+		assert.NoError(t, err)
+		for _, trialID := range []string{"estimate-1", "estimate-2"} {
+			samples := []*grpcapi.StoredTrialSample{}
+			for tickID := uint64(0); tickID < 10; tickID++ {
+				sample := &grpcapi.StoredTrialSample{
+					TrialId: trialID,
+					TickId:  tickID,
+					State:   grpcapi.TrialState_RUNNING,
+					ActorSamples: []*grpcapi.StoredTrialActorSample{
+						{Actor: 0, Observation: pointy.Uint32(0)},
+						{Actor: 1, Observation: pointy.Uint32(1)},
+					},
+					Payloads: [][]byte{makeRandomBytes(32), makeRandomBytes(64)},
+				}
+				if tickID%2 == 0 {
+					sample.ActorSamples[1].Action = pointy.Uint32(2)
+					sample.Payloads = append(sample.Payloads, makeRandomBytes(128))
+				}
+				if tickID == 9 {
+					sample.State = grpcapi.TrialState_ENDED
+				}
+				samples = append(samples, sample)
+			}
+			err = b.AddSamples(context.Background(), samples)
+			assert.NoError(t, err)
+		}
+
+		observe := func(filter backend.TrialSampleFilter) backend.SamplesEstimate {
+			observed := backend.SamplesEstimate{Exact: true}
+			observer := make(backend.TrialSampleObserver)
+			go func() {
+				defer close(observer)
+				err := b.ObserveSamples(context.Background(), filter, observer)
+				assert.NoError(t, err)
+			}()
+			for sample := range observer {
+				observed.SamplesCount++
+				observed.SamplesSize += proto.Size(sample)
+			}
+			return observed
+		}
+
+		filters := []backend.TrialSampleFilter{
+			{TrialIDs: []string{"estimate-1"}},
+			{TrialIDs: []string{"estimate-1", "estimate-2"}},
+			{TrialIDs: []string{"estimate-1"}, ActorNames: []string{"actor-0"}, CompactPayloads: true},
+			{TrialIDs: []string{"estimate-1"}, Fields: []grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION}},
+			{TrialIDs: []string{"estimate-1", "estimate-2"}, ActorNames: []string{"actor-1"}, RequireActions: true},
+			{TrialIDs: []string{"estimate-2"}, FromTickID: pointy.Uint64(3), ToTickID: pointy.Uint64(6)},
+			{TrialIDs: []string{"estimate-2"}, ToTickID: pointy.Uint64(4), MaxPayloadsSize: pointy.Int(100)},
+		}
+		for filterIdx, filter := range filters {
+			estimate, err := b.EstimateSamples(context.Background(), filter)
+			assert.NoError(t, err)
+			// Small trials are exactly estimated, whatever the backend
+			assert.Equal(t, observe(filter), estimate, "filter #%d", filterIdx)
+		}
+
+		estimate, err := b.EstimateSamples(context.Background(), filters[0])
+		assert.NoError(t, err)
+		filteredEstimate, err := b.EstimateSamples(context.Background(), filters[2])
+		assert.NoError(t, err)
+		assert.Equal(t, 10, filteredEstimate.SamplesCount)
+		assert.Less(t, filteredEstimate.SamplesSize, estimate.SamplesSize)
+
+		_, err = b.EstimateSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"estimate-1", "unknown"}})
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+	})
+
+	t.Run("TestEstimateSamplesOutOfOrder", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "estimate", Params: generateTrialParams(2, 100)}})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for _, tickID := range []uint64{1, 2, 5, 3, 4} {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: "estimate", TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		err = b.AddSamples(context.Background(), samples)
+		assert.NoError(t, err)
+
+		// The samples stored after one past the range are still counted
+		estimate, err := b.EstimateSamples(context.Background(), backend.TrialSampleFilter{
+			TrialIDs:   []string{"estimate"},
+			FromTickID: pointy.Uint64(1),
+			ToTickID:   pointy.Uint64(4),
+		})
+		assert.NoError(t, err)
+		assert.True(t, estimate.Exact)
+		assert.Equal(t, 4, estimate.SamplesCount)
+	})
+
+	t.Run("TestRetrieveSamplePayload", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", UserID: "my-user", Params: &grpcapi.TrialParams{}}})
+		assert.NoError(t, err)
+
+		_, err = b.RetrieveSamplePayload(context.Background(), "my-trial", 0, 0)
+		var unknownSampleErr *backend.UnknownSampleError
+		assert.ErrorAs(t, err, &unknownSampleErr)
+		assert.Equal(t, 0, unknownSampleErr.StoredSamplesCount)
+
+		payloadsPerTick := map[uint64][][]byte{}
+		for _, tickID := range []uint64{0, 1, 3} {
+			payloadsPerTick[tickID] = [][]byte{[]byte(fmt.Sprintf("observation %d", tickID)), {}, makeRandomBytes(1024)}
+			err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{
+				TrialId:  "my-trial",
+				TickId:   tickID,
+				State:    grpcapi.TrialState_RUNNING,
+				Payloads: payloadsPerTick[tickID],
+			}})
+			assert.NoError(t, err)
+		}
+
+		for tickID, payloads := range payloadsPerTick {
+			for payloadIdx, expectedPayload := range payloads {
+				payload, err := b.RetrieveSamplePayload(context.Background(), "my-trial", tickID, uint32(payloadIdx))
+				assert.NoError(t, err)
+				assert.Equal(t, len(expectedPayload), len(payload))
+				assert.Equal(t, string(expectedPayload), string(payload))
+			}
+
+			_, err := b.RetrieveSamplePayload(context.Background(), "my-trial", tickID, uint32(len(payloads)))
+			var unknownPayloadErr *backend.UnknownPayloadError
+			assert.ErrorAs(t, err, &unknownPayloadErr)
+			assert.Equal(t, uint32(len(payloads)), unknownPayloadErr.PayloadIdx)
+		}
+
+		// Altering a retrieved payload doesn't alter the stored one
+		payload, err := b.RetrieveSamplePayload(context.Background(), "my-trial", 0, 0)
+		assert.NoError(t, err)
+		payload[0] = 'X'
+		payload, err = b.RetrieveSamplePayload(context.Background(), "my-trial", 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("observation 0"), payload)
+
+		_, err = b.RetrieveSamplePayload(context.Background(), "my-trial", 2, 0)
+		assert.ErrorAs(t, err, &unknownSampleErr)
+		assert.Equal(t, 3, unknownSampleErr.StoredSamplesCount)
+		assert.Equal(t, uint64(0), unknownSampleErr.MinTickID)
+		assert.Equal(t, uint64(3), unknownSampleErr.MaxTickID)
+
+		_, err = b.RetrieveSamplePayload(context.Background(), "unknown-trial", 0, 0)
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+	})
+
+	t.Run("TestAbandonTrials", func(t *testing.T) {
+		b := createBackend()
+		defer destroyBackend(b)
+
+		err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+			{TrialID: "my-trial", Params: &grpcapi.TrialParams{}},
+			{TrialID: "my-other-trial", Params: &grpcapi.TrialParams{}},
+		})
+		assert.NoError(t, err)
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+			{TrialId: "my-trial", TickId: 0, State: grpcapi.TrialState_RUNNING},
+			{TrialId: "my-other-trial", TickId: 0, State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+
+		retrieveTrialInfo := func(trialID string) *backend.TrialInfo {
+			trialsInfo, err := b.RetrieveTrials(context.Background(), []string{trialID}, -1, -1)
+			assert.NoError(t, err)
+			assert.Len(t, trialsInfo.TrialInfos, 1)
+			return trialsInfo.TrialInfos[0]
+		}
+
+		// Ongoing observations end once the trial is abandoned
+		observer := make(backend.TrialSampleObserver)
+		observationErr := make(chan error, 1)
+		go func() {
+			defer close(observer)
+			observationErr <- b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"my-trial"}}, observer)
+		}()
+		assert.Equal(t, uint64(0), (<-observer).TickId)
+
+		err = b.AbandonTrials(context.Background(), []string{"my-trial"})
+		assert.NoError(t, err)
+		for range observer {
+		}
+		assert.NoError(t, <-observationErr)
+
+		trialInfo := retrieveTrialInfo("my-trial")
+		assert.Equal(t, grpcapi.TrialState_ENDED, trialInfo.State)
+		assert.True(t, trialInfo.Abandoned)
+		assert.False(t, retrieveTrialInfo("my-other-trial").Abandoned)
+
+		// Adding samples to an abandoned trial makes it ongoing again
+		err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{{TrialId: "my-trial", TickId: 1, State: grpcapi.TrialState_RUNNING}})
+		assert.NoError(t, err)
+		trialInfo = retrieveTrialInfo("my-trial")
+		assert.Equal(t, grpcapi.TrialState_RUNNING, trialInfo.State)
+		assert.False(t, trialInfo.Abandoned)
+
+		// Ending an abandoned trial explicitly removes the mark
+		err = b.AbandonTrials(context.Background(), []string{"my-trial"})
+		assert.NoError(t, err)
+		assert.True(t, retrieveTrialInfo("my-trial").Abandoned)
+		err = b.EndTrials(context.Background(), []string{"my-trial"})
+		assert.NoError(t, err)
+		trialInfo = retrieveTrialInfo("my-trial")
+		assert.Equal(t, grpcapi.TrialState_ENDED, trialInfo.State)
+		assert.False(t, trialInfo.Abandoned)
+
+		// Clearing the samples of an abandoned trial removes the mark
+		err = b.AbandonTrials(context.Background(), []string{"my-other-trial"})
+		assert.NoError(t, err)
+		err = b.ClearSamples(context.Background(), "my-other-trial")
+		assert.NoError(t, err)
+		assert.False(t, retrieveTrialInfo("my-other-trial").Abandoned)
+
+		err = b.AbandonTrials(context.Background(), []string{"unknown-trial"})
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+	})
 }
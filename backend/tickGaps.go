@@ -0,0 +1,100 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"sort"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// TickGap represents a range of missing ticks, both bounds are included
+type TickGap struct {
+	FromTickID uint64
+	ToTickID   uint64
+}
+
+// TickGapsOptions represents how the missing ticks of a trial are detected
+type TickGapsOptions struct {
+	Step uint64 // Expected difference between consecutive tick ids, 0 is considered as 1 unless `AllowNonContiguousTicks` is set
+	// The trial legitimately has ticks not separated by a multiple of `Step`, such ticks aren't reported as gaps.
+	// Without `Step`, the expected difference is inferred from the ticks themselves as the largest one dividing every
+	// difference between consecutive ticks, e.g. ticks 0, 3, 9 are expected 3 apart and miss tick 6.
+	AllowNonContiguousTicks bool
+}
+
+// ComputeTickGaps computes the ranges of missing ticks in the given samples, assuming they should be separated by `options.Step`.
+//
+// When consecutive samples are separated by a difference that isn't a multiple of the step, the missing range covers every
+// tick in between unless `options.AllowNonContiguousTicks` is set. With the default step of 1, every difference is a
+// multiple of the step, `options.AllowNonContiguousTicks` then infers the step from the ticks.
+func ComputeTickGaps(samples []*grpcapi.StoredTrialSample, options TickGapsOptions) []TickGap {
+	tickIDs := make([]uint64, len(samples))
+	for sampleIdx, sample := range samples {
+		tickIDs[sampleIdx] = sample.TickId
+	}
+	sort.Slice(tickIDs, func(i, j int) bool { return tickIDs[i] < tickIDs[j] })
+
+	step := options.Step
+	if step == 0 && options.AllowNonContiguousTicks {
+		step = inferTickStep(tickIDs)
+	}
+	if step == 0 {
+		step = 1
+	}
+
+	gaps := []TickGap{}
+	for tickIdx := 1; tickIdx < len(tickIDs); tickIdx++ {
+		previousTickID := tickIDs[tickIdx-1]
+		tickID := tickIDs[tickIdx]
+		delta := tickID - previousTickID
+		if delta <= step {
+			// Contiguous ticks, or duplicated ones
+			continue
+		}
+		if delta%step != 0 {
+			if options.AllowNonContiguousTicks {
+				continue
+			}
+			gaps = append(gaps, TickGap{FromTickID: previousTickID + 1, ToTickID: tickID - 1})
+			continue
+		}
+		gaps = append(gaps, TickGap{FromTickID: previousTickID + step, ToTickID: tickID - step})
+	}
+	return gaps
+}
+
+// inferTickStep returns the greatest common divisor of the differences between the given sorted tick ids, 0 when they
+// are all the same
+func inferTickStep(sortedTickIDs []uint64) uint64 {
+	step := uint64(0)
+	for tickIdx := 1; tickIdx < len(sortedTickIDs); tickIdx++ {
+		delta := sortedTickIDs[tickIdx] - sortedTickIDs[tickIdx-1]
+		for delta != 0 {
+			step, delta = delta, step%delta
+		}
+	}
+	return step
+}
+
+// RetrieveTickGaps computes the ranges of missing ticks in the currently stored samples of a trial
+func RetrieveTickGaps(ctx context.Context, b Backend, trialID string, options TickGapsOptions) ([]TickGap, error) {
+	samples, err := retrieveStoredSamples(ctx, b, trialID)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeTickGaps(samples, options), nil
+}
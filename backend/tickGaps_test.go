@@ -0,0 +1,79 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func generateSamplesFromTickIDs(tickIDs ...uint64) []*grpcapi.StoredTrialSample {
+	samples := make([]*grpcapi.StoredTrialSample, len(tickIDs))
+	for sampleIdx, tickID := range tickIDs {
+		samples[sampleIdx] = &grpcapi.StoredTrialSample{TickId: tickID}
+	}
+	return samples
+}
+
+func TestComputeTickGaps(t *testing.T) {
+	assert.Equal(t, []TickGap{}, ComputeTickGaps(generateSamplesFromTickIDs(0, 1, 2, 3), TickGapsOptions{}))
+	assert.Equal(t, []TickGap{}, ComputeTickGaps(generateSamplesFromTickIDs(), TickGapsOptions{}))
+
+	assert.Equal(
+		t,
+		[]TickGap{{FromTickID: 3, ToTickID: 3}, {FromTickID: 6, ToTickID: 8}},
+		ComputeTickGaps(generateSamplesFromTickIDs(1, 2, 5, 4, 9), TickGapsOptions{}),
+	)
+}
+
+func TestComputeTickGapsStep(t *testing.T) {
+	assert.Equal(t, []TickGap{}, ComputeTickGaps(generateSamplesFromTickIDs(0, 2, 4, 6), TickGapsOptions{Step: 2}))
+
+	assert.Equal(
+		t,
+		[]TickGap{{FromTickID: 4, ToTickID: 6}},
+		ComputeTickGaps(generateSamplesFromTickIDs(0, 2, 8), TickGapsOptions{Step: 2}),
+	)
+
+	// 5 is not aligned with the step
+	assert.Equal(
+		t,
+		[]TickGap{{FromTickID: 3, ToTickID: 4}},
+		ComputeTickGaps(generateSamplesFromTickIDs(0, 2, 5, 7), TickGapsOptions{Step: 2}),
+	)
+	assert.Equal(
+		t,
+		[]TickGap{},
+		ComputeTickGaps(generateSamplesFromTickIDs(0, 2, 5, 7), TickGapsOptions{Step: 2, AllowNonContiguousTicks: true}),
+	)
+}
+
+func TestComputeTickGapsInferredStep(t *testing.T) {
+	// Ticks expected 3 apart, 6 is missing
+	assert.Equal(
+		t,
+		[]TickGap{{FromTickID: 6, ToTickID: 6}},
+		ComputeTickGaps(generateSamplesFromTickIDs(0, 3, 9, 12), TickGapsOptions{AllowNonContiguousTicks: true}),
+	)
+	assert.Equal(
+		t,
+		[]TickGap{{FromTickID: 1, ToTickID: 2}, {FromTickID: 4, ToTickID: 8}, {FromTickID: 10, ToTickID: 11}},
+		ComputeTickGaps(generateSamplesFromTickIDs(0, 3, 9, 12), TickGapsOptions{}),
+	)
+	assert.Equal(t, []TickGap{}, ComputeTickGaps(generateSamplesFromTickIDs(4, 4), TickGapsOptions{AllowNonContiguousTicks: true}))
+	assert.Equal(t, []TickGap{}, ComputeTickGaps(generateSamplesFromTickIDs(5), TickGapsOptions{AllowNonContiguousTicks: true}))
+}
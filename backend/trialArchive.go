@@ -0,0 +1,325 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// A trial archive is a stream of length-delimited messages, each one prefixed by its size as an uvarint:
+//
+//   - the `trialArchiveMagic` bytes, followed by the format version as a bare uvarint,
+//   - a `grpcapi.StoredTrialInfo` holding the trial id, user id, params and the number of samples in the archive,
+//   - the sample ordering key of the trial, as a string,
+//   - since version 2, the tags of the trial, as a JSON object,
+//   - the samples of the trial as `grpcapi.StoredTrialSample`, in the order they are observed.
+var trialArchiveMagic = []byte("CTDTRIAL")
+
+const TrialArchiveVersion = 2
+
+// DefaultMaxTrialArchiveMessageSize is the default maximum size, in bytes, of a message read from a trial archive, it
+// matches the default maximum size of a message received by the gRPC server, e.g. an added sample
+const DefaultMaxTrialArchiveMessageSize = 4 * 1024 * 1024
+
+// TrialAlreadyExistsError is raised when trying to import a trial whose id is already used
+type TrialAlreadyExistsError struct {
+	TrialID string
+}
+
+func (e *TrialAlreadyExistsError) Error() string {
+	return fmt.Sprintf("trial %q already exists", e.TrialID)
+}
+
+// Is matches `ErrTrialAlreadyExists`
+func (e *TrialAlreadyExistsError) Is(target error) bool {
+	return target == ErrTrialAlreadyExists
+}
+
+// trialArchiveHeader represents the header of a trial archive
+type trialArchiveHeader struct {
+	Version           uint64
+	TrialInfo         *grpcapi.StoredTrialInfo
+	SampleOrderingKey SampleOrderingKey
+	Tags              map[string]string
+}
+
+func writeDelimited(w io.Writer, message []byte) error {
+	sizeBuffer := make([]byte, binary.MaxVarintLen64)
+	_, err := w.Write(sizeBuffer[:binary.PutUvarint(sizeBuffer, uint64(len(message)))])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(message)
+	return err
+}
+
+func writeDelimitedMessage(w io.Writer, message proto.Message) error {
+	serializedMessage, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return writeDelimited(w, serializedMessage)
+}
+
+// readDelimited reads a length-delimited message of at most `maxMessageSize` bytes, returning `io.EOF` only if the
+// reader is exhausted before it starts
+func readDelimited(r *bufio.Reader, maxMessageSize int) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(maxMessageSize) {
+		return nil, fmt.Errorf("invalid trial archive, message of %dB exceeds the maximum size of %dB", size, maxMessageSize)
+	}
+	message := make([]byte, size)
+	_, err = io.ReadFull(r, message)
+	if errors.Is(err, io.EOF) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return message, err
+}
+
+func readDelimitedMessage(r *bufio.Reader, maxMessageSize int, message proto.Message) error {
+	serializedMessage, err := readDelimited(r, maxMessageSize)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(serializedMessage, message)
+}
+
+// ExportTrial writes the params and the currently stored samples of a trial to the given writer as a trial archive.
+//
+// Samples are written as they are observed, the whole trial is never held in memory.
+func ExportTrial(ctx context.Context, b Backend, trialID string, w io.Writer) error {
+	trialsInfo, err := b.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+	if err != nil {
+		return err
+	}
+	if len(trialsInfo.TrialInfos) == 0 {
+		return &UnknownTrialError{TrialID: trialID}
+	}
+	trialInfo := trialsInfo.TrialInfos[0]
+	trialsParams, err := b.GetTrialParams(ctx, []string{trialID})
+	if err != nil {
+		return err
+	}
+	trialParams := trialsParams[0]
+
+	err = writeDelimited(w, trialArchiveMagic)
+	if err != nil {
+		return err
+	}
+	versionBuffer := make([]byte, binary.MaxVarintLen64)
+	_, err = w.Write(versionBuffer[:binary.PutUvarint(versionBuffer, TrialArchiveVersion)])
+	if err != nil {
+		return err
+	}
+	err = writeDelimitedMessage(w, &grpcapi.StoredTrialInfo{
+		TrialId:      trialInfo.TrialID,
+		UserId:       trialInfo.UserID,
+		LastState:    trialInfo.State,
+		SamplesCount: uint32(trialInfo.StoredSamplesCount),
+		Params:       trialParams.Params,
+	})
+	if err != nil {
+		return err
+	}
+	err = writeDelimited(w, []byte(trialParams.SampleOrderingKey.String()))
+	if err != nil {
+		return err
+	}
+	serializedTags, err := json.Marshal(trialParams.Tags)
+	if err != nil {
+		return err
+	}
+	err = writeDelimited(w, serializedTags)
+	if err != nil {
+		return err
+	}
+
+	// Only exporting the samples stored when the export started
+	exportedSamplesCount, err := forEachStoredSample(ctx, b, trialID, trialInfo.StoredSamplesCount, nil, func(sample *grpcapi.StoredTrialSample) error {
+		return writeDelimitedMessage(w, sample)
+	})
+	if err != nil {
+		return err
+	}
+	if exportedSamplesCount != trialInfo.StoredSamplesCount {
+		return fmt.Errorf("%d samples of trial %q were exported, %d were expected, samples were deleted during the export", exportedSamplesCount, trialID, trialInfo.StoredSamplesCount)
+	}
+	return nil
+}
+
+// readTrialArchiveHeader reads and validates the header of a trial archive
+func readTrialArchiveHeader(r *bufio.Reader, maxMessageSize int) (*trialArchiveHeader, error) {
+	magic, err := readDelimited(r, maxMessageSize)
+	if err != nil || !bytes.Equal(magic, trialArchiveMagic) {
+		return nil, fmt.Errorf("invalid trial archive header")
+	}
+	header := &trialArchiveHeader{TrialInfo: &grpcapi.StoredTrialInfo{}}
+	header.Version, err = binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trial archive header")
+	}
+	if header.Version == 0 || header.Version > TrialArchiveVersion {
+		return nil, fmt.Errorf("unsupported trial archive version %d, expecting at most %d", header.Version, TrialArchiveVersion)
+	}
+	err = readDelimitedMessage(r, maxMessageSize, header.TrialInfo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trial archive header (%w)", err)
+	}
+	if header.TrialInfo.TrialId == "" {
+		return nil, fmt.Errorf("invalid trial archive header, missing trial id")
+	}
+	sampleOrderingKeyStr, err := readDelimited(r, maxMessageSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trial archive header (%w)", err)
+	}
+	header.SampleOrderingKey, err = ParseSampleOrderingKey(string(sampleOrderingKeyStr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid trial archive header (%w)", err)
+	}
+	if header.Version >= 2 {
+		serializedTags, err := readDelimited(r, maxMessageSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trial archive header (%w)", err)
+		}
+		err = json.Unmarshal(serializedTags, &header.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trial archive header, invalid tags (%w)", err)
+		}
+	}
+	return header, nil
+}
+
+// ImportTrialOptions represents the configuration of a trial import
+type ImportTrialOptions struct {
+	TrialID      string // Id of the imported trial, the one from the archive if empty
+	SkipExisting bool   // Leave an existing trial untouched instead of failing with a `TrialAlreadyExistsError`
+	// Replace an existing trial instead of failing with a `TrialAlreadyExistsError`, its samples are cleared and its
+	// params, user id, ordering key and tags replaced by the archived ones. `SkipExisting` takes precedence.
+	Overwrite bool
+	// Maximum size, in bytes, of a message of the archive, e.g. a sample, larger ones fail the import. 0 means
+	// `DefaultMaxTrialArchiveMessageSize`
+	MaxMessageSize int
+}
+
+// ImportTrial creates a trial from a trial archive read from the given reader and returns its id.
+//
+// Samples are added to the backend by chunks as they are read. When the archive is invalid or truncated the trial is
+// deleted.
+func ImportTrial(ctx context.Context, b Backend, r io.Reader, options ImportTrialOptions) (string, error) {
+	trialID, _, err := importTrial(ctx, b, r, options)
+	return trialID, err
+}
+
+// importTrial is `ImportTrial` also returning whether an existing trial was skipped
+func importTrial(ctx context.Context, b Backend, r io.Reader, options ImportTrialOptions) (string, bool, error) {
+	bufferedReader := bufio.NewReader(r)
+	maxMessageSize := options.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxTrialArchiveMessageSize
+	}
+	header, err := readTrialArchiveHeader(bufferedReader, maxMessageSize)
+	if err != nil {
+		return "", false, err
+	}
+	trialID := options.TrialID
+	if trialID == "" {
+		trialID = header.TrialInfo.TrialId
+	}
+
+	exists, err := TrialExists(ctx, b, trialID)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		if options.SkipExisting {
+			return trialID, true, nil
+		}
+		if !options.Overwrite {
+			return "", false, &TrialAlreadyExistsError{TrialID: trialID}
+		}
+		err = b.ClearSamples(ctx, trialID)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	err = b.CreateOrUpdateTrials(ctx, []*TrialParams{{
+		TrialID:           trialID,
+		UserID:            header.TrialInfo.UserId,
+		Params:            header.TrialInfo.Params,
+		SampleOrderingKey: header.SampleOrderingKey,
+		Tags:              header.Tags,
+	}})
+	if err != nil {
+		return "", false, err
+	}
+
+	err = importTrialSamples(ctx, b, bufferedReader, maxMessageSize, trialID, int(header.TrialInfo.SamplesCount))
+	if err != nil {
+		deleteErr := b.DeleteTrials(context.Background(), []string{trialID})
+		if deleteErr != nil {
+			return "", false, fmt.Errorf("%w, unable to delete the partially imported trial %q (%s)", err, trialID, deleteErr)
+		}
+		return "", false, err
+	}
+	return trialID, false, nil
+}
+
+const importTrialSamplesChunkSize = 100
+
+func importTrialSamples(ctx context.Context, b Backend, r *bufio.Reader, maxMessageSize int, trialID string, expectedSamplesCount int) error {
+	samplesChunk := make([]*grpcapi.StoredTrialSample, 0, importTrialSamplesChunkSize)
+	importedSamplesCount := 0
+	for {
+		sample := &grpcapi.StoredTrialSample{}
+		err := readDelimitedMessage(r, maxMessageSize, sample)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid trial archive sample (%w)", err)
+		}
+		sample.TrialId = trialID
+		samplesChunk = append(samplesChunk, sample)
+		importedSamplesCount++
+		if len(samplesChunk) == importTrialSamplesChunkSize {
+			err := b.AddSamples(ctx, samplesChunk)
+			if err != nil {
+				return err
+			}
+			samplesChunk = make([]*grpcapi.StoredTrialSample, 0, importTrialSamplesChunkSize)
+		}
+	}
+	if importedSamplesCount != expectedSamplesCount {
+		return fmt.Errorf("invalid trial archive, %d samples found, %d were expected", importedSamplesCount, expectedSamplesCount)
+	}
+	if len(samplesChunk) > 0 {
+		return b.AddSamples(ctx, samplesChunk)
+	}
+	return nil
+}
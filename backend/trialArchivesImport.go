@@ -0,0 +1,115 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// TrialArchiveImport represents the outcome of the import of a trial archive file
+type TrialArchiveImport struct {
+	FilePath string
+	TrialID  string // Id of the imported, or skipped, trial, empty when the import failed
+	Skipped  bool   // The trial already existed and was left untouched
+	Err      error  // Why the import failed, nil when it succeeded or was skipped
+}
+
+// ImportTrialArchivesOptions represents the configuration of the import of several trial archive files
+type ImportTrialArchivesOptions struct {
+	Concurrency    int  // Maximum number of archives imported concurrently, values below 1 import one archive at a time
+	SkipExisting   bool // see `ImportTrialOptions`
+	Overwrite      bool // see `ImportTrialOptions`
+	MaxMessageSize int  // see `ImportTrialOptions`
+	// Called once for each archive, as soon as its import is over, calls are never concurrent. Can be nil.
+	Progress func(result TrialArchiveImport, done int, total int)
+}
+
+// ImportTrialArchivesSummary counts the outcomes of the imports of several trial archive files
+type ImportTrialArchivesSummary struct {
+	Succeeded int
+	Failed    int
+	Skipped   int
+}
+
+// ImportTrialArchives imports each of the given trial archive files, using the trial id of each archive.
+//
+// The failure of an archive doesn't stop the import of the others, it is reported through `Progress` and counted in
+// the returned summary. Once the context is done the remaining archives fail.
+func ImportTrialArchives(ctx context.Context, b Backend, filePaths []string, options ImportTrialArchivesOptions) ImportTrialArchivesSummary {
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	summary := ImportTrialArchivesSummary{}
+	summaryMutex := sync.Mutex{}
+	report := func(result TrialArchiveImport) {
+		summaryMutex.Lock()
+		defer summaryMutex.Unlock()
+		switch {
+		case result.Err != nil:
+			summary.Failed++
+		case result.Skipped:
+			summary.Skipped++
+		default:
+			summary.Succeeded++
+		}
+		if options.Progress != nil {
+			options.Progress(result, summary.Succeeded+summary.Failed+summary.Skipped, len(filePaths))
+		}
+	}
+
+	filePathsChannel := make(chan string)
+	wg := sync.WaitGroup{}
+	for workerIdx := 0; workerIdx < concurrency; workerIdx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range filePathsChannel {
+				report(importTrialArchive(ctx, b, filePath, options))
+			}
+		}()
+	}
+	for _, filePath := range filePaths {
+		filePathsChannel <- filePath
+	}
+	close(filePathsChannel)
+	wg.Wait()
+
+	return summary
+}
+
+func importTrialArchive(ctx context.Context, b Backend, filePath string, options ImportTrialArchivesOptions) TrialArchiveImport {
+	result := TrialArchiveImport{FilePath: filePath}
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer file.Close()
+
+	result.TrialID, result.Skipped, result.Err = importTrial(ctx, b, file, ImportTrialOptions{
+		SkipExisting:   options.SkipExisting,
+		Overwrite:      options.Overwrite,
+		MaxMessageSize: options.MaxMessageSize,
+	})
+	return result
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"crypto/sha256"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// HashTrialParams computes a content hash of trial params, from their deterministic serialization, equal params have
+// the same hash. nil params are hashed as empty params.
+func HashTrialParams(params *grpcapi.TrialParams) ([sha256.Size]byte, error) {
+	if params == nil {
+		params = &grpcapi.TrialParams{}
+	}
+	serializedParams, err := proto.MarshalOptions{Deterministic: true}.Marshal(params)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(serializedParams), nil
+}
+
+// DiffTrialParams returns the names of the top-level fields of `grpcapi.TrialParams` having different values in `a`
+// and `b`, e.g. "max_steps", in field number order.
+func DiffTrialParams(a *grpcapi.TrialParams, b *grpcapi.TrialParams) []string {
+	if a == nil {
+		a = &grpcapi.TrialParams{}
+	}
+	if b == nil {
+		b = &grpcapi.TrialParams{}
+	}
+	aMessage := a.ProtoReflect()
+	bMessage := b.ProtoReflect()
+	fieldNames := []string{}
+	fields := aMessage.Descriptor().Fields()
+	for fieldIdx := 0; fieldIdx < fields.Len(); fieldIdx++ {
+		field := fields.Get(fieldIdx)
+		if !proto.Equal(onlyField(aMessage, field), onlyField(bMessage, field)) {
+			fieldNames = append(fieldNames, string(field.Name()))
+		}
+	}
+	return fieldNames
+}
+
+// onlyField returns a copy of a message restricted to a single field
+func onlyField(message protoreflect.Message, field protoreflect.FieldDescriptor) proto.Message {
+	restrictedMessage := message.New()
+	if message.Has(field) {
+		restrictedMessage.Set(field, message.Get(field))
+	}
+	return restrictedMessage.Interface()
+}
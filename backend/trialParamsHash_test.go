@@ -0,0 +1,68 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashTrialParams(t *testing.T) {
+	params := &grpcapi.TrialParams{
+		Actors:   []*grpcapi.ActorParams{{Name: "player", ActorClass: "pl"}, {Name: "human", ActorClass: "hu"}},
+		MaxSteps: 100,
+	}
+	hash, err := HashTrialParams(params)
+	assert.NoError(t, err)
+
+	sameHash, err := HashTrialParams(&grpcapi.TrialParams{
+		Actors:   []*grpcapi.ActorParams{{Name: "player", ActorClass: "pl"}, {Name: "human", ActorClass: "hu"}},
+		MaxSteps: 100,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, hash, sameHash)
+
+	otherHash, err := HashTrialParams(&grpcapi.TrialParams{
+		Actors:   []*grpcapi.ActorParams{{Name: "human", ActorClass: "hu"}, {Name: "player", ActorClass: "pl"}},
+		MaxSteps: 100,
+	})
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, otherHash)
+
+	nilHash, err := HashTrialParams(nil)
+	assert.NoError(t, err)
+	emptyHash, err := HashTrialParams(&grpcapi.TrialParams{})
+	assert.NoError(t, err)
+	assert.Equal(t, emptyHash, nilHash)
+}
+
+func TestDiffTrialParams(t *testing.T) {
+	params := &grpcapi.TrialParams{
+		Environment: &grpcapi.EnvironmentParams{Endpoint: "grpc://environment:9000"},
+		Actors:      []*grpcapi.ActorParams{{Name: "player"}},
+		MaxSteps:    100,
+	}
+	assert.Empty(t, DiffTrialParams(params, params))
+	assert.Empty(t, DiffTrialParams(nil, &grpcapi.TrialParams{}))
+
+	assert.Equal(t, []string{"environment", "actors", "max_steps"}, DiffTrialParams(params, nil))
+	assert.Equal(t, []string{"actors", "max_steps"}, DiffTrialParams(params, &grpcapi.TrialParams{
+		Environment: &grpcapi.EnvironmentParams{Endpoint: "grpc://environment:9000"},
+		Actors:      []*grpcapi.ActorParams{{Name: "player", ActorClass: "pl"}},
+		MaxSteps:    200,
+	}))
+}
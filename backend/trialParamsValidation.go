@@ -0,0 +1,103 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// TrialParamsProblem represents an issue found in a `grpcapi.TrialParams`
+type TrialParamsProblem struct {
+	Field       string // Path of the field having the issue, e.g. "actors[1].name"
+	Description string
+}
+
+func (p TrialParamsProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Field, p.Description)
+}
+
+// ValidateTrialParams checks that trial params are well-formed and returns the found problems, if any.
+//
+// Actors need a non-empty name unique among the actors of the trial, as samples are filtered by actor name. Endpoints
+// are optional but, when defined, need to be a "grpc://<host>:<port>" or a "cogment://..." url.
+//
+// `MaxSteps` and `MaxInactivity` being unsigned, any value is valid.
+func ValidateTrialParams(params *grpcapi.TrialParams) []TrialParamsProblem {
+	problems := []TrialParamsProblem{}
+	if params == nil {
+		return problems
+	}
+
+	if params.Environment != nil {
+		problems = appendEndpointProblem(problems, "environment.endpoint", params.Environment.Endpoint)
+	}
+	if params.Datalog != nil {
+		problems = appendEndpointProblem(problems, "datalog.endpoint", params.Datalog.Endpoint)
+	}
+
+	actorIndices := make(map[string]int)
+	for actorIdx, actor := range params.Actors {
+		field := fmt.Sprintf("actors[%d]", actorIdx)
+		if actor.Name == "" {
+			problems = append(problems, TrialParamsProblem{Field: field + ".name", Description: "empty actor name"})
+		} else if firstActorIdx, duplicate := actorIndices[actor.Name]; duplicate {
+			problems = append(problems, TrialParamsProblem{
+				Field:       field + ".name",
+				Description: fmt.Sprintf("actor name %q already used by actors[%d]", actor.Name, firstActorIdx),
+			})
+		} else {
+			actorIndices[actor.Name] = actorIdx
+		}
+		problems = appendEndpointProblem(problems, field+".endpoint", actor.Endpoint)
+	}
+
+	return problems
+}
+
+func appendEndpointProblem(problems []TrialParamsProblem, field string, endpoint string) []TrialParamsProblem {
+	if endpoint == "" {
+		return problems
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return append(problems, TrialParamsProblem{Field: field, Description: fmt.Sprintf("invalid endpoint %q", endpoint)})
+	}
+	switch endpointURL.Scheme {
+	case "grpc":
+		if _, port, err := net.SplitHostPort(endpointURL.Host); err != nil || port == "" {
+			return append(problems, TrialParamsProblem{
+				Field:       field,
+				Description: fmt.Sprintf("invalid endpoint %q, expecting \"grpc://<host>:<port>\"", endpoint),
+			})
+		}
+	case "cogment":
+		if endpointURL.Host == "" {
+			return append(problems, TrialParamsProblem{
+				Field:       field,
+				Description: fmt.Sprintf("invalid endpoint %q, expecting \"cogment://<host>\"", endpoint),
+			})
+		}
+	default:
+		return append(problems, TrialParamsProblem{
+			Field:       field,
+			Description: fmt.Sprintf("invalid endpoint %q, expecting a \"grpc\" or \"cogment\" scheme", endpoint),
+		})
+	}
+	return problems
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTrialParamsValid(t *testing.T) {
+	assert.Empty(t, ValidateTrialParams(nil))
+	assert.Empty(t, ValidateTrialParams(&grpcapi.TrialParams{}))
+	assert.Empty(t, ValidateTrialParams(&grpcapi.TrialParams{
+		Environment: &grpcapi.EnvironmentParams{Endpoint: "grpc://environment:9000"},
+		Datalog:     &grpcapi.DatalogParams{Endpoint: "grpc://localhost:9001"},
+		Actors: []*grpcapi.ActorParams{
+			{Name: "player", Endpoint: "grpc://[::1]:9002"},
+			{Name: "human", Endpoint: "cogment://client"},
+			{Name: "other"},
+		},
+		MaxSteps: 100,
+	}))
+}
+
+func TestValidateTrialParamsActorNames(t *testing.T) {
+	problems := ValidateTrialParams(&grpcapi.TrialParams{
+		Actors: []*grpcapi.ActorParams{
+			{Name: "player"},
+			{Name: ""},
+			{Name: "other"},
+			{Name: "player"},
+		},
+	})
+	assert.Equal(t, []TrialParamsProblem{
+		{Field: "actors[1].name", Description: "empty actor name"},
+		{Field: "actors[3].name", Description: `actor name "player" already used by actors[0]`},
+	}, problems)
+	assert.Equal(t, `actors[3].name: actor name "player" already used by actors[0]`, problems[1].String())
+}
+
+func TestValidateTrialParamsEndpoints(t *testing.T) {
+	problems := ValidateTrialParams(&grpcapi.TrialParams{
+		Environment: &grpcapi.EnvironmentParams{Endpoint: "grpc://environment"},
+		Datalog:     &grpcapi.DatalogParams{Endpoint: "localhost:9001"},
+		Actors: []*grpcapi.ActorParams{
+			{Name: "player", Endpoint: "http://player:9000"},
+			{Name: "human", Endpoint: "cogment://"},
+			{Name: "other", Endpoint: "grpc://other:9000"},
+		},
+	})
+	fields := []string{}
+	for _, problem := range problems {
+		fields = append(fields, problem.Field)
+	}
+	assert.Equal(t, []string{"environment.endpoint", "datalog.endpoint", "actors[0].endpoint", "actors[1].endpoint"}, fields)
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TrialResult represents the final result of a trial, e.g. its return, stored along with its params once it is complete
+type TrialResult struct {
+	Scores map[string]float64 // Named scalar scores, e.g. "return"
+	Data   []byte             // Opaque result, e.g. a serialized message
+}
+
+// NoTrialResultError is raised when retrieving the result of a trial for which none was set
+type NoTrialResultError struct {
+	TrialID string
+}
+
+func (e *NoTrialResultError) Error() string {
+	return fmt.Sprintf("trial %q has no result", e.TrialID)
+}
+
+// ParseTrialResultScores parses scores expressed as `name=value` definitions, e.g. "return=12.5", into a map.
+//
+// Names can't be empty and values must be finite numbers. Defining the same name twice with different values is an
+// error.
+func ParseTrialResultScores(definitions []string) (map[string]float64, error) {
+	scores := make(map[string]float64, len(definitions))
+	for _, definition := range definitions {
+		separatorIdx := strings.Index(definition, "=")
+		if separatorIdx < 0 {
+			return nil, fmt.Errorf("invalid trial result score %q, expecting `name=value`", definition)
+		}
+		name := strings.TrimSpace(definition[:separatorIdx])
+		if name == "" {
+			return nil, fmt.Errorf("invalid trial result score %q, the name is empty", definition)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(definition[separatorIdx+1:]), 64)
+		if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, fmt.Errorf("invalid trial result score %q, expecting a finite number", definition)
+		}
+		if definedValue, defined := scores[name]; defined && definedValue != value {
+			return nil, fmt.Errorf("trial result score %q is defined twice, as %v and %v", name, definedValue, value)
+		}
+		scores[name] = value
+	}
+	return scores, nil
+}
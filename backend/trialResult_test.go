@@ -0,0 +1,42 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTrialResultScores(t *testing.T) {
+	scores, err := ParseTrialResultScores([]string{"return=12.5", " steps = 42 ", "loss=-1e-3"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"return": 12.5, "steps": 42, "loss": -0.001}, scores)
+
+	scores, err = ParseTrialResultScores([]string{})
+	assert.NoError(t, err)
+	assert.Len(t, scores, 0)
+
+	// Defining the same score twice is fine as long as the values are equal
+	_, err = ParseTrialResultScores([]string{"return=1", "return=1.0"})
+	assert.NoError(t, err)
+	_, err = ParseTrialResultScores([]string{"return=1", "return=2"})
+	assert.Error(t, err)
+
+	for _, definition := range []string{"return", "=1", "return=", "return=foo", "return=NaN", "return=+Inf"} {
+		_, err = ParseTrialResultScores([]string{definition})
+		assert.Error(t, err, definition)
+	}
+}
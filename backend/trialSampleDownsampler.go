@@ -0,0 +1,102 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// TrialSampleDownsampler keeps every `factor`-th tick of a stream of samples, as well as the first and last samples of
+// each trial, e.g. to visualize long trials.
+//
+// A sample is kept when its tick id is a multiple of the factor. The first sample of each trial is always kept, as is
+// its last one: an ended sample, a sample at `toTickID`, or the last sample held back until `Flush` is called once the
+// stream is over. It expects the samples of each trial in increasing tick order, or decreasing tick order when created
+// by `NewReverseTrialSampleDownsampler`, and isn't safe for concurrent use.
+type TrialSampleDownsampler struct {
+	factor     uint64
+	toTickID   *uint64
+	reverse    bool
+	fromTickID *uint64 // Only used in reverse, the last sample of each trial is then at `fromTickID`
+	trials     map[string]*downsampledTrial
+	// Trial ids in the order their first sample was seen
+	trialIDs []string
+}
+
+type downsampledTrial struct {
+	// Last sample that was skipped, nil if the last seen sample was kept
+	pendingSample *grpcapi.StoredTrialSample
+}
+
+// NewTrialSampleDownsampler creates a downsampler keeping every `factor`-th tick, factors of 0 and 1 keep every sample
+func NewTrialSampleDownsampler(factor uint64, toTickID *uint64) *TrialSampleDownsampler {
+	return &TrialSampleDownsampler{
+		factor:   factor,
+		toTickID: toTickID,
+		trials:   make(map[string]*downsampledTrial),
+		trialIDs: []string{},
+	}
+}
+
+// NewReverseTrialSampleDownsampler creates a downsampler, keeping every `factor`-th tick, of samples in decreasing tick
+// order. The first sample of each trial is then the one with the largest tick id and its last one a sample at
+// `fromTickID` or the last sample held back until `Flush` is called.
+func NewReverseTrialSampleDownsampler(factor uint64, fromTickID *uint64) *TrialSampleDownsampler {
+	d := NewTrialSampleDownsampler(factor, nil)
+	d.reverse = true
+	d.fromTickID = fromTickID
+	return d
+}
+
+// isLastTick returns true if the given sample is the last one of its trial within the selected tick range
+func (d *TrialSampleDownsampler) isLastTick(sample *grpcapi.StoredTrialSample) bool {
+	if d.reverse {
+		return d.fromTickID != nil && sample.TickId <= *d.fromTickID
+	}
+	return sample.State == grpcapi.TrialState_ENDED || (d.toTickID != nil && sample.TickId >= *d.toTickID)
+}
+
+// Keeps returns true if the given sample is kept, otherwise it might only be delivered at the end by `Flush`
+func (d *TrialSampleDownsampler) Keeps(sample *grpcapi.StoredTrialSample) bool {
+	if d.factor <= 1 {
+		return true
+	}
+	trial, ok := d.trials[sample.TrialId]
+	if !ok {
+		// First sample of the trial
+		d.trials[sample.TrialId] = &downsampledTrial{}
+		d.trialIDs = append(d.trialIDs, sample.TrialId)
+		return true
+	}
+	if sample.TickId%d.factor == 0 || d.isLastTick(sample) {
+		trial.pendingSample = nil
+		return true
+	}
+	trial.pendingSample = sample
+	return false
+}
+
+// Flush returns the last samples of each trial that were not kept, it should only be called once every sample was seen
+func (d *TrialSampleDownsampler) Flush() []*grpcapi.StoredTrialSample {
+	samples := []*grpcapi.StoredTrialSample{}
+	for _, trialID := range d.trialIDs {
+		trial := d.trials[trialID]
+		if trial.pendingSample != nil {
+			samples = append(samples, trial.pendingSample)
+			trial.pendingSample = nil
+		}
+	}
+	return samples
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/openlyinc/pointy"
+	"github.com/stretchr/testify/assert"
+)
+
+// generateTrialSamples generates the samples of a trial having the given ticks, the last one ending the trial if `ended`
+func generateTrialSamples(trialID string, ended bool, tickIDs ...uint64) []*grpcapi.StoredTrialSample {
+	samples := generateSamplesFromTickIDs(tickIDs...)
+	for _, sample := range samples {
+		sample.TrialId = trialID
+		sample.State = grpcapi.TrialState_RUNNING
+	}
+	if ended && len(samples) > 0 {
+		samples[len(samples)-1].State = grpcapi.TrialState_ENDED
+	}
+	return samples
+}
+
+// downsample returns the tick ids of the samples kept by the downsampler, including the flushed ones
+func downsample(d *TrialSampleDownsampler, samples []*grpcapi.StoredTrialSample) []uint64 {
+	tickIDs := []uint64{}
+	for _, sample := range samples {
+		if d.Keeps(sample) {
+			tickIDs = append(tickIDs, sample.TickId)
+		}
+	}
+	for _, sample := range d.Flush() {
+		tickIDs = append(tickIDs, sample.TickId)
+	}
+	return tickIDs
+}
+
+func TestTrialSampleDownsampler(t *testing.T) {
+	samples := generateTrialSamples("my-trial", true, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+
+	assert.Equal(t, []uint64{0, 3, 6, 9}, downsample(NewTrialSampleDownsampler(3, nil), samples))
+	assert.Equal(t, []uint64{0, 4, 8, 9}, downsample(NewTrialSampleDownsampler(4, nil), samples))
+	assert.Equal(t, []uint64{0, 9}, downsample(NewTrialSampleDownsampler(20, nil), samples))
+	assert.Len(t, downsample(NewTrialSampleDownsampler(1, nil), samples), 10)
+	assert.Len(t, downsample(NewTrialSampleDownsampler(0, nil), samples), 10)
+}
+
+func TestTrialSampleDownsamplerTickRange(t *testing.T) {
+	// Samples of the [1, 7] tick range of a 10-tick trial
+	samples := generateTrialSamples("my-trial", false, 1, 2, 3, 4, 5, 6, 7)
+	assert.Equal(t, []uint64{1, 3, 6, 7}, downsample(NewTrialSampleDownsampler(3, pointy.Uint64(7)), samples))
+
+	// The last sample of the range is missing
+	samples = generateTrialSamples("my-trial", false, 1, 2, 3, 4, 5, 7, 8)
+	assert.Equal(t, []uint64{1, 3, 8}, downsample(NewTrialSampleDownsampler(3, pointy.Uint64(10)), samples))
+}
+
+func TestReverseTrialSampleDownsampler(t *testing.T) {
+	samples := generateTrialSamples("my-trial", true, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	reverseSamples := make([]*grpcapi.StoredTrialSample, 0, len(samples))
+	for sampleIdx := len(samples) - 1; sampleIdx >= 0; sampleIdx-- {
+		reverseSamples = append(reverseSamples, samples[sampleIdx])
+	}
+
+	assert.Equal(t, []uint64{9, 6, 3, 0}, downsample(NewReverseTrialSampleDownsampler(3, nil), reverseSamples))
+	assert.Equal(t, []uint64{9, 8, 4, 0}, downsample(NewReverseTrialSampleDownsampler(4, nil), reverseSamples))
+
+	// Samples of the [2, 8] tick range
+	assert.Equal(t, []uint64{8, 6, 3, 2}, downsample(NewReverseTrialSampleDownsampler(3, pointy.Uint64(2)), reverseSamples[1:8]))
+
+	// The first sample of the range is missing
+	assert.Equal(t, []uint64{8, 4, 3}, downsample(NewReverseTrialSampleDownsampler(4, pointy.Uint64(2)), reverseSamples[1:7]))
+}
+
+func TestTrialSampleDownsamplerInterleavedTrials(t *testing.T) {
+	d := NewTrialSampleDownsampler(3, nil)
+	samplesA := generateTrialSamples("trial-a", false, 0, 1, 2, 3, 4)
+	samplesB := generateTrialSamples("trial-b", true, 1, 2, 3, 4, 5)
+	keptSamples := []*grpcapi.StoredTrialSample{}
+	for sampleIdx := range samplesA {
+		for _, sample := range []*grpcapi.StoredTrialSample{samplesA[sampleIdx], samplesB[sampleIdx]} {
+			if d.Keeps(sample) {
+				keptSamples = append(keptSamples, sample)
+			}
+		}
+	}
+	keptSamples = append(keptSamples, d.Flush()...)
+
+	keptTickIDs := map[string][]uint64{}
+	for _, sample := range keptSamples {
+		keptTickIDs[sample.TrialId] = append(keptTickIDs[sample.TrialId], sample.TickId)
+	}
+	assert.Equal(t, map[string][]uint64{
+		"trial-a": {0, 3, 4},
+		"trial-b": {1, 3, 5},
+	}, keptTickIDs)
+	assert.Len(t, d.Flush(), 0)
+}
@@ -15,29 +15,144 @@
 package backend
 
 import (
+	"fmt"
+	"strings"
+
 	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
 	"github.com/cogment/cogment-trial-datastore/utils"
 )
 
 // TrialSampleFilter represents the arguments to filter requested trial samples
 type TrialSampleFilter struct {
-	TrialIDs             []string
+	TrialIDs []string
+	// Actor names prefixed by `utils.ExclusionPrefix`, e.g. "!my-actor", are excluded
 	ActorNames           []string
 	ActorClasses         []string
 	ActorImplementations []string
 	Fields               []grpcapi.StoredTrialSampleField
+	RequireActions       bool // Only select samples in which at least one of the selected actors has an action
+	RequireAllActions    bool // Only select samples in which every selected actor has an action
+	// Only select the received rewards sent by the actors having the given names or indices, everything is selected if both are empty
+	ReceivedRewardSenderNames   []string
+	ReceivedRewardSenderIndices []int32
+	// Only select the sent rewards received by the actors having the given names or indices, everything is selected if both are empty
+	SentRewardReceiverNames   []string
+	SentRewardReceiverIndices []int32
+	// Only select the samples in which the selected actors sent messages to the actors having the given names or
+	// indices, the other sent messages are filtered out. Everything is selected if both are empty.
+	SentMessageReceiverNames   []string
+	SentMessageReceiverIndices []int32
+	// By default, broadcast rewards and messages, i.e. having a -1 sender or receiver, only match the senders and
+	// receivers filters explicitly selecting the -1 index. When set, they match every actor.
+	BroadcastMatchesAllActors bool
+	// Fields selected by default for the actors of the given classes, only used when `Fields` is empty
+	DefaultActorClassFields map[string][]grpcapi.StoredTrialSampleField
+	// Only select the samples whose tick id is within [FromTickID, ToTickID], nil bounds are unbounded.
+	//
+	// Samples are expected to be stored in increasing tick order, the observation of a trial ends after the first stored
+	// sample whose tick id is after `ToTickID`.
+	FromTickID *uint64
+	ToTickID   *uint64
+	// Deliver the samples of each trial in decreasing tick order, or in the decreasing order of the trial's sample
+	// ordering key. Only the samples stored when the observation starts are delivered, it doesn't wait for the
+	// following samples of an ongoing trial. Combined with a tick range, the observation of a trial ends after the
+	// first stored sample whose tick id is before `FromTickID`.
+	Reverse bool
+	// Only select the samples whose cumulated payloads size, in bytes, is within [MinPayloadsSize, MaxPayloadsSize],
+	// nil bounds are unbounded. The size is computed on what the other filters select.
+	MinPayloadsSize *int
+	MaxPayloadsSize *int
+	// When defined, the selected received rewards of each actor sample are collapsed into a single reward, see `RewardAggregation`
+	ReceivedRewardsAggregation RewardAggregation
+	// Strip the user data of the selected rewards and the payloads of the selected messages, the rewards values and
+	// confidences as well as the messages senders and receivers are kept
+	StripUserData bool
+	// Only keep the payloads referenced by the filtered sample, their references being rewritten, instead of leaving
+	// the filtered out payloads empty. Payloads keep their relative order.
+	CompactPayloads bool
+}
+
+// RewardAggregation defines how a list of rewards is collapsed into a single one
+type RewardAggregation int
+
+const (
+	// NoRewardAggregation keeps every reward
+	NoRewardAggregation RewardAggregation = iota
+	// SumRewardAggregation collapses rewards into one whose value is the sum of their values
+	SumRewardAggregation
+	// MeanRewardAggregation collapses rewards into one whose value is the mean of their values
+	MeanRewardAggregation
+)
+
+// ParseRewardAggregation parses a reward aggregation expressed as "none", "sum" or "mean", an empty string is "none"
+func ParseRewardAggregation(aggregationName string) (RewardAggregation, error) {
+	switch strings.ToLower(strings.TrimSpace(aggregationName)) {
+	case "", "none":
+		return NoRewardAggregation, nil
+	case "sum":
+		return SumRewardAggregation, nil
+	case "mean":
+		return MeanRewardAggregation, nil
+	default:
+		return NoRewardAggregation, fmt.Errorf("unknown reward aggregation %q, expecting one of [none sum mean]", aggregationName)
+	}
+}
+
+// aggregate collapses the given rewards received by an actor into a single one.
+//
+// The aggregated reward is sent by -1, its value is the sum or the mean of the rewards values, its confidence is the
+// mean of their confidences and it has no user data. No reward is aggregated into none.
+func (a RewardAggregation) aggregate(receiver uint32, rewards []*grpcapi.StoredTrialActorSampleReward) []*grpcapi.StoredTrialActorSampleReward {
+	if len(rewards) == 0 {
+		return rewards
+	}
+	aggregatedReward := &grpcapi.StoredTrialActorSampleReward{
+		Sender:   broadcastActorRef,
+		Receiver: int32(receiver),
+	}
+	for _, reward := range rewards {
+		aggregatedReward.Reward += reward.Reward
+		aggregatedReward.Confidence += reward.Confidence
+	}
+	if a == MeanRewardAggregation {
+		aggregatedReward.Reward /= float32(len(rewards))
+	}
+	aggregatedReward.Confidence /= float32(len(rewards))
+	return []*grpcapi.StoredTrialActorSampleReward{aggregatedReward}
 }
 
 // AppliedTrialSampleFilter represents a TrialSampleFilter applied to a particular trial
 type AppliedTrialSampleFilter struct {
-	trialParams  *grpcapi.TrialParams
-	actorsFilter *idxFilter
-	fieldsFilter *idxFilter
+	trialParams    *grpcapi.TrialParams
+	actorsFilter   *idxFilter
+	fieldsFilter   *idxFilter
+	requireActions bool
+	// Only select samples in which every selected actor has an action
+	requireAllActions bool
+	// Selected received rewards senders, nil means every sender is selected
+	receivedRewardSendersFilter map[int32]struct{}
+	// Selected sent rewards receivers, nil means every receiver is selected
+	sentRewardReceiversFilter map[int32]struct{}
+	// Selected sent messages receivers, nil means every receiver is selected
+	sentMessageReceiversFilter map[int32]struct{}
+	broadcastMatchesAllActors  bool
+	fromTickID                 *uint64
+	toTickID                   *uint64
+	minPayloadsSize            *int
+	maxPayloadsSize            *int
+	receivedRewardsAggregation RewardAggregation
+	stripUserData              bool
+	compactPayloads            bool
+	// Fields filters of the actors using a default one, by actor index
+	actorFieldsFilters map[uint32]*idxFilter
 }
 
+// noActorIdx is never the index of an actor, a filter only selecting it selects no actor
+const noActorIdx = -1
+
 func newActorsFilter(filter TrialSampleFilter, trialParams *grpcapi.TrialParams) *idxFilter {
 
-	actorNamesFilter := utils.NewIDFilter(filter.ActorNames)
+	actorNamesFilter := utils.NewExclusiveIDFilter(filter.ActorNames)
 	actorClassesFilter := utils.NewIDFilter(filter.ActorClasses)
 	actorImplsFilter := utils.NewIDFilter(filter.ActorImplementations)
 
@@ -47,18 +162,18 @@ func newActorsFilter(filter TrialSampleFilter, trialParams *grpcapi.TrialParams)
 
 	actorsFilter := newIdxFilter([]int{})
 	selectAllActors := true
-	for actorIdx, actorParams := range trialParams.Actors {
+	for actorIdx, actorParams := range trialParams.GetActors() {
 		selectActorName := actorNamesFilter.SelectsAll()
 		if !selectActorName {
 			selectActorName = actorNamesFilter.Selects(actorParams.Name)
 		}
 		selectActorClass := actorClassesFilter.SelectsAll()
-		if !selectActorName {
-			selectActorClass = actorNamesFilter.Selects(actorParams.ActorClass)
+		if !selectActorClass {
+			selectActorClass = actorClassesFilter.Selects(actorParams.ActorClass)
 		}
 		selectActorImpl := actorImplsFilter.SelectsAll()
 		if !selectActorImpl {
-			selectActorImpl = actorNamesFilter.Selects(actorParams.Implementation)
+			selectActorImpl = actorImplsFilter.Selects(actorParams.Implementation)
 		}
 
 		if selectActorName && selectActorClass && selectActorImpl {
@@ -70,10 +185,125 @@ func newActorsFilter(filter TrialSampleFilter, trialParams *grpcapi.TrialParams)
 	if selectAllActors {
 		return newIdxFilter([]int{})
 	}
+	if actorsFilter.selectsAll() {
+		// No actor is selected, an empty filter would select them all
+		return newIdxFilter([]int{noActorIdx})
+	}
 
 	return actorsFilter
 }
 
+// broadcastActorRef is the sender or receiver of rewards and messages broadcasted to, or sent by, every actor
+const broadcastActorRef int32 = -1
+
+// newActorRefsFilter builds the set of actor indices, as used by the senders and receivers of rewards and
+// messages, matching the given names or indices, nil means every actor is selected
+func newActorRefsFilter(names []string, indices []int32, trialParams *grpcapi.TrialParams) map[int32]struct{} {
+	if len(names) == 0 && len(indices) == 0 {
+		return nil
+	}
+	actorRefsFilter := make(map[int32]struct{})
+	for _, actorIdx := range indices {
+		actorRefsFilter[actorIdx] = struct{}{}
+	}
+	actorNamesFilter := utils.NewIDFilter(names)
+	if !actorNamesFilter.SelectsAll() {
+		for actorIdx, actorParams := range trialParams.GetActors() {
+			if actorNamesFilter.Selects(actorParams.Name) {
+				actorRefsFilter[int32(actorIdx)] = struct{}{}
+			}
+		}
+	}
+	return actorRefsFilter
+}
+
+func (f *AppliedTrialSampleFilter) selectsActorRef(actorRefsFilter map[int32]struct{}, actorRef int32) bool {
+	if actorRefsFilter == nil {
+		return true
+	}
+	if actorRef == broadcastActorRef && f.broadcastMatchesAllActors {
+		return true
+	}
+	_, isSelected := actorRefsFilter[actorRef]
+	return isSelected
+}
+
+func (f *AppliedTrialSampleFilter) selectsReceivedReward(reward *grpcapi.StoredTrialActorSampleReward) bool {
+	return f.selectsActorRef(f.receivedRewardSendersFilter, reward.Sender)
+}
+
+func (f *AppliedTrialSampleFilter) selectsSentReward(reward *grpcapi.StoredTrialActorSampleReward) bool {
+	return f.selectsActorRef(f.sentRewardReceiversFilter, reward.Receiver)
+}
+
+func (f *AppliedTrialSampleFilter) selectsSentMessage(message *grpcapi.StoredTrialActorSampleMessage) bool {
+	return f.selectsActorRef(f.sentMessageReceiversFilter, message.Receiver)
+}
+
+func newActorFieldsFilters(filter TrialSampleFilter, trialParams *grpcapi.TrialParams) map[uint32]*idxFilter {
+	actorFieldsFilters := make(map[uint32]*idxFilter)
+	if len(filter.Fields) > 0 || len(filter.DefaultActorClassFields) == 0 {
+		// Fields explicitly requested take precedence over the defaults
+		return actorFieldsFilters
+	}
+	for actorIdx, actorParams := range trialParams.GetActors() {
+		if fields, ok := filter.DefaultActorClassFields[actorParams.ActorClass]; ok {
+			actorFieldsFilter := newFieldsFilter(fields)
+			if !actorFieldsFilter.selectsAll() {
+				actorFieldsFilters[uint32(actorIdx)] = actorFieldsFilter
+			}
+		}
+	}
+	return actorFieldsFilters
+}
+
+func (f *AppliedTrialSampleFilter) actorFieldsFilter(actorIdx uint32) *idxFilter {
+	if actorFieldsFilter, ok := f.actorFieldsFilters[actorIdx]; ok {
+		return actorFieldsFilter
+	}
+	return f.fieldsFilter
+}
+
+// ParseStoredTrialSampleField parses a field expressed as its lowercase short name, e.g. "received_rewards", or
+// its full enum name, e.g. "STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS"
+func ParseStoredTrialSampleField(fieldName string) (grpcapi.StoredTrialSampleField, error) {
+	enumName := strings.ToUpper(strings.TrimSpace(fieldName))
+	if !strings.HasPrefix(enumName, "STORED_TRIAL_SAMPLE_FIELD_") {
+		enumName = "STORED_TRIAL_SAMPLE_FIELD_" + enumName
+	}
+	field, ok := grpcapi.StoredTrialSampleField_value[enumName]
+	if !ok || field == int32(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_UNKNOWN) {
+		return grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_UNKNOWN, fmt.Errorf("unknown sample field %q", fieldName)
+	}
+	return grpcapi.StoredTrialSampleField(field), nil
+}
+
+// ParseDefaultActorClassFields parses default fields per actor class expressed as semicolon-separated
+// `actor_class=field,field` definitions, e.g. "renderer=observation,action;player=action,reward"
+func ParseDefaultActorClassFields(definitions string) (map[string][]grpcapi.StoredTrialSampleField, error) {
+	actorClassFields := make(map[string][]grpcapi.StoredTrialSampleField)
+	for _, definition := range strings.Split(definitions, ";") {
+		if strings.TrimSpace(definition) == "" {
+			continue
+		}
+		actorClassAndFields := strings.SplitN(definition, "=", 2)
+		actorClass := strings.TrimSpace(actorClassAndFields[0])
+		if len(actorClassAndFields) != 2 || actorClass == "" {
+			return nil, fmt.Errorf("invalid default actor class fields definition %q, expecting `actor_class=field,field`", definition)
+		}
+		fields := []grpcapi.StoredTrialSampleField{}
+		for _, fieldName := range strings.Split(actorClassAndFields[1], ",") {
+			field, err := ParseStoredTrialSampleField(fieldName)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default fields for actor class %q (%w)", actorClass, err)
+			}
+			fields = append(fields, field)
+		}
+		actorClassFields[actorClass] = fields
+	}
+	return actorClassFields, nil
+}
+
 func newFieldsFilter(fields []grpcapi.StoredTrialSampleField) *idxFilter {
 	fieldsFilter := newIdxFilter([]int{})
 	for _, field := range fields {
@@ -87,23 +317,197 @@ func newFieldsFilter(fields []grpcapi.StoredTrialSampleField) *idxFilter {
 	return fieldsFilter
 }
 
+// SelectsEverySample returns true if the filter never filters out a sample altogether, the stored samples of the
+// selected trials are then all delivered, possibly with less contents
+func (filter TrialSampleFilter) SelectsEverySample() bool {
+	return filter.FromTickID == nil && filter.ToTickID == nil && !filter.RequireActions && !filter.RequireAllActions && len(filter.SentMessageReceiverNames) == 0 && len(filter.SentMessageReceiverIndices) == 0 && filter.MinPayloadsSize == nil && filter.MaxPayloadsSize == nil
+}
+
+// CheckTrialParams returns a `MissingTrialParamsError` if the filter resolves actor names, classes or implementations
+// while the params of the given trial are unavailable, i.e. nil.
+//
+// Filters only using actor indices don't require the trial params. Without them, the default fields of the actor
+// classes are ignored.
+func (filter TrialSampleFilter) CheckTrialParams(trialID string, trialParams *grpcapi.TrialParams) error {
+	if trialParams != nil {
+		return nil
+	}
+	criteria := []struct {
+		filter string
+		values []string
+	}{
+		{"actor names", filter.ActorNames},
+		{"actor classes", filter.ActorClasses},
+		{"actor implementations", filter.ActorImplementations},
+		{"received reward sender names", filter.ReceivedRewardSenderNames},
+		{"sent reward receiver names", filter.SentRewardReceiverNames},
+		{"sent message receiver names", filter.SentMessageReceiverNames},
+	}
+	for _, criterion := range criteria {
+		if len(criterion.values) > 0 {
+			return &MissingTrialParamsError{TrialID: trialID, Filter: criterion.filter}
+		}
+	}
+	return nil
+}
+
+// NewAppliedTrialSampleFilter applies the given filter to a trial having the given params.
+//
+// Nil params are handled as a trial without actors, `CheckTrialParams` is expected to be called beforehand.
 func NewAppliedTrialSampleFilter(filter TrialSampleFilter, trialParams *grpcapi.TrialParams) *AppliedTrialSampleFilter {
 	return &AppliedTrialSampleFilter{
-		trialParams:  trialParams,
-		actorsFilter: newActorsFilter(filter, trialParams),
-		fieldsFilter: newFieldsFilter(filter.Fields),
+		trialParams:    trialParams,
+		actorsFilter:   newActorsFilter(filter, trialParams),
+		fieldsFilter:   newFieldsFilter(filter.Fields),
+		requireActions: filter.RequireActions,
+
+		requireAllActions:           filter.RequireAllActions,
+		receivedRewardSendersFilter: newActorRefsFilter(filter.ReceivedRewardSenderNames, filter.ReceivedRewardSenderIndices, trialParams),
+		sentRewardReceiversFilter:   newActorRefsFilter(filter.SentRewardReceiverNames, filter.SentRewardReceiverIndices, trialParams),
+		sentMessageReceiversFilter:  newActorRefsFilter(filter.SentMessageReceiverNames, filter.SentMessageReceiverIndices, trialParams),
+		broadcastMatchesAllActors:   filter.BroadcastMatchesAllActors,
+		fromTickID:                  filter.FromTickID,
+		toTickID:                    filter.ToTickID,
+		minPayloadsSize:             filter.MinPayloadsSize,
+		maxPayloadsSize:             filter.MaxPayloadsSize,
+		receivedRewardsAggregation:  filter.ReceivedRewardsAggregation,
+		stripUserData:               filter.StripUserData,
+		compactPayloads:             filter.CompactPayloads,
+		actorFieldsFilters:          newActorFieldsFilters(filter, trialParams),
 	}
 }
 
 func (f *AppliedTrialSampleFilter) SelectsAll() bool {
-	return f.actorsFilter.selectsAll() && f.fieldsFilter.selectsAll()
+	return f.fromTickID == nil && f.toTickID == nil && f.minPayloadsSize == nil && f.maxPayloadsSize == nil && f.selectsAllContents()
+}
+
+// SelectsTick returns true if a sample having the given tick id is within the selected tick range
+func (f *AppliedTrialSampleFilter) SelectsTick(tickID uint64) bool {
+	return (f.fromTickID == nil || tickID >= *f.fromTickID) && !f.IsPastTickRange(tickID)
+}
+
+// IsPastTickRange returns true if the given tick id is after the selected tick range
+func (f *AppliedTrialSampleFilter) IsPastTickRange(tickID uint64) bool {
+	return f.toTickID != nil && tickID > *f.toTickID
 }
 
+// IsBeforeTickRange returns true if the given tick id is before the selected tick range
+func (f *AppliedTrialSampleFilter) IsBeforeTickRange(tickID uint64) bool {
+	return f.fromTickID != nil && tickID < *f.fromTickID
+}
+
+func (f *AppliedTrialSampleFilter) selectsAllContents() bool {
+	return f.actorsFilter.selectsAll() && f.fieldsFilter.selectsAll() && !f.requireActions && !f.requireAllActions && f.receivedRewardSendersFilter == nil && f.sentRewardReceiversFilter == nil && f.sentMessageReceiversFilter == nil && len(f.actorFieldsFilters) == 0 && f.receivedRewardsAggregation == NoRewardAggregation && !f.stripUserData && !f.compactPayloads
+}
+
+// filterReward returns the given reward, or a copy of it without user data when it is stripped
+func (f *AppliedTrialSampleFilter) filterReward(reward *grpcapi.StoredTrialActorSampleReward) *grpcapi.StoredTrialActorSampleReward {
+	if !f.stripUserData || reward.UserData == nil {
+		return reward
+	}
+	return &grpcapi.StoredTrialActorSampleReward{
+		Sender:     reward.Sender,
+		Receiver:   reward.Receiver,
+		Reward:     reward.Reward,
+		Confidence: reward.Confidence,
+	}
+}
+
+// selectsPayloadsSize returns true if the cumulated size of the payloads of the given sample is within the selected range
+func (f *AppliedTrialSampleFilter) selectsPayloadsSize(sample *grpcapi.StoredTrialSample) bool {
+	if f.minPayloadsSize == nil && f.maxPayloadsSize == nil {
+		return true
+	}
+	payloadsSize := 0
+	for _, payload := range sample.Payloads {
+		payloadsSize += len(payload)
+	}
+	return (f.minPayloadsSize == nil || payloadsSize >= *f.minPayloadsSize) && (f.maxPayloadsSize == nil || payloadsSize <= *f.maxPayloadsSize)
+}
+
+func (f *AppliedTrialSampleFilter) hasSelectedAction(sample *grpcapi.StoredTrialSample) bool {
+	for _, actorSample := range sample.ActorSamples {
+		if actorSample.Action != nil && f.actorsFilter.selects(int(actorSample.Actor)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAllSelectedActions returns true if every selected actor has an action in the given sample, the actors of the
+// trial missing from the sample don't have one
+func (f *AppliedTrialSampleFilter) hasAllSelectedActions(sample *grpcapi.StoredTrialSample) bool {
+	actorsHavingAction := make(map[uint32]struct{}, len(sample.ActorSamples))
+	for _, actorSample := range sample.ActorSamples {
+		if !f.actorsFilter.selects(int(actorSample.Actor)) {
+			continue
+		}
+		if actorSample.Action == nil {
+			return false
+		}
+		actorsHavingAction[actorSample.Actor] = struct{}{}
+	}
+	for actorIdx := range f.trialParams.GetActors() {
+		if _, ok := actorsHavingAction[uint32(actorIdx)]; !ok && f.actorsFilter.selects(actorIdx) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *AppliedTrialSampleFilter) hasSelectedSentMessage(sample *grpcapi.StoredTrialSample) bool {
+	for _, actorSample := range sample.ActorSamples {
+		if !f.actorsFilter.selects(int(actorSample.Actor)) {
+			continue
+		}
+		for _, message := range actorSample.SentMessages {
+			if f.selectsSentMessage(message) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filter returns a filtered version of the given sample.
+//
+// When the filter selects everything, the given sample is returned as is without any allocation. In any case,
+// the returned sample shares data with the given sample and neither should be modified afterwards. Filter never
+// modifies the given sample, filtering a cached sample is safe.
+//
+// When the sample is filtered out altogether, nil is returned.
 func (f *AppliedTrialSampleFilter) Filter(sample *grpcapi.StoredTrialSample) *grpcapi.StoredTrialSample {
-	if f.actorsFilter.selectsAll() && f.fieldsFilter.selectsAll() {
+	if !f.SelectsTick(sample.TickId) {
+		return nil
+	}
+
+	filteredSample := f.filterContents(sample)
+	if filteredSample == nil || !f.selectsPayloadsSize(filteredSample) {
+		return nil
+	}
+	if f.compactPayloads {
+		return compactSamplePayloads(filteredSample)
+	}
+	return filteredSample
+}
+
+func (f *AppliedTrialSampleFilter) filterContents(sample *grpcapi.StoredTrialSample) *grpcapi.StoredTrialSample {
+	if f.selectsAllContents() {
 		return sample
 	}
 
+	if f.requireActions && !f.hasSelectedAction(sample) {
+		return nil
+	}
+
+	if f.requireAllActions && !f.hasAllSelectedActions(sample) {
+		return nil
+	}
+
+	if f.sentMessageReceiversFilter != nil && !f.hasSelectedSentMessage(sample) {
+		return nil
+	}
+
 	// Copy the base
 	filteredSample := grpcapi.StoredTrialSample{
 		UserId:       sample.UserId,
@@ -118,6 +522,7 @@ func (f *AppliedTrialSampleFilter) Filter(sample *grpcapi.StoredTrialSample) *gr
 	// Copy selected fields of selected agents
 	for _, actorSample := range sample.ActorSamples {
 		if f.actorsFilter.selects(int(actorSample.Actor)) {
+			fieldsFilter := f.actorFieldsFilter(actorSample.Actor)
 			filteredActorSample := grpcapi.StoredTrialActorSample{
 				Actor:            actorSample.Actor,
 				ReceivedRewards:  make([]*grpcapi.StoredTrialActorSampleReward, 0, len(actorSample.ReceivedRewards)),
@@ -125,49 +530,65 @@ func (f *AppliedTrialSampleFilter) Filter(sample *grpcapi.StoredTrialSample) *gr
 				ReceivedMessages: make([]*grpcapi.StoredTrialActorSampleMessage, 0, len(actorSample.ReceivedMessages)),
 				SentMessages:     make([]*grpcapi.StoredTrialActorSampleMessage, 0, len(actorSample.SentMessages)),
 			}
-			if actorSample.Observation != nil && f.fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION)) {
+			if actorSample.Observation != nil && fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION)) {
 				filteredActorSample.Observation = actorSample.Observation
 				filteredSample.Payloads[*actorSample.Observation] = sample.Payloads[*actorSample.Observation]
 			}
 
-			if actorSample.Action != nil && f.fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION)) {
+			if actorSample.Action != nil && fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION)) {
 				filteredActorSample.Action = actorSample.Action
 				filteredSample.Payloads[*actorSample.Action] = sample.Payloads[*actorSample.Action]
 			}
 
-			if f.fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_REWARD)) {
+			if fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_REWARD)) {
 				filteredActorSample.Reward = actorSample.Reward
 			}
 
-			if f.fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS)) {
+			if fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS)) {
 				for _, reward := range actorSample.ReceivedRewards {
-					filteredActorSample.ReceivedRewards = append(filteredActorSample.ReceivedRewards, reward)
-					if reward.UserData != nil {
+					if !f.selectsReceivedReward(reward) {
+						continue
+					}
+					filteredActorSample.ReceivedRewards = append(filteredActorSample.ReceivedRewards, f.filterReward(reward))
+					if reward.UserData != nil && f.receivedRewardsAggregation == NoRewardAggregation && !f.stripUserData {
 						filteredSample.Payloads[*reward.UserData] = sample.Payloads[*reward.UserData]
 					}
 				}
+				if f.receivedRewardsAggregation != NoRewardAggregation {
+					filteredActorSample.ReceivedRewards = f.receivedRewardsAggregation.aggregate(actorSample.Actor, filteredActorSample.ReceivedRewards)
+				}
 			}
 
-			if f.fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_REWARDS)) {
+			if fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_REWARDS)) {
 				for _, reward := range actorSample.SentRewards {
-					filteredActorSample.SentRewards = append(filteredActorSample.SentRewards, reward)
-					if reward.UserData != nil {
+					if !f.selectsSentReward(reward) {
+						continue
+					}
+					filteredActorSample.SentRewards = append(filteredActorSample.SentRewards, f.filterReward(reward))
+					if reward.UserData != nil && !f.stripUserData {
 						filteredSample.Payloads[*reward.UserData] = sample.Payloads[*reward.UserData]
 					}
 				}
 			}
 
-			if f.fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_MESSAGES)) {
+			if fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_MESSAGES)) {
 				for _, message := range actorSample.ReceivedMessages {
 					filteredActorSample.ReceivedMessages = append(filteredActorSample.ReceivedMessages, message)
-					filteredSample.Payloads[message.Payload] = sample.Payloads[message.Payload]
+					if !f.stripUserData {
+						filteredSample.Payloads[message.Payload] = sample.Payloads[message.Payload]
+					}
 				}
 			}
 
-			if f.fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_MESSAGES)) {
+			if fieldsFilter.selects(int(grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_MESSAGES)) {
 				for _, message := range actorSample.SentMessages {
+					if !f.selectsSentMessage(message) {
+						continue
+					}
 					filteredActorSample.SentMessages = append(filteredActorSample.SentMessages, message)
-					filteredSample.Payloads[message.Payload] = sample.Payloads[message.Payload]
+					if !f.stripUserData {
+						filteredSample.Payloads[message.Payload] = sample.Payloads[message.Payload]
+					}
 				}
 			}
 			filteredSample.ActorSamples = append(filteredSample.ActorSamples, &filteredActorSample)
@@ -177,6 +598,113 @@ func (f *AppliedTrialSampleFilter) Filter(sample *grpcapi.StoredTrialSample) *gr
 	return &filteredSample
 }
 
+// compactSamplePayloads returns a version of the given sample only holding the payloads it references, in their
+// initial order, every payload reference being rewritten accordingly.
+//
+// The given sample isn't modified, it is returned as is when every payload is referenced or when a reference is
+// out of range.
+func compactSamplePayloads(sample *grpcapi.StoredTrialSample) *grpcapi.StoredTrialSample {
+	referencedPayloads := make([]bool, len(sample.Payloads))
+	validReferences := true
+	reference := func(payloadIdx uint32) {
+		if int(payloadIdx) >= len(referencedPayloads) {
+			validReferences = false
+			return
+		}
+		referencedPayloads[payloadIdx] = true
+	}
+	for _, actorSample := range sample.ActorSamples {
+		if actorSample.Observation != nil {
+			reference(*actorSample.Observation)
+		}
+		if actorSample.Action != nil {
+			reference(*actorSample.Action)
+		}
+		for _, rewards := range [][]*grpcapi.StoredTrialActorSampleReward{actorSample.ReceivedRewards, actorSample.SentRewards} {
+			for _, reward := range rewards {
+				if reward.UserData != nil {
+					reference(*reward.UserData)
+				}
+			}
+		}
+		for _, messages := range [][]*grpcapi.StoredTrialActorSampleMessage{actorSample.ReceivedMessages, actorSample.SentMessages} {
+			for _, message := range messages {
+				reference(message.Payload)
+			}
+		}
+	}
+	if !validReferences {
+		return sample
+	}
+
+	compactedPayloadIdxs := make([]uint32, len(sample.Payloads))
+	compactedPayloads := make([][]byte, 0, len(sample.Payloads))
+	for payloadIdx, referenced := range referencedPayloads {
+		if referenced {
+			compactedPayloadIdxs[payloadIdx] = uint32(len(compactedPayloads))
+			compactedPayloads = append(compactedPayloads, sample.Payloads[payloadIdx])
+		}
+	}
+	if len(compactedPayloads) == len(sample.Payloads) {
+		return sample
+	}
+
+	compactPayloadIdx := func(payloadIdx *uint32) *uint32 {
+		if payloadIdx == nil {
+			return nil
+		}
+		compactedPayloadIdx := compactedPayloadIdxs[*payloadIdx]
+		return &compactedPayloadIdx
+	}
+	compactRewards := func(rewards []*grpcapi.StoredTrialActorSampleReward) []*grpcapi.StoredTrialActorSampleReward {
+		compactedRewards := make([]*grpcapi.StoredTrialActorSampleReward, len(rewards))
+		for rewardIdx, reward := range rewards {
+			compactedRewards[rewardIdx] = &grpcapi.StoredTrialActorSampleReward{
+				Sender:     reward.Sender,
+				Receiver:   reward.Receiver,
+				Reward:     reward.Reward,
+				Confidence: reward.Confidence,
+				UserData:   compactPayloadIdx(reward.UserData),
+			}
+		}
+		return compactedRewards
+	}
+	compactMessages := func(messages []*grpcapi.StoredTrialActorSampleMessage) []*grpcapi.StoredTrialActorSampleMessage {
+		compactedMessages := make([]*grpcapi.StoredTrialActorSampleMessage, len(messages))
+		for messageIdx, message := range messages {
+			compactedMessages[messageIdx] = &grpcapi.StoredTrialActorSampleMessage{
+				Sender:   message.Sender,
+				Receiver: message.Receiver,
+				Payload:  compactedPayloadIdxs[message.Payload],
+			}
+		}
+		return compactedMessages
+	}
+
+	compactedSample := &grpcapi.StoredTrialSample{
+		UserId:       sample.UserId,
+		TrialId:      sample.TrialId,
+		TickId:       sample.TickId,
+		Timestamp:    sample.Timestamp,
+		State:        sample.State,
+		ActorSamples: make([]*grpcapi.StoredTrialActorSample, len(sample.ActorSamples)),
+		Payloads:     compactedPayloads,
+	}
+	for actorSampleIdx, actorSample := range sample.ActorSamples {
+		compactedSample.ActorSamples[actorSampleIdx] = &grpcapi.StoredTrialActorSample{
+			Actor:            actorSample.Actor,
+			Observation:      compactPayloadIdx(actorSample.Observation),
+			Action:           compactPayloadIdx(actorSample.Action),
+			Reward:           actorSample.Reward,
+			ReceivedRewards:  compactRewards(actorSample.ReceivedRewards),
+			SentRewards:      compactRewards(actorSample.SentRewards),
+			ReceivedMessages: compactMessages(actorSample.ReceivedMessages),
+			SentMessages:     compactMessages(actorSample.SentMessages),
+		}
+	}
+	return compactedSample
+}
+
 type idxFilter map[int]struct{}
 
 func newIdxFilter(selectedIdxs []int) *idxFilter {
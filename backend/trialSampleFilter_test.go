@@ -125,6 +125,7 @@ func TestNoFilters(t *testing.T) {
 	f := NewAppliedTrialSampleFilter(TrialSampleFilter{}, trialParams)
 	filteredTrialSample1 := f.Filter(trialSample1)
 	assert.True(t, proto.Equal(trialSample1, filteredTrialSample1))
+	assert.Same(t, trialSample1, filteredTrialSample1)
 }
 
 func TestFieldFiltersFilterOutRewardsAndMessages(t *testing.T) {
@@ -218,3 +219,776 @@ func TestActorNameFilters(t *testing.T) {
 
 	assert.True(t, proto.Equal(twiceFilteredTrialSample1, filteredTrialSample1))
 }
+
+func TestActorImplementationFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorImplementations: []string{"my-actor-implementation"},
+	}, trialParams)
+
+	// Every actor of the trial shares the selected implementation
+	assert.True(t, f.SelectsAll())
+	assert.True(t, proto.Equal(trialSample1, f.Filter(trialSample1)))
+
+	abTestTrialParams := proto.Clone(trialParams).(*grpcapi.TrialParams)
+	abTestTrialParams.Actors[1].ActorClass = abTestTrialParams.Actors[0].ActorClass
+	abTestTrialParams.Actors[1].Implementation = "my-other-actor-implementation"
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorImplementations: []string{"my-actor-implementation"},
+		Fields: []grpcapi.StoredTrialSampleField{
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_REWARDS,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_MESSAGES,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_MESSAGES,
+		},
+	}, abTestTrialParams)
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+
+	assert.Less(t, proto.Size(filteredTrialSample1), proto.Size(trialSample1))
+
+	assert.Len(t, filteredTrialSample1.ActorSamples, 1)
+	assert.Equal(t, uint32(0), filteredTrialSample1.ActorSamples[0].Actor)
+
+	assert.NotEmpty(t, filteredTrialSample1.Payloads[0])
+	assert.NotEmpty(t, filteredTrialSample1.Payloads[1])
+	assert.NotEmpty(t, filteredTrialSample1.Payloads[2])
+	assert.Empty(t, filteredTrialSample1.Payloads[3])
+	assert.Empty(t, filteredTrialSample1.Payloads[4])
+	assert.Empty(t, filteredTrialSample1.Payloads[5])
+
+	twiceFilteredTrialSample1 := f.Filter(filteredTrialSample1)
+
+	assert.True(t, proto.Equal(twiceFilteredTrialSample1, filteredTrialSample1))
+}
+
+func TestActorClassAndImplementationFilters(t *testing.T) {
+	// Filters are intersected
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorClasses:         []string{"my-actor-class-2"},
+		ActorImplementations: []string{"my-actor-implementation"},
+	}, trialParams)
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples, 1)
+	assert.Equal(t, uint32(1), filteredTrialSample1.ActorSamples[0].Actor)
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames:           []string{"my-actor-1"},
+		ActorClasses:         []string{"my-actor-class-2"},
+		ActorImplementations: []string{"my-actor-implementation"},
+	}, trialParams)
+
+	filteredTrialSample1 = f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples, 0)
+}
+
+func TestActorNameExclusionFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames: []string{"!my-actor-1"},
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Less(t, proto.Size(filteredTrialSample1), proto.Size(trialSample1))
+	assert.Len(t, filteredTrialSample1.ActorSamples, 1)
+	assert.Equal(t, uint32(1), filteredTrialSample1.ActorSamples[0].Actor)
+	// The payloads only referenced by the excluded actor are zeroed
+	assert.Empty(t, filteredTrialSample1.Payloads[1])
+	assert.NotEmpty(t, filteredTrialSample1.Payloads[3])
+
+	// Excluding an actor that isn't in the trial selects everything
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames: []string{"!unknown-actor"},
+	}, trialParams)
+	assert.True(t, proto.Equal(trialSample1, f.Filter(trialSample1)))
+}
+
+func TestActorNameInclusionAndExclusionFilters(t *testing.T) {
+	// Exclusion wins on conflict
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames: []string{"my-actor-1", "my-actor-2", "!my-actor-2"},
+	}, trialParams)
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples, 1)
+	assert.Equal(t, uint32(0), filteredTrialSample1.ActorSamples[0].Actor)
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames: []string{"my-actor-1", "!my-actor-1"},
+	}, trialParams)
+
+	filteredTrialSample1 = f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples, 0)
+}
+
+var trialSample2 *grpcapi.StoredTrialSample = &grpcapi.StoredTrialSample{
+	UserId:    "my-user-id",
+	TrialId:   "my-trial",
+	TickId:    13,
+	Timestamp: uint64(time.Now().Unix()),
+	ActorSamples: []*grpcapi.StoredTrialActorSample{
+		{
+			Actor:       0,
+			Observation: pointy.Uint32(0),
+		},
+		{
+			Actor:       1,
+			Observation: pointy.Uint32(0),
+			Action:      pointy.Uint32(1),
+		},
+	},
+	Payloads: [][]byte{
+		[]byte("an observation"),
+		[]byte("an action"),
+	},
+}
+
+func TestRequireActionsFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		RequireActions: true,
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	filteredTrialSample2 := f.Filter(trialSample2)
+	assert.True(t, proto.Equal(trialSample2, filteredTrialSample2))
+
+	twiceFilteredTrialSample2 := f.Filter(filteredTrialSample2)
+	assert.True(t, proto.Equal(twiceFilteredTrialSample2, filteredTrialSample2))
+}
+
+func TestRequireActionsAndActorNameFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames:     []string{"my-actor-1"},
+		RequireActions: true,
+	}, trialParams)
+
+	// The only actor having an action in trialSample2 is filtered out, the sample is dropped
+	assert.Nil(t, f.Filter(trialSample2))
+
+	// Both actors have an action in trialSample1, the sample is kept
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.NotNil(t, filteredTrialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples, 1)
+
+	twiceFilteredTrialSample1 := f.Filter(filteredTrialSample1)
+	assert.True(t, proto.Equal(twiceFilteredTrialSample1, filteredTrialSample1))
+}
+
+func TestRequireAllActionsFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		RequireAllActions: true,
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	// Both actors have an action in trialSample1, the sample is kept
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.True(t, proto.Equal(trialSample1, filteredTrialSample1))
+
+	twiceFilteredTrialSample1 := f.Filter(filteredTrialSample1)
+	assert.True(t, proto.Equal(twiceFilteredTrialSample1, filteredTrialSample1))
+
+	// The first actor doesn't have an action in trialSample2, the sample is dropped
+	assert.Nil(t, f.Filter(trialSample2))
+
+	// The first actor is missing from the sample, the sample is dropped
+	assert.Nil(t, f.Filter(&grpcapi.StoredTrialSample{
+		TickId:       14,
+		ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 1, Action: pointy.Uint32(0)}},
+		Payloads:     [][]byte{[]byte("an action")},
+	}))
+}
+
+func TestRequireAllActionsAndActorNameFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames:        []string{"my-actor-2"},
+		RequireAllActions: true,
+	}, trialParams)
+
+	// The only selected actor has an action in trialSample2, the sample is kept
+	filteredTrialSample2 := f.Filter(trialSample2)
+	assert.NotNil(t, filteredTrialSample2)
+	assert.Len(t, filteredTrialSample2.ActorSamples, 1)
+	assert.Equal(t, uint32(1), filteredTrialSample2.ActorSamples[0].Actor)
+
+	twiceFilteredTrialSample2 := f.Filter(filteredTrialSample2)
+	assert.True(t, proto.Equal(twiceFilteredTrialSample2, filteredTrialSample2))
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames:        []string{"my-actor-1"},
+		RequireAllActions: true,
+	}, trialParams)
+
+	// The only selected actor doesn't have an action in trialSample2, the sample is dropped
+	assert.Nil(t, f.Filter(trialSample2))
+	assert.NotNil(t, f.Filter(trialSample1))
+}
+
+func TestReceivedRewardSendersFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ReceivedRewardSenderIndices: []int32{1},
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 1)
+	assert.Equal(t, int32(1), filteredTrialSample1.ActorSamples[0].ReceivedRewards[0].Sender)
+	assert.NotEmpty(t, filteredTrialSample1.Payloads[2])
+	// Other rewards are kept
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentRewards, 1)
+
+	twiceFilteredTrialSample1 := f.Filter(filteredTrialSample1)
+	assert.True(t, proto.Equal(twiceFilteredTrialSample1, filteredTrialSample1))
+}
+
+func TestFilterWithoutTrialParams(t *testing.T) {
+	filter := TrialSampleFilter{ActorNames: []string{"my-actor-1"}}
+	err := filter.CheckTrialParams("my-trial", nil)
+	var missingTrialParamsErr *MissingTrialParamsError
+	assert.ErrorAs(t, err, &missingTrialParamsErr)
+	assert.EqualError(t, err, "unable to filter the samples of trial \"my-trial\" by actor names, its params are unavailable")
+	assert.NoError(t, filter.CheckTrialParams("my-trial", trialParams))
+
+	err = TrialSampleFilter{SentMessageReceiverNames: []string{"my-actor-2"}}.CheckTrialParams("my-trial", nil)
+	assert.EqualError(t, err, "unable to filter the samples of trial \"my-trial\" by sent message receiver names, its params are unavailable")
+
+	// Filtering by actor indices doesn't require the trial params
+	filter = TrialSampleFilter{
+		ReceivedRewardSenderIndices: []int32{1},
+		DefaultActorClassFields: map[string][]grpcapi.StoredTrialSampleField{
+			"my-actor-class-1": {grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION},
+		},
+	}
+	assert.NoError(t, filter.CheckTrialParams("my-trial", nil))
+	f := NewAppliedTrialSampleFilter(filter, nil)
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 1)
+	assert.Equal(t, int32(1), filteredTrialSample1.ActorSamples[0].ReceivedRewards[0].Sender)
+	// Without the trial params the default fields of the actor classes are ignored
+	assert.NotNil(t, filteredTrialSample1.ActorSamples[0].Observation)
+}
+
+func TestReceivedRewardSendersFiltersPrunePayloads(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ReceivedRewardSenderIndices: []int32{-1},
+		Fields: []grpcapi.StoredTrialSampleField{
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS,
+		},
+	}, trialParams)
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 1)
+	assert.Equal(t, int32(-1), filteredTrialSample1.ActorSamples[0].ReceivedRewards[0].Sender)
+	// The user data of the dropped reward is pruned
+	assert.Empty(t, filteredTrialSample1.Payloads[2])
+}
+
+func TestReceivedRewardSenderNamesFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ReceivedRewardSenderNames: []string{"my-actor-2"},
+	}, trialParams)
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 1)
+	assert.Equal(t, int32(1), filteredTrialSample1.ActorSamples[0].ReceivedRewards[0].Sender)
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ReceivedRewardSenderNames: []string{"unknown-actor"},
+	}, trialParams)
+
+	filteredTrialSample1 = f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 0)
+}
+
+func TestFilterDoesntModifyTheSample(t *testing.T) {
+	originalTrialSample1 := proto.Clone(trialSample1).(*grpcapi.StoredTrialSample)
+
+	filters := []TrialSampleFilter{
+		{},
+		{ActorNames: []string{"my-actor-2"}},
+		{Fields: []grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION}},
+		{ReceivedRewardSenderIndices: []int32{1}, SentRewardReceiverIndices: []int32{-1}},
+		{SentMessageReceiverIndices: []int32{-1}, BroadcastMatchesAllActors: true},
+		{RequireActions: true, MaxPayloadsSize: pointy.Int(1)},
+		{RequireAllActions: true, ActorClasses: []string{"my-actor-class-2"}},
+		{DefaultActorClassFields: map[string][]grpcapi.StoredTrialSampleField{
+			"my-actor-class-1": {grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION},
+		}},
+	}
+	for _, filter := range filters {
+		f := NewAppliedTrialSampleFilter(filter, trialParams)
+		filteredTrialSample1 := f.Filter(trialSample1)
+		if filteredTrialSample1 != nil {
+			f.Filter(filteredTrialSample1)
+		}
+		assert.True(t, proto.Equal(originalTrialSample1, trialSample1))
+	}
+	// Byte-for-byte unchanged
+	serializedOriginal, err := proto.MarshalOptions{Deterministic: true}.Marshal(originalTrialSample1)
+	assert.NoError(t, err)
+	serialized, err := proto.MarshalOptions{Deterministic: true}.Marshal(trialSample1)
+	assert.NoError(t, err)
+	assert.Equal(t, serializedOriginal, serialized)
+}
+
+func TestSentRewardReceiversFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		SentRewardReceiverIndices: []int32{-1},
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentRewards, 0)
+	// Other rewards are kept
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 2)
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		SentRewardReceiverNames: []string{"my-actor-1"},
+	}, trialParams)
+
+	filteredTrialSample1 = f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentRewards, 1)
+	assert.Equal(t, int32(0), filteredTrialSample1.ActorSamples[1].SentRewards[0].Receiver)
+
+	twiceFilteredTrialSample1 := f.Filter(filteredTrialSample1)
+	assert.True(t, proto.Equal(twiceFilteredTrialSample1, filteredTrialSample1))
+}
+
+func TestRewardSendersAndReceiversFilters(t *testing.T) {
+	// Only keeping the rewards provided by actor 1
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ReceivedRewardSenderIndices: []int32{1},
+		SentRewardReceiverIndices:   []int32{1},
+		Fields: []grpcapi.StoredTrialSampleField{
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_REWARDS,
+		},
+	}, trialParams)
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 1)
+	assert.Equal(t, int32(1), filteredTrialSample1.ActorSamples[0].ReceivedRewards[0].Sender)
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentRewards, 0)
+	// The user data of the kept reward is kept
+	assert.NotEmpty(t, filteredTrialSample1.Payloads[2])
+}
+
+func TestSentMessageReceiversFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		SentMessageReceiverIndices: []int32{-1},
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	// Broadcast messages are selected when explicitly requested
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.NotNil(t, filteredTrialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentMessages, 1)
+	assert.Equal(t, int32(-1), filteredTrialSample1.ActorSamples[1].SentMessages[0].Receiver)
+	assert.NotEmpty(t, filteredTrialSample1.Payloads[5])
+
+	twiceFilteredTrialSample1 := f.Filter(filteredTrialSample1)
+	assert.True(t, proto.Equal(twiceFilteredTrialSample1, filteredTrialSample1))
+
+	// Samples without a message sent to the selected receivers are dropped
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		SentMessageReceiverNames: []string{"my-actor-1"},
+	}, trialParams)
+	assert.Nil(t, f.Filter(trialSample1))
+}
+
+func TestSentMessageReceiversFiltersPrunePayloads(t *testing.T) {
+	trialSample := proto.Clone(trialSample1).(*grpcapi.StoredTrialSample)
+	trialSample.ActorSamples[1].SentMessages = append(trialSample.ActorSamples[1].SentMessages, &grpcapi.StoredTrialActorSampleMessage{
+		Receiver: 0,
+		Payload:  uint32(len(trialSample.Payloads)),
+	})
+	trialSample.Payloads = append(trialSample.Payloads, []byte("a directed message payload"))
+
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		SentMessageReceiverNames: []string{"my-actor-1"},
+	}, trialParams)
+
+	filteredTrialSample := f.Filter(trialSample)
+	assert.NotNil(t, filteredTrialSample)
+	assert.Len(t, filteredTrialSample.ActorSamples[1].SentMessages, 1)
+	assert.Equal(t, int32(0), filteredTrialSample.ActorSamples[1].SentMessages[0].Receiver)
+	assert.NotEmpty(t, filteredTrialSample.Payloads[6])
+	// The payload of the dropped broadcast message is pruned
+	assert.Empty(t, filteredTrialSample.Payloads[5])
+
+	// Composes with the actors filter
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames:               []string{"my-actor-1"},
+		SentMessageReceiverNames: []string{"my-actor-1"},
+	}, trialParams)
+	assert.Nil(t, f.Filter(trialSample))
+}
+
+func TestBroadcastMatchesAllActorsFilters(t *testing.T) {
+	// By default, a broadcast message only matches an explicit -1 receiver
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		SentMessageReceiverNames: []string{"my-actor-2"},
+	}, trialParams)
+	assert.Nil(t, f.Filter(trialSample1))
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		SentMessageReceiverNames:  []string{"my-actor-2"},
+		BroadcastMatchesAllActors: true,
+	}, trialParams)
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.NotNil(t, filteredTrialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentMessages, 1)
+	assert.Equal(t, int32(-1), filteredTrialSample1.ActorSamples[1].SentMessages[0].Receiver)
+	assert.NotEmpty(t, filteredTrialSample1.Payloads[5])
+
+	// The same goes for broadcast rewards
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 2)
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ReceivedRewardSenderIndices: []int32{0},
+		BroadcastMatchesAllActors:   true,
+	}, trialParams)
+	filteredTrialSample1 = f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 1)
+	assert.Equal(t, int32(-1), filteredTrialSample1.ActorSamples[0].ReceivedRewards[0].Sender)
+}
+
+func TestTickRangeFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		FromTickID: pointy.Uint64(12),
+		ToTickID:   pointy.Uint64(12),
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	// Samples in the range are kept as is
+	assert.Same(t, trialSample1, f.Filter(trialSample1))
+	assert.Nil(t, f.Filter(trialSample2))
+	assert.False(t, f.IsPastTickRange(12))
+	assert.True(t, f.IsPastTickRange(13))
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		FromTickID: pointy.Uint64(13),
+	}, trialParams)
+	assert.Nil(t, f.Filter(trialSample1))
+	assert.NotNil(t, f.Filter(trialSample2))
+	assert.False(t, f.IsPastTickRange(1000))
+}
+
+func TestPayloadsSizeFilters(t *testing.T) {
+	// The payloads of trialSample1 are 95 bytes long
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		MinPayloadsSize: pointy.Int(95),
+		MaxPayloadsSize: pointy.Int(95),
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+	// Selected samples are kept as is
+	assert.Same(t, trialSample1, f.Filter(trialSample1))
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{MinPayloadsSize: pointy.Int(96)}, trialParams)
+	assert.Nil(t, f.Filter(trialSample1))
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{MaxPayloadsSize: pointy.Int(94)}, trialParams)
+	assert.Nil(t, f.Filter(trialSample1))
+
+	// Samples of various sizes
+	samples := []*grpcapi.StoredTrialSample{}
+	for _, payloadSize := range []int{0, 10, 1000, 100000} {
+		samples = append(samples, &grpcapi.StoredTrialSample{
+			ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Observation: pointy.Uint32(0)}},
+			Payloads:     [][]byte{make([]byte, payloadSize)},
+		})
+	}
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		MinPayloadsSize: pointy.Int(500),
+		MaxPayloadsSize: pointy.Int(50000),
+	}, trialParams)
+	selectedPayloadSizes := []int{}
+	for _, sample := range samples {
+		if filteredSample := f.Filter(sample); filteredSample != nil {
+			selectedPayloadSizes = append(selectedPayloadSizes, len(filteredSample.Payloads[0]))
+		}
+	}
+	assert.Equal(t, []int{1000}, selectedPayloadSizes)
+}
+
+func TestPayloadsSizeFiltersAfterFieldsAndActorsFilters(t *testing.T) {
+	// Only the 14 bytes of the observation remain
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		Fields:          []grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION},
+		MinPayloadsSize: pointy.Int(15),
+	}, trialParams)
+	assert.Nil(t, f.Filter(trialSample1))
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		Fields:          []grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION},
+		MinPayloadsSize: pointy.Int(14),
+	}, trialParams)
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.NotNil(t, filteredTrialSample1)
+	assert.Equal(t, []byte("an observation"), filteredTrialSample1.Payloads[0])
+
+	// Only the 9 bytes of the action and the 14 bytes of the observation remain
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames: []string{"my-actor-1"},
+		Fields: []grpcapi.StoredTrialSampleField{
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION,
+		},
+		MaxPayloadsSize: pointy.Int(23),
+	}, trialParams)
+	assert.NotNil(t, f.Filter(trialSample1))
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames:      []string{"my-actor-1"},
+		MaxPayloadsSize: pointy.Int(23),
+	}, trialParams)
+	assert.Nil(t, f.Filter(trialSample1))
+}
+
+func TestDefaultActorClassFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		DefaultActorClassFields: map[string][]grpcapi.StoredTrialSampleField{
+			"my-actor-class-2": {
+				grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION,
+				grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION,
+				grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_REWARD,
+				grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS,
+				grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_REWARDS,
+			},
+		},
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+
+	// Messages are stripped from the "my-actor-class-2" actor
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].ReceivedMessages, 0)
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentMessages, 0)
+	assert.Empty(t, filteredTrialSample1.Payloads[4])
+	assert.Empty(t, filteredTrialSample1.Payloads[5])
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentRewards, 1)
+	assert.NotNil(t, filteredTrialSample1.ActorSamples[1].Action)
+
+	// The other actor is untouched
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 2)
+
+	twiceFilteredTrialSample1 := f.Filter(filteredTrialSample1)
+	assert.True(t, proto.Equal(twiceFilteredTrialSample1, filteredTrialSample1))
+}
+
+func TestDefaultActorClassFiltersOverridden(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		Fields: []grpcapi.StoredTrialSampleField{
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_MESSAGES,
+		},
+		DefaultActorClassFields: map[string][]grpcapi.StoredTrialSampleField{
+			"my-actor-class-2": {
+				grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION,
+			},
+		},
+	}, trialParams)
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+
+	// The fields requested by the client take precedence
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].ReceivedMessages, 1)
+	assert.Nil(t, filteredTrialSample1.ActorSamples[1].Observation)
+}
+
+func TestParseDefaultActorClassFields(t *testing.T) {
+	actorClassFields, err := ParseDefaultActorClassFields("renderer=observation, action;player=STORED_TRIAL_SAMPLE_FIELD_REWARD;")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]grpcapi.StoredTrialSampleField{
+		"renderer": {grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION, grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION},
+		"player":   {grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_REWARD},
+	}, actorClassFields)
+
+	actorClassFields, err = ParseDefaultActorClassFields("")
+	assert.NoError(t, err)
+	assert.Len(t, actorClassFields, 0)
+
+	_, err = ParseDefaultActorClassFields("renderer")
+	assert.Error(t, err)
+
+	_, err = ParseDefaultActorClassFields("renderer=observation,unknown")
+	assert.Error(t, err)
+}
+
+func TestReceivedRewardsAggregation(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		Fields: []grpcapi.StoredTrialSampleField{
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS,
+		},
+		ReceivedRewardsAggregation: SumRewardAggregation,
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 1)
+	aggregatedReward := filteredTrialSample1.ActorSamples[0].ReceivedRewards[0]
+	assert.Equal(t, int32(-1), aggregatedReward.Sender)
+	assert.Equal(t, int32(0), aggregatedReward.Receiver)
+	assert.InDelta(t, 1., aggregatedReward.Reward, 1e-6)
+	assert.InDelta(t, 0.6, aggregatedReward.Confidence, 1e-6)
+	assert.Nil(t, aggregatedReward.UserData)
+	// The user data of the aggregated rewards is pruned
+	assert.Empty(t, filteredTrialSample1.Payloads[2])
+	// Observations and actions are kept
+	assert.Equal(t, trialSample1.Payloads[0], filteredTrialSample1.Payloads[0])
+	assert.Equal(t, trialSample1.Payloads[1], filteredTrialSample1.Payloads[1])
+	// Actors without received rewards don't get an aggregated one
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].ReceivedRewards, 0)
+
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ReceivedRewardsAggregation: MeanRewardAggregation,
+	}, trialParams)
+
+	filteredTrialSample1 = f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 1)
+	assert.InDelta(t, 0.5, filteredTrialSample1.ActorSamples[0].ReceivedRewards[0].Reward, 1e-6)
+	// The other fields are kept as is
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentRewards, 1)
+	assert.Equal(t, trialSample1.Payloads[2], filteredTrialSample1.Payloads[2])
+}
+
+func TestReceivedRewardsAggregationAfterSendersFilters(t *testing.T) {
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ReceivedRewardSenderIndices: []int32{1},
+		ReceivedRewardsAggregation:  MeanRewardAggregation,
+	}, trialParams)
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 1)
+	assert.InDelta(t, 0.5, filteredTrialSample1.ActorSamples[0].ReceivedRewards[0].Reward, 1e-6)
+	assert.InDelta(t, 0.2, filteredTrialSample1.ActorSamples[0].ReceivedRewards[0].Confidence, 1e-6)
+}
+
+func TestParseRewardAggregation(t *testing.T) {
+	aggregation, err := ParseRewardAggregation("")
+	assert.NoError(t, err)
+	assert.Equal(t, NoRewardAggregation, aggregation)
+
+	aggregation, err = ParseRewardAggregation("Sum")
+	assert.NoError(t, err)
+	assert.Equal(t, SumRewardAggregation, aggregation)
+
+	aggregation, err = ParseRewardAggregation("mean")
+	assert.NoError(t, err)
+	assert.Equal(t, MeanRewardAggregation, aggregation)
+
+	_, err = ParseRewardAggregation("max")
+	assert.Error(t, err)
+}
+
+func BenchmarkNoFilters(b *testing.B) {
+	b.ReportAllocs()
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{}, trialParams)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.Filter(trialSample1)
+	}
+}
+
+func BenchmarkFieldFilters(b *testing.B) {
+	b.ReportAllocs()
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		Fields: []grpcapi.StoredTrialSampleField{
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION,
+		},
+	}, trialParams)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.Filter(trialSample1)
+	}
+}
+
+func TestStripUserData(t *testing.T) {
+	originalTrialSample1 := proto.Clone(trialSample1)
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{StripUserData: true}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	// The rewards values and confidences survive, their user data is stripped
+	assert.Len(t, filteredTrialSample1.ActorSamples[0].ReceivedRewards, 2)
+	strippedReward := filteredTrialSample1.ActorSamples[0].ReceivedRewards[1]
+	assert.Equal(t, int32(1), strippedReward.Sender)
+	assert.Equal(t, float32(0.5), strippedReward.Reward)
+	assert.Equal(t, float32(0.2), strippedReward.Confidence)
+	assert.Nil(t, strippedReward.UserData)
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentRewards, 1)
+	assert.Nil(t, filteredTrialSample1.ActorSamples[1].SentRewards[0].UserData)
+	assert.Empty(t, filteredTrialSample1.Payloads[2])
+	// The messages are kept without their payloads
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].ReceivedMessages, 1)
+	assert.Len(t, filteredTrialSample1.ActorSamples[1].SentMessages, 1)
+	assert.Empty(t, filteredTrialSample1.Payloads[4])
+	assert.Empty(t, filteredTrialSample1.Payloads[5])
+	// Observations, actions and rewards are kept
+	assert.Equal(t, trialSample1.Payloads[0], filteredTrialSample1.Payloads[0])
+	assert.Equal(t, trialSample1.Payloads[3], filteredTrialSample1.Payloads[3])
+	assert.Equal(t, trialSample1.ActorSamples[0].Reward, filteredTrialSample1.ActorSamples[0].Reward)
+
+	// The filtered sample isn't modified
+	assert.True(t, proto.Equal(originalTrialSample1, trialSample1))
+}
+
+func TestCompactPayloads(t *testing.T) {
+	originalTrialSample1 := proto.Clone(trialSample1)
+	f := NewAppliedTrialSampleFilter(TrialSampleFilter{
+		ActorNames: []string{"my-actor-2"},
+		Fields: []grpcapi.StoredTrialSampleField{
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_ACTION,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_REWARDS,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_SENT_MESSAGES,
+		},
+		CompactPayloads: true,
+	}, trialParams)
+	assert.False(t, f.SelectsAll())
+
+	filteredTrialSample1 := f.Filter(trialSample1)
+	// Only the action, the reward user data and the sent message payload are kept, in their initial order
+	assert.Equal(t, [][]byte{[]byte("a reward user data"), []byte("another action"), []byte("another message payload")}, filteredTrialSample1.Payloads)
+	assert.Len(t, filteredTrialSample1.ActorSamples, 1)
+	actorSample := filteredTrialSample1.ActorSamples[0]
+	assert.Nil(t, actorSample.Observation)
+	assert.Equal(t, []byte("another action"), filteredTrialSample1.Payloads[*actorSample.Action])
+	assert.Equal(t, []byte("a reward user data"), filteredTrialSample1.Payloads[*actorSample.SentRewards[0].UserData])
+	assert.Equal(t, float32(0.5), actorSample.SentRewards[0].Reward)
+	assert.Equal(t, []byte("another message payload"), filteredTrialSample1.Payloads[actorSample.SentMessages[0].Payload])
+	assert.Len(t, actorSample.ReceivedMessages, 0)
+
+	// Every reference of every actor sample remains consistent
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{
+		Fields: []grpcapi.StoredTrialSampleField{
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_REWARDS,
+			grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_RECEIVED_MESSAGES,
+		},
+		CompactPayloads: true,
+	}, trialParams)
+	filteredTrialSample1 = f.Filter(trialSample1)
+	assert.Equal(t, [][]byte{[]byte("an observation"), []byte("a reward user data"), []byte("a message payload")}, filteredTrialSample1.Payloads)
+	for actorSampleIdx, actorSample := range filteredTrialSample1.ActorSamples {
+		originalActorSample := trialSample1.ActorSamples[actorSampleIdx]
+		assert.Equal(t, trialSample1.Payloads[*originalActorSample.Observation], filteredTrialSample1.Payloads[*actorSample.Observation])
+		for rewardIdx, reward := range actorSample.ReceivedRewards {
+			if reward.UserData != nil {
+				assert.Equal(t, trialSample1.Payloads[*originalActorSample.ReceivedRewards[rewardIdx].UserData], filteredTrialSample1.Payloads[*reward.UserData])
+			}
+		}
+		for messageIdx, message := range actorSample.ReceivedMessages {
+			assert.Equal(t, trialSample1.Payloads[originalActorSample.ReceivedMessages[messageIdx].Payload], filteredTrialSample1.Payloads[message.Payload])
+		}
+	}
+
+	// Without other filters, every payload is referenced and the sample is kept as is
+	f = NewAppliedTrialSampleFilter(TrialSampleFilter{CompactPayloads: true}, trialParams)
+	assert.True(t, proto.Equal(trialSample1, f.Filter(trialSample1)))
+
+	// The filtered sample isn't modified
+	assert.True(t, proto.Equal(originalTrialSample1, trialSample1))
+}
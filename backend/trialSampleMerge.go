@@ -0,0 +1,125 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"google.golang.org/protobuf/proto"
+)
+
+func offsetPayloadIdx(payloadIdx *uint32, offset uint32) *uint32 {
+	if payloadIdx == nil {
+		return nil
+	}
+	offsetPayloadIdx := *payloadIdx + offset
+	return &offsetPayloadIdx
+}
+
+func offsetRewards(rewards []*grpcapi.StoredTrialActorSampleReward, offset uint32) []*grpcapi.StoredTrialActorSampleReward {
+	offsetRewards := make([]*grpcapi.StoredTrialActorSampleReward, len(rewards))
+	for rewardIdx, reward := range rewards {
+		offsetRewards[rewardIdx] = &grpcapi.StoredTrialActorSampleReward{
+			Sender:     reward.Sender,
+			Receiver:   reward.Receiver,
+			Reward:     reward.Reward,
+			Confidence: reward.Confidence,
+			UserData:   offsetPayloadIdx(reward.UserData, offset),
+		}
+	}
+	return offsetRewards
+}
+
+func offsetMessages(messages []*grpcapi.StoredTrialActorSampleMessage, offset uint32) []*grpcapi.StoredTrialActorSampleMessage {
+	offsetMessages := make([]*grpcapi.StoredTrialActorSampleMessage, len(messages))
+	for messageIdx, message := range messages {
+		offsetMessages[messageIdx] = &grpcapi.StoredTrialActorSampleMessage{
+			Sender:   message.Sender,
+			Receiver: message.Receiver,
+			Payload:  message.Payload + offset,
+		}
+	}
+	return offsetMessages
+}
+
+// MergeTrialSamples merges the fields defined in a partial sample into a sample of the same tick.
+//
+// The merge follows a "last writer wins" policy at the field level:
+// - `UserId`, `Timestamp` and `State` are overwritten when they are set in the partial sample,
+// - actor samples are matched using their `Actor` index, actor samples only present in the partial sample are added,
+// - `Observation`, `Action` and `Reward` are overwritten when they are set in the partial actor sample,
+// - `ReceivedRewards`, `SentRewards`, `ReceivedMessages` and `SentMessages` are replaced when they are non-empty in the partial actor sample.
+//
+// The payloads of the partial sample are appended to the payloads of the sample and the payload indices of the
+// merged fields are offset accordingly. Payloads that are no longer referenced are kept in place, as a consequence
+// the payload indices of the sample are never invalidated by a merge.
+//
+// Neither `sample` nor `partialSample` are modified.
+func MergeTrialSamples(sample *grpcapi.StoredTrialSample, partialSample *grpcapi.StoredTrialSample) *grpcapi.StoredTrialSample {
+	mergedSample := proto.Clone(sample).(*grpcapi.StoredTrialSample)
+
+	if partialSample.UserId != "" {
+		mergedSample.UserId = partialSample.UserId
+	}
+	if partialSample.Timestamp != 0 {
+		mergedSample.Timestamp = partialSample.Timestamp
+	}
+	if partialSample.State != grpcapi.TrialState_UNKNOWN {
+		mergedSample.State = partialSample.State
+	}
+
+	payloadsOffset := uint32(len(mergedSample.Payloads))
+	for _, payload := range partialSample.Payloads {
+		mergedSample.Payloads = append(mergedSample.Payloads, append([]byte(nil), payload...))
+	}
+
+	for _, partialActorSample := range partialSample.ActorSamples {
+		var mergedActorSample *grpcapi.StoredTrialActorSample
+		for _, actorSample := range mergedSample.ActorSamples {
+			if actorSample.Actor == partialActorSample.Actor {
+				mergedActorSample = actorSample
+				break
+			}
+		}
+		if mergedActorSample == nil {
+			mergedActorSample = &grpcapi.StoredTrialActorSample{Actor: partialActorSample.Actor}
+			mergedSample.ActorSamples = append(mergedSample.ActorSamples, mergedActorSample)
+		}
+
+		if partialActorSample.Observation != nil {
+			mergedActorSample.Observation = offsetPayloadIdx(partialActorSample.Observation, payloadsOffset)
+		}
+		if partialActorSample.Action != nil {
+			mergedActorSample.Action = offsetPayloadIdx(partialActorSample.Action, payloadsOffset)
+		}
+		if partialActorSample.Reward != nil {
+			reward := *partialActorSample.Reward
+			mergedActorSample.Reward = &reward
+		}
+		if len(partialActorSample.ReceivedRewards) > 0 {
+			mergedActorSample.ReceivedRewards = offsetRewards(partialActorSample.ReceivedRewards, payloadsOffset)
+		}
+		if len(partialActorSample.SentRewards) > 0 {
+			mergedActorSample.SentRewards = offsetRewards(partialActorSample.SentRewards, payloadsOffset)
+		}
+		if len(partialActorSample.ReceivedMessages) > 0 {
+			mergedActorSample.ReceivedMessages = offsetMessages(partialActorSample.ReceivedMessages, payloadsOffset)
+		}
+		if len(partialActorSample.SentMessages) > 0 {
+			mergedActorSample.SentMessages = offsetMessages(partialActorSample.SentMessages, payloadsOffset)
+		}
+	}
+
+	return mergedSample
+}
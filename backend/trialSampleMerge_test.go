@@ -0,0 +1,143 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/openlyinc/pointy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMergeObservationThenReward(t *testing.T) {
+	observationSample := &grpcapi.StoredTrialSample{
+		TrialId:   "my-trial",
+		TickId:    3,
+		Timestamp: 1000,
+		State:     grpcapi.TrialState_RUNNING,
+		ActorSamples: []*grpcapi.StoredTrialActorSample{
+			{
+				Actor:       0,
+				Observation: pointy.Uint32(0),
+			},
+		},
+		Payloads: [][]byte{
+			[]byte("an observation"),
+		},
+	}
+	observationSampleCopy := proto.Clone(observationSample)
+
+	rewardSample := &grpcapi.StoredTrialSample{
+		TrialId: "my-trial",
+		TickId:  3,
+		ActorSamples: []*grpcapi.StoredTrialActorSample{
+			{
+				Actor:  0,
+				Reward: pointy.Float32(0.5),
+				ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+					{
+						Sender:     1,
+						Reward:     0.5,
+						Confidence: 1,
+						UserData:   pointy.Uint32(0),
+					},
+				},
+			},
+			{
+				Actor: 1,
+				SentRewards: []*grpcapi.StoredTrialActorSampleReward{
+					{
+						Receiver:   0,
+						Reward:     0.5,
+						Confidence: 1,
+						UserData:   pointy.Uint32(0),
+					},
+				},
+			},
+		},
+		Payloads: [][]byte{
+			[]byte("a reward user data"),
+		},
+	}
+	rewardSampleCopy := proto.Clone(rewardSample)
+
+	mergedSample := MergeTrialSamples(observationSample, rewardSample)
+
+	// Inputs are left untouched
+	assert.True(t, proto.Equal(observationSampleCopy, observationSample))
+	assert.True(t, proto.Equal(rewardSampleCopy, rewardSample))
+
+	assert.Equal(t, uint64(3), mergedSample.TickId)
+	assert.Equal(t, uint64(1000), mergedSample.Timestamp)
+	assert.Equal(t, grpcapi.TrialState_RUNNING, mergedSample.State)
+	assert.Len(t, mergedSample.Payloads, 2)
+	assert.Len(t, mergedSample.ActorSamples, 2)
+
+	actorSample0 := mergedSample.ActorSamples[0]
+	assert.Equal(t, uint32(0), actorSample0.Actor)
+	assert.Equal(t, []byte("an observation"), mergedSample.Payloads[*actorSample0.Observation])
+	assert.Equal(t, float32(0.5), *actorSample0.Reward)
+	assert.Len(t, actorSample0.ReceivedRewards, 1)
+	assert.Equal(t, []byte("a reward user data"), mergedSample.Payloads[*actorSample0.ReceivedRewards[0].UserData])
+
+	actorSample1 := mergedSample.ActorSamples[1]
+	assert.Equal(t, uint32(1), actorSample1.Actor)
+	assert.Nil(t, actorSample1.Observation)
+	assert.Len(t, actorSample1.SentRewards, 1)
+	assert.Equal(t, []byte("a reward user data"), mergedSample.Payloads[*actorSample1.SentRewards[0].UserData])
+}
+
+func TestMergeLastWriterWins(t *testing.T) {
+	firstSample := &grpcapi.StoredTrialSample{
+		TickId: 3,
+		State:  grpcapi.TrialState_RUNNING,
+		ActorSamples: []*grpcapi.StoredTrialActorSample{
+			{
+				Actor:       0,
+				Observation: pointy.Uint32(0),
+				Action:      pointy.Uint32(1),
+			},
+		},
+		Payloads: [][]byte{
+			[]byte("an observation"),
+			[]byte("an action"),
+		},
+	}
+
+	secondSample := &grpcapi.StoredTrialSample{
+		TickId: 3,
+		State:  grpcapi.TrialState_ENDED,
+		ActorSamples: []*grpcapi.StoredTrialActorSample{
+			{
+				Actor:  0,
+				Action: pointy.Uint32(0),
+			},
+		},
+		Payloads: [][]byte{
+			[]byte("another action"),
+		},
+	}
+
+	mergedSample := MergeTrialSamples(firstSample, secondSample)
+
+	assert.Equal(t, grpcapi.TrialState_ENDED, mergedSample.State)
+	assert.Len(t, mergedSample.ActorSamples, 1)
+	assert.Equal(t, []byte("an observation"), mergedSample.Payloads[*mergedSample.ActorSamples[0].Observation])
+	assert.Equal(t, []byte("another action"), mergedSample.Payloads[*mergedSample.ActorSamples[0].Action])
+	// The overwritten action payload is kept in place
+	assert.Equal(t, []byte("an action"), mergedSample.Payloads[1])
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TrialTagsMatch defines how the tags of a trial are matched against the tags of a query
+type TrialTagsMatch int
+
+const (
+	// SubsetTrialTagsMatch matches the trials having every queried tag, they can have other tags
+	SubsetTrialTagsMatch TrialTagsMatch = iota
+	// ExactTrialTagsMatch matches the trials having exactly the queried tags
+	ExactTrialTagsMatch
+)
+
+// ParseTrialTagsMatch parses a trial tags match expressed as "subset" or "exact", an empty string is "subset"
+func ParseTrialTagsMatch(matchName string) (TrialTagsMatch, error) {
+	switch strings.ToLower(strings.TrimSpace(matchName)) {
+	case "", "subset":
+		return SubsetTrialTagsMatch, nil
+	case "exact":
+		return ExactTrialTagsMatch, nil
+	default:
+		return SubsetTrialTagsMatch, fmt.Errorf("unknown trial tags match %q, expecting one of [subset exact]", matchName)
+	}
+}
+
+// ParseTrialTags parses tags expressed as `key=value` definitions, e.g. "experiment=foo", into a map.
+//
+// Keys can't be empty, values can. Defining the same key twice with different values is an error.
+func ParseTrialTags(definitions []string) (map[string]string, error) {
+	tags := make(map[string]string, len(definitions))
+	for _, definition := range definitions {
+		separatorIdx := strings.Index(definition, "=")
+		if separatorIdx < 0 {
+			return nil, fmt.Errorf("invalid trial tag %q, expecting `key=value`", definition)
+		}
+		key := strings.TrimSpace(definition[:separatorIdx])
+		value := strings.TrimSpace(definition[separatorIdx+1:])
+		if key == "" {
+			return nil, fmt.Errorf("invalid trial tag %q, the key is empty", definition)
+		}
+		if definedValue, defined := tags[key]; defined && definedValue != value {
+			return nil, fmt.Errorf("trial tag %q is defined twice, as %q and %q", key, definedValue, value)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// MatchesTrialTags returns true if the tags of a trial match the queried tags, an empty query matches every trial
+func MatchesTrialTags(tags map[string]string, query map[string]string, match TrialTagsMatch) bool {
+	if len(query) == 0 {
+		return true
+	}
+	if match == ExactTrialTagsMatch {
+		return EqualTrialTags(tags, query)
+	}
+	return containsTrialTags(tags, query)
+}
+
+// EqualTrialTags returns true if both trials have the same tags, nil and empty tags are equal
+func EqualTrialTags(a map[string]string, b map[string]string) bool {
+	return len(a) == len(b) && containsTrialTags(a, b)
+}
+
+func containsTrialTags(tags map[string]string, contained map[string]string) bool {
+	for key, value := range contained {
+		if tagValue, found := tags[key]; !found || tagValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// CopyTrialTags returns a copy of the given tags, nil when there are none
+func CopyTrialTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	copiedTags := make(map[string]string, len(tags))
+	for key, value := range tags {
+		copiedTags[key] = value
+	}
+	return copiedTags
+}
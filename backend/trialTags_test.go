@@ -0,0 +1,80 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTrialTags(t *testing.T) {
+	tags, err := ParseTrialTags([]string{"experiment=foo", " seed = 42 ", "note=a=b", "empty="})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"experiment": "foo", "seed": "42", "note": "a=b", "empty": ""}, tags)
+
+	tags, err = ParseTrialTags([]string{})
+	assert.NoError(t, err)
+	assert.Len(t, tags, 0)
+
+	// Defining the same tag twice is fine as long as the values are equal
+	_, err = ParseTrialTags([]string{"seed=42", "seed=42"})
+	assert.NoError(t, err)
+	_, err = ParseTrialTags([]string{"seed=42", "seed=43"})
+	assert.Error(t, err)
+
+	_, err = ParseTrialTags([]string{"experiment"})
+	assert.Error(t, err)
+	_, err = ParseTrialTags([]string{"=foo"})
+	assert.Error(t, err)
+}
+
+func TestParseTrialTagsMatch(t *testing.T) {
+	match, err := ParseTrialTagsMatch("")
+	assert.NoError(t, err)
+	assert.Equal(t, SubsetTrialTagsMatch, match)
+
+	match, err = ParseTrialTagsMatch("Exact")
+	assert.NoError(t, err)
+	assert.Equal(t, ExactTrialTagsMatch, match)
+
+	_, err = ParseTrialTagsMatch("any")
+	assert.Error(t, err)
+}
+
+func TestMatchesTrialTags(t *testing.T) {
+	tags := map[string]string{"experiment": "foo", "seed": "42"}
+
+	// Single tag
+	assert.True(t, MatchesTrialTags(tags, map[string]string{"experiment": "foo"}, SubsetTrialTagsMatch))
+	assert.False(t, MatchesTrialTags(tags, map[string]string{"experiment": "bar"}, SubsetTrialTagsMatch))
+	assert.False(t, MatchesTrialTags(tags, map[string]string{"algorithm": "foo"}, SubsetTrialTagsMatch))
+
+	// Every queried tag must match
+	assert.True(t, MatchesTrialTags(tags, map[string]string{"experiment": "foo", "seed": "42"}, SubsetTrialTagsMatch))
+	assert.False(t, MatchesTrialTags(tags, map[string]string{"experiment": "foo", "seed": "43"}, SubsetTrialTagsMatch))
+
+	// Exact matches require the same tag set
+	assert.True(t, MatchesTrialTags(tags, map[string]string{"experiment": "foo", "seed": "42"}, ExactTrialTagsMatch))
+	assert.False(t, MatchesTrialTags(tags, map[string]string{"experiment": "foo"}, ExactTrialTagsMatch))
+
+	// An empty query matches everything
+	assert.True(t, MatchesTrialTags(nil, map[string]string{}, SubsetTrialTagsMatch))
+	assert.True(t, MatchesTrialTags(tags, nil, ExactTrialTagsMatch))
+	assert.False(t, MatchesTrialTags(nil, map[string]string{"experiment": "foo"}, SubsetTrialTagsMatch))
+
+	assert.True(t, EqualTrialTags(nil, map[string]string{}))
+	assert.False(t, EqualTrialTags(nil, tags))
+}
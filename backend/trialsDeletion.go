@@ -0,0 +1,99 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// TrialsDeletionFilter selects the trials to delete, a trial is selected when it matches every defined criterion.
+//
+// A filter without any criterion selects no trial.
+type TrialsDeletionFilter struct {
+	TrialIDs      []string // Ids of the trials to delete, nil matches any trial
+	TrialIDPrefix string   // Prefix of the id of the trials to delete, "" matches any trial
+	// Only the trials created before this time are deleted, the zero time matches any trial. Trials without creation
+	// timestamp never match a non-zero time.
+	CreatedBefore time.Time
+	// Fail with an `UnknownTrialError` if one of `TrialIDs` doesn't exist, they are skipped otherwise
+	Strict bool
+}
+
+func (f TrialsDeletionFilter) selectsNothing() bool {
+	return f.TrialIDs == nil && f.TrialIDPrefix == "" && f.CreatedBefore.IsZero()
+}
+
+func (f TrialsDeletionFilter) selects(trialInfo *TrialInfo) bool {
+	if !f.CreatedBefore.IsZero() && (trialInfo.CreatedAt.IsZero() || !trialInfo.CreatedAt.Before(f.CreatedBefore)) {
+		return false
+	}
+	return strings.HasPrefix(trialInfo.TrialID, f.TrialIDPrefix)
+}
+
+// TrialsDeletionResult represents what was deleted by `DeleteTrialsMatching`
+type TrialsDeletionResult struct {
+	DeletedTrialIDs     []string
+	DeletedSamplesCount int // Number of stored samples of the deleted trials
+}
+
+// DeleteTrialsMatching deletes every trial selected by the given filter, along with its samples.
+//
+// The trials are deleted in a single call to `Backend.DeleteTrials`, atomically if the backend supports it. The
+// deleted samples are counted when the trials are selected, samples added in the meantime are deleted as well.
+func DeleteTrialsMatching(ctx context.Context, b Backend, filter TrialsDeletionFilter) (TrialsDeletionResult, error) {
+	result := TrialsDeletionResult{DeletedTrialIDs: []string{}}
+	if filter.selectsNothing() || (filter.TrialIDs != nil && len(filter.TrialIDs) == 0) {
+		return result, nil
+	}
+
+	if filter.Strict && filter.TrialIDs != nil {
+		exist, err := b.TrialsExist(ctx, filter.TrialIDs)
+		if err != nil {
+			return result, err
+		}
+		for idx, trialID := range filter.TrialIDs {
+			if !exist[idx] {
+				return result, &UnknownTrialError{TrialID: trialID}
+			}
+		}
+	}
+
+	// Without ids the trials are listed
+	trialsInfo, err := b.RetrieveTrials(ctx, filter.TrialIDs, -1, -1)
+	if err != nil {
+		return result, err
+	}
+	deletedSamplesCount := 0
+	deletedTrialIDs := []string{}
+	for _, trialInfo := range trialsInfo.TrialInfos {
+		if filter.selects(trialInfo) {
+			deletedTrialIDs = append(deletedTrialIDs, trialInfo.TrialID)
+			deletedSamplesCount += trialInfo.StoredSamplesCount
+		}
+	}
+	if len(deletedTrialIDs) == 0 {
+		return result, nil
+	}
+
+	err = b.DeleteTrials(ctx, deletedTrialIDs)
+	if err != nil {
+		return result, err
+	}
+	result.DeletedTrialIDs = deletedTrialIDs
+	result.DeletedSamplesCount = deletedSamplesCount
+	return result, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// TrialsMergeOptions represents the configuration of a trials merge
+type TrialsMergeOptions struct {
+	// Offset added to the tick ids of the source samples. When nil, the source samples are renumbered to directly
+	// follow the last stored sample of the destination trial.
+	TickIDOffset *uint64
+	DeleteSource bool // Delete the source trial once its samples are merged
+}
+
+// TrialsMergeResult represents what was done by `MergeTrials`
+type TrialsMergeResult struct {
+	MergedSamplesCount int
+	// Difference between the tick id of the merged samples in the destination trial and in the source trial
+	TickIDOffset int64
+}
+
+// IncompatibleTrialParamsError is raised when merging trials whose actors differ, the actor indices of their samples
+// referring to different actors
+type IncompatibleTrialParamsError struct {
+	SourceTrialID      string
+	DestinationTrialID string
+	Differences        []string // Description of each difference, e.g. "actors[1] is named \"bob\" instead of \"alice\""
+}
+
+func (e *IncompatibleTrialParamsError) Error() string {
+	return fmt.Sprintf("the params of trial %q are incompatible with the params of trial %q: %s", e.SourceTrialID, e.DestinationTrialID, strings.Join(e.Differences, ", "))
+}
+
+// diffActorParams describes how the actors of `source` differ from the actors of `destination`, actors are compatible
+// when they have the same name and class at the same index
+func diffActorParams(source *grpcapi.TrialParams, destination *grpcapi.TrialParams) []string {
+	differences := []string{}
+	sourceActors := source.GetActors()
+	destinationActors := destination.GetActors()
+	if len(sourceActors) != len(destinationActors) {
+		return append(differences, fmt.Sprintf("%d actors instead of %d", len(sourceActors), len(destinationActors)))
+	}
+	for actorIdx, sourceActor := range sourceActors {
+		destinationActor := destinationActors[actorIdx]
+		if sourceActor.GetName() != destinationActor.GetName() {
+			differences = append(differences, fmt.Sprintf("actors[%d] is named %q instead of %q", actorIdx, sourceActor.GetName(), destinationActor.GetName()))
+		}
+		if sourceActor.GetActorClass() != destinationActor.GetActorClass() {
+			differences = append(differences, fmt.Sprintf("actors[%d] is of class %q instead of %q", actorIdx, sourceActor.GetActorClass(), destinationActor.GetActorClass()))
+		}
+	}
+	return differences
+}
+
+func retrieveTrialInfo(ctx context.Context, b Backend, trialID string) (*TrialInfo, error) {
+	trialsInfo, err := b.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(trialsInfo.TrialInfos) == 0 {
+		return nil, &UnknownTrialError{TrialID: trialID}
+	}
+	return trialsInfo.TrialInfos[0], nil
+}
+
+// MergeTrials appends the currently stored samples of the source trial to the destination trial, e.g. to join an
+// episode split across two trials.
+//
+// The trials need compatible actors, see `IncompatibleTrialParamsError`, and the merged samples need to follow the
+// stored samples of the destination trial, otherwise an error matching `ErrSampleOutOfOrder` is raised. Nothing is
+// merged in both cases. Samples are added by chunks as they are observed, the source trial is left untouched when the
+// merge fails midway.
+func MergeTrials(ctx context.Context, b Backend, sourceTrialID string, destinationTrialID string, options TrialsMergeOptions) (TrialsMergeResult, error) {
+	result := TrialsMergeResult{}
+	if sourceTrialID == destinationTrialID {
+		return result, fmt.Errorf("unable to merge trial %q into itself", sourceTrialID)
+	}
+
+	sourceInfo, err := retrieveTrialInfo(ctx, b, sourceTrialID)
+	if err != nil {
+		return result, err
+	}
+	destinationInfo, err := retrieveTrialInfo(ctx, b, destinationTrialID)
+	if err != nil {
+		return result, err
+	}
+	trialsParams, err := b.GetTrialParams(ctx, []string{sourceTrialID, destinationTrialID})
+	if err != nil {
+		return result, err
+	}
+	differences := diffActorParams(trialsParams[0].Params, trialsParams[1].Params)
+	if len(differences) > 0 {
+		return result, &IncompatibleTrialParamsError{
+			SourceTrialID:      sourceTrialID,
+			DestinationTrialID: destinationTrialID,
+			Differences:        differences,
+		}
+	}
+
+	// Tick ids being unsigned, a negative offset wraps around
+	tickIDOffset := uint64(0)
+	if sourceInfo.StoredSamplesCount > 0 {
+		if options.TickIDOffset != nil {
+			if sourceInfo.MaxTickID > math.MaxUint64-*options.TickIDOffset {
+				return result, fmt.Errorf("offsetting the tick ids of trial %q by %d overflows", sourceTrialID, *options.TickIDOffset)
+			}
+			tickIDOffset = *options.TickIDOffset
+		} else {
+			firstTickID := uint64(0)
+			if destinationInfo.StoredSamplesCount > 0 {
+				firstTickID = destinationInfo.MaxTickID + 1
+			}
+			tickIDOffset = firstTickID - sourceInfo.MinTickID
+		}
+		firstTickID := sourceInfo.MinTickID + tickIDOffset
+		if destinationInfo.StoredSamplesCount > 0 && firstTickID <= destinationInfo.MaxTickID {
+			return result, fmt.Errorf(
+				"%w, the merged samples of trial %q would start at tick %d, trial %q already stores samples up to tick %d",
+				ErrSampleOutOfOrder,
+				sourceTrialID,
+				firstTickID,
+				destinationTrialID,
+				destinationInfo.MaxTickID,
+			)
+		}
+	}
+
+	samplesChunk := make([]*grpcapi.StoredTrialSample, 0, importTrialSamplesChunkSize)
+	mergedSamplesCount, err := forEachStoredSample(ctx, b, sourceTrialID, sourceInfo.StoredSamplesCount, nil, func(sample *grpcapi.StoredTrialSample) error {
+		// Observed samples can be shared with the backend storage
+		mergedSample := proto.Clone(sample).(*grpcapi.StoredTrialSample)
+		mergedSample.TrialId = destinationTrialID
+		mergedSample.TickId = sample.TickId + tickIDOffset
+		samplesChunk = append(samplesChunk, mergedSample)
+		if len(samplesChunk) < importTrialSamplesChunkSize {
+			return nil
+		}
+		err := b.AddSamples(ctx, samplesChunk)
+		samplesChunk = make([]*grpcapi.StoredTrialSample, 0, importTrialSamplesChunkSize)
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+	if len(samplesChunk) > 0 {
+		err = b.AddSamples(ctx, samplesChunk)
+		if err != nil {
+			return result, err
+		}
+	}
+	result.MergedSamplesCount = mergedSamplesCount
+	result.TickIDOffset = int64(tickIDOffset)
+
+	if options.DeleteSource {
+		err = b.DeleteTrials(ctx, []string{sourceTrialID})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
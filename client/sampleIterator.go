@@ -0,0 +1,220 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// SampleIteratorOptions represents the configuration of a sample iterator
+type SampleIteratorOptions struct {
+	// Header metadata of the retrieval as key/value pairs, e.g. "require-actions", "true"
+	Headers []string
+	// Number of consecutive times a retrieval interrupted by an `UNAVAILABLE` error is resumed, 0 disables it
+	MaxReconnections int
+	// Delay before the first reconnection, doubled for each following consecutive one
+	ReconnectionDelay time.Duration
+}
+
+var DefaultSampleIteratorOptions = SampleIteratorOptions{
+	MaxReconnections:  3,
+	ReconnectionDelay: 100 * time.Millisecond,
+}
+
+// unresumableHeaders lists the header metadata of the retrievals that can't be resumed using a continuation token
+var unresumableHeaders = []string{"reverse", "windows-count", "random-samples-count", "latest-sample"}
+
+// SampleIterator iterates over the samples retrieved by a `RetrieveSamples` call.
+//
+// When the call is interrupted, e.g. by a server restart, it is resumed right after the last iterated sample using a
+// continuation token, the iterated samples are never repeated. Retrievals that can't be resumed, e.g. reverse ones,
+// fail instead.
+type SampleIterator struct {
+	ctx                context.Context
+	cancel             context.CancelFunc
+	client             grpcapi.TrialDatastoreSPClient
+	req                *grpcapi.RetrieveSamplesRequest
+	options            SampleIteratorOptions
+	resumable          bool
+	stream             grpcapi.TrialDatastoreSP_RetrieveSamplesClient
+	token              *utils.ContinuationToken // Last iterated sample of each trial, along with the resumed ones
+	reconnectionsCount int                      // Number of consecutive reconnections without iterated sample
+	err                error
+	done               bool
+}
+
+// CreateSampleIterator creates an iterator over the samples retrieved using the given request and options.
+//
+// The retrieval starts with the first call to `Next`, it is canceled when the given context is done or the iterator
+// is closed.
+func CreateSampleIterator(ctx context.Context, client grpcapi.TrialDatastoreSPClient, req *grpcapi.RetrieveSamplesRequest, options SampleIteratorOptions) *SampleIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &SampleIterator{
+		ctx:       ctx,
+		cancel:    cancel,
+		client:    client,
+		req:       req,
+		resumable: true,
+		token:     utils.NewContinuationToken(),
+	}
+	if len(options.Headers)%2 != 0 {
+		it.fail(fmt.Errorf("odd number of header metadata key/value pairs (%d)", len(options.Headers)))
+		return it
+	}
+	it.options = options
+	it.options.Headers = []string{}
+	for idx := 0; idx < len(options.Headers); idx += 2 {
+		key, value := options.Headers[idx], options.Headers[idx+1]
+		for _, unresumableHeader := range unresumableHeaders {
+			if key == unresumableHeader {
+				it.resumable = false
+			}
+		}
+		if key == "continuation-token" {
+			// The retrieval is resumed, the resumed samples are accounted for in the following reconnections
+			token, err := utils.ParseContinuationToken(value)
+			if err != nil {
+				it.fail(err)
+				return it
+			}
+			it.token = token
+			continue
+		}
+		it.options.Headers = append(it.options.Headers, key, value)
+	}
+	return it
+}
+
+// Next retrieves the next sample, it returns false once every sample is retrieved or when the retrieval fails
+func (it *SampleIterator) Next() (*grpcapi.StoredTrialSample, bool) {
+	for !it.done {
+		if it.stream == nil {
+			stream, err := it.client.RetrieveSamples(it.retrievalContext(), it.req)
+			if err != nil {
+				it.reconnectOrFail(err)
+				continue
+			}
+			it.stream = stream
+		}
+		reply, err := it.stream.Recv()
+		if err == io.EOF {
+			it.done = true
+			break
+		}
+		if err != nil {
+			it.reconnectOrFail(err)
+			continue
+		}
+		sample := reply.GetTrialSample()
+		it.token.Advance(sample)
+		it.reconnectionsCount = 0
+		return sample, true
+	}
+	return nil, false
+}
+
+// Err returns the error that ended the iteration, nil if every sample was retrieved.
+//
+// Errors reported by the server match, using `errors.Is`, the backend sentinel errors corresponding to their status
+// code, e.g. `backend.ErrTrialNotFound`. The cancellation of the retrieval results in the context's error.
+func (it *SampleIterator) Err() error {
+	return it.err
+}
+
+// Close cancels the ongoing retrieval, if any, and releases its resources
+func (it *SampleIterator) Close() {
+	it.cancel()
+	if !it.done {
+		it.done = true
+		it.err = context.Canceled
+	}
+}
+
+// retrievalContext returns the context of a `RetrieveSamples` call holding its header metadata
+func (it *SampleIterator) retrievalContext() context.Context {
+	headers := it.options.Headers
+	if len(it.token.LastTickIDs) > 0 {
+		headers = append(headers[:len(headers):len(headers)], "continuation-token", it.token.String())
+	}
+	return metadata.AppendToOutgoingContext(it.ctx, headers...)
+}
+
+// reconnectOrFail prepares the resumption of the retrieval after the given error, or ends the iteration with it
+func (it *SampleIterator) reconnectOrFail(err error) {
+	it.stream = nil
+	if it.ctx.Err() != nil {
+		it.fail(it.ctx.Err())
+		return
+	}
+	if status.Code(err) != codes.Unavailable || !it.resumable || it.reconnectionsCount >= it.options.MaxReconnections {
+		it.fail(err)
+		return
+	}
+	delay := it.options.ReconnectionDelay << it.reconnectionsCount
+	it.reconnectionsCount++
+	select {
+	case <-it.ctx.Done():
+		it.fail(it.ctx.Err())
+	case <-time.After(delay):
+	}
+}
+
+func (it *SampleIterator) fail(err error) {
+	it.done = true
+	if _, isStatus := status.FromError(err); isStatus && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		err = &StatusError{Status: status.Convert(err)}
+	}
+	it.err = err
+}
+
+// StatusError is an error reported by the server, it matches the backend sentinel error corresponding to its status
+// code, if any
+type StatusError struct {
+	Status *status.Status
+}
+
+func (e *StatusError) Error() string {
+	return e.Status.Err().Error()
+}
+
+// GRPCStatus lets `status.FromError` and `status.Code` handle the error like the original status error
+func (e *StatusError) GRPCStatus() *status.Status {
+	return e.Status
+}
+
+// Is matches the backend sentinel errors mapped to the status code of the error by the server
+func (e *StatusError) Is(target error) bool {
+	switch e.Status.Code() {
+	case codes.NotFound:
+		return target == backend.ErrTrialNotFound
+	case codes.AlreadyExists:
+		return target == backend.ErrTrialAlreadyExists
+	case codes.Aborted:
+		return target == backend.ErrTrialDeleted
+	default:
+		return false
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/grpcservers"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// interruptedServerStream fails the sent messages once `remainingSends` are sent, as if the connection was lost
+type interruptedServerStream struct {
+	grpc.ServerStream
+	remainingSends int
+	interrupted    bool
+}
+
+func (s *interruptedServerStream) SendMsg(m interface{}) error {
+	if s.remainingSends == 0 {
+		s.interrupted = true
+		return status.Error(codes.Unavailable, "connection lost")
+	}
+	s.remainingSends--
+	return s.ServerStream.SendMsg(m)
+}
+
+type sampleIteratorTestFixture struct {
+	backend backend.Backend
+	client  grpcapi.TrialDatastoreSPClient
+	destroy func()
+	// Number of samples sent by each call before it is interrupted, calls beyond its length aren't interrupted
+	interruptions []int
+	callsCount    int
+	mutex         sync.Mutex
+}
+
+func createSampleIteratorTestFixture(t *testing.T) *sampleIteratorTestFixture {
+	fxt := &sampleIteratorTestFixture{}
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		fxt.mutex.Lock()
+		callIdx := fxt.callsCount
+		fxt.callsCount++
+		interruptions := fxt.interruptions
+		fxt.mutex.Unlock()
+		if callIdx >= len(interruptions) {
+			return handler(srv, stream)
+		}
+		interruptedStream := &interruptedServerStream{ServerStream: stream, remainingSends: interruptions[callIdx]}
+		err := handler(srv, interruptedStream)
+		if interruptedStream.interrupted {
+			return status.Error(codes.Unavailable, "connection lost")
+		}
+		return err
+	}))
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	assert.NoError(t, grpcservers.RegisterTrialDatastoreServer(server, b))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	connection, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+
+	fxt.backend = b
+	fxt.client = grpcapi.NewTrialDatastoreSPClient(connection)
+	fxt.destroy = func() {
+		connection.Close()
+		server.Stop()
+		b.Destroy()
+	}
+	return fxt
+}
+
+func (fxt *sampleIteratorTestFixture) addTrial(t *testing.T, trialID string, samplesCount int, end bool) {
+	err := fxt.backend.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: trialID, Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+	samples := make([]*grpcapi.StoredTrialSample, samplesCount)
+	for tickID := range samples {
+		samples[tickID] = &grpcapi.StoredTrialSample{TrialId: trialID, TickId: uint64(tickID), State: grpcapi.TrialState_RUNNING}
+	}
+	if end {
+		samples[samplesCount-1].State = grpcapi.TrialState_ENDED
+	}
+	err = fxt.backend.AddSamples(context.Background(), samples)
+	assert.NoError(t, err)
+}
+
+// interrupt sets the interruptions of the following calls and resets the calls count
+func (fxt *sampleIteratorTestFixture) interrupt(interruptions ...int) {
+	fxt.mutex.Lock()
+	defer fxt.mutex.Unlock()
+	fxt.interruptions = interruptions
+	fxt.callsCount = 0
+}
+
+func (fxt *sampleIteratorTestFixture) retrievalsCount() int {
+	fxt.mutex.Lock()
+	defer fxt.mutex.Unlock()
+	return fxt.callsCount
+}
+
+func iterateTickIDs(it *SampleIterator) []uint64 {
+	tickIDs := []uint64{}
+	for sample, ok := it.Next(); ok; sample, ok = it.Next() {
+		tickIDs = append(tickIDs, sample.TickId)
+	}
+	return tickIDs
+}
+
+func TestSampleIterator(t *testing.T) {
+	fxt := createSampleIteratorTestFixture(t)
+	defer fxt.destroy()
+	fxt.addTrial(t, "my-trial", 10, true)
+
+	it := CreateSampleIterator(context.Background(), fxt.client, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}}, DefaultSampleIteratorOptions)
+	defer it.Close()
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, iterateTickIDs(it))
+	assert.NoError(t, it.Err())
+
+	// Once over, the iteration stays over
+	_, ok := it.Next()
+	assert.False(t, ok)
+	assert.NoError(t, it.Err())
+}
+
+func TestSampleIteratorHeaders(t *testing.T) {
+	fxt := createSampleIteratorTestFixture(t)
+	defer fxt.destroy()
+	fxt.addTrial(t, "my-trial", 10, true)
+
+	options := DefaultSampleIteratorOptions
+	options.Headers = []string{"from-tick-id", "2", "to-tick-id", "7", "downsampling-factor", "2"}
+	it := CreateSampleIterator(context.Background(), fxt.client, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}}, options)
+	defer it.Close()
+	assert.Equal(t, []uint64{2, 4, 6, 7}, iterateTickIDs(it))
+	assert.NoError(t, it.Err())
+
+	options.Headers = []string{"from-tick-id"}
+	it = CreateSampleIterator(context.Background(), fxt.client, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}}, options)
+	defer it.Close()
+	assert.Empty(t, iterateTickIDs(it))
+	assert.Error(t, it.Err())
+}
+
+func TestSampleIteratorReconnection(t *testing.T) {
+	fxt := createSampleIteratorTestFixture(t)
+	defer fxt.destroy()
+	fxt.addTrial(t, "my-trial", 10, true)
+	fxt.interrupt(3, 0, 4)
+
+	options := DefaultSampleIteratorOptions
+	options.ReconnectionDelay = time.Millisecond
+	it := CreateSampleIterator(context.Background(), fxt.client, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}}, options)
+	defer it.Close()
+	// The retrieval is resumed after the last iterated sample each time
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, iterateTickIDs(it))
+	assert.NoError(t, it.Err())
+	assert.Equal(t, 4, fxt.retrievalsCount())
+
+	// Resuming a retrieval using a continuation token
+	options.Headers = []string{"continuation-token", "eyJsYXN0X3RpY2tfaWRzIjp7Im15LXRyaWFsIjo1fX0"} // {"last_tick_ids":{"my-trial":5}}
+	fxt.interrupt(4, 1)
+	it = CreateSampleIterator(context.Background(), fxt.client, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}}, options)
+	defer it.Close()
+	assert.Equal(t, []uint64{6, 7, 8, 9}, iterateTickIDs(it))
+	assert.NoError(t, it.Err())
+}
+
+func TestSampleIteratorReconnectionFailure(t *testing.T) {
+	fxt := createSampleIteratorTestFixture(t)
+	defer fxt.destroy()
+	fxt.addTrial(t, "my-trial", 10, true)
+	fxt.interrupt(3, 0, 0)
+
+	options := DefaultSampleIteratorOptions
+	options.MaxReconnections = 2
+	options.ReconnectionDelay = time.Millisecond
+	it := CreateSampleIterator(context.Background(), fxt.client, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}}, options)
+	defer it.Close()
+	assert.Equal(t, []uint64{0, 1, 2}, iterateTickIDs(it))
+	assert.Equal(t, codes.Unavailable, status.Code(it.Err()))
+
+	// Reverse retrievals can't be resumed
+	options.Headers = []string{"reverse", "true"}
+	fxt.interrupt(3)
+	it = CreateSampleIterator(context.Background(), fxt.client, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}}, options)
+	defer it.Close()
+	assert.Equal(t, []uint64{9, 8, 7}, iterateTickIDs(it))
+	assert.Equal(t, codes.Unavailable, status.Code(it.Err()))
+	assert.Equal(t, 1, fxt.retrievalsCount())
+}
+
+func TestSampleIteratorTypedErrors(t *testing.T) {
+	fxt := createSampleIteratorTestFixture(t)
+	defer fxt.destroy()
+
+	it := CreateSampleIterator(context.Background(), fxt.client, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"unknown-trial"}}, DefaultSampleIteratorOptions)
+	defer it.Close()
+	_, ok := it.Next()
+	assert.False(t, ok)
+	assert.ErrorIs(t, it.Err(), backend.ErrTrialNotFound)
+	assert.Equal(t, codes.NotFound, status.Code(it.Err()))
+	var statusErr *StatusError
+	assert.ErrorAs(t, it.Err(), &statusErr)
+}
+
+func TestSampleIteratorCancellation(t *testing.T) {
+	fxt := createSampleIteratorTestFixture(t)
+	defer fxt.destroy()
+	// The trial is ongoing, the retrieval waits for its next samples
+	fxt.addTrial(t, "my-trial", 3, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := CreateSampleIterator(ctx, fxt.client, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}}, DefaultSampleIteratorOptions)
+	defer it.Close()
+	for tickID := uint64(0); tickID < 3; tickID++ {
+		sample, ok := it.Next()
+		assert.True(t, ok)
+		assert.Equal(t, tickID, sample.TickId)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, ok := it.Next()
+	assert.False(t, ok)
+	assert.ErrorIs(t, it.Err(), context.Canceled)
+}
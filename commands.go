@@ -0,0 +1,321 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/viper"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/boltBackend"
+	log "github.com/sirupsen/logrus"
+)
+
+// runCommand runs one of the commands operating on the file storage instead of serving the gRPC services.
+//
+// Commands return their errors instead of exiting, for the file storage to be closed before exiting.
+// `maxMessageSize` is the maximum size, in bytes, of the messages read from trial archives.
+func runCommand(command string, args []string, payloadCompression backend.PayloadCompression, maxMessageSize int) {
+	var err error
+	switch command {
+	case "export":
+		err = runExportCommand(args, payloadCompression)
+	case "import":
+		err = runImportCommand(args, payloadCompression, maxMessageSize)
+	case "bulk-import":
+		err = runBulkImportCommand(args, payloadCompression, maxMessageSize)
+	case "compact":
+		err = runCompactCommand(args)
+	case "inspect":
+		err = runInspectCommand(args, payloadCompression)
+	case "merge":
+		err = runMergeCommand(args, payloadCompression)
+	default:
+		err = fmt.Errorf("unknown command %q expecting one of [export import bulk-import compact inspect merge]", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func createCommandBackend(command string, payloadCompression backend.PayloadCompression) (backend.Backend, error) {
+	if !viper.IsSet("FILE_STORAGE_PATH") {
+		return nil, fmt.Errorf("the %q command requires a file storage, defined using `COGMENT_TRIAL_DATASTORE_FILE_STORAGE_PATH`", command)
+	}
+	b, err := createBackend(boltBackend.BackendName, payloadCompression)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the bolt file backend: %w", err)
+	}
+	return b, nil
+}
+
+func runExportCommand(args []string, payloadCompression backend.PayloadCompression) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export [-output <file>] <trial_id>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	outputPath := flags.String("output", "-", "path of the exported trial archive, \"-\" for the standard output")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	trialID := flags.Arg(0)
+
+	b, err := createCommandBackend("export", payloadCompression)
+	if err != nil {
+		return err
+	}
+	defer b.Destroy()
+
+	var output io.Writer = os.Stdout
+	if *outputPath != "-" {
+		outputFile, err := os.Create(*outputPath)
+		if err != nil {
+			return fmt.Errorf("unable to create the archive file: %w", err)
+		}
+		defer outputFile.Close()
+		output = outputFile
+	}
+
+	err = backend.ExportTrial(context.Background(), b, trialID, output)
+	if err != nil {
+		return fmt.Errorf("unable to export trial %q: %w", trialID, err)
+	}
+	log.WithFields(log.Fields{"operation": "export", "trial_id": trialID}).Info("trial exported")
+	return nil
+}
+
+func runImportCommand(args []string, payloadCompression backend.PayloadCompression, maxMessageSize int) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s import [-trial-id <trial_id>] [-skip-existing | -overwrite] [<file>]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	trialID := flags.String("trial-id", "", "id of the imported trial, the one from the archive if empty")
+	skipExisting := flags.Bool("skip-existing", false, "succeed without importing anything if the trial already exists")
+	overwrite := flags.Bool("overwrite", false, "replace the trial if it already exists")
+	_ = flags.Parse(args)
+	if flags.NArg() > 1 || (*skipExisting && *overwrite) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var input io.Reader = os.Stdin
+	if flags.NArg() == 1 && flags.Arg(0) != "-" {
+		inputFile, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("unable to open the archive file: %w", err)
+		}
+		defer inputFile.Close()
+		input = inputFile
+	}
+
+	b, err := createCommandBackend("import", payloadCompression)
+	if err != nil {
+		return err
+	}
+	defer b.Destroy()
+
+	importedTrialID, err := backend.ImportTrial(context.Background(), b, input, backend.ImportTrialOptions{
+		TrialID:        *trialID,
+		SkipExisting:   *skipExisting,
+		Overwrite:      *overwrite,
+		MaxMessageSize: maxMessageSize,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to import trial: %w", err)
+	}
+	log.WithFields(log.Fields{"operation": "import", "trial_id": importedTrialID}).Info("trial imported")
+	return nil
+}
+
+// listTrialArchives lists the trial archive files of a directory, i.e. every regular file that isn't hidden, its
+// sub-directories aren't scanned
+func listTrialArchives(dirPath string) ([]string, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	filePaths := []string{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || entry.Name()[0] == '.' {
+			continue
+		}
+		filePaths = append(filePaths, filepath.Join(dirPath, entry.Name()))
+	}
+	sort.Strings(filePaths)
+	return filePaths, nil
+}
+
+func runBulkImportCommand(args []string, payloadCompression backend.PayloadCompression, maxMessageSize int) error {
+	flags := flag.NewFlagSet("bulk-import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s bulk-import [-concurrency <n>] [-skip-existing | -overwrite] <directory>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	concurrency := flags.Int("concurrency", 4, "maximum number of archives imported concurrently")
+	skipExisting := flags.Bool("skip-existing", false, "leave the trials that already exist untouched instead of failing their import")
+	overwrite := flags.Bool("overwrite", false, "replace the trials that already exist instead of failing their import")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 || (*skipExisting && *overwrite) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	filePaths, err := listTrialArchives(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("unable to list the archive files: %w", err)
+	}
+
+	b, err := createCommandBackend("bulk-import", payloadCompression)
+	if err != nil {
+		return err
+	}
+	defer b.Destroy()
+
+	summary := backend.ImportTrialArchives(context.Background(), b, filePaths, backend.ImportTrialArchivesOptions{
+		Concurrency:    *concurrency,
+		SkipExisting:   *skipExisting,
+		Overwrite:      *overwrite,
+		MaxMessageSize: maxMessageSize,
+		Progress: func(result backend.TrialArchiveImport, done int, total int) {
+			logger := log.WithFields(log.Fields{"operation": "bulk-import", "file": result.FilePath, "progress": fmt.Sprintf("%d/%d", done, total)})
+			switch {
+			case result.Err != nil:
+				logger.WithError(result.Err).Error("unable to import trial")
+			case result.Skipped:
+				logger.WithField("trial_id", result.TrialID).Info("existing trial skipped")
+			default:
+				logger.WithField("trial_id", result.TrialID).Info("trial imported")
+			}
+		},
+	})
+	if summary.Failed > 0 {
+		return fmt.Errorf("some trials couldn't be imported, %d succeeded, %d failed and %d were skipped", summary.Succeeded, summary.Failed, summary.Skipped)
+	}
+	log.WithFields(log.Fields{"operation": "bulk-import", "succeeded": summary.Succeeded, "failed": summary.Failed, "skipped": summary.Skipped}).Info("trials imported")
+	return nil
+}
+
+func runCompactCommand(args []string) error {
+	flags := flag.NewFlagSet("compact", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s compact\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if !viper.IsSet("FILE_STORAGE_PATH") {
+		return fmt.Errorf("the \"compact\" command requires a file storage, defined using `COGMENT_TRIAL_DATASTORE_FILE_STORAGE_PATH`")
+	}
+	filePath := viper.GetString("FILE_STORAGE_PATH")
+
+	sizeBefore, sizeAfter, err := boltBackend.CompactFile(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to compact the file storage: %w", err)
+	}
+	log.WithFields(log.Fields{"operation": "compact", "size_before": sizeBefore, "size_after": sizeAfter}).Info("file storage compacted")
+	return nil
+}
+
+func runInspectCommand(args []string, payloadCompression backend.PayloadCompression) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s inspect -trial <trial_id> -tick <tick_id> [-hexdump]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	trialID := flags.String("trial", "", "id of the trial of the inspected sample")
+	tickID := flags.Uint64("tick", 0, "tick id of the inspected sample")
+	hexdump := flags.Bool("hexdump", false, "also dump the raw bytes of the payloads")
+	_ = flags.Parse(args)
+	tickIsSet := false
+	flags.Visit(func(f *flag.Flag) { tickIsSet = tickIsSet || f.Name == "tick" })
+	if flags.NArg() != 0 || *trialID == "" || !tickIsSet {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	b, err := createCommandBackend("inspect", payloadCompression)
+	if err != nil {
+		return err
+	}
+	defer b.Destroy()
+
+	sample, trialParams, err := backend.RetrieveSample(context.Background(), b, *trialID, *tickID)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the sample: %w", err)
+	}
+	err = backend.WriteSampleInspection(os.Stdout, sample, trialParams.Params, *hexdump)
+	if err != nil {
+		return fmt.Errorf("unable to write the sample: %w", err)
+	}
+	return nil
+}
+
+func runMergeCommand(args []string, payloadCompression backend.PayloadCompression) error {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s merge [-tick-id-offset <offset>] [-delete-source] <source_trial_id> <destination_trial_id>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	tickIDOffset := flags.Uint64("tick-id-offset", 0, "offset added to the tick ids of the source samples, by default they directly follow the destination samples")
+	deleteSource := flags.Bool("delete-source", false, "delete the source trial once its samples are merged")
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	sourceTrialID := flags.Arg(0)
+	destinationTrialID := flags.Arg(1)
+	options := backend.TrialsMergeOptions{DeleteSource: *deleteSource}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "tick-id-offset" {
+			options.TickIDOffset = tickIDOffset
+		}
+	})
+
+	b, err := createCommandBackend("merge", payloadCompression)
+	if err != nil {
+		return err
+	}
+	defer b.Destroy()
+
+	result, err := backend.MergeTrials(context.Background(), b, sourceTrialID, destinationTrialID, options)
+	if err != nil {
+		return fmt.Errorf("unable to merge trial %q into trial %q: %w", sourceTrialID, destinationTrialID, err)
+	}
+	log.WithFields(log.Fields{
+		"operation":            "merge",
+		"source_trial_id":      sourceTrialID,
+		"destination_trial_id": destinationTrialID,
+		"merged_samples_count": result.MergedSamplesCount,
+		"tick_id_offset":       result.TickIDOffset,
+		"source_deleted":       *deleteSource,
+	}).Info("trials merged")
+	return nil
+}
@@ -0,0 +1,365 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The admin service isn't part of the cogment API, it's described below instead of being generated from a proto file
+// so that it can be called through the reflection server, e.g. using grpcurl.
+//
+//	package cogmentTrialDatastore;
+//	service Admin {
+//	  rpc GetStorageStats(google.protobuf.Empty) returns (google.protobuf.Struct) {}
+//	  rpc GetActorRewardStats(google.protobuf.Struct) returns (google.protobuf.Struct) {}
+//	  rpc GetTrialResult(google.protobuf.Struct) returns (google.protobuf.Struct) {}
+//	  rpc GetSamplePayload(google.protobuf.Struct) returns (google.protobuf.Struct) {}
+//	}
+const (
+	adminProtoFileName               = "cogment_trial_datastore/admin.proto"
+	adminServiceName                 = "cogmentTrialDatastore.Admin"
+	adminGetStorageStatsFullName     = "/" + adminServiceName + "/GetStorageStats"
+	adminGetActorRewardStatsFullName = "/" + adminServiceName + "/GetActorRewardStats"
+	adminGetTrialResultFullName      = "/" + adminServiceName + "/GetTrialResult"
+	adminGetSamplePayloadFullName    = "/" + adminServiceName + "/GetSamplePayload"
+)
+
+func init() {
+	fileDescriptor, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String(adminProtoFileName),
+		Package:    proto.String("cogmentTrialDatastore"),
+		Dependency: []string{"google/protobuf/empty.proto", "google/protobuf/struct.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Admin"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("GetStorageStats"),
+				InputType:  proto.String(".google.protobuf.Empty"),
+				OutputType: proto.String(".google.protobuf.Struct"),
+			}, {
+				Name:       proto.String("GetActorRewardStats"),
+				InputType:  proto.String(".google.protobuf.Struct"),
+				OutputType: proto.String(".google.protobuf.Struct"),
+			}, {
+				Name:       proto.String("GetTrialResult"),
+				InputType:  proto.String(".google.protobuf.Struct"),
+				OutputType: proto.String(".google.protobuf.Struct"),
+			}, {
+				Name:       proto.String("GetSamplePayload"),
+				InputType:  proto.String(".google.protobuf.Struct"),
+				OutputType: proto.String(".google.protobuf.Struct"),
+			}},
+		}},
+		Syntax: proto.String("proto3"),
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fileDescriptor); err != nil {
+		panic(err)
+	}
+}
+
+// adminServiceServer is the interface implemented by the admin servers
+type adminServiceServer interface {
+	GetStorageStats(ctx context.Context, req *emptypb.Empty) (*structpb.Struct, error)
+	GetActorRewardStats(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	GetTrialResult(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	GetSamplePayload(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+func getStorageStatsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &emptypb.Empty{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(adminServiceServer).GetStorageStats(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: adminGetStorageStatsFullName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(adminServiceServer).GetStorageStats(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func getActorRewardStatsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &structpb.Struct{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(adminServiceServer).GetActorRewardStats(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: adminGetActorRewardStatsFullName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(adminServiceServer).GetActorRewardStats(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func getTrialResultHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &structpb.Struct{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(adminServiceServer).GetTrialResult(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: adminGetTrialResultFullName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(adminServiceServer).GetTrialResult(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func getSamplePayloadHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &structpb.Struct{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(adminServiceServer).GetSamplePayload(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: adminGetSamplePayloadFullName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(adminServiceServer).GetSamplePayload(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: adminServiceName,
+	HandlerType: (*adminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStorageStats",
+			Handler:    getStorageStatsHandler,
+		},
+		{
+			MethodName: "GetActorRewardStats",
+			Handler:    getActorRewardStatsHandler,
+		},
+		{
+			MethodName: "GetTrialResult",
+			Handler:    getTrialResultHandler,
+		},
+		{
+			MethodName: "GetSamplePayload",
+			Handler:    getSamplePayloadHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: adminProtoFileName,
+}
+
+type adminServer struct {
+	backend   backend.Backend
+	startedAt time.Time
+}
+
+// GetStorageStats retrieves the storage usage of the backend along with the uptime of the server.
+//
+// Sizes are expressed in bytes, the size of the storage file and the free space of its file system are only defined
+// for persistent backends.
+func (s *adminServer) GetStorageStats(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	stats, err := s.backend.StorageStats(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "AdminServer.GetStorageStats: internal error %q", err)
+	}
+	fields := map[string]interface{}{
+		"backend_type":   stats.BackendType,
+		"trials_count":   stats.TrialsCount,
+		"samples_count":  stats.SamplesCount,
+		"samples_size":   stats.SamplesSize,
+		"uptime_seconds": time.Since(s.startedAt).Seconds(),
+	}
+	if stats.Persistent {
+		fields["file_size"] = stats.FileSize
+		if stats.FreeSpace >= 0 {
+			fields["free_space"] = stats.FreeSpace
+		}
+	}
+	rep, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "AdminServer.GetStorageStats: internal error %q", err)
+	}
+	return rep, nil
+}
+
+// optionalTickIDFromStruct parses the optional tick id field `name` of a request
+func optionalTickIDFromStruct(req *structpb.Struct, name string) (*uint64, error) {
+	value, found := req.GetFields()[name]
+	if !found {
+		return nil, nil
+	}
+	number, ok := value.GetKind().(*structpb.Value_NumberValue)
+	if !ok || number.NumberValue < 0 || number.NumberValue != math.Trunc(number.NumberValue) {
+		return nil, fmt.Errorf("invalid %q, expecting a positive integer", name)
+	}
+	tickID := uint64(number.NumberValue)
+	return &tickID, nil
+}
+
+// GetActorRewardStats computes the count, total and mean of the rewards received by each actor of a trial, including
+// the rewards sent by the environment.
+//
+// The request defines `trial_id` and, optionally, an inclusive tick range using `from_tick_id` and `to_tick_id`. The
+// stats are returned in the `actors` field keyed by actor index.
+func (s *adminServer) GetActorRewardStats(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	trialID := req.GetFields()["trial_id"].GetStringValue()
+	if trialID == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetActorRewardStats: a \"trial_id\" is required")
+	}
+	fromTickID, err := optionalTickIDFromStruct(req, "from_tick_id")
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetActorRewardStats: %s", err)
+	}
+	toTickID, err := optionalTickIDFromStruct(req, "to_tick_id")
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetActorRewardStats: %s", err)
+	}
+	if fromTickID != nil && toTickID != nil && *fromTickID > *toTickID {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetActorRewardStats: \"from_tick_id\" is after \"to_tick_id\"")
+	}
+
+	stats, err := backend.RetrieveActorRewardStats(ctx, s.backend, trialID, fromTickID, toTickID)
+	if err != nil {
+		return nil, backendErrorStatus("AdminServer.GetActorRewardStats", err)
+	}
+	actors := make(map[string]interface{}, len(stats))
+	for actorIdx, actorStats := range stats {
+		actors[strconv.FormatUint(uint64(actorIdx), 10)] = map[string]interface{}{
+			"rewards_count": actorStats.RewardsCount,
+			"total":         actorStats.Total,
+			"mean":          actorStats.Mean,
+		}
+	}
+	rep, err := structpb.NewStruct(map[string]interface{}{"actors": actors})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "AdminServer.GetActorRewardStats: internal error %q", err)
+	}
+	return rep, nil
+}
+
+// GetTrialResult retrieves the result set when a trial ended, without retrieving its samples.
+//
+// The request defines `trial_id`. The scores are returned in the `scores` field keyed by name and the opaque result,
+// if any, in the `data` field encoded in base64. A trial without result results in a `NOT_FOUND` error.
+func (s *adminServer) GetTrialResult(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	trialID := req.GetFields()["trial_id"].GetStringValue()
+	if trialID == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetTrialResult: a \"trial_id\" is required")
+	}
+
+	result, err := s.backend.GetTrialResult(ctx, trialID)
+	if err != nil {
+		return nil, backendErrorStatus("AdminServer.GetTrialResult", err)
+	}
+	scores := make(map[string]interface{}, len(result.Scores))
+	for name, score := range result.Scores {
+		scores[name] = score
+	}
+	fields := map[string]interface{}{"scores": scores}
+	if len(result.Data) > 0 {
+		fields["data"] = base64.StdEncoding.EncodeToString(result.Data)
+	}
+	rep, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "AdminServer.GetTrialResult: internal error %q", err)
+	}
+	return rep, nil
+}
+
+// GetSamplePayload retrieves a single payload of a stored sample, without retrieving the whole sample.
+//
+// The request defines `trial_id`, `tick_id` and `payload_idx`, the index of the payload in the sample `payloads`. The
+// payload is returned in the `payload` field encoded in base64. A sample that isn't stored, or a payload index out of
+// the range of its payloads, results in a `NOT_FOUND` error.
+func (s *adminServer) GetSamplePayload(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	trialID := req.GetFields()["trial_id"].GetStringValue()
+	if trialID == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetSamplePayload: a \"trial_id\" is required")
+	}
+	tickID, err := optionalTickIDFromStruct(req, "tick_id")
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetSamplePayload: %s", err)
+	}
+	if tickID == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetSamplePayload: a \"tick_id\" is required")
+	}
+	// Payload indices are parsed like tick ids
+	payloadIdx, err := optionalTickIDFromStruct(req, "payload_idx")
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetSamplePayload: %s", err)
+	}
+	if payloadIdx == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetSamplePayload: a \"payload_idx\" is required")
+	}
+	if *payloadIdx > math.MaxUint32 {
+		return nil, status.Errorf(codes.InvalidArgument, "AdminServer.GetSamplePayload: \"payload_idx\" is out of range")
+	}
+
+	payload, err := s.backend.RetrieveSamplePayload(ctx, trialID, *tickID, uint32(*payloadIdx))
+	if err != nil {
+		return nil, backendErrorStatus("AdminServer.GetSamplePayload", err)
+	}
+	rep, err := structpb.NewStruct(map[string]interface{}{"payload": base64.StdEncoding.EncodeToString(payload)})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "AdminServer.GetSamplePayload: internal error %q", err)
+	}
+	return rep, nil
+}
+
+// RegisterAdminServer registers an admin server, reporting the storage usage of the given backend, to a gRPC server.
+//
+// Its uptime is measured from the registration.
+func RegisterAdminServer(grpcServer grpc.ServiceRegistrar, backend backend.Backend) error {
+	server := &adminServer{
+		backend:   backend,
+		startedAt: time.Now(),
+	}
+
+	grpcServer.RegisterService(&adminServiceDesc, server)
+	return nil
+}
@@ -0,0 +1,350 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestAdminServerGetStorageStats(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := CreateGrpcServer(false)
+	defer server.Stop()
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	assert.NoError(t, RegisterTrialDatastoreServer(server, b))
+	assert.NoError(t, RegisterAdminServer(server, b))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	ctx := context.Background()
+	connection, err := grpc.DialContext(
+		ctx,
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	defer connection.Close()
+
+	getStorageStats := func() map[string]interface{} {
+		rep := &structpb.Struct{}
+		err := connection.Invoke(ctx, adminGetStorageStatsFullName, &emptypb.Empty{}, rep)
+		assert.NoError(t, err)
+		return rep.AsMap()
+	}
+
+	stats := getStorageStats()
+	assert.Equal(t, memoryBackend.BackendName, stats["backend_type"])
+	assert.Equal(t, 0., stats["trials_count"])
+	assert.Equal(t, 0., stats["samples_count"])
+	assert.GreaterOrEqual(t, stats["uptime_seconds"], 0.)
+	// The memory backend isn't persistent
+	assert.NotContains(t, stats, "file_size")
+	assert.NotContains(t, stats, "free_space")
+
+	err = b.CreateOrUpdateTrials(ctx, []*backend.TrialParams{
+		{TrialID: "trial-1", Params: &grpcapi.TrialParams{}},
+		{TrialID: "trial-2", Params: &grpcapi.TrialParams{}},
+	})
+	assert.NoError(t, err)
+	err = b.AddSamples(ctx, []*grpcapi.StoredTrialSample{
+		{TrialId: "trial-1", TickId: 0, State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{[]byte("an observation")}},
+		{TrialId: "trial-1", TickId: 1, State: grpcapi.TrialState_ENDED, Payloads: [][]byte{[]byte("another observation")}},
+		{TrialId: "trial-2", TickId: 0, State: grpcapi.TrialState_ENDED, Payloads: [][]byte{[]byte("an observation")}},
+	})
+	assert.NoError(t, err)
+
+	stats = getStorageStats()
+	assert.Equal(t, 2., stats["trials_count"])
+	assert.Equal(t, 3., stats["samples_count"])
+	samplesSize := stats["samples_size"]
+	assert.Greater(t, samplesSize, 0.)
+
+	_, err = grpcapi.NewTrialDatastoreSPClient(connection).DeleteTrials(ctx, &grpcapi.DeleteTrialsRequest{TrialIds: []string{"trial-1"}})
+	assert.NoError(t, err)
+
+	stats = getStorageStats()
+	assert.Equal(t, 1., stats["trials_count"])
+	assert.Equal(t, 1., stats["samples_count"])
+	assert.Less(t, stats["samples_size"], samplesSize)
+	assert.Greater(t, stats["samples_size"], 0.)
+}
+
+func TestAdminServerGetActorRewardStats(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := CreateGrpcServer(false)
+	defer server.Stop()
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	assert.NoError(t, RegisterAdminServer(server, b))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	ctx := context.Background()
+	connection, err := grpc.DialContext(
+		ctx,
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	defer connection.Close()
+
+	err = b.CreateOrUpdateTrials(ctx, []*backend.TrialParams{{TrialID: "my-trial", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+	err = b.AddSamples(ctx, []*grpcapi.StoredTrialSample{
+		{
+			TrialId: "my-trial",
+			TickId:  0,
+			State:   grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{Actor: 0, ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+					{Sender: backend.EnvironmentActorIdx, Receiver: 0, Reward: 1, Confidence: 1},
+					{Sender: 1, Receiver: 0, Reward: 2, Confidence: 1},
+				}},
+				{Actor: 1, SentRewards: []*grpcapi.StoredTrialActorSampleReward{
+					{Sender: 1, Receiver: 0, Reward: 2, Confidence: 1},
+				}},
+			},
+		},
+		{
+			TrialId: "my-trial",
+			TickId:  1,
+			State:   grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{Actor: 0, ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+					{Sender: backend.EnvironmentActorIdx, Receiver: 0, Reward: -4, Confidence: 1},
+				}},
+				{Actor: 1, ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+					{Sender: backend.EnvironmentActorIdx, Receiver: 1, Reward: 3, Confidence: 1},
+				}},
+			},
+		},
+		{
+			TrialId: "my-trial",
+			TickId:  2,
+			State:   grpcapi.TrialState_ENDED,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{Actor: 0, ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+					{Sender: backend.EnvironmentActorIdx, Receiver: 0, Reward: 5, Confidence: 1},
+				}},
+				{Actor: 1},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	getActorRewardStats := func(fields map[string]interface{}) (map[string]interface{}, error) {
+		req, err := structpb.NewStruct(fields)
+		assert.NoError(t, err)
+		rep := &structpb.Struct{}
+		err = connection.Invoke(ctx, adminGetActorRewardStatsFullName, req, rep)
+		if err != nil {
+			return nil, err
+		}
+		return rep.AsMap()["actors"].(map[string]interface{}), nil
+	}
+
+	stats, err := getActorRewardStats(map[string]interface{}{"trial_id": "my-trial"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"0": map[string]interface{}{"rewards_count": 4., "total": 4., "mean": 1.},
+		"1": map[string]interface{}{"rewards_count": 1., "total": 3., "mean": 3.},
+	}, stats)
+
+	stats, err = getActorRewardStats(map[string]interface{}{"trial_id": "my-trial", "from_tick_id": 1, "to_tick_id": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"0": map[string]interface{}{"rewards_count": 1., "total": -4., "mean": -4.},
+		"1": map[string]interface{}{"rewards_count": 1., "total": 3., "mean": 3.},
+	}, stats)
+
+	stats, err = getActorRewardStats(map[string]interface{}{"trial_id": "my-trial", "from_tick_id": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"0": map[string]interface{}{"rewards_count": 1., "total": 5., "mean": 5.},
+		"1": map[string]interface{}{"rewards_count": 0., "total": 0., "mean": 0.},
+	}, stats)
+
+	_, err = getActorRewardStats(map[string]interface{}{"trial_id": "unknown-trial"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = getActorRewardStats(map[string]interface{}{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = getActorRewardStats(map[string]interface{}{"trial_id": "my-trial", "from_tick_id": 1.5})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = getActorRewardStats(map[string]interface{}{"trial_id": "my-trial", "from_tick_id": 2, "to_tick_id": 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAdminServerGetTrialResult(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := CreateGrpcServer(false)
+	defer server.Stop()
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	assert.NoError(t, RegisterAdminServer(server, b))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	ctx := context.Background()
+	connection, err := grpc.DialContext(
+		ctx,
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	defer connection.Close()
+
+	err = b.CreateOrUpdateTrials(ctx, []*backend.TrialParams{
+		{TrialID: "trial-1", Params: &grpcapi.TrialParams{}},
+		{TrialID: "trial-2", Params: &grpcapi.TrialParams{}},
+		{TrialID: "trial-3", Params: &grpcapi.TrialParams{}},
+	})
+	assert.NoError(t, err)
+	err = b.SetTrialResult(ctx, "trial-1", &backend.TrialResult{Scores: map[string]float64{"return": 12.5, "steps": 3}, Data: []byte("details")})
+	assert.NoError(t, err)
+	err = b.SetTrialResult(ctx, "trial-2", &backend.TrialResult{Scores: map[string]float64{"return": -1}})
+	assert.NoError(t, err)
+
+	getTrialResult := func(fields map[string]interface{}) (map[string]interface{}, error) {
+		req, err := structpb.NewStruct(fields)
+		assert.NoError(t, err)
+		rep := &structpb.Struct{}
+		err = connection.Invoke(ctx, adminGetTrialResultFullName, req, rep)
+		if err != nil {
+			return nil, err
+		}
+		return rep.AsMap(), nil
+	}
+
+	result, err := getTrialResult(map[string]interface{}{"trial_id": "trial-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"scores": map[string]interface{}{"return": 12.5, "steps": 3.},
+		"data":   "ZGV0YWlscw==",
+	}, result)
+
+	result, err = getTrialResult(map[string]interface{}{"trial_id": "trial-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"scores": map[string]interface{}{"return": -1.}}, result)
+
+	// A trial without result is reported as such, like an unknown trial
+	_, err = getTrialResult(map[string]interface{}{"trial_id": "trial-3"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "has no result")
+
+	_, err = getTrialResult(map[string]interface{}{"trial_id": "unknown-trial"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = getTrialResult(map[string]interface{}{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAdminServerGetSamplePayload(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := CreateGrpcServer(false)
+	defer server.Stop()
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	assert.NoError(t, RegisterAdminServer(server, b))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	ctx := context.Background()
+	connection, err := grpc.DialContext(
+		ctx,
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	defer connection.Close()
+
+	err = b.CreateOrUpdateTrials(ctx, []*backend.TrialParams{{TrialID: "trial-1", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+	err = b.AddSamples(ctx, []*grpcapi.StoredTrialSample{
+		{TrialId: "trial-1", TickId: 4, Payloads: [][]byte{[]byte("an observation"), []byte("an action")}},
+	})
+	assert.NoError(t, err)
+
+	getSamplePayload := func(fields map[string]interface{}) (map[string]interface{}, error) {
+		req, err := structpb.NewStruct(fields)
+		assert.NoError(t, err)
+		rep := &structpb.Struct{}
+		err = connection.Invoke(ctx, adminGetSamplePayloadFullName, req, rep)
+		if err != nil {
+			return nil, err
+		}
+		return rep.AsMap(), nil
+	}
+
+	payload, err := getSamplePayload(map[string]interface{}{"trial_id": "trial-1", "tick_id": 4, "payload_idx": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"payload": "YW4gYWN0aW9u"}, payload)
+
+	for _, fields := range []map[string]interface{}{
+		{"trial_id": "trial-1", "tick_id": 4, "payload_idx": 2},
+		{"trial_id": "trial-1", "tick_id": 3, "payload_idx": 0},
+		{"trial_id": "unknown-trial", "tick_id": 4, "payload_idx": 0},
+	} {
+		_, err = getSamplePayload(fields)
+		assert.Equal(t, codes.NotFound, status.Code(err), "%v", fields)
+	}
+
+	for _, fields := range []map[string]interface{}{
+		{"tick_id": 4, "payload_idx": 0},
+		{"trial_id": "trial-1", "payload_idx": 0},
+		{"trial_id": "trial-1", "tick_id": 4},
+		{"trial_id": "trial-1", "tick_id": 4, "payload_idx": -1},
+		{"trial_id": "trial-1", "tick_id": 4, "payload_idx": 1 << 32},
+	} {
+		_, err = getSamplePayload(fields)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", fields)
+	}
+}
+
+func TestAdminServiceDescriptor(t *testing.T) {
+	// The admin service is described for the reflection server
+	descriptor, err := protoregistry.GlobalFiles.FindDescriptorByName(adminServiceName)
+	assert.NoError(t, err)
+	assert.Equal(t, adminProtoFileName, descriptor.ParentFile().Path())
+}
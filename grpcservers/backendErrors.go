@@ -0,0 +1,78 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"errors"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// backendErrorCode returns the gRPC status code matching an error raised by the backend, false if it isn't a known
+// backend error
+func backendErrorCode(err error) (codes.Code, bool) {
+	switch {
+	case errors.Is(err, backend.ErrTrialDeleted):
+		// The operation conflicted with a concurrent deletion, it can be retried at a higher level
+		return codes.Aborted, true
+	case errors.Is(err, backend.ErrTrialNotFound):
+		return codes.NotFound, true
+	case errors.Is(err, backend.ErrTrialAlreadyExists):
+		return codes.AlreadyExists, true
+	case errors.Is(err, backend.ErrSampleOutOfOrder):
+		return codes.InvalidArgument, true
+	}
+	var tooManyTrialsErr *backend.TooManyTrialsError
+	if errors.As(err, &tooManyTrialsErr) {
+		return codes.ResourceExhausted, true
+	}
+	var evictedSamplesErr *backend.EvictedSamplesError
+	if errors.As(err, &evictedSamplesErr) {
+		return codes.OutOfRange, true
+	}
+	var corruptedSampleErr *backend.CorruptedSampleError
+	if errors.As(err, &corruptedSampleErr) {
+		return codes.DataLoss, true
+	}
+	var noTrialResultErr *backend.NoTrialResultError
+	if errors.As(err, &noTrialResultErr) {
+		return codes.NotFound, true
+	}
+	var unknownSampleErr *backend.UnknownSampleError
+	if errors.As(err, &unknownSampleErr) {
+		return codes.NotFound, true
+	}
+	var unknownPayloadErr *backend.UnknownPayloadError
+	if errors.As(err, &unknownPayloadErr) {
+		return codes.NotFound, true
+	}
+	var missingTrialParamsErr *backend.MissingTrialParamsError
+	if errors.As(err, &missingTrialParamsErr) {
+		return codes.FailedPrecondition, true
+	}
+	return codes.Unknown, false
+}
+
+// backendErrorStatus converts an error raised by the backend while handling the given method to a gRPC status error,
+// unknown errors being reported as internal errors
+func backendErrorStatus(method string, err error) error {
+	code, ok := backendErrorCode(err)
+	if !ok {
+		return status.Errorf(codes.Internal, "%s: internal error %q", method, err)
+	}
+	return status.Errorf(code, "%s: %s", method, err)
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackendErrorCode(t *testing.T) {
+	for _, testCase := range []struct {
+		err          error
+		expectedCode codes.Code
+	}{
+		{err: &backend.UnknownTrialError{TrialID: "my-trial"}, expectedCode: codes.NotFound},
+		{err: fmt.Errorf("wrapped (%w)", &backend.UnknownTrialError{TrialID: "my-trial"}), expectedCode: codes.NotFound},
+		{err: &backend.UnknownTrialError{TrialID: "my-trial", Deleted: true}, expectedCode: codes.Aborted},
+		{err: &backend.TrialAlreadyExistsError{TrialID: "my-trial"}, expectedCode: codes.AlreadyExists},
+		{err: &outOfOrderTickError{TrialID: "my-trial", TickID: 1, LastTickID: 2}, expectedCode: codes.InvalidArgument},
+		{err: &backend.TooManyTrialsError{MaxTrialsCount: 1}, expectedCode: codes.ResourceExhausted},
+		{err: &backend.EvictedSamplesError{TrialID: "my-trial"}, expectedCode: codes.OutOfRange},
+		{err: &backend.CorruptedSampleError{TrialID: "my-trial"}, expectedCode: codes.DataLoss},
+		{err: &backend.MissingTrialParamsError{TrialID: "my-trial", Filter: "actor names"}, expectedCode: codes.FailedPrecondition},
+		{err: &backend.NoTrialResultError{TrialID: "my-trial"}, expectedCode: codes.NotFound},
+		{err: &backend.UnknownSampleError{TrialID: "my-trial", TickID: 12}, expectedCode: codes.NotFound},
+		{err: &backend.UnknownPayloadError{TrialID: "my-trial", TickID: 12, PayloadIdx: 3}, expectedCode: codes.NotFound},
+	} {
+		code, ok := backendErrorCode(testCase.err)
+		assert.True(t, ok, testCase.err.Error())
+		assert.Equal(t, testCase.expectedCode, code, testCase.err.Error())
+
+		err := backendErrorStatus("MyServer.MyMethod", testCase.err)
+		assert.Equal(t, testCase.expectedCode, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "MyServer.MyMethod: ")
+	}
+
+	_, ok := backendErrorCode(errors.New("something went wrong"))
+	assert.False(t, ok)
+	assert.Equal(t, codes.Internal, status.Code(backendErrorStatus("MyServer.MyMethod", errors.New("something went wrong"))))
+}
+
+func TestAddSamplesToDeletedTrial(t *testing.T) {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	// The trial gets deleted while samples are added to it
+	slowBackend := &slowBackend{Backend: b, release: make(chan struct{}), err: &backend.UnknownTrialError{TrialID: "trial0", Deleted: true}}
+	close(slowBackend.release)
+
+	client, destroy := createSlowBackendClient(t, slowBackend, TrialDatastoreServerOptions{}, func() {})
+	defer destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "trial0", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "trial-id", "trial0")
+	stream, err := client.AddSample(ctx)
+	assert.NoError(t, err)
+	err = stream.Send(&grpcapi.AddSampleRequest{TrialSample: &grpcapi.StoredTrialSample{TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Aborted, status.Code(err))
+}
@@ -15,14 +15,20 @@
 package grpcservers
 
 import (
+	"context"
+	"path"
 	"sync"
+	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/cogment/cogment-trial-datastore/metrics"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip" // Registering the gzip compressor, used for the calls requesting it
 	"google.golang.org/grpc/reflection"
 )
 
@@ -53,14 +59,68 @@ func offsetLevel(level log.Level, offset int) log.Level {
 	return log.Level(offsetValue)
 }
 
+// durationToMillisecondsField logs the duration of the calls as `duration_ms`, like the rest of the service.
+func durationToMillisecondsField(duration time.Duration) (key string, value interface{}) {
+	return "duration_ms", duration.Milliseconds()
+}
+
+// operationFromFullMethod returns the name of the operation of a call, e.g. "RetrieveSamples"
+func operationFromFullMethod(fullMethod string) string {
+	return path.Base(fullMethod)
+}
+
+func operationUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	grpc_ctxtags.Extract(ctx).Set("operation", operationFromFullMethod(info.FullMethod))
+	return handler(ctx, req)
+}
+
+func operationStreamServerInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	grpc_ctxtags.Extract(stream.Context()).Set("operation", operationFromFullMethod(info.FullMethod))
+	return handler(srv, stream)
+}
+
+// tagTrialIDs adds the ids of the trials a call deals with to the fields of its log entry
+func tagTrialIDs(ctx context.Context, trialIDs ...string) {
+	switch len(trialIDs) {
+	case 0:
+	case 1:
+		grpc_ctxtags.Extract(ctx).Set("trial_id", trialIDs[0])
+	default:
+		grpc_ctxtags.Extract(ctx).Set("trial_ids", trialIDs)
+	}
+}
+
 var replaceInternalGrpcLoggerSingleton sync.Once
 
+// GrpcServerOptions represents the configuration of the gRPC server
+type GrpcServerOptions struct {
+	EnableReflection bool            // Register a gRPC reflection server
+	EnableMetrics    bool            // Measure the handled calls in `metrics.GrpcServerMetrics`
+	Drainer          *Drainer        // Track the handled calls to interrupt them on shutdown, nil disables the tracking
+	TLSCredentials   *TLSCredentials // Serve over TLS, nil serves in plaintext
+	// Require calls to the API to send a token having the required scope, nil disables the authentication
+	TokenAuthenticator *TokenAuthenticator
+	// Maximum size, in bytes, of the received messages, 0 means `DefaultMaxReceivedMessageSize`
+	MaxReceivedMessageSize int
+	// Maximum size, in bytes, of the sent messages, 0 means `DefaultMaxSentMessageSize`
+	MaxSentMessageSize int
+}
+
 func CreateGrpcServer(enableReflection bool) *grpc.Server {
+	return CreateGrpcServerWithOptions(GrpcServerOptions{EnableReflection: enableReflection})
+}
+
+// CreateGrpcServerWithOptions creates a gRPC server configured with the given options.
+//
+// When the metrics are enabled, `metrics.GrpcServerMetrics.InitializeMetrics` should be called once the services are registered.
+func CreateGrpcServerWithOptions(options GrpcServerOptions) *grpc.Server {
 	globalLogLevel := log.GetLevel()
+	globalLogFormatter := log.StandardLogger().Formatter
 
 	replaceInternalGrpcLoggerSingleton.Do(func() {
 		// Replacing the internal grpc logger
 		grpcServerLog := log.New()
+		grpcServerLog.SetFormatter(globalLogFormatter)
 		grpcServerLog.SetLevel(offsetLevel(globalLogLevel, -1)) // This is really verbose so we set it one level above the global logger's
 		grpcServerEntry := log.NewEntry(grpcServerLog)
 		// This should be done only once before any call to `CreateGrpcServer`
@@ -69,23 +129,52 @@ func CreateGrpcServer(enableReflection bool) *grpc.Server {
 
 	// Logging calls
 	grpcCallsLog := log.New()
+	grpcCallsLog.SetFormatter(globalLogFormatter)
 	grpcCallsLog.SetLevel(globalLogLevel)
 	grpcCallsEntry := log.NewEntry(grpcCallsLog)
 	grpcLogrusOpts := []grpc_logrus.Option{
 		grpc_logrus.WithLevels(grpcCodeToLogrusLevel),
+		grpc_logrus.WithDurationField(durationToMillisecondsField),
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		grpc_ctxtags.UnaryServerInterceptor(grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)),
+		operationUnaryServerInterceptor,
+		grpc_logrus.UnaryServerInterceptor(grpcCallsEntry, grpcLogrusOpts...),
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_ctxtags.StreamServerInterceptor(grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)),
+		operationStreamServerInterceptor,
+		grpc_logrus.StreamServerInterceptor(grpcCallsEntry, grpcLogrusOpts...),
+	}
+	if options.EnableMetrics {
+		unaryInterceptors = append(unaryInterceptors, metrics.GrpcServerMetrics.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, metrics.GrpcServerMetrics.StreamServerInterceptor())
+	}
+	if options.TokenAuthenticator != nil {
+		unaryInterceptors = append(unaryInterceptors, options.TokenAuthenticator.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, options.TokenAuthenticator.StreamServerInterceptor())
 	}
-	server := grpc.NewServer(
-		grpc_middleware.WithUnaryServerChain(
-			grpc_ctxtags.UnaryServerInterceptor(grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)),
-			grpc_logrus.UnaryServerInterceptor(grpcCallsEntry, grpcLogrusOpts...),
-		),
-		grpc_middleware.WithStreamServerChain(
-			grpc_ctxtags.StreamServerInterceptor(grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)),
-			grpc_logrus.StreamServerInterceptor(grpcCallsEntry, grpcLogrusOpts...),
-		),
-	)
-
-	if enableReflection {
+	if options.Drainer != nil {
+		unaryInterceptors = append(unaryInterceptors, options.Drainer.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, options.Drainer.StreamServerInterceptor())
+	}
+	serverOptions := []grpc.ServerOption{
+		grpc_middleware.WithUnaryServerChain(unaryInterceptors...),
+		grpc_middleware.WithStreamServerChain(streamInterceptors...),
+	}
+	if options.TLSCredentials != nil {
+		serverOptions = append(serverOptions, grpc.Creds(options.TLSCredentials.TransportCredentials()))
+	}
+	if options.MaxReceivedMessageSize > 0 {
+		serverOptions = append(serverOptions, grpc.MaxRecvMsgSize(options.MaxReceivedMessageSize))
+	}
+	if options.MaxSentMessageSize > 0 {
+		serverOptions = append(serverOptions, grpc.MaxSendMsgSize(options.MaxSentMessageSize))
+	}
+
+	server := grpc.NewServer(serverOptions...)
+
+	if options.EnableReflection {
 		reflection.Register(server)
 		log.Info("gRPC reflection server enabled")
 	}
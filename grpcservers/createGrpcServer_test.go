@@ -0,0 +1,111 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestOperationUnaryServerInterceptor(t *testing.T) {
+	ctx := grpc_ctxtags.SetInContext(context.Background(), grpc_ctxtags.NewTags())
+	_, err := operationUnaryServerInterceptor(
+		ctx,
+		nil,
+		&grpc.UnaryServerInfo{FullMethod: "/cogment.TrialDatastoreSP/RetrieveTrials"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			tagTrialIDs(ctx, "my-trial")
+			return nil, nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"operation": "RetrieveTrials", "trial_id": "my-trial"}, grpc_ctxtags.Extract(ctx).Values())
+}
+
+func TestTagTrialIDs(t *testing.T) {
+	ctx := grpc_ctxtags.SetInContext(context.Background(), grpc_ctxtags.NewTags())
+	tagTrialIDs(ctx)
+	assert.Empty(t, grpc_ctxtags.Extract(ctx).Values())
+
+	tagTrialIDs(ctx, "trial-1", "trial-2")
+	assert.Equal(t, map[string]interface{}{"trial_ids": []string{"trial-1", "trial-2"}}, grpc_ctxtags.Extract(ctx).Values())
+
+	// Without tags in the context, e.g. when the server isn't created with `CreateGrpcServer`, nothing happens
+	tagTrialIDs(context.Background(), "trial-1")
+}
+
+func TestReflectionServer(t *testing.T) {
+	listServices := func(enableReflection bool) ([]string, error) {
+		listener := bufconn.Listen(1024 * 1024)
+		server := CreateGrpcServer(enableReflection)
+		defer server.Stop()
+		b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+		assert.NoError(t, err)
+		defer b.Destroy()
+		assert.NoError(t, RegisterTrialDatastoreServer(server, b))
+		assert.NoError(t, RegisterAdminServer(server, b))
+		go func() {
+			_ = server.Serve(listener)
+		}()
+
+		ctx := context.Background()
+		connection, err := grpc.DialContext(
+			ctx,
+			"bufnet",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+			grpc.WithInsecure(),
+		)
+		assert.NoError(t, err)
+		defer connection.Close()
+
+		stream, err := grpc_reflection_v1alpha.NewServerReflectionClient(connection).ServerReflectionInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = stream.Send(&grpc_reflection_v1alpha.ServerReflectionRequest{
+			MessageRequest: &grpc_reflection_v1alpha.ServerReflectionRequest_ListServices{},
+		})
+		if err != nil {
+			return nil, err
+		}
+		rep, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		services := []string{}
+		for _, service := range rep.GetListServicesResponse().GetService() {
+			services = append(services, service.Name)
+		}
+		return services, nil
+	}
+
+	services, err := listServices(true)
+	assert.NoError(t, err)
+	assert.Contains(t, services, "cogmentAPI.TrialDatastoreSP")
+	assert.Contains(t, services, adminServiceName)
+
+	_, err = listServices(false)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
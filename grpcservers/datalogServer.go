@@ -20,6 +20,7 @@ import (
 
 	"github.com/cogment/cogment-trial-datastore/backend"
 	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/utils"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -28,7 +29,13 @@ import (
 
 type datalogServer struct {
 	grpcapi.UnimplementedDatalogSPServer
-	backend backend.Backend
+	backend          backend.Backend
+	trialIDValidator *utils.TrialIDValidator
+}
+
+// DatalogServerOptions represents the configuration of a DatalogSPServer
+type DatalogServerOptions struct {
+	TrialIDValidator *utils.TrialIDValidator // Validates the ids of the logged trials, nil disables the validation
 }
 
 func actorIdxFromActorName(actorName string, actorIndices map[string]uint32) int32 {
@@ -152,10 +159,47 @@ func (s *datalogServer) RunTrialDatalog(stream grpcapi.DatalogSP_RunTrialDatalog
 	if len(trialIDs) == 0 {
 		return status.Errorf(codes.InvalidArgument, "DatalogServer.RunTrialDatalog: missing required header \"trial-id\"")
 	}
-	trialID := trialIDs[0]
+	trialID, err := s.trialIDValidator.Validate(trialIDs[0])
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "DatalogServer.RunTrialDatalog: %s", err)
+	}
+	tagTrialIDs(ctx, trialID)
 	actorIndices := make(map[string]uint32)
+
+	// Messages are received in the background so that the call can be interrupted, e.g. when the server shuts down,
+	// while waiting for the next message. Each sample is stored before being acknowledged: nothing is lost then.
+	receivedReqs := make(chan *grpcapi.RunTrialDatalogInput)
+	receiveErr := make(chan error, 1)
+	go func() {
+		defer close(receivedReqs)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				receiveErr <- err
+				return
+			}
+			select {
+			case <-ctx.Done():
+				receiveErr <- ctx.Err()
+				return
+			case receivedReqs <- req:
+			}
+		}
+	}()
+	receive := func() (*grpcapi.RunTrialDatalogInput, error) {
+		select {
+		case <-ctx.Done():
+			return nil, status.Errorf(codes.Unavailable, "DatalogServer.RunTrialDatalog: call interrupted, the acknowledged samples are stored")
+		case req, ok := <-receivedReqs:
+			if !ok {
+				return nil, <-receiveErr
+			}
+			return req, nil
+		}
+	}
+
 	// Receive the first element, it should be trial data
-	req, err := stream.Recv()
+	req, err := receive()
 	if err != nil {
 		return err
 	}
@@ -165,7 +209,7 @@ func (s *datalogServer) RunTrialDatalog(stream grpcapi.DatalogSP_RunTrialDatalog
 	}
 	err = s.backend.CreateOrUpdateTrials(ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "log exporter server", Params: trialParams}})
 	if err != nil {
-		return status.Errorf(codes.Internal, "DatalogServer.RunTrialDatalog: internal error %q", err)
+		return backendErrorStatus("DatalogServer.RunTrialDatalog", err)
 	}
 
 	// Acknowledge the handling of the first "params" message
@@ -179,7 +223,7 @@ func (s *datalogServer) RunTrialDatalog(stream grpcapi.DatalogSP_RunTrialDatalog
 	}
 
 	for {
-		req, err := stream.Recv()
+		req, err := receive()
 		if err == io.EOF {
 			return nil
 		}
@@ -213,8 +257,14 @@ func (s *datalogServer) Version(context.Context, *grpcapi.VersionRequest) (*grpc
 
 // RegisterDatalogServer registers a DatalogServer to a gRPC server.
 func RegisterDatalogServer(grpcServer grpc.ServiceRegistrar, backend backend.Backend) error {
+	return RegisterDatalogServerWithOptions(grpcServer, backend, DatalogServerOptions{})
+}
+
+// RegisterDatalogServerWithOptions registers a DatalogServer configured with the given options to a gRPC server.
+func RegisterDatalogServerWithOptions(grpcServer grpc.ServiceRegistrar, backend backend.Backend, options DatalogServerOptions) error {
 	server := &datalogServer{
-		backend: backend,
+		backend:          backend,
+		trialIDValidator: options.TrialIDValidator,
 	}
 
 	grpcapi.RegisterDatalogSPServer(grpcServer, server)
@@ -0,0 +1,162 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var DefaultShutdownGracePeriod = 20 * time.Second
+
+type pendingCall struct {
+	operation string
+	trialID   string
+	startTime time.Time
+}
+
+// Drainer tracks the calls handled by a gRPC server and interrupts its streaming calls when the server shuts down.
+//
+// Interrupted calls adding samples store the samples they received before ending.
+type Drainer struct {
+	drainCtx     context.Context
+	drain        context.CancelFunc
+	mutex        sync.Mutex
+	pendingCalls map[*pendingCall]struct{}
+}
+
+// CreateDrainer creates a drainer, its interceptors need to be registered by the gRPC server
+func CreateDrainer() *Drainer {
+	drainCtx, drain := context.WithCancel(context.Background())
+	return &Drainer{
+		drainCtx:     drainCtx,
+		drain:        drain,
+		pendingCalls: make(map[*pendingCall]struct{}),
+	}
+}
+
+func (d *Drainer) startCall(ctx context.Context, fullMethod string) *pendingCall {
+	call := &pendingCall{
+		operation: operationFromFullMethod(fullMethod),
+		startTime: time.Now(),
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if trialIDs := md.Get("trial-id"); len(trialIDs) > 0 {
+			call.trialID = trialIDs[0]
+		}
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.pendingCalls[call] = struct{}{}
+	return call
+}
+
+func (d *Drainer) endCall(call *pendingCall) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.pendingCalls, call)
+}
+
+// UnaryServerInterceptor tracks the handled unary calls, they are short-lived and therefore never interrupted
+func (d *Drainer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		call := d.startCall(ctx, info.FullMethod)
+		defer d.endCall(call)
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor tracks the handled streaming calls, their context is canceled once the drain starts
+func (d *Drainer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		call := d.startCall(stream.Context(), info.FullMethod)
+		defer d.endCall(call)
+
+		ctx, cancel := context.WithCancel(stream.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-d.drainCtx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		wrappedStream := grpc_middleware.WrapServerStream(stream)
+		wrappedStream.WrappedContext = ctx
+		return handler(srv, wrappedStream)
+	}
+}
+
+// Drain interrupts the ongoing, and any future, streaming calls
+func (d *Drainer) Drain() {
+	d.drain()
+}
+
+// PendingCallsCount returns the number of calls currently handled
+func (d *Drainer) PendingCallsCount() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.pendingCalls)
+}
+
+func (d *Drainer) logPendingCalls() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for call := range d.pendingCalls {
+		log.WithFields(log.Fields{
+			"operation":  call.operation,
+			"trial_id":   call.trialID,
+			"pending_ms": time.Since(call.startTime).Milliseconds(),
+		}).Warn("Call still pending at the end of the shutdown grace period")
+	}
+}
+
+// GracefulStop stops a gRPC server, letting its ongoing calls end for at most the given grace period.
+//
+// The health server, if any, starts reporting `NOT_SERVING`, the server stops accepting new calls and its streaming
+// calls are interrupted by the drainer. If calls are still pending once the grace period expires they are logged and
+// canceled, and an error is returned.
+func GracefulStop(server *grpc.Server, healthServer *HealthServer, drainer *Drainer, gracePeriod time.Duration) error {
+	if healthServer != nil {
+		healthServer.Shutdown()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		server.GracefulStop()
+	}()
+	drainer.Drain()
+
+	gracePeriodTimer := time.NewTimer(gracePeriod)
+	defer gracePeriodTimer.Stop()
+	select {
+	case <-stopped:
+		return nil
+	case <-gracePeriodTimer.C:
+		pendingCallsCount := drainer.PendingCallsCount()
+		drainer.logPendingCalls()
+		server.Stop()
+		return fmt.Errorf("%d calls still pending after the shutdown grace period of %v", pendingCallsCount, gracePeriod)
+	}
+}
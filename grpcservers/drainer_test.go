@@ -0,0 +1,196 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type drainerTestFixture struct {
+	backend    backend.Backend
+	server     *grpc.Server
+	drainer    *Drainer
+	received   chan struct{}
+	connection *grpc.ClientConn
+}
+
+func createDrainerTestFixture(t *testing.T, b backend.Backend) drainerTestFixture {
+	listener := bufconn.Listen(1024 * 1024)
+	fxt := drainerTestFixture{
+		backend:  b,
+		drainer:  CreateDrainer(),
+		received: make(chan struct{}, 1000),
+	}
+	fxt.server = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(fxt.drainer.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(
+			fxt.drainer.StreamServerInterceptor(),
+			func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				return handler(srv, &recvCountingServerStream{ServerStream: ss, onRecv: func() { fxt.received <- struct{}{} }})
+			},
+		),
+	)
+	assert.NoError(t, RegisterTrialDatastoreServer(fxt.server, b))
+	assert.NoError(t, RegisterDatalogServer(fxt.server, b))
+	go func() {
+		_ = fxt.server.Serve(listener)
+	}()
+
+	connection, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	fxt.connection = connection
+	return fxt
+}
+
+func (fxt *drainerTestFixture) destroy() {
+	fxt.connection.Close()
+	fxt.server.Stop()
+	fxt.backend.Destroy()
+}
+
+func (fxt *drainerTestFixture) waitForReceivedMessages(t *testing.T, count int) {
+	for messageIdx := 0; messageIdx < count; messageIdx++ {
+		select {
+		case <-fxt.received:
+		case <-time.After(5 * time.Second):
+			assert.FailNow(t, "messages not received by the server")
+		}
+	}
+}
+
+func TestGracefulStopAddSample(t *testing.T) {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	fxt := createDrainerTestFixture(t, b)
+	defer fxt.destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "trial-id", "my-trial")
+	stream, err := grpcapi.NewTrialDatastoreSPClient(fxt.connection).AddSample(ctx)
+	assert.NoError(t, err)
+	for tickID := uint64(0); tickID < 3; tickID++ {
+		err = stream.Send(&grpcapi.AddSampleRequest{TrialSample: &grpcapi.StoredTrialSample{TickId: tickID, State: grpcapi.TrialState_RUNNING}})
+		assert.NoError(t, err)
+	}
+	fxt.waitForReceivedMessages(t, 3)
+
+	// The stream is still open, draining interrupts it
+	err = GracefulStop(fxt.server, nil, fxt.drainer, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fxt.drainer.PendingCallsCount())
+
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// The received samples were stored
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, trialsInfo.TrialInfos[0].StoredSamplesCount)
+}
+
+func TestGracefulStopRunTrialDatalog(t *testing.T) {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	fxt := createDrainerTestFixture(t, b)
+	defer fxt.destroy()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "trial-id", "my-trial")
+	stream, err := grpcapi.NewDatalogSPClient(fxt.connection).RunTrialDatalog(ctx)
+	assert.NoError(t, err)
+	err = stream.Send(&grpcapi.RunTrialDatalogInput{
+		Msg: &grpcapi.RunTrialDatalogInput_TrialParams{TrialParams: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "my-actor"}}}},
+	})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+	err = stream.Send(&grpcapi.RunTrialDatalogInput{
+		Msg: &grpcapi.RunTrialDatalogInput_Sample{Sample: &grpcapi.DatalogSample{Info: &grpcapi.SampleInfo{TickId: 0}}},
+	})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+
+	// The stream waits for the next sample, draining interrupts it
+	err = GracefulStop(fxt.server, nil, fxt.drainer, 5*time.Second)
+	assert.NoError(t, err)
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// The acknowledged sample was stored
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"my-trial"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, trialsInfo.TrialInfos[0].StoredSamplesCount)
+}
+
+func TestGracefulStopGracePeriodExpired(t *testing.T) {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	slowBackend := &slowBackend{Backend: b, release: make(chan struct{})}
+	fxt := createDrainerTestFixture(t, slowBackend)
+	defer fxt.destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "trial-id", "my-trial")
+	stream, err := grpcapi.NewTrialDatastoreSPClient(fxt.connection).AddSample(ctx)
+	assert.NoError(t, err)
+	err = stream.Send(&grpcapi.AddSampleRequest{TrialSample: &grpcapi.StoredTrialSample{TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+	fxt.waitForReceivedMessages(t, 1)
+
+	// Storing the received sample is blocked by the backend past the grace period
+	err = GracefulStop(fxt.server, nil, fxt.drainer, 100*time.Millisecond)
+	assert.Error(t, err)
+	assert.Equal(t, 1, fxt.drainer.PendingCallsCount())
+
+	close(slowBackend.release)
+}
+
+func TestDrainerInterruptsLaterStreams(t *testing.T) {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	fxt := createDrainerTestFixture(t, b)
+	defer fxt.destroy()
+
+	fxt.drainer.Drain()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "trial-id", "my-trial")
+	stream, err := grpcapi.NewDatalogSPClient(fxt.connection).RunTrialDatalog(ctx)
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Error(t, err)
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthServer reports the status of the services of a gRPC server through the standard `grpc.health.v1.Health` service
+type HealthServer struct {
+	server       *health.Server
+	serviceNames []string
+}
+
+// RegisterHealthServer registers a health server to a gRPC server, reporting its services as `NOT_SERVING` until
+// `SetServing` is called.
+//
+// It should be called once the other services are registered so that their status can be checked individually, the
+// overall status of the server is reported for the empty service name.
+func RegisterHealthServer(grpcServer *grpc.Server) *HealthServer {
+	server := &HealthServer{
+		server:       health.NewServer(),
+		serviceNames: []string{""},
+	}
+	for serviceName := range grpcServer.GetServiceInfo() {
+		server.serviceNames = append(server.serviceNames, serviceName)
+	}
+	server.SetServing(false)
+
+	grpc_health_v1.RegisterHealthServer(grpcServer, server.server)
+	return server
+}
+
+// SetServing sets the status of every service, e.g. `SERVING` once the backend is initialized.
+//
+// It has no effect once `Shutdown` has been called.
+func (s *HealthServer) SetServing(serving bool) {
+	status := grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	for _, serviceName := range s.serviceNames {
+		s.server.SetServingStatus(serviceName, status)
+	}
+}
+
+// Shutdown definitively sets the status of every service to `NOT_SERVING`, it should be called when the server starts
+// draining so that load balancers stop routing calls to it.
+func (s *HealthServer) Shutdown() {
+	s.server.Shutdown()
+}
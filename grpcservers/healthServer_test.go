@@ -0,0 +1,77 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestHealthServer(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := CreateGrpcServer(false)
+	defer server.Stop()
+	backend, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer backend.Destroy()
+	err = RegisterTrialDatastoreServer(server, backend)
+	assert.NoError(t, err)
+	healthServer := RegisterHealthServer(server)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	ctx := context.Background()
+	connection, err := grpc.DialContext(
+		ctx,
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	defer connection.Close()
+	client := grpc_health_v1.NewHealthClient(connection)
+
+	checkStatuses := func(expectedStatus grpc_health_v1.HealthCheckResponse_ServingStatus) {
+		for _, service := range []string{"", "cogmentAPI.TrialDatastoreSP"} {
+			rep, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+			if assert.NoError(t, err) {
+				assert.Equal(t, expectedStatus, rep.Status, service)
+			}
+		}
+	}
+
+	checkStatuses(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	healthServer.SetServing(true)
+	checkStatuses(grpc_health_v1.HealthCheckResponse_SERVING)
+
+	healthServer.Shutdown()
+	checkStatuses(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	// Once shutdown, the status no longer changes
+	healthServer.SetServing(true)
+	checkStatuses(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "unknown.Service"})
+	assert.Error(t, err)
+}
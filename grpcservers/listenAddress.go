@@ -0,0 +1,105 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ListenAddress represents the endpoint the gRPC server listens on, either a tcp address or a unix domain socket
+type ListenAddress struct {
+	Network string // "tcp" or "unix"
+	Address string // "host:port" for tcp, the path of the socket file for unix
+}
+
+// ParseListenAddress parses a listen address expressed as `tcp://host:port`, e.g. "tcp://:9000", or
+// `unix:///path/to.sock`
+func ParseListenAddress(address string) (ListenAddress, error) {
+	for _, network := range []string{"tcp", "unix"} {
+		prefix := network + "://"
+		if !strings.HasPrefix(address, prefix) {
+			continue
+		}
+		listenAddress := ListenAddress{Network: network, Address: strings.TrimPrefix(address, prefix)}
+		if listenAddress.Address == "" {
+			return ListenAddress{}, fmt.Errorf("invalid listen address %q, no %s address defined", address, network)
+		}
+		if network == "tcp" {
+			if _, _, err := net.SplitHostPort(listenAddress.Address); err != nil {
+				return ListenAddress{}, fmt.Errorf("invalid listen address %q, expecting `tcp://host:port` (%w)", address, err)
+			}
+		}
+		return listenAddress, nil
+	}
+	return ListenAddress{}, fmt.Errorf("invalid listen address %q, expecting `tcp://host:port` or `unix:///path/to.sock`", address)
+}
+
+// TCPListenAddress is the listen address of every interface on the given tcp port
+func TCPListenAddress(port int) ListenAddress {
+	return ListenAddress{Network: "tcp", Address: fmt.Sprintf(":%d", port)}
+}
+
+func (a ListenAddress) String() string {
+	return a.Network + "://" + a.Address
+}
+
+// Listen listens on the given address.
+//
+// A stale unix socket file, e.g. left by a crashed server, is removed beforehand, as long as no server accepts
+// connections on it. The socket file is removed once the listener is closed, e.g. by the graceful shutdown of the
+// gRPC server.
+func Listen(address ListenAddress) (net.Listener, error) {
+	if address.Network == "unix" {
+		err := removeStaleSocket(address.Address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen(address.Network, address.Address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", address, err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the unix socket file at the given path if no server accepts connections on it, any other
+// kind of file is left untouched
+func removeStaleSocket(path string) error {
+	fileInfo, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to check the unix socket file %q: %w", path, err)
+	}
+	if fileInfo.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("unable to listen on unix socket %q, the file exists and isn't a socket", path)
+	}
+	connection, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		connection.Close()
+		return fmt.Errorf("unable to listen on unix socket %q, it is already in use", path)
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove the stale unix socket file %q: %w", path, err)
+	}
+	return nil
+}
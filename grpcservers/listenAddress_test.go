@@ -0,0 +1,126 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestParseListenAddress(t *testing.T) {
+	address, err := ParseListenAddress("tcp://:9000")
+	assert.NoError(t, err)
+	assert.Equal(t, ListenAddress{Network: "tcp", Address: ":9000"}, address)
+	assert.Equal(t, "tcp://:9000", address.String())
+	assert.Equal(t, address, TCPListenAddress(9000))
+
+	address, err = ParseListenAddress("tcp://localhost:9000")
+	assert.NoError(t, err)
+	assert.Equal(t, ListenAddress{Network: "tcp", Address: "localhost:9000"}, address)
+
+	address, err = ParseListenAddress("unix:///var/run/datastore.sock")
+	assert.NoError(t, err)
+	assert.Equal(t, ListenAddress{Network: "unix", Address: "/var/run/datastore.sock"}, address)
+	assert.Equal(t, "unix:///var/run/datastore.sock", address.String())
+
+	for _, invalidAddress := range []string{"", ":9000", "localhost:9000", "tcp://", "tcp://localhost", "unix://", "udp://:9000"} {
+		_, err = ParseListenAddress(invalidAddress)
+		assert.Error(t, err, invalidAddress)
+	}
+}
+
+// checkHealthServing serves a health server on the given listener and checks it is reachable using the given target
+func checkHealthServing(t *testing.T, listener net.Listener, target string) {
+	server := CreateGrpcServer(false)
+	defer server.Stop()
+	healthServer := RegisterHealthServer(server)
+	healthServer.SetServing(true)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	connection, err := grpc.DialContext(context.Background(), target, grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer connection.Close()
+	rep, err := grpc_health_v1.NewHealthClient(connection).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, rep.Status)
+	}
+}
+
+func TestListenTCP(t *testing.T) {
+	address, err := ParseListenAddress("tcp://127.0.0.1:0")
+	assert.NoError(t, err)
+	listener, err := Listen(address)
+	assert.NoError(t, err)
+
+	checkHealthServing(t, listener, listener.Addr().String())
+}
+
+func TestListenUnix(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "datastore.sock")
+	address, err := ParseListenAddress("unix://" + socketPath)
+	assert.NoError(t, err)
+	listener, err := Listen(address)
+	assert.NoError(t, err)
+
+	// The socket is in use
+	_, err = Listen(address)
+	assert.Error(t, err)
+
+	checkHealthServing(t, listener, "unix://"+socketPath)
+
+	// Stopping the server removes the socket file
+	_, err = os.Stat(socketPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestListenUnixStaleSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "datastore.sock")
+	staleListener, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	// Simulating a crashed server, leaving its socket file behind
+	staleListener.(*net.UnixListener).SetUnlinkOnClose(false)
+	staleListener.Close()
+	_, err = os.Stat(socketPath)
+	assert.NoError(t, err)
+
+	listener, err := Listen(ListenAddress{Network: "unix", Address: socketPath})
+	assert.NoError(t, err)
+
+	checkHealthServing(t, listener, "unix://"+socketPath)
+}
+
+func TestListenUnixExistingFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "datastore.sock")
+	err := ioutil.WriteFile(filePath, []byte("not a socket"), 0600)
+	assert.NoError(t, err)
+
+	_, err = Listen(ListenAddress{Network: "unix", Address: filePath})
+	assert.Error(t, err)
+
+	// The file is left untouched
+	content, err := ioutil.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "not a socket", string(content))
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"fmt"
+	"math"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultMaxReceivedMessageSize is the maximum size, in bytes, of the messages received by the gRPC server unless
+// specified otherwise, it is the gRPC default.
+var DefaultMaxReceivedMessageSize = 4 * 1024 * 1024
+
+// DefaultMaxSentMessageSize is the maximum size, in bytes, of the messages sent by the gRPC server unless specified
+// otherwise, it is the gRPC default. Clients usually limit the size of the messages they receive to 4MB.
+var DefaultMaxSentMessageSize = math.MaxInt32
+
+// largestPayloadSize returns the size, in bytes, of the largest payload of a sample
+func largestPayloadSize(sample *grpcapi.StoredTrialSample) int {
+	size := 0
+	for _, payload := range sample.Payloads {
+		if len(payload) > size {
+			size = len(payload)
+		}
+	}
+	return size
+}
+
+// checkSentSampleSize checks that a message sending a sample doesn't exceed the maximum sent message size, returning
+// an error describing the sample otherwise
+func checkSentSampleSize(method string, message proto.Message, sample *grpcapi.StoredTrialSample, maxSentMessageSize int) error {
+	if maxSentMessageSize >= DefaultMaxSentMessageSize {
+		// No message can exceed it
+		return nil
+	}
+	messageSize := proto.Size(message)
+	if messageSize <= maxSentMessageSize {
+		return nil
+	}
+	return status.Errorf(
+		codes.ResourceExhausted,
+		"TrialDatastoreSPServer.%s: the sample of trial %q at tick %d is %d bytes, its largest payload being %d bytes, larger than the maximum message size of %d bytes",
+		method,
+		sample.TrialId,
+		sample.TickId,
+		messageSize,
+		largestPayloadSize(sample),
+		maxSentMessageSize,
+	)
+}
+
+// describeReceiveError describes the errors occurring when a message is received, a message larger than the maximum
+// received message size can't be decoded, it is described by its position in the stream.
+//
+// gRPC already ended the call with its own error, including the message size, the description is logged.
+func describeReceiveError(method string, trialID string, lastTickID *uint64, err error) error {
+	if status.Code(err) != codes.ResourceExhausted {
+		return err
+	}
+	position := "the first sample"
+	if lastTickID != nil {
+		position = fmt.Sprintf("the sample following tick %d", *lastTickID)
+	}
+	return status.Errorf(
+		codes.ResourceExhausted,
+		"TrialDatastoreSPServer.%s: %s of trial %q is larger than the maximum message size, the maximum received message size of the server needs to be raised (%s)",
+		method,
+		position,
+		trialID,
+		status.Convert(err).Message(),
+	)
+}
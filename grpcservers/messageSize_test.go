@@ -0,0 +1,58 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"fmt"
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/openlyinc/pointy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckSentSampleSize(t *testing.T) {
+	sample := &grpcapi.StoredTrialSample{TrialId: "my-trial", TickId: 12, Payloads: [][]byte{make([]byte, 100), make([]byte, 2000)}}
+	reply := &grpcapi.RetrieveSampleReply{TrialSample: sample}
+
+	assert.NoError(t, checkSentSampleSize("RetrieveSamples", reply, sample, DefaultMaxSentMessageSize))
+	assert.NoError(t, checkSentSampleSize("RetrieveSamples", reply, sample, 4096))
+
+	err := checkSentSampleSize("RetrieveSamples", reply, sample, 1024)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), `TrialDatastoreSPServer.RetrieveSamples: the sample of trial "my-trial" at tick 12 is`)
+	assert.Contains(t, status.Convert(err).Message(), "its largest payload being 2000 bytes, larger than the maximum message size of 1024 bytes")
+}
+
+func TestDescribeReceiveError(t *testing.T) {
+	tooLargeErr := status.Errorf(codes.ResourceExhausted, "grpc: received message larger than max (2058 vs. 1024)")
+
+	err := describeReceiveError("AddSample", "my-trial", nil, tooLargeErr)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(
+		t,
+		`TrialDatastoreSPServer.AddSample: the first sample of trial "my-trial" is larger than the maximum message size, the maximum received message size of the server needs to be raised (grpc: received message larger than max (2058 vs. 1024))`,
+		status.Convert(err).Message(),
+	)
+
+	err = describeReceiveError("AddSample", "my-trial", pointy.Uint64(3), tooLargeErr)
+	assert.Contains(t, status.Convert(err).Message(), `the sample following tick 3 of trial "my-trial"`)
+
+	// Other errors are left untouched
+	otherErr := fmt.Errorf("other error")
+	assert.Equal(t, otherErr, describeReceiveError("AddSample", "my-trial", nil, otherErr))
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// TickOrderValidation defines how the tick ids of the samples added to a trial are validated
+type TickOrderValidation int
+
+const (
+	// LaxTickOrder adds samples in whichever order they are received
+	LaxTickOrder TickOrderValidation = iota
+	// StrictTickOrder rejects a sample whose tick id isn't strictly greater than the last added tick id of the trial
+	StrictTickOrder
+	// ReorderTicks sorts each chunk of received samples by tick id before validating it following `StrictTickOrder`,
+	// samples received out of order within a chunk are therefore accepted
+	ReorderTicks
+)
+
+// ParseTickOrderValidation parses a tick order validation expressed as either "lax", "strict" or "reorder"
+func ParseTickOrderValidation(validationName string) (TickOrderValidation, error) {
+	switch strings.ToLower(strings.TrimSpace(validationName)) {
+	case "", "lax":
+		return LaxTickOrder, nil
+	case "strict":
+		return StrictTickOrder, nil
+	case "reorder":
+		return ReorderTicks, nil
+	default:
+		return LaxTickOrder, fmt.Errorf("unknown tick order validation %q, expecting one of [lax strict reorder]", validationName)
+	}
+}
+
+// outOfOrderTickError is raised when the tick id of a sample isn't strictly greater than the last added tick id of its trial
+type outOfOrderTickError struct {
+	TrialID    string
+	TickID     uint64
+	LastTickID uint64
+}
+
+func (e *outOfOrderTickError) Error() string {
+	return fmt.Sprintf("out of order tick %d for trial %q, expecting a tick id strictly greater than the last added tick %d", e.TickID, e.TrialID, e.LastTickID)
+}
+
+// Is matches `backend.ErrSampleOutOfOrder`
+func (e *outOfOrderTickError) Is(target error) bool {
+	return target == backend.ErrSampleOutOfOrder
+}
+
+// tickOrderValidator validates the tick order of the chunks of samples successively added to a trial
+type tickOrderValidator struct {
+	validation    TickOrderValidation
+	lastTickID    uint64
+	hasLastTickID bool
+}
+
+// createTickOrderValidator creates a validator for a trial, `lastTickID` is its largest stored tick id, if any
+func createTickOrderValidator(validation TickOrderValidation, lastTickID uint64, hasLastTickID bool) *tickOrderValidator {
+	return &tickOrderValidator{
+		validation:    validation,
+		lastTickID:    lastTickID,
+		hasLastTickID: hasLastTickID,
+	}
+}
+
+// validate validates a chunk of samples, sorting it first when reordering ticks.
+//
+// It returns the number of samples, from the start of the chunk, that can be added and an `outOfOrderTickError` for
+// the first one that can't.
+func (v *tickOrderValidator) validate(samples []*grpcapi.StoredTrialSample) (int, error) {
+	if v.validation == LaxTickOrder {
+		return len(samples), nil
+	}
+	if v.validation == ReorderTicks {
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].TickId < samples[j].TickId })
+	}
+	for sampleIdx, sample := range samples {
+		if v.hasLastTickID && sample.TickId <= v.lastTickID {
+			return sampleIdx, &outOfOrderTickError{TrialID: sample.TrialId, TickID: sample.TickId, LastTickID: v.lastTickID}
+		}
+		v.lastTickID = sample.TickId
+		v.hasLastTickID = true
+	}
+	return len(samples), nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func generateSamplesFromTickIDs(tickIDs ...uint64) []*grpcapi.StoredTrialSample {
+	samples := make([]*grpcapi.StoredTrialSample, len(tickIDs))
+	for sampleIdx, tickID := range tickIDs {
+		samples[sampleIdx] = &grpcapi.StoredTrialSample{TrialId: "my-trial", TickId: tickID}
+	}
+	return samples
+}
+
+func tickIDs(samples []*grpcapi.StoredTrialSample) []uint64 {
+	tickIDs := make([]uint64, len(samples))
+	for sampleIdx, sample := range samples {
+		tickIDs[sampleIdx] = sample.TickId
+	}
+	return tickIDs
+}
+
+func TestTickOrderValidatorInOrder(t *testing.T) {
+	for _, validation := range []TickOrderValidation{LaxTickOrder, StrictTickOrder, ReorderTicks} {
+		v := createTickOrderValidator(validation, 0, false)
+		validSamplesCount, err := v.validate(generateSamplesFromTickIDs(0, 1, 3))
+		assert.NoError(t, err)
+		assert.Equal(t, 3, validSamplesCount)
+		validSamplesCount, err = v.validate(generateSamplesFromTickIDs(4, 12))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, validSamplesCount)
+	}
+}
+
+func TestTickOrderValidatorDuplicateTicks(t *testing.T) {
+	v := createTickOrderValidator(StrictTickOrder, 0, false)
+	validSamplesCount, err := v.validate(generateSamplesFromTickIDs(0, 1, 1, 2))
+	assert.Equal(t, 2, validSamplesCount)
+	var outOfOrderErr *outOfOrderTickError
+	assert.ErrorAs(t, err, &outOfOrderErr)
+	assert.Equal(t, uint64(1), outOfOrderErr.TickID)
+	assert.Equal(t, uint64(1), outOfOrderErr.LastTickID)
+
+	// Duplicates can't be reordered
+	v = createTickOrderValidator(ReorderTicks, 0, false)
+	validSamplesCount, err = v.validate(generateSamplesFromTickIDs(1, 0, 1))
+	assert.Equal(t, 2, validSamplesCount)
+	assert.ErrorAs(t, err, &outOfOrderErr)
+
+	// The stored ticks are taken into account
+	v = createTickOrderValidator(StrictTickOrder, 5, true)
+	validSamplesCount, err = v.validate(generateSamplesFromTickIDs(5))
+	assert.Equal(t, 0, validSamplesCount)
+	assert.ErrorAs(t, err, &outOfOrderErr)
+
+	v = createTickOrderValidator(LaxTickOrder, 5, true)
+	validSamplesCount, err = v.validate(generateSamplesFromTickIDs(5, 5))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, validSamplesCount)
+}
+
+func TestTickOrderValidatorDecreasingTicks(t *testing.T) {
+	v := createTickOrderValidator(StrictTickOrder, 0, false)
+	validSamplesCount, err := v.validate(generateSamplesFromTickIDs(0, 2, 1, 3))
+	assert.Equal(t, 2, validSamplesCount)
+	var outOfOrderErr *outOfOrderTickError
+	assert.ErrorAs(t, err, &outOfOrderErr)
+	assert.Equal(t, uint64(1), outOfOrderErr.TickID)
+	assert.Equal(t, uint64(2), outOfOrderErr.LastTickID)
+
+	// Samples are reordered within a chunk
+	v = createTickOrderValidator(ReorderTicks, 0, false)
+	samples := generateSamplesFromTickIDs(0, 2, 1, 3)
+	validSamplesCount, err = v.validate(samples)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, validSamplesCount)
+	assert.Equal(t, []uint64{0, 1, 2, 3}, tickIDs(samples))
+
+	// But not across chunks
+	validSamplesCount, err = v.validate(generateSamplesFromTickIDs(5, 2))
+	assert.Equal(t, 0, validSamplesCount)
+	assert.ErrorAs(t, err, &outOfOrderErr)
+
+	v = createTickOrderValidator(LaxTickOrder, 0, false)
+	validSamplesCount, err = v.validate(generateSamplesFromTickIDs(3, 2, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, validSamplesCount)
+}
+
+func TestParseTickOrderValidation(t *testing.T) {
+	validation, err := ParseTickOrderValidation("")
+	assert.NoError(t, err)
+	assert.Equal(t, LaxTickOrder, validation)
+
+	validation, err = ParseTickOrderValidation("Strict")
+	assert.NoError(t, err)
+	assert.Equal(t, StrictTickOrder, validation)
+
+	validation, err = ParseTickOrderValidation("reorder")
+	assert.NoError(t, err)
+	assert.Equal(t, ReorderTicks, validation)
+
+	_, err = ParseTickOrderValidation("sorted")
+	assert.Error(t, err)
+}
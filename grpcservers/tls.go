@@ -0,0 +1,107 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSOptions represents the TLS configuration of a gRPC server
+type TLSOptions struct {
+	CertFile     string // PEM encoded certificate of the server
+	KeyFile      string // PEM encoded private key of the server
+	ClientCAFile string // PEM encoded CA certificates, when defined clients are required to present a certificate signed by one of them
+}
+
+// TLSCredentials serves gRPC over TLS, optionally requiring client certificates, using files that can be reloaded
+type TLSCredentials struct {
+	options TLSOptions
+	mutex   sync.RWMutex
+	config  *tls.Config
+}
+
+// CreateTLSCredentials loads the certificates and key defined by the given options
+func CreateTLSCredentials(options TLSOptions) (*TLSCredentials, error) {
+	if options.CertFile == "" || options.KeyFile == "" {
+		return nil, fmt.Errorf("both a certificate and a key are required to serve over TLS")
+	}
+	c := &TLSCredentials{options: options}
+	err := c.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload loads the certificates and key files again, following connections use them while established ones are
+// unaffected. On failure, the previously loaded ones are kept.
+func (c *TLSCredentials) Reload() error {
+	certificate, err := tls.LoadX509KeyPair(c.options.CertFile, c.options.KeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the server certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+	}
+	if c.options.ClientCAFile != "" {
+		clientCAs, err := ioutil.ReadFile(c.options.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("unable to read the client CA certificates: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(clientCAs) {
+			return fmt.Errorf("no valid certificate found in the client CA certificates file %q", c.options.ClientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.config = config
+	return nil
+}
+
+// TransportCredentials returns the credentials to give to the gRPC server, they always use the last loaded files
+func (c *TLSCredentials) TransportCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mutex.RLock()
+			defer c.mutex.RUnlock()
+			return c.config, nil
+		},
+	})
+}
+
+// HTTPConfig returns the tls configuration of the http servers, e.g. the metrics server, it always uses the last
+// loaded files and also accepts HTTP/1.1 clients
+func (c *TLSCredentials) HTTPConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mutex.RLock()
+			defer c.mutex.RUnlock()
+			config := c.config.Clone()
+			config.NextProtos = []string{"h2", "http/1.1"}
+			return config, nil
+		},
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+// generateTestCertificate generates a certificate signed by `parent`, self-signed when `parent` is nil
+func generateTestCertificate(t *testing.T, commonName string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parentCertificate, parentKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parentCertificate, parentKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCertificate, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func (c *testCertificate) tlsCertificate(t *testing.T) tls.Certificate {
+	certificate, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	assert.NoError(t, err)
+	return certificate
+}
+
+func writeTestFile(t *testing.T, directory string, name string, content []byte) string {
+	filePath := filepath.Join(directory, name)
+	assert.NoError(t, ioutil.WriteFile(filePath, content, 0600))
+	return filePath
+}
+
+// createTLSTestServer starts a server whose health is checked by the returned function, dialing with the given config
+func createTLSTestServer(t *testing.T, tlsCredentials *TLSCredentials) (func(clientConfig *tls.Config) error, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := CreateGrpcServerWithOptions(GrpcServerOptions{TLSCredentials: tlsCredentials})
+	RegisterHealthServer(server).SetServing(true)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	checkHealth := func(clientConfig *tls.Config) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		connection, err := grpc.DialContext(
+			ctx,
+			"bufnet",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+			grpc.WithTransportCredentials(credentials.NewTLS(clientConfig)),
+		)
+		if err != nil {
+			return err
+		}
+		defer connection.Close()
+		_, err = grpc_health_v1.NewHealthClient(connection).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		return err
+	}
+	return checkHealth, server.Stop
+}
+
+func TestTLS(t *testing.T) {
+	directory := t.TempDir()
+	ca := generateTestCertificate(t, "test-ca", nil)
+	serverCertificate := generateTestCertificate(t, "localhost", ca)
+
+	tlsCredentials, err := CreateTLSCredentials(TLSOptions{
+		CertFile: writeTestFile(t, directory, "server.crt", serverCertificate.certPEM),
+		KeyFile:  writeTestFile(t, directory, "server.key", serverCertificate.keyPEM),
+	})
+	assert.NoError(t, err)
+	checkHealth, stop := createTLSTestServer(t, tlsCredentials)
+	defer stop()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.certificate)
+	assert.NoError(t, checkHealth(&tls.Config{RootCAs: rootCAs, ServerName: "localhost"}))
+
+	// The server certificate isn't trusted
+	assert.Error(t, checkHealth(&tls.Config{RootCAs: x509.NewCertPool(), ServerName: "localhost"}))
+}
+
+func TestMutualTLS(t *testing.T) {
+	directory := t.TempDir()
+	ca := generateTestCertificate(t, "test-ca", nil)
+	serverCertificate := generateTestCertificate(t, "localhost", ca)
+	clientCertificate := generateTestCertificate(t, "client", ca)
+	otherCA := generateTestCertificate(t, "other-ca", nil)
+	otherClientCertificate := generateTestCertificate(t, "other-client", otherCA)
+
+	tlsCredentials, err := CreateTLSCredentials(TLSOptions{
+		CertFile:     writeTestFile(t, directory, "server.crt", serverCertificate.certPEM),
+		KeyFile:      writeTestFile(t, directory, "server.key", serverCertificate.keyPEM),
+		ClientCAFile: writeTestFile(t, directory, "client-ca.crt", ca.certPEM),
+	})
+	assert.NoError(t, err)
+	checkHealth, stop := createTLSTestServer(t, tlsCredentials)
+	defer stop()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.certificate)
+
+	// With a client certificate signed by the client CA
+	assert.NoError(t, checkHealth(&tls.Config{
+		RootCAs:      rootCAs,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{clientCertificate.tlsCertificate(t)},
+	}))
+
+	// Without any client certificate
+	assert.Error(t, checkHealth(&tls.Config{RootCAs: rootCAs, ServerName: "localhost"}))
+
+	// With a client certificate signed by another CA
+	assert.Error(t, checkHealth(&tls.Config{
+		RootCAs:      rootCAs,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{otherClientCertificate.tlsCertificate(t)},
+	}))
+}
+
+func TestTLSReload(t *testing.T) {
+	directory := t.TempDir()
+	ca := generateTestCertificate(t, "test-ca", nil)
+	serverCertificate := generateTestCertificate(t, "localhost", ca)
+	certFile := writeTestFile(t, directory, "server.crt", serverCertificate.certPEM)
+	keyFile := writeTestFile(t, directory, "server.key", serverCertificate.keyPEM)
+
+	tlsCredentials, err := CreateTLSCredentials(TLSOptions{CertFile: certFile, KeyFile: keyFile})
+	assert.NoError(t, err)
+	checkHealth, stop := createTLSTestServer(t, tlsCredentials)
+	defer stop()
+
+	otherCA := generateTestCertificate(t, "other-ca", nil)
+	otherServerCertificate := generateTestCertificate(t, "localhost", otherCA)
+	otherRootCAs := x509.NewCertPool()
+	otherRootCAs.AddCert(otherCA.certificate)
+	assert.Error(t, checkHealth(&tls.Config{RootCAs: otherRootCAs, ServerName: "localhost"}))
+
+	writeTestFile(t, directory, "server.crt", otherServerCertificate.certPEM)
+	writeTestFile(t, directory, "server.key", otherServerCertificate.keyPEM)
+	assert.NoError(t, tlsCredentials.Reload())
+	assert.NoError(t, checkHealth(&tls.Config{RootCAs: otherRootCAs, ServerName: "localhost"}))
+
+	// Failing reloads keep the previous certificate
+	writeTestFile(t, directory, "server.key", []byte("not a key"))
+	assert.Error(t, tlsCredentials.Reload())
+	assert.NoError(t, checkHealth(&tls.Config{RootCAs: otherRootCAs, ServerName: "localhost"}))
+}
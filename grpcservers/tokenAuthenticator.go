@@ -0,0 +1,234 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TokenScope represents what the holder of an API token is allowed to do
+type TokenScope int
+
+const (
+	NoScope    TokenScope = iota
+	ReadScope             // Retrieve trials and samples
+	WriteScope            // Retrieve, add and delete trials and samples
+)
+
+func (s TokenScope) String() string {
+	switch s {
+	case ReadScope:
+		return "read"
+	case WriteScope:
+		return "write"
+	default:
+		return "none"
+	}
+}
+
+// ParseTokenScope parses a token scope, either "read" or "write"
+func ParseTokenScope(scope string) (TokenScope, error) {
+	switch scope {
+	case "read":
+		return ReadScope, nil
+	case "write":
+		return WriteScope, nil
+	default:
+		return NoScope, fmt.Errorf("unknown token scope %q expecting one of [read write]", scope)
+	}
+}
+
+// methodsScope lists the scope required to call the methods that don't require the write scope.
+//
+// Methods of the unauthenticated services, i.e. health and reflection, don't require any token, every other method
+// requires the write scope.
+var methodsScope = map[string]TokenScope{
+	"/cogmentAPI.TrialDatastoreSP/RetrieveTrials":  ReadScope,
+	"/cogmentAPI.TrialDatastoreSP/RetrieveSamples": ReadScope,
+	"/cogmentAPI.DatalogSP/Version":                ReadScope,
+	adminGetActorRewardStatsFullName:               ReadScope,
+	adminGetTrialResultFullName:                    ReadScope,
+	adminGetSamplePayloadFullName:                  ReadScope,
+}
+
+// unauthenticatedServicesPrefixes lists the services whose methods don't require any token, e.g. to let the
+// orchestrator probe the health of the datastore
+var unauthenticatedServicesPrefixes = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+	"/grpc.reflection.v1.ServerReflection/",
+}
+
+func requiredScope(fullMethod string) TokenScope {
+	if scope, found := methodsScope[fullMethod]; found {
+		return scope
+	}
+	for _, prefix := range unauthenticatedServicesPrefixes {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return NoScope
+		}
+	}
+	return WriteScope
+}
+
+type apiToken struct {
+	token []byte
+	scope TokenScope
+}
+
+// TokenAuthenticator checks the bearer token, sent in the `authorization` metadata of calls, against a set of API tokens
+type TokenAuthenticator struct {
+	tokens []apiToken
+}
+
+// CreateTokenAuthenticator creates an authenticator accepting the given tokens, mapped to their scope
+func CreateTokenAuthenticator(tokens map[string]TokenScope) (*TokenAuthenticator, error) {
+	a := &TokenAuthenticator{tokens: make([]apiToken, 0, len(tokens))}
+	for token, scope := range tokens {
+		if token == "" {
+			return nil, fmt.Errorf("empty api token")
+		}
+		if scope != ReadScope && scope != WriteScope {
+			return nil, fmt.Errorf("invalid scope for an api token, expecting [read write]")
+		}
+		a.tokens = append(a.tokens, apiToken{token: []byte(token), scope: scope})
+	}
+	return a, nil
+}
+
+// ParseTokensFile parses a tokens file, each of its lines defines a token followed by its scope separated by white
+// spaces, e.g. "my-secret-token read". Empty lines, and lines starting with #, are ignored.
+func ParseTokensFile(filePath string) (map[string]TokenScope, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := make(map[string]TokenScope)
+	scanner := bufio.NewScanner(file)
+	for lineIdx := 1; scanner.Scan(); lineIdx++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expecting a token followed by its scope", filePath, lineIdx)
+		}
+		scope, err := ParseTokenScope(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filePath, lineIdx, err)
+		}
+		tokens[fields[0]] = scope
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// tokenScope returns the scope of the given token, `NoScope` if it is unknown
+func (a *TokenAuthenticator) tokenScope(token string) TokenScope {
+	scope := NoScope
+	// Every token is compared, in constant time, to avoid leaking anything through timing
+	for _, apiToken := range a.tokens {
+		if subtle.ConstantTimeCompare(apiToken.token, []byte(token)) == 1 {
+			scope = apiToken.scope
+		}
+	}
+	return scope
+}
+
+// authorize checks that the given `authorization` header values hold a bearer token having the required scope, it
+// returns the code and the message of the failure otherwise
+func (a *TokenAuthenticator) authorize(authorizations []string, requiredScope TokenScope) (codes.Code, string) {
+	if requiredScope == NoScope {
+		return codes.OK, ""
+	}
+	if len(authorizations) == 0 {
+		return codes.Unauthenticated, "missing required header \"authorization\""
+	}
+	const bearerPrefix = "bearer "
+	if len(authorizations[0]) <= len(bearerPrefix) || !strings.EqualFold(authorizations[0][:len(bearerPrefix)], bearerPrefix) {
+		return codes.Unauthenticated, "header \"authorization\" should be a bearer token"
+	}
+
+	scope := a.tokenScope(authorizations[0][len(bearerPrefix):])
+	if scope == NoScope {
+		return codes.Unauthenticated, "invalid api token"
+	}
+	if scope < requiredScope {
+		return codes.PermissionDenied, fmt.Sprintf("requires an api token with the %q scope", requiredScope)
+	}
+	return codes.OK, ""
+}
+
+func (a *TokenAuthenticator) authenticate(ctx context.Context, fullMethod string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	code, message := a.authorize(md.Get("authorization"), requiredScope(fullMethod))
+	if code != codes.OK {
+		return status.Errorf(code, "%s: %s", operationFromFullMethod(fullMethod), message)
+	}
+	return nil
+}
+
+// HTTPHandler wraps an http handler to reject the requests without a token, sent in their `Authorization` header,
+// having the given scope
+func (a *TokenAuthenticator) HTTPHandler(scope TokenScope, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, message := a.authorize(r.Header.Values("Authorization"), scope)
+		switch code {
+		case codes.OK:
+			handler.ServeHTTP(w, r)
+		case codes.PermissionDenied:
+			http.Error(w, message, http.StatusForbidden)
+		default:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, message, http.StatusUnauthorized)
+		}
+	})
+}
+
+// UnaryServerInterceptor rejects the unary calls without a token having the required scope
+func (a *TokenAuthenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.authenticate(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects the streaming calls without a token having the required scope
+func (a *TokenAuthenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.authenticate(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
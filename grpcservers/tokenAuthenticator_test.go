@@ -0,0 +1,153 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	tokenAuthenticator, err := CreateTokenAuthenticator(map[string]TokenScope{
+		"reader-token": ReadScope,
+		"writer-token": WriteScope,
+	})
+	assert.NoError(t, err)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := CreateGrpcServerWithOptions(GrpcServerOptions{TokenAuthenticator: tokenAuthenticator})
+	defer server.Stop()
+	backend, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer backend.Destroy()
+	assert.NoError(t, RegisterTrialDatastoreServer(server, backend))
+	assert.NoError(t, RegisterAdminServer(server, backend))
+	RegisterHealthServer(server).SetServing(true)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	connection, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	defer connection.Close()
+	client := grpcapi.NewTrialDatastoreSPClient(connection)
+
+	withAuthorization := func(authorization string) context.Context {
+		if authorization == "" {
+			return context.Background()
+		}
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", authorization)
+	}
+	retrieveTrials := func(authorization string) error {
+		_, err := client.RetrieveTrials(withAuthorization(authorization), &grpcapi.RetrieveTrialsRequest{})
+		return err
+	}
+	addSample := func(authorization string) error {
+		ctx := metadata.AppendToOutgoingContext(withAuthorization(authorization), "trial-id", "my-trial")
+		stream, err := client.AddSample(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+	deleteTrials := func(authorization string) error {
+		_, err := client.DeleteTrials(withAuthorization(authorization), &grpcapi.DeleteTrialsRequest{TrialIds: []string{"my-trial"}})
+		return err
+	}
+
+	getStorageStats := func(authorization string) error {
+		return connection.Invoke(withAuthorization(authorization), adminGetStorageStatsFullName, &emptypb.Empty{}, &structpb.Struct{})
+	}
+
+	for _, call := range []func(string) error{retrieveTrials, addSample, deleteTrials, getStorageStats} {
+		assert.Equal(t, codes.Unauthenticated, status.Code(call("")))
+		assert.Equal(t, codes.Unauthenticated, status.Code(call("Bearer unknown-token")))
+		assert.Equal(t, codes.Unauthenticated, status.Code(call("writer-token")))
+		assert.NoError(t, call("Bearer writer-token"))
+	}
+
+	// Read scope
+	assert.NoError(t, retrieveTrials("bearer reader-token"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(addSample("Bearer reader-token")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(deleteTrials("Bearer reader-token")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(getStorageStats("Bearer reader-token")))
+	req, err := structpb.NewStruct(map[string]interface{}{"trial_id": "unknown-trial"})
+	assert.NoError(t, err)
+	err = connection.Invoke(withAuthorization("Bearer reader-token"), adminGetActorRewardStatsFullName, req, &structpb.Struct{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	err = connection.Invoke(withAuthorization("Bearer reader-token"), adminGetTrialResultFullName, req, &structpb.Struct{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	err = connection.Invoke(withAuthorization("Bearer reader-token"), adminGetSamplePayloadFullName, req, &structpb.Struct{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Health checks don't require any token
+	_, err = grpc_health_v1.NewHealthClient(connection).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
+
+func TestRequiredScope(t *testing.T) {
+	assert.Equal(t, ReadScope, requiredScope("/cogmentAPI.TrialDatastoreSP/RetrieveSamples"))
+	assert.Equal(t, WriteScope, requiredScope("/cogmentAPI.TrialDatastoreSP/AddSample"))
+	assert.Equal(t, ReadScope, requiredScope(adminGetTrialResultFullName))
+	// Methods that aren't listed require the write scope, whatever their service
+	assert.Equal(t, WriteScope, requiredScope(adminGetStorageStatsFullName))
+	assert.Equal(t, WriteScope, requiredScope("/"+adminServiceName+"/UnlistedMethod"))
+	assert.Equal(t, WriteScope, requiredScope("/someService/SomeMethod"))
+	assert.Equal(t, NoScope, requiredScope("/grpc.health.v1.Health/Check"))
+	assert.Equal(t, NoScope, requiredScope("/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"))
+}
+
+func TestParseTokensFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "tokens")
+	err := ioutil.WriteFile(filePath, []byte("# Training jobs\ntraining-token write\n\n  # Analysts\n  analysts-token\tread\n"), 0600)
+	assert.NoError(t, err)
+	tokens, err := ParseTokensFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]TokenScope{"training-token": WriteScope, "analysts-token": ReadScope}, tokens)
+
+	err = ioutil.WriteFile(filePath, []byte("training-token write\nanalysts-token admin\n"), 0600)
+	assert.NoError(t, err)
+	_, err = ParseTokensFile(filePath)
+	assert.EqualError(t, err, filePath+":2: unknown token scope \"admin\" expecting one of [read write]")
+
+	err = ioutil.WriteFile(filePath, []byte("training-token\n"), 0600)
+	assert.NoError(t, err)
+	_, err = ParseTokensFile(filePath)
+	assert.Error(t, err)
+
+	_, err = CreateTokenAuthenticator(map[string]TokenScope{"": ReadScope})
+	assert.Error(t, err)
+}
@@ -16,77 +16,251 @@ package grpcservers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cogment/cogment-trial-datastore/backend"
 	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/utils"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+// DefaultCombinedRetrievalMaxSamples is the maximum number of samples that can be retrieved along with the trial params
+// unless specified otherwise
+var DefaultCombinedRetrievalMaxSamples = 10000
+
+// DefaultAddSampleBufferSize is the maximum number of samples received through an `AddSample` stream waiting to be
+// added to the backend unless specified otherwise
+var DefaultAddSampleBufferSize = 1000
+
 type trialDatastoreServer struct {
 	grpcapi.UnimplementedTrialDatastoreSPServer
-	backend            backend.Backend
-	addSampleChunkSize int
+	backend             backend.Backend
+	addSampleChunkSize  int
+	addSampleBufferSize int
+	trialIDValidator    *utils.TrialIDValidator
+	tickOrderValidation TickOrderValidation
+	maxSentMessageSize  int
+	addTrialLocks       *utils.TrialLocks // Makes the registration of a trial, checking its existing params, atomic
+	addSampleRateLimit  utils.RateLimit
+	trialRateLimiter    *utils.TrialRateLimiter
+
+	defaultActorClassFields map[string][]grpcapi.StoredTrialSampleField
 }
 
-func (s *trialDatastoreServer) RetrieveTrials(ctx context.Context, req *grpcapi.RetrieveTrialsRequest) (*grpcapi.RetrieveTrialsReply, error) {
-	pageOffset := 0
-	if req.TrialHandle != "" {
-		var err error
-		pageOffset, err = strconv.Atoi(req.TrialHandle)
+// TrialDatastoreServerOptions represents the configuration of a TrialDatastoreSPServer
+type TrialDatastoreServerOptions struct {
+	TrialIDValidator *utils.TrialIDValidator // Validates the ids of the added trials, nil disables the validation
+	// Sample fields retrieved by default for the actors of the given classes, when the request doesn't select any field
+	DefaultActorClassFields map[string][]grpcapi.StoredTrialSampleField
+	// Maximum number of received samples waiting to be added to the backend, per `AddSample` stream, once reached
+	// the stream isn't read anymore until samples are added. 0 means `DefaultAddSampleBufferSize`
+	AddSampleBufferSize int
+	// How the tick order of the samples added through `AddSample` is validated, `LaxTickOrder` by default
+	TickOrderValidation TickOrderValidation
+	// Maximum size, in bytes, of the messages sent by the server, as configured in `GrpcServerOptions`, samples that
+	// don't fit fail the retrieval with a descriptive error. 0 means `DefaultMaxSentMessageSize`
+	MaxSentMessageSize int
+	// Maximum rates at which samples are added to each trial through `AddSample`, unlimited by default. Calls can
+	// override it for their trials using the `samples-rate-limit` and `bytes-rate-limit` header metadata.
+	AddSampleRateLimit utils.RateLimit
+}
+
+// trialSummary represents the storage usage of a trial sent in the `trial-summaries` header metadata
+type trialSummary struct {
+	StoredSamplesCount int     `json:"stored_samples_count"`
+	StoredSamplesSize  int     `json:"stored_samples_size"`
+	MinTickID          *uint64 `json:"min_tick_id"`
+	MaxTickID          *uint64 `json:"max_tick_id"`
+}
+
+// sendTrialCreationTimestamps sends the creation timestamps of the given trials, in nanoseconds since the Unix epoch
+// and 0 when unknown, in the `trial-creation-timestamps` header metadata
+func sendTrialCreationTimestamps(ctx context.Context, trialInfos []*backend.TrialInfo) error {
+	headerMD := metadata.MD{}
+	for _, trialInfo := range trialInfos {
+		createdAt := int64(0)
+		if !trialInfo.CreatedAt.IsZero() {
+			createdAt = trialInfo.CreatedAt.UnixNano()
+		}
+		headerMD.Append("trial-creation-timestamps", strconv.FormatInt(createdAt, 10))
+	}
+	return grpc.SetHeader(ctx, headerMD)
+}
+
+// sendAbandonedTrials sends the ids of the given trials that were abandoned, their `last_state` being `ENDED` like the
+// trials explicitly ended
+func sendAbandonedTrials(ctx context.Context, trialInfos []*backend.TrialInfo) error {
+	headerMD := metadata.MD{}
+	for _, trialInfo := range trialInfos {
+		if trialInfo.Abandoned {
+			headerMD.Append("abandoned-trials", trialInfo.TrialID)
+		}
+	}
+	return grpc.SetHeader(ctx, headerMD)
+}
+
+func sendTrialSummaries(ctx context.Context, trialInfos []*backend.TrialInfo) error {
+	headerMD := metadata.MD{}
+	for _, trialInfo := range trialInfos {
+		summary := trialSummary{
+			StoredSamplesCount: trialInfo.StoredSamplesCount,
+			StoredSamplesSize:  trialInfo.StoredSamplesSize,
+		}
+		if trialInfo.StoredSamplesCount > 0 {
+			minTickID, maxTickID := trialInfo.MinTickID, trialInfo.MaxTickID
+			summary.MinTickID = &minTickID
+			summary.MaxTickID = &maxTickID
+		}
+		serializedSummary, err := json.Marshal(summary)
+		if err != nil {
+			return status.Errorf(codes.Internal, "TrialDatastoreSPServer.RetrieveTrials: unable to serialize trial summary %q", err)
+		}
+		headerMD.Append("trial-summaries", string(serializedSummary))
+	}
+	return grpc.SetHeader(ctx, headerMD)
+}
+
+func sendTrialTags(ctx context.Context, trialInfos []*backend.TrialInfo) error {
+	headerMD := metadata.MD{}
+	for _, trialInfo := range trialInfos {
+		tags := trialInfo.Tags
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		serializedTags, err := json.Marshal(tags)
 		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "Invalid value for `page_handle` (%q) only empty or values provided by a previous call should be used", req.TrialHandle)
+			return status.Errorf(codes.Internal, "TrialDatastoreSPServer.RetrieveTrials: unable to serialize trial tags %q", err)
+		}
+		headerMD.Append("trial-tags", string(serializedTags))
+	}
+	return grpc.SetHeader(ctx, headerMD)
+}
+
+// retrieveTrialsPage retrieves the info of at most `count` trials, 0 meaning no limit, starting at the given offset
+// and returns them along with the offset of the next page.
+//
+// When observing, it waits for new trials to be created until it retrieves `count` trials or the context is done.
+func (s *trialDatastoreServer) retrieveTrialsPage(ctx context.Context, trialIDs []string, offset int, count int, observe bool) ([]*backend.TrialInfo, int, error) {
+	trialInfos := []*backend.TrialInfo{}
+	nextOffset := 0
+	if !observe {
+		results, err := s.backend.RetrieveTrials(ctx, trialIDs, offset, count)
+		if err != nil {
+			return nil, 0, status.Errorf(codes.Internal, "TrialDatastoreSPServer.ObserveSamples: internal error %q", err)
+		}
+		return results.TrialInfos, results.NextTrialIdx, nil
+	}
+
+	observer := make(backend.TrialsInfoObserver)
+	g, observationCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(observer)
+		return s.backend.ObserveTrials(observationCtx, trialIDs, offset, count, observer)
+	})
+	g.Go(func() error {
+		for trialInfoResult := range observer {
+			trialInfos = append(trialInfos, trialInfoResult.TrialInfos...)
+			nextOffset = trialInfoResult.NextTrialIdx
 		}
+		return nil
+	})
+
+	if err := g.Wait(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		// context.DeadlineExceeded errors means the timeout we allocated to retrieve the trials is exceeded
+		return nil, 0, status.Errorf(codes.Internal, "TrialDatastoreSPServer.ObserveSamples: internal error %q", err)
+	}
+	return trialInfos, nextOffset, nil
+}
+
+func (s *trialDatastoreServer) RetrieveTrials(ctx context.Context, req *grpcapi.RetrieveTrialsRequest) (*grpcapi.RetrieveTrialsReply, error) {
+	includeTrialSummaries, err := boolFromHeaderMetadata(ctx, "include-trial-summaries")
+	if err != nil {
+		return nil, err
+	}
+	tags, err := backend.ParseTrialTags(headerMetadataValues(ctx, "trial-tags"))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveTrials: invalid 'trial-tags' header metadata, %s", err)
 	}
+	tagsMatchStr, _, err := optionalHeaderMetadata(ctx, "trial-tags-match")
+	if err != nil {
+		return nil, err
+	}
+	tagsMatch, err := backend.ParseTrialTagsMatch(tagsMatchStr)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveTrials: invalid 'trial-tags-match' header metadata, %s", err)
+	}
+
+	token, err := parseTrialsPageToken(req.TrialHandle)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid value for `trial_handle` (%q) only empty or values provided by a previous call should be used, %s", req.TrialHandle, err)
+	}
+	pageOffset := token.NextTrialIdx
+
+	req.TrialIds = s.trialIDValidator.NormalizeAll(req.TrialIds)
+	tagTrialIDs(ctx, req.TrialIds...)
 
 	trialIds := make([]string, 0, req.TrialsCount)
 	trialInfos := make([]*backend.TrialInfo, 0, req.TrialsCount)
-	nextPageOffset := 0
+	nextToken := *token
 
-	// 1 - Retrieve the trialIds and trialInfos
+	// 1 - Retrieve the trialIds and trialInfos, when filtering by tags the following pages are scanned until enough
+	// matching trials are found
+	observationCtx := ctx
 	if req.Timeout > 0 {
-		ctx, cancelCtx := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Millisecond)
-		defer cancelCtx()
-
-		observer := make(backend.TrialsInfoObserver)
-
-		g, ctx := errgroup.WithContext(ctx)
-		g.Go(func() error {
-			defer close(observer)
-			return s.backend.ObserveTrials(ctx, req.TrialIds, pageOffset, int(req.TrialsCount), observer)
-		})
-		g.Go(func() error {
-			for trialInfoResult := range observer {
-				for _, trialInfo := range trialInfoResult.TrialInfos {
-					trialIds = append(trialIds, trialInfo.TrialID)
-					trialInfos = append(trialInfos, trialInfo)
-				}
-				nextPageOffset = trialInfoResult.NextTrialIdx
-			}
-			return nil
-		})
-
-		if err := g.Wait(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
-			// context.DeadlineExceeded errors means the timeout we allocated to retrieve the trials is exceeded
-			return nil, status.Errorf(codes.Internal, "TrialDatastoreSPServer.ObserveSamples: internal error %q", err)
+		var cancelObservationCtx context.CancelFunc
+		observationCtx, cancelObservationCtx = context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Millisecond)
+		defer cancelObservationCtx()
+	}
+	for {
+		remainingCount := 0
+		if req.TrialsCount > 0 {
+			remainingCount = int(req.TrialsCount) - len(trialInfos)
 		}
-	} else {
-		results, err := s.backend.RetrieveTrials(ctx, req.TrialIds, pageOffset, int(req.TrialsCount))
+		pageTrialInfos, pageNextOffset, err := s.retrieveTrialsPage(observationCtx, req.TrialIds, pageOffset, remainingCount, req.Timeout > 0)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "TrialDatastoreSPServer.ObserveSamples: internal error %q", err)
+			return nil, err
+		}
+		for _, trialInfo := range pageTrialInfos {
+			if token.selects(trialInfo) && backend.MatchesTrialTags(trialInfo.Tags, tags, tagsMatch) {
+				trialIds = append(trialIds, trialInfo.TrialID)
+				trialInfos = append(trialInfos, trialInfo)
+			}
 		}
-		for _, trialInfo := range results.TrialInfos {
-			trialIds = append(trialIds, trialInfo.TrialID)
-			trialInfos = append(trialInfos, trialInfo)
+		nextToken.advance(trialInfos, pageNextOffset)
+		if len(tags) == 0 || remainingCount == 0 || len(pageTrialInfos) < remainingCount || len(trialInfos) >= int(req.TrialsCount) {
+			break
+		}
+		pageOffset = nextToken.NextTrialIdx
+	}
+
+	if includeTrialSummaries {
+		err := sendTrialSummaries(ctx, trialInfos)
+		if err != nil {
+			return nil, err
 		}
-		nextPageOffset = results.NextTrialIdx
+	}
+	err = sendTrialCreationTimestamps(ctx, trialInfos)
+	if err != nil {
+		return nil, err
+	}
+	err = sendTrialTags(ctx, trialInfos)
+	if err != nil {
+		return nil, err
+	}
+	err = sendAbandonedTrials(ctx, trialInfos)
+	if err != nil {
+		return nil, err
 	}
 
 	// 2 - Retrieve the params
@@ -96,11 +270,9 @@ func (s *trialDatastoreServer) RetrieveTrials(ctx context.Context, req *grpcapi.
 			return nil, status.Errorf(codes.Internal, "TrialDatastoreSPServer.ObserveSamples: internal error %q", err)
 		}
 
-		nextTrialHandle := strconv.Itoa(nextPageOffset)
-
 		res := &grpcapi.RetrieveTrialsReply{
 			TrialInfos:      make([]*grpcapi.StoredTrialInfo, len(trialInfos)),
-			NextTrialHandle: nextTrialHandle,
+			NextTrialHandle: nextToken.String(),
 		}
 		for trialInfoIdx, trialInfo := range trialInfos {
 			res.TrialInfos[trialInfoIdx] = &grpcapi.StoredTrialInfo{
@@ -117,114 +289,1104 @@ func (s *trialDatastoreServer) RetrieveTrials(ctx context.Context, req *grpcapi.
 }
 
 func (s *trialDatastoreServer) RetrieveSamples(req *grpcapi.RetrieveSamplesRequest, resStream grpcapi.TrialDatastoreSP_RetrieveSamplesServer) error {
+	requireActions, err := boolFromHeaderMetadata(resStream.Context(), "require-actions")
+	if err != nil {
+		return err
+	}
+	requireAllActions, err := boolFromHeaderMetadata(resStream.Context(), "require-all-actions")
+	if err != nil {
+		return err
+	}
+	includeTrialParams, err := boolFromHeaderMetadata(resStream.Context(), "include-trial-params")
+	if err != nil {
+		return err
+	}
+	defaultMaxSamples := 0
+	if includeTrialParams {
+		defaultMaxSamples = DefaultCombinedRetrievalMaxSamples
+	}
+	maxSamples, err := uintFromHeaderMetadata(resStream.Context(), "max-samples", defaultMaxSamples)
+	if err != nil {
+		return err
+	}
+	receivedRewardSenderIndices, err := int32sFromHeaderMetadata(resStream.Context(), "received-reward-sender-indices")
+	if err != nil {
+		return err
+	}
+	sentRewardReceiverIndices, err := int32sFromHeaderMetadata(resStream.Context(), "sent-reward-receiver-indices")
+	if err != nil {
+		return err
+	}
+	sentMessageReceiverIndices, err := int32sFromHeaderMetadata(resStream.Context(), "sent-message-receiver-indices")
+	if err != nil {
+		return err
+	}
+	broadcastMatchesAllActors, err := boolFromHeaderMetadata(resStream.Context(), "broadcast-matches-all-actors")
+	if err != nil {
+		return err
+	}
+	stripUserData, err := boolFromHeaderMetadata(resStream.Context(), "strip-user-data")
+	if err != nil {
+		return err
+	}
+	compactPayloads, err := boolFromHeaderMetadata(resStream.Context(), "compact-payloads")
+	if err != nil {
+		return err
+	}
+	reverse, err := boolFromHeaderMetadata(resStream.Context(), "reverse")
+	if err != nil {
+		return err
+	}
+	fromTickID, err := optionalUint64FromHeaderMetadata(resStream.Context(), "from-tick-id")
+	if err != nil {
+		return err
+	}
+	toTickID, err := optionalUint64FromHeaderMetadata(resStream.Context(), "to-tick-id")
+	if err != nil {
+		return err
+	}
+	if fromTickID != nil && toTickID != nil && *fromTickID > *toTickID {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: invalid tick range, 'from-tick-id' (%d) is after 'to-tick-id' (%d)", *fromTickID, *toTickID)
+	}
+	minPayloadsSize, err := optionalUintFromHeaderMetadata(resStream.Context(), "min-payloads-size")
+	if err != nil {
+		return err
+	}
+	maxPayloadsSize, err := optionalUintFromHeaderMetadata(resStream.Context(), "max-payloads-size")
+	if err != nil {
+		return err
+	}
+	downsamplingFactor, err := uintFromHeaderMetadata(resStream.Context(), "downsampling-factor", 1)
+	if err != nil {
+		return err
+	}
+	windowsCount, err := uintFromHeaderMetadata(resStream.Context(), "windows-count", 0)
+	if err != nil {
+		return err
+	}
+	if windowsCount > 0 && downsamplingFactor > 1 {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'windows-count' and 'downsampling-factor' header metadata can't be used together")
+	}
+	if windowsCount > 0 && reverse {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'windows-count' and 'reverse' header metadata can't be used together")
+	}
+	randomSamplesCount, err := uintFromHeaderMetadata(resStream.Context(), "random-samples-count", 0)
+	if err != nil {
+		return err
+	}
+	randomSeed, err := optionalUint64FromHeaderMetadata(resStream.Context(), "random-seed")
+	if err != nil {
+		return err
+	}
+	if randomSamplesCount > 0 && windowsCount > 0 {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'random-samples-count' and 'windows-count' header metadata can't be used together")
+	}
+	if randomSamplesCount > 0 && downsamplingFactor > 1 {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'random-samples-count' and 'downsampling-factor' header metadata can't be used together")
+	}
+	if randomSamplesCount > 0 && reverse {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'random-samples-count' and 'reverse' header metadata can't be used together")
+	}
+	if randomSamplesCount == 0 && randomSeed != nil {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'random-seed' header metadata requires 'random-samples-count'")
+	}
+	latestSample, err := boolFromHeaderMetadata(resStream.Context(), "latest-sample")
+	if err != nil {
+		return err
+	}
+	if latestSample && (windowsCount > 0 || randomSamplesCount > 0) {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'latest-sample' can't be used together with 'windows-count' or 'random-samples-count' header metadata")
+	}
+	if latestSample && (downsamplingFactor > 1 || reverse) {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'latest-sample' can't be used together with 'downsampling-factor' or 'reverse' header metadata")
+	}
+	if latestSample && (fromTickID != nil || toTickID != nil) {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'latest-sample' can't be used together with a tick range")
+	}
+	estimateSize, err := boolFromHeaderMetadata(resStream.Context(), "estimate-size")
+	if err != nil {
+		return err
+	}
+	if estimateSize && (windowsCount > 0 || randomSamplesCount > 0 || latestSample) {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'estimate-size' can't be used together with 'windows-count', 'random-samples-count' or 'latest-sample' header metadata")
+	}
+	if estimateSize && (downsamplingFactor > 1 || includeTrialParams) {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: 'estimate-size' can't be used together with 'downsampling-factor' or 'include-trial-params' header metadata")
+	}
+	receivedRewardsAggregationStr, _, err := optionalHeaderMetadata(resStream.Context(), "received-rewards-aggregation")
+	if err != nil {
+		return err
+	}
+	receivedRewardsAggregation, err := backend.ParseRewardAggregation(receivedRewardsAggregationStr)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: invalid 'received-rewards-aggregation' header metadata, %s", err)
+	}
+	req.TrialIds = s.trialIDValidator.NormalizeAll(req.TrialIds)
+	tagTrialIDs(resStream.Context(), req.TrialIds...)
 	filter := backend.TrialSampleFilter{
 		TrialIDs:             req.TrialIds,
 		ActorNames:           req.ActorNames,
 		ActorClasses:         req.ActorClasses,
 		ActorImplementations: req.ActorImplementations,
 		Fields:               req.SelectedSampleFields,
+		RequireActions:       requireActions,
+		RequireAllActions:    requireAllActions,
+
+		ReceivedRewardSenderNames:   headerMetadataValues(resStream.Context(), "received-reward-sender-names"),
+		ReceivedRewardSenderIndices: receivedRewardSenderIndices,
+		SentRewardReceiverNames:     headerMetadataValues(resStream.Context(), "sent-reward-receiver-names"),
+		SentRewardReceiverIndices:   sentRewardReceiverIndices,
+		SentMessageReceiverNames:    headerMetadataValues(resStream.Context(), "sent-message-receiver-names"),
+		SentMessageReceiverIndices:  sentMessageReceiverIndices,
+		BroadcastMatchesAllActors:   broadcastMatchesAllActors,
+		FromTickID:                  fromTickID,
+		ToTickID:                    toTickID,
+		MinPayloadsSize:             minPayloadsSize,
+		MaxPayloadsSize:             maxPayloadsSize,
+		DefaultActorClassFields:     s.defaultActorClassFields,
+		ReceivedRewardsAggregation:  receivedRewardsAggregation,
+		StripUserData:               stripUserData,
+		CompactPayloads:             compactPayloads,
+		Reverse:                     reverse,
+	}
+
+	serializedToken, resumed, err := optionalHeaderMetadata(resStream.Context(), "continuation-token")
+	if err != nil {
+		return err
+	}
+	if resumed && windowsCount > 0 {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: windowed retrievals can't be resumed using a 'continuation-token'")
+	}
+	if resumed && randomSamplesCount > 0 {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: random retrievals can't be resumed using a 'continuation-token'")
+	}
+	if resumed && latestSample {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: latest sample retrievals can't be resumed using a 'continuation-token'")
+	}
+	if resumed && reverse {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: reverse retrievals can't be resumed using a 'continuation-token'")
+	}
+	if resumed && estimateSize {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: size estimations can't be resumed using a 'continuation-token'")
+	}
+	if estimateSize {
+		return s.estimateSamples(resStream, filter)
+	}
+	resumedToken := utils.NewContinuationToken()
+	if resumed {
+		resumedToken, err = s.checkContinuationToken(resStream.Context(), serializedToken, filter.TrialIDs)
+		if err != nil {
+			return err
+		}
+		// Not reading the samples every requested trial already delivered
+		filter.FromTickID = resumedToken.FromTickID(filter.TrialIDs, filter.FromTickID)
+	}
+	token := resumedToken.Clone()
+
+	downsampler := backend.NewTrialSampleDownsampler(uint64(downsamplingFactor), toTickID)
+	if reverse {
+		downsampler = backend.NewReverseTrialSampleDownsampler(uint64(downsamplingFactor), fromTickID)
+	}
+
+	if maxSamples > 0 && filter.SelectsEverySample() && !resumed && downsamplingFactor <= 1 && windowsCount == 0 && randomSamplesCount == 0 && !latestSample {
+		// When every stored sample is delivered, the number of samples to retrieve is known upfront, otherwise the
+		// retrieved samples are counted while streaming
+		trialsInfo, err := s.backend.RetrieveTrials(resStream.Context(), filter.TrialIDs, -1, -1)
+		if err != nil {
+			return status.Errorf(codes.Internal, "TrialDatastoreSPServer.RetrieveSamples: internal error %q", err)
+		}
+		storedSamplesCount := 0
+		for _, trialInfo := range trialsInfo.TrialInfos {
+			storedSamplesCount += trialInfo.StoredSamplesCount
+		}
+		if storedSamplesCount > maxSamples {
+			return status.Errorf(codes.ResourceExhausted, "TrialDatastoreSPServer.RetrieveSamples: the requested trials have %d samples, more than the maximum of %d", storedSamplesCount, maxSamples)
+		}
+	}
+
+	if includeTrialParams {
+		trialsParams, err := s.backend.GetTrialParams(resStream.Context(), filter.TrialIDs)
+		if err != nil {
+			return backendErrorStatus("TrialDatastoreSPServer.RetrieveSamples", err)
+		}
+		headerMD := metadata.MD{}
+		for _, trialParams := range trialsParams {
+			serializedParams, err := proto.Marshal(trialParams.Params)
+			if err != nil {
+				return status.Errorf(codes.Internal, "TrialDatastoreSPServer.RetrieveSamples: unable to serialize trial params %q", err)
+			}
+			headerMD.Append("trial-params-bin", string(serializedParams))
+		}
+		err = resStream.SendHeader(headerMD)
+		if err != nil {
+			return err
+		}
+	}
+
+	if windowsCount > 0 {
+		return s.retrieveSampleWindows(resStream, filter, windowsCount, maxSamples)
+	}
+	if randomSamplesCount > 0 {
+		seed := int64(0)
+		if randomSeed != nil {
+			seed = int64(*randomSeed)
+		}
+		return s.retrieveRandomSamples(resStream, filter, randomSamplesCount, seed, maxSamples)
+	}
+	if latestSample {
+		return s.retrieveLatestSamples(resStream, filter, maxSamples)
 	}
+
 	observer := make(backend.TrialSampleObserver)
-	g, ctx := errgroup.WithContext(resStream.Context())
+	ctx, cancel := context.WithCancel(resStream.Context())
+	defer cancel()
+	var limitErr error // Reaching a limit fails the retrieval with an error that the canceled observation mustn't mask
+	var observeErr error
+	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
-		defer close(observer)
-		return s.backend.ObserveSamples(ctx, filter, observer)
+		observeErr = s.backend.ObserveSamples(ctx, filter, observer)
+		close(observer)
+		return observeErr
 	})
 	g.Go(func() error {
+		samplesCount := 0
+		send := func(sampleResult *grpcapi.StoredTrialSample) error {
+			samplesCount++
+			if maxSamples > 0 && samplesCount > maxSamples {
+				limitErr = status.Errorf(codes.ResourceExhausted, "TrialDatastoreSPServer.RetrieveSamples: the requested trials have more than the maximum of %d samples", maxSamples)
+				return limitErr
+			}
+			reply := &grpcapi.RetrieveSampleReply{TrialSample: sampleResult}
+			limitErr = checkSentSampleSize("RetrieveSamples", reply, sampleResult, s.maxSentMessageSize)
+			if limitErr != nil {
+				return limitErr
+			}
+			err := resStream.Send(reply)
+			if err != nil {
+				return err
+			}
+			token.Advance(sampleResult)
+			return nil
+		}
 		for sampleResult := range observer {
-			err := resStream.Send(&grpcapi.RetrieveSampleReply{TrialSample: sampleResult})
+			if !resumedToken.Selects(sampleResult) {
+				// Already delivered before the retrieval was resumed
+				continue
+			}
+			if !downsampler.Keeps(sampleResult) {
+				continue
+			}
+			err := send(sampleResult)
+			if err != nil {
+				// Stopping the observation and draining the remaining samples
+				cancel()
+				for range observer {
+				}
+				return err
+			}
+		}
+		if observeErr != nil {
+			return nil
+		}
+		// The observation is over, sending the last samples held back by the downsampling
+		for _, sampleResult := range downsampler.Flush() {
+			err := send(sampleResult)
 			if err != nil {
 				return err
 			}
 		}
 		return nil
 	})
-	return g.Wait()
-}
-
-func trialIDFromHeaderMetadata(ctx context.Context) (string, error) {
-	headerMD, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return "", status.Errorf(codes.InvalidArgument, "Missing expected 'trial-id' header metadata defining the id of the trial to add")
-	}
-	trialIDs, ok := headerMD["trial-id"]
-	if !ok || len(trialIDs) != 1 {
-		return "", status.Errorf(codes.InvalidArgument, "Missing expected 'trial-id' header metadata defining the id of the trial to add")
+	err = g.Wait()
+	if !reverse {
+		// Whatever the outcome, letting the client know how to resume the retrieval
+		resStream.SetTrailer(metadata.Pairs("continuation-token", token.String()))
 	}
-	return trialIDs[0], nil
-}
-
-func (s *trialDatastoreServer) AddTrial(ctx context.Context, req *grpcapi.AddTrialRequest) (*grpcapi.AddTrialReply, error) {
-	trialID, err := trialIDFromHeaderMetadata(ctx)
-	if err != nil {
-		return nil, err
+	if limitErr != nil {
+		return limitErr
 	}
-	err = s.backend.CreateOrUpdateTrials(ctx, []*backend.TrialParams{
-		{
-			TrialID: trialID,
-			UserID:  req.UserId,
-			Params:  req.TrialParams,
-		},
-	})
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "TrialDatastoreSPServer.ObserveSamples: internal error %q", err)
+	if code, ok := backendErrorCode(err); ok {
+		return status.Errorf(code, "TrialDatastoreSPServer.RetrieveSamples: %s", err)
 	}
-	return &grpcapi.AddTrialReply{}, nil
+	return err
 }
 
-func (s *trialDatastoreServer) AddSample(stream grpcapi.TrialDatastoreSP_AddSampleServer) error {
-	ctx := stream.Context()
-	trialID, err := trialIDFromHeaderMetadata(ctx)
+// estimateSamples sends, as header metadata, the estimated number and size of the samples the retrieval would send,
+// without sending them
+func (s *trialDatastoreServer) estimateSamples(resStream grpcapi.TrialDatastoreSP_RetrieveSamplesServer, filter backend.TrialSampleFilter) error {
+	estimate, err := s.backend.EstimateSamples(resStream.Context(), filter)
 	if err != nil {
-		return err
+		return backendErrorStatus("TrialDatastoreSPServer.RetrieveSamples", err)
 	}
+	return resStream.SendHeader(metadata.Pairs(
+		"estimated-samples-count", strconv.Itoa(estimate.SamplesCount),
+		"estimated-samples-size", strconv.Itoa(estimate.SamplesSize),
+		"estimate-exact", strconv.FormatBool(estimate.Exact),
+	))
+}
 
-	samplesChunk := make([]*grpcapi.StoredTrialSample, 0, s.addSampleChunkSize)
-	for {
-		req, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
+// retrieveSampleWindows sends the samples of the requested trials aggregated in windows, one trial after the other
+func (s *trialDatastoreServer) retrieveSampleWindows(resStream grpcapi.TrialDatastoreSP_RetrieveSamplesServer, filter backend.TrialSampleFilter, windowsCount int, maxSamples int) error {
+	trialIDs := filter.TrialIDs
+	if len(trialIDs) == 0 {
+		trialsInfo, err := s.backend.RetrieveTrials(resStream.Context(), trialIDs, -1, -1)
 		if err != nil {
-			return err
-		}
-		if req.TrialSample.TrialId != "" && req.TrialSample.TrialId != trialID {
-			return status.Errorf(codes.InvalidArgument, "'AddSampleRequest.TrialSample.trial_id' should be left undefined or should match the header metadata 'trial-id'")
+			return status.Errorf(codes.Internal, "TrialDatastoreSPServer.RetrieveSamples: internal error %q", err)
 		}
-		req.TrialSample.TrialId = trialID
-		samplesChunk = append(samplesChunk, req.TrialSample)
-		if len(samplesChunk) == s.addSampleChunkSize {
-			err = s.backend.AddSamples(ctx, samplesChunk)
-			if err != nil {
-				return status.Errorf(codes.Internal, "TrialDatastoreSPServer.ObserveSamples: internal error %q", err)
-			}
-			samplesChunk = samplesChunk[:0] // Empty the slice while preserving allocated space
+		for _, trialInfo := range trialsInfo.TrialInfos {
+			trialIDs = append(trialIDs, trialInfo.TrialID)
 		}
 	}
 
-	if len(samplesChunk) > 0 {
-		err := s.backend.AddSamples(ctx, samplesChunk)
+	samplesCount := 0
+	for _, trialID := range trialIDs {
+		windowSamples, err := backend.RetrieveSampleWindows(resStream.Context(), s.backend, trialID, windowsCount, filter)
 		if err != nil {
-			return status.Errorf(codes.Internal, "TrialDatastoreSPServer.ObserveSamples: internal error %q", err)
+			return backendErrorStatus("TrialDatastoreSPServer.RetrieveSamples", err)
 		}
-	}
+		for _, windowSample := range windowSamples {
+			samplesCount++
+			if maxSamples > 0 && samplesCount > maxSamples {
+				return status.Errorf(codes.ResourceExhausted, "TrialDatastoreSPServer.RetrieveSamples: the requested trials have more than the maximum of %d samples", maxSamples)
+			}
+			reply := &grpcapi.RetrieveSampleReply{TrialSample: windowSample}
+			err := checkSentSampleSize("RetrieveSamples", reply, windowSample, s.maxSentMessageSize)
+			if err != nil {
+				return err
+			}
+			err = resStream.Send(reply)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retrieveRandomSamples sends a random selection of the samples of the requested trials, one trial after the other
+func (s *trialDatastoreServer) retrieveRandomSamples(resStream grpcapi.TrialDatastoreSP_RetrieveSamplesServer, filter backend.TrialSampleFilter, randomSamplesCount int, seed int64, maxSamples int) error {
+	trialIDs := filter.TrialIDs
+	if len(trialIDs) == 0 {
+		trialsInfo, err := s.backend.RetrieveTrials(resStream.Context(), trialIDs, -1, -1)
+		if err != nil {
+			return status.Errorf(codes.Internal, "TrialDatastoreSPServer.RetrieveSamples: internal error %q", err)
+		}
+		for _, trialInfo := range trialsInfo.TrialInfos {
+			trialIDs = append(trialIDs, trialInfo.TrialID)
+		}
+	}
+
+	samplesCount := 0
+	for _, trialID := range trialIDs {
+		randomSamples, err := backend.RetrieveRandomSamples(resStream.Context(), s.backend, trialID, randomSamplesCount, seed, filter)
+		if err != nil {
+			return backendErrorStatus("TrialDatastoreSPServer.RetrieveSamples", err)
+		}
+		for _, randomSample := range randomSamples {
+			samplesCount++
+			if maxSamples > 0 && samplesCount > maxSamples {
+				return status.Errorf(codes.ResourceExhausted, "TrialDatastoreSPServer.RetrieveSamples: the requested trials have more than the maximum of %d samples", maxSamples)
+			}
+			reply := &grpcapi.RetrieveSampleReply{TrialSample: randomSample}
+			err := checkSentSampleSize("RetrieveSamples", reply, randomSample, s.maxSentMessageSize)
+			if err != nil {
+				return err
+			}
+			err = resStream.Send(reply)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retrieveLatestSamples sends the latest stored sample of each of the selected trials, without waiting for the
+// samples of ongoing trials.
+//
+// Requested trials without stored sample fail the retrieval, they are skipped when every trial is selected.
+func (s *trialDatastoreServer) retrieveLatestSamples(resStream grpcapi.TrialDatastoreSP_RetrieveSamplesServer, filter backend.TrialSampleFilter, maxSamples int) error {
+	trialIDs := filter.TrialIDs
+	if len(trialIDs) == 0 {
+		trialsInfo, err := s.backend.RetrieveTrials(resStream.Context(), trialIDs, -1, -1)
+		if err != nil {
+			return status.Errorf(codes.Internal, "TrialDatastoreSPServer.RetrieveSamples: internal error %q", err)
+		}
+		for _, trialInfo := range trialsInfo.TrialInfos {
+			if trialInfo.StoredSamplesCount > 0 {
+				trialIDs = append(trialIDs, trialInfo.TrialID)
+			}
+		}
+	}
+
+	samplesCount := 0
+	for _, trialID := range trialIDs {
+		latestSample, err := s.backend.RetrieveLatestSample(resStream.Context(), trialID, filter)
+		if err != nil {
+			return backendErrorStatus("TrialDatastoreSPServer.RetrieveSamples", err)
+		}
+		if latestSample == nil {
+			trialsInfo, err := s.backend.RetrieveTrials(resStream.Context(), []string{trialID}, -1, -1)
+			if err != nil {
+				return backendErrorStatus("TrialDatastoreSPServer.RetrieveSamples", err)
+			}
+			if len(trialsInfo.TrialInfos) == 1 && trialsInfo.TrialInfos[0].StoredSamplesCount == 0 {
+				return status.Errorf(codes.NotFound, "TrialDatastoreSPServer.RetrieveSamples: trial %q has no stored sample", trialID)
+			}
+			// The latest sample is filtered out
+			continue
+		}
+		samplesCount++
+		if maxSamples > 0 && samplesCount > maxSamples {
+			return status.Errorf(codes.ResourceExhausted, "TrialDatastoreSPServer.RetrieveSamples: the requested trials have more than the maximum of %d samples", maxSamples)
+		}
+		reply := &grpcapi.RetrieveSampleReply{TrialSample: latestSample}
+		err = checkSentSampleSize("RetrieveSamples", reply, latestSample, s.maxSentMessageSize)
+		if err != nil {
+			return err
+		}
+		err = resStream.Send(reply)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkContinuationToken parses a continuation token and checks that its trials are requested, still exist and have
+// their samples ordered by tick id
+func (s *trialDatastoreServer) checkContinuationToken(ctx context.Context, serializedToken string, requestedTrialIDs []string) (*utils.ContinuationToken, error) {
+	token, err := utils.ParseContinuationToken(serializedToken)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: %s", err)
+	}
+	requestedTrialIDsFilter := utils.NewIDFilter(requestedTrialIDs)
+	tokenTrialIDs := make([]string, 0, len(token.LastTickIDs))
+	for trialID := range token.LastTickIDs {
+		if !requestedTrialIDsFilter.Selects(trialID) {
+			return nil, status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: the continuation token refers to trial %q which isn't requested", trialID)
+		}
+		tokenTrialIDs = append(tokenTrialIDs, trialID)
+	}
+	sort.Strings(tokenTrialIDs)
+	exist, err := s.backend.TrialsExist(ctx, tokenTrialIDs)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "TrialDatastoreSPServer.RetrieveSamples: internal error %q", err)
+	}
+	for trialIdx, trialID := range tokenTrialIDs {
+		if !exist[trialIdx] {
+			return nil, status.Errorf(codes.NotFound, "TrialDatastoreSPServer.RetrieveSamples: trial %q of the continuation token was deleted, the retrieval can't be resumed", trialID)
+		}
+	}
+	// Tokens track the tick ids of the delivered samples, the samples after them are only known in tick order
+	trialsParams, err := s.backend.GetTrialParams(ctx, tokenTrialIDs)
+	if err != nil {
+		return nil, backendErrorStatus("TrialDatastoreSPServer.RetrieveSamples", err)
+	}
+	for _, trialParams := range trialsParams {
+		if !trialParams.SampleOrderingKey.IsTickID() {
+			return nil, status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.RetrieveSamples: trial %q of the continuation token has its samples ordered by %q, the retrieval can't be resumed", trialParams.TrialID, trialParams.SampleOrderingKey)
+		}
+	}
+	return token, nil
+}
+
+func trialIDFromHeaderMetadata(ctx context.Context) (string, error) {
+	headerMD, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", status.Errorf(codes.InvalidArgument, "Missing expected 'trial-id' header metadata defining the id of the trial to add")
+	}
+	trialIDs, ok := headerMD["trial-id"]
+	if !ok || len(trialIDs) != 1 {
+		return "", status.Errorf(codes.InvalidArgument, "Missing expected 'trial-id' header metadata defining the id of the trial to add")
+	}
+	return trialIDs[0], nil
+}
+
+// optionalHeaderMetadata retrieves an optional header metadata
+func optionalHeaderMetadata(ctx context.Context, key string) (string, bool, error) {
+	headerMD, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false, nil
+	}
+	values := headerMD.Get(key)
+	if len(values) == 0 {
+		return "", false, nil
+	}
+	if len(values) > 1 {
+		return "", false, status.Errorf(codes.InvalidArgument, "Unexpected multiple values for the '%s' header metadata", key)
+	}
+	return values[0], true, nil
+}
+
+// headerMetadataValues retrieves the values of an optional header metadata, either specified several times or as a comma-separated list
+func headerMetadataValues(ctx context.Context, key string) []string {
+	headerMD, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return []string{}
+	}
+	values := []string{}
+	for _, value := range headerMD.Get(key) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// int32sFromHeaderMetadata retrieves the integer values of an optional header metadata
+func int32sFromHeaderMetadata(ctx context.Context, key string) ([]int32, error) {
+	strValues := headerMetadataValues(ctx, key)
+	values := make([]int32, len(strValues))
+	for valueIdx, strValue := range strValues {
+		value, err := strconv.ParseInt(strValue, 10, 32)
+		if err != nil {
+			return []int32{}, status.Errorf(codes.InvalidArgument, "Invalid value for the '%s' header metadata (%q) expecting integers", key, strValue)
+		}
+		values[valueIdx] = int32(value)
+	}
+	return values, nil
+}
+
+// boolFromHeaderMetadata retrieves an optional boolean header metadata, defaulting to false
+func boolFromHeaderMetadata(ctx context.Context, key string) (bool, error) {
+	strValue, ok, err := optionalHeaderMetadata(ctx, key)
+	if err != nil || !ok {
+		return false, err
+	}
+	value, err := strconv.ParseBool(strValue)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "Invalid value for the '%s' header metadata (%q) expecting a boolean", key, strValue)
+	}
+	return value, nil
+}
+
+// uintFromHeaderMetadata retrieves an optional positive integer header metadata, defaulting to the given value
+func uintFromHeaderMetadata(ctx context.Context, key string, defaultValue int) (int, error) {
+	strValue, ok, err := optionalHeaderMetadata(ctx, key)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	value, err := strconv.ParseUint(strValue, 10, 31)
+	if err != nil {
+		return defaultValue, status.Errorf(codes.InvalidArgument, "Invalid value for the '%s' header metadata (%q) expecting a positive integer", key, strValue)
+	}
+	return int(value), nil
+}
+
+// optionalUintFromHeaderMetadata retrieves an optional positive integer header metadata, nil when it isn't defined
+func optionalUintFromHeaderMetadata(ctx context.Context, key string) (*int, error) {
+	strValue, ok, err := optionalHeaderMetadata(ctx, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	value, err := strconv.ParseUint(strValue, 10, 31)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid value for the '%s' header metadata (%q) expecting a positive integer", key, strValue)
+	}
+	intValue := int(value)
+	return &intValue, nil
+}
+
+func optionalUint64FromHeaderMetadata(ctx context.Context, key string) (*uint64, error) {
+	strValue, ok, err := optionalHeaderMetadata(ctx, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	value, err := strconv.ParseUint(strValue, 10, 64)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid value for the '%s' header metadata (%q) expecting a positive integer", key, strValue)
+	}
+	return &value, nil
+}
+
+func (s *trialDatastoreServer) AddTrial(ctx context.Context, req *grpcapi.AddTrialRequest) (*grpcapi.AddTrialReply, error) {
+	trialID, err := trialIDFromHeaderMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	trialID, err = s.trialIDValidator.Validate(trialID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.AddTrial: %s", err)
+	}
+	tagTrialIDs(ctx, trialID)
+	sampleOrderingKeyStr, _, err := optionalHeaderMetadata(ctx, "sample-ordering-key")
+	if err != nil {
+		return nil, err
+	}
+	sampleOrderingKey, err := backend.ParseSampleOrderingKey(sampleOrderingKeyStr)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.AddTrial: invalid 'sample-ordering-key' header metadata, %s", err)
+	}
+	tags, err := backend.ParseTrialTags(headerMetadataValues(ctx, "trial-tags"))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.AddTrial: invalid 'trial-tags' header metadata, %s", err)
+	}
+	validateOnly, err := boolFromHeaderMetadata(ctx, "validate-only")
+	if err != nil {
+		return nil, err
+	}
+	if validateOnly {
+		// Dry run, the trial isn't stored
+		headerMD := metadata.MD{}
+		for _, problem := range backend.ValidateTrialParams(req.TrialParams) {
+			headerMD.Append("trial-params-problems", problem.String())
+		}
+		err = grpc.SetHeader(ctx, headerMD)
+		if err != nil {
+			return nil, err
+		}
+		return &grpcapi.AddTrialReply{}, nil
+	}
+	trialParams := &backend.TrialParams{
+		TrialID:           trialID,
+		UserID:            req.UserId,
+		Params:            req.TrialParams,
+		SampleOrderingKey: sampleOrderingKey,
+		Tags:              tags,
+	}
+
+	defer s.addTrialLocks.Lock(trialID)()
+	existingTrialsParams, err := s.backend.GetTrialParams(ctx, []string{trialID})
+	if err == nil {
+		// Registering a trial is idempotent, retrying with the same params succeeds without changing anything
+		differences, err := diffRegisteredTrialParams(existingTrialsParams[0], trialParams)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "TrialDatastoreSPServer.AddTrial: internal error %q", err)
+		}
+		if len(differences) > 0 {
+			return nil, status.Errorf(
+				codes.AlreadyExists,
+				"TrialDatastoreSPServer.AddTrial: trial %q already exists with different params (differing fields: %s)",
+				trialID,
+				strings.Join(differences, ", "),
+			)
+		}
+		return &grpcapi.AddTrialReply{}, nil
+	}
+	// A deleted trial can be registered again
+	if !errors.Is(err, backend.ErrTrialNotFound) && !errors.Is(err, backend.ErrTrialDeleted) {
+		return nil, backendErrorStatus("TrialDatastoreSPServer.AddTrial", err)
+	}
+
+	err = s.backend.CreateOrUpdateTrials(ctx, []*backend.TrialParams{trialParams})
+	if err != nil {
+		return nil, backendErrorStatus("TrialDatastoreSPServer.AddTrial", err)
+	}
+	return &grpcapi.AddTrialReply{}, nil
+}
+
+// diffRegisteredTrialParams returns the fields differing between the registered params of a trial and the params of
+// a new registration, the params themselves being compared using their content hash. Top-level fields of the params
+// are prefixed by "trial_params.".
+func diffRegisteredTrialParams(registered *backend.TrialParams, registration *backend.TrialParams) ([]string, error) {
+	differences := []string{}
+	if registered.UserID != registration.UserID {
+		differences = append(differences, "user_id")
+	}
+	if registered.SampleOrderingKey != registration.SampleOrderingKey {
+		differences = append(differences, "sample-ordering-key")
+	}
+	if !backend.EqualTrialTags(registered.Tags, registration.Tags) {
+		differences = append(differences, "trial-tags")
+	}
+	registeredHash, err := backend.HashTrialParams(registered.Params)
+	if err != nil {
+		return nil, err
+	}
+	registrationHash, err := backend.HashTrialParams(registration.Params)
+	if err != nil {
+		return nil, err
+	}
+	if registeredHash != registrationHash {
+		fields := backend.DiffTrialParams(registered.Params, registration.Params)
+		if len(fields) == 0 {
+			// Only fields unknown to the datastore differ
+			differences = append(differences, "trial_params")
+		}
+		for _, field := range fields {
+			differences = append(differences, "trial_params."+field)
+		}
+	}
+	return differences, nil
+}
+
+// receiveSamples receives the samples of an `AddSample` stream and sends them to `out`, blocking when it is full.
+//
+// Samples are added to the trial defined by `headerTrialID` or, when it is empty, to the trial defined by their own
+// trial id.
+func (s *trialDatastoreServer) receiveSamples(ctx context.Context, stream grpcapi.TrialDatastoreSP_AddSampleServer, headerTrialID string, out chan<- *grpcapi.StoredTrialSample) error {
+	lastTrialID := headerTrialID
+	var lastTickID *uint64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return describeReceiveError("AddSample", lastTrialID, lastTickID, err)
+		}
+		if headerTrialID == "" {
+			if req.TrialSample.TrialId == "" {
+				return status.Errorf(codes.InvalidArgument, "'AddSampleRequest.TrialSample.trial_id' should be defined when the header metadata 'trial-id' isn't")
+			}
+			req.TrialSample.TrialId, err = s.trialIDValidator.Validate(req.TrialSample.TrialId)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.AddSample: %s", err)
+			}
+		} else {
+			if req.TrialSample.TrialId != "" && s.trialIDValidator.Normalize(req.TrialSample.TrialId) != headerTrialID {
+				return status.Errorf(codes.InvalidArgument, "'AddSampleRequest.TrialSample.trial_id' should be left undefined or should match the header metadata 'trial-id'")
+			}
+			req.TrialSample.TrialId = headerTrialID
+		}
+		tickID := req.TrialSample.TickId
+		lastTrialID = req.TrialSample.TrialId
+		lastTickID = &tickID
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- req.TrialSample:
+		}
+	}
+}
+
+// addedTrials tracks the trials to which the samples of an `AddSample` call are added
+type addedTrials struct {
+	trialIDs   []string // In the order in which they are first seen
+	validators map[string]*tickOrderValidator
+	rateLimit  utils.RateLimit // Maximum rates at which the samples of the call are added to each trial
+}
+
+func createAddedTrials(rateLimit utils.RateLimit) *addedTrials {
+	return &addedTrials{
+		trialIDs:   []string{},
+		validators: make(map[string]*tickOrderValidator),
+		rateLimit:  rateLimit,
+	}
+}
+
+// tickOrderValidator returns the validator of the tick order of the samples added to a trial, creating it the first
+// time the trial is seen. Unless ticks are added in any order, it returns an `UnknownTrialError` for unknown trials.
+func (s *trialDatastoreServer) tickOrderValidator(ctx context.Context, trials *addedTrials, trialID string) (*tickOrderValidator, error) {
+	if validator, ok := trials.validators[trialID]; ok {
+		return validator, nil
+	}
+	validator := createTickOrderValidator(s.tickOrderValidation, 0, false)
+	if s.tickOrderValidation != LaxTickOrder {
+		trialsInfo, err := s.backend.RetrieveTrials(ctx, []string{trialID}, -1, -1)
+		if err != nil {
+			return nil, err
+		}
+		if len(trialsInfo.TrialInfos) == 0 {
+			return nil, &backend.UnknownTrialError{TrialID: trialID}
+		}
+		trialInfo := trialsInfo.TrialInfos[0]
+		validator = createTickOrderValidator(s.tickOrderValidation, trialInfo.MaxTickID, trialInfo.StoredSamplesCount > 0)
+	}
+	trials.trialIDs = append(trials.trialIDs, trialID)
+	trials.validators[trialID] = validator
+	return validator, nil
+}
+
+// addSamplesChunk adds the samples of a chunk following the tick order validation of each of their trials, the
+// samples of a trial preceding an out of order one are added
+func (s *trialDatastoreServer) addSamplesChunk(ctx context.Context, trials *addedTrials, samplesChunk []*grpcapi.StoredTrialSample) error {
+	chunkTrialIDs := []string{}
+	samplesByTrial := make(map[string][]*grpcapi.StoredTrialSample)
+	for _, sample := range samplesChunk {
+		if _, ok := samplesByTrial[sample.TrialId]; !ok {
+			chunkTrialIDs = append(chunkTrialIDs, sample.TrialId)
+		}
+		samplesByTrial[sample.TrialId] = append(samplesByTrial[sample.TrialId], sample)
+	}
+
+	validSamples := make([]*grpcapi.StoredTrialSample, 0, len(samplesChunk))
+	var chunkErr error
+	for _, trialID := range chunkTrialIDs {
+		trialSamples := samplesByTrial[trialID]
+		validator, err := s.tickOrderValidator(ctx, trials, trialID)
+		if err != nil {
+			if !errors.Is(err, backend.ErrTrialNotFound) {
+				return backendErrorStatus("TrialDatastoreSPServer.AddSample", err)
+			}
+			if chunkErr == nil {
+				chunkErr = backendErrorStatus("TrialDatastoreSPServer.AddSample", err)
+			}
+			continue
+		}
+		validSamplesCount, validationErr := validator.validate(trialSamples)
+		allowedSamplesCount := 0
+		for allowedSamplesCount < validSamplesCount && s.trialRateLimiter.Allow(trialID, trials.rateLimit, proto.Size(trialSamples[allowedSamplesCount])) {
+			allowedSamplesCount++
+		}
+		validSamples = append(validSamples, trialSamples[:allowedSamplesCount]...)
+		if allowedSamplesCount < validSamplesCount && chunkErr == nil {
+			chunkErr = status.Errorf(codes.ResourceExhausted, "TrialDatastoreSPServer.AddSample: samples are added to trial %q faster than its rate limit of %s, the previous samples of the trial are stored", trialID, trials.rateLimit)
+		}
+		if validationErr != nil && chunkErr == nil {
+			chunkErr = status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.AddSample: %s, the previous samples of the trial are stored", validationErr)
+		}
+	}
+
+	if len(validSamples) > 0 {
+		err := s.backend.AddSamples(ctx, validSamples)
+		if err != nil {
+			return backendErrorStatus("TrialDatastoreSPServer.AddSample", err)
+		}
+	}
+	return chunkErr
+}
+
+// addSampleRateLimitFromHeaderMetadata retrieves the rate limit of an `AddSample` call, the `samples-rate-limit` and
+// `bytes-rate-limit` header metadata can lower the default limits, they can't raise them and 0 keeps them
+func (s *trialDatastoreServer) addSampleRateLimitFromHeaderMetadata(ctx context.Context) (utils.RateLimit, error) {
+	rateLimit := s.addSampleRateLimit
+	samplesPerSecond, err := optionalUintFromHeaderMetadata(ctx, "samples-rate-limit")
+	if err != nil {
+		return rateLimit, err
+	}
+	if samplesPerSecond != nil {
+		rateLimit.SamplesPerSecond = lowerRate(rateLimit.SamplesPerSecond, *samplesPerSecond)
+	}
+	bytesPerSecond, err := optionalUintFromHeaderMetadata(ctx, "bytes-rate-limit")
+	if err != nil {
+		return rateLimit, err
+	}
+	if bytesPerSecond != nil {
+		rateLimit.BytesPerSecond = lowerRate(rateLimit.BytesPerSecond, *bytesPerSecond)
+	}
+	return rateLimit, nil
+}
+
+// lowerRate returns the lowest of the given rates, 0 meaning unlimited
+func lowerRate(rate int, overridingRate int) int {
+	if overridingRate <= 0 {
+		return rate
+	}
+	if rate <= 0 || overridingRate < rate {
+		return overridingRate
+	}
+	return rate
+}
+
+// trialResultFromHeaderMetadata parses the trial result defined by the `trial-result-scores` and `trial-result-bin`
+// header metadata, nil if neither is defined
+func trialResultFromHeaderMetadata(ctx context.Context) (*backend.TrialResult, error) {
+	scores, err := backend.ParseTrialResultScores(headerMetadataValues(ctx, "trial-result-scores"))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.AddSample: invalid 'trial-result-scores' header metadata, %s", err)
+	}
+	data, dataDefined, err := optionalHeaderMetadata(ctx, "trial-result-bin")
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) == 0 && !dataDefined {
+		return nil, nil
+	}
+	result := &backend.TrialResult{}
+	if len(scores) > 0 {
+		result.Scores = scores
+	}
+	if dataDefined {
+		result.Data = []byte(data)
+	}
+	return result, nil
+}
+
+func (s *trialDatastoreServer) AddSample(stream grpcapi.TrialDatastoreSP_AddSampleServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	headerTrialID, _, err := optionalHeaderMetadata(ctx, "trial-id")
+	if err != nil {
+		return err
+	}
+	rateLimit, err := s.addSampleRateLimitFromHeaderMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	trials := createAddedTrials(rateLimit)
+	if headerTrialID != "" {
+		headerTrialID, err = s.trialIDValidator.Validate(headerTrialID)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.AddSample: %s", err)
+		}
+		tagTrialIDs(ctx, headerTrialID)
+	} else {
+		defer func() { tagTrialIDs(ctx, trials.trialIDs...) }()
+	}
+	endTrial, err := boolFromHeaderMetadata(ctx, "end-trial")
+	if err != nil {
+		return err
+	}
+	trialResult, err := trialResultFromHeaderMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	if trialResult != nil && (!endTrial || headerTrialID == "") {
+		return status.Errorf(codes.InvalidArgument, "TrialDatastoreSPServer.AddSample: a trial result can only be set when ending the trial defined by the 'trial-id' header metadata")
+	}
+
+	// Samples are received while the previous ones are added to the backend, as the buffer is bounded the stream stops
+	// being read when the backend is too slow which lets gRPC flow control slow down the client.
+	receivedSamples := make(chan *grpcapi.StoredTrialSample, s.addSampleBufferSize)
+	receiveErr := make(chan error, 1)
+	receiverDone := make(chan struct{})
+	go func() {
+		defer close(receiverDone)
+		defer close(receivedSamples)
+		receiveErr <- s.receiveSamples(ctx, stream, headerTrialID, receivedSamples)
+	}()
+	// The stream mustn't be read once the call returns, including when the backend fails, the receiver is stopped and
+	// waited for. It is blocked in `stream.Recv` until the next sample or the end of the stream, only an interrupted
+	// call doesn't wait for it, `stream.Recv` then fails once the call returns.
+	defer func() {
+		cancel()
+		select {
+		case <-receiverDone:
+		case <-stream.Context().Done():
+		}
+	}()
+
+	samplesChunk := make([]*grpcapi.StoredTrialSample, 0, s.addSampleChunkSize)
+receiveLoop:
+	for {
+		select {
+		case sample, ok := <-receivedSamples:
+			if !ok {
+				break receiveLoop
+			}
+			samplesChunk = append(samplesChunk, sample)
+			// Samples are stored as soon as no other is waiting, for ongoing retrievals to follow them with a minimal
+			// lag, unless they are reordered which requires full chunks
+			if len(samplesChunk) == s.addSampleChunkSize || (len(receivedSamples) == 0 && s.tickOrderValidation != ReorderTicks) {
+				err = s.addSamplesChunk(ctx, trials, samplesChunk)
+				if err != nil {
+					return err
+				}
+				samplesChunk = samplesChunk[:0] // Empty the slice while preserving allocated space
+			}
+		case <-ctx.Done():
+			// The call is interrupted, e.g. when the server shuts down, the already received samples are still stored
+			for bufferedSamplesCount := len(receivedSamples); bufferedSamplesCount > 0; bufferedSamplesCount-- {
+				sample, ok := <-receivedSamples
+				if !ok {
+					break
+				}
+				samplesChunk = append(samplesChunk, sample)
+			}
+			if len(samplesChunk) > 0 {
+				err := s.addSamplesChunk(context.Background(), trials, samplesChunk)
+				if err != nil {
+					return err
+				}
+			}
+			return status.Errorf(codes.Unavailable, "TrialDatastoreSPServer.AddSample: call interrupted, the samples received until then are stored")
+		}
+	}
+	err = <-receiveErr
+	if err != nil {
+		return err
+	}
+
+	if len(samplesChunk) > 0 {
+		err := s.addSamplesChunk(ctx, trials, samplesChunk)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Without the `trial-id` header metadata, the trials to which samples were added are ended
+	endedTrialIDs := trials.trialIDs
+	if headerTrialID != "" {
+		endedTrialIDs = []string{headerTrialID}
+	}
+	if trialResult != nil {
+		// Setting the result first, it is available as soon as the trial is seen as ended
+		err := s.backend.SetTrialResult(ctx, headerTrialID, trialResult)
+		if err != nil {
+			return backendErrorStatus("TrialDatastoreSPServer.AddSample", err)
+		}
+	}
+	if endTrial && len(endedTrialIDs) > 0 {
+		err := s.backend.EndTrials(ctx, endedTrialIDs)
+		if err != nil {
+			return backendErrorStatus("TrialDatastoreSPServer.AddSample", err)
+		}
+	}
 
 	return stream.SendAndClose(&grpcapi.AddSamplesReply{})
 }
 
 func (s *trialDatastoreServer) DeleteTrials(ctx context.Context, req *grpcapi.DeleteTrialsRequest) (*grpcapi.DeleteTrialsReply, error) {
-	err := s.backend.DeleteTrials(ctx, req.TrialIds)
+	trialIDPrefix, _, err := optionalHeaderMetadata(ctx, "trial-id-prefix")
+	if err != nil {
+		return nil, err
+	}
+	strict, err := boolFromHeaderMetadata(ctx, "strict")
+	if err != nil {
+		return nil, err
+	}
+	createdBefore, err := optionalUint64FromHeaderMetadata(ctx, "created-before")
+	if err != nil {
+		return nil, err
+	}
+
+	filter := backend.TrialsDeletionFilter{
+		TrialIDPrefix: trialIDPrefix,
+		Strict:        strict,
+	}
+	if createdBefore != nil {
+		filter.CreatedBefore = time.Unix(0, int64(*createdBefore))
+	}
+	if len(req.TrialIds) > 0 || (trialIDPrefix == "" && createdBefore == nil) {
+		// Without a prefix or a creation time, no trial ids means no deleted trial
+		filter.TrialIDs = s.trialIDValidator.NormalizeAll(req.TrialIds)
+	}
+	result, err := backend.DeleteTrialsMatching(ctx, s.backend, filter)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "TrialDatastoreSPServer.DeleteTrials: internal error %q", err)
+		return nil, backendErrorStatus("TrialDatastoreSPServer.DeleteTrials", err)
+	}
+	tagTrialIDs(ctx, result.DeletedTrialIDs...)
+
+	err = grpc.SetHeader(ctx, metadata.Pairs(
+		"deleted-trials-count", strconv.Itoa(len(result.DeletedTrialIDs)),
+		"deleted-samples-count", strconv.Itoa(result.DeletedSamplesCount),
+	))
+	if err != nil {
+		return nil, err
 	}
 	return &grpcapi.DeleteTrialsReply{}, nil
 }
 
 // RegisterTrialDatastoreServer registers an TrialDatastoreSPServer to a gRPC server.
 func RegisterTrialDatastoreServer(grpcServer grpc.ServiceRegistrar, backend backend.Backend) error {
+	return RegisterTrialDatastoreServerWithOptions(grpcServer, backend, TrialDatastoreServerOptions{})
+}
+
+// RegisterTrialDatastoreServerWithOptions registers an TrialDatastoreSPServer configured with the given options to a gRPC server.
+func RegisterTrialDatastoreServerWithOptions(grpcServer grpc.ServiceRegistrar, backend backend.Backend, options TrialDatastoreServerOptions) error {
 	server := &trialDatastoreServer{
-		backend:            backend,
-		addSampleChunkSize: 100,
+		backend:             backend,
+		addSampleChunkSize:  100,
+		addSampleBufferSize: options.AddSampleBufferSize,
+		trialIDValidator:    options.TrialIDValidator,
+		tickOrderValidation: options.TickOrderValidation,
+		maxSentMessageSize:  options.MaxSentMessageSize,
+		addSampleRateLimit:  options.AddSampleRateLimit,
+		trialRateLimiter:    utils.CreateTrialRateLimiter(),
+		addTrialLocks:       utils.CreateTrialLocks(),
+
+		defaultActorClassFields: options.DefaultActorClassFields,
+	}
+
+	if server.addSampleBufferSize <= 0 {
+		server.addSampleBufferSize = DefaultAddSampleBufferSize
+	}
+	if server.maxSentMessageSize <= 0 {
+		server.maxSentMessageSize = DefaultMaxSentMessageSize
 	}
 
 	grpcapi.RegisterTrialDatastoreSPServer(grpcServer, server)
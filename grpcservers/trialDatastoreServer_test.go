@@ -16,23 +16,33 @@ package grpcservers
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/cogment/cogment-trial-datastore/backend"
 	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
 	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/utils"
+	"github.com/openlyinc/pointy"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 )
 
 type trialDatastoreServerTestFixture struct {
@@ -43,13 +53,21 @@ type trialDatastoreServerTestFixture struct {
 }
 
 func createTrialDatastoreServerTestFixture() (trialDatastoreServerTestFixture, error) {
+	return createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{})
+}
+
+func createTrialDatastoreServerTestFixtureWithOptions(options TrialDatastoreServerOptions, dialOptions ...grpc.DialOption) (trialDatastoreServerTestFixture, error) {
+	return createTrialDatastoreServerTestFixtureWithServerOptions(GrpcServerOptions{}, options, dialOptions...)
+}
+
+func createTrialDatastoreServerTestFixtureWithServerOptions(serverOptions GrpcServerOptions, options TrialDatastoreServerOptions, dialOptions ...grpc.DialOption) (trialDatastoreServerTestFixture, error) {
 	listener := bufconn.Listen(1024 * 1024)
-	server := CreateGrpcServer(false)
+	server := CreateGrpcServerWithOptions(serverOptions)
 	backend, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
 	if err != nil {
 		return trialDatastoreServerTestFixture{}, err
 	}
-	err = RegisterTrialDatastoreServer(server, backend)
+	err = RegisterTrialDatastoreServerWithOptions(server, backend, options)
 	if err != nil {
 		return trialDatastoreServerTestFixture{}, err
 	}
@@ -65,7 +83,8 @@ func createTrialDatastoreServerTestFixture() (trialDatastoreServerTestFixture, e
 
 	ctx := context.Background()
 
-	connection, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	dialOptions = append(dialOptions, grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	connection, err := grpc.DialContext(ctx, "bufnet", dialOptions...)
 	if err != nil {
 		return trialDatastoreServerTestFixture{}, err
 	}
@@ -132,409 +151,2953 @@ func TestListenToTrialSequential(t *testing.T) {
 	}
 }
 
-func TestListenToTrialConcurrentTrials(t *testing.T) {
+func TestRetrieveTrialsIncludeTrialSummaries(t *testing.T) {
 	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
 	defer fxt.destroy()
 
-	wg := sync.WaitGroup{}
-	createAndSamplesToTrial := func(trialID string, ticks []uint64, delay time.Duration) {
-		defer wg.Done()
-		err := fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "test", Params: &grpcapi.TrialParams{MaxSteps: 72}}})
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{
+			{TrialID: "trial-1", UserID: "foo", Params: &grpcapi.TrialParams{MaxSteps: 12}},
+			{TrialID: "trial-2", UserID: "foo", Params: &grpcapi.TrialParams{MaxSteps: 12}},
+		})
+		assert.NoError(t, err)
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: "trial-1", TickId: 3, State: grpcapi.TrialState_RUNNING},
+			{TrialId: "trial-1", TickId: 5, State: grpcapi.TrialState_ENDED},
+		})
 		assert.NoError(t, err)
-
-		for tickIdx, tickID := range ticks {
-			time.Sleep(delay)
-			if tickIdx < len(ticks)-1 {
-				err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: trialID, UserId: "test", TickId: tickID, State: grpcapi.TrialState_RUNNING}})
-				assert.NoError(t, err)
-			} else {
-				err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: trialID, UserId: "test", TickId: tickID, State: grpcapi.TrialState_ENDED}})
-				assert.NoError(t, err)
-			}
-		}
 	}
-	trial1Ticks := []uint64{1, 2, 3, 4, 5, 6, 7}
-	trial2Ticks := []uint64{8, 9, 10, 11, 12, 13, 14}
-	wg.Add(1)
-	go createAndSamplesToTrial("trial1", trial1Ticks, 10*time.Millisecond)
-	wg.Add(1)
-	go createAndSamplesToTrial("trial2", trial2Ticks, 5*time.Millisecond)
-
-	retrieveSamples := func(trialIDs []string, expectedSampleTicks []uint64, delay time.Duration) {
-		defer wg.Done()
-		retrievedSamplesTicks := []uint64{}
-
-		stream, err := fxt.client.RetrieveSamples(fxt.ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: trialIDs})
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "include-trial-summaries", "true")
+		var headerMD metadata.MD
+		rep, err := fxt.client.RetrieveTrials(ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: []string{"trial-1", "trial-2"}}, grpc.Header(&headerMD))
 		assert.NoError(t, err)
+		assert.Len(t, rep.TrialInfos, 2)
+
+		summaries := headerMD.Get("trial-summaries")
+		assert.Len(t, summaries, 2)
+		assert.JSONEq(t, fmt.Sprintf(`{"stored_samples_count":2,"stored_samples_size":%d,"min_tick_id":3,"max_tick_id":5}`,
+			proto.Size(&grpcapi.StoredTrialSample{TrialId: "trial-1", TickId: 3, State: grpcapi.TrialState_RUNNING})+
+				proto.Size(&grpcapi.StoredTrialSample{TrialId: "trial-1", TickId: 5, State: grpcapi.TrialState_ENDED}),
+		), summaries[0])
+		assert.JSONEq(t, `{"stored_samples_count":0,"stored_samples_size":0,"min_tick_id":null,"max_tick_id":null}`, summaries[1])
+	}
+	{
+		var headerMD metadata.MD
+		_, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: []string{"trial-1"}}, grpc.Header(&headerMD))
+		assert.NoError(t, err)
+		assert.Empty(t, headerMD.Get("trial-summaries"))
+	}
+}
 
-		for {
-			time.Sleep(delay)
-			msg, err := stream.Recv()
-
-			if len(retrievedSamplesTicks) == len(expectedSampleTicks) {
-				// Should have received everything
-				msg, err = stream.Recv()
-				assert.Equal(t, io.EOF, err)
-				assert.Nil(t, msg)
-				break
-			}
+func TestRetrieveSamplesRequireActions(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
 
-			assert.NoError(t, err)
-			sample := msg.GetTrialSample()
-			assert.NotNil(t, sample)
-			assert.Equal(t, "test", sample.UserId)
-			assert.Contains(t, trialIDs, sample.TrialId)
-			assert.Contains(t, expectedSampleTicks, sample.TickId)
-			retrievedSamplesTicks = append(retrievedSamplesTicks, sample.TickId)
-		}
+	trialID := "mytrial"
 
-		assert.ElementsMatch(t, expectedSampleTicks, retrievedSamplesTicks)
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}}})
+		assert.NoError(t, err)
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0}}},
+			{TrialId: trialID, TickId: 1, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Action: pointy.Uint32(0)}}, Payloads: [][]byte{[]byte("an action")}},
+			{TrialId: trialID, TickId: 2, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0}}},
+		})
+		assert.NoError(t, err)
 	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "require-actions", "true")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
 
-	wg.Add(1)
-	go retrieveSamples([]string{"trial1", "trial2"}, append(trial1Ticks, trial2Ticks...), 100*time.Millisecond)
-	wg.Add(1)
-	go retrieveSamples([]string{"trial1"}, trial1Ticks, 10*time.Millisecond)
-	wg.Add(1)
-	go retrieveSamples([]string{"trial2"}, trial2Ticks, 60*time.Millisecond)
-
-	wg.Wait()
-}
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), msg.GetTrialSample().TickId)
 
-func createTrials(t *testing.T, fxt *trialDatastoreServerTestFixture, trialCount int) {
-	for i := 0; i < trialCount; i++ {
-		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", fmt.Sprintf("trial%d", i))
-		_, err := fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{
-			UserId: "test",
-			TrialParams: &grpcapi.TrialParams{
-				MaxSteps: uint32(10 * (i + 1)),
-			},
-		})
+		msg, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+		assert.Nil(t, msg)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "require-actions", "not-a-boolean")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
 		assert.NoError(t, err)
 
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
 	}
 }
 
-func TestAddAndListTrials(t *testing.T) {
+func TestRetrieveSamplesRequireAllActions(t *testing.T) {
 	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
 	defer fxt.destroy()
 
-	createTrials(t, &fxt, 10)
+	trialID := "mytrial"
 
-	// Retrieve everything in one page
-	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{})
-	assert.NoError(t, err)
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}, {Name: "bar"}}}}})
+		assert.NoError(t, err)
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0}, {Actor: 1}}},
+			{TrialId: trialID, TickId: 1, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Action: pointy.Uint32(0)}, {Actor: 1}}, Payloads: [][]byte{[]byte("an action")}},
+			{TrialId: trialID, TickId: 2, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Action: pointy.Uint32(0)}, {Actor: 1, Action: pointy.Uint32(1)}}, Payloads: [][]byte{[]byte("an action"), []byte("another action")}},
+		})
+		assert.NoError(t, err)
+	}
+	retrieveTickIDs := func(ctx context.Context, actorNames []string) []uint64 {
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}, ActorNames: actorNames})
+		assert.NoError(t, err)
 
-	assert.Len(t, rep.TrialInfos, 10)
-	for i := 0; i < 10; i++ {
-		assert.Equal(t, fmt.Sprintf("trial%d", i), rep.TrialInfos[i].TrialId)
-		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
-		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+		tickIDs := []uint64{}
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return tickIDs
+			}
+			assert.NoError(t, err)
+			tickIDs = append(tickIDs, msg.GetTrialSample().TickId)
+		}
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "require-all-actions", "true")
+		assert.Equal(t, []uint64{2}, retrieveTickIDs(ctx, nil))
+		assert.Equal(t, []uint64{1, 2}, retrieveTickIDs(ctx, []string{"foo"}))
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "require-actions", "true")
+		assert.Equal(t, []uint64{1, 2}, retrieveTickIDs(ctx, nil))
 	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "require-all-actions", "not-a-boolean")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
 
-	assert.Equal(t, "10", rep.NextTrialHandle)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
 }
 
-func TestAddAndListTrialsPaginated(t *testing.T) {
+func TestRetrieveSamplesPayloadsSize(t *testing.T) {
 	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
 	defer fxt.destroy()
 
-	createTrials(t, &fxt, 10)
+	trialID := "mytrial"
 
-	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialsCount: 5})
-	assert.NoError(t, err)
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}}})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID, observationSize := range []int{10, 1000, 20, 2000} {
+			samples = append(samples, &grpcapi.StoredTrialSample{
+				TrialId:      trialID,
+				TickId:       uint64(tickID),
+				State:        grpcapi.TrialState_RUNNING,
+				ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Observation: pointy.Uint32(0), Action: pointy.Uint32(1)}},
+				Payloads:     [][]byte{make([]byte, observationSize), make([]byte, 500)},
+			})
+		}
+		samples[len(samples)-1].State = grpcapi.TrialState_ENDED
+		err = fxt.backend.AddSamples(fxt.ctx, samples)
+		assert.NoError(t, err)
+	}
+	{
+		// Only considering the observations
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "min-payloads-size", "100")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{
+			TrialIds:             []string{trialID},
+			SelectedSampleFields: []grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION},
+		})
+		assert.NoError(t, err)
 
-	assert.Len(t, rep.TrialInfos, 5)
-	for i := 0; i < 5; i++ {
-		assert.Equal(t, fmt.Sprintf("trial%d", i), rep.TrialInfos[i].TrialId)
-		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
-		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+		for _, expectedTickID := range []uint64{1, 3} {
+			msg, err := stream.Recv()
+			assert.NoError(t, err)
+			assert.Equal(t, expectedTickID, msg.GetTrialSample().TickId)
+		}
+
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
 	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "min-payloads-size", "100", "max-payloads-size", "1500")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
 
-	assert.Equal(t, "5", rep.NextTrialHandle)
+		for _, expectedTickID := range []uint64{0, 1, 2} {
+			msg, err := stream.Recv()
+			assert.NoError(t, err)
+			assert.Equal(t, expectedTickID, msg.GetTrialSample().TickId)
+		}
 
-	rep, err = fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialsCount: 5, TrialHandle: rep.NextTrialHandle})
-	assert.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "min-payloads-size", "-1")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
 
-	assert.Len(t, rep.TrialInfos, 5)
-	for i := 0; i < 5; i++ {
-		assert.Equal(t, fmt.Sprintf("trial%d", i+5), rep.TrialInfos[i].TrialId)
-		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
-		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
 	}
+}
 
-	assert.Equal(t, "10", rep.NextTrialHandle)
+func addContinuationTestSamples(t *testing.T, fxt *trialDatastoreServerTestFixture) {
+	createTrials(t, fxt, 2)
+	for _, trialID := range []string{"trial0", "trial1"} {
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 5; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: trialID, TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		samples[len(samples)-1].State = grpcapi.TrialState_ENDED
+		err := fxt.backend.AddSamples(fxt.ctx, samples)
+		assert.NoError(t, err)
+	}
 }
 
-func TestAddAndListTrialsSelectedAndPaginated(t *testing.T) {
+// retrieveSampleIDs retrieves samples until the end of the stream and returns their ids, the final error and the continuation token
+func retrieveSampleIDs(t *testing.T, stream grpcapi.TrialDatastoreSP_RetrieveSamplesClient) ([]string, error, string) {
+	sampleIDs := []string{}
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			continuationTokens := stream.Trailer().Get("continuation-token")
+			assert.Len(t, continuationTokens, 1)
+			return sampleIDs, err, continuationTokens[0]
+		}
+		sampleIDs = append(sampleIDs, fmt.Sprintf("%s/%d", msg.GetTrialSample().TrialId, msg.GetTrialSample().TickId))
+	}
+}
+
+func TestRetrieveSamplesContinuationToken(t *testing.T) {
 	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
 	defer fxt.destroy()
 
-	createTrials(t, &fxt, 10)
+	addContinuationTestSamples(t, &fxt)
 
-	selectedTrialIds := []string{"trial0", "trial9", "trial5", "trial2", "trial3"}
-	expectedTrialIds := []string{"trial0", "trial2", "trial3", "trial5", "trial9"} // Trials should be returned according to their creation order
-	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, TrialsCount: 3})
-	assert.NoError(t, err)
+	req := &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0", "trial1"}}
 
-	assert.Len(t, rep.TrialInfos, 3)
-	for i := 0; i < 3; i++ {
-		assert.Equal(t, expectedTrialIds[i], rep.TrialInfos[i].TrialId)
-		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
-		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
-	}
+	// Retrieval interrupted after 3 samples, the tick range skips the upfront check of the number of samples
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "max-samples", "3", "from-tick-id", "0")
+	stream, err := fxt.client.RetrieveSamples(ctx, req)
+	assert.NoError(t, err)
+	firstSampleIDs, err, continuationToken := retrieveSampleIDs(t, stream)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Len(t, firstSampleIDs, 3)
 
-	assert.Equal(t, "4", rep.NextTrialHandle)
+	// Resumed retrieval
+	ctx = metadata.AppendToOutgoingContext(fxt.ctx, "continuation-token", continuationToken)
+	stream, err = fxt.client.RetrieveSamples(ctx, req)
+	assert.NoError(t, err)
+	resumedSampleIDs, err, continuationToken := retrieveSampleIDs(t, stream)
+	assert.NoError(t, err)
+	assert.Len(t, resumedSampleIDs, 7)
+	assert.ElementsMatch(t, []string{
+		"trial0/0", "trial0/1", "trial0/2", "trial0/3", "trial0/4",
+		"trial1/0", "trial1/1", "trial1/2", "trial1/3", "trial1/4",
+	}, append(firstSampleIDs, resumedSampleIDs...))
+
+	// Resuming a complete retrieval doesn't retrieve anything
+	ctx = metadata.AppendToOutgoingContext(fxt.ctx, "continuation-token", continuationToken)
+	stream, err = fxt.client.RetrieveSamples(ctx, req)
+	assert.NoError(t, err)
+	sampleIDs, err, _ := retrieveSampleIDs(t, stream)
+	assert.NoError(t, err)
+	assert.Len(t, sampleIDs, 0)
+}
 
-	rep, err = fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, TrialsCount: 3, TrialHandle: rep.NextTrialHandle})
+func TestRetrieveSamplesClientBuiltContinuationToken(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
+	defer fxt.destroy()
 
-	assert.Len(t, rep.TrialInfos, 2)
-	for i := 0; i < 2; i++ {
-		assert.Equal(t, expectedTrialIds[i+3], rep.TrialInfos[i].TrialId)
-		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
-		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
-	}
+	addContinuationTestSamples(t, &fxt)
 
-	assert.Equal(t, "10", rep.NextTrialHandle)
+	// Token built, following the documented format, by a client which lost the connection after receiving a few samples
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "continuation-token", base64.RawURLEncoding.EncodeToString([]byte(`{"last_tick_ids":{"trial0":3}}`)))
+	stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0", "trial1"}})
+	assert.NoError(t, err)
+	sampleIDs, err, _ := retrieveSampleIDs(t, stream)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"trial0/4", "trial1/0", "trial1/1", "trial1/2", "trial1/3", "trial1/4"}, sampleIDs)
 }
 
-func TestAddAndListTrialsSelectedAndPaginatedAsync(t *testing.T) {
+func TestRetrieveSamplesContinuationTokenTickRange(t *testing.T) {
 	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
 	defer fxt.destroy()
 
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		time.Sleep(100 * time.Millisecond)
-		defer wg.Done()
-		createTrials(t, &fxt, 10)
-	}()
+	addContinuationTestSamples(t, &fxt)
+
+	token := utils.NewContinuationToken()
+	token.Advance(&grpcapi.StoredTrialSample{TrialId: "trial0", TickId: 1})
+	token.Advance(&grpcapi.StoredTrialSample{TrialId: "trial1", TickId: 3})
+
+	for _, testCase := range []struct {
+		headerMD          []string
+		expectedSampleIDs []string
+	}{
+		{[]string{}, []string{"trial0/2", "trial0/3", "trial0/4", "trial1/4"}},
+		{[]string{"from-tick-id", "3"}, []string{"trial0/3", "trial0/4", "trial1/4"}},
+		{[]string{"to-tick-id", "3"}, []string{"trial0/2", "trial0/3"}},
+	} {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, append(testCase.headerMD, "continuation-token", token.String())...)
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0", "trial1"}})
+		assert.NoError(t, err)
+		sampleIDs, err, _ := retrieveSampleIDs(t, stream)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, testCase.expectedSampleIDs, sampleIDs)
+	}
+}
 
-	selectedTrialIds := []string{"trial0", "trial9", "trial5", "trial2", "trial3"}
-	expectedTrialIds := []string{"trial0", "trial2", "trial3", "trial5", "trial9"} // Trials should be returned according to their creation order
-	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, TrialsCount: 3, Timeout: 1000})
+func TestRetrieveSamplesContinuationTokenSampleOrderingKey(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
+	defer fxt.destroy()
 
-	assert.Len(t, rep.TrialInfos, 3)
-	for i := 0; i < 3; i++ {
-		assert.Equal(t, expectedTrialIds[i], rep.TrialInfos[i].TrialId)
-		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
-		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
-	}
+	sampleOrderingKey, err := backend.ParseSampleOrderingKey("timestamp")
+	assert.NoError(t, err)
+	err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: "my-trial", UserID: "foo", Params: &grpcapi.TrialParams{}, SampleOrderingKey: sampleOrderingKey}})
+	assert.NoError(t, err)
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+		{TrialId: "my-trial", TickId: 0, Timestamp: 20, State: grpcapi.TrialState_RUNNING},
+		{TrialId: "my-trial", TickId: 1, Timestamp: 10, State: grpcapi.TrialState_ENDED},
+	})
+	assert.NoError(t, err)
 
-	assert.Equal(t, "4", rep.NextTrialHandle)
+	// The samples following the last delivered tick aren't known when the samples aren't ordered by tick id
+	token := utils.NewContinuationToken()
+	token.Advance(&grpcapi.StoredTrialSample{TrialId: "my-trial", TickId: 1})
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "continuation-token", token.String())
+	stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
 
-	rep, err = fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, TrialsCount: 3, TrialHandle: rep.NextTrialHandle, Timeout: 1000})
+func TestRetrieveSamplesInvalidContinuationToken(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
+	defer fxt.destroy()
 
-	assert.Len(t, rep.TrialInfos, 2)
-	for i := 0; i < 2; i++ {
-		assert.Equal(t, expectedTrialIds[i+3], rep.TrialInfos[i].TrialId)
-		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
-		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+	addContinuationTestSamples(t, &fxt)
+
+	token := utils.NewContinuationToken()
+	token.Advance(&grpcapi.StoredTrialSample{TrialId: "trial1", TickId: 2})
+
+	for _, testCase := range []struct {
+		serializedToken string
+		trialIDs        []string
+		expectedCode    codes.Code
+	}{
+		{serializedToken: "not a token", trialIDs: []string{"trial0", "trial1"}, expectedCode: codes.InvalidArgument},
+		{serializedToken: base64.RawURLEncoding.EncodeToString([]byte("{}")), trialIDs: []string{"trial0", "trial1"}, expectedCode: codes.InvalidArgument},
+		// The token refers to a trial which isn't requested
+		{serializedToken: token.String(), trialIDs: []string{"trial0"}, expectedCode: codes.InvalidArgument},
+	} {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "continuation-token", testCase.serializedToken)
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: testCase.trialIDs})
+		assert.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, testCase.expectedCode, status.Code(err))
 	}
 
-	assert.Equal(t, "10", rep.NextTrialHandle)
-
-	wg.Wait()
+	// The trial of the token was deleted
+	err = fxt.backend.DeleteTrials(fxt.ctx, []string{"trial1"})
+	assert.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "continuation-token", token.String())
+	stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0", "trial1"}})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
 }
 
-func TestAddSamplesSimple(t *testing.T) {
+func TestRetrieveSamplesTickRange(t *testing.T) {
 	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
 	defer fxt.destroy()
 
-	createTrials(t, &fxt, 1)
+	trialID := "mytrial"
 
 	{
-		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
-		stream, err := fxt.client.AddSample(ctx)
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}}})
 		assert.NoError(t, err)
-		err = stream.Send(&grpcapi.AddSampleRequest{
-			TrialSample: &grpcapi.StoredTrialSample{TrialId: "trial0", UserId: "my_user", State: grpcapi.TrialState_RUNNING},
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Reward: pointy.Float32(0)}}},
+			{TrialId: trialID, TickId: 1, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Reward: pointy.Float32(1)}}},
+			{TrialId: trialID, TickId: 2, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Reward: pointy.Float32(2)}}},
+			{TrialId: trialID, TickId: 3, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Reward: pointy.Float32(3)}}},
 		})
 		assert.NoError(t, err)
-		_, err = stream.CloseAndRecv()
+	}
+	{
+		// Combined with a fields filter
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "from-tick-id", "1", "to-tick-id", "2")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{
+			TrialIds:             []string{trialID},
+			SelectedSampleFields: []grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION},
+		})
+		assert.NoError(t, err)
+
+		for _, expectedTickID := range []uint64{1, 2} {
+			msg, err := stream.Recv()
+			assert.NoError(t, err)
+			sample := msg.GetTrialSample()
+			assert.Equal(t, expectedTickID, sample.TickId)
+			assert.Nil(t, sample.ActorSamples[0].Reward)
+		}
+
+		msg, err := stream.Recv()
+		assert.Equal(t, io.EOF, err)
+		assert.Nil(t, msg)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "from-tick-id", "3")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), msg.GetTrialSample().TickId)
+
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "from-tick-id", "2", "to-tick-id", "1")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func TestRetrieveSamplesDownsampling(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}}})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 10; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: trialID, TickId: tickID, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Reward: pointy.Float32(float32(tickID))}}})
+		}
+		samples[9].State = grpcapi.TrialState_ENDED
+		err = fxt.backend.AddSamples(fxt.ctx, samples)
+		assert.NoError(t, err)
+	}
+	retrieveTickIDs := func(req *grpcapi.RetrieveSamplesRequest, headers ...string) []uint64 {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, headers...)
+		stream, err := fxt.client.RetrieveSamples(ctx, req)
+		assert.NoError(t, err)
+
+		tickIDs := []uint64{}
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return tickIDs
+			}
+			assert.NoError(t, err)
+			if err != nil {
+				return tickIDs
+			}
+			tickIDs = append(tickIDs, msg.GetTrialSample().TickId)
+		}
+	}
+
+	assert.Equal(t, []uint64{0, 3, 6, 9}, retrieveTickIDs(&grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}}, "downsampling-factor", "3"))
+	// The bounds of the tick range are kept
+	assert.Equal(t, []uint64{1, 3, 6, 7}, retrieveTickIDs(&grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}}, "downsampling-factor", "3", "from-tick-id", "1", "to-tick-id", "7"))
+	assert.Equal(t, []uint64{2, 4, 8, 9}, retrieveTickIDs(&grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}}, "downsampling-factor", "4", "from-tick-id", "2"))
+	{
+		// Combined with a fields filter
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "downsampling-factor", "3")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{
+			TrialIds:             []string{trialID},
+			SelectedSampleFields: []grpcapi.StoredTrialSampleField{grpcapi.StoredTrialSampleField_STORED_TRIAL_SAMPLE_FIELD_OBSERVATION},
+		})
+		assert.NoError(t, err)
+
+		for _, expectedTickID := range []uint64{0, 3, 6, 9} {
+			msg, err := stream.Recv()
+			assert.NoError(t, err)
+			sample := msg.GetTrialSample()
+			assert.Equal(t, expectedTickID, sample.TickId)
+			assert.Nil(t, sample.ActorSamples[0].Reward)
+		}
+
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+	}
+	{
+		// The maximum number of samples applies to the downsampled samples
+		assert.Len(t, retrieveTickIDs(&grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}}, "downsampling-factor", "3", "max-samples", "4"), 4)
+
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "downsampling-factor", "3", "max-samples", "3")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+		for {
+			_, err = stream.Recv()
+			if err != nil {
+				break
+			}
+		}
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "downsampling-factor", "-3")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func TestRetrieveSamplesReverse(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}}})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 10; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: trialID, TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		samples[9].State = grpcapi.TrialState_ENDED
+		err = fxt.backend.AddSamples(fxt.ctx, samples)
+		assert.NoError(t, err)
+	}
+	retrieveTickIDs := func(headers ...string) []uint64 {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, headers...)
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		tickIDs := []uint64{}
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return tickIDs
+			}
+			assert.NoError(t, err)
+			if err != nil {
+				return tickIDs
+			}
+			tickIDs = append(tickIDs, msg.GetTrialSample().TickId)
+		}
+	}
+
+	assert.Equal(t, []uint64{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, retrieveTickIDs("reverse", "true"))
+	assert.Equal(t, []uint64{7, 6, 5, 4, 3}, retrieveTickIDs("reverse", "true", "from-tick-id", "3", "to-tick-id", "7"))
+	// The bounds of the tick range are kept by the downsampling
+	assert.Equal(t, []uint64{9, 6, 3, 0}, retrieveTickIDs("reverse", "true", "downsampling-factor", "3"))
+	assert.Equal(t, []uint64{8, 6, 3, 2}, retrieveTickIDs("reverse", "true", "downsampling-factor", "3", "from-tick-id", "2", "to-tick-id", "8"))
+	assert.Equal(t, []uint64{7, 4, 1}, retrieveTickIDs("reverse", "true", "downsampling-factor", "4", "from-tick-id", "1", "to-tick-id", "7"))
+
+	for _, headers := range [][]string{
+		{"reverse", "true", "windows-count", "3"},
+		{"reverse", "true", "continuation-token", utils.NewContinuationToken().String()},
+		{"reverse", "maybe"},
+	} {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, headers...)
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), headers)
+	}
+}
+
+func TestRetrieveSamplesWindows(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}}})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 1000; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: trialID, TickId: tickID, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Reward: pointy.Float32(float32(tickID))}}})
+		}
+		samples[999].State = grpcapi.TrialState_ENDED
+		err = fxt.backend.AddSamples(fxt.ctx, samples)
+		assert.NoError(t, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "windows-count", "10", "from-tick-id", "100")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		// Windows of 90 ticks from tick 100
+		for windowIdx := uint64(0); windowIdx < 10; windowIdx++ {
+			msg, err := stream.Recv()
+			assert.NoError(t, err)
+			sample := msg.GetTrialSample()
+			assert.Equal(t, 100+windowIdx*90+89, sample.TickId)
+			assert.Equal(t, float32(100+windowIdx*90)+44.5, *sample.ActorSamples[0].Reward)
+		}
+
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "windows-count", "10", "downsampling-factor", "2")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "windows-count", "10")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"unknown-trial"}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	}
+}
+
+func TestRetrieveSamplesRandom(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}}})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 100; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: trialID, TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		samples[99].State = grpcapi.TrialState_ENDED
+		err = fxt.backend.AddSamples(fxt.ctx, samples)
+		assert.NoError(t, err)
+	}
+	retrieveTickIDs := func(headers ...string) []uint64 {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, headers...)
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		tickIDs := []uint64{}
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return tickIDs
+			}
+			assert.NoError(t, err)
+			if err != nil {
+				return tickIDs
+			}
+			tickIDs = append(tickIDs, msg.GetTrialSample().TickId)
+		}
+	}
+
+	tickIDs := retrieveTickIDs("random-samples-count", "8", "random-seed", "1234")
+	assert.Len(t, tickIDs, 8)
+	assert.IsIncreasing(t, tickIDs)
+	assert.Equal(t, tickIDs, retrieveTickIDs("random-samples-count", "8", "random-seed", "1234"))
+
+	tickIDs = retrieveTickIDs("random-samples-count", "8", "random-seed", "1234", "from-tick-id", "90")
+	assert.Len(t, tickIDs, 8)
+	for _, tickID := range tickIDs {
+		assert.GreaterOrEqual(t, tickID, uint64(90))
+	}
+
+	assert.Len(t, retrieveTickIDs("random-samples-count", "200"), 100)
+
+	for _, headers := range [][]string{
+		{"random-samples-count", "8", "windows-count", "3"},
+		{"random-samples-count", "8", "downsampling-factor", "3"},
+		{"random-samples-count", "8", "reverse", "true"},
+		{"random-samples-count", "8", "continuation-token", utils.NewContinuationToken().String()},
+		{"random-seed", "1234"},
+		{"random-samples-count", "8", "random-seed", "-1"},
+	} {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, headers...)
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), headers)
+	}
+}
+
+func TestRetrieveSamplesLatestSample(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{
+			{TrialID: "trial-1", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}},
+			{TrialID: "trial-2", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}},
+			{TrialID: "empty-trial", Params: &grpcapi.TrialParams{}},
+		})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 10; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{TrialId: "trial-1", TickId: tickID, State: grpcapi.TrialState_RUNNING})
+		}
+		samples = append(samples, &grpcapi.StoredTrialSample{TrialId: "trial-2", TickId: 3, State: grpcapi.TrialState_RUNNING})
+		err = fxt.backend.AddSamples(fxt.ctx, samples)
+		assert.NoError(t, err)
+	}
+	retrieveLatestSamples := func(trialIDs []string, headers ...string) (map[string]uint64, error) {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, append([]string{"latest-sample", "true"}, headers...)...)
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: trialIDs})
+		assert.NoError(t, err)
+
+		tickIDs := map[string]uint64{}
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return tickIDs, nil
+			}
+			if err != nil {
+				return tickIDs, err
+			}
+			tickIDs[msg.GetTrialSample().TrialId] = msg.GetTrialSample().TickId
+		}
+	}
+
+	// The trials are ongoing, the retrieval doesn't wait for their next samples
+	tickIDs, err := retrieveLatestSamples([]string{"trial-1", "trial-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"trial-1": 9, "trial-2": 3}, tickIDs)
+
+	// Trials without samples are skipped when every trial is selected
+	tickIDs, err = retrieveLatestSamples([]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"trial-1": 9, "trial-2": 3}, tickIDs)
+
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: "trial-1", TickId: 12, State: grpcapi.TrialState_ENDED}})
+	assert.NoError(t, err)
+	tickIDs, err = retrieveLatestSamples([]string{"trial-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"trial-1": 12}, tickIDs)
+
+	_, err = retrieveLatestSamples([]string{"empty-trial"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = retrieveLatestSamples([]string{"unknown-trial"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	for _, headers := range [][]string{
+		{"windows-count", "3"},
+		{"random-samples-count", "8"},
+		{"downsampling-factor", "3"},
+		{"reverse", "true"},
+		{"from-tick-id", "3"},
+		{"continuation-token", utils.NewContinuationToken().String()},
+	} {
+		_, err = retrieveLatestSamples([]string{"trial-1"}, headers...)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), headers)
+	}
+}
+
+func TestRetrieveSamplesEstimateSize(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{
+			{TrialID: "trial-1", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}, {Name: "bar"}}}},
+		})
+		assert.NoError(t, err)
+		samples := []*grpcapi.StoredTrialSample{}
+		for tickID := uint64(0); tickID < 10; tickID++ {
+			samples = append(samples, &grpcapi.StoredTrialSample{
+				TrialId: "trial-1",
+				TickId:  tickID,
+				State:   grpcapi.TrialState_RUNNING,
+				ActorSamples: []*grpcapi.StoredTrialActorSample{
+					{Actor: 0, Observation: pointy.Uint32(0)},
+					{Actor: 1, Observation: pointy.Uint32(1)},
+				},
+				Payloads: [][]byte{[]byte("a small observation"), []byte("a much larger observation of the second actor")},
+			})
+		}
+		samples[len(samples)-1].State = grpcapi.TrialState_ENDED
+		err = fxt.backend.AddSamples(fxt.ctx, samples)
+		assert.NoError(t, err)
+	}
+	retrieve := func(req *grpcapi.RetrieveSamplesRequest, headers ...string) (metadata.MD, int, int, error) {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, headers...)
+		stream, err := fxt.client.RetrieveSamples(ctx, req)
+		assert.NoError(t, err)
+
+		samplesCount := 0
+		samplesSize := 0
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				header, err := stream.Header()
+				return header, samplesCount, samplesSize, err
+			}
+			if err != nil {
+				return nil, samplesCount, samplesSize, err
+			}
+			samplesCount++
+			samplesSize += proto.Size(msg.GetTrialSample())
+		}
+	}
+
+	for _, req := range []*grpcapi.RetrieveSamplesRequest{
+		{TrialIds: []string{"trial-1"}},
+		{TrialIds: []string{"trial-1"}, ActorNames: []string{"foo"}},
+	} {
+		header, estimatedSamplesCount, _, err := retrieve(req, "estimate-size", "true", "compact-payloads", "true")
+		assert.NoError(t, err)
+		// Nothing is sent
+		assert.Equal(t, 0, estimatedSamplesCount)
+		assert.Equal(t, []string{"true"}, header.Get("estimate-exact"))
+
+		_, samplesCount, samplesSize, err := retrieve(req, "compact-payloads", "true")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{strconv.Itoa(samplesCount)}, header.Get("estimated-samples-count"))
+		assert.Equal(t, []string{strconv.Itoa(samplesSize)}, header.Get("estimated-samples-size"))
+	}
+
+	_, _, _, err = retrieve(&grpcapi.RetrieveSamplesRequest{TrialIds: []string{"unknown-trial"}}, "estimate-size", "true")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	for _, headers := range [][]string{
+		{"windows-count", "3"},
+		{"random-samples-count", "8"},
+		{"latest-sample", "true"},
+		{"downsampling-factor", "3"},
+		{"include-trial-params", "true"},
+		{"continuation-token", utils.NewContinuationToken().String()},
+	} {
+		_, _, _, err = retrieve(&grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial-1"}}, append([]string{"estimate-size", "true"}, headers...)...)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), headers)
+	}
+}
+
+func TestRetrieveSamplesReceivedRewardSenders(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{
+			Actors: []*grpcapi.ActorParams{{Name: "foo"}, {Name: "bar"}, {Name: "baz"}},
+		}}})
+		assert.NoError(t, err)
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+				{Sender: -1, Reward: 1},
+				{Sender: 1, Reward: 2},
+				{Sender: 2, Reward: 3},
+			}}}},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "received-reward-sender-indices", "-1", "received-reward-sender-names", "baz")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		receivedRewards := msg.GetTrialSample().ActorSamples[0].ReceivedRewards
+		assert.Len(t, receivedRewards, 2)
+		assert.Equal(t, int32(-1), receivedRewards[0].Sender)
+		assert.Equal(t, int32(2), receivedRewards[1].Sender)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "received-reward-sender-indices", "foo")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func TestRetrieveSamplesReceivedRewardsAggregation(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{
+			Actors: []*grpcapi.ActorParams{{Name: "foo"}, {Name: "bar"}, {Name: "baz"}},
+		}}})
+		assert.NoError(t, err)
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{
+				{Sender: -1, Reward: 1, Confidence: 1},
+				{Sender: 1, Reward: 2, Confidence: 1},
+				{Sender: 2, Reward: 3, Confidence: 1},
+			}}}},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "received-rewards-aggregation", "mean", "received-reward-sender-indices", "1,2")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		receivedRewards := msg.GetTrialSample().ActorSamples[0].ReceivedRewards
+		assert.Len(t, receivedRewards, 1)
+		assert.Equal(t, int32(-1), receivedRewards[0].Sender)
+		assert.Equal(t, float32(2.5), receivedRewards[0].Reward)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "received-rewards-aggregation", "max")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func TestRetrieveSamplesStripUserData(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{
+			Actors: []*grpcapi.ActorParams{{Name: "foo"}, {Name: "bar"}},
+		}}})
+		assert.NoError(t, err)
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{
+				Actor:            0,
+				ReceivedRewards:  []*grpcapi.StoredTrialActorSampleReward{{Sender: 1, Reward: 2, Confidence: 0.5, UserData: pointy.Uint32(0)}},
+				ReceivedMessages: []*grpcapi.StoredTrialActorSampleMessage{{Sender: 1, Payload: 1}},
+			}}, Payloads: [][]byte{[]byte("a large user data"), []byte("a large message")}},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "strip-user-data", "true")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		actorSample := msg.GetTrialSample().ActorSamples[0]
+		assert.Len(t, actorSample.ReceivedRewards, 1)
+		assert.Equal(t, float32(2), actorSample.ReceivedRewards[0].Reward)
+		assert.Equal(t, float32(0.5), actorSample.ReceivedRewards[0].Confidence)
+		assert.Nil(t, actorSample.ReceivedRewards[0].UserData)
+		assert.Len(t, actorSample.ReceivedMessages, 1)
+		assert.Empty(t, msg.GetTrialSample().Payloads[0])
+		assert.Empty(t, msg.GetTrialSample().Payloads[1])
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "strip-user-data", "maybe")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func TestRetrieveSamplesSentRewardReceivers(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{
+			Actors: []*grpcapi.ActorParams{{Name: "foo"}, {Name: "bar"}, {Name: "baz"}},
+		}}})
+		assert.NoError(t, err)
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, SentRewards: []*grpcapi.StoredTrialActorSampleReward{
+				{Receiver: -1, Reward: 1},
+				{Receiver: 1, Reward: 2},
+				{Receiver: 2, Reward: 3},
+			}}}},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "sent-reward-receiver-indices", "1", "sent-reward-receiver-names", "baz")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		sentRewards := msg.GetTrialSample().ActorSamples[0].SentRewards
+		assert.Len(t, sentRewards, 2)
+		assert.Equal(t, int32(1), sentRewards[0].Receiver)
+		assert.Equal(t, int32(2), sentRewards[1].Receiver)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "sent-reward-receiver-indices", "foo")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func TestRetrieveSamplesSentMessageReceivers(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{
+			Actors: []*grpcapi.ActorParams{{Name: "foo"}, {Name: "bar"}, {Name: "baz"}},
+		}}})
+		assert.NoError(t, err)
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, SentMessages: []*grpcapi.StoredTrialActorSampleMessage{
+				{Receiver: -1, Payload: 0},
+			}}}, Payloads: [][]byte{[]byte("a broadcast message")}},
+			{TrialId: trialID, TickId: 1, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, SentMessages: []*grpcapi.StoredTrialActorSampleMessage{
+				{Receiver: 1, Payload: 0},
+				{Receiver: 2, Payload: 1},
+			}}}, Payloads: [][]byte{[]byte("a message to bar"), []byte("a message to baz")}},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "sent-message-receiver-names", "baz")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		sample := msg.GetTrialSample()
+		assert.Equal(t, uint64(1), sample.TickId)
+		assert.Len(t, sample.ActorSamples[0].SentMessages, 1)
+		assert.Equal(t, int32(2), sample.ActorSamples[0].SentMessages[0].Receiver)
+		assert.Empty(t, sample.Payloads[0])
+
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "sent-message-receiver-indices", "bar")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func TestRetrieveSamplesIncludeTrialParams(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	{
+		err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{
+			MaxSteps: 72,
+			Actors:   []*grpcapi.ActorParams{{Name: "foo"}, {Name: "bar"}},
+		}}})
+		assert.NoError(t, err)
+		err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+			{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0}, {Actor: 1}}},
+			{TrialId: trialID, TickId: 1, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0}, {Actor: 1}}},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "include-trial-params", "true")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}, ActorNames: []string{"bar"}})
+		assert.NoError(t, err)
+
+		headerMD, err := stream.Header()
+		assert.NoError(t, err)
+		serializedParams := headerMD.Get("trial-params-bin")
+		assert.Len(t, serializedParams, 1)
+		params := &grpcapi.TrialParams{}
+		err = proto.Unmarshal([]byte(serializedParams[0]), params)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(72), params.MaxSteps)
+
+		for tickID := uint64(0); tickID < 2; tickID++ {
+			msg, err := stream.Recv()
+			assert.NoError(t, err)
+			assert.Equal(t, tickID, msg.GetTrialSample().TickId)
+			assert.Len(t, msg.GetTrialSample().ActorSamples, 1)
+			assert.Equal(t, uint32(1), msg.GetTrialSample().ActorSamples[0].Actor)
+		}
+
+		msg, err := stream.Recv()
+		assert.Equal(t, io.EOF, err)
+		assert.Nil(t, msg)
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "include-trial-params", "true", "max-samples", "1")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	}
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "include-trial-params", "true")
+		stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"unknown"}})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	}
+}
+
+func TestRetrieveSamplesMaxSamplesOngoingTrial(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "max-samples", "2")
+	stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+	assert.NoError(t, err)
+
+	msg, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), msg.GetTrialSample().TickId)
+
+	// The trial goes over the maximum while being retrieved
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+		{TrialId: trialID, TickId: 1, State: grpcapi.TrialState_RUNNING},
+		{TrialId: trialID, TickId: 2, State: grpcapi.TrialState_RUNNING},
+	})
+	assert.NoError(t, err)
+
+	msg, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), msg.GetTrialSample().TickId)
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestRetrieveSamplesMaxSamplesFiltered(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	trialID := "mytrial"
+
+	err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "foo", Params: &grpcapi.TrialParams{Actors: []*grpcapi.ActorParams{{Name: "foo"}}}}})
+	assert.NoError(t, err)
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+		{TrialId: trialID, TickId: 0, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0}}},
+		{TrialId: trialID, TickId: 1, State: grpcapi.TrialState_RUNNING, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Action: pointy.Uint32(0)}}, Payloads: [][]byte{[]byte("an action")}},
+		{TrialId: trialID, TickId: 2, State: grpcapi.TrialState_ENDED, ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0}}},
+	})
+	assert.NoError(t, err)
+
+	// 3 samples are stored but only 1 is selected
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "require-actions", "true", "max-samples", "2")
+	stream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{trialID}})
+	assert.NoError(t, err)
+
+	msg, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), msg.GetTrialSample().TickId)
+
+	msg, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, msg)
+}
+
+func TestRetrieveSamplesFollowOngoingTrial(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 1)
+
+	addCtx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
+	addStream, err := fxt.client.AddSample(addCtx)
+	assert.NoError(t, err)
+	err = addStream.Send(&grpcapi.AddSampleRequest{
+		TrialSample: &grpcapi.StoredTrialSample{TickId: 0, State: grpcapi.TrialState_RUNNING},
+	})
+	assert.NoError(t, err)
+
+	retrieveStream, err := fxt.client.RetrieveSamples(fxt.ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0"}})
+	assert.NoError(t, err)
+	msg, err := retrieveStream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), msg.GetTrialSample().TickId)
+
+	// Samples appended while the retrieval is ongoing are sent
+	for tickID := uint64(1); tickID <= 3; tickID++ {
+		state := grpcapi.TrialState_RUNNING
+		if tickID == 3 {
+			state = grpcapi.TrialState_ENDED
+		}
+		err = addStream.Send(&grpcapi.AddSampleRequest{
+			TrialSample: &grpcapi.StoredTrialSample{TickId: tickID, State: state},
+		})
+		assert.NoError(t, err)
+		msg, err := retrieveStream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, tickID, msg.GetTrialSample().TickId)
+	}
+	_, err = addStream.CloseAndRecv()
+	assert.NoError(t, err)
+
+	// The retrieval ends with the trial
+	_, err = retrieveStream.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRetrieveSamplesFollowCanceled(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 1)
+
+	ctx, cancel := context.WithCancel(fxt.ctx)
+	retrieveStream, err := fxt.client.RetrieveSamples(ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0"}})
+	assert.NoError(t, err)
+
+	// The retrieval waits for the samples of the ongoing trial until the client cancels it
+	cancel()
+	_, err = retrieveStream.Recv()
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	// The trial can still be appended to and retrieved
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: "trial0", TickId: 0, State: grpcapi.TrialState_ENDED}})
+	assert.NoError(t, err)
+	retrieveStream, err = fxt.client.RetrieveSamples(fxt.ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0"}})
+	assert.NoError(t, err)
+	msg, err := retrieveStream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), msg.GetTrialSample().TickId)
+	_, err = retrieveStream.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestListenToTrialConcurrentTrials(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	wg := sync.WaitGroup{}
+	createAndSamplesToTrial := func(trialID string, ticks []uint64, delay time.Duration) {
+		defer wg.Done()
+		err := fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: trialID, UserID: "test", Params: &grpcapi.TrialParams{MaxSteps: 72}}})
+		assert.NoError(t, err)
+
+		for tickIdx, tickID := range ticks {
+			time.Sleep(delay)
+			if tickIdx < len(ticks)-1 {
+				err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: trialID, UserId: "test", TickId: tickID, State: grpcapi.TrialState_RUNNING}})
+				assert.NoError(t, err)
+			} else {
+				err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: trialID, UserId: "test", TickId: tickID, State: grpcapi.TrialState_ENDED}})
+				assert.NoError(t, err)
+			}
+		}
+	}
+	trial1Ticks := []uint64{1, 2, 3, 4, 5, 6, 7}
+	trial2Ticks := []uint64{8, 9, 10, 11, 12, 13, 14}
+	wg.Add(1)
+	go createAndSamplesToTrial("trial1", trial1Ticks, 10*time.Millisecond)
+	wg.Add(1)
+	go createAndSamplesToTrial("trial2", trial2Ticks, 5*time.Millisecond)
+
+	retrieveSamples := func(trialIDs []string, expectedSampleTicks []uint64, delay time.Duration) {
+		defer wg.Done()
+		retrievedSamplesTicks := []uint64{}
+
+		stream, err := fxt.client.RetrieveSamples(fxt.ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: trialIDs})
+		assert.NoError(t, err)
+
+		for {
+			time.Sleep(delay)
+			msg, err := stream.Recv()
+
+			if len(retrievedSamplesTicks) == len(expectedSampleTicks) {
+				// Should have received everything
+				msg, err = stream.Recv()
+				assert.Equal(t, io.EOF, err)
+				assert.Nil(t, msg)
+				break
+			}
+
+			assert.NoError(t, err)
+			sample := msg.GetTrialSample()
+			assert.NotNil(t, sample)
+			assert.Equal(t, "test", sample.UserId)
+			assert.Contains(t, trialIDs, sample.TrialId)
+			assert.Contains(t, expectedSampleTicks, sample.TickId)
+			retrievedSamplesTicks = append(retrievedSamplesTicks, sample.TickId)
+		}
+
+		assert.ElementsMatch(t, expectedSampleTicks, retrievedSamplesTicks)
+	}
+
+	wg.Add(1)
+	go retrieveSamples([]string{"trial1", "trial2"}, append(trial1Ticks, trial2Ticks...), 100*time.Millisecond)
+	wg.Add(1)
+	go retrieveSamples([]string{"trial1"}, trial1Ticks, 10*time.Millisecond)
+	wg.Add(1)
+	go retrieveSamples([]string{"trial2"}, trial2Ticks, 60*time.Millisecond)
+
+	wg.Wait()
+}
+
+func createTrials(t *testing.T, fxt *trialDatastoreServerTestFixture, trialCount int) {
+	for i := 0; i < trialCount; i++ {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", fmt.Sprintf("trial%d", i))
+		_, err := fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{
+			UserId: "test",
+			TrialParams: &grpcapi.TrialParams{
+				MaxSteps: uint32(10 * (i + 1)),
+			},
+		})
+		assert.NoError(t, err)
+
+	}
+}
+
+// parseNextTrialIdx returns the index from which the trials are listed by the page of the given handle
+func parseNextTrialIdx(t *testing.T, trialHandle string) int {
+	token, err := parseTrialsPageToken(trialHandle)
+	assert.NoError(t, err)
+	return token.NextTrialIdx
+}
+
+func TestAddAndListTrials(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 10)
+
+	// Retrieve everything in one page
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{})
+	assert.NoError(t, err)
+
+	assert.Len(t, rep.TrialInfos, 10)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, fmt.Sprintf("trial%d", i), rep.TrialInfos[i].TrialId)
+		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
+		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+	}
+
+	assert.Equal(t, 10, parseNextTrialIdx(t, rep.NextTrialHandle))
+}
+
+func TestAddAndListTrialsPaginated(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 10)
+
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialsCount: 5})
+	assert.NoError(t, err)
+
+	assert.Len(t, rep.TrialInfos, 5)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, fmt.Sprintf("trial%d", i), rep.TrialInfos[i].TrialId)
+		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
+		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+	}
+
+	assert.Equal(t, 5, parseNextTrialIdx(t, rep.NextTrialHandle))
+
+	rep, err = fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialsCount: 5, TrialHandle: rep.NextTrialHandle})
+	assert.NoError(t, err)
+
+	assert.Len(t, rep.TrialInfos, 5)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, fmt.Sprintf("trial%d", i+5), rep.TrialInfos[i].TrialId)
+		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
+		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+	}
+
+	assert.Equal(t, 10, parseNextTrialIdx(t, rep.NextTrialHandle))
+}
+
+func TestListTrialsPages(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 10)
+	addTrial := func(trialID string) {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", trialID)
+		_, err := fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{}})
+		assert.NoError(t, err)
+	}
+	retrievePage := func(trialHandle string) ([]string, string) {
+		rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialsCount: 3, TrialHandle: trialHandle})
+		assert.NoError(t, err)
+		trialIDs := []string{}
+		for _, trialInfo := range rep.TrialInfos {
+			trialIDs = append(trialIDs, trialInfo.TrialId)
+		}
+		return trialIDs, rep.NextTrialHandle
+	}
+
+	t.Run("Iteration", func(t *testing.T) {
+		pages := [][]string{}
+		trialIDs, trialHandle := retrievePage("")
+		for len(trialIDs) > 0 {
+			pages = append(pages, trialIDs)
+			trialIDs, trialHandle = retrievePage(trialHandle)
+		}
+		assert.Equal(t, [][]string{
+			{"trial0", "trial1", "trial2"},
+			{"trial3", "trial4", "trial5"},
+			{"trial6", "trial7", "trial8"},
+			{"trial9"},
+		}, pages)
+
+		// Past the last page, the listing doesn't start over
+		trialIDs, nextTrialHandle := retrievePage(trialHandle)
+		assert.Empty(t, trialIDs)
+		assert.Equal(t, trialHandle, nextTrialHandle)
+	})
+
+	t.Run("ConcurrentChanges", func(t *testing.T) {
+		trialIDs, trialHandle := retrievePage("")
+		assert.Equal(t, []string{"trial0", "trial1", "trial2"}, trialIDs)
+
+		// Trials created between pages are listed after the existing ones, deleted ones are skipped
+		addTrial("created-1")
+		_, err := fxt.client.DeleteTrials(fxt.ctx, &grpcapi.DeleteTrialsRequest{TrialIds: []string{"trial1", "trial4", "trial5"}})
+		assert.NoError(t, err)
+		trialIDs, trialHandle = retrievePage(trialHandle)
+		assert.Equal(t, []string{"trial3", "trial6", "trial7"}, trialIDs)
+
+		_, err = fxt.client.DeleteTrials(fxt.ctx, &grpcapi.DeleteTrialsRequest{TrialIds: []string{"trial7", "trial9"}})
+		assert.NoError(t, err)
+		addTrial("created-2")
+		trialIDs, trialHandle = retrievePage(trialHandle)
+		assert.Equal(t, []string{"trial8", "created-1", "created-2"}, trialIDs)
+		addTrial("created-3")
+		trialIDs, trialHandle = retrievePage(trialHandle)
+		assert.Equal(t, []string{"created-3"}, trialIDs)
+		trialIDs, _ = retrievePage(trialHandle)
+		assert.Empty(t, trialIDs)
+	})
+
+	t.Run("LegacyHandle", func(t *testing.T) {
+		trialIDs, _ := retrievePage("6")
+		assert.Equal(t, []string{"trial6", "trial8", "created-1"}, trialIDs)
+	})
+
+	t.Run("InvalidHandle", func(t *testing.T) {
+		_, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialsCount: 3, TrialHandle: "not a token"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestAddAndListTrialsSelectedAndPaginated(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 10)
+
+	selectedTrialIds := []string{"trial0", "trial9", "trial5", "trial2", "trial3"}
+	expectedTrialIds := []string{"trial0", "trial2", "trial3", "trial5", "trial9"} // Trials should be returned according to their creation order
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, TrialsCount: 3})
+	assert.NoError(t, err)
+
+	assert.Len(t, rep.TrialInfos, 3)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, expectedTrialIds[i], rep.TrialInfos[i].TrialId)
+		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
+		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+	}
+
+	assert.Equal(t, 4, parseNextTrialIdx(t, rep.NextTrialHandle))
+
+	rep, err = fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, TrialsCount: 3, TrialHandle: rep.NextTrialHandle})
+	assert.NoError(t, err)
+
+	assert.Len(t, rep.TrialInfos, 2)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, expectedTrialIds[i+3], rep.TrialInfos[i].TrialId)
+		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
+		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+	}
+
+	assert.Equal(t, 10, parseNextTrialIdx(t, rep.NextTrialHandle))
+}
+
+func TestAddAndListTrialsSelectedAndPaginatedAsync(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		defer wg.Done()
+		createTrials(t, &fxt, 10)
+	}()
+
+	selectedTrialIds := []string{"trial0", "trial9", "trial5", "trial2", "trial3"}
+	expectedTrialIds := []string{"trial0", "trial2", "trial3", "trial5", "trial9"} // Trials should be returned according to their creation order
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, TrialsCount: 3, Timeout: 1000})
+	assert.NoError(t, err)
+
+	assert.Len(t, rep.TrialInfos, 3)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, expectedTrialIds[i], rep.TrialInfos[i].TrialId)
+		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
+		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+	}
+
+	assert.Equal(t, 4, parseNextTrialIdx(t, rep.NextTrialHandle))
+
+	rep, err = fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, TrialsCount: 3, TrialHandle: rep.NextTrialHandle, Timeout: 1000})
+	assert.NoError(t, err)
+
+	assert.Len(t, rep.TrialInfos, 2)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, expectedTrialIds[i+3], rep.TrialInfos[i].TrialId)
+		assert.Equal(t, grpcapi.TrialState_UNKNOWN, rep.TrialInfos[i].LastState)
+		assert.Equal(t, "test", rep.TrialInfos[i].UserId)
+	}
+
+	assert.Equal(t, 10, parseNextTrialIdx(t, rep.NextTrialHandle))
+
+	wg.Wait()
+}
+
+func TestAddSamplesSimple(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 1)
+
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
+		stream, err := fxt.client.AddSample(ctx)
+		assert.NoError(t, err)
+		err = stream.Send(&grpcapi.AddSampleRequest{
+			TrialSample: &grpcapi.StoredTrialSample{TrialId: "trial0", UserId: "my_user", State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+		_, err = stream.CloseAndRecv()
+		assert.NoError(t, err)
+	}
+
+	{
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
+		stream, err := fxt.client.AddSample(ctx)
+		assert.NoError(t, err)
+		err = stream.Send(&grpcapi.AddSampleRequest{
+			TrialSample: &grpcapi.StoredTrialSample{UserId: "my_user", State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+		_, err = stream.CloseAndRecv()
+		assert.NoError(t, err)
+	}
+}
+
+func TestAddSamplesTrialResult(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{
+		{TrialID: "trial-1", Params: &grpcapi.TrialParams{}},
+		{TrialID: "trial-2", Params: &grpcapi.TrialParams{}},
+	})
+	assert.NoError(t, err)
+
+	addSample := func(trialID string, headers ...string) error {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, headers...)
+		stream, err := fxt.client.AddSample(ctx)
+		assert.NoError(t, err)
+		err = stream.Send(&grpcapi.AddSampleRequest{
+			TrialSample: &grpcapi.StoredTrialSample{TrialId: trialID, State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	err = addSample("trial-1", "trial-id", "trial-1", "end-trial", "true", "trial-result-scores", "return=12.5,steps=1", "trial-result-bin", "details")
+	assert.NoError(t, err)
+	infos, err := fxt.backend.RetrieveTrials(fxt.ctx, []string{"trial-1"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, grpcapi.TrialState_ENDED, infos.TrialInfos[0].State)
+	result, err := fxt.backend.GetTrialResult(fxt.ctx, "trial-1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"return": 12.5, "steps": 1}, result.Scores)
+	assert.Equal(t, []byte("details"), result.Data)
+
+	// Ending a trial without result doesn't set any
+	err = addSample("trial-2", "trial-id", "trial-2", "end-trial", "true")
+	assert.NoError(t, err)
+	_, err = fxt.backend.GetTrialResult(fxt.ctx, "trial-2")
+	var noTrialResultErr *backend.NoTrialResultError
+	assert.ErrorAs(t, err, &noTrialResultErr)
+
+	for _, headers := range [][]string{
+		{"trial-id", "trial-2", "trial-result-scores", "return=1"},
+		{"end-trial", "true", "trial-result-scores", "return=1"},
+		{"trial-id", "trial-2", "end-trial", "true", "trial-result-scores", "return"},
+	} {
+		err = addSample("trial-2", headers...)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), headers)
+	}
+}
+
+func TestAddSamplesStrictTickOrder(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{TickOrderValidation: StrictTickOrder})
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 1)
+	addSamples := func(tickIDs ...uint64) error {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
+		stream, err := fxt.client.AddSample(ctx)
+		assert.NoError(t, err)
+		for _, tickID := range tickIDs {
+			err = stream.Send(&grpcapi.AddSampleRequest{
+				TrialSample: &grpcapi.StoredTrialSample{TickId: tickID, State: grpcapi.TrialState_RUNNING},
+			})
+			if err != nil {
+				break
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+	storedSamplesCount := func() int {
+		trialsInfo, err := fxt.backend.RetrieveTrials(fxt.ctx, []string{"trial0"}, -1, -1)
+		assert.NoError(t, err)
+		return trialsInfo.TrialInfos[0].StoredSamplesCount
+	}
+
+	assert.NoError(t, addSamples(0, 1, 2))
+	assert.Equal(t, 3, storedSamplesCount())
+
+	// Decreasing ticks are rejected, the previous samples are stored
+	err = addSamples(3, 4, 1, 5)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "out of order tick 1")
+	assert.Equal(t, 5, storedSamplesCount())
+
+	// The ticks of the previous calls are taken into account
+	err = addSamples(4)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, 5, storedSamplesCount())
+
+	assert.NoError(t, addSamples(5))
+	assert.Equal(t, 6, storedSamplesCount())
+}
+
+func TestAddSamplesRateLimit(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{AddSampleRateLimit: utils.RateLimit{SamplesPerSecond: 10}})
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 2)
+	nextTickIDs := map[string]uint64{}
+	addSamples := func(trialID string, samplesCount int, md ...string) error {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, append([]string{"trial-id", trialID}, md...)...)
+		stream, err := fxt.client.AddSample(ctx)
+		assert.NoError(t, err)
+		for sampleIdx := 0; sampleIdx < samplesCount; sampleIdx++ {
+			err = stream.Send(&grpcapi.AddSampleRequest{
+				TrialSample: &grpcapi.StoredTrialSample{TickId: nextTickIDs[trialID], State: grpcapi.TrialState_RUNNING},
+			})
+			if err != nil {
+				break
+			}
+			nextTickIDs[trialID]++
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+	storedSamplesCount := func(trialID string) int {
+		trialsInfo, err := fxt.backend.RetrieveTrials(fxt.ctx, []string{trialID}, -1, -1)
+		assert.NoError(t, err)
+		return trialsInfo.TrialInfos[0].StoredSamplesCount
+	}
+
+	// Going past the limit, the samples within the limit are stored
+	err = addSamples("trial0", 100)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "rate limit of 10 samples/s")
+	assert.GreaterOrEqual(t, storedSamplesCount("trial0"), 10)
+	assert.Less(t, storedSamplesCount("trial0"), 100)
+
+	// Other trials are unaffected
+	assert.NoError(t, addSamples("trial1", 10))
+	assert.Equal(t, 10, storedSamplesCount("trial1"))
+
+	// Calls can't raise the limit of their trials
+	for _, samplesRateLimit := range []string{"0", "1000"} {
+		err = addSamples("trial0", 100, "samples-rate-limit", samplesRateLimit)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "rate limit of 10 samples/s")
+	}
+
+	// They can lower it
+	err = addSamples("trial1", 100, "samples-rate-limit", "5", "bytes-rate-limit", "1000000")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "rate limit of 5 samples/s and 1000000 bytes/s")
+
+	err = addSamples("trial1", 1, "bytes-rate-limit", "-1")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAddSamplesReorderTicks(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{TickOrderValidation: ReorderTicks})
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 1)
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
+	stream, err := fxt.client.AddSample(ctx)
+	assert.NoError(t, err)
+	for _, tickID := range []uint64{1, 0, 3, 2} {
+		err = stream.Send(&grpcapi.AddSampleRequest{
+			TrialSample: &grpcapi.StoredTrialSample{TickId: tickID, State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+	}
+	_, err = stream.CloseAndRecv()
+	assert.NoError(t, err)
+
+	retrieveStream, err := fxt.client.RetrieveSamples(fxt.ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0"}})
+	assert.NoError(t, err)
+	for _, expectedTickID := range []uint64{0, 1, 2, 3} {
+		msg, err := retrieveStream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, expectedTickID, msg.GetTrialSample().TickId)
+	}
+}
+
+func TestAddSamplesEndTrial(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 1)
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: "trial0", TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+
+	retrieveStream, err := fxt.client.RetrieveSamples(fxt.ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0"}})
+	assert.NoError(t, err)
+	msg, err := retrieveStream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), msg.GetTrialSample().TickId)
+
+	// Ending the trial without any sample, e.g. when its producer crashed
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0", "end-trial", "true")
+	addStream, err := fxt.client.AddSample(ctx)
+	assert.NoError(t, err)
+	_, err = addStream.CloseAndRecv()
+	assert.NoError(t, err)
+
+	// The ongoing retrieval ends
+	_, err = retrieveStream.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: []string{"trial0"}})
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 1)
+	assert.Equal(t, grpcapi.TrialState_ENDED, rep.TrialInfos[0].LastState)
+	assert.Equal(t, uint32(1), rep.TrialInfos[0].SamplesCount)
+
+	ctx = metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "unknown-trial", "end-trial", "true")
+	addStream, err = fxt.client.AddSample(ctx)
+	assert.NoError(t, err)
+	_, err = addStream.CloseAndRecv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAddSamplesMultipleTrials(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{TickOrderValidation: StrictTickOrder})
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 2)
+	addSamples := func(ctx context.Context, samples ...*grpcapi.StoredTrialSample) error {
+		stream, err := fxt.client.AddSample(ctx)
+		assert.NoError(t, err)
+		for _, sample := range samples {
+			err = stream.Send(&grpcapi.AddSampleRequest{TrialSample: sample})
+			if err != nil {
+				break
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	// Without the `trial-id` header metadata, samples are routed following their trial id, the tick order being
+	// validated independently for each trial
+	samples := []*grpcapi.StoredTrialSample{}
+	for tickID := uint64(0); tickID < 5; tickID++ {
+		samples = append(
+			samples,
+			&grpcapi.StoredTrialSample{TrialId: "trial0", TickId: tickID, State: grpcapi.TrialState_RUNNING},
+			&grpcapi.StoredTrialSample{TrialId: "trial1", TickId: tickID, State: grpcapi.TrialState_RUNNING},
+		)
+	}
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "end-trial", "true")
+	assert.NoError(t, addSamples(ctx, samples...))
+
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: []string{"trial0", "trial1"}})
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 2)
+	for _, trialInfo := range rep.TrialInfos {
+		assert.Equal(t, uint32(5), trialInfo.SamplesCount)
+		assert.Equal(t, grpcapi.TrialState_ENDED, trialInfo.LastState)
+	}
+
+	// Samples of unknown trials are rejected
+	err = addSamples(
+		fxt.ctx,
+		&grpcapi.StoredTrialSample{TrialId: "trial0", TickId: 5, State: grpcapi.TrialState_RUNNING},
+		&grpcapi.StoredTrialSample{TrialId: "unknown-trial", TickId: 0, State: grpcapi.TrialState_RUNNING},
+	)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "unknown-trial")
+
+	// Samples without trial id require the `trial-id` header metadata
+	err = addSamples(fxt.ctx, &grpcapi.StoredTrialSample{TickId: 6, State: grpcapi.TrialState_RUNNING})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAddAndRetrieveSamplesMaxMessageSize(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixtureWithServerOptions(
+		GrpcServerOptions{MaxReceivedMessageSize: 1024, MaxSentMessageSize: 1024},
+		TrialDatastoreServerOptions{MaxSentMessageSize: 1024},
+	)
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 1)
+
+	// Many small samples are fine
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
+	stream, err := fxt.client.AddSample(ctx)
+	assert.NoError(t, err)
+	for tickID := uint64(0); tickID < 100; tickID++ {
+		err = stream.Send(&grpcapi.AddSampleRequest{
+			TrialSample: &grpcapi.StoredTrialSample{TickId: tickID, State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{make([]byte, 512)}},
+		})
+		assert.NoError(t, err)
+	}
+	_, err = stream.CloseAndRecv()
+	assert.NoError(t, err)
+
+	// A single huge one isn't
+	stream, err = fxt.client.AddSample(ctx)
+	assert.NoError(t, err)
+	for _, payloadSize := range []int{512, 2048} {
+		err = stream.Send(&grpcapi.AddSampleRequest{
+			TrialSample: &grpcapi.StoredTrialSample{TickId: 100, State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{make([]byte, payloadSize)}},
+		})
+		if err != nil {
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "vs. 1024")
+
+	// Samples too large to be sent are described
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+		{TrialId: "trial0", TickId: 101, State: grpcapi.TrialState_ENDED, Payloads: [][]byte{make([]byte, 16), make([]byte, 2048)}},
+	})
+	assert.NoError(t, err)
+	retrieveStream, err := fxt.client.RetrieveSamples(fxt.ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0"}})
+	assert.NoError(t, err)
+	for tickID := uint64(0); tickID <= 100; tickID++ {
+		msg, err := retrieveStream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, tickID, msg.GetTrialSample().TickId)
+	}
+	_, err = retrieveStream.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), `the sample of trial "trial0" at tick 101 is`)
+	assert.Contains(t, status.Convert(err).Message(), "its largest payload being 2048 bytes, larger than the maximum message size of 1024 bytes")
+}
+
+func TestAddSamplesInconsistentTrialId(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 1)
+
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
+	stream, err := fxt.client.AddSample(ctx)
+	assert.NoError(t, err)
+	err = stream.Send(&grpcapi.AddSampleRequest{
+		TrialSample: &grpcapi.StoredTrialSample{TrialId: "foo", UserId: "my_user", State: grpcapi.TrialState_RUNNING},
+	})
+	assert.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.Error(t, err)
+	s, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, s.Code(), codes.InvalidArgument)
+}
+
+// slowBackend is a backend whose samples additions are blocked until `release` is closed
+type slowBackend struct {
+	backend.Backend
+	release           chan struct{}
+	err               error
+	addedSamplesCount int64
+}
+
+func (b *slowBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error {
+	<-b.release
+	if b.err != nil {
+		return b.err
+	}
+	err := b.Backend.AddSamples(ctx, samples)
+	atomic.AddInt64(&b.addedSamplesCount, int64(len(samples)))
+	return err
+}
+
+// recvCountingServerStream counts the messages received by a server stream
+type recvCountingServerStream struct {
+	grpc.ServerStream
+	onRecv func()
+}
+
+func (s *recvCountingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.onRecv()
+	}
+	return err
+}
+
+func createSlowBackendClient(t *testing.T, slowBackend *slowBackend, options TrialDatastoreServerOptions, onRecv func()) (grpcapi.TrialDatastoreSPClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &recvCountingServerStream{ServerStream: ss, onRecv: onRecv})
+	}))
+	err := RegisterTrialDatastoreServerWithOptions(server, slowBackend, options)
+	assert.NoError(t, err)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Fatalf("Server exited with error: %v", err)
+		}
+	}()
+
+	connection, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}), grpc.WithInsecure())
+	assert.NoError(t, err)
+
+	return grpcapi.NewTrialDatastoreSPClient(connection), func() {
+		connection.Close()
+		server.Stop()
+	}
+}
+
+func TestAddSamplesBoundedBuffer(t *testing.T) {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	slowBackend := &slowBackend{Backend: b, release: make(chan struct{})}
+
+	receivedSamplesCount := int64(0)
+	maxPendingSamplesCount := int64(0)
+	pendingSamplesCountMutex := sync.Mutex{}
+	client, destroy := createSlowBackendClient(t, slowBackend, TrialDatastoreServerOptions{AddSampleBufferSize: 10}, func() {
+		pendingSamplesCountMutex.Lock()
+		defer pendingSamplesCountMutex.Unlock()
+		receivedSamplesCount++
+		pendingSamplesCount := receivedSamplesCount - atomic.LoadInt64(&slowBackend.addedSamplesCount)
+		if pendingSamplesCount > maxPendingSamplesCount {
+			maxPendingSamplesCount = pendingSamplesCount
+		}
+	})
+	defer destroy()
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "trial0", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+
+	samplesCount := 2000
+	addSamplesErr := make(chan error)
+	go func() {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "trial-id", "trial0")
+		stream, err := client.AddSample(ctx)
+		if err != nil {
+			addSamplesErr <- err
+			return
+		}
+		for tickID := 0; tickID < samplesCount; tickID++ {
+			err = stream.Send(&grpcapi.AddSampleRequest{
+				TrialSample: &grpcapi.StoredTrialSample{TickId: uint64(tickID), State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{make([]byte, 256)}},
+			})
+			if err != nil {
+				addSamplesErr <- err
+				return
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		addSamplesErr <- err
+	}()
+
+	// While the backend is blocked, the server stops reading the stream
+	time.Sleep(200 * time.Millisecond)
+	pendingSamplesCountMutex.Lock()
+	assert.Less(t, receivedSamplesCount, int64(samplesCount))
+	pendingSamplesCountMutex.Unlock()
+
+	close(slowBackend.release)
+	assert.NoError(t, <-addSamplesErr)
+
+	// At most a chunk being added, the buffer and a sample waiting to be buffered are pending
+	assert.LessOrEqual(t, maxPendingSamplesCount, int64(100+10+1))
+
+	// No sample is lost
+	trialsInfo, err := b.RetrieveTrials(context.Background(), []string{"trial0"}, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, samplesCount, trialsInfo.TrialInfos[0].StoredSamplesCount)
+}
+
+func TestAddSamplesBackendFailure(t *testing.T) {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	slowBackend := &slowBackend{Backend: b, release: make(chan struct{}), err: fmt.Errorf("backend failure")}
+	close(slowBackend.release)
+
+	client, destroy := createSlowBackendClient(t, slowBackend, TrialDatastoreServerOptions{AddSampleBufferSize: 10}, func() {})
+	defer destroy()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "trial-id", "trial0")
+	stream, err := client.AddSample(ctx)
+	assert.NoError(t, err)
+	for tickID := 0; tickID < 1000; tickID++ {
+		err = stream.Send(&grpcapi.AddSampleRequest{TrialSample: &grpcapi.StoredTrialSample{TickId: uint64(tickID)}})
+		if err != nil {
+			// The server ended the stream
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	assert.Error(t, err)
+	s, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Internal, s.Code())
+}
+
+// callTrackingServerStream flags the messages received by a server stream after its handler returned
+type callTrackingServerStream struct {
+	grpc.ServerStream
+	handlerReturned *int32
+	lateRecv        *int32
+}
+
+func (s *callTrackingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if atomic.LoadInt32(s.handlerReturned) != 0 {
+		atomic.StoreInt32(s.lateRecv, 1)
+	}
+	return err
+}
+
+func TestAddSamplesBackendFailureStopsReceiving(t *testing.T) {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	slowBackend := &slowBackend{Backend: b, release: make(chan struct{}), err: fmt.Errorf("backend failure")}
+	close(slowBackend.release)
+
+	handlerReturned := int32(0)
+	lateRecv := int32(0)
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, &callTrackingServerStream{ServerStream: ss, handlerReturned: &handlerReturned, lateRecv: &lateRecv})
+		atomic.StoreInt32(&handlerReturned, 1)
+		return err
+	}))
+	err = RegisterTrialDatastoreServerWithOptions(server, slowBackend, TrialDatastoreServerOptions{})
+	assert.NoError(t, err)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Fatalf("Server exited with error: %v", err)
+		}
+	}()
+	defer server.Stop()
+
+	connection, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer connection.Close()
+	client := grpcapi.NewTrialDatastoreSPClient(connection)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "trial-id", "trial0")
+	stream, err := client.AddSample(ctx)
+	assert.NoError(t, err)
+	err = stream.Send(&grpcapi.AddSampleRequest{TrialSample: &grpcapi.StoredTrialSample{TickId: 0}})
+	assert.NoError(t, err)
+
+	// The backend fails while the stream remains open, the receiver is still waiting for the next sample
+	time.Sleep(100 * time.Millisecond)
+	_, err = stream.CloseAndRecv()
+	assert.Error(t, err)
+	s, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Internal, s.Code())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&lateRecv))
+}
+
+func TestAddAndRetrieveSamplesConcurrent(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 2)
+
+	wg := sync.WaitGroup{}
+
+	for _, trialID := range []string{"trial0", "trial1"} {
+		wg.Add(1)
+		trialID := trialID
+		go func() {
+			defer wg.Done()
+
+			ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", trialID)
+			stream, err := fxt.client.AddSample(ctx)
+			assert.NoError(t, err)
+
+			for i := 0; i < 1000; i++ {
+				err = stream.Send(&grpcapi.AddSampleRequest{
+					TrialSample: &grpcapi.StoredTrialSample{UserId: "my_user", State: grpcapi.TrialState_RUNNING, TickId: uint64(i)},
+				})
+				assert.NoError(t, err)
+			}
+
+			err = stream.Send(&grpcapi.AddSampleRequest{
+				TrialSample: &grpcapi.StoredTrialSample{UserId: "my_user", State: grpcapi.TrialState_ENDED, TickId: uint64(1000)},
+			})
+			assert.NoError(t, err)
+
+			_, err = stream.CloseAndRecv()
+			assert.NoError(t, err)
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			stream, err := fxt.client.RetrieveSamples(fxt.ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0", "trial1"}})
+			assert.NoError(t, err)
+
+			nextTrial0TickID := 0
+			nextTrial1TickID := 0
+
+			for i := 0; i < 2002; i++ {
+				msg, err := stream.Recv()
+				assert.NoError(t, err)
+				sample := msg.GetTrialSample()
+				assert.NotNil(t, sample)
+				assert.Equal(t, "my_user", sample.UserId)
+				assert.Contains(t, []string{"trial0", "trial1"}, sample.TrialId)
+				if sample.TrialId == "trial0" {
+					assert.Equal(t, nextTrial0TickID, int(sample.TickId))
+					nextTrial0TickID++
+				} else {
+					assert.Equal(t, nextTrial1TickID, int(sample.TickId))
+					nextTrial1TickID++
+				}
+			}
+
+			_, err = stream.Recv()
+			assert.Equal(t, io.EOF, err)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestDeleteTrials(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 10)
+	selectedTrialIds := []string{"trial0", "trial9", "trial5", "trial2", "trial3"}
+
+	{
+		// Make sure retrieval works as expected
+		expectedTrialIds := []string{"trial0", "trial2", "trial3", "trial5", "trial9"} // Trials should be returned according to their creation order
+		rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, Timeout: 500})
+		assert.NoError(t, err)
+		assert.Equal(t, 10, parseNextTrialIdx(t, rep.NextTrialHandle))
+		assert.Len(t, rep.TrialInfos, len(expectedTrialIds))
+		for i, trialInfo := range rep.TrialInfos {
+			assert.Equal(t, expectedTrialIds[i], trialInfo.TrialId)
+			assert.Equal(t, grpcapi.TrialState_UNKNOWN, trialInfo.LastState)
+			assert.Equal(t, "test", trialInfo.UserId)
+		}
+	}
+
+	{
+		// Delete a few trials
+		_, err = fxt.client.DeleteTrials(fxt.ctx, &grpcapi.DeleteTrialsRequest{TrialIds: []string{"trial2", "trial3", "trial4"}})
+		assert.NoError(t, err)
+	}
+
+	t.Run("DeletedTrialsNotRetrievedWTimeout", func(t *testing.T) {
+		expectedTrialIds := []string{"trial0", "trial5", "trial9"} // Trials should be returned according to their creation order
+		rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, Timeout: 500})
+		assert.NoError(t, err)
+		assert.Equal(t, 10, parseNextTrialIdx(t, rep.NextTrialHandle))
+		assert.Len(t, rep.TrialInfos, len(expectedTrialIds))
+		for i, trialInfo := range rep.TrialInfos {
+			assert.Equal(t, expectedTrialIds[i], trialInfo.TrialId)
+			assert.Equal(t, grpcapi.TrialState_UNKNOWN, trialInfo.LastState)
+			assert.Equal(t, "test", trialInfo.UserId)
+		}
+	})
+
+	t.Run("DeletedTrialsNotRetrievedWOTimeout", func(t *testing.T) {
+		expectedTrialIds := []string{"trial0", "trial5", "trial9"} // Trials should be returned according to their creation order
+		rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds})
+		assert.NoError(t, err)
+		assert.Equal(t, 10, parseNextTrialIdx(t, rep.NextTrialHandle))
+		assert.Len(t, rep.TrialInfos, len(expectedTrialIds))
+		for i, trialInfo := range rep.TrialInfos {
+			assert.Equal(t, expectedTrialIds[i], trialInfo.TrialId)
+			assert.Equal(t, grpcapi.TrialState_UNKNOWN, trialInfo.LastState)
+			assert.Equal(t, "test", trialInfo.UserId)
+		}
+	})
+
+	t.Run("CantAddSampleToDeletedTrial", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial3")
+		stream, err := fxt.client.AddSample(ctx)
+		assert.NoError(t, err)
+		err = stream.Send(&grpcapi.AddSampleRequest{
+			TrialSample: &grpcapi.StoredTrialSample{TrialId: "foo", UserId: "my_user", State: grpcapi.TrialState_RUNNING},
+		})
+		assert.NoError(t, err)
+		_, err = stream.CloseAndRecv()
+		assert.Error(t, err)
+		s, ok := status.FromError(err)
+		assert.True(t, ok)
+		assert.Equal(t, s.Code(), codes.InvalidArgument)
+	})
+}
+
+func TestRetrieveTrialsAbandoned(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	for _, trialID := range []string{"trial-1", "trial-2", "trial-3"} {
+		_, err := fxt.client.AddTrial(metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", trialID), &grpcapi.AddTrialRequest{UserId: "foo", TrialParams: &grpcapi.TrialParams{}})
+		assert.NoError(t, err)
+	}
+	err = fxt.backend.AbandonTrials(fxt.ctx, []string{"trial-2"})
+	assert.NoError(t, err)
+	err = fxt.backend.EndTrials(fxt.ctx, []string{"trial-3"})
+	assert.NoError(t, err)
+
+	var headerMD metadata.MD
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{}, grpc.Header(&headerMD))
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 3)
+	assert.Equal(t, grpcapi.TrialState_ENDED, rep.TrialInfos[1].LastState)
+	assert.Equal(t, grpcapi.TrialState_ENDED, rep.TrialInfos[2].LastState)
+	// Abandoned trials are distinguished from the trials explicitly ended
+	assert.Equal(t, []string{"trial-2"}, headerMD.Get("abandoned-trials"))
+
+	rep, err = fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: []string{"trial-1", "trial-3"}}, grpc.Header(&headerMD))
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 2)
+	assert.Empty(t, headerMD.Get("abandoned-trials"))
+}
+
+func TestRetrieveTrialsCreationTimestamps(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	before := time.Now()
+	for _, trialID := range []string{"trial-3", "trial-1", "trial-2"} {
+		_, err := fxt.client.AddTrial(metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", trialID), &grpcapi.AddTrialRequest{UserId: "foo", TrialParams: &grpcapi.TrialParams{MaxSteps: 12}})
+		assert.NoError(t, err)
+	}
+
+	var headerMD metadata.MD
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{}, grpc.Header(&headerMD))
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 3)
+	assert.Equal(t, "trial-3", rep.TrialInfos[0].TrialId)
+	assert.Equal(t, "trial-1", rep.TrialInfos[1].TrialId)
+	assert.Equal(t, "trial-2", rep.TrialInfos[2].TrialId)
+
+	creationTimestamps := headerMD.Get("trial-creation-timestamps")
+	assert.Len(t, creationTimestamps, 3)
+	previousCreationTimestamp := before.UnixNano()
+	for _, serializedCreationTimestamp := range creationTimestamps {
+		creationTimestamp, err := strconv.ParseInt(serializedCreationTimestamp, 10, 64)
+		assert.NoError(t, err)
+		assert.Greater(t, creationTimestamp, previousCreationTimestamp)
+		previousCreationTimestamp = creationTimestamp
+	}
+
+	// Deleting the trials created before "trial-2"
+	var deleteHeaderMD metadata.MD
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "created-before", creationTimestamps[2])
+	_, err = fxt.client.DeleteTrials(ctx, &grpcapi.DeleteTrialsRequest{}, grpc.Header(&deleteHeaderMD))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2"}, deleteHeaderMD.Get("deleted-trials-count"))
+	rep, err = fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 1)
+	assert.Equal(t, "trial-2", rep.TrialInfos[0].TrialId)
+}
+
+func TestRetrieveTrialsByTags(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	for trialID, tags := range map[string][]string{
+		"trial-1": {"experiment=foo", "seed=1"},
+		"trial-2": {"experiment=bar", "seed=1"},
+		"trial-3": {"experiment=foo", "seed=2"},
+		"trial-4": {"experiment=foo"},
+		"trial-5": {},
+	} {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", trialID)
+		for _, tag := range tags {
+			ctx = metadata.AppendToOutgoingContext(ctx, "trial-tags", tag)
+		}
+		_, err := fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "foo", TrialParams: &grpcapi.TrialParams{MaxSteps: 12}})
+		assert.NoError(t, err)
+	}
+	retrievedTrialIDs := func(rep *grpcapi.RetrieveTrialsReply) []string {
+		trialIDs := []string{}
+		for _, trialInfo := range rep.TrialInfos {
+			trialIDs = append(trialIDs, trialInfo.TrialId)
+		}
+		sort.Strings(trialIDs)
+		return trialIDs
+	}
+
+	t.Run("SingleTag", func(t *testing.T) {
+		var headerMD metadata.MD
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-tags", "experiment=foo")
+		rep, err := fxt.client.RetrieveTrials(ctx, &grpcapi.RetrieveTrialsRequest{}, grpc.Header(&headerMD))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"trial-1", "trial-3", "trial-4"}, retrievedTrialIDs(rep))
+
+		serializedTags := headerMD.Get("trial-tags")
+		assert.Len(t, serializedTags, 3)
+		for trialInfoIdx, trialInfo := range rep.TrialInfos {
+			tags := map[string]string{}
+			err := json.Unmarshal([]byte(serializedTags[trialInfoIdx]), &tags)
+			assert.NoError(t, err)
+			assert.Equal(t, "foo", tags["experiment"], trialInfo.TrialId)
+		}
+	})
+
+	t.Run("MultipleTags", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-tags", "experiment=foo,seed=1")
+		rep, err := fxt.client.RetrieveTrials(ctx, &grpcapi.RetrieveTrialsRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"trial-1"}, retrievedTrialIDs(rep))
+
+		ctx = metadata.AppendToOutgoingContext(fxt.ctx, "trial-tags", "seed=1", "trial-tags", "experiment=baz")
+		rep, err = fxt.client.RetrieveTrials(ctx, &grpcapi.RetrieveTrialsRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, rep.TrialInfos, 0)
+	})
+
+	t.Run("ExactMatch", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-tags", "experiment=foo", "trial-tags-match", "exact")
+		rep, err := fxt.client.RetrieveTrials(ctx, &grpcapi.RetrieveTrialsRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"trial-4"}, retrievedTrialIDs(rep))
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		// Pages hold the requested count of matching trials, whatever the number of trials in between
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-tags", "experiment=foo")
+		retrievedTrialsCount := 0
+		pagesCount := 0
+		trialHandle := ""
+		for {
+			rep, err := fxt.client.RetrieveTrials(ctx, &grpcapi.RetrieveTrialsRequest{TrialsCount: 2, TrialHandle: trialHandle})
+			assert.NoError(t, err)
+			if len(rep.TrialInfos) == 0 {
+				break
+			}
+			pagesCount++
+			retrievedTrialsCount += len(rep.TrialInfos)
+			trialHandle = rep.NextTrialHandle
+		}
+		assert.Equal(t, 3, retrievedTrialsCount)
+		assert.Equal(t, 2, pagesCount)
+	})
+
+	t.Run("InvalidTags", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-tags", "experiment")
+		_, err := fxt.client.RetrieveTrials(ctx, &grpcapi.RetrieveTrialsRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		ctx = metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial-6", "trial-tags", "=foo")
+		_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("ConflictingRegistration", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial-4", "trial-tags", "experiment=bar")
+		_, err := fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "foo", TrialParams: &grpcapi.TrialParams{MaxSteps: 12}})
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+		assert.Contains(t, err.Error(), "trial-tags")
+	})
+}
+
+func TestDeleteTrialsMatching(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	createTrials(t, &fxt, 12)
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{
+		{TrialId: "trial1", TickId: 0, State: grpcapi.TrialState_RUNNING},
+		{TrialId: "trial1", TickId: 1, State: grpcapi.TrialState_ENDED},
+		{TrialId: "trial10", TickId: 0, State: grpcapi.TrialState_ENDED},
+	})
+	assert.NoError(t, err)
+
+	deleteTrials := func(trialIDs []string, headers ...string) (metadata.MD, error) {
+		var headerMD metadata.MD
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, headers...)
+		_, err := fxt.client.DeleteTrials(ctx, &grpcapi.DeleteTrialsRequest{TrialIds: trialIDs}, grpc.Header(&headerMD))
+		return headerMD, err
+	}
+	retrieveTrialIDs := func() []string {
+		rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{})
+		assert.NoError(t, err)
+		trialIDs := []string{}
+		for _, trialInfo := range rep.TrialInfos {
+			trialIDs = append(trialIDs, trialInfo.TrialId)
+		}
+		return trialIDs
+	}
+
+	// Strict deletions of unknown trials fail
+	_, err = deleteTrials([]string{"trial2", "trial42"}, "strict", "true")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Len(t, retrieveTrialIDs(), 12)
+
+	// Unknown trials are skipped otherwise
+	headerMD, err := deleteTrials([]string{"trial2", "trial42"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, headerMD.Get("deleted-trials-count"))
+	assert.Equal(t, []string{"0"}, headerMD.Get("deleted-samples-count"))
+
+	headerMD, err = deleteTrials(nil, "trial-id-prefix", "trial1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3"}, headerMD.Get("deleted-trials-count"))
+	assert.Equal(t, []string{"3"}, headerMD.Get("deleted-samples-count"))
+	assert.Equal(t, []string{"trial0", "trial3", "trial4", "trial5", "trial6", "trial7", "trial8", "trial9"}, retrieveTrialIDs())
+
+	// Both the ids and the prefix need to match
+	headerMD, err = deleteTrials([]string{"trial3", "trial4"}, "trial-id-prefix", "trial4")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, headerMD.Get("deleted-trials-count"))
+	assert.Equal(t, []string{"trial0", "trial3", "trial5", "trial6", "trial7", "trial8", "trial9"}, retrieveTrialIDs())
+
+	// Neither ids nor prefix, nothing is deleted
+	headerMD, err = deleteTrials(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0"}, headerMD.Get("deleted-trials-count"))
+	assert.Len(t, retrieveTrialIDs(), 7)
+}
+
+func TestAddTrialInvalidTrialID(t *testing.T) {
+	trialIDValidator, err := utils.CreateTrialIDValidator(utils.DefaultTrialIDAllowedCharacters, 16, false)
+	assert.NoError(t, err)
+	fxt, err := createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{TrialIDValidator: trialIDValidator})
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	for _, trialID := range []string{"my/trial", "my trial", "my-very-long-trial-id"} {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", trialID)
+		_, err := fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{}})
+		assert.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 0)
+}
+
+func TestAddTrialValidateOnly(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my-trial", "validate-only", "true")
+	var header metadata.MD
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{TrialParams: &grpcapi.TrialParams{
+		Actors: []*grpcapi.ActorParams{
+			{Name: "player", Endpoint: "grpc://player:9000"},
+			{Name: "player", Endpoint: "player:9000"},
+		},
+	}}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`actors[1].name: actor name "player" already used by actors[0]`,
+		`actors[1].endpoint: invalid endpoint "player:9000", expecting a "grpc" or "cogment" scheme`,
+	}, header.Get("trial-params-problems"))
+
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{TrialParams: &grpcapi.TrialParams{
+		Actors: []*grpcapi.ActorParams{{Name: "player"}},
+	}}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Empty(t, header.Get("trial-params-problems"))
+
+	// Nothing is stored
+	exists, err := backend.TrialExists(fxt.ctx, fxt.backend, "my-trial")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestAddTrialIdempotent(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my-trial")
+	req := &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{
+		Actors:   []*grpcapi.ActorParams{{Name: "player", ActorClass: "pl"}},
+		MaxSteps: 100,
+	}}
+	_, err = fxt.client.AddTrial(ctx, req)
+	assert.NoError(t, err)
+
+	stream, err := fxt.client.AddSample(ctx)
+	assert.NoError(t, err)
+	err = stream.Send(&grpcapi.AddSampleRequest{TrialSample: &grpcapi.StoredTrialSample{TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.NoError(t, err)
+
+	// Retrying with the same params is a no-op
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{
+		Actors:   []*grpcapi.ActorParams{{Name: "player", ActorClass: "pl"}},
+		MaxSteps: 100,
+	}})
+	assert.NoError(t, err)
+
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 1)
+	assert.Equal(t, uint32(1), rep.TrialInfos[0].SamplesCount)
+	assert.Equal(t, uint32(100), rep.TrialInfos[0].Params.MaxSteps)
+}
+
+func TestAddTrialAfterDeletion(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my-trial")
+	addSample := func(tickID uint64) error {
+		stream, err := fxt.client.AddSample(ctx)
+		assert.NoError(t, err)
+		err = stream.Send(&grpcapi.AddSampleRequest{TrialSample: &grpcapi.StoredTrialSample{TickId: tickID, State: grpcapi.TrialState_RUNNING}})
 		assert.NoError(t, err)
+		_, err = stream.CloseAndRecv()
+		return err
 	}
 
-	{
-		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
+	for _, maxSteps := range []uint32{100, 100, 200} {
+		_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{MaxSteps: maxSteps}})
+		assert.NoError(t, err)
+		assert.NoError(t, addSample(0))
+		assert.NoError(t, addSample(1))
+
+		rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, rep.TrialInfos, 1)
+		assert.Equal(t, uint32(2), rep.TrialInfos[0].SamplesCount)
+		assert.Equal(t, maxSteps, rep.TrialInfos[0].Params.MaxSteps)
+
+		// Deleted trials can be registered again, with the same or different params
+		_, err = fxt.client.DeleteTrials(fxt.ctx, &grpcapi.DeleteTrialsRequest{TrialIds: []string{"my-trial"}})
+		assert.NoError(t, err)
+		_, err = fxt.backend.GetTrialParams(fxt.ctx, []string{"my-trial"})
+		assert.ErrorIs(t, err, backend.ErrTrialNotFound)
+	}
+}
+
+func TestAddTrialConflicting(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my-trial")
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{
+		Actors:   []*grpcapi.ActorParams{{Name: "player", ActorClass: "pl"}},
+		MaxSteps: 100,
+	}})
+	assert.NoError(t, err)
+
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{
+		Actors:   []*grpcapi.ActorParams{{Name: "player", ActorClass: "pl"}},
+		MaxSteps: 200,
+	}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "differing fields: trial_params.max_steps")
+
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "other", TrialParams: &grpcapi.TrialParams{
+		MaxSteps: 100,
+	}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "differing fields: user_id, trial_params.actors")
+
+	// The registered params are kept
+	trialsParams, err := fxt.backend.GetTrialParams(fxt.ctx, []string{"my-trial"})
+	assert.NoError(t, err)
+	assert.Equal(t, "test", trialsParams[0].UserID)
+	assert.Equal(t, uint32(100), trialsParams[0].Params.MaxSteps)
+}
+
+func TestAddTrialSampleOrderingKey(t *testing.T) {
+	fxt, err := createTrialDatastoreServerTestFixture()
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my-trial", "sample-ordering-key", "timestamp")
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{}})
+	assert.NoError(t, err)
+
+	trialsParams, err := fxt.backend.GetTrialParams(fxt.ctx, []string{"my-trial"})
+	assert.NoError(t, err)
+	assert.Equal(t, "timestamp", trialsParams[0].SampleOrderingKey.String())
+
+	for _, sampleOrderingKey := range []string{"unknown_field", "actor_samples"} {
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my-other-trial", "sample-ordering-key", sampleOrderingKey)
+		_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func TestAddSampleInvalidTrialID(t *testing.T) {
+	trialIDValidator, err := utils.CreateTrialIDValidator(utils.DefaultTrialIDAllowedCharacters, 16, false)
+	assert.NoError(t, err)
+	fxt, err := createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{TrialIDValidator: trialIDValidator})
+	assert.NoError(t, err)
+	defer fxt.destroy()
+
+	addSample := func(ctx context.Context, trialID string) error {
 		stream, err := fxt.client.AddSample(ctx)
 		assert.NoError(t, err)
 		err = stream.Send(&grpcapi.AddSampleRequest{
-			TrialSample: &grpcapi.StoredTrialSample{UserId: "my_user", State: grpcapi.TrialState_RUNNING},
+			TrialSample: &grpcapi.StoredTrialSample{TrialId: trialID, UserId: "test", State: grpcapi.TrialState_ENDED},
 		})
-		assert.NoError(t, err)
+		if err != nil {
+			return err
+		}
 		_, err = stream.CloseAndRecv()
-		assert.NoError(t, err)
+		return err
+	}
+
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my-trial")
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{}})
+	assert.NoError(t, err)
+	assert.NoError(t, addSample(fxt.ctx, "my-trial"))
+
+	for _, trialID := range []string{"my/trial", "my trial", "my-very-long-trial-id"} {
+		// Invalid trial ids are rejected, instead of being reported as unknown trials
+		err = addSample(fxt.ctx, trialID)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", trialID)
+		err = addSample(ctx, "")
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
 	}
 }
 
-func TestAddSamplesInconsistentTrialId(t *testing.T) {
+func TestAddSampleUnvalidatedTrialID(t *testing.T) {
 	fxt, err := createTrialDatastoreServerTestFixture()
 	assert.NoError(t, err)
 	defer fxt.destroy()
 
-	createTrials(t, &fxt, 1)
+	// Without validator, every trial id is accepted as is
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my trial/ü")
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{}})
+	assert.NoError(t, err)
 
-	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial0")
 	stream, err := fxt.client.AddSample(ctx)
 	assert.NoError(t, err)
 	err = stream.Send(&grpcapi.AddSampleRequest{
-		TrialSample: &grpcapi.StoredTrialSample{TrialId: "foo", UserId: "my_user", State: grpcapi.TrialState_RUNNING},
+		TrialSample: &grpcapi.StoredTrialSample{UserId: "test", State: grpcapi.TrialState_ENDED},
 	})
 	assert.NoError(t, err)
 	_, err = stream.CloseAndRecv()
-	assert.Error(t, err)
-	s, ok := status.FromError(err)
-	assert.True(t, ok)
-	assert.Equal(t, s.Code(), codes.InvalidArgument)
+	assert.NoError(t, err)
+
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: []string{"my trial/ü"}})
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 1)
+	assert.Equal(t, uint32(1), rep.TrialInfos[0].SamplesCount)
 }
 
-func TestAddAndRetrieveSamplesConcurrent(t *testing.T) {
-	fxt, err := createTrialDatastoreServerTestFixture()
+func TestAddTrialSanitizedTrialID(t *testing.T) {
+	trialIDValidator, err := utils.CreateTrialIDValidator(utils.DefaultTrialIDAllowedCharacters, 16, true)
+	assert.NoError(t, err)
+	fxt, err := createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{TrialIDValidator: trialIDValidator})
 	assert.NoError(t, err)
 	defer fxt.destroy()
 
-	createTrials(t, &fxt, 2)
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my trial")
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "test", TrialParams: &grpcapi.TrialParams{}})
+	assert.NoError(t, err)
 
-	wg := sync.WaitGroup{}
+	stream, err := fxt.client.AddSample(ctx)
+	assert.NoError(t, err)
+	err = stream.Send(&grpcapi.AddSampleRequest{
+		TrialSample: &grpcapi.StoredTrialSample{TrialId: "my trial", UserId: "test", State: grpcapi.TrialState_ENDED},
+	})
+	assert.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.NoError(t, err)
 
-	for _, trialID := range []string{"trial0", "trial1"} {
-		wg.Add(1)
-		trialID := trialID
-		go func() {
-			defer wg.Done()
+	rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: []string{"my trial"}})
+	assert.NoError(t, err)
+	assert.Len(t, rep.TrialInfos, 1)
+	assert.Equal(t, "mytrial", rep.TrialInfos[0].TrialId)
+	assert.Equal(t, uint32(1), rep.TrialInfos[0].SamplesCount)
+}
 
-			ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", trialID)
-			stream, err := fxt.client.AddSample(ctx)
-			assert.NoError(t, err)
+type compressionKey struct{}
 
-			for i := 0; i < 1000; i++ {
-				err = stream.Send(&grpcapi.AddSampleRequest{
-					TrialSample: &grpcapi.StoredTrialSample{UserId: "my_user", State: grpcapi.TrialState_RUNNING, TickId: uint64(i)},
-				})
-				assert.NoError(t, err)
-			}
+// compressionStatsHandler records the compression, and the size on the wire, of the messages received by each tagged call
+type compressionStatsHandler struct {
+	mutex        sync.Mutex
+	compressions map[string]string
+	wireLengths  map[string]int
+}
 
-			err = stream.Send(&grpcapi.AddSampleRequest{
-				TrialSample: &grpcapi.StoredTrialSample{UserId: "my_user", State: grpcapi.TrialState_ENDED, TickId: uint64(1000)},
-			})
-			assert.NoError(t, err)
+func (h *compressionStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
 
-			_, err = stream.CloseAndRecv()
-			assert.NoError(t, err)
-		}()
+func (h *compressionStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	tag, ok := ctx.Value(compressionKey{}).(string)
+	if !ok {
+		return
 	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	switch s := s.(type) {
+	case *stats.InHeader:
+		h.compressions[tag] = s.Compression
+	case *stats.InPayload:
+		if h.wireLengths != nil {
+			h.wireLengths[tag] += s.WireLength
+		}
+	}
+}
 
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+func (h *compressionStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
 
-			stream, err := fxt.client.RetrieveSamples(fxt.ctx, &grpcapi.RetrieveSamplesRequest{TrialIds: []string{"trial0", "trial1"}})
-			assert.NoError(t, err)
+func (h *compressionStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
 
-			nextTrial0TickID := 0
-			nextTrial1TickID := 0
+func TestRetrieveSamplesPerCallCompression(t *testing.T) {
+	statsHandler := &compressionStatsHandler{compressions: make(map[string]string)}
+	fxt, err := createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{}, grpc.WithStatsHandler(statsHandler))
+	assert.NoError(t, err)
+	defer fxt.destroy()
 
-			for i := 0; i < 2002; i++ {
-				msg, err := stream.Recv()
-				assert.NoError(t, err)
-				sample := msg.GetTrialSample()
-				assert.NotNil(t, sample)
-				assert.Equal(t, "my_user", sample.UserId)
-				assert.Contains(t, []string{"trial0", "trial1"}, sample.TrialId)
-				if sample.TrialId == "trial0" {
-					assert.Equal(t, nextTrial0TickID, int(sample.TickId))
-					nextTrial0TickID++
-				} else {
-					assert.Equal(t, nextTrial1TickID, int(sample.TickId))
-					nextTrial1TickID++
-				}
-			}
+	err = fxt.backend.CreateOrUpdateTrials(fxt.ctx, []*backend.TrialParams{{TrialID: "my-trial", Params: &grpcapi.TrialParams{MaxSteps: 12}}})
+	assert.NoError(t, err)
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: "my-trial", TickId: 0, State: grpcapi.TrialState_RUNNING}})
+	assert.NoError(t, err)
 
-			_, err = stream.Recv()
-			assert.Equal(t, io.EOF, err)
-		}()
+	callOptions := map[string][]grpc.CallOption{
+		"compressed":   {grpc.UseCompressor(gzip.Name)},
+		"uncompressed": {},
+	}
+	streams := make(map[string]grpcapi.TrialDatastoreSP_RetrieveSamplesClient)
+	for tag, options := range callOptions {
+		stream, err := fxt.client.RetrieveSamples(
+			context.WithValue(fxt.ctx, compressionKey{}, tag),
+			&grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}},
+			options...,
+		)
+		assert.NoError(t, err)
+		streams[tag] = stream
 	}
 
-	wg.Wait()
+	// Both streams are concurrently ongoing and receive the first sample
+	for _, stream := range streams {
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), msg.GetTrialSample().TickId)
+	}
+
+	err = fxt.backend.AddSamples(fxt.ctx, []*grpcapi.StoredTrialSample{{TrialId: "my-trial", TickId: 1, State: grpcapi.TrialState_ENDED}})
+	assert.NoError(t, err)
+
+	for _, stream := range streams {
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), msg.GetTrialSample().TickId)
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+	}
+
+	statsHandler.mutex.Lock()
+	defer statsHandler.mutex.Unlock()
+	assert.Equal(t, "gzip", statsHandler.compressions["compressed"])
+	assert.Equal(t, "", statsHandler.compressions["uncompressed"])
 }
 
-func TestDeleteTrials(t *testing.T) {
-	fxt, err := createTrialDatastoreServerTestFixture()
+func TestCompressedRoundTrip(t *testing.T) {
+	statsHandler := &compressionStatsHandler{compressions: make(map[string]string), wireLengths: make(map[string]int)}
+	fxt, err := createTrialDatastoreServerTestFixtureWithOptions(TrialDatastoreServerOptions{}, grpc.WithStatsHandler(statsHandler))
 	assert.NoError(t, err)
 	defer fxt.destroy()
 
-	createTrials(t, &fxt, 10)
-	selectedTrialIds := []string{"trial0", "trial9", "trial5", "trial2", "trial3"}
-
-	{
-		// Make sure retrieval works as expected
-		expectedTrialIds := []string{"trial0", "trial2", "trial3", "trial5", "trial9"} // Trials should be returned according to their creation order
-		rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, Timeout: 500})
-		assert.NoError(t, err)
-		assert.Equal(t, rep.NextTrialHandle, "10")
-		assert.Len(t, rep.TrialInfos, len(expectedTrialIds))
-		for i, trialInfo := range rep.TrialInfos {
-			assert.Equal(t, expectedTrialIds[i], trialInfo.TrialId)
-			assert.Equal(t, grpcapi.TrialState_UNKNOWN, trialInfo.LastState)
-			assert.Equal(t, "test", trialInfo.UserId)
+	// Somewhat compressible payloads, e.g. serialized observations of a grid world
+	samples := make([]*grpcapi.StoredTrialSample, 20)
+	for tickID := range samples {
+		payload := make([]byte, 4096)
+		for byteIdx := range payload {
+			payload[byteIdx] = byte((byteIdx * (tickID + 1) / 64) % 7)
+		}
+		samples[tickID] = &grpcapi.StoredTrialSample{
+			TrialId:      "my-trial",
+			TickId:       uint64(tickID),
+			State:        grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{{Actor: 0, Observation: pointy.Uint32(0), Reward: pointy.Float32(float32(tickID))}},
+			Payloads:     [][]byte{payload},
 		}
 	}
+	samples[len(samples)-1].State = grpcapi.TrialState_ENDED
 
-	{
-		// Delete a few trials
-		_, err = fxt.client.DeleteTrials(fxt.ctx, &grpcapi.DeleteTrialsRequest{TrialIds: []string{"trial2", "trial3", "trial4"}})
+	ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "my-trial")
+	_, err = fxt.client.AddTrial(ctx, &grpcapi.AddTrialRequest{UserId: "foo", TrialParams: &grpcapi.TrialParams{MaxSteps: 20}})
+	assert.NoError(t, err)
+
+	// Adding the samples with a compressed call
+	addStream, err := fxt.client.AddSample(ctx, grpc.UseCompressor(gzip.Name))
+	assert.NoError(t, err)
+	for _, sample := range samples {
+		err = addStream.Send(&grpcapi.AddSampleRequest{TrialSample: sample})
 		assert.NoError(t, err)
 	}
+	_, err = addStream.CloseAndRecv()
+	assert.NoError(t, err)
 
-	t.Run("DeletedTrialsNotRetrievedWTimeout", func(t *testing.T) {
-		expectedTrialIds := []string{"trial0", "trial5", "trial9"} // Trials should be returned according to their creation order
-		rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds, Timeout: 500})
+	retrieve := func(tag string, options ...grpc.CallOption) [][]byte {
+		stream, err := fxt.client.RetrieveSamples(
+			context.WithValue(fxt.ctx, compressionKey{}, tag),
+			&grpcapi.RetrieveSamplesRequest{TrialIds: []string{"my-trial"}},
+			options...,
+		)
 		assert.NoError(t, err)
-		assert.Equal(t, rep.NextTrialHandle, "10")
-		assert.Len(t, rep.TrialInfos, len(expectedTrialIds))
-		for i, trialInfo := range rep.TrialInfos {
-			assert.Equal(t, expectedTrialIds[i], trialInfo.TrialId)
-			assert.Equal(t, grpcapi.TrialState_UNKNOWN, trialInfo.LastState)
-			assert.Equal(t, "test", trialInfo.UserId)
+		serializedSamples := [][]byte{}
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return serializedSamples
+			}
+			assert.NoError(t, err)
+			serializedSample, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg.GetTrialSample())
+			assert.NoError(t, err)
+			serializedSamples = append(serializedSamples, serializedSample)
 		}
-	})
-
-	t.Run("DeletedTrialsNotRetrievedWOTimeout", func(t *testing.T) {
-		expectedTrialIds := []string{"trial0", "trial5", "trial9"} // Trials should be returned according to their creation order
-		rep, err := fxt.client.RetrieveTrials(fxt.ctx, &grpcapi.RetrieveTrialsRequest{TrialIds: selectedTrialIds})
+	}
+	compressedSamples := retrieve("compressed", grpc.UseCompressor(gzip.Name))
+	uncompressedSamples := retrieve("uncompressed")
+
+	// Both retrievals deliver the same bytes, the ones of the added samples
+	assert.Len(t, compressedSamples, len(samples))
+	assert.Equal(t, uncompressedSamples, compressedSamples)
+	for tickID, sample := range samples {
+		expectedSerializedSample, err := proto.MarshalOptions{Deterministic: true}.Marshal(sample)
 		assert.NoError(t, err)
-		assert.Equal(t, rep.NextTrialHandle, "10")
-		assert.Len(t, rep.TrialInfos, len(expectedTrialIds))
-		for i, trialInfo := range rep.TrialInfos {
-			assert.Equal(t, expectedTrialIds[i], trialInfo.TrialId)
-			assert.Equal(t, grpcapi.TrialState_UNKNOWN, trialInfo.LastState)
-			assert.Equal(t, "test", trialInfo.UserId)
-		}
-	})
+		assert.Equal(t, expectedSerializedSample, compressedSamples[tickID])
+	}
 
-	t.Run("CantAddSampleToDeletedTrial", func(t *testing.T) {
-		ctx := metadata.AppendToOutgoingContext(fxt.ctx, "trial-id", "trial3")
-		stream, err := fxt.client.AddSample(ctx)
-		assert.NoError(t, err)
-		err = stream.Send(&grpcapi.AddSampleRequest{
-			TrialSample: &grpcapi.StoredTrialSample{TrialId: "foo", UserId: "my_user", State: grpcapi.TrialState_RUNNING},
-		})
-		assert.NoError(t, err)
-		_, err = stream.CloseAndRecv()
-		assert.Error(t, err)
-		s, ok := status.FromError(err)
-		assert.True(t, ok)
-		assert.Equal(t, s.Code(), codes.InvalidArgument)
-	})
+	// Less bytes went through the wire with compression
+	statsHandler.mutex.Lock()
+	defer statsHandler.mutex.Unlock()
+	assert.Equal(t, "gzip", statsHandler.compressions["compressed"])
+	assert.Equal(t, "", statsHandler.compressions["uncompressed"])
+	assert.Less(t, statsHandler.wireLengths["compressed"], statsHandler.wireLengths["uncompressed"]/2)
 }
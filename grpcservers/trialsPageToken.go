@@ -0,0 +1,88 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcservers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+)
+
+// trialsPageToken represents the position of a `RetrieveTrials` call in the listing of the trials, in creation order.
+//
+// Trials are listed from `NextTrialIdx`, their position in the creation order. Positions are never reused, the trials
+// created or deleted between two pages don't move the following ones. The last listed trial is also recorded, the
+// following pages never list it or a trial created before it.
+type trialsPageToken struct {
+	NextTrialIdx  int    `json:"next_trial_idx"`
+	LastTrialID   string `json:"last_trial_id,omitempty"`
+	LastCreatedAt int64  `json:"last_created_at,omitempty"` // In nanoseconds since the Unix epoch, 0 when unknown
+}
+
+// parseTrialsPageToken parses a token serialized as base64url encoded JSON, the empty string being the first page.
+//
+// The trial indices used as handles before the tokens were introduced remain supported.
+func parseTrialsPageToken(serializedToken string) (*trialsPageToken, error) {
+	if serializedToken == "" {
+		return &trialsPageToken{}, nil
+	}
+	if trialIdx, err := strconv.Atoi(serializedToken); err == nil {
+		return &trialsPageToken{NextTrialIdx: trialIdx}, nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(serializedToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token encoding (%w)", err)
+	}
+	token := &trialsPageToken{}
+	err = json.Unmarshal(payload, token)
+	if err != nil || token.NextTrialIdx < 0 {
+		return nil, fmt.Errorf("invalid page token content")
+	}
+	return token, nil
+}
+
+func (t *trialsPageToken) String() string {
+	payload, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// selects returns true if the given trial wasn't listed by the previous pages
+func (t *trialsPageToken) selects(trialInfo *backend.TrialInfo) bool {
+	if t.LastCreatedAt != 0 && !trialInfo.CreatedAt.IsZero() {
+		// Creation timestamps are strictly increasing, a trial deleted and created again is a new trial
+		return trialInfo.CreatedAt.UnixNano() > t.LastCreatedAt
+	}
+	return trialInfo.TrialID != t.LastTrialID
+}
+
+// advance records the last of the given trials as listed, the following pages starting at the given index
+func (t *trialsPageToken) advance(listedTrialInfos []*backend.TrialInfo, nextTrialIdx int) {
+	if nextTrialIdx > t.NextTrialIdx {
+		// Not every backend defines the next index of an empty page
+		t.NextTrialIdx = nextTrialIdx
+	}
+	if len(listedTrialInfos) == 0 {
+		return
+	}
+	lastTrialInfo := listedTrialInfos[len(listedTrialInfos)-1]
+	t.LastTrialID = lastTrialInfo.TrialID
+	t.LastCreatedAt = 0
+	if !lastTrialInfo.CreatedAt.IsZero() {
+		t.LastCreatedAt = lastTrialInfo.CreatedAt.UnixNano()
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservers
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/utils"
+)
+
+// HttpServerOptions represents the configuration of the http server
+type HttpServerOptions struct {
+	TrialIDValidator *utils.TrialIDValidator // Normalize the trial ids provided by clients, no normalization if nil
+	// Wraps the handlers of the exports, e.g. to require an api token, they are used as is if nil
+	Authenticate func(http.Handler) http.Handler
+	TLSConfig    *tls.Config // Serves over TLS when defined
+}
+
+// writeTrackingResponseWriter tracks if anything was written to a response, once it is the status can't change
+type writeTrackingResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *writeTrackingResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// rewardsCSVHandler serves the flattened rewards of the trials given by the `trial_id` query parameters, or of every
+// trial if none is given, as CSV
+func rewardsCSVHandler(b backend.Backend, options HttpServerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		trialIDs := options.TrialIDValidator.NormalizeAll(r.URL.Query()["trial_id"])
+		logger := log.WithFields(log.Fields{"operation": "ExportRewardsCSV", "trial_ids": trialIDs})
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		trackingWriter := &writeTrackingResponseWriter{ResponseWriter: w}
+		err := backend.ExportRewardsCSV(r.Context(), b, trialIDs, trackingWriter)
+		if err == nil {
+			return
+		}
+		if r.Context().Err() != nil {
+			logger.Info("rewards export canceled by the client")
+			return
+		}
+		if trackingWriter.written {
+			// The response is already started, aborting it lets the client know it is truncated
+			logger.WithError(err).Error("rewards export failed")
+			panic(http.ErrAbortHandler)
+		}
+		var unknownTrialErr *backend.UnknownTrialError
+		if errors.As(err, &unknownTrialErr) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.WithError(err).Error("rewards export failed")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// CreateHttpServer creates an http server exposing the given backend's data at:
+//
+//   - `/rewards.csv`, the flattened rewards of the trials given by the `trial_id` query parameters.
+func CreateHttpServer(b backend.Backend, options HttpServerOptions) *http.Server {
+	var rewardsCSV http.Handler = rewardsCSVHandler(b, options)
+	if options.Authenticate != nil {
+		rewardsCSV = options.Authenticate(rewardsCSV)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/rewards.csv", rewardsCSV)
+	return &http.Server{Handler: mux}
+}
+
+// StartServer starts serving the given backend's data over http using the given listener
+func StartServer(listener net.Listener, b backend.Backend, options HttpServerOptions) *http.Server {
+	server := CreateHttpServer(b, options)
+	if options.TLSConfig != nil {
+		listener = tls.NewListener(listener, options.TLSConfig)
+	}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("unexpected error while serving http")
+		}
+	}()
+	return server
+}
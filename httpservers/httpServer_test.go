@@ -0,0 +1,146 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/grpcservers"
+)
+
+func createTestServer(t *testing.T) (*httptest.Server, backend.Backend) {
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	server := httptest.NewServer(CreateHttpServer(b, HttpServerOptions{}).Handler)
+	return server, b
+}
+
+func get(t *testing.T, url string) (int, string) {
+	res, err := http.Get(url)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	return res.StatusCode, string(body)
+}
+
+func TestRewardsCSV(t *testing.T) {
+	server, b := createTestServer(t)
+	defer server.Close()
+	defer b.Destroy()
+
+	err := b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "trial-1", Params: &grpcapi.TrialParams{}},
+		{TrialID: "trial-2", Params: &grpcapi.TrialParams{}},
+	})
+	assert.NoError(t, err)
+	err = b.AddSamples(context.Background(), []*grpcapi.StoredTrialSample{
+		{
+			TrialId: "trial-1",
+			TickId:  0,
+			State:   grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{Actor: 0, ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{{Sender: 1, Reward: 2, Confidence: 0.5}}},
+				{Actor: 1, SentRewards: []*grpcapi.StoredTrialActorSampleReward{{Receiver: 0, Reward: 2, Confidence: 0.5}}},
+			},
+			Payloads: [][]byte{[]byte("an opaque observation")},
+		},
+		{
+			TrialId: "trial-1",
+			TickId:  1,
+			State:   grpcapi.TrialState_RUNNING,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{Actor: 0, ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{{Sender: -1, Reward: -1, Confidence: 1}}},
+			},
+		},
+		{
+			TrialId: "trial-2",
+			TickId:  0,
+			State:   grpcapi.TrialState_ENDED,
+			ActorSamples: []*grpcapi.StoredTrialActorSample{
+				{Actor: 0, ReceivedRewards: []*grpcapi.StoredTrialActorSampleReward{{Sender: -1, Reward: 3, Confidence: 1}}},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	// trial-1 is still running, only its stored samples are exported
+	status, body := get(t, server.URL+"/rewards.csv?trial_id=trial-1&trial_id=trial-2")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `trial_id,tick_id,actor_index,direction,sender,receiver,reward,confidence
+trial-1,0,0,received,1,0,2,0.5
+trial-1,0,1,sent,1,0,2,0.5
+trial-1,1,0,received,-1,0,-1,1
+trial-2,0,0,received,-1,0,3,1
+`, body)
+
+	status, body = get(t, server.URL+"/rewards.csv?trial_id=trial-2")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `trial_id,tick_id,actor_index,direction,sender,receiver,reward,confidence
+trial-2,0,0,received,-1,0,3,1
+`, body)
+
+	// Every trial is exported without trial ids
+	_, body = get(t, server.URL+"/rewards.csv")
+	assert.Contains(t, body, "trial-1,1,0,received,-1,0,-1,1\n")
+	assert.Contains(t, body, "trial-2,0,0,received,-1,0,3,1\n")
+
+	status, _ = get(t, server.URL+"/rewards.csv?trial_id=unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	res, err := http.Post(server.URL+"/rewards.csv", "text/plain", nil)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestRewardsCSVTokenAuthentication(t *testing.T) {
+	tokenAuthenticator, err := grpcservers.CreateTokenAuthenticator(map[string]grpcservers.TokenScope{"reader-token": grpcservers.ReadScope})
+	assert.NoError(t, err)
+	b, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer b.Destroy()
+	server := httptest.NewServer(CreateHttpServer(b, HttpServerOptions{
+		Authenticate: func(handler http.Handler) http.Handler {
+			return tokenAuthenticator.HTTPHandler(grpcservers.ReadScope, handler)
+		},
+	}).Handler)
+	defer server.Close()
+
+	getWithAuthorization := func(authorization string) int {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/rewards.csv", nil)
+		assert.NoError(t, err)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, getWithAuthorization(""))
+	assert.Equal(t, http.StatusUnauthorized, getWithAuthorization("Bearer unknown-token"))
+	assert.Equal(t, http.StatusOK, getWithAuthorization("Bearer reader-token"))
+}
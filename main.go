@@ -15,15 +15,30 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"math"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/viper"
 
 	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/abandonmentBackend"
+	"github.com/cogment/cogment-trial-datastore/backend/batchingBackend"
 	"github.com/cogment/cogment-trial-datastore/backend/boltBackend"
+	_ "github.com/cogment/cogment-trial-datastore/backend/cachedBackend" // Registers the "cached" backend
 	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	"github.com/cogment/cogment-trial-datastore/backend/retentionBackend"
 	"github.com/cogment/cogment-trial-datastore/grpcservers"
+	"github.com/cogment/cogment-trial-datastore/httpservers"
+	"github.com/cogment/cogment-trial-datastore/metrics"
+	"github.com/cogment/cogment-trial-datastore/utils"
 	"github.com/cogment/cogment-trial-datastore/version"
 	log "github.com/sirupsen/logrus"
 )
@@ -31,12 +46,56 @@ import (
 func main() {
 	viper.AutomaticEnv()
 	viper.SetDefault("PORT", 9000)
+	viper.SetDefault("LISTEN", "")
 	viper.SetDefault("GRPC_REFLECTION", false)
+	viper.SetDefault("GRPC_MAX_RECEIVED_MESSAGE_SIZE", grpcservers.DefaultMaxReceivedMessageSize)
+	viper.SetDefault("GRPC_MAX_SENT_MESSAGE_SIZE", grpcservers.DefaultMaxSentMessageSize)
+	viper.SetDefault("TLS_CERT", "")
+	viper.SetDefault("TLS_KEY", "")
+	viper.SetDefault("TLS_CLIENT_CA", "")
+	viper.SetDefault("API_TOKEN", "")
+	viper.SetDefault("API_TOKENS_FILE", "")
+	viper.SetDefault("METRICS_PORT", 0)
+	viper.SetDefault("HTTP_PORT", 0)
 	viper.SetDefault("LOG_LEVEL", "info")
+	viper.SetDefault("LOG_FORMAT", "text")
 	viper.SetDefault("MEMORY_STORAGE_MAX_SAMPLE_SIZE", memoryBackend.DefaultMaxSampleSize)
+	viper.SetDefault("MEMORY_STORAGE_MAX_TRIALS_COUNT", 0)
+	viper.SetDefault("MEMORY_STORAGE_MAX_TRIALS_COUNT_POLICY", "reject")
+	viper.SetDefault("MEMORY_STORAGE_PAYLOAD_DEDUPLICATION", false)
+	viper.SetDefault("BACKEND", "")
 	viper.SetDefault("FILE_STORAGE_PATH", nil)
+	viper.SetDefault("WRITE_BATCH_SIZE", 0)
+	viper.SetDefault("WRITE_BATCH_FLUSH_INTERVAL", batchingBackend.DefaultOptions.FlushInterval)
+	viper.SetDefault("RETENTION_MAX_TRIALS_COUNT", 0)
+	viper.SetDefault("RETENTION_MAX_STORED_SAMPLES_SIZE", 0)
+	viper.SetDefault("ABANDONED_TRIALS_TTL", 0)
+	viper.SetDefault("ABANDONED_TRIALS_DELETION", false)
+	viper.SetDefault("DETERMINISTIC_SERIALIZATION", false)
+	viper.SetDefault("PAYLOAD_COMPRESSION", "none")
+	viper.SetDefault("SAMPLE_SERIALIZATION_POOLING", true)
+	viper.SetDefault("SAMPLE_CHECKSUMS", false)
+	viper.SetDefault("VERIFY_SAMPLE_CHECKSUMS", true)
+	viper.SetDefault("TRIAL_ID_VALIDATION", "none")
+	viper.SetDefault("TRIAL_ID_ALLOWED_CHARACTERS", utils.DefaultTrialIDAllowedCharacters)
+	viper.SetDefault("TRIAL_ID_MAX_LENGTH", utils.DefaultTrialIDMaxLength)
+	viper.SetDefault("DEFAULT_ACTOR_CLASS_FIELDS", "")
+	viper.SetDefault("ADD_SAMPLE_BUFFER_SIZE", grpcservers.DefaultAddSampleBufferSize)
+	viper.SetDefault("TICK_ORDER_VALIDATION", "lax")
+	viper.SetDefault("ADD_SAMPLE_RATE_LIMIT", 0)
+	viper.SetDefault("ADD_SAMPLE_BYTES_RATE_LIMIT", 0)
+	viper.SetDefault("SHUTDOWN_GRACE_PERIOD", grpcservers.DefaultShutdownGracePeriod)
 	viper.SetEnvPrefix("COGMENT_TRIAL_DATASTORE")
 
+	switch logFormat := viper.GetString("LOG_FORMAT"); logFormat {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Fatalf("invalid log format specified %q expecting one of [text json]", logFormat)
+	}
+
 	logLevel, err := log.ParseLevel(viper.GetString("LOG_LEVEL"))
 	if err != nil {
 		expectedLevels := make([]string, 0)
@@ -48,39 +107,322 @@ func main() {
 	log.Infof("setting up log level to %q", logLevel.String())
 	log.SetLevel(logLevel)
 
-	var backend backend.Backend
-	if viper.IsSet("FILE_STORAGE_PATH") {
-		storageFilePath := viper.GetString("FILE_STORAGE_PATH")
-		log.Infof("using a file storage backend in %q", storageFilePath)
-		backend, err = boltBackend.CreateBoltBackend(storageFilePath)
+	defaultActorClassFields, err := backend.ParseDefaultActorClassFields(viper.GetString("DEFAULT_ACTOR_CLASS_FIELDS"))
+	if err != nil {
+		log.Fatalf("invalid default actor class fields: %v", err)
+	}
+
+	payloadCompression, err := backend.ParsePayloadCompression(viper.GetString("PAYLOAD_COMPRESSION"))
+	if err != nil {
+		log.Fatalf("invalid payload compression: %v", err)
+	}
+
+	tickOrderValidation, err := grpcservers.ParseTickOrderValidation(viper.GetString("TICK_ORDER_VALIDATION"))
+	if err != nil {
+		log.Fatalf("invalid tick order validation: %v", err)
+	}
+
+	backendName := flag.String(
+		"backend",
+		viper.GetString("BACKEND"),
+		fmt.Sprintf("backend storing the trials, one of %v, defaults to %q or %q when a file storage path is defined", backend.RegisteredBackends(), memoryBackend.BackendName, boltBackend.BackendName),
+	)
+	listen := flag.String("listen", viper.GetString("LISTEN"), "address the gRPC services listen on, either tcp://host:port or unix:///path/to.sock, defaults to the tcp port defined by COGMENT_TRIAL_DATASTORE_PORT")
+	tlsOptions := grpcservers.TLSOptions{}
+	flag.StringVar(&tlsOptions.CertFile, "tls-cert", viper.GetString("TLS_CERT"), "PEM encoded certificate file, serves over TLS when defined along with a key")
+	flag.StringVar(&tlsOptions.KeyFile, "tls-key", viper.GetString("TLS_KEY"), "PEM encoded private key file of the certificate")
+	flag.StringVar(&tlsOptions.ClientCAFile, "tls-client-ca", viper.GetString("TLS_CLIENT_CA"), "PEM encoded CA certificates file, requires clients to present a certificate signed by one of them when defined")
+	maxReceivedMessageSize := flag.Int("grpc-max-received-message-size", viper.GetInt("GRPC_MAX_RECEIVED_MESSAGE_SIZE"), "maximum size, in bytes, of the messages received by the gRPC server, e.g. an added sample")
+	maxSentMessageSize := flag.Int("grpc-max-sent-message-size", viper.GetInt("GRPC_MAX_SENT_MESSAGE_SIZE"), "maximum size, in bytes, of the messages sent by the gRPC server, e.g. a retrieved sample")
+	writeBatchSize := flag.Int("write-batch-size", viper.GetInt("WRITE_BATCH_SIZE"), "maximum number of added samples grouped in a single write to the storage, 0 or 1 disables the batching")
+	writeBatchFlushInterval := flag.Duration("write-batch-flush-interval", viper.GetDuration("WRITE_BATCH_FLUSH_INTERVAL"), "maximum duration an added sample waits for others before being written to the storage, e.g. \"10ms\"")
+	memoryStorageMaxSampleSize := flag.Uint("memory-storage-max-sample-size", viper.GetUint("MEMORY_STORAGE_MAX_SAMPLE_SIZE"), "memory budget, in bytes, of the samples held by the memory storage before the samples of the least recently used trials are evicted")
+	grpcReflection := flag.Bool("grpc-reflection", viper.GetBool("GRPC_REFLECTION"), "start a gRPC reflection server, e.g. to call the datastore using grpcurl without its proto files")
+	verifySampleChecksums := flag.Bool("verify-sample-checksums", viper.GetBool("VERIFY_SAMPLE_CHECKSUMS"), "check the checksums of the stored samples when reading them, reporting the corrupted ones instead of returning them")
+	flag.Parse()
+	if *memoryStorageMaxSampleSize > math.MaxUint32 {
+		log.Fatalf("invalid memory storage max sample size %d, expecting at most %d bytes", *memoryStorageMaxSampleSize, uint(math.MaxUint32))
+	}
+	// The backends are configured through viper
+	viper.Set("MEMORY_STORAGE_MAX_SAMPLE_SIZE", *memoryStorageMaxSampleSize)
+	viper.Set("VERIFY_SAMPLE_CHECKSUMS", *verifySampleChecksums)
+
+	if flag.NArg() > 0 {
+		runCommand(flag.Arg(0), flag.Args()[1:], payloadCompression, *maxReceivedMessageSize)
+		return
+	}
+
+	var tlsCredentials *grpcservers.TLSCredentials
+	if tlsOptions.CertFile != "" || tlsOptions.KeyFile != "" {
+		tlsCredentials, err = grpcservers.CreateTLSCredentials(tlsOptions)
 		if err != nil {
-			log.Fatalf("unable to create the bolt file backend: %v", err)
+			log.Fatalf("unable to setup tls: %v", err)
 		}
-	} else {
-		log.Info("using an in-memory storage")
-		backend, err = memoryBackend.CreateMemoryBackend(viper.GetUint32("MEMORY_STORAGE_MAX_SAMPLE_SIZE"))
+		go reloadTLSCredentialsOnSighup(tlsCredentials)
+		log.WithField("mutual_tls", tlsOptions.ClientCAFile != "").Info("serving over tls")
+	} else if tlsOptions.ClientCAFile != "" {
+		log.Fatalf("client certificates can only be required when serving over tls, using a certificate and a key")
+	}
+
+	var tokenAuthenticator *grpcservers.TokenAuthenticator
+	if viper.GetString("API_TOKEN") != "" || viper.GetString("API_TOKENS_FILE") != "" {
+		tokens := make(map[string]grpcservers.TokenScope)
+		if tokensFilePath := viper.GetString("API_TOKENS_FILE"); tokensFilePath != "" {
+			tokens, err = grpcservers.ParseTokensFile(tokensFilePath)
+			if err != nil {
+				log.Fatalf("invalid api tokens file: %v", err)
+			}
+		}
+		if token := viper.GetString("API_TOKEN"); token != "" {
+			tokens[token] = grpcservers.WriteScope
+		}
+		tokenAuthenticator, err = grpcservers.CreateTokenAuthenticator(tokens)
 		if err != nil {
-			log.Fatalf("unable to create the memory backend: %v", err)
+			log.Fatalf("unable to setup the api tokens authentication: %v", err)
 		}
+		log.WithField("tokens_count", len(tokens)).Info("requiring api tokens")
 	}
 
-	port := viper.GetInt("PORT")
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	// The http servers, metrics and exports, are secured like the gRPC services, they only require the read scope
+	var httpAuthenticate func(http.Handler) http.Handler
+	if tokenAuthenticator != nil {
+		httpAuthenticate = func(handler http.Handler) http.Handler {
+			return tokenAuthenticator.HTTPHandler(grpcservers.ReadScope, handler)
+		}
+	}
+	var httpTLSConfig *tls.Config
+	if tlsCredentials != nil {
+		httpTLSConfig = tlsCredentials.HTTPConfig()
+	}
+
+	listenAddress := grpcservers.TCPListenAddress(viper.GetInt("PORT"))
+	if *listen != "" {
+		listenAddress, err = grpcservers.ParseListenAddress(*listen)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if viper.GetInt("MEMORY_STORAGE_MAX_TRIALS_COUNT") > 0 && viper.GetInt("RETENTION_MAX_TRIALS_COUNT") > 0 {
+		// Both limit the number of trials, with the memory storage the lowest one would silently win
+		log.Fatalf("`COGMENT_TRIAL_DATASTORE_MEMORY_STORAGE_MAX_TRIALS_COUNT` and `COGMENT_TRIAL_DATASTORE_RETENTION_MAX_TRIALS_COUNT` can't be used together")
+	}
+	createdBackend, err := createBackend(*backendName, payloadCompression)
 	if err != nil {
-		log.Fatalf("unable to listen to tcp port %d: %v", port, err)
+		var unknownBackendErr *backend.UnknownBackendError
+		if errors.As(err, &unknownBackendErr) {
+			log.Fatalf("invalid backend: %v", err)
+		}
+		log.WithError(err).Error("unable to create the backend")
+		serveNotServingHealth(listenAddress, tlsCredentials)
+		return
+	}
+	var backend backend.Backend = createdBackend
+
+	if *writeBatchSize > 1 {
+		log.WithFields(log.Fields{
+			"batch_size":        *writeBatchSize,
+			"flush_interval_ms": writeBatchFlushInterval.Milliseconds(),
+		}).Info("batching the writes of the added samples")
+		backend = batchingBackend.CreateBatchingBackend(backend, batchingBackend.Options{
+			MaxBatchSize:  *writeBatchSize,
+			FlushInterval: *writeBatchFlushInterval,
+		})
+	}
+
+	retentionPolicies := []retentionBackend.Policy{}
+	if maxTrialsCount := viper.GetInt("RETENTION_MAX_TRIALS_COUNT"); maxTrialsCount > 0 {
+		retentionPolicies = append(retentionPolicies, retentionBackend.MaxTrialsCountPolicy{MaxTrialsCount: maxTrialsCount})
+	}
+	if maxStoredSamplesSize := viper.GetInt("RETENTION_MAX_STORED_SAMPLES_SIZE"); maxStoredSamplesSize > 0 {
+		retentionPolicies = append(retentionPolicies, retentionBackend.MaxStoredSamplesSizePolicy{MaxStoredSamplesSize: maxStoredSamplesSize})
 	}
-	server := grpcservers.CreateGrpcServer(viper.GetBool("GRPC_REFLECTION"))
-	err = grpcservers.RegisterTrialDatastoreServer(server, backend)
+	if len(retentionPolicies) > 0 {
+		log.Infof("evicting the oldest trials to keep %v", retentionPolicies)
+		backend = retentionBackend.CreateRetentionBackend(backend, retentionPolicies...)
+	}
+
+	if abandonedTrialsTTL := viper.GetDuration("ABANDONED_TRIALS_TTL"); abandonedTrialsTTL > 0 {
+		deleteAbandonedTrials := viper.GetBool("ABANDONED_TRIALS_DELETION")
+		log.WithFields(log.Fields{
+			"ttl":     abandonedTrialsTTL,
+			"deleted": deleteAbandonedTrials,
+		}).Info("abandoning the ongoing trials to which no samples are added")
+		backend, err = abandonmentBackend.CreateAbandonmentBackend(backend, abandonmentBackend.Options{
+			TTL:             abandonedTrialsTTL,
+			DeleteAbandoned: deleteAbandonedTrials,
+		})
+		if err != nil {
+			log.Fatalf("unable to abandon the inactive trials: %v", err)
+		}
+	}
+
+	metricsPort := viper.GetInt("METRICS_PORT")
+	if metricsPort > 0 {
+		backend, err = metrics.InstrumentBackend(backend)
+		if err != nil {
+			log.Fatalf("unable to instrument the backend: %v", err)
+		}
+		metricsListener, err := net.Listen("tcp", fmt.Sprintf(":%d", metricsPort))
+		if err != nil {
+			log.Fatalf("unable to listen to tcp port %d: %v", metricsPort, err)
+		}
+		metrics.StartServerWithOptions(metricsListener, metrics.ServerOptions{
+			Authenticate: httpAuthenticate,
+			TLSConfig:    httpTLSConfig,
+		})
+		log.WithField("port", metricsPort).Info("serving metrics at /metrics")
+	}
+
+	var trialIDValidator *utils.TrialIDValidator
+	switch trialIDValidation := viper.GetString("TRIAL_ID_VALIDATION"); trialIDValidation {
+	case "none":
+	case "reject", "sanitize":
+		trialIDValidator, err = utils.CreateTrialIDValidator(
+			viper.GetString("TRIAL_ID_ALLOWED_CHARACTERS"),
+			viper.GetInt("TRIAL_ID_MAX_LENGTH"),
+			trialIDValidation == "sanitize",
+		)
+		if err != nil {
+			log.Fatalf("unable to create the trial id validator: %v", err)
+		}
+	default:
+		log.Fatalf("invalid trial id validation specified %q expecting one of [none reject sanitize]", trialIDValidation)
+	}
+
+	if httpPort := viper.GetInt("HTTP_PORT"); httpPort > 0 {
+		httpListener, err := net.Listen("tcp", fmt.Sprintf(":%d", httpPort))
+		if err != nil {
+			log.Fatalf("unable to listen to tcp port %d: %v", httpPort, err)
+		}
+		httpservers.StartServer(httpListener, backend, httpservers.HttpServerOptions{
+			TrialIDValidator: trialIDValidator,
+			Authenticate:     httpAuthenticate,
+			TLSConfig:        httpTLSConfig,
+		})
+		log.WithField("port", httpPort).Info("serving http exports")
+	}
+
+	listener, err := grpcservers.Listen(listenAddress)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
-	err = grpcservers.RegisterDatalogServer(server, backend)
+	drainer := grpcservers.CreateDrainer()
+	server := grpcservers.CreateGrpcServerWithOptions(grpcservers.GrpcServerOptions{
+		EnableReflection:       *grpcReflection,
+		EnableMetrics:          metricsPort > 0,
+		Drainer:                drainer,
+		TLSCredentials:         tlsCredentials,
+		TokenAuthenticator:     tokenAuthenticator,
+		MaxReceivedMessageSize: *maxReceivedMessageSize,
+		MaxSentMessageSize:     *maxSentMessageSize,
+	})
+	err = grpcservers.RegisterTrialDatastoreServerWithOptions(server, backend, grpcservers.TrialDatastoreServerOptions{
+		TrialIDValidator:        trialIDValidator,
+		DefaultActorClassFields: defaultActorClassFields,
+		AddSampleBufferSize:     viper.GetInt("ADD_SAMPLE_BUFFER_SIZE"),
+		TickOrderValidation:     tickOrderValidation,
+		MaxSentMessageSize:      *maxSentMessageSize,
+		AddSampleRateLimit: utils.RateLimit{
+			SamplesPerSecond: viper.GetInt("ADD_SAMPLE_RATE_LIMIT"),
+			BytesPerSecond:   viper.GetInt("ADD_SAMPLE_BYTES_RATE_LIMIT"),
+		},
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	err = grpcservers.RegisterDatalogServerWithOptions(server, backend, grpcservers.DatalogServerOptions{
+		TrialIDValidator: trialIDValidator,
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	err = grpcservers.RegisterAdminServer(server, backend)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	healthServer := grpcservers.RegisterHealthServer(server)
+	if metricsPort > 0 {
+		metrics.GrpcServerMetrics.InitializeMetrics(server)
+	}
+	// The backend is initialized at this point
+	healthServer.SetServing(true)
+
+	shutdownGracePeriod := viper.GetDuration("SHUTDOWN_GRACE_PERIOD")
+	shutdownErr := make(chan error, 1)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		receivedSignal := <-signals
+		log.WithField("signal", receivedSignal.String()).WithField("grace_period_ms", shutdownGracePeriod.Milliseconds()).Info("Cogment Trial Datastore service shuts down...")
+		shutdownErr <- grpcservers.GracefulStop(server, healthServer, drainer, shutdownGracePeriod)
+	}()
+
+	log.WithField("listen", listenAddress.String()).WithField("version", version.Version).Info("Cogment Trial Datastore service starts...\n")
+	err = server.Serve(listener)
+	if err != nil {
+		log.Fatalf("unexpected error while serving grpc services: %v", err)
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		// Ongoing calls might still be using the backend, it is not closed. The last write of the file storage is
+		// either fully committed or not at all.
+		log.Fatalf("unable to gracefully shut down: %v", err)
+	}
+	backend.Destroy()
+	log.Info("Cogment Trial Datastore service stopped")
+}
+
+// createBackend creates the backend registered under the given name, configured by the environment.
+//
+// Without a name, the bolt file backend is created when `FILE_STORAGE_PATH` is defined, the memory backend otherwise.
+func createBackend(name string, payloadCompression backend.PayloadCompression) (backend.Backend, error) {
+	if name == "" {
+		name = memoryBackend.BackendName
+		if viper.IsSet("FILE_STORAGE_PATH") {
+			name = boltBackend.BackendName
+		}
+	}
+	log.WithField("backend", name).Info("using the backend")
+	return backend.CreateBackend(name, backend.FactoryOptions{
+		DeterministicSerialization:  viper.GetBool("DETERMINISTIC_SERIALIZATION"),
+		PayloadCompression:          payloadCompression,
+		SerializationPooling:        viper.GetBool("SAMPLE_SERIALIZATION_POOLING"),
+		SampleChecksums:             viper.GetBool("SAMPLE_CHECKSUMS"),
+		VerifySampleChecksums:       viper.GetBool("VERIFY_SAMPLE_CHECKSUMS"),
+		Settings:                    viper.GetViper(),
+		EvictedTrialsCounter:        metrics.EvictedTrialsCount.WithLabelValues("max_trials_count"),
+		EvictedTrialsSamplesCounter: metrics.EvictedTrialsSamplesCount.WithLabelValues("max_samples_size"),
+	})
+}
+
+// serveNotServingHealth serves only the health service, reporting `NOT_SERVING`, when the backend can't be created.
+//
+// This lets the probes of the orchestrator, e.g. kubernetes, report the failure.
+func serveNotServingHealth(listenAddress grpcservers.ListenAddress, tlsCredentials *grpcservers.TLSCredentials) {
+	listener, err := grpcservers.Listen(listenAddress)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
-	log.WithField("port", port).WithField("version", version.Version).Info("Cogment Trial Datastore service starts...\n")
+	server := grpcservers.CreateGrpcServerWithOptions(grpcservers.GrpcServerOptions{TLSCredentials: tlsCredentials})
+	grpcservers.RegisterHealthServer(server)
+	log.WithField("listen", listenAddress.String()).Warn("Cogment Trial Datastore health service starts, reporting the service as not serving")
 	err = server.Serve(listener)
 	if err != nil {
 		log.Fatalf("unexpected error while serving grpc services: %v", err)
 	}
 }
+
+// reloadTLSCredentialsOnSighup reloads the tls certificates and key each time a SIGHUP is received
+func reloadTLSCredentialsOnSighup(tlsCredentials *grpcservers.TLSCredentials) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		err := tlsCredentials.Reload()
+		if err != nil {
+			log.WithError(err).Error("unable to reload the tls certificates, keeping the previous ones")
+			continue
+		}
+		log.Info("tls certificates reloaded")
+	}
+}
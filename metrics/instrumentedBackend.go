@@ -0,0 +1,188 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// instrumentedBackend wraps a backend to measure the samples added to it and retrieved from it
+type instrumentedBackend struct {
+	backend.Backend
+}
+
+// InstrumentBackend creates a Backend measuring the samples added to and retrieved from the given backend.
+//
+// The stored trials and samples of the given backend are also measured, when the metrics are gathered. Only one
+// backend can be instrumented.
+func InstrumentBackend(b backend.Backend) (backend.Backend, error) {
+	err := Registry.Register(newBackendCollector(b))
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedBackend{Backend: b}, nil
+}
+
+func observeAddedSample(sample *grpcapi.StoredTrialSample) {
+	AddedSamplesCount.Inc()
+	AddedSamplesSize.Add(float64(proto.Size(sample)))
+	payloadsSize := 0
+	for _, payload := range sample.Payloads {
+		payloadsSize += len(payload)
+	}
+	AddedSamplePayloadsSize.Observe(float64(payloadsSize))
+}
+
+func (b *instrumentedBackend) AddSamples(ctx context.Context, samples []*grpcapi.StoredTrialSample) error {
+	err := b.Backend.AddSamples(ctx, samples)
+	if err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		observeAddedSample(sample)
+	}
+	return nil
+}
+
+func (b *instrumentedBackend) AddSamplePartial(ctx context.Context, sample *grpcapi.StoredTrialSample) error {
+	err := b.Backend.AddSamplePartial(ctx, sample)
+	if err != nil {
+		return err
+	}
+	observeAddedSample(sample)
+	return nil
+}
+
+func (b *instrumentedBackend) ObserveSamples(ctx context.Context, filter backend.TrialSampleFilter, out chan<- *grpcapi.StoredTrialSample) error {
+	observer := make(backend.TrialSampleObserver)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(observer)
+		return b.Backend.ObserveSamples(ctx, filter, observer)
+	})
+	g.Go(func() error {
+		for sample := range observer {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- sample:
+				RetrievedSamplesCount.Inc()
+			}
+		}
+		return nil
+	})
+	return g.Wait()
+}
+
+func (b *instrumentedBackend) RetrieveLatestSample(ctx context.Context, trialID string, filter backend.TrialSampleFilter) (*grpcapi.StoredTrialSample, error) {
+	sample, err := b.Backend.RetrieveLatestSample(ctx, trialID, filter)
+	if err != nil {
+		return nil, err
+	}
+	if sample != nil {
+		RetrievedSamplesCount.Inc()
+	}
+	return sample, nil
+}
+
+// backendCollector measures the trials and samples stored by a backend.
+//
+// Measuring them goes through every stored trial, the measures are reused by the metrics gathered during the following
+// `backendCollectorInterval`.
+type backendCollector struct {
+	backend               backend.Backend
+	storedTrialsDesc      *prometheus.Desc
+	activeTrialsDesc      *prometheus.Desc
+	storedSamplesSizeDesc *prometheus.Desc
+
+	measuresMutex sync.Mutex // Also serializes the concurrent measures
+	measures      storedTrialsMeasures
+	measuredAt    time.Time // Zero until the stored trials are measured
+}
+
+// storedTrialsMeasures represents the measures of the trials and samples stored by a backend
+type storedTrialsMeasures struct {
+	storedTrialsCount int
+	activeTrialsCount int
+	storedSamplesSize int
+}
+
+// backendCollectorTimeout is the maximum duration of the retrieval of the stored trials when the metrics are gathered
+var backendCollectorTimeout = 5 * time.Second
+
+// backendCollectorInterval is the duration during which the measures of the stored trials are reused
+var backendCollectorInterval = 10 * time.Second
+
+func newBackendCollector(b backend.Backend) *backendCollector {
+	return &backendCollector{
+		backend:               b,
+		storedTrialsDesc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "stored_trials"), "Number of stored trials", nil, nil),
+		activeTrialsDesc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "active_trials"), "Number of stored trials that haven't ended", nil, nil),
+		storedSamplesSizeDesc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "stored_samples_bytes"), "Cumulated size of the stored samples", nil, nil),
+	}
+}
+
+func (c *backendCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.storedTrialsDesc
+	ch <- c.activeTrialsDesc
+	ch <- c.storedSamplesSizeDesc
+}
+
+func (c *backendCollector) Collect(ch chan<- prometheus.Metric) {
+	measures, err := c.measure()
+	if err != nil {
+		log.WithField("operation", "collect_metrics").WithError(err).Error("Unable to retrieve the stored trials metrics")
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.storedTrialsDesc, prometheus.GaugeValue, float64(measures.storedTrialsCount))
+	ch <- prometheus.MustNewConstMetric(c.activeTrialsDesc, prometheus.GaugeValue, float64(measures.activeTrialsCount))
+	ch <- prometheus.MustNewConstMetric(c.storedSamplesSizeDesc, prometheus.GaugeValue, float64(measures.storedSamplesSize))
+}
+
+// measure returns the measures of the stored trials, measuring them again if the previous ones are too old
+func (c *backendCollector) measure() (storedTrialsMeasures, error) {
+	c.measuresMutex.Lock()
+	defer c.measuresMutex.Unlock()
+	if !c.measuredAt.IsZero() && time.Since(c.measuredAt) < backendCollectorInterval {
+		return c.measures, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendCollectorTimeout)
+	defer cancel()
+	trialsInfo, err := c.backend.RetrieveTrials(ctx, []string{}, 0, -1)
+	if err != nil {
+		return storedTrialsMeasures{}, err
+	}
+	measures := storedTrialsMeasures{storedTrialsCount: len(trialsInfo.TrialInfos)}
+	for _, trialInfo := range trialsInfo.TrialInfos {
+		if trialInfo.State != grpcapi.TrialState_ENDED {
+			measures.activeTrialsCount++
+		}
+		measures.storedSamplesSize += trialInfo.StoredSamplesSize
+	}
+	c.measures = measures
+	c.measuredAt = time.Now()
+	return measures, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// retrievalsCountingBackend counts the retrievals of the stored trials
+type retrievalsCountingBackend struct {
+	backend.Backend
+	retrievalsCount int
+}
+
+func (b *retrievalsCountingBackend) RetrieveTrials(ctx context.Context, filter []string, fromTrialIdx int, count int) (backend.TrialsInfoResult, error) {
+	b.retrievalsCount++
+	return b.Backend.RetrieveTrials(ctx, filter, fromTrialIdx, count)
+}
+
+func collectMetricsCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 16)
+	c.Collect(ch)
+	close(ch)
+	metricsCount := 0
+	for range ch {
+		metricsCount++
+	}
+	return metricsCount
+}
+
+func TestBackendCollectorReusesMeasures(t *testing.T) {
+	mb, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer mb.Destroy()
+	err = mb.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-trial", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+
+	b := &retrievalsCountingBackend{Backend: mb}
+	collector := newBackendCollector(b)
+	assert.Equal(t, 3, collectMetricsCount(collector))
+	assert.Equal(t, 3, collectMetricsCount(collector))
+	assert.Equal(t, 1, b.retrievalsCount)
+	assert.Equal(t, 1, collector.measures.storedTrialsCount)
+
+	// The stored trials are measured again once the interval is elapsed
+	err = mb.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: "my-other-trial", Params: &grpcapi.TrialParams{}}})
+	assert.NoError(t, err)
+	collector.measuredAt = collector.measuredAt.Add(-backendCollectorInterval - time.Second)
+	assert.Equal(t, 3, collectMetricsCount(collector))
+	assert.Equal(t, 2, b.retrievalsCount)
+	assert.Equal(t, 2, collector.measures.storedTrialsCount)
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const namespace = "cogment_trial_datastore"
+
+// Registry gathers the metrics exposed by the metrics server
+var Registry = prometheus.NewRegistry()
+
+// PayloadsSizeBuckets are the buckets of the payloads size histograms, from 1KB to 16MB
+var PayloadsSizeBuckets = prometheus.ExponentialBuckets(1024, 4, 8)
+
+var (
+	AddedSamplesCount = promauto.With(Registry).NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "added_samples_total",
+		Help:      "Number of samples added to the backend",
+	})
+	AddedSamplesSize = promauto.With(Registry).NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "added_samples_bytes_total",
+		Help:      "Cumulated serialized size of the samples added to the backend",
+	})
+	AddedSamplePayloadsSize = promauto.With(Registry).NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "added_sample_payloads_bytes",
+		Help:      "Cumulated size of the payloads of each sample added to the backend",
+		Buckets:   PayloadsSizeBuckets,
+	})
+	RetrievedSamplesCount = promauto.With(Registry).NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retrieved_samples_total",
+		Help:      "Number of samples retrieved from the backend",
+	})
+	EvictedTrialsCount = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evicted_trials_total",
+		Help:      "Number of trials evicted from the backend, params and samples",
+	}, []string{"reason"})
+	EvictedTrialsSamplesCount = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evicted_trials_samples_total",
+		Help:      "Number of trials whose samples only were evicted from the backend",
+	}, []string{"reason"})
+
+	// GrpcServerMetrics measures the calls handled by the gRPC servers, including their latency
+	GrpcServerMetrics = grpc_prometheus.NewServerMetrics()
+)
+
+func init() {
+	GrpcServerMetrics.EnableHandlingTimeHistogram()
+	Registry.MustRegister(
+		GrpcServerMetrics,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ServerOptions represents the configuration of the metrics server
+type ServerOptions struct {
+	// Wraps the metrics handler, e.g. to require an api token, the handler is used as is if nil
+	Authenticate func(http.Handler) http.Handler
+	TLSConfig    *tls.Config // Serves over TLS when defined
+}
+
+// StartServer starts serving the metrics over http at `/metrics` using the given listener
+func StartServer(listener net.Listener) *http.Server {
+	return StartServerWithOptions(listener, ServerOptions{})
+}
+
+// StartServerWithOptions starts serving the metrics at `/metrics` using the given listener and options
+func StartServerWithOptions(listener net.Listener, options ServerOptions) *http.Server {
+	var handler http.Handler = promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if options.Authenticate != nil {
+		handler = options.Authenticate(handler)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	server := &http.Server{Handler: mux}
+	if options.TLSConfig != nil {
+		listener = tls.NewListener(listener, options.TLSConfig)
+	}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("unexpected error while serving metrics")
+		}
+	}()
+	return server
+}
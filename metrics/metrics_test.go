@@ -0,0 +1,130 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/cogment/cogment-trial-datastore/backend"
+	"github.com/cogment/cogment-trial-datastore/backend/memoryBackend"
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/cogment/cogment-trial-datastore/metrics"
+)
+
+func retrieveMetrics(t *testing.T, address string) string {
+	res, err := http.Get(fmt.Sprintf("http://%s/metrics", address))
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestInstrumentedBackend(t *testing.T) {
+	mb, err := memoryBackend.CreateMemoryBackend(memoryBackend.DefaultMaxSampleSize)
+	assert.NoError(t, err)
+	defer mb.Destroy()
+	b, err := metrics.InstrumentBackend(mb)
+	assert.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := metrics.StartServer(listener)
+	defer server.Close()
+
+	addedSamplesCount := testutil.ToFloat64(metrics.AddedSamplesCount)
+	addedSamplesSize := testutil.ToFloat64(metrics.AddedSamplesSize)
+	retrievedSamplesCount := testutil.ToFloat64(metrics.RetrievedSamplesCount)
+
+	err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{
+		{TrialID: "ended-trial", Params: &grpcapi.TrialParams{}},
+		{TrialID: "running-trial", Params: &grpcapi.TrialParams{}},
+	})
+	assert.NoError(t, err)
+	samples := []*grpcapi.StoredTrialSample{
+		{TrialId: "ended-trial", TickId: 0, State: grpcapi.TrialState_RUNNING, Payloads: [][]byte{make([]byte, 2048)}},
+		{TrialId: "ended-trial", TickId: 1, State: grpcapi.TrialState_ENDED, Payloads: [][]byte{make([]byte, 512*1024)}},
+		{TrialId: "running-trial", TickId: 0, State: grpcapi.TrialState_RUNNING},
+	}
+	err = b.AddSamples(context.Background(), samples)
+	assert.NoError(t, err)
+	samplesSize := 0
+	for _, sample := range samples {
+		samplesSize += proto.Size(sample)
+	}
+
+	observer := make(backend.TrialSampleObserver)
+	go func() {
+		defer close(observer)
+		err := b.ObserveSamples(context.Background(), backend.TrialSampleFilter{TrialIDs: []string{"ended-trial"}}, observer)
+		assert.NoError(t, err)
+	}()
+	for range observer {
+	}
+
+	assert.Equal(t, addedSamplesCount+3, testutil.ToFloat64(metrics.AddedSamplesCount))
+	assert.Equal(t, addedSamplesSize+float64(samplesSize), testutil.ToFloat64(metrics.AddedSamplesSize))
+	assert.Equal(t, retrievedSamplesCount+2, testutil.ToFloat64(metrics.RetrievedSamplesCount))
+
+	exposedMetrics := retrieveMetrics(t, listener.Addr().String())
+	assert.Contains(t, exposedMetrics, "cogment_trial_datastore_stored_trials 2\n")
+	assert.Contains(t, exposedMetrics, "cogment_trial_datastore_active_trials 1\n")
+	assert.Contains(t, exposedMetrics, fmt.Sprintf("cogment_trial_datastore_stored_samples_bytes %d\n", samplesSize))
+	// Payloads are counted in KB to MB buckets
+	assert.Contains(t, exposedMetrics, "cogment_trial_datastore_added_sample_payloads_bytes_bucket{le=\"1024\"}")
+	assert.Contains(t, exposedMetrics, "cogment_trial_datastore_added_sample_payloads_bytes_bucket{le=\"1.6777216e+07\"}")
+
+	// Only one backend can be instrumented
+	_, err = metrics.InstrumentBackend(mb)
+	assert.Error(t, err)
+}
+
+func TestEvictionMetrics(t *testing.T) {
+	options := memoryBackend.DefaultOptions
+	options.MaxTrialsCount = 1
+	options.MaxTrialsCountPolicy = memoryBackend.EvictOldestTrials
+	options.EvictedTrialsCounter = metrics.EvictedTrialsCount.WithLabelValues("max_trials_count")
+	b, err := memoryBackend.CreateMemoryBackendWithOptions(options)
+	assert.NoError(t, err)
+	defer b.Destroy()
+
+	evictedTrialsCount := testutil.ToFloat64(metrics.EvictedTrialsCount.WithLabelValues("max_trials_count"))
+	for _, trialID := range []string{"trial-1", "trial-2", "trial-3"} {
+		err = b.CreateOrUpdateTrials(context.Background(), []*backend.TrialParams{{TrialID: trialID, Params: &grpcapi.TrialParams{}}})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, evictedTrialsCount+2, testutil.ToFloat64(metrics.EvictedTrialsCount.WithLabelValues("max_trials_count")))
+}
+
+func TestGrpcServerMetricsAreExposed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := metrics.StartServer(listener)
+	defer server.Close()
+
+	exposedMetrics := retrieveMetrics(t, listener.Addr().String())
+	assert.True(t, strings.Contains(exposedMetrics, "go_goroutines"))
+}
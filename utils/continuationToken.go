@@ -0,0 +1,102 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+)
+
+// ContinuationToken represents the tick of the last delivered sample of each trial of a `RetrieveSamples` call.
+//
+// As the samples of the retrieved trials are interleaved, the position is tracked per trial. Tokens only depend on the
+// trial and tick ids, they remain valid as long as the trials are stored.
+//
+// The server sends it to resume the retrievals, clients build it from the samples they received when they lost the
+// connection along with the token.
+type ContinuationToken struct {
+	LastTickIDs map[string]uint64 `json:"last_tick_ids"`
+}
+
+func NewContinuationToken() *ContinuationToken {
+	return &ContinuationToken{LastTickIDs: make(map[string]uint64)}
+}
+
+// ParseContinuationToken parses a token serialized as base64url encoded JSON
+func ParseContinuationToken(serializedToken string) (*ContinuationToken, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(serializedToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid continuation token encoding (%w)", err)
+	}
+	token := &ContinuationToken{}
+	err = json.Unmarshal(payload, token)
+	if err != nil || token.LastTickIDs == nil {
+		return nil, fmt.Errorf("invalid continuation token content")
+	}
+	return token, nil
+}
+
+func (t *ContinuationToken) String() string {
+	payload, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func (t *ContinuationToken) Clone() *ContinuationToken {
+	clonedToken := NewContinuationToken()
+	for trialID, lastTickID := range t.LastTickIDs {
+		clonedToken.LastTickIDs[trialID] = lastTickID
+	}
+	return clonedToken
+}
+
+// Selects returns true if the given sample is after the last delivered sample of its trial
+func (t *ContinuationToken) Selects(sample *grpcapi.StoredTrialSample) bool {
+	lastTickID, delivered := t.LastTickIDs[sample.TrialId]
+	return !delivered || sample.TickId > lastTickID
+}
+
+// Advance records the given sample as delivered
+func (t *ContinuationToken) Advance(sample *grpcapi.StoredTrialSample) {
+	t.LastTickIDs[sample.TrialId] = sample.TickId
+}
+
+// FromTickID returns the lower bound of the tick range of a retrieval of the given trials resumed from this token,
+// given the requested `fromTickID`.
+//
+// The samples up to the last delivered one of every requested trial are skipped, the bound is unchanged when no trial
+// is requested, i.e. every trial is, or when a requested trial has no delivered sample.
+func (t *ContinuationToken) FromTickID(trialIDs []string, fromTickID *uint64) *uint64 {
+	if len(trialIDs) == 0 {
+		return fromTickID
+	}
+	resumedFromTickID := uint64(math.MaxUint64)
+	for _, trialID := range trialIDs {
+		lastTickID, delivered := t.LastTickIDs[trialID]
+		if !delivered || lastTickID == math.MaxUint64 {
+			return fromTickID
+		}
+		if lastTickID+1 < resumedFromTickID {
+			resumedFromTickID = lastTickID + 1
+		}
+	}
+	if fromTickID != nil && *fromTickID >= resumedFromTickID {
+		return fromTickID
+	}
+	return &resumedFromTickID
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+
+	grpcapi "github.com/cogment/cogment-trial-datastore/grpcapi/cogment/api"
+	"github.com/openlyinc/pointy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContinuationTokenSerialization(t *testing.T) {
+	token := NewContinuationToken()
+	token.Advance(&grpcapi.StoredTrialSample{TrialId: "my-trial", TickId: 12})
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString([]byte(`{"last_tick_ids":{"my-trial":12}}`)), token.String())
+
+	parsedToken, err := ParseContinuationToken(token.String())
+	assert.NoError(t, err)
+	assert.Equal(t, token, parsedToken)
+
+	_, err = ParseContinuationToken("not a token")
+	assert.Error(t, err)
+	_, err = ParseContinuationToken(base64.RawURLEncoding.EncodeToString([]byte("{}")))
+	assert.Error(t, err)
+}
+
+func TestContinuationTokenSelects(t *testing.T) {
+	token := NewContinuationToken()
+	token.Advance(&grpcapi.StoredTrialSample{TrialId: "trial0", TickId: 1})
+	clonedToken := token.Clone()
+	token.Advance(&grpcapi.StoredTrialSample{TrialId: "trial0", TickId: 3})
+
+	assert.False(t, token.Selects(&grpcapi.StoredTrialSample{TrialId: "trial0", TickId: 3}))
+	assert.True(t, token.Selects(&grpcapi.StoredTrialSample{TrialId: "trial0", TickId: 4}))
+	assert.True(t, token.Selects(&grpcapi.StoredTrialSample{TrialId: "trial1", TickId: 0}))
+	// The cloned token isn't advanced along with the original one
+	assert.True(t, clonedToken.Selects(&grpcapi.StoredTrialSample{TrialId: "trial0", TickId: 2}))
+}
+
+func TestContinuationTokenFromTickID(t *testing.T) {
+	token := NewContinuationToken()
+	token.Advance(&grpcapi.StoredTrialSample{TrialId: "trial0", TickId: 1})
+	token.Advance(&grpcapi.StoredTrialSample{TrialId: "trial1", TickId: 3})
+	assert.Equal(t, uint64(2), *token.FromTickID([]string{"trial0", "trial1"}, nil))
+	assert.Equal(t, uint64(3), *token.FromTickID([]string{"trial0", "trial1"}, pointy.Uint64(3)))
+	assert.Equal(t, uint64(2), *token.FromTickID([]string{"trial0", "trial1"}, pointy.Uint64(1)))
+	assert.Nil(t, token.FromTickID([]string{"trial0", "trial2"}, nil))
+	assert.Nil(t, token.FromTickID([]string{}, nil))
+}
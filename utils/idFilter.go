@@ -14,6 +14,8 @@
 
 package utils
 
+import "strings"
+
 type IDFilter map[string]struct{}
 
 func NewIDFilter(selectedIDs []string) IDFilter {
@@ -35,3 +37,42 @@ func (f *IDFilter) Selects(id string) bool {
 	_, isSelected := (*f)[id]
 	return isSelected
 }
+
+// ExclusionPrefix is the prefix marking the ids excluded by an `ExclusiveIDFilter`, e.g. "!my-actor"
+const ExclusionPrefix = "!"
+
+// ExclusiveIDFilter is an id filter supporting both included and excluded ids, excluded ids win on conflict
+type ExclusiveIDFilter struct {
+	included IDFilter
+	excluded IDFilter
+}
+
+// NewExclusiveIDFilter creates a filter from ids, the ones prefixed by `ExclusionPrefix` are excluded.
+//
+// When only excluded ids are given, every other id is selected.
+func NewExclusiveIDFilter(ids []string) ExclusiveIDFilter {
+	includedIDs := []string{}
+	excludedIDs := []string{}
+	for _, id := range ids {
+		if strings.HasPrefix(id, ExclusionPrefix) {
+			excludedIDs = append(excludedIDs, strings.TrimPrefix(id, ExclusionPrefix))
+		} else {
+			includedIDs = append(includedIDs, id)
+		}
+	}
+	return ExclusiveIDFilter{
+		included: NewIDFilter(includedIDs),
+		excluded: NewIDFilter(excludedIDs),
+	}
+}
+
+func (f *ExclusiveIDFilter) SelectsAll() bool {
+	return f.included.SelectsAll() && f.excluded.SelectsAll()
+}
+
+func (f *ExclusiveIDFilter) Selects(id string) bool {
+	if _, isExcluded := f.excluded[id]; isExcluded {
+		return false
+	}
+	return f.included.Selects(id)
+}
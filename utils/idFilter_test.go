@@ -0,0 +1,39 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExclusiveIDFilterOnlyExclusions(t *testing.T) {
+	f := NewExclusiveIDFilter([]string{"!foo"})
+	assert.False(t, f.SelectsAll())
+	assert.False(t, f.Selects("foo"))
+	assert.True(t, f.Selects("bar"))
+}
+
+func TestExclusiveIDFilterConflict(t *testing.T) {
+	f := NewExclusiveIDFilter([]string{"foo", "bar", "!bar"})
+	assert.True(t, f.Selects("foo"))
+	assert.False(t, f.Selects("bar"))
+	assert.False(t, f.Selects("baz"))
+
+	f = NewExclusiveIDFilter([]string{})
+	assert.True(t, f.SelectsAll())
+	assert.True(t, f.Selects("foo"))
+}
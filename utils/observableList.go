@@ -22,15 +22,24 @@ import (
 type ObservableListItem interface{}
 type ObservableListObserver chan ObservableListItem
 
+// ObservableList is a list safe for concurrent use by any number of readers and writers.
+//
+// Readers, including observers, never block each other and only block writers while they copy the items. They see a
+// consistent prefix of the list, items appended afterwards are then observed in order. Items are expected to be
+// immutable once added, they are shared with every reader.
 type ObservableList interface {
 	Len() int
 	HasEnded() bool
 	Item(index int) (ObservableListItem, bool)
 	Append(item ObservableListItem, last bool)
+	Replace(index int, item ObservableListItem, last bool) bool
+	End()
 	Observe(ctx context.Context, from int, out chan<- ObservableListItem) error
 }
 
-type observer chan bool
+// observer is signaled when the list is updated, signals are coalesced, an observer only needs to know that it has to
+// read the list again
+type observer chan struct{}
 
 type observableList struct {
 	itemsLock     sync.RWMutex
@@ -63,7 +72,7 @@ func (l *observableList) HasEnded() bool {
 func (l *observableList) Item(index int) (ObservableListItem, bool) {
 	l.itemsLock.RLock()
 	defer l.itemsLock.RUnlock()
-	if index < 0 || index > l.Len() {
+	if index < 0 || index >= len(l.items) {
 		return nil, false
 	}
 	return l.items[index], true
@@ -72,7 +81,7 @@ func (l *observableList) Item(index int) (ObservableListItem, bool) {
 func (l *observableList) registerObserver() *observer {
 	l.observersLock.Lock()
 	defer l.observersLock.Unlock()
-	observer := make(observer)
+	observer := make(observer, 1)
 	l.observers[&observer] = struct{}{}
 	return &observer
 }
@@ -89,20 +98,58 @@ func (l *observableList) Append(item ObservableListItem, lastItem bool) {
 	l.ended = lastItem
 	l.itemsLock.Unlock()
 
-	l.observersLock.RLock() // This locks the iteration thus making sure the goroutine is called with an observer that exist
+	l.notifyObservers()
+}
+
+// Replace replaces the item at the given index.
+//
+// Observers that have not reached the index yet will observe the new item, the others won't be notified.
+// `lastItem` is only taken into account when replacing the last item of the list.
+func (l *observableList) Replace(index int, item ObservableListItem, lastItem bool) bool {
+	l.itemsLock.Lock()
+	if index < 0 || index >= len(l.items) {
+		l.itemsLock.Unlock()
+		return false
+	}
+	l.items[index] = item
+	if index == len(l.items)-1 {
+		l.ended = lastItem
+	}
+	l.itemsLock.Unlock()
+
+	l.notifyObservers()
+	return true
+}
+
+// End marks the list as ended without appending any item.
+func (l *observableList) End() {
+	l.itemsLock.Lock()
+	l.ended = true
+	l.itemsLock.Unlock()
+
+	l.notifyObservers()
+}
+
+// notifyObservers signals every observer without blocking, an observer already having a pending signal will read the
+// update anyway
+func (l *observableList) notifyObservers() {
+	l.observersLock.RLock()
 	defer l.observersLock.RUnlock()
 	for o := range l.observers {
-		o := o
-		go func() {
-			*o <- lastItem
-		}()
+		select {
+		case *o <- struct{}{}:
+		default:
+		}
 	}
 }
 
 func (l *observableList) Observe(ctx context.Context, from int, out chan<- ObservableListItem) error {
 	if l.HasEnded() {
-		// The list is ended, no risk from concurrent writes
-		currentItems := l.items[from:]
+		// The list is ended, no risk from concurrent appends but items can still be replaced
+		l.itemsLock.RLock()
+		currentItems := make([]ObservableListItem, len(l.items)-from)
+		copy(currentItems, l.items[from:])
+		l.itemsLock.RUnlock()
 		for _, item := range currentItems {
 			select {
 			case <-ctx.Done():
@@ -118,7 +165,8 @@ func (l *observableList) Observe(ctx context.Context, from int, out chan<- Obser
 			l.itemsLock.RLock()
 			end := len(l.items)
 			ended := l.ended
-			currentItems := l.items[from:end]
+			currentItems := make([]ObservableListItem, end-from)
+			copy(currentItems, l.items[from:end])
 			l.itemsLock.RUnlock()
 			from = end
 			for _, item := range currentItems {
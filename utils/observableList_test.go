@@ -16,6 +16,7 @@ package utils
 
 import (
 	"context"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -304,3 +305,82 @@ func TestObservableListConcurrentOneCancelled(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestObservableListReplace(t *testing.T) {
+	l := CreateObservableList()
+
+	one := &item{value: 1}
+	two := &item{value: 2}
+	otherTwo := &item{value: 22}
+
+	l.Append(one, false)
+	l.Append(two, false)
+
+	assert.False(t, l.Replace(2, otherTwo, false))
+	assert.True(t, l.Replace(1, otherTwo, true))
+	assert.Equal(t, 2, l.Len())
+	assert.True(t, l.HasEnded())
+
+	retrievedItems := make([]*item, 0)
+	observer := make(ObservableListObserver)
+	go func() {
+		err := l.Observe(context.Background(), 0, observer)
+		assert.NoError(t, err)
+		close(observer)
+	}()
+	for retrievedItem := range observer {
+		retrievedItems = append(retrievedItems, retrievedItem.(*item))
+	}
+
+	assert.Len(t, retrievedItems, 2)
+	assert.Equal(t, one, retrievedItems[0])
+	assert.Equal(t, otherTwo, retrievedItems[1])
+}
+
+func TestObservableListEnd(t *testing.T) {
+	l := CreateObservableList()
+	l.Append(1, false)
+	l.Append(2, false)
+
+	observer := make(ObservableListObserver)
+	go func() {
+		defer close(observer)
+		err := l.Observe(context.Background(), 0, observer)
+		assert.NoError(t, err)
+	}()
+
+	assert.Equal(t, 1, <-observer)
+	assert.Equal(t, 2, <-observer)
+
+	assert.False(t, l.HasEnded())
+	l.End()
+	assert.True(t, l.HasEnded())
+	assert.Equal(t, 2, l.Len())
+
+	_, ok := <-observer
+	assert.False(t, ok)
+}
+
+func TestObservableListNotificationsDontLeak(t *testing.T) {
+	l := CreateObservableList()
+	goroutinesCount := runtime.NumGoroutine()
+
+	// A slow observer, not reading its items while the list is updated, stopping before it is ended
+	ctx, cancel := context.WithCancel(context.Background())
+	observer := make(ObservableListObserver)
+	observeErr := make(chan error, 1)
+	go func() {
+		observeErr <- l.Observe(ctx, 0, observer)
+	}()
+	l.Append(&item{value: 0}, false)
+	<-observer
+	for value := 1; value < 1000; value++ {
+		l.Append(&item{value: value}, false)
+	}
+	cancel()
+	assert.ErrorIs(t, <-observeErr, context.Canceled)
+	l.End()
+
+	// Notifying observers doesn't leave goroutines behind
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutinesCount+5)
+}
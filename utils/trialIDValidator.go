@@ -0,0 +1,123 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// InvalidTrialIDError is raised when a trial id doesn't follow the rules of a TrialIDValidator
+type InvalidTrialIDError struct {
+	TrialID string
+	Reason  string
+}
+
+func (e *InvalidTrialIDError) Error() string {
+	return fmt.Sprintf("invalid trial id %q, %s", e.TrialID, e.Reason)
+}
+
+// TrialIDValidator validates trial ids against the following rules:
+// - trial ids are not empty, "." or "..",
+// - trial ids only contains allowed characters,
+// - trial ids are not longer than a maximum number of characters.
+//
+// When sanitizing, the disallowed characters are removed and trial ids are truncated to the maximum length instead
+// of being rejected.
+//
+// A nil validator accepts every trial id as is.
+type TrialIDValidator struct {
+	allowedCharacters    string
+	disallowedCharacters *regexp.Regexp
+	maxLength            int
+	sanitize             bool
+}
+
+// DefaultTrialIDAllowedCharacters is the default set of allowed characters
+const DefaultTrialIDAllowedCharacters = "A-Za-z0-9._-"
+
+// DefaultTrialIDMaxLength is the default maximum length of trial ids
+const DefaultTrialIDMaxLength = 128
+
+// CreateTrialIDValidator creates a validator
+//
+// `allowedCharacters` is expressed like a regular expression character class without the brackets, e.g. "A-Za-z0-9._-"
+// `maxLength` is expressed in characters, 0 means no limit.
+func CreateTrialIDValidator(allowedCharacters string, maxLength int, sanitize bool) (*TrialIDValidator, error) {
+	disallowedCharacters, err := regexp.Compile(fmt.Sprintf("[^%s]", allowedCharacters))
+	if err != nil {
+		return nil, fmt.Errorf("invalid trial id allowed characters %q (%w)", allowedCharacters, err)
+	}
+	return &TrialIDValidator{
+		allowedCharacters:    allowedCharacters,
+		disallowedCharacters: disallowedCharacters,
+		maxLength:            maxLength,
+		sanitize:             sanitize,
+	}, nil
+}
+
+// Validate checks the given trial id and returns it, sanitized if the validator is configured to do so
+func (v *TrialIDValidator) Validate(trialID string) (string, error) {
+	if v == nil {
+		return trialID, nil
+	}
+	validatedTrialID := trialID
+	if v.disallowedCharacters.MatchString(validatedTrialID) {
+		if !v.sanitize {
+			return "", &InvalidTrialIDError{TrialID: trialID, Reason: fmt.Sprintf("only characters in [%s] are allowed", v.allowedCharacters)}
+		}
+		validatedTrialID = v.disallowedCharacters.ReplaceAllString(validatedTrialID, "")
+	}
+	if v.maxLength > 0 {
+		if runes := []rune(validatedTrialID); len(runes) > v.maxLength {
+			if !v.sanitize {
+				return "", &InvalidTrialIDError{TrialID: trialID, Reason: fmt.Sprintf("it is longer than %d characters", v.maxLength)}
+			}
+			validatedTrialID = string(runes[:v.maxLength])
+		}
+	}
+	if strings.Trim(validatedTrialID, ".") == "" {
+		return "", &InvalidTrialIDError{TrialID: trialID, Reason: "it is empty or only made of dots"}
+	}
+	return validatedTrialID, nil
+}
+
+// Normalize returns the given trial id, sanitized if the validator is configured to do so
+//
+// It should be used when referencing existing trials, for which the trial id has already been validated.
+func (v *TrialIDValidator) Normalize(trialID string) string {
+	if v == nil || !v.sanitize {
+		return trialID
+	}
+	validatedTrialID, err := v.Validate(trialID)
+	if err != nil {
+		// Can't be sanitized, leaving as is, it won't match any trial
+		return trialID
+	}
+	return validatedTrialID
+}
+
+// NormalizeAll normalizes every given trial id
+func (v *TrialIDValidator) NormalizeAll(trialIDs []string) []string {
+	if v == nil || !v.sanitize {
+		return trialIDs
+	}
+	normalizedTrialIDs := make([]string, len(trialIDs))
+	for idx, trialID := range trialIDs {
+		normalizedTrialIDs[idx] = v.Normalize(trialID)
+	}
+	return normalizedTrialIDs
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrialIDValidatorReject(t *testing.T) {
+	v, err := CreateTrialIDValidator(DefaultTrialIDAllowedCharacters, 16, false)
+	assert.NoError(t, err)
+
+	trialID, err := v.Validate("my-trial_1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-trial_1.2", trialID)
+
+	var invalidTrialIDErr *InvalidTrialIDError
+	for _, invalidTrialID := range []string{"../my-trial", "my/trial", "my trial", " my-trial", "my-very-long-trial-id", "", ".."} {
+		_, err = v.Validate(invalidTrialID)
+		assert.ErrorAs(t, err, &invalidTrialIDErr)
+		assert.Equal(t, invalidTrialID, invalidTrialIDErr.TrialID)
+	}
+
+	assert.Equal(t, "my trial", v.Normalize("my trial"))
+}
+
+func TestTrialIDValidatorSanitize(t *testing.T) {
+	v, err := CreateTrialIDValidator(DefaultTrialIDAllowedCharacters, 16, true)
+	assert.NoError(t, err)
+
+	for invalidTrialID, expectedTrialID := range map[string]string{
+		"my-trial_1.2":          "my-trial_1.2",
+		"../my-trial":           "..my-trial",
+		"my/trial":              "mytrial",
+		"my trial":              "mytrial",
+		" my-trial\t":           "my-trial",
+		"my-very-long-trial-id": "my-very-long-tri",
+	} {
+		trialID, err := v.Validate(invalidTrialID)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedTrialID, trialID)
+		assert.Equal(t, expectedTrialID, v.Normalize(invalidTrialID))
+	}
+
+	var invalidTrialIDErr *InvalidTrialIDError
+	for _, invalidTrialID := range []string{"", "/", "./.."} {
+		_, err = v.Validate(invalidTrialID)
+		assert.ErrorAs(t, err, &invalidTrialIDErr)
+	}
+}
+
+func TestTrialIDValidatorNoMaxLength(t *testing.T) {
+	v, err := CreateTrialIDValidator(DefaultTrialIDAllowedCharacters, 0, false)
+	assert.NoError(t, err)
+
+	longTrialID := strings.Repeat("a", 1024)
+	trialID, err := v.Validate(longTrialID)
+	assert.NoError(t, err)
+	assert.Equal(t, longTrialID, trialID)
+}
+
+func TestTrialIDValidatorBadAllowedCharacters(t *testing.T) {
+	_, err := CreateTrialIDValidator("z-a", 0, false)
+	assert.Error(t, err)
+}
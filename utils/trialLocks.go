@@ -0,0 +1,122 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"sort"
+	"sync"
+)
+
+// trialLock is the lock of a trial along with the number of operations holding or waiting for it
+type trialLock struct {
+	mutex    sync.RWMutex
+	refCount int
+}
+
+// TrialLocks serializes the operations on the same trials while operations on different trials proceed in parallel.
+//
+// The lock of a trial only exists while an operation holds or waits for it, the memory usage doesn't grow with the
+// number of trials.
+//
+// The memory and file backends already serialize the writes on a trial, respectively using its samples mutex and
+// their transactions. Only the operations spanning several backend calls need it: the cached backend writing to the
+// persistent backend then to its cache, the abandonment backend checking the last addition to a trial before
+// abandoning it, and the trial registration checking the existing params before writing them.
+type TrialLocks struct {
+	locksMutex sync.Mutex
+	locks      map[string]*trialLock
+}
+
+func CreateTrialLocks() *TrialLocks {
+	return &TrialLocks{
+		locks: make(map[string]*trialLock),
+	}
+}
+
+// Lock locks the given trials, waiting for the operations holding any of them, and returns the function unlocking
+// them.
+//
+// Duplicated trial ids are locked once. Trials are always locked in the same order, operations locking several
+// trials can't deadlock each other.
+func (l *TrialLocks) Lock(trialIDs ...string) func() {
+	return l.lock(trialIDs, false)
+}
+
+// RLock locks the given trials for reading, waiting for the operations holding any of them with `Lock`, and returns
+// the function unlocking them. Any number of operations can hold the same trials with `RLock`.
+//
+// Like `sync.RWMutex`, an operation holding a trial with `RLock` mustn't lock it again while another operation might
+// be waiting to `Lock` it.
+func (l *TrialLocks) RLock(trialIDs ...string) func() {
+	return l.lock(trialIDs, true)
+}
+
+func (l *TrialLocks) lock(trialIDs []string, forReading bool) func() {
+	sortedTrialIDs := make([]string, 0, len(trialIDs))
+	seenTrialIDs := make(map[string]struct{}, len(trialIDs))
+	for _, trialID := range trialIDs {
+		if _, seen := seenTrialIDs[trialID]; !seen {
+			seenTrialIDs[trialID] = struct{}{}
+			sortedTrialIDs = append(sortedTrialIDs, trialID)
+		}
+	}
+	sort.Strings(sortedTrialIDs)
+
+	locks := make([]*trialLock, len(sortedTrialIDs))
+	l.locksMutex.Lock()
+	for idx, trialID := range sortedTrialIDs {
+		lock, exists := l.locks[trialID]
+		if !exists {
+			lock = &trialLock{}
+			l.locks[trialID] = lock
+		}
+		lock.refCount++
+		locks[idx] = lock
+	}
+	l.locksMutex.Unlock()
+
+	for _, lock := range locks {
+		if forReading {
+			lock.mutex.RLock()
+		} else {
+			lock.mutex.Lock()
+		}
+	}
+
+	return func() {
+		for _, lock := range locks {
+			if forReading {
+				lock.mutex.RUnlock()
+			} else {
+				lock.mutex.Unlock()
+			}
+		}
+		l.locksMutex.Lock()
+		defer l.locksMutex.Unlock()
+		for idx, trialID := range sortedTrialIDs {
+			locks[idx].refCount--
+			if locks[idx].refCount == 0 {
+				delete(l.locks, trialID)
+			}
+		}
+	}
+}
+
+// locksCount returns the number of trials currently locked or waited for
+func (l *TrialLocks) locksCount() int {
+	l.locksMutex.Lock()
+	defer l.locksMutex.Unlock()
+	return len(l.locks)
+}
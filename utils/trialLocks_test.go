@@ -0,0 +1,104 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrialLocksSerializeSameTrial(t *testing.T) {
+	l := CreateTrialLocks()
+
+	counter := 0
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := l.Lock("trial-1", "trial-2")
+			defer unlock()
+			counter++
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Locking in a different order, with duplicates, doesn't deadlock
+			unlock := l.Lock("trial-2", "trial-1", "trial-2")
+			defer unlock()
+			counter++
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 200, counter)
+	assert.Equal(t, 0, l.locksCount())
+}
+
+func TestTrialLocksDifferentTrials(t *testing.T) {
+	l := CreateTrialLocks()
+
+	unlock1 := l.Lock("trial-1")
+	locked2 := make(chan struct{})
+	go func() {
+		unlock2 := l.Lock("trial-2")
+		defer unlock2()
+		close(locked2)
+	}()
+	select {
+	case <-locked2:
+	case <-time.After(time.Second):
+		assert.Fail(t, "trial-2 couldn't be locked while trial-1 is")
+	}
+
+	locked1 := make(chan struct{})
+	go func() {
+		unlock := l.Lock("trial-1")
+		defer unlock()
+		close(locked1)
+	}()
+	select {
+	case <-locked1:
+		assert.Fail(t, "trial-1 was locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock1()
+	<-locked1
+}
+
+func TestTrialLocksReading(t *testing.T) {
+	l := CreateTrialLocks()
+
+	unlockReading1 := l.RLock("trial-1")
+	unlockReading2 := l.RLock("trial-1", "trial-2")
+
+	locked := make(chan struct{})
+	go func() {
+		unlock := l.Lock("trial-1")
+		defer unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		assert.Fail(t, "trial-1 was locked while being read")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockReading1()
+	unlockReading2()
+	<-locked
+	assert.Equal(t, 0, l.locksCount())
+}
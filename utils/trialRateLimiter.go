@@ -0,0 +1,167 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit defines the maximum rates at which samples are added to a trial, a zero rate is unlimited
+type RateLimit struct {
+	SamplesPerSecond int
+	BytesPerSecond   int
+}
+
+// IsUnlimited returns true if neither the samples nor the bytes rate is limited
+func (l RateLimit) IsUnlimited() bool {
+	return l.SamplesPerSecond <= 0 && l.BytesPerSecond <= 0
+}
+
+func (l RateLimit) String() string {
+	limits := []string{}
+	if l.SamplesPerSecond > 0 {
+		limits = append(limits, fmt.Sprintf("%d samples/s", l.SamplesPerSecond))
+	}
+	if l.BytesPerSecond > 0 {
+		limits = append(limits, fmt.Sprintf("%d bytes/s", l.BytesPerSecond))
+	}
+	if len(limits) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(limits, " and ")
+}
+
+// tokenBucket holds up to one second worth of tokens at the given rate, refilled continuously
+type tokenBucket struct {
+	tokens float64
+	rate   float64
+}
+
+func (b *tokenBucket) refill(elapsed time.Duration, rate int) {
+	b.rate = float64(rate)
+	b.tokens += elapsed.Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+}
+
+// allows returns true if `n` tokens can be taken, a full bucket allows any amount, e.g. a sample larger than the
+// bytes rate, which then takes more than a second to refill
+func (b *tokenBucket) allows(n float64) bool {
+	return b.rate <= 0 || b.tokens >= n || b.tokens >= b.rate
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+func (b *tokenBucket) isFull() bool {
+	return b.rate <= 0 || b.tokens >= b.rate
+}
+
+// trialBuckets are the token buckets of a trial
+type trialBuckets struct {
+	lastRefill time.Time
+	samples    tokenBucket
+	bytes      tokenBucket
+}
+
+// TrialRateLimiter limits the rates at which samples are added to each trial, a trial exceeding its limit doesn't
+// affect the others.
+//
+// Each trial can add up to one second worth of samples and bytes at once. Trials that are back to their full allowance
+// are forgotten, the memory usage doesn't grow with the number of trials.
+type TrialRateLimiter struct {
+	mutex          sync.Mutex
+	buckets        map[string]*trialBuckets
+	sweepThreshold int
+	now            func() time.Time
+}
+
+const minTrialRateLimiterSweepThreshold = 64
+
+func CreateTrialRateLimiter() *TrialRateLimiter {
+	return &TrialRateLimiter{
+		buckets:        make(map[string]*trialBuckets),
+		sweepThreshold: minTrialRateLimiterSweepThreshold,
+		now:            time.Now,
+	}
+}
+
+// Allow returns true, and accounts for it, if a sample of the given size can be added to the given trial without
+// exceeding the given limit, which can differ from one call to the other.
+func (l *TrialRateLimiter) Allow(trialID string, limit RateLimit, sampleSize int) bool {
+	if limit.IsUnlimited() {
+		return true
+	}
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	buckets, exists := l.buckets[trialID]
+	if !exists {
+		l.sweep(now)
+		buckets = &trialBuckets{
+			lastRefill: now,
+			samples:    tokenBucket{tokens: float64(limit.SamplesPerSecond)},
+			bytes:      tokenBucket{tokens: float64(limit.BytesPerSecond)},
+		}
+		l.buckets[trialID] = buckets
+	}
+	elapsed := now.Sub(buckets.lastRefill)
+	buckets.lastRefill = now
+	buckets.samples.refill(elapsed, limit.SamplesPerSecond)
+	buckets.bytes.refill(elapsed, limit.BytesPerSecond)
+
+	if !buckets.samples.allows(1) || !buckets.bytes.allows(float64(sampleSize)) {
+		return false
+	}
+	buckets.samples.take(1)
+	buckets.bytes.take(float64(sampleSize))
+	return true
+}
+
+// sweep forgets the trials whose buckets are full once their number reaches the sweep threshold, the threshold
+// doubling the remaining number of trials for the cost of the sweeps to be amortized
+func (l *TrialRateLimiter) sweep(now time.Time) {
+	if len(l.buckets) < l.sweepThreshold {
+		return
+	}
+	for trialID, buckets := range l.buckets {
+		elapsed := now.Sub(buckets.lastRefill)
+		buckets.lastRefill = now
+		buckets.samples.refill(elapsed, int(buckets.samples.rate))
+		buckets.bytes.refill(elapsed, int(buckets.bytes.rate))
+		if buckets.samples.isFull() && buckets.bytes.isFull() {
+			delete(l.buckets, trialID)
+		}
+	}
+	l.sweepThreshold = 2 * len(l.buckets)
+	if l.sweepThreshold < minTrialRateLimiterSweepThreshold {
+		l.sweepThreshold = minTrialRateLimiterSweepThreshold
+	}
+}
+
+// trialsCount returns the number of trials currently tracked
+func (l *TrialRateLimiter) trialsCount() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.buckets)
+}
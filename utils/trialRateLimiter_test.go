@@ -0,0 +1,114 @@
+// Copyright 2021 AI Redefined Inc. <dev+cogment@ai-r.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createTestTrialRateLimiter creates a limiter whose clock only advances when the returned function is called
+func createTestTrialRateLimiter() (*TrialRateLimiter, func(time.Duration)) {
+	now := time.Unix(0, 0)
+	l := CreateTrialRateLimiter()
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestTrialRateLimiterSamples(t *testing.T) {
+	l, advance := createTestTrialRateLimiter()
+	limit := RateLimit{SamplesPerSecond: 10}
+
+	// Up to a second worth of samples at once
+	for sampleIdx := 0; sampleIdx < 10; sampleIdx++ {
+		assert.True(t, l.Allow("trial-1", limit, 100))
+	}
+	assert.False(t, l.Allow("trial-1", limit, 100))
+
+	// Other trials are unaffected
+	assert.True(t, l.Allow("trial-2", limit, 100))
+
+	// Then at the limited rate
+	advance(100 * time.Millisecond)
+	assert.True(t, l.Allow("trial-1", limit, 100))
+	assert.False(t, l.Allow("trial-1", limit, 100))
+
+	advance(time.Second)
+	for sampleIdx := 0; sampleIdx < 10; sampleIdx++ {
+		assert.True(t, l.Allow("trial-1", limit, 100))
+	}
+	assert.False(t, l.Allow("trial-1", limit, 100))
+}
+
+func TestTrialRateLimiterBytes(t *testing.T) {
+	l, advance := createTestTrialRateLimiter()
+	limit := RateLimit{BytesPerSecond: 1000}
+
+	assert.True(t, l.Allow("trial-1", limit, 600))
+	assert.False(t, l.Allow("trial-1", limit, 600))
+	assert.True(t, l.Allow("trial-1", limit, 400))
+	assert.False(t, l.Allow("trial-1", limit, 1))
+
+	// A sample larger than the limit is allowed once the allowance is full, it then takes longer to refill
+	advance(time.Second)
+	assert.True(t, l.Allow("trial-1", limit, 3000))
+	advance(time.Second)
+	assert.False(t, l.Allow("trial-1", limit, 1))
+	advance(2 * time.Second)
+	assert.True(t, l.Allow("trial-1", limit, 1))
+}
+
+func TestTrialRateLimiterSamplesAndBytes(t *testing.T) {
+	l, _ := createTestTrialRateLimiter()
+	limit := RateLimit{SamplesPerSecond: 10, BytesPerSecond: 1000}
+
+	// The bytes limit is reached first
+	for sampleIdx := 0; sampleIdx < 5; sampleIdx++ {
+		assert.True(t, l.Allow("trial-1", limit, 200))
+	}
+	assert.False(t, l.Allow("trial-1", limit, 200))
+
+	// The samples limit is reached first, a denied sample doesn't consume anything
+	for sampleIdx := 0; sampleIdx < 10; sampleIdx++ {
+		assert.True(t, l.Allow("trial-2", limit, 10))
+	}
+	assert.False(t, l.Allow("trial-2", limit, 10))
+}
+
+func TestTrialRateLimiterUnlimited(t *testing.T) {
+	l, _ := createTestTrialRateLimiter()
+	for sampleIdx := 0; sampleIdx < 1000; sampleIdx++ {
+		assert.True(t, l.Allow("trial-1", RateLimit{}, 1000))
+	}
+	assert.Equal(t, 0, l.trialsCount())
+	assert.Equal(t, "unlimited", RateLimit{}.String())
+	assert.Equal(t, "10 samples/s and 1000 bytes/s", RateLimit{SamplesPerSecond: 10, BytesPerSecond: 1000}.String())
+}
+
+func TestTrialRateLimiterForgetsIdleTrials(t *testing.T) {
+	l, advance := createTestTrialRateLimiter()
+	limit := RateLimit{SamplesPerSecond: 10}
+
+	for trialIdx := 0; trialIdx < 1000; trialIdx++ {
+		assert.True(t, l.Allow(fmt.Sprintf("trial-%d", trialIdx), limit, 100))
+		if trialIdx%100 == 0 {
+			advance(time.Second)
+		}
+	}
+	assert.Less(t, l.trialsCount(), 2*minTrialRateLimiterSweepThreshold)
+}